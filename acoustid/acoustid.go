@@ -0,0 +1,183 @@
+package acoustid
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/throttle"
+)
+
+// Client of the AcoustID web service, which identifies recordings by their
+// Chromaprint fingerprint.
+// https://acoustid.org/webservice
+
+const (
+	BASE_URL            = "https://api.acoustid.org"
+	REQUESTS_PER_SECOND = 3
+	// Additional data returned for each result
+	LOOKUP_META = "recordings releasegroups"
+)
+
+var (
+	ErrMissingAPIKey = errors.New("missing AcoustID API key")
+	ErrServiceError  = errors.New("AcoustID web service error")
+)
+
+type Artist struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+}
+
+type ReleaseGroup struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Type    string   `json:"type"`
+	Artists []Artist `json:"artists"`
+}
+
+// Recording is a MusicBrainz recording linked to an AcoustID.
+type Recording struct {
+	ID            string         `json:"id"` // MusicBrainz recording ID
+	Title         string         `json:"title"`
+	Duration      float64        `json:"duration"` // in seconds
+	Artists       []Artist       `json:"artists"`
+	ReleaseGroups []ReleaseGroup `json:"releasegroups"`
+}
+
+// Result is an AcoustID matching the looked up fingerprint.
+type Result struct {
+	ID         string      `json:"id"`
+	Score      float64     `json:"score"` // 0 to 1
+	Recordings []Recording `json:"recordings"`
+}
+
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Response is the body of all web service responses.
+type Response struct {
+	Status  string         `json:"status"` // "ok" or "error"
+	Results []Result       `json:"results"`
+	Error   *ResponseError `json:"error,omitempty"`
+}
+
+type Client struct {
+	BaseURL    string
+	APIKey     string // application API key
+	HTTPClient *http.Client
+	// Database caching responses, nil disables the cache
+	Cache sqlx.Ext
+
+	throttle *throttle.Throttle
+}
+
+func NewClient(apiKey string, cache sqlx.Ext) *Client {
+	return &Client{
+		BaseURL:    BASE_URL,
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Cache:      cache,
+		throttle:   throttle.New(REQUESTS_PER_SECOND),
+	}
+}
+
+// Lookup returns the AcoustIDs matching a compressed fingerprint, as
+// calculated from the first seconds of audio, and the duration of the whole
+// audio in seconds. Responses are cached, the best result comes first.
+func (c *Client) Lookup(ctx context.Context, fingerprint string, duration float64) ([]Result, error) {
+	seconds := int(math.Round(duration))
+	if c.Cache != nil {
+		cached, err := data.GetAcoustIDResponse(c.Cache, fingerprint, seconds)
+		if err == nil {
+			return parseResponse([]byte(cached.Response))
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+	if c.APIKey == "" {
+		return nil, ErrMissingAPIKey
+	}
+
+	body, err := c.post(ctx, "/v2/lookup", url.Values{
+		"client":      {c.APIKey},
+		"format":      {"json"},
+		"meta":        {LOOKUP_META},
+		"duration":    {strconv.Itoa(seconds)},
+		"fingerprint": {fingerprint},
+	})
+	if err != nil {
+		return nil, err
+	}
+	results, err := parseResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Cache != nil {
+		err = data.SaveAcoustIDResponse(c.Cache, schema.AcoustIDResponse{
+			Fingerprint: fingerprint,
+			Duration:    seconds,
+			Response:    string(body),
+			Created:     time.Now(),
+		})
+	}
+	return results, err
+}
+
+func (c *Client) post(ctx context.Context, path string, form url.Values) ([]byte, error) {
+	if err := c.throttle.Wait(ctx); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.BaseURL, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// Errors come with a JSON body explaining them
+	if resp.StatusCode != http.StatusOK && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, fmt.Errorf("%w: %s", ErrServiceError, resp.Status)
+	}
+	return body, nil
+}
+
+func parseResponse(body []byte) ([]Result, error) {
+	var r Response
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrServiceError, err)
+	}
+	if r.Status != "ok" {
+		if r.Error != nil {
+			return nil, fmt.Errorf("%w: %s (code %d)", ErrServiceError, r.Error.Message, r.Error.Code)
+		}
+		return nil, fmt.Errorf("%w: status \"%s\"", ErrServiceError, r.Status)
+	}
+	sort.SliceStable(r.Results, func(i, j int) bool { return r.Results[i].Score > r.Results[j].Score })
+	return r.Results, nil
+}
@@ -0,0 +1,141 @@
+package acoustid_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/makl11/musiman/acoustid"
+	"github.com/makl11/musiman/acoustid/acoustidtest"
+	"github.com/makl11/musiman/data"
+)
+
+func setupTestDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Connect("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite database: %v", err)
+	}
+	db.SetMaxOpenConns(1) // every connection would get its own in-memory database
+	if err := data.Migrate(db); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	return db
+}
+
+var recording = acoustid.Recording{
+	ID:       "b9ad642e-b012-41c7-b72a-42cf4911f9ff",
+	Title:    "Song",
+	Duration: 187,
+	Artists:  []acoustid.Artist{{ID: "2fd06f00-d1ab-4c34-a23c-d0aa7b1fe5e9", Name: "Band"}},
+	ReleaseGroups: []acoustid.ReleaseGroup{
+		{ID: "ddaa2d4d-314e-3e7c-b1d0-f6d207f5aa2f", Title: "Album", Type: "Album"},
+	},
+}
+
+func TestLookup(t *testing.T) {
+	server := acoustidtest.NewServer()
+	defer server.Close()
+	server.Add("AQAAfingerprint", 187,
+		acoustid.Result{ID: "weak", Score: 0.5},
+		acoustid.Result{ID: "strong", Score: 0.98, Recordings: []acoustid.Recording{recording}},
+	)
+	client := acoustid.NewClient(acoustidtest.API_KEY, nil)
+	client.BaseURL = server.URL
+
+	results, err := client.Lookup(context.Background(), "AQAAfingerprint", 186.6)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != "strong" {
+		t.Fatalf("expected the best of 2 results first, but got %v", results)
+	}
+	if len(results[0].Recordings) != 1 || results[0].Recordings[0].ReleaseGroups[0].Title != "Album" {
+		t.Errorf("expected the recording with its release group, but got %v", results[0].Recordings)
+	}
+
+	results, err = client.Lookup(context.Background(), "AQAAunknown", 187)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results, but got %v", results)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := acoustidtest.NewServer()
+	defer server.Close()
+	server.Add("AQAAfingerprint", 187, acoustid.Result{ID: "strong", Score: 0.98})
+	client := acoustid.NewClient(acoustidtest.API_KEY, db)
+	client.BaseURL = server.URL
+
+	for i := 0; i < 2; i++ {
+		results, err := client.Lookup(context.Background(), "AQAAfingerprint", 187)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != 1 || results[0].ID != "strong" {
+			t.Errorf("expected the registered result, but got %v", results)
+		}
+	}
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("expected 1 request, but got %d", n)
+	}
+
+	// Cached responses do not need an API key
+	offline := acoustid.NewClient("", db)
+	offline.BaseURL = "http://127.0.0.1:0"
+	if _, err := offline.Lookup(context.Background(), "AQAAfingerprint", 187); err != nil {
+		t.Errorf("expected the cached response, but got %v", err)
+	}
+}
+
+func TestLookupRateLimit(t *testing.T) {
+	server := acoustidtest.NewServer()
+	defer server.Close()
+	client := acoustid.NewClient(acoustidtest.API_KEY, nil)
+	client.BaseURL = server.URL
+
+	for _, fp := range []string{"AQAAa", "AQAAb", "AQAAc"} {
+		if _, err := client.Lookup(context.Background(), fp, 100); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	requests := server.Requests()
+	minimum := time.Second / acoustid.REQUESTS_PER_SECOND
+	for i := 1; i < len(requests); i++ {
+		// Allow for the resolution of the clock
+		if gap := requests[i].Sub(requests[i-1]); gap < minimum-5*time.Millisecond {
+			t.Errorf("expected at least %v between requests, but got %v", minimum, gap)
+		}
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	server := acoustidtest.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		apiKey      string
+		expectedErr error
+	}{
+		{"missing API key", "", acoustid.ErrMissingAPIKey},
+		{"invalid API key", "wrong", acoustid.ErrServiceError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := acoustid.NewClient(tt.apiKey, nil)
+			client.BaseURL = server.URL
+			if _, err := client.Lookup(context.Background(), "AQAAfingerprint", 187); !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected error %v, but got %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
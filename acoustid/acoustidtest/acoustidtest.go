@@ -0,0 +1,105 @@
+// Package acoustidtest provides an in-process fake of the AcoustID web
+// service, so lookups can be tested without network access.
+package acoustidtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/makl11/musiman/acoustid"
+)
+
+const API_KEY = "test-api-key"
+
+// Error codes of the real web service
+const (
+	ERR_MISSING_PARAMETER = 2
+	ERR_INVALID_API_KEY   = 4
+)
+
+type lookupKey struct {
+	fingerprint string
+	duration    int
+}
+
+// Server answers lookups of registered fingerprints and records the times
+// of all requests.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	results  map[lookupKey][]acoustid.Result
+	requests []time.Time
+}
+
+// NewServer starts a Server accepting API_KEY. It has to be closed after use.
+func NewServer() *Server {
+	s := &Server{results: map[lookupKey][]acoustid.Result{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/lookup", s.lookup)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Add registers the results of looking up fingerprint with the duration in
+// whole seconds. Unknown fingerprints have no results.
+func (s *Server) Add(fingerprint string, duration int, results ...acoustid.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[lookupKey{fingerprint, duration}] = results
+}
+
+// Requests returns the times of all requests received so far.
+func (s *Server) Requests() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.requests...)
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, time.Now())
+	s.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, ERR_MISSING_PARAMETER, err.Error())
+		return
+	}
+	if r.Form.Get("client") != API_KEY {
+		writeError(w, ERR_INVALID_API_KEY, "invalid API key")
+		return
+	}
+	for _, name := range []string{"fingerprint", "duration"} {
+		if r.Form.Get(name) == "" {
+			writeError(w, ERR_MISSING_PARAMETER, "missing required parameter \""+name+"\"")
+			return
+		}
+	}
+	duration, err := strconv.Atoi(r.Form.Get("duration"))
+	if err != nil {
+		writeError(w, ERR_MISSING_PARAMETER, "invalid duration")
+		return
+	}
+
+	s.mu.Lock()
+	results := s.results[lookupKey{r.Form.Get("fingerprint"), duration}]
+	s.mu.Unlock()
+	if results == nil {
+		results = []acoustid.Result{}
+	}
+	writeJSON(w, http.StatusOK, acoustid.Response{Status: "ok", Results: results})
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, http.StatusBadRequest, acoustid.Response{Status: "error", Error: &acoustid.ResponseError{Code: code, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
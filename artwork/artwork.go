@@ -0,0 +1,121 @@
+package artwork
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// Base names of images in album directories which show the album cover, in
+// order of preference. Compared case insensitively.
+var FOLDER_IMAGE_NAMES = []string{"cover", "folder", "front", "album", "albumart"}
+
+var FOLDER_IMAGE_EXTENSIONS = []string{".jpg", ".jpeg", ".png"}
+
+// Quality of JPEG images written by EncodeJPEG
+const JPEG_QUALITY = 90
+
+// FolderImageRank returns the preference of the file name as album cover,
+// lower is better, or -1 if it is no folder image.
+func FolderImageRank(name string) int {
+	ext := strings.ToLower(filepath.Ext(name))
+	if !slices.Contains(FOLDER_IMAGE_EXTENSIONS, ext) {
+		return -1
+	}
+	return slices.Index(FOLDER_IMAGE_NAMES, strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name))))
+}
+
+// IsFolderImage reports whether the file name is one of the usual names of
+// album cover images, i.e. "cover.jpg" or "Folder.png".
+func IsFolderImage(name string) bool {
+	return FolderImageRank(name) >= 0
+}
+
+// Info describes an encoded image.
+type Info struct {
+	MIME   string // i.e. "image/jpeg"
+	Width  int
+	Height int
+}
+
+// Inspect returns the format and size of the encoded image in data without
+// decoding all of it. JPEG, PNG and GIF images are supported.
+func Inspect(data []byte) (Info, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+	return Info{MIME: "image/" + format, Width: config.Width, Height: config.Height}, nil
+}
+
+// Resize scales img down to fit into a square of size pixels, keeping its
+// aspect ratio. Every pixel of the result is the average of the pixels it
+// covers in img. Images which already fit are returned unchanged.
+func Resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	newW, newH := size, size
+	if w > h {
+		newH = max(1, h*size/w)
+	} else {
+		newW = max(1, w*size/h)
+	}
+
+	result := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	for y := range newH {
+		y0, y1 := bounds.Min.Y+y*h/newH, bounds.Min.Y+(y+1)*h/newH
+		for x := range newW {
+			x0, x1 := bounds.Min.X+x*w/newW, bounds.Min.X+(x+1)*w/newW
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			result.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return result
+}
+
+// EncodeJPEG returns the image in data as JPEG, scaled down to fit into a
+// square of size pixels if it is larger. A size of 0 keeps the size. JPEG
+// images which need no scaling are returned unchanged.
+func EncodeJPEG(data []byte, size int) ([]byte, error) {
+	info, err := Inspect(data)
+	if err != nil {
+		return nil, err
+	}
+	fits := size <= 0 || info.Width <= size && info.Height <= size
+	if info.MIME == "image/jpeg" && fits {
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+	if !fits {
+		img = Resize(img, size)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEG_QUALITY}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
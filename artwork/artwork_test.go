@@ -0,0 +1,104 @@
+package artwork_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/makl11/musiman/artwork"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestIsFolderImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"cover.jpg", true},
+		{"Folder.PNG", true},
+		{"front.jpeg", true},
+		{"albumart.jpg", true},
+		{"cover.gif", false},
+		{"back.jpg", false},
+		{"cover", false},
+	}
+	for _, test := range tests {
+		if result := artwork.IsFolderImage(test.name); result != test.expected {
+			t.Errorf("%s: expected %v, but got %v", test.name, test.expected, result)
+		}
+	}
+	if artwork.FolderImageRank("cover.png") >= artwork.FolderImageRank("folder.jpg") {
+		t.Errorf("expected cover images to be preferred over folder images")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	info, err := artwork.Inspect(encodePNG(t, image.NewGray(image.Rect(0, 0, 30, 20))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.MIME != "image/png" || info.Width != 30 || info.Height != 20 {
+		t.Errorf("expected image/png of 30x20, but got %s of %dx%d", info.MIME, info.Width, info.Height)
+	}
+
+	if _, err := artwork.Inspect([]byte("no image")); !errors.Is(err, artwork.ErrUnsupportedImage) {
+		t.Errorf("expected error %v, but got %v", artwork.ErrUnsupportedImage, err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	// Left half black, right half white
+	img := image.NewGray(image.Rect(0, 0, 40, 20))
+	for y := range 20 {
+		for x := 20; x < 40; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	result := artwork.Resize(img, 10)
+	if b := result.Bounds(); b.Dx() != 10 || b.Dy() != 5 {
+		t.Fatalf("expected size 10x5, but got %dx%d", b.Dx(), b.Dy())
+	}
+	if r, _, _, _ := result.At(0, 0).RGBA(); r != 0 {
+		t.Errorf("expected black on the left, but got %d", r)
+	}
+	if r, _, _, _ := result.At(9, 4).RGBA(); r != 0xFFFF {
+		t.Errorf("expected white on the right, but got %d", r)
+	}
+
+	if small := artwork.Resize(img, 100); small != image.Image(img) {
+		t.Errorf("expected an image which fits to be returned unchanged")
+	}
+}
+
+func TestEncodeJPEG(t *testing.T) {
+	data, err := artwork.EncodeJPEG(encodePNG(t, image.NewGray(image.Rect(0, 0, 600, 300))), 200)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := artwork.Inspect(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.MIME != "image/jpeg" || info.Width != 200 || info.Height != 100 {
+		t.Errorf("expected image/jpeg of 200x100, but got %s of %dx%d", info.MIME, info.Width, info.Height)
+	}
+
+	unchanged, err := artwork.EncodeJPEG(data, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(unchanged, data) {
+		t.Errorf("expected a JPEG image which fits to be returned unchanged")
+	}
+}
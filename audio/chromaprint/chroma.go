@@ -0,0 +1,142 @@
+package chromaprint
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+const (
+	frameSize    = 4096
+	frameOverlap = frameSize - frameSize/3
+	frameStep    = frameSize - frameOverlap
+
+	minFreq  = 28
+	maxFreq  = 3520
+	numBands = 12
+)
+
+// Coefficients of the filter smoothing the chroma features over time
+var chromaFilterCoefficients = []float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+// spectrum computes the power spectra of Hamming windowed frames.
+type spectrum struct {
+	window  []float64
+	twiddle []complex128
+	reverse []int
+	buf     []complex128
+	power   []float64 // frameSize/2 + 1 bins
+}
+
+func newSpectrum() *spectrum {
+	s := &spectrum{
+		window:  make([]float64, frameSize),
+		twiddle: make([]complex128, frameSize/2),
+		reverse: make([]int, frameSize),
+		buf:     make([]complex128, frameSize),
+		power:   make([]float64, frameSize/2+1),
+	}
+	for i := range s.window {
+		s.window[i] = 1.0 / math.MaxInt16 * (0.54 - 0.46*math.Cos(float64(i)*2.0*math.Pi/float64(frameSize-1)))
+	}
+	for k := range s.twiddle {
+		s.twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/frameSize))
+	}
+	shift := 64 - bits.Len(frameSize-1)
+	for i := range s.reverse {
+		s.reverse[i] = int(bits.Reverse64(uint64(i)) >> shift)
+	}
+	return s
+}
+
+// compute calculates the power spectrum of frame.
+func (s *spectrum) compute(frame []int16) []float64 {
+	for i, v := range frame {
+		s.buf[s.reverse[i]] = complex(float64(v)*s.window[i], 0)
+	}
+	for size := 2; size <= frameSize; size <<= 1 {
+		step := frameSize / size
+		for start := 0; start < frameSize; start += size {
+			for k := 0; k < size/2; k++ {
+				a, b := s.buf[start+k], s.buf[start+k+size/2]*s.twiddle[k*step]
+				s.buf[start+k], s.buf[start+k+size/2] = a+b, a-b
+			}
+		}
+	}
+	for i := range s.power {
+		re, im := real(s.buf[i]), imag(s.buf[i])
+		s.power[i] = re*re + im*im
+	}
+	return s.power
+}
+
+// chroma sums the spectral energy of each semitone over all octaves.
+type chroma struct {
+	minIndex, maxIndex int
+	notes              []int // band by spectrum bin
+}
+
+func newChroma() *chroma {
+	freqToIndex := func(freq float64) int {
+		return int(math.Round(frameSize * freq / SAMPLE_RATE))
+	}
+	c := &chroma{
+		minIndex: max(1, freqToIndex(minFreq)),
+		maxIndex: min(frameSize/2, freqToIndex(maxFreq)),
+		notes:    make([]int, frameSize/2),
+	}
+	for i := c.minIndex; i < c.maxIndex; i++ {
+		freq := float64(i) * SAMPLE_RATE / frameSize
+		octave := math.Log(freq/(440.0/16.0)) / math.Log(2.0)
+		c.notes[i] = int(numBands * (octave - math.Floor(octave)))
+	}
+	return c
+}
+
+func (c *chroma) features(power []float64) []float64 {
+	features := make([]float64, numBands)
+	for i := c.minIndex; i < c.maxIndex; i++ {
+		features[c.notes[i]] += power[i]
+	}
+	return features
+}
+
+// chromaFilter smooths the features over the last frames.
+type chromaFilter struct {
+	buffer [][]float64 // last frames, oldest first
+}
+
+// filter adds a frame and returns the filtered features once enough frames
+// are buffered, nil before.
+func (f *chromaFilter) filter(features []float64) []float64 {
+	f.buffer = append(f.buffer, features)
+	if len(f.buffer) > len(chromaFilterCoefficients) {
+		f.buffer = f.buffer[1:]
+	}
+	if len(f.buffer) < len(chromaFilterCoefficients) {
+		return nil
+	}
+	result := make([]float64, numBands)
+	for i := range result {
+		for j, c := range chromaFilterCoefficients {
+			result[i] += f.buffer[j][i] * c
+		}
+	}
+	return result
+}
+
+// normalize scales features to unit length, silent frames become zero.
+func normalize(features []float64) {
+	squares := 0.0
+	for _, v := range features {
+		squares += v * v
+	}
+	norm := math.Sqrt(squares)
+	for i := range features {
+		if norm < 0.01 {
+			features[i] = 0
+		} else {
+			features[i] /= norm
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package chromaprint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// Audio fingerprints compatible with Chromaprint's default algorithm, as used
+// by the AcoustID database.
+// https://oxygene.sk/2011/01/how-does-chromaprint-work/
+
+const (
+	ALGORITHM    = 1     // id of the default algorithm (TEST2) in compressed fingerprints
+	SAMPLE_RATE  = 11025 // of the analysed mono audio
+	MAX_DURATION = 120   // seconds of audio fingerprinted by Calculate, as with fpcalc
+)
+
+var (
+	ErrInvalidFormat      = errors.New("invalid audio format")
+	ErrInvalidFingerprint = errors.New("invalid fingerprint")
+)
+
+// Chromaprint's audio processor downmixes and resamples in chunks of this many
+// samples, which affects the resampler at the chunk boundaries
+const maxBufferSize = 1024 * 32
+
+// Fingerprinter calculates the fingerprint of a stream of 16 bit samples.
+type Fingerprinter struct {
+	channels  int
+	resampler *resampler // nil for audio at SAMPLE_RATE
+	buffer    []int16    // downmixed samples waiting to be resampled
+	resampled []int16
+	frame     []int16 // samples of the next frame
+	spectrum  *spectrum
+	chroma    *chroma
+	filter    chromaFilter
+	image     integralImage
+	print     []uint32
+}
+
+// New returns a Fingerprinter for interleaved audio with the given sample rate
+// and number of channels.
+func New(sampleRate int, channels int) (*Fingerprinter, error) {
+	if sampleRate <= 1000 || channels <= 0 {
+		return nil, fmt.Errorf("%w: %d Hz with %d channels", ErrInvalidFormat, sampleRate, channels)
+	}
+	f := &Fingerprinter{
+		channels:  channels,
+		buffer:    make([]int16, 0, maxBufferSize),
+		resampled: make([]int16, maxBufferSize),
+		spectrum:  newSpectrum(),
+		chroma:    newChroma(),
+	}
+	if sampleRate != SAMPLE_RATE {
+		f.resampler = newResampler(SAMPLE_RATE, sampleRate)
+	}
+	return f, nil
+}
+
+// Feed adds interleaved samples, whose number has to be a multiple of the
+// number of channels.
+func (f *Fingerprinter) Feed(samples []int16) {
+	for i := 0; i+f.channels <= len(samples); i += f.channels {
+		// Integer division like Chromaprint, which rounds towards zero
+		sum := 0
+		for _, s := range samples[i : i+f.channels] {
+			sum += int(s)
+		}
+		f.buffer = append(f.buffer, int16(sum/f.channels))
+		if len(f.buffer) == maxBufferSize {
+			f.flush()
+		}
+	}
+}
+
+// Finish processes the buffered samples and returns the fingerprint, one
+// subfingerprint per frame step after the first ones needed by the filters.
+func (f *Fingerprinter) Finish() []uint32 {
+	if len(f.buffer) > 0 {
+		f.flush()
+	}
+	return f.print
+}
+
+// flush resamples the buffered samples, keeping those still needed by the
+// resampler.
+func (f *Fingerprinter) flush() {
+	if f.resampler == nil {
+		f.consume(f.buffer)
+		f.buffer = f.buffer[:0]
+		return
+	}
+	n, consumed := f.resampler.resample(f.resampled, f.buffer)
+	f.consume(f.resampled[:n])
+	remaining := copy(f.buffer, f.buffer[min(consumed, len(f.buffer)):])
+	f.buffer = f.buffer[:remaining]
+}
+
+// consume splits mono samples at SAMPLE_RATE into overlapping frames.
+func (f *Fingerprinter) consume(samples []int16) {
+	for len(samples) > 0 {
+		n := min(len(samples), frameSize-len(f.frame))
+		f.frame = append(f.frame, samples[:n]...)
+		samples = samples[n:]
+		if len(f.frame) == frameSize {
+			f.processFrame()
+			f.frame = append(f.frame[:0], f.frame[frameStep:]...)
+		}
+	}
+}
+
+func (f *Fingerprinter) processFrame() {
+	features := f.filter.filter(f.chroma.features(f.spectrum.compute(f.frame)))
+	if features == nil {
+		return
+	}
+	normalize(features)
+	f.image.addRow(features)
+	if rows := len(f.image.rows); rows >= maxFilterWidth {
+		f.print = append(f.print, subfingerprint(&f.image, rows-maxFilterWidth))
+	}
+}
+
+// Calculate fingerprints the first MAX_DURATION seconds of the audio of d,
+// like fpcalc does by default. It also returns the duration of the whole
+// stream in seconds.
+func Calculate(d decode.Decoder) ([]uint32, float64, error) {
+	f, err := New(d.SampleRate(), d.Channels())
+	if err != nil {
+		return nil, 0, err
+	}
+	limit := int64(MAX_DURATION * d.SampleRate() * d.Channels())
+	var total int64
+	buf := make([]int16, 4096*d.Channels())
+	for {
+		n, err := decode.ReadInt16(d, buf)
+		if n > 0 && total < limit {
+			f.Feed(buf[:min(int64(n), limit-total)])
+		}
+		total += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	duration := float64(total/int64(d.Channels())) / float64(d.SampleRate())
+	return f.Finish(), duration, nil
+}
@@ -0,0 +1,247 @@
+package chromaprint_test
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/audio/chromaprint"
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// The reference fingerprints of the test audio as calculated by the fpcalc
+// tool of the Chromaprint project: 8 seconds of notes with harmonics and
+// noise, once at the native sample rate and once as 44.1 kHz stereo, which
+// is resampled.
+//go:generate sh -c "fpcalc -raw -plain testdata/reference.wav > testdata/reference.fpcalc"
+//go:generate sh -c "fpcalc -raw -plain testdata/reference44k.wav > testdata/reference44k.fpcalc"
+
+// fixedDecoder returns samples generated by sample for each frame and channel.
+type fixedDecoder struct {
+	rate, channels int
+	frames, pos    int
+	sample         func(frame int, rate int) float32
+}
+
+func (d *fixedDecoder) SampleRate() int { return d.rate }
+func (d *fixedDecoder) Channels() int   { return d.channels }
+
+func (d *fixedDecoder) Read(samples []float32) (int, error) {
+	n := 0
+	for ; n+d.channels <= len(samples) && d.pos < d.frames; d.pos++ {
+		v := d.sample(d.pos, d.rate)
+		for c := 0; c < d.channels; c++ {
+			samples[n] = v
+			n++
+		}
+	}
+	if d.pos == d.frames {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func silent(int, int) float32 { return 0 }
+
+// melody plays a different note every half second.
+func melody(frame int, rate int) float32 {
+	notes := []float64{262, 330, 392, 523, 440, 349, 294, 247}
+	t := float64(frame) / float64(rate)
+	freq := notes[int(t*2)%len(notes)]
+	return float32(0.5 * math.Sin(2*math.Pi*freq*t))
+}
+
+func TestCalculateSilence(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     int
+		channels int
+	}{
+		{"native rate", chromaprint.SAMPLE_RATE, 1},
+		{"resampled", 44100, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fixedDecoder{rate: tt.rate, channels: tt.channels, frames: 10 * tt.rate, sample: silent}
+			fp, duration, err := chromaprint.Calculate(d)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if duration != 10 {
+				t.Errorf("expected a duration of 10, but got %v", duration)
+			}
+			if len(fp) == 0 {
+				t.Fatalf("expected a fingerprint, but got none")
+			}
+			for i, sub := range fp {
+				if sub != chromaprint.SILENCE {
+					t.Fatalf("expected subfingerprint %d to be %d, but got %d", i, chromaprint.SILENCE, sub)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateLength(t *testing.T) {
+	// Frames of 4096 samples every 1365, the first 4 feed the chroma filter
+	// and 15 more the classifiers
+	samples := 10 * chromaprint.SAMPLE_RATE
+	expected := (samples-4096)/1365 + 1 - 19
+	d := &fixedDecoder{rate: chromaprint.SAMPLE_RATE, channels: 1, frames: samples, sample: silent}
+	fp, _, err := chromaprint.Calculate(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fp) != expected {
+		t.Errorf("expected %d subfingerprints, but got %d", expected, len(fp))
+	}
+}
+
+func TestCalculateMaxDuration(t *testing.T) {
+	rate := chromaprint.SAMPLE_RATE
+	long := &fixedDecoder{rate: rate, channels: 1, frames: (chromaprint.MAX_DURATION + 10) * rate, sample: melody}
+	limited := &fixedDecoder{rate: rate, channels: 1, frames: chromaprint.MAX_DURATION * rate, sample: melody}
+	fp, duration, err := chromaprint.Calculate(long)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if duration != chromaprint.MAX_DURATION+10 {
+		t.Errorf("expected a duration of %d, but got %v", chromaprint.MAX_DURATION+10, duration)
+	}
+	expected, _, err := chromaprint.Calculate(limited)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fp) != len(expected) {
+		t.Fatalf("expected %d subfingerprints, but got %d", len(expected), len(fp))
+	}
+}
+
+func TestCalculateResampledSimilar(t *testing.T) {
+	native := &fixedDecoder{rate: chromaprint.SAMPLE_RATE, channels: 1, frames: 20 * chromaprint.SAMPLE_RATE, sample: melody}
+	resampled := &fixedDecoder{rate: 44100, channels: 2, frames: 20 * 44100, sample: melody}
+	a, _, err := chromaprint.Calculate(native)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _, err := chromaprint.Calculate(resampled)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n := min(len(a), len(b))
+	if n == 0 || max(len(a), len(b))-n > 2 {
+		t.Fatalf("expected fingerprints of about the same length, but got %d and %d", len(a), len(b))
+	}
+	errorBits := 0
+	for i := 0; i < n; i++ {
+		errorBits += bits.OnesCount32(a[i] ^ b[i])
+	}
+	if rate := float64(errorBits) / float64(32*n); rate > 0.1 {
+		t.Errorf("expected a bit error rate below 0.1, but got %.3f", rate)
+	}
+}
+
+func TestCalculateMatchesFpcalc(t *testing.T) {
+	for _, name := range []string{"reference", "reference44k"} {
+		t.Run(name, func(t *testing.T) {
+			reference, err := os.ReadFile("testdata/" + name + ".fpcalc")
+			if err != nil {
+				t.Fatalf("failed to read reference fingerprint, create it with go generate where fpcalc is installed: %v", err)
+			}
+			var expected []uint32
+			for _, field := range strings.Split(strings.TrimSpace(string(reference)), ",") {
+				v, err := strconv.ParseUint(field, 10, 32)
+				if err != nil {
+					t.Fatalf("failed to parse reference fingerprint: %v", err)
+				}
+				expected = append(expected, uint32(v))
+			}
+
+			d, err := decode.Open("testdata/" + name + ".wav")
+			if err != nil {
+				t.Fatalf("failed to open reference audio: %v", err)
+			}
+			defer d.Close()
+			fp, _, err := chromaprint.Calculate(d)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(fp) != len(expected) {
+				t.Fatalf("expected %d subfingerprints like fpcalc, but got %d", len(expected), len(fp))
+			}
+			for i := range expected {
+				if fp[i] != expected[i] {
+					t.Fatalf("expected subfingerprint %d to be %d like fpcalc, but got %d (%d bits differ)", i, expected[i], fp[i], bits.OnesCount32(fp[i]^expected[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	if _, err := chromaprint.New(44100, 0); !errors.Is(err, chromaprint.ErrInvalidFormat) {
+		t.Errorf("expected ErrInvalidFormat, but got %v", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name     string
+		fp       []uint32
+		expected string
+	}{
+		{"empty", []uint32{}, "AQAAAA"},
+		// Header 01 000001, a single terminator
+		{"zero", []uint32{0}, "AQAAAQA"},
+		// Bits 1 and 2 set: values 1, 1, 0 packed into 001 001 000
+		{"low bits", []uint32{3}, "AQAAAQkA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if encoded := chromaprint.Encode(tt.fp); encoded != tt.expected {
+				t.Errorf("expected %q, but got %q", tt.expected, encoded)
+			}
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	// High bits need exceptions, equal neighbours no changed bits
+	fp := []uint32{chromaprint.SILENCE, chromaprint.SILENCE, 0x80000001, 0xffffffff, 0, 0x40000000, 12345678, 12345678}
+	decoded, err := chromaprint.Decode(chromaprint.Encode(fp))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(decoded) != len(fp) {
+		t.Fatalf("expected %d subfingerprints, but got %d", len(fp), len(decoded))
+	}
+	for i := range fp {
+		if decoded[i] != fp[i] {
+			t.Errorf("expected subfingerprint %d to be %d, but got %d", i, fp[i], decoded[i])
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"not base64", "!!!"},
+		{"missing header", "AQA"},
+		{"unknown algorithm", "BwAAAQA"},
+		{"truncated", "AQAAAg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := chromaprint.Decode(tt.encoded); !errors.Is(err, chromaprint.ErrInvalidFingerprint) {
+				t.Errorf("expected ErrInvalidFingerprint, but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package chromaprint
+
+import "math"
+
+// classifier compares areas of the chroma image with a Haar-like filter and
+// quantizes the result to 2 bits.
+type classifier struct {
+	filterType int
+	y          int // first band
+	height     int // bands
+	width      int // frames
+	thresholds [3]float64
+}
+
+// Classifiers of the default algorithm (TEST2)
+var classifiers = [16]classifier{
+	{0, 4, 3, 15, [3]float64{1.98215, 2.35817, 2.63523}},
+	{4, 4, 6, 15, [3]float64{-1.03809, -0.651211, -0.282167}},
+	{1, 0, 4, 16, [3]float64{-0.298702, 0.119262, 0.558497}},
+	{3, 8, 2, 12, [3]float64{-0.105439, 0.0153946, 0.135898}},
+	{3, 4, 4, 8, [3]float64{-0.142891, 0.0258736, 0.200632}},
+	{4, 0, 3, 5, [3]float64{-0.826319, -0.590612, -0.368214}},
+	{1, 2, 2, 9, [3]float64{-0.557409, -0.233035, 0.0534525}},
+	{2, 7, 3, 4, [3]float64{-0.0646826, 0.00620476, 0.0784847}},
+	{2, 6, 2, 16, [3]float64{-0.192387, -0.029699, 0.215855}},
+	{2, 1, 3, 2, [3]float64{-0.0397818, -0.00568076, 0.0292026}},
+	{5, 10, 1, 15, [3]float64{-0.53823, -0.369934, -0.190235}},
+	{3, 6, 2, 10, [3]float64{-0.124877, 0.0296483, 0.139239}},
+	{2, 1, 1, 14, [3]float64{-0.101475, 0.0225617, 0.231971}},
+	{3, 5, 6, 4, [3]float64{-0.0799915, -0.00729616, 0.063262}},
+	{1, 9, 2, 12, [3]float64{-0.272556, 0.019424, 0.302559}},
+	{3, 4, 2, 14, [3]float64{-0.164292, -0.0321188, 0.0846339}},
+}
+
+// Widest classifier, the number of frames needed for a subfingerprint
+const maxFilterWidth = 16
+
+var grayCodes = [4]uint32{0, 1, 3, 2}
+
+// integralImage holds the sums of all features in the frames and bands
+// before each position.
+type integralImage struct {
+	rows [][numBands]float64
+}
+
+func (m *integralImage) addRow(features []float64) {
+	var row [numBands]float64
+	sum := 0.0
+	for i, v := range features {
+		sum += v
+		row[i] = sum
+	}
+	if len(m.rows) > 0 {
+		last := &m.rows[len(m.rows)-1]
+		for i := range row {
+			row[i] += last[i]
+		}
+	}
+	m.rows = append(m.rows, row)
+}
+
+// area returns the sum of the features in the frames [r1, r2) and the bands
+// [c1, c2).
+func (m *integralImage) area(r1 int, c1 int, r2 int, c2 int) float64 {
+	if r1 == r2 || c1 == c2 {
+		return 0
+	}
+	row2 := &m.rows[r2-1]
+	if r1 == 0 {
+		if c1 == 0 {
+			return row2[c2-1]
+		}
+		return row2[c2-1] - row2[c1-1]
+	}
+	row1 := &m.rows[r1-1]
+	if c1 == 0 {
+		return row2[c2-1] - row1[c2-1]
+	}
+	return row2[c2-1] - row1[c2-1] - row2[c1-1] + row1[c1-1]
+}
+
+func subtractLog(a float64, b float64) float64 {
+	return math.Log((1.0 + a) / (1.0 + b))
+}
+
+// apply applies the filter at frame x.
+func (c *classifier) apply(m *integralImage, x int) float64 {
+	y, w, h := c.y, c.width, c.height
+	switch c.filterType {
+	case 0:
+		return subtractLog(m.area(x, y, x+w, y+h), 0)
+	case 1: // upper against lower half of the bands
+		return subtractLog(m.area(x, y+h/2, x+w, y+h), m.area(x, y, x+w, y+h/2))
+	case 2: // later against earlier half of the frames
+		return subtractLog(m.area(x+w/2, y, x+w, y+h), m.area(x, y, x+w/2, y+h))
+	case 3: // quadrants
+		a := m.area(x, y+h/2, x+w/2, y+h) + m.area(x+w/2, y, x+w, y+h/2)
+		b := m.area(x, y, x+w/2, y+h/2) + m.area(x+w/2, y+h/2, x+w, y+h)
+		return subtractLog(a, b)
+	case 4: // middle against outer thirds of the bands
+		a := m.area(x, y+h/3, x+w, y+2*(h/3))
+		b := m.area(x, y, x+w, y+h/3) + m.area(x, y+2*(h/3), x+w, y+h)
+		return subtractLog(a, b)
+	default: // middle against outer thirds of the frames
+		a := m.area(x+w/3, y, x+2*(w/3), y+h)
+		b := m.area(x, y, x+w/3, y+h) + m.area(x+2*(w/3), y, x+w, y+h)
+		return subtractLog(a, b)
+	}
+}
+
+func (c *classifier) classify(m *integralImage, x int) int {
+	value := c.apply(m, x)
+	switch {
+	case value < c.thresholds[0]:
+		return 0
+	case value < c.thresholds[1]:
+		return 1
+	case value < c.thresholds[2]:
+		return 2
+	}
+	return 3
+}
+
+// subfingerprint combines the gray coded classifications of the frames
+// starting at x.
+func subfingerprint(m *integralImage, x int) uint32 {
+	var bits uint32
+	for i := range classifiers {
+		bits = bits<<2 | grayCodes[classifiers[i].classify(m, x)]
+	}
+	return bits
+}
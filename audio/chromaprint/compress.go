@@ -0,0 +1,157 @@
+package chromaprint
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// Compressed fingerprints store the positions of the bits changed from the
+// previous subfingerprint, as 3 bit values with larger ones continued in 5 bit
+// exceptions.
+
+const (
+	maxNormalValue = 7
+	normalBits     = 3
+	exceptionBits  = 5
+)
+
+// Encode compresses fp and encodes it in URL safe base64 without padding, as
+// printed by fpcalc and accepted by the AcoustID web service.
+func Encode(fp []uint32) string {
+	return base64.RawURLEncoding.EncodeToString(compress(fp, ALGORITHM))
+}
+
+// Decode reverses Encode.
+func Decode(s string) ([]uint32, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFingerprint, err)
+	}
+	return decompress(data)
+}
+
+func compress(fp []uint32, algorithm int) []byte {
+	var normal, exceptions []uint32
+	var last uint32
+	for _, sub := range fp {
+		x := sub ^ last
+		last = sub
+		bit, lastBit := uint32(1), uint32(0)
+		for ; x != 0; x >>= 1 {
+			if x&1 != 0 {
+				if value := bit - lastBit; value >= maxNormalValue {
+					normal = append(normal, maxNormalValue)
+					exceptions = append(exceptions, value-maxNormalValue)
+				} else {
+					normal = append(normal, value)
+				}
+				lastBit = bit
+			}
+			bit++
+		}
+		normal = append(normal, 0)
+	}
+
+	out := []byte{byte(algorithm), byte(len(fp) >> 16), byte(len(fp) >> 8), byte(len(fp))}
+	out = packBits(out, normal, normalBits)
+	return packBits(out, exceptions, exceptionBits)
+}
+
+// packBits appends values of the given size, least significant bit first.
+func packBits(out []byte, values []uint32, size int) []byte {
+	var buffer uint32
+	n := 0
+	for _, v := range values {
+		buffer |= v << n
+		for n += size; n >= 8; n -= 8 {
+			out = append(out, byte(buffer))
+			buffer >>= 8
+		}
+	}
+	if n > 0 {
+		out = append(out, byte(buffer))
+	}
+	return out
+}
+
+func decompress(data []byte) ([]uint32, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidFingerprint)
+	}
+	if data[0] != ALGORITHM {
+		return nil, fmt.Errorf("%w: unsupported algorithm %d", ErrInvalidFingerprint, data[0])
+	}
+	count := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	r := &bitReader{data: data[4:]}
+
+	// The normal values end with the terminator of the last subfingerprint
+	var normal []uint32
+	exceptionCount := 0
+	for terminators := 0; terminators < count; {
+		v, ok := r.read(normalBits)
+		if !ok {
+			return nil, fmt.Errorf("%w: truncated", ErrInvalidFingerprint)
+		}
+		switch v {
+		case 0:
+			terminators++
+		case maxNormalValue:
+			exceptionCount++
+		}
+		normal = append(normal, v)
+	}
+	r.align()
+	exceptions := make([]uint32, exceptionCount)
+	for i := range exceptions {
+		v, ok := r.read(exceptionBits)
+		if !ok {
+			return nil, fmt.Errorf("%w: truncated exceptions", ErrInvalidFingerprint)
+		}
+		exceptions[i] = v
+	}
+
+	fp := make([]uint32, 0, count)
+	var last, x uint32
+	bit := uint32(0)
+	for _, v := range normal {
+		if v == 0 {
+			last ^= x
+			fp = append(fp, last)
+			x, bit = 0, 0
+			continue
+		}
+		if v == maxNormalValue {
+			v += exceptions[0]
+			exceptions = exceptions[1:]
+		}
+		bit += v
+		if bit > 32 {
+			return nil, fmt.Errorf("%w: bit position out of range", ErrInvalidFingerprint)
+		}
+		x |= 1 << (bit - 1)
+	}
+	return fp, nil
+}
+
+// bitReader reads values of up to 8 bits, least significant bit first.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *bitReader) read(size int) (uint32, bool) {
+	if r.pos+size > len(r.data)*8 {
+		return 0, false
+	}
+	var v uint32
+	for i := 0; i < size; i++ {
+		p := r.pos + i
+		v |= uint32(r.data[p/8]>>(p%8)&1) << i
+	}
+	r.pos += size
+	return v, true
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
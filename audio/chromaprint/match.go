@@ -0,0 +1,140 @@
+package chromaprint
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Fingerprints of the same recording differ in a few bits per subfingerprint
+// after lossy encoding, fingerprints of unrelated audio in about half of them.
+
+const (
+	SILENCE = 627964279 // subfingerprint of silent frames
+
+	// Fewest aligned subfingerprints compared, about 10 seconds
+	minOverlap = 80
+	// Index keys are the subfingerprints with the bits of their last
+	// classifiers dropped, as those flip most easily
+	indexKeyShift = 12
+	// Aligned index hits needed before a candidate is compared
+	minHits = 2
+	// Best offsets of a candidate compared in full
+	maxCandidateOffsets = 3
+)
+
+// Similarity returns the share of equal bits of a and b, with the start of b
+// shifted by offset subfingerprints relative to a. Positions where both are
+// silent are not counted. Identical audio scores 1, unrelated audio about 0.5
+// and too short overlaps 0.
+func Similarity(a []uint32, b []uint32, offset int) float64 {
+	start := max(0, -offset)
+	end := min(len(a), len(b)-offset)
+	if end-start < minOverlap {
+		return 0
+	}
+	compared, errors := 0, 0
+	for i := start; i < end; i++ {
+		x, y := a[i], b[i+offset]
+		if x == SILENCE && y == SILENCE {
+			continue
+		}
+		compared++
+		errors += bits.OnesCount32(x ^ y)
+	}
+	if compared < minOverlap {
+		return 0
+	}
+	return 1 - float64(errors)/float64(32*compared)
+}
+
+// Match is a fingerprint of an Index similar to the searched one.
+type Match struct {
+	ID         int
+	Similarity float64
+	Offset     int // of the indexed fingerprint relative to the searched one
+}
+
+type posting struct {
+	id  int32
+	pos int32
+}
+
+// Index finds similar fingerprints without comparing all pairs. Fingerprints
+// sharing aligned subfingerprints are candidates, only those are compared.
+type Index struct {
+	prints   [][]uint32
+	postings map[uint32][]posting
+}
+
+func NewIndex() *Index {
+	return &Index{postings: map[uint32][]posting{}}
+}
+
+// Add adds fp to the index and returns its ID, the number of previously added
+// fingerprints.
+func (x *Index) Add(fp []uint32) int {
+	id := len(x.prints)
+	x.prints = append(x.prints, fp)
+	for i, sub := range fp {
+		if sub == SILENCE {
+			continue
+		}
+		key := sub >> indexKeyShift
+		x.postings[key] = append(x.postings[key], posting{int32(id), int32(i)})
+	}
+	return id
+}
+
+// Search returns the indexed fingerprints with a similarity of at least
+// threshold to fp, the most similar first.
+func (x *Index) Search(fp []uint32, threshold float64) []Match {
+	type candidate struct {
+		id     int32
+		offset int
+	}
+	hits := map[candidate]int{}
+	for i, sub := range fp {
+		if sub == SILENCE {
+			continue
+		}
+		// Repeated keys in a row, i.e. of a held note, only count once
+		if i > 0 && sub>>indexKeyShift == fp[i-1]>>indexKeyShift {
+			continue
+		}
+		for _, p := range x.postings[sub>>indexKeyShift] {
+			hits[candidate{p.id, int(p.pos) - i}]++
+		}
+	}
+
+	offsets := map[int32][]candidate{}
+	for c, n := range hits {
+		if n >= minHits {
+			offsets[c.id] = append(offsets[c.id], c)
+		}
+	}
+	var matches []Match
+	for id, candidates := range offsets {
+		sort.Slice(candidates, func(i, j int) bool {
+			if hits[candidates[i]] != hits[candidates[j]] {
+				return hits[candidates[i]] > hits[candidates[j]]
+			}
+			return candidates[i].offset < candidates[j].offset
+		})
+		best := Match{ID: int(id)}
+		for _, c := range candidates[:min(len(candidates), maxCandidateOffsets)] {
+			if s := Similarity(fp, x.prints[id], c.offset); s > best.Similarity {
+				best.Similarity, best.Offset = s, c.offset
+			}
+		}
+		if best.Similarity >= threshold {
+			matches = append(matches, best)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
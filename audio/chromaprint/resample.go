@@ -0,0 +1,123 @@
+package chromaprint
+
+import "math"
+
+// The resampler of Chromaprint's audio processor, a port of the polyphase
+// filter of FFmpeg's libavcodec/resample2.c bundled with it. Fingerprints
+// depend on its exact integer output.
+
+const (
+	resampleFilterLength = 16
+	resamplePhaseShift   = 8
+	resampleCutoff       = 0.8
+	resampleFilterShift  = 15
+	resampleKaiserBeta   = 9
+)
+
+type resampler struct {
+	filters      []int16 // filterLength taps for each phase
+	filterLength int
+	phaseMask    int
+	index        int // position in the input in 1/phaseCount samples
+	frac         int
+	dstIncr      int
+	srcIncr      int
+}
+
+func newResampler(outRate int, inRate int) *resampler {
+	factor := min(float64(outRate)*resampleCutoff/float64(inRate), 1)
+	phaseCount := 1 << resamplePhaseShift
+	r := &resampler{
+		filterLength: max(int(math.Ceil(resampleFilterLength/factor)), 1),
+		phaseMask:    phaseCount - 1,
+		srcIncr:      outRate,
+		dstIncr:      inRate * phaseCount,
+	}
+	r.filters = buildFilter(factor, r.filterLength, phaseCount, 1<<resampleFilterShift)
+	r.index = -phaseCount * ((r.filterLength - 1) / 2)
+	return r
+}
+
+// buildFilter returns the Kaiser windowed sinc filters for all phases,
+// normalized to a DC gain of scale.
+func buildFilter(factor float64, taps int, phaseCount int, scale int) []int16 {
+	filters := make([]int16, taps*phaseCount)
+	tab := make([]float64, taps)
+	center := (taps - 1) / 2
+	for ph := 0; ph < phaseCount; ph++ {
+		norm := 0.0
+		for i := range tab {
+			x := math.Pi * (float64(i-center) - float64(ph)/float64(phaseCount)) * factor
+			y := 1.0
+			if x != 0 {
+				y = math.Sin(x) / x
+			}
+			w := 2.0 * x / (factor * float64(taps) * math.Pi)
+			y *= bessel(resampleKaiserBeta * math.Sqrt(max(1-w*w, 0)))
+			tab[i] = y
+			norm += y
+		}
+		for i, y := range tab {
+			v := math.RoundToEven(float64(float32(y * float64(scale) / norm)))
+			filters[ph*taps+i] = int16(max(math.MinInt16, min(math.MaxInt16, v)))
+		}
+	}
+	return filters
+}
+
+// bessel is the zeroth order modified Bessel function of the first kind.
+func bessel(x float64) float64 {
+	v, last, t := 1.0, 0.0, 1.0
+	x = x * x / 4
+	for i := 1; v != last; i++ {
+		last = v
+		t *= x / float64(i*i)
+		v += t
+	}
+	return v
+}
+
+// resample writes as many samples to dst as the input in src allows and
+// returns their number and the number of input samples which are no longer
+// needed.
+func (r *resampler) resample(dst []int16, src []int16) (int, int) {
+	index, frac := r.index, r.frac
+	dstIncrFrac, dstIncr := r.dstIncr%r.srcIncr, r.dstIncr/r.srcIncr
+	n := 0
+	for ; n < len(dst); n++ {
+		filter := r.filters[r.filterLength*(index&r.phaseMask):][:r.filterLength]
+		sampleIndex := index >> resamplePhaseShift
+		var val int32
+		if sampleIndex < 0 {
+			// The start of the input is mirrored
+			for i, tap := range filter {
+				j := sampleIndex + i
+				if j < 0 {
+					j = -j
+				}
+				val += int32(src[j%len(src)]) * int32(tap)
+			}
+		} else if sampleIndex+r.filterLength > len(src) {
+			break
+		} else {
+			for i, tap := range filter {
+				val += int32(src[sampleIndex+i]) * int32(tap)
+			}
+		}
+		val = (val + 1<<(resampleFilterShift-1)) >> resampleFilterShift
+		dst[n] = int16(max(math.MinInt16, min(math.MaxInt16, val)))
+
+		frac += dstIncrFrac
+		index += dstIncr
+		if frac >= r.srcIncr {
+			frac -= r.srcIncr
+			index++
+		}
+	}
+	consumed := max(index, 0) >> resamplePhaseShift
+	if index >= 0 {
+		index &= r.phaseMask
+	}
+	r.index, r.frac = index, frac
+	return n, consumed
+}
@@ -0,0 +1,145 @@
+package decode
+
+import (
+	"io"
+	"math/bits"
+)
+
+// bitReader reads big endian bit fields from a byte slice. Reading past the
+// end returns zero bits, callers check overruns with pos.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (b *bitReader) bit() uint32 {
+	return b.bits(1)
+}
+
+// bits reads n <= 32 bits.
+func (b *bitReader) bits(n int) uint32 {
+	var v uint32
+	for n > 0 {
+		i := b.pos >> 3
+		offset := b.pos & 7
+		take := min(n, 8-offset)
+		var c uint32
+		if i < len(b.data) {
+			c = uint32(b.data[i]>>(8-offset-take)) & (1<<take - 1)
+		}
+		v = v<<take | c
+		b.pos += take
+		n -= take
+	}
+	return v
+}
+
+// streamBitReader reads big endian bit fields from a stream. It never reads
+// more bytes than needed for the requested bits, so the stream is positioned
+// right after a frame once it is byte aligned. All consumed bytes are kept in
+// consumed, for checksums.
+type streamBitReader struct {
+	r        io.ByteReader
+	cache    uint64 // n bits, left aligned
+	n        int
+	consumed []byte
+	err      error // first read error, later reads return zero bits
+}
+
+func (b *streamBitReader) fill(n int) bool {
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if b.err == nil {
+				b.err = err
+			}
+			return false
+		}
+		b.consumed = append(b.consumed, c)
+		b.cache |= uint64(c) << (56 - b.n)
+		b.n += 8
+	}
+	return true
+}
+
+// bits reads n <= 32 bits.
+func (b *streamBitReader) bits(n int) uint32 {
+	if n == 0 || !b.fill(n) {
+		return 0
+	}
+	v := uint32(b.cache >> (64 - n))
+	b.cache <<= n
+	b.n -= n
+	return v
+}
+
+// signed reads an n <= 64 bit two's complement number.
+func (b *streamBitReader) signed(n int) int64 {
+	if n == 0 {
+		return 0
+	}
+	v := uint64(b.bits(min(n, 32)))
+	if n > 32 {
+		v = v<<(n-32) | uint64(b.bits(n-32))
+	}
+	return int64(v<<(64-n)) >> (64 - n)
+}
+
+// unary reads the number of 0 bits before the next 1 bit.
+func (b *streamBitReader) unary() int {
+	count := 0
+	for {
+		if b.n == 0 && !b.fill(8) {
+			return count
+		}
+		if zeros := bits.LeadingZeros64(b.cache); zeros < b.n {
+			b.cache <<= zeros + 1
+			b.n -= zeros + 1
+			return count + zeros
+		}
+		count += b.n
+		b.cache, b.n = 0, 0
+	}
+}
+
+// align drops the bits left of the current byte.
+func (b *streamBitReader) align() {
+	b.bits(b.n % 8)
+}
+
+// lsbBitReader reads little endian bit fields, least significant bit first,
+// from a byte slice as used by Vorbis. Reading past the end sets eop and
+// returns zero bits.
+type lsbBitReader struct {
+	data []byte
+	pos  int // in bits
+	eop  bool
+}
+
+// bits reads n <= 32 bits.
+func (b *lsbBitReader) bits(n int) uint32 {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// peek returns the next n <= 32 bits without consuming them.
+func (b *lsbBitReader) peek(n int) uint32 {
+	i, offset := b.pos>>3, b.pos&7
+	var v uint64
+	for k := 0; k < 5 && i+k < len(b.data); k++ {
+		v |= uint64(b.data[i+k]) << (8 * k)
+	}
+	return uint32(v>>offset) & uint32(1<<n-1)
+}
+
+func (b *lsbBitReader) skip(n int) {
+	b.pos += n
+	if b.pos > len(b.data)*8 {
+		b.eop = true
+	}
+}
+
+func (b *lsbBitReader) flag() bool {
+	return b.bits(1) == 1
+}
@@ -0,0 +1,188 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Decoders turning the audio data of music files into PCM samples, without
+// cgo or external programs.
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported audio format")
+	ErrMalformedStream   = errors.New("malformed audio stream")
+)
+
+// Kinds of damage, wrapped together with ErrMalformedStream
+var (
+	ErrTruncated = errors.New("truncated")
+	ErrChecksum  = errors.New("checksum mismatch")
+	ErrLostSync  = errors.New("lost sync")
+)
+
+// Decoder decodes an audio stream into interleaved PCM samples in the range
+// [-1, 1]. Lossy decoders clip samples which overshoot it.
+type Decoder interface {
+	SampleRate() int
+	Channels() int
+	// Read decodes up to len(samples) samples into samples. Only whole
+	// sample frames (one sample per channel) are returned, so samples has to
+	// have room for at least one. At the end of the stream it returns io.EOF.
+	Read(samples []float32) (int, error)
+}
+
+// File is a Decoder for an opened music file.
+type File struct {
+	Decoder
+	file *os.File
+}
+
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// BitsPerSample returns the sample size of lossless formats, 0 for lossy ones.
+func (f *File) BitsPerSample() int {
+	if d, ok := f.Decoder.(interface{ BitsPerSample() int }); ok {
+		return d.BitsPerSample()
+	}
+	return 0
+}
+
+// Open opens the music file at path and returns a decoder for it. The format
+// is detected from the file content, not its extension.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(f, 64*1024)
+	format, err := detectFormat(r)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var d Decoder
+	switch format {
+	case formatFLAC:
+		d, err = NewFLAC(r)
+	case formatWAV:
+		d, err = NewWAV(r)
+	case formatOgg:
+		d, err = NewVorbis(r)
+	case formatAIFF:
+		d, err = NewAIFF(r)
+	case formatMP3:
+		d, err = NewMP3(r)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &File{Decoder: d, file: f}, nil
+}
+
+type format int
+
+const (
+	formatMP3 format = iota
+	formatFLAC
+	formatWAV
+	formatAIFF
+	formatOgg
+)
+
+// detectFormat skips an ID3v2 tag at the start of r and detects the format of
+// the stream following it, which is left unread.
+func detectFormat(r *bufio.Reader) (format, error) {
+	// An ID3v2 tag is mostly found in front of MP3 streams, which may also
+	// have junk before the first frame
+	prefix, _ := r.Peek(3)
+	tagged := bytes.Equal(prefix, []byte("ID3"))
+	if err := skipID3v2(r); err != nil {
+		return 0, err
+	}
+	header, err := r.Peek(12)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return formatFLAC, nil
+	case len(header) == 12 && (string(header[:4]) == "RIFF" || string(header[:4]) == "RIFX") && string(header[8:]) == "WAVE":
+		return formatWAV, nil
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return formatOgg, nil
+	case len(header) == 12 && string(header[:4]) == "FORM" && (string(header[8:]) == "AIFF" || string(header[8:]) == "AIFC"):
+		return formatAIFF, nil
+	case isMP3Header(header) || tagged:
+		return formatMP3, nil
+	}
+	return 0, ErrUnsupportedFormat
+}
+
+// skipID3v2 skips an ID3v2 tag at the start of r, which some programs also
+// put in front of other formats than MP3.
+func skipID3v2(r *bufio.Reader) error {
+	header, err := r.Peek(10)
+	if err != nil || !bytes.HasPrefix(header, []byte("ID3")) {
+		return nil // too short for a tag, which the decoders report
+	}
+	size := 10 + (int(header[6]&0x7F)<<21 | int(header[7]&0x7F)<<14 | int(header[8]&0x7F)<<7 | int(header[9]&0x7F))
+	if header[5]&0x10 != 0 { // footer
+		size += 10
+	}
+	_, err = r.Discard(size)
+	if err == io.EOF {
+		return fmt.Errorf("%w: no audio after ID3v2 tag", ErrMalformedStream)
+	}
+	return err
+}
+
+// ReadInt16 reads up to len(samples) samples from d and converts them to 16
+// bit integers.
+func ReadInt16(d Decoder, samples []int16) (int, error) {
+	buf := make([]float32, len(samples))
+	n, err := d.Read(buf)
+	for i, s := range buf[:n] {
+		samples[i] = floatToInt16(s)
+	}
+	return n, err
+}
+
+func floatToInt16(s float32) int16 {
+	v := math.Round(float64(s) * 32768)
+	return int16(max(math.MinInt16, min(math.MaxInt16, v)))
+}
+
+// clip limits a decoded sample to [-1, 1]. The output of lossy codecs
+// overshoots full scale when the original was mastered close to it.
+func clip(s float32) float32 {
+	return max(-1, min(1, s))
+}
+
+// pcmBuffer holds decoded but not yet read samples for decoders producing
+// whole blocks of samples.
+type pcmBuffer struct {
+	samples  []float32
+	channels int
+}
+
+// read copies as many whole sample frames as fit from the buffer into dst.
+func (b *pcmBuffer) read(dst []float32) (int, error) {
+	if len(dst) < b.channels {
+		return 0, io.ErrShortBuffer
+	}
+	n := min(len(dst), len(b.samples))
+	n -= n % b.channels
+	copy(dst, b.samples[:n])
+	b.samples = b.samples[n:]
+	return n, nil
+}
@@ -0,0 +1,77 @@
+package decode_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	return path
+}
+
+// readAll decodes the whole stream of d.
+func readAll(t *testing.T, d decode.Decoder) []float32 {
+	var samples []float32
+	buf := make([]float32, 1000)
+	for {
+		n, err := d.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return samples
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestOpenUnsupportedFormat(t *testing.T) {
+	path := writeTestFile(t, "test.txt", []byte("not audio at all"))
+	if _, err := decode.Open(path); !errors.Is(err, decode.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
+
+func TestReadInt16(t *testing.T) {
+	d := &fixedDecoder{samples: []float32{0, 0.5, -0.5, 1, -1, 2}}
+	samples := make([]int16, 10)
+	n, err := decode.ReadInt16(d, samples)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []int16{0, 16384, -16384, 32767, -32768, 32767}
+	if n != len(expected) {
+		t.Fatalf("expected %d samples, but got %d", len(expected), n)
+	}
+	for i, s := range expected {
+		if samples[i] != s {
+			t.Errorf("expected sample %d to be %d, but got %d", i, s, samples[i])
+		}
+	}
+}
+
+// fixedDecoder is a mono Decoder returning a fixed list of samples.
+type fixedDecoder struct {
+	samples []float32
+}
+
+func (d *fixedDecoder) SampleRate() int { return 44100 }
+func (d *fixedDecoder) Channels() int   { return 1 }
+
+func (d *fixedDecoder) Read(samples []float32) (int, error) {
+	if len(d.samples) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(samples, d.samples)
+	d.samples = d.samples[n:]
+	return n, nil
+}
@@ -0,0 +1,453 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+)
+
+// https://xiph.org/flac/format.html
+
+const (
+	flacBlockStreamInfo = 0
+	flacLastBlockFlag   = 0x80
+
+	// Channel assignments of stereo decorrelation
+	flacLeftSide  = 8
+	flacRightSide = 9
+	flacMidSide   = 10
+)
+
+// Sample rates by the sample rate code of frame headers
+var flacSampleRates = [12]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+
+// Sample sizes by the sample size code of frame headers, 0 for the size of
+// the stream info and -1 for reserved codes
+var flacSampleSizes = [8]int{0, 8, 12, -1, 16, 20, 24, 32}
+
+// FLAC is a streaming FLAC decoder. If the stream info has an MD5 signature
+// of the audio, it is verified at the end of the stream.
+type FLAC struct {
+	r             *bufio.Reader
+	bits          streamBitReader
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	totalSamples  int64    // per channel, 0 if unknown
+	signature     [16]byte // MD5 of the audio, all zero if unknown
+	hash          hash.Hash
+	samples       [8][]int64 // of the current frame by channel
+	pcm           pcmBuffer
+	eof           bool
+}
+
+// NewFLAC returns a decoder for the FLAC stream in r.
+func NewFLAC(r io.Reader) (*FLAC, error) {
+	d := &FLAC{r: bufio.NewReaderSize(r, 64*1024)}
+	if err := skipID3v2(d.r); err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(d.r, magic); err != nil || !bytes.Equal(magic, []byte("fLaC")) {
+		return nil, fmt.Errorf("%w: missing FLAC stream marker", ErrMalformedStream)
+	}
+
+	hasStreamInfo := false
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(d.r, header); err != nil {
+			return nil, fmt.Errorf("%w: truncated metadata block header", ErrMalformedStream)
+		}
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		if header[0]&^flacLastBlockFlag == flacBlockStreamInfo {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(d.r, data); err != nil || size < 34 {
+				return nil, fmt.Errorf("%w: truncated STREAMINFO block", ErrMalformedStream)
+			}
+			d.parseStreamInfo(data)
+			hasStreamInfo = true
+		} else if _, err := d.r.Discard(size); err != nil {
+			return nil, fmt.Errorf("%w: truncated metadata block", ErrMalformedStream)
+		}
+		if header[0]&flacLastBlockFlag != 0 {
+			break
+		}
+	}
+	if !hasStreamInfo || d.sampleRate == 0 || d.channels > 8 {
+		return nil, fmt.Errorf("%w: missing or invalid STREAMINFO block", ErrMalformedStream)
+	}
+
+	if d.signature != [16]byte{} {
+		d.hash = md5.New()
+	}
+	d.bits.r = d.r
+	d.pcm.channels = d.channels
+	return d, nil
+}
+
+func (d *FLAC) parseStreamInfo(data []byte) {
+	d.sampleRate = int(data[10])<<12 | int(data[11])<<4 | int(data[12])>>4
+	d.channels = int(data[12]>>1&7) + 1
+	d.bitsPerSample = int(data[12]&1)<<4 | int(data[13]>>4) + 1
+	d.totalSamples = int64(data[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(data[14:18]))
+	copy(d.signature[:], data[18:34])
+}
+
+func (d *FLAC) SampleRate() int {
+	return d.sampleRate
+}
+
+func (d *FLAC) Channels() int {
+	return d.channels
+}
+
+// BitsPerSample returns the sample size of the stream.
+func (d *FLAC) BitsPerSample() int {
+	return d.bitsPerSample
+}
+
+// TotalSamples returns the number of samples per channel, 0 if unknown.
+func (d *FLAC) TotalSamples() int64 {
+	return d.totalSamples
+}
+
+func (d *FLAC) Read(samples []float32) (int, error) {
+	for len(d.pcm.samples) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+		err := d.decodeFrame()
+		if err == io.EOF {
+			d.eof = true
+			if d.hash != nil && !bytes.Equal(d.hash.Sum(nil), d.signature[:]) {
+				return 0, fmt.Errorf("%w: MD5 signature %w", ErrMalformedStream, ErrChecksum)
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return d.pcm.read(samples)
+}
+
+// decodeFrame decodes the next frame into the PCM buffer. Junk before the
+// frame, like an ID3v1 tag at the end of the file, is skipped.
+func (d *FLAC) decodeFrame() error {
+	for {
+		b, err := d.r.Peek(2)
+		if len(b) < 2 {
+			if err == nil || err == io.EOF {
+				return io.EOF
+			}
+			return err
+		}
+		if b[0] == 0xFF && b[1]&0xFE == 0xF8 {
+			break
+		}
+		d.r.Discard(1)
+	}
+
+	br := &d.bits
+	br.consumed, br.cache, br.n, br.err = br.consumed[:0], 0, 0, nil
+	br.bits(16) // sync code and blocking strategy
+	blockSizeCode, rateCode := br.bits(4), br.bits(4)
+	assignment, sizeCode := int(br.bits(4)), br.bits(3)
+	br.bits(1)
+	// UTF-8 like coded frame or sample number
+	if length := bits.LeadingZeros8(^uint8(br.bits(8))); length == 1 || length > 7 {
+		return fmt.Errorf("%w: invalid frame number", ErrMalformedStream)
+	} else if length > 1 {
+		br.bits(8 * (length - 1))
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 0:
+		return fmt.Errorf("%w: reserved block size", ErrMalformedStream)
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		blockSize = int(br.bits(8)) + 1
+	case blockSizeCode == 7:
+		blockSize = int(br.bits(16)) + 1
+	default:
+		blockSize = 256 << (blockSizeCode - 8)
+	}
+	switch rateCode {
+	case 12:
+		br.bits(8)
+	case 13, 14:
+		br.bits(16)
+	case 15:
+		return fmt.Errorf("%w: invalid sample rate", ErrMalformedStream)
+	}
+	bitsPerSample := flacSampleSizes[sizeCode]
+	if bitsPerSample == 0 {
+		bitsPerSample = d.bitsPerSample
+	}
+	if bitsPerSample != d.bitsPerSample {
+		return fmt.Errorf("%w: frame sample size differs from the stream", ErrMalformedStream)
+	}
+	if checksum := crc8(br.consumed); br.bits(8) != uint32(checksum) {
+		if br.err != nil {
+			return io.EOF // truncated header at the end
+		}
+		return fmt.Errorf("%w: frame header %w", ErrMalformedStream, ErrChecksum)
+	}
+
+	channels := assignment + 1
+	if assignment >= flacLeftSide {
+		channels = 2
+	}
+	if assignment > flacMidSide || channels != d.channels {
+		return fmt.Errorf("%w: invalid channel assignment", ErrMalformedStream)
+	}
+	for ch := 0; ch < channels; ch++ {
+		if cap(d.samples[ch]) < blockSize {
+			d.samples[ch] = make([]int64, blockSize)
+		}
+		d.samples[ch] = d.samples[ch][:blockSize]
+		// The side channel needs an extra bit
+		sampleSize := bitsPerSample
+		if (assignment == flacLeftSide || assignment == flacMidSide) && ch == 1 || assignment == flacRightSide && ch == 0 {
+			sampleSize++
+		}
+		if err := decodeFLACSubframe(br, d.samples[ch], sampleSize); err != nil {
+			return err
+		}
+	}
+	br.align()
+	if checksum := crc16(br.consumed); br.bits(16) != uint32(checksum) {
+		if br.err != nil {
+			return fmt.Errorf("%w: %w frame", ErrMalformedStream, ErrTruncated)
+		}
+		return fmt.Errorf("%w: frame %w", ErrMalformedStream, ErrChecksum)
+	}
+
+	d.decorrelate(assignment, blockSize)
+	d.output(blockSize)
+	return nil
+}
+
+func (d *FLAC) decorrelate(assignment int, blockSize int) {
+	left, right := d.samples[0], d.samples[1]
+	switch assignment {
+	case flacLeftSide:
+		for i := 0; i < blockSize; i++ {
+			right[i] = left[i] - right[i]
+		}
+	case flacRightSide:
+		for i := 0; i < blockSize; i++ {
+			left[i] += right[i]
+		}
+	case flacMidSide:
+		for i := 0; i < blockSize; i++ {
+			mid, side := left[i]<<1|right[i]&1, right[i]
+			left[i], right[i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+}
+
+// output appends the samples of the current frame to the PCM buffer and the
+// MD5 hash.
+func (d *FLAC) output(blockSize int) {
+	scale := 1 / float32(int64(1)<<(d.bitsPerSample-1))
+	bytesPerSample := (d.bitsPerSample + 7) / 8
+	var raw []byte
+	if d.hash != nil {
+		raw = make([]byte, 0, blockSize*d.channels*bytesPerSample)
+	}
+	for i := 0; i < blockSize; i++ {
+		for ch := 0; ch < d.channels; ch++ {
+			s := d.samples[ch][i]
+			d.pcm.samples = append(d.pcm.samples, float32(s)*scale)
+			if d.hash != nil {
+				for b := 0; b < bytesPerSample; b++ {
+					raw = append(raw, byte(s>>(8*b)))
+				}
+			}
+		}
+	}
+	if d.hash != nil {
+		d.hash.Write(raw)
+	}
+}
+
+// decodeFLACSubframe decodes the samples of a single channel into out.
+func decodeFLACSubframe(br *streamBitReader, out []int64, sampleSize int) error {
+	if br.bits(1) != 0 {
+		return fmt.Errorf("%w: invalid subframe header", ErrMalformedStream)
+	}
+	kind := int(br.bits(6))
+	wasted := 0
+	if br.bits(1) == 1 {
+		wasted = br.unary() + 1
+	}
+	sampleSize -= wasted
+	if sampleSize <= 0 {
+		return fmt.Errorf("%w: invalid wasted bits", ErrMalformedStream)
+	}
+
+	switch {
+	case kind == 0: // constant
+		v := br.signed(sampleSize)
+		for i := range out {
+			out[i] = v
+		}
+	case kind == 1: // verbatim
+		for i := range out {
+			out[i] = br.signed(sampleSize)
+		}
+	case kind >= 8 && kind <= 12: // fixed predictor
+		order := kind - 8
+		if err := decodeFLACWarmup(br, out, order, sampleSize); err != nil {
+			return err
+		}
+		if err := decodeFLACResidual(br, out, order); err != nil {
+			return err
+		}
+		predictFLACFixed(out, order)
+	case kind >= 32: // linear predictor
+		order := kind - 31
+		if err := decodeFLACWarmup(br, out, order, sampleSize); err != nil {
+			return err
+		}
+		precision := int(br.bits(4)) + 1
+		shift := int(br.signed(5))
+		if precision == 16 || shift < 0 {
+			return fmt.Errorf("%w: invalid LPC parameters", ErrMalformedStream)
+		}
+		coefficients := make([]int64, order)
+		for i := range coefficients {
+			coefficients[i] = br.signed(precision)
+		}
+		if err := decodeFLACResidual(br, out, order); err != nil {
+			return err
+		}
+		for i := order; i < len(out); i++ {
+			var sum int64
+			for j, c := range coefficients {
+				sum += c * out[i-1-j]
+			}
+			out[i] += sum >> shift
+		}
+	default:
+		return fmt.Errorf("%w: reserved subframe type %d", ErrMalformedStream, kind)
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+func decodeFLACWarmup(br *streamBitReader, out []int64, order int, sampleSize int) error {
+	if order > len(out) {
+		return fmt.Errorf("%w: predictor order exceeds block size", ErrMalformedStream)
+	}
+	for i := 0; i < order; i++ {
+		out[i] = br.signed(sampleSize)
+	}
+	return nil
+}
+
+// decodeFLACResidual decodes the Rice coded residual of a predicted subframe
+// into out after the order warm-up samples.
+func decodeFLACResidual(br *streamBitReader, out []int64, order int) error {
+	method := br.bits(2)
+	if method > 1 {
+		return fmt.Errorf("%w: reserved residual coding method", ErrMalformedStream)
+	}
+	parameterBits := 4 + int(method)
+	escape := uint32(1)<<parameterBits - 1
+	partitionOrder := int(br.bits(4))
+	partitionSize := len(out) >> partitionOrder
+	if partitionSize<<partitionOrder != len(out) || partitionSize < order {
+		return fmt.Errorf("%w: invalid residual partition order", ErrMalformedStream)
+	}
+
+	i := order
+	for p := 0; p < 1<<partitionOrder; p++ {
+		end := (p + 1) * partitionSize
+		parameter := br.bits(parameterBits)
+		if parameter == escape {
+			size := int(br.bits(5))
+			for ; i < end; i++ {
+				out[i] = br.signed(size)
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			v := uint64(br.unary())<<parameter | uint64(br.bits(int(parameter)))
+			out[i] = int64(v>>1) ^ -int64(v&1)
+		}
+	}
+	if br.err != nil {
+		return fmt.Errorf("%w: %w frame", ErrMalformedStream, ErrTruncated)
+	}
+	return nil
+}
+
+// predictFLACFixed adds the fixed polynomial predictions to the residual.
+func predictFLACFixed(out []int64, order int) {
+	for i := order; i < len(out); i++ {
+		switch order {
+		case 1:
+			out[i] += out[i-1]
+		case 2:
+			out[i] += 2*out[i-1] - out[i-2]
+		case 3:
+			out[i] += 3*out[i-1] - 3*out[i-2] + out[i-3]
+		case 4:
+			out[i] += 4*out[i-1] - 6*out[i-2] + 4*out[i-3] - out[i-4]
+		}
+	}
+}
+
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	for i := range crc8Table {
+		c8, c16 := uint8(i), uint16(i)<<8
+		for b := 0; b < 8; b++ {
+			c8 = c8<<1 ^ uint8(-(c8>>7))&0x07
+			c16 = c16<<1 ^ uint16(-(c16>>15))&0x8005
+		}
+		crc8Table[i], crc16Table[i] = c8, c16
+	}
+}
+
+// crc8 is the CRC of FLAC frame headers, polynomial x^8 + x^2 + x + 1.
+func crc8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
+
+// crc16 is the CRC of FLAC frames, polynomial x^16 + x^15 + x^2 + 1.
+func crc16(data []byte) uint16 {
+	return updateCRC16(0, data)
+}
+
+// updateCRC16 continues crc16 with data, starting from crc.
+func updateCRC16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
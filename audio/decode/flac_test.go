@@ -0,0 +1,264 @@
+package decode_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// Test stream parameters: 44100 Hz, 16 bit, blocks of 16 samples
+const flacBlockSize = 16
+
+// flacStream builds a FLAC stream with the given frames. A signature of the
+// samples is added unless it is nil.
+func flacStream(channels int, frames [][]byte, signature []byte) []byte {
+	info := &bitWriter{}
+	info.write(flacBlockSize, 16)
+	info.write(flacBlockSize, 16)
+	info.write(0, 48) // frame sizes
+	info.write(44100, 20)
+	info.write(uint32(channels-1), 3)
+	info.write(15, 5) // bits per sample - 1
+	info.write(0, 4)
+	info.write(uint32(len(frames)*flacBlockSize), 32)
+	content := append([]byte("fLaC"), 0x80, 0, 0, 34)
+	content = append(content, info.data...)
+	content = append(content, signature...)
+	if signature == nil {
+		content = append(content, make([]byte, 16)...)
+	}
+	for _, frame := range frames {
+		content = append(content, frame...)
+	}
+	return content
+}
+
+// flacFrame builds a frame with the given channel assignment, subframes are
+// written by subframes.
+func flacFrame(number int, assignment int, subframes func(w *bitWriter)) []byte {
+	w := &bitWriter{}
+	w.write(0xFFF8, 16)
+	w.write(6, 4) // 8 bit block size at the end of the header
+	w.write(9, 4) // 44100 Hz
+	w.write(uint32(assignment), 4)
+	w.write(4, 3) // 16 bit
+	w.write(0, 1)
+	w.write(uint32(number), 8)
+	w.write(flacBlockSize-1, 8)
+	w.write(uint32(testCRC8(w.data)), 8)
+	subframes(w)
+	for w.n%8 != 0 {
+		w.write(0, 1)
+	}
+	w.write(uint32(testCRC16(w.data)), 16)
+	return w.data
+}
+
+func writeSigned(w *bitWriter, v int, bits int) {
+	w.write(uint32(v)&(1<<bits-1), bits)
+}
+
+// writeConstant writes v, which has to be even, with one wasted bit.
+func writeConstant(w *bitWriter, v int, bits int) {
+	w.write(3, 9) // wasted bits 1 in unary
+	writeSigned(w, v>>1, bits-1)
+}
+
+func writeVerbatim(w *bitWriter, values []int, bits int) {
+	w.write(1<<1, 8)
+	for _, v := range values {
+		writeSigned(w, v, bits)
+	}
+}
+
+// writeFixed writes values with the fixed predictor of order 2.
+func writeFixed(w *bitWriter, values []int, bits int) {
+	w.write(10<<1, 8)
+	writeSigned(w, values[0], bits)
+	writeSigned(w, values[1], bits)
+	residual := make([]int, 0, len(values))
+	for i := 2; i < len(values); i++ {
+		residual = append(residual, values[i]-2*values[i-1]+values[i-2])
+	}
+	writeResidual(w, residual)
+}
+
+// writeLPC writes values with the linear predictor x[i] = (3x[i-1] - x[i-2]) / 2.
+func writeLPC(w *bitWriter, values []int, bits int) {
+	w.write(33<<1, 8) // order 2
+	writeSigned(w, values[0], bits)
+	writeSigned(w, values[1], bits)
+	w.write(3, 4) // precision - 1
+	w.write(1, 5) // shift
+	writeSigned(w, 3, 4)
+	writeSigned(w, -1, 4)
+	residual := make([]int, 0, len(values))
+	for i := 2; i < len(values); i++ {
+		residual = append(residual, values[i]-(3*values[i-1]-values[i-2])>>1)
+	}
+	writeResidual(w, residual)
+}
+
+// writeResidual writes a single partition with Rice parameter 3.
+func writeResidual(w *bitWriter, residual []int) {
+	w.write(0, 2)
+	w.write(0, 4)
+	w.write(3, 4)
+	for _, v := range residual {
+		u := uint32(v<<1 ^ v>>63)
+		w.write(1, int(u>>3)+1)
+		w.write(u&7, 3)
+	}
+}
+
+func testCRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func testCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// testSignal returns a smooth signal of flacBlockSize samples.
+func testSignal(offset int) []int {
+	values := make([]int, flacBlockSize)
+	for i := range values {
+		values[i] = offset + 50*i - 3*i*i
+	}
+	return values
+}
+
+func testSignature(left [][]int, right [][]int) []byte {
+	var raw []byte
+	for block := range left {
+		for i := range left[block] {
+			raw = append(raw, byte(left[block][i]), byte(left[block][i]>>8))
+			raw = append(raw, byte(right[block][i]), byte(right[block][i]>>8))
+		}
+	}
+	sum := md5.Sum(raw)
+	return sum[:]
+}
+
+func TestFLAC(t *testing.T) {
+	constant := make([]int, flacBlockSize)
+	for i := range constant {
+		constant[i] = -1234
+	}
+	left := [][]int{constant, testSignal(100), testSignal(-2000), testSignal(30000)}
+	right := [][]int{testSignal(-32768), testSignal(-100), testSignal(-1000), testSignal(20000)}
+	side := func(a []int, b []int) []int {
+		result := make([]int, len(a))
+		for i := range a {
+			result[i] = a[i] - b[i]
+		}
+		return result
+	}
+	frames := [][]byte{
+		flacFrame(0, 1, func(w *bitWriter) { // independent
+			writeConstant(w, -1234, 16)
+			writeVerbatim(w, right[0], 16)
+		}),
+		flacFrame(1, 8, func(w *bitWriter) { // left/side
+			writeFixed(w, left[1], 16)
+			writeLPC(w, side(left[1], right[1]), 17)
+		}),
+		flacFrame(2, 9, func(w *bitWriter) { // side/right
+			writeVerbatim(w, side(left[2], right[2]), 17)
+			writeFixed(w, right[2], 16)
+		}),
+		flacFrame(3, 10, func(w *bitWriter) { // mid/side
+			mid := make([]int, flacBlockSize)
+			for i := range mid {
+				mid[i] = (left[3][i] + right[3][i]) >> 1
+			}
+			writeLPC(w, mid, 16)
+			writeVerbatim(w, side(left[3], right[3]), 17)
+		}),
+	}
+	content := flacStream(2, frames, testSignature(left, right))
+	content = append(content, "TAG"...) // ID3v1 tags are skipped
+
+	d, err := decode.NewFLAC(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.SampleRate() != 44100 || d.Channels() != 2 || d.BitsPerSample() != 16 {
+		t.Errorf("expected 44100 Hz 16 bit stereo, but got %d Hz %d bit with %d channels", d.SampleRate(), d.BitsPerSample(), d.Channels())
+	}
+	samples := readAll(t, d)
+	if len(samples) != 4*flacBlockSize*2 {
+		t.Fatalf("expected %d samples, but got %d", 4*flacBlockSize*2, len(samples))
+	}
+	for block := range left {
+		for i := 0; i < flacBlockSize; i++ {
+			l, r := samples[(block*flacBlockSize+i)*2]*32768, samples[(block*flacBlockSize+i)*2+1]*32768
+			if int(l) != left[block][i] || int(r) != right[block][i] {
+				t.Errorf("expected sample %d of frame %d to be %d/%d, but got %.0f/%.0f", i, block, left[block][i], right[block][i], l, r)
+			}
+		}
+	}
+}
+
+func TestFLACSignatureMismatch(t *testing.T) {
+	frame := flacFrame(0, 0, func(w *bitWriter) { writeVerbatim(w, testSignal(0), 16) })
+	d, err := decode.NewFLAC(bytes.NewReader(flacStream(1, [][]byte{frame}, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	samples := make([]float32, 100)
+	if n, err := d.Read(samples); err != nil || n != flacBlockSize {
+		t.Fatalf("expected %d samples, but got %d (%v)", flacBlockSize, n, err)
+	}
+	if _, err := d.Read(samples); err != io.EOF {
+		t.Errorf("expected EOF without signature, but got %v", err)
+	}
+
+	wrong := bytes.Repeat([]byte{1}, 16)
+	d, err = decode.NewFLAC(bytes.NewReader(flacStream(1, [][]byte{frame}, wrong)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.Read(samples)
+	if _, err := d.Read(samples); !errors.Is(err, decode.ErrMalformedStream) {
+		t.Errorf("expected ErrMalformedStream, but got %v", err)
+	}
+}
+
+func TestFLACCorruptFrame(t *testing.T) {
+	frame := flacFrame(0, 0, func(w *bitWriter) { writeVerbatim(w, testSignal(0), 16) })
+	frame[10] ^= 0xFF
+	d, err := decode.NewFLAC(bytes.NewReader(flacStream(1, [][]byte{frame}, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.Read(make([]float32, 100)); !errors.Is(err, decode.ErrMalformedStream) {
+		t.Errorf("expected ErrMalformedStream, but got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package decode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lossy encoders add silence before the audio, the encoder delay, and after
+// it to fill the last frame, the padding. Encoders which support gapless
+// playback record both, LAME and ffmpeg in the LAME tag of MP3 files and
+// iTunes and ffmpeg as iTunSMPB in AAC files. The decoders remove them, so the
+// decoded audio has exactly the samples of the encoded audio.
+
+// Delay of MP3 decoders, the synthesis filterbank starts 528 samples late,
+// plus one sample by convention
+const MP3_DECODER_DELAY = 529
+
+// Gapless is the encoder delay and padding of a lossy file.
+type Gapless struct {
+	Delay   int   // samples per channel before the audio
+	Padding int   // samples per channel after the audio
+	Samples int64 // samples per channel of the audio, 0 if unknown
+}
+
+// Gapless returns the encoder delay and padding of the file, if its format
+// records them. They are already removed from the decoded samples.
+func (f *File) Gapless() (Gapless, bool) {
+	if d, ok := f.Decoder.(interface{ Gapless() (Gapless, bool) }); ok {
+		return d.Gapless()
+	}
+	return Gapless{}, false
+}
+
+// ParseITunSMPB parses the value of the iTunSMPB tag of AAC files. It
+// consists of hexadecimal numbers, the second is the encoder delay, the third
+// the padding and the fourth the number of samples.
+func ParseITunSMPB(value string) (Gapless, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		return Gapless{}, fmt.Errorf("%w: invalid iTunSMPB \"%s\"", ErrMalformedStream, value)
+	}
+	var numbers [3]int64
+	for i, field := range fields[1:4] {
+		n, err := strconv.ParseInt(field, 16, 64)
+		if err != nil || n < 0 {
+			return Gapless{}, fmt.Errorf("%w: invalid iTunSMPB \"%s\"", ErrMalformedStream, value)
+		}
+		numbers[i] = n
+	}
+	return Gapless{Delay: int(numbers[0]), Padding: int(numbers[1]), Samples: numbers[2]}, nil
+}
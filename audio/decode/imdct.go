@@ -0,0 +1,82 @@
+package decode
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// imdct computes inverse modified discrete cosine transforms of a fixed size
+//
+//	y[n] = sum(X[k] cos(2pi/N (n + 1/2 + N/4)(k + 1/2)))
+//
+// for N outputs from N/2 coefficients, by a DCT-IV on an N/8 point complex
+// FFT.
+type imdct struct {
+	n       int          // number of outputs, a power of two >= 16
+	twiddle []complex128 // exp(-i pi (k + 1/8) / (N/2))
+	fft     []complex128 // exp(-2 pi i k / (N/4))
+	reverse []int        // bit reversal permutation of the FFT
+	buf     []complex128
+	dct     []float64
+}
+
+func newIMDCT(n int) *imdct {
+	m := n / 2 // coefficients
+	t := &imdct{
+		n:       n,
+		twiddle: make([]complex128, m/2),
+		fft:     make([]complex128, m/4),
+		reverse: make([]int, m/2),
+		buf:     make([]complex128, m/2),
+		dct:     make([]float64, m),
+	}
+	for k := range t.twiddle {
+		t.twiddle[k] = cmplx.Exp(complex(0, -math.Pi*(float64(k)+0.125)/float64(m)))
+	}
+	for k := range t.fft {
+		t.fft[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(m/2)))
+	}
+	shift := 64 - bits.Len(uint(m/2)-1)
+	for k := range t.reverse {
+		t.reverse[k] = int(bits.Reverse64(uint64(k)) >> shift)
+	}
+	return t
+}
+
+// transform computes the IMDCT of the n/2 coefficients in in into out.
+func (t *imdct) transform(in []float32, out []float32) {
+	m := t.n / 2
+
+	// DCT-IV u[j] = sum(X[k] cos(pi/M (j + 1/2)(k + 1/2)))
+	z := t.buf
+	for k := 0; k < m/2; k++ {
+		z[t.reverse[k]] = complex(float64(in[2*k]), float64(in[m-1-2*k])) * t.twiddle[k]
+	}
+	for size := 2; size <= m/2; size <<= 1 {
+		step := m / 2 / size
+		for start := 0; start < m/2; start += size {
+			for k := 0; k < size/2; k++ {
+				a, b := z[start+k], z[start+k+size/2]*t.fft[k*step]
+				z[start+k], z[start+k+size/2] = a+b, a-b
+			}
+		}
+	}
+	u := t.dct
+	for j := 0; j < m/2; j++ {
+		w := z[j] * t.twiddle[j]
+		u[2*j], u[m-1-2*j] = real(w), -imag(w)
+	}
+
+	// y[n] = u[n + M/2] with u extended by its symmetries
+	for n := 0; n < t.n; n++ {
+		switch j := n + m/2; {
+		case j < m:
+			out[n] = float32(u[j])
+		case j < 2*m:
+			out[n] = float32(-u[2*m-1-j])
+		default:
+			out[n] = float32(-u[j-2*m])
+		}
+	}
+}
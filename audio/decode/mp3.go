@@ -0,0 +1,365 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MPEG-1/2/2.5 Layer III, ISO/IEC 11172-3 and ISO/IEC 13818-3
+// https://www.mp3-tech.org/programmer/frame_header.html
+
+const (
+	mp3VersionMPEG25 = 0
+	mp3VersionMPEG2  = 2
+	mp3VersionMPEG1  = 3
+
+	mp3ModeJointStereo = 1
+	mp3ModeMono        = 3
+
+	mp3MaxReservoir = 511 // largest main_data_begin
+)
+
+// Bitrates in kbit/s by bitrate index for MPEG-1 and MPEG-2/2.5
+var mp3Bitrates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// Sample rates of MPEG-1, MPEG-2 and MPEG-2.5
+var mp3SampleRates = [9]int{44100, 48000, 32000, 22050, 24000, 16000, 11025, 12000, 8000}
+
+type mp3Header struct {
+	version       int
+	protected     bool // a CRC follows the header
+	bitrate       int  // kbit/s
+	rateIndex     int  // index into mp3SampleRates
+	padding       bool
+	mode          int
+	modeExtension int
+}
+
+// parseMP3Header parses the 4 byte frame header at the start of b. Free
+// format streams and layers other than III are not supported.
+func parseMP3Header(b []byte) (mp3Header, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Header{}, false
+	}
+	h := mp3Header{
+		version:       int(b[1] >> 3 & 3),
+		protected:     b[1]&1 == 0,
+		padding:       b[2]>>1&1 == 1,
+		mode:          int(b[3] >> 6),
+		modeExtension: int(b[3] >> 4 & 3),
+	}
+	layer, bitrateIndex, rateIndex := b[1]>>1&3, int(b[2]>>4), int(b[2]>>2&3)
+	if h.version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Header{}, false
+	}
+	if h.lsf() {
+		h.bitrate = mp3Bitrates[1][bitrateIndex]
+	} else {
+		h.bitrate = mp3Bitrates[0][bitrateIndex]
+	}
+	switch h.version {
+	case mp3VersionMPEG1:
+		h.rateIndex = rateIndex
+	case mp3VersionMPEG2:
+		h.rateIndex = 3 + rateIndex
+	case mp3VersionMPEG25:
+		h.rateIndex = 6 + rateIndex
+	}
+	return h, true
+}
+
+func isMP3Header(b []byte) bool {
+	_, ok := parseMP3Header(b)
+	return ok
+}
+
+// lsf reports whether the frame uses the lower sampling frequencies of MPEG-2
+// and MPEG-2.5.
+func (h mp3Header) lsf() bool {
+	return h.version != mp3VersionMPEG1
+}
+
+func (h mp3Header) channels() int {
+	if h.mode == mp3ModeMono {
+		return 1
+	}
+	return 2
+}
+
+func (h mp3Header) sampleRate() int {
+	return mp3SampleRates[h.rateIndex]
+}
+
+func (h mp3Header) granules() int {
+	if h.lsf() {
+		return 1
+	}
+	return 2
+}
+
+func (h mp3Header) frameSize() int {
+	size := 144000 * h.bitrate / h.sampleRate()
+	if h.lsf() {
+		size /= 2
+	}
+	if h.padding {
+		size++
+	}
+	return size
+}
+
+func (h mp3Header) sideInfoSize() int {
+	switch {
+	case h.lsf() && h.channels() == 1:
+		return 9
+	case h.lsf(), h.channels() == 1:
+		return 17
+	}
+	return 32
+}
+
+// compatible reports whether a frame with header other can belong to the same
+// stream as one with h.
+func (h mp3Header) compatible(other mp3Header) bool {
+	return h.version == other.version && h.rateIndex == other.rateIndex && h.channels() == other.channels()
+}
+
+// MP3 is a streaming MPEG-1/2/2.5 Layer III decoder.
+type MP3 struct {
+	r         *bufio.Reader
+	header    mp3Header // of the first frame
+	reservoir []byte    // main data of previous frames
+	channels  [2]mp3Channel
+	pcm       pcmBuffer
+	eof       bool
+
+	gapless   Gapless
+	hasGaps   bool  // set if the stream has a LAME tag
+	skip      int64 // samples per channel still to be removed at the start
+	remaining int64 // samples per channel still to be returned, -1 if unknown
+}
+
+// NewMP3 returns a decoder for the MP3 stream in r. A leading ID3v2 tag and
+// the Xing/Info frame of VBR files are skipped. If the Xing/Info frame has a
+// LAME tag, the encoder delay and padding it records are removed.
+func NewMP3(r io.Reader) (*MP3, error) {
+	d := &MP3{r: bufio.NewReaderSize(r, 16*1024), remaining: -1}
+	if err := skipID3v2(d.r); err != nil {
+		return nil, err
+	}
+
+	frame, h, err := d.nextFrame(false)
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: no MPEG audio frame found", ErrMalformedStream)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.header = h
+	d.pcm.channels = h.channels()
+	if !isXingFrame(frame, h) {
+		d.decodeFrame(frame, h)
+	} else if g, ok := parseLAMETag(frame, h); ok {
+		d.gapless, d.hasGaps = g, true
+		d.skip = int64(g.Delay + MP3_DECODER_DELAY)
+		if g.Samples > 0 {
+			d.remaining = g.Samples
+		}
+	}
+	return d, nil
+}
+
+func (d *MP3) SampleRate() int {
+	return d.header.sampleRate()
+}
+
+func (d *MP3) Channels() int {
+	return d.header.channels()
+}
+
+// Gapless returns the encoder delay and padding recorded in the LAME tag.
+func (d *MP3) Gapless() (Gapless, bool) {
+	return d.gapless, d.hasGaps
+}
+
+func (d *MP3) Read(samples []float32) (int, error) {
+	channels := d.header.channels()
+	for {
+		if d.skip > 0 && len(d.pcm.samples) > 0 {
+			n := min(d.skip, int64(len(d.pcm.samples)/channels))
+			d.pcm.samples = d.pcm.samples[n*int64(channels):]
+			d.skip -= n
+		}
+		if len(d.pcm.samples) > 0 || d.remaining == 0 {
+			break
+		}
+		if d.eof {
+			return 0, io.EOF
+		}
+		frame, h, err := d.nextFrame(true)
+		if err == io.EOF {
+			d.eof = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		d.decodeFrame(frame, h)
+	}
+	if d.remaining < 0 {
+		return d.pcm.read(samples)
+	}
+	if d.remaining == 0 {
+		return 0, io.EOF
+	}
+	if limit := d.remaining * int64(channels); int64(len(samples)) > limit {
+		samples = samples[:limit]
+	}
+	n, err := d.pcm.read(samples)
+	d.remaining -= int64(n / channels)
+	return n, err
+}
+
+// nextFrame reads the next frame. Junk between frames, like tags at the end of
+// the stream, is skipped. Before the stream is synced a frame is only
+// accepted if it is followed by another frame header, to avoid false syncs
+// in junk. A truncated last frame is dropped.
+func (d *MP3) nextFrame(synced bool) ([]byte, mp3Header, error) {
+	for {
+		b, err := d.r.Peek(4)
+		if len(b) < 4 {
+			if err == nil || err == io.EOF {
+				return nil, mp3Header{}, io.EOF
+			}
+			return nil, mp3Header{}, err
+		}
+		h, ok := parseMP3Header(b)
+		if !ok || (synced && !h.compatible(d.header)) {
+			d.r.Discard(1)
+			continue
+		}
+
+		size := h.frameSize()
+		b, err = d.r.Peek(size + 4)
+		if len(b) < size {
+			if err == io.EOF {
+				return nil, mp3Header{}, io.EOF
+			}
+			return nil, mp3Header{}, err
+		}
+		if !synced && len(b) == size+4 {
+			if next, ok := parseMP3Header(b[size:]); !ok || !next.compatible(h) {
+				d.r.Discard(1)
+				continue
+			}
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(d.r, frame); err != nil {
+			return nil, mp3Header{}, err
+		}
+		return frame, h, nil
+	}
+}
+
+// isXingFrame reports whether frame holds the Xing, Info or VBRI header of a
+// VBR file instead of audio.
+func isXingFrame(frame []byte, h mp3Header) bool {
+	offset := 4 + h.sideInfoSize()
+	if h.protected {
+		offset += 2
+	}
+	if len(frame) >= offset+4 {
+		if tag := frame[offset : offset+4]; bytes.Equal(tag, []byte("Xing")) || bytes.Equal(tag, []byte("Info")) {
+			return true
+		}
+	}
+	return len(frame) >= 36+4 && bytes.Equal(frame[36:40], []byte("VBRI"))
+}
+
+// parseLAMETag returns the encoder delay and padding of the LAME tag in the
+// Xing/Info frame, and the number of samples if the frame has the number of
+// frames. ffmpeg writes the same tag.
+// http://gabriel.mp3-tech.org/mp3infotag.html
+func parseLAMETag(frame []byte, h mp3Header) (Gapless, bool) {
+	offset := 4 + h.sideInfoSize()
+	if h.protected {
+		offset += 2
+	}
+	if len(frame) < offset+8 || !isXingFrame(frame[:offset+4], h) {
+		return Gapless{}, false
+	}
+	flags := binary.BigEndian.Uint32(frame[offset+4:])
+	offset += 8
+	var frames int64
+	if flags&1 != 0 {
+		if len(frame) < offset+4 {
+			return Gapless{}, false
+		}
+		frames = int64(binary.BigEndian.Uint32(frame[offset:]))
+		offset += 4
+	}
+	for _, field := range []struct {
+		flag uint32
+		size int
+	}{{2, 4}, {4, 100}, {8, 4}} { // bytes, table of contents, quality
+		if flags&field.flag != 0 {
+			offset += field.size
+		}
+	}
+	if len(frame) < offset+24 {
+		return Gapless{}, false
+	}
+	tag := frame[offset:]
+	if encoder := string(tag[:4]); encoder != "LAME" && encoder != "Lavc" && encoder != "Lavf" {
+		return Gapless{}, false
+	}
+	g := Gapless{
+		Delay:   int(tag[21])<<4 | int(tag[22])>>4,
+		Padding: int(tag[22]&0x0F)<<8 | int(tag[23]),
+	}
+	if frames > 0 {
+		g.Samples = max(0, frames*int64(h.granules()*576)-int64(g.Delay+g.Padding))
+	}
+	return g, true
+}
+
+// decodeFrame decodes frame and appends its samples to the PCM buffer. Frames
+// referencing main data which is not available, i.e. after junk, are
+// decoded as silence.
+func (d *MP3) decodeFrame(frame []byte, h mp3Header) {
+	offset := 4
+	if h.protected {
+		offset += 2
+	}
+	if len(frame) < offset+h.sideInfoSize() {
+		return
+	}
+	side := parseMP3SideInfo(frame[offset:offset+h.sideInfoSize()], h)
+	mainData := frame[offset+h.sideInfoSize():]
+
+	buf := append(d.reservoir, mainData...)
+	start := len(d.reservoir) - side.mainDataBegin
+	d.reservoir = append([]byte(nil), buf[max(0, len(buf)-mp3MaxReservoir):]...)
+
+	channels := h.channels()
+	samples := make([]float32, h.granules()*576*channels)
+	if start >= 0 {
+		br := &bitReader{data: buf, pos: start * 8}
+		var out [2][576]float32
+		for gr := 0; gr < h.granules(); gr++ {
+			d.decodeGranule(br, h, &side, gr, &out)
+			for ch := 0; ch < channels; ch++ {
+				for i, s := range out[ch] {
+					samples[(gr*576+i)*channels+ch] = clip(s)
+				}
+			}
+		}
+	}
+	d.pcm.samples = append(d.pcm.samples, samples...)
+}
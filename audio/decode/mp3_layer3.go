@@ -0,0 +1,736 @@
+package decode
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Block kinds, used to index band layouts and mp3LSFScalefactorCounts
+const (
+	mp3BlockLong  = 0
+	mp3BlockShort = 1
+	mp3BlockMixed = 2
+)
+
+type mp3GranuleInfo struct {
+	part23Length     int // bits of scalefactors and Huffman data
+	bigValues        int
+	globalGain       int
+	scalefacCompress int
+	windowSwitching  bool
+	blockType        int
+	mixedBlock       bool
+	tableSelect      [3]int
+	subblockGain     [3]int
+	regionCount      [2]int // bands of region 0 and 1, minus one
+	preflag          bool
+	scalefacScale    int
+	count1Table      int
+}
+
+func (g *mp3GranuleInfo) kind() int {
+	switch {
+	case !g.windowSwitching || g.blockType != 2:
+		return mp3BlockLong
+	case g.mixedBlock:
+		return mp3BlockMixed
+	}
+	return mp3BlockShort
+}
+
+type mp3SideInfo struct {
+	mainDataBegin int
+	scfsi         [2][4]bool
+	granules      [2][2]mp3GranuleInfo // by granule and channel
+}
+
+func parseMP3SideInfo(b []byte, h mp3Header) mp3SideInfo {
+	br := &bitReader{data: b}
+	var side mp3SideInfo
+	channels := h.channels()
+	if h.lsf() {
+		side.mainDataBegin = int(br.bits(8))
+		br.bits(channels) // private bits
+	} else {
+		side.mainDataBegin = int(br.bits(9))
+		if channels == 1 {
+			br.bits(5)
+		} else {
+			br.bits(3)
+		}
+		for ch := 0; ch < channels; ch++ {
+			for band := range side.scfsi[ch] {
+				side.scfsi[ch][band] = br.bit() == 1
+			}
+		}
+	}
+
+	for gr := 0; gr < h.granules(); gr++ {
+		for ch := 0; ch < channels; ch++ {
+			g := &side.granules[gr][ch]
+			g.part23Length = int(br.bits(12))
+			g.bigValues = min(int(br.bits(9)), 288)
+			g.globalGain = int(br.bits(8))
+			if h.lsf() {
+				g.scalefacCompress = int(br.bits(9))
+			} else {
+				g.scalefacCompress = int(br.bits(4))
+			}
+			g.windowSwitching = br.bit() == 1
+			if g.windowSwitching {
+				g.blockType = int(br.bits(2))
+				g.mixedBlock = br.bit() == 1
+				for i := 0; i < 2; i++ {
+					g.tableSelect[i] = int(br.bits(5))
+				}
+				for i := range g.subblockGain {
+					g.subblockGain[i] = int(br.bits(3))
+				}
+				// Region 1 extends to the end of the big values
+				g.regionCount = [2]int{7, 255}
+				if g.kind() == mp3BlockShort {
+					g.regionCount[0] = 8
+				}
+			} else {
+				for i := range g.tableSelect {
+					g.tableSelect[i] = int(br.bits(5))
+				}
+				g.regionCount[0] = int(br.bits(4))
+				g.regionCount[1] = int(br.bits(3))
+			}
+			if !h.lsf() {
+				g.preflag = br.bit() == 1
+			}
+			g.scalefacScale = int(br.bits(1))
+			g.count1Table = int(br.bits(1))
+		}
+	}
+	return side
+}
+
+// mp3BandLayout lists the widths of the scalefactor bands of a granule. Short
+// bands are listed once per window, as their samples are stored window after
+// window.
+type mp3BandLayout struct {
+	widths []int
+	long   int // number of long bands at the start
+}
+
+// Band layouts by sample rate index and block kind
+var mp3Layouts [9][3]mp3BandLayout
+
+func init() {
+	for rate := range mp3Layouts {
+		long, short := mp3LongBands[rate], mp3ShortBands[rate]
+		longLayout := mp3BandLayout{long: 22}
+		for b := 0; b < 22; b++ {
+			longLayout.widths = append(longLayout.widths, long[b+1]-long[b])
+		}
+		shortLayout := mp3BandLayout{}
+		for b := 0; b < 13; b++ {
+			for w := 0; w < 3; w++ {
+				shortLayout.widths = append(shortLayout.widths, short[b+1]-short[b])
+			}
+		}
+		// The long part of mixed blocks covers the first two subbands, which
+		// are the first 8 long bands of MPEG-1 and the first 6 of MPEG-2/2.5.
+		mixedLayout := mp3BandLayout{long: 8}
+		if rate >= 3 {
+			mixedLayout.long = 6
+		}
+		mixedLayout.widths = append(mixedLayout.widths, longLayout.widths[:mixedLayout.long]...)
+		mixedLayout.widths = append(mixedLayout.widths, shortLayout.widths[9:]...)
+		mp3Layouts[rate] = [3]mp3BandLayout{longLayout, shortLayout, mixedLayout}
+	}
+}
+
+// mp3Channel is the decoder state of a single channel.
+type mp3Channel struct {
+	scalefactors [39]int // of the previous granule, for scfsi
+	overlap      [576]float32
+	synthesis    mp3Synthesis
+}
+
+// decodeGranule decodes granule gr of all channels into PCM samples.
+func (d *MP3) decodeGranule(br *bitReader, h mp3Header, side *mp3SideInfo, gr int, out *[2][576]float32) {
+	var values [576]int
+	var isPos [39]int
+	channels := h.channels()
+	intensity := h.mode == mp3ModeJointStereo && h.modeExtension&1 != 0
+	for ch := 0; ch < channels; ch++ {
+		g := &side.granules[gr][ch]
+		layout := &mp3Layouts[h.rateIndex][g.kind()]
+		start := br.pos
+
+		var scalefactors [39]int
+		if h.lsf() {
+			scalefactors, isPos = readMP3ScalefactorsLSF(br, g, intensity && ch == 1)
+		} else {
+			scalefactors = d.readMP3Scalefactors(br, g, side.scfsi[ch], gr, ch)
+			isPos = scalefactors
+		}
+		readMP3Huffman(br, g, layout, start+g.part23Length, &values)
+		br.pos = start + g.part23Length
+		requantizeMP3(&values, g, layout, &scalefactors, &out[ch])
+	}
+
+	if h.mode == mp3ModeJointStereo && channels == 2 {
+		g := &side.granules[gr][1]
+		mp3Stereo(h, g, &mp3Layouts[h.rateIndex][g.kind()], &isPos, &out[0], &out[1])
+	}
+	for ch := 0; ch < channels; ch++ {
+		g := &side.granules[gr][ch]
+		d.channels[ch].synthesize(g, &mp3Layouts[h.rateIndex][g.kind()], &out[ch])
+	}
+}
+
+func (d *MP3) readMP3Scalefactors(br *bitReader, g *mp3GranuleInfo, scfsi [4]bool, gr int, ch int) [39]int {
+	var scalefactors [39]int
+	slen1, slen2 := mp3Slen1[g.scalefacCompress], mp3Slen2[g.scalefacCompress]
+	if g.kind() == mp3BlockLong {
+		partitions := [5]int{0, 6, 11, 16, 21}
+		previous := &d.channels[ch].scalefactors
+		for p := 0; p < 4; p++ {
+			slen := slen1
+			if p >= 2 {
+				slen = slen2
+			}
+			for b := partitions[p]; b < partitions[p+1]; b++ {
+				if gr == 1 && scfsi[p] {
+					scalefactors[b] = previous[b]
+				} else {
+					scalefactors[b] = int(br.bits(slen))
+				}
+			}
+		}
+	} else {
+		// Short bands 0-5 (3-5 for mixed blocks) use slen1, 6-11 slen2
+		first := 18
+		if g.kind() == mp3BlockMixed {
+			first = 17
+		}
+		for b := 0; b < first; b++ {
+			scalefactors[b] = int(br.bits(slen1))
+		}
+		for b := first; b < first+18; b++ {
+			scalefactors[b] = int(br.bits(slen2))
+		}
+	}
+	d.channels[ch].scalefactors = scalefactors
+	return scalefactors
+}
+
+// readMP3ScalefactorsLSF reads the scalefactors of MPEG-2/2.5. For the right
+// channel of intensity stereo they are intensity positions, it also returns
+// them with -1 for illegal positions.
+func readMP3ScalefactorsLSF(br *bitReader, g *mp3GranuleInfo, intensityRight bool) ([39]int, [39]int) {
+	var slen [4]int
+	var table int
+	sfc := g.scalefacCompress
+	switch {
+	case intensityRight && sfc>>1 < 180:
+		sfc >>= 1
+		slen, table = [4]int{sfc / 36, sfc % 36 / 6, sfc % 36 % 6, 0}, 3
+	case intensityRight && sfc>>1 < 244:
+		sfc = sfc>>1 - 180
+		slen, table = [4]int{sfc & 63 >> 4, sfc & 15 >> 2, sfc & 3, 0}, 4
+	case intensityRight:
+		sfc = sfc>>1 - 244
+		slen, table = [4]int{sfc / 3, sfc % 3, 0, 0}, 5
+	case sfc < 400:
+		slen, table = [4]int{sfc >> 4 / 5, sfc >> 4 % 5, sfc & 15 >> 2, sfc & 3}, 0
+	case sfc < 500:
+		sfc -= 400
+		slen, table = [4]int{sfc >> 2 / 5, sfc >> 2 % 5, sfc & 3, 0}, 1
+	default:
+		sfc -= 500
+		slen, table = [4]int{sfc / 3, sfc % 3, 0, 0}, 2
+		g.preflag = true
+	}
+
+	var scalefactors, isPos [39]int
+	b := 0
+	for p, count := range mp3LSFScalefactorCounts[table][g.kind()] {
+		for i := 0; i < count; i++ {
+			scalefactors[b] = int(br.bits(slen[p]))
+			isPos[b] = scalefactors[b]
+			if intensityRight && slen[p] > 0 && scalefactors[b] == 1<<slen[p]-1 {
+				isPos[b] = -1
+			}
+			b++
+		}
+	}
+	return scalefactors, isPos
+}
+
+// huffmanTree is a binary tree for decoding a Huffman table. Children are
+// node indexes, or -(value+1) for leaves. 0 marks a missing child.
+type huffmanTree [][2]int16
+
+var mp3HuffmanTrees [34]huffmanTree
+
+func init() {
+	for i, table := range mp3HuffmanTables {
+		if table.lengths != nil {
+			mp3HuffmanTrees[i] = buildHuffmanTree(table)
+		}
+	}
+}
+
+func buildHuffmanTree(table huffmanTable) huffmanTree {
+	tree := huffmanTree{{}}
+	for value, length := range table.lengths {
+		if length == 0 {
+			continue
+		}
+		code, node := table.codes[value], 0
+		for bit := int(length) - 1; bit > 0; bit-- {
+			b := code >> bit & 1
+			if tree[node][b] == 0 {
+				tree = append(tree, [2]int16{})
+				tree[node][b] = int16(len(tree) - 1)
+			}
+			node = int(tree[node][b])
+		}
+		tree[node][code&1] = int16(-value - 1)
+	}
+	return tree
+}
+
+// decode reads a code word and returns its value. It fails for invalid codes.
+func (t huffmanTree) decode(br *bitReader) (int, bool) {
+	node := 0
+	for {
+		child := t[node][br.bit()]
+		switch {
+		case child < 0:
+			return int(-child - 1), true
+		case child == 0:
+			return 0, false
+		}
+		node = int(child)
+	}
+}
+
+// readMP3Huffman decodes the quantized values of a granule, which end at bit
+// end. Values not coded are zero.
+func readMP3Huffman(br *bitReader, g *mp3GranuleInfo, layout *mp3BandLayout, end int, values *[576]int) {
+	region1, region2 := 576, 576
+	sum := 0
+	for b, width := range layout.widths {
+		sum += width
+		if b == g.regionCount[0] {
+			region1 = sum
+		}
+		if b == g.regionCount[0]+g.regionCount[1]+1 {
+			region2 = sum
+		}
+	}
+
+	i := 0
+	defer func() {
+		clear(values[i:])
+	}()
+	for ; i < g.bigValues*2; i += 2 {
+		selected := g.tableSelect[2]
+		if i < region1 {
+			selected = g.tableSelect[0]
+		} else if i < region2 {
+			selected = g.tableSelect[1]
+		}
+		table := &mp3HuffmanTables[selected]
+		if table.lengths == nil {
+			values[i], values[i+1] = 0, 0
+			continue
+		}
+		v, ok := mp3HuffmanTrees[selected].decode(br)
+		if !ok {
+			return
+		}
+		x, y := v/table.size, v%table.size
+		if table.linbits > 0 && x == 15 {
+			x += int(br.bits(table.linbits))
+		}
+		if x != 0 && br.bit() == 1 {
+			x = -x
+		}
+		if table.linbits > 0 && y == 15 {
+			y += int(br.bits(table.linbits))
+		}
+		if y != 0 && br.bit() == 1 {
+			y = -y
+		}
+		values[i], values[i+1] = x, y
+	}
+
+	tree := mp3HuffmanTrees[32+g.count1Table]
+	for i+4 <= 576 && br.pos < end {
+		v, ok := tree.decode(br)
+		if !ok {
+			return
+		}
+		quad := [4]int{v >> 3 & 1, v >> 2 & 1, v >> 1 & 1, v & 1}
+		for k := range quad {
+			if quad[k] != 0 && br.bit() == 1 {
+				quad[k] = -1
+			}
+		}
+		if br.pos > end {
+			return // the last quadruple overran the granule and is dropped
+		}
+		copy(values[i:], quad[:])
+		i += 4
+	}
+}
+
+// |x|^(4/3) for all possible quantized values
+var mp3Pow43 [8207]float32
+
+// 2^(i/4)
+var mp3QuarterPowers = [4]float64{1, math.Pow(2, 0.25), math.Pow(2, 0.5), math.Pow(2, 0.75)}
+
+func init() {
+	for i := range mp3Pow43 {
+		mp3Pow43[i] = float32(math.Pow(float64(i), 4.0/3.0))
+	}
+}
+
+func requantizeMP3(values *[576]int, g *mp3GranuleInfo, layout *mp3BandLayout, scalefactors *[39]int, xr *[576]float32) {
+	i := 0
+	shift := 1 + g.scalefacScale
+	for b, width := range layout.widths {
+		// Exponent in quarter powers of 2
+		q := g.globalGain - 210
+		if b < layout.long {
+			scalefactor := scalefactors[b]
+			if g.preflag {
+				scalefactor += mp3Pretab[b]
+			}
+			q -= scalefactor << shift
+		} else {
+			q -= 8*g.subblockGain[(b-layout.long)%3] + scalefactors[b]<<shift
+		}
+		gain := float32(math.Ldexp(mp3QuarterPowers[q&3], q>>2))
+		for end := i + width; i < end; i++ {
+			v := values[i]
+			switch {
+			case v == 0:
+				xr[i] = 0
+			case v > 0:
+				xr[i] = mp3Pow43[min(v, len(mp3Pow43)-1)] * gain
+			default:
+				xr[i] = -mp3Pow43[min(-v, len(mp3Pow43)-1)] * gain
+			}
+		}
+	}
+}
+
+// Intensity stereo ratios of MPEG-1 by position, tan(pos*pi/12) split into a
+// left and right factor
+var mp3IntensityRatios [7][2]float32
+
+func init() {
+	for pos := range mp3IntensityRatios {
+		if pos == 6 {
+			mp3IntensityRatios[pos] = [2]float32{1, 0}
+			continue
+		}
+		ratio := math.Tan(float64(pos) * math.Pi / 12)
+		mp3IntensityRatios[pos] = [2]float32{float32(ratio / (1 + ratio)), float32(1 / (1 + ratio))}
+	}
+}
+
+// mp3Stereo applies middle/side and intensity stereo. Intensity stereo is
+// used for the bands above the highest non zero band of the right channel
+// (per window for short blocks), g and layout are those of the right channel.
+func mp3Stereo(h mp3Header, g *mp3GranuleInfo, layout *mp3BandLayout, isPos *[39]int, left *[576]float32, right *[576]float32) {
+	ms := h.modeExtension&2 != 0
+	if h.modeExtension&1 == 0 {
+		if ms {
+			for i := range left {
+				left[i], right[i] = (left[i]+right[i])*math.Sqrt2/2, (left[i]-right[i])*math.Sqrt2/2
+			}
+		}
+		return
+	}
+
+	bands := len(layout.widths)
+	windows := 1
+	if layout.long < bands {
+		windows = 3
+	}
+	maxBand := [3]int{-1, -1, -1}
+	i := 0
+	for b, width := range layout.widths {
+		for k := i; k < i+width; k++ {
+			if right[k] != 0 {
+				maxBand[b%3] = b
+				break
+			}
+		}
+		i += width
+	}
+	if layout.long > 0 {
+		highest := max(maxBand[0], maxBand[1], maxBand[2])
+		maxBand = [3]int{highest, highest, highest}
+	}
+
+	// The top band has no scalefactor and continues the band below
+	defaultPos, maxPos := 0, math.MaxInt
+	if !h.lsf() {
+		defaultPos, maxPos = 3, 7
+	}
+	for w := 0; w < windows; w++ {
+		top := bands - windows + w
+		if previous := top - windows; maxBand[w] >= previous {
+			isPos[top] = defaultPos
+		} else {
+			isPos[top] = isPos[previous]
+		}
+	}
+
+	i = 0
+	for b, width := range layout.widths {
+		pos := isPos[b]
+		switch {
+		case b > maxBand[b%3] && pos >= 0 && pos < maxPos:
+			var kl, kr float32
+			if h.lsf() {
+				f := float32(math.Pow(2, -float64((pos+1)>>1<<(g.scalefacCompress&1))/4))
+				kl, kr = 1, f
+				if pos&1 == 1 {
+					kl, kr = f, 1
+				}
+			} else {
+				kl, kr = mp3IntensityRatios[pos][0], mp3IntensityRatios[pos][1]
+			}
+			for k := i; k < i+width; k++ {
+				left[k], right[k] = left[k]*kl, left[k]*kr
+			}
+		case ms:
+			for k := i; k < i+width; k++ {
+				left[k], right[k] = (left[k]+right[k])*math.Sqrt2/2, (left[k]-right[k])*math.Sqrt2/2
+			}
+		}
+		i += width
+	}
+}
+
+// IMDCT cosine tables, windows by block type and antialias butterflies
+var (
+	mp3IMDCTLong  [36][18]float32
+	mp3IMDCTShort [12][6]float32
+	mp3Windows    [4][36]float32
+	mp3AliasCS    [8]float32
+	mp3AliasCA    [8]float32
+)
+
+func init() {
+	for i := 0; i < 36; i++ {
+		for k := 0; k < 18; k++ {
+			mp3IMDCTLong[i][k] = float32(math.Cos(math.Pi / 72 * float64((2*i+1+18)*(2*k+1))))
+		}
+	}
+	for i := 0; i < 12; i++ {
+		for k := 0; k < 6; k++ {
+			mp3IMDCTShort[i][k] = float32(math.Cos(math.Pi / 24 * float64((2*i+1+6)*(2*k+1))))
+		}
+	}
+
+	sine := func(n int, i int) float32 {
+		return float32(math.Sin(math.Pi / float64(n) * (float64(i) + 0.5)))
+	}
+	for i := 0; i < 36; i++ {
+		mp3Windows[0][i] = sine(36, i)
+	}
+	for i := 0; i < 18; i++ {
+		mp3Windows[1][i] = sine(36, i)
+		mp3Windows[3][i+18] = sine(36, i+18)
+	}
+	for i := 18; i < 24; i++ {
+		mp3Windows[1][i] = 1
+		mp3Windows[3][i-6] = 1
+	}
+	for i := 24; i < 30; i++ {
+		mp3Windows[1][i] = sine(12, i-18)
+		mp3Windows[3][i-18] = sine(12, i-24)
+	}
+	for i := 0; i < 12; i++ {
+		mp3Windows[2][i] = sine(12, i)
+	}
+
+	ci := [8]float64{-0.6, -0.535, -0.33, -0.185, -0.095, -0.041, -0.0142, -0.0037}
+	for i, c := range ci {
+		mp3AliasCS[i] = float32(1 / math.Sqrt(1+c*c))
+		mp3AliasCA[i] = float32(c / math.Sqrt(1+c*c))
+	}
+}
+
+// synthesize turns the requantized frequency lines of a granule in xr into
+// PCM samples: reordering of short blocks, alias reduction, IMDCT with
+// overlap-add, frequency inversion and the polyphase filterbank.
+func (c *mp3Channel) synthesize(g *mp3GranuleInfo, layout *mp3BandLayout, xr *[576]float32) {
+	kind := g.kind()
+	if kind != mp3BlockLong {
+		first := 0
+		for _, width := range layout.widths[:layout.long] {
+			first += width
+		}
+		var reordered [576]float32
+		for b, start := layout.long, first; b < len(layout.widths); b += 3 {
+			width := layout.widths[b]
+			for w := 0; w < 3; w++ {
+				for j := 0; j < width; j++ {
+					reordered[start+3*j+w] = xr[start+w*width+j]
+				}
+			}
+			start += 3 * width
+		}
+		copy(xr[first:], reordered[first:])
+	}
+
+	subbands := 32
+	switch kind {
+	case mp3BlockShort:
+		subbands = 0
+	case mp3BlockMixed:
+		subbands = 2
+	}
+	for sb := 1; sb < subbands; sb++ {
+		for i := 0; i < 8; i++ {
+			lo, hi := xr[18*sb-1-i], xr[18*sb+i]
+			xr[18*sb-1-i] = lo*mp3AliasCS[i] - hi*mp3AliasCA[i]
+			xr[18*sb+i] = hi*mp3AliasCS[i] + lo*mp3AliasCA[i]
+		}
+	}
+
+	var samples [576]float32 // by subband and time slot
+	for sb := 0; sb < 32; sb++ {
+		var raw [36]float32
+		in := xr[sb*18 : sb*18+18]
+		blockType := g.blockType
+		if !g.windowSwitching || (g.mixedBlock && sb < 2) {
+			blockType = 0
+		}
+		if blockType == 2 {
+			for w := 0; w < 3; w++ {
+				for i := 0; i < 12; i++ {
+					var sum float32
+					for k := 0; k < 6; k++ {
+						sum += in[3*k+w] * mp3IMDCTShort[i][k]
+					}
+					raw[6+6*w+i] += sum * mp3Windows[2][i]
+				}
+			}
+		} else {
+			for i := 0; i < 36; i++ {
+				var sum float32
+				for k := 0; k < 18; k++ {
+					sum += in[k] * mp3IMDCTLong[i][k]
+				}
+				raw[i] = sum * mp3Windows[blockType][i]
+			}
+		}
+		overlap := c.overlap[sb*18 : sb*18+18]
+		for i := 0; i < 18; i++ {
+			samples[sb*18+i] = raw[i] + overlap[i]
+			overlap[i] = raw[18+i]
+		}
+		if sb%2 == 1 {
+			for i := 1; i < 18; i += 2 {
+				samples[sb*18+i] = -samples[sb*18+i]
+			}
+		}
+	}
+
+	for t := 0; t < 18; t++ {
+		var in [32]float32
+		for sb := range in {
+			in[sb] = samples[sb*18+t]
+		}
+		c.synthesis.filter(&in, xr[t*32:t*32+32])
+	}
+}
+
+// mp3Synthesis is the polyphase synthesis filterbank of a channel.
+type mp3Synthesis struct {
+	v      [1024]float32
+	offset int
+}
+
+var mp3SynthesisD [512]float32
+
+func init() {
+	for i, d := range mp3SynthesisWindow {
+		mp3SynthesisD[i] = float32(d) / 65536
+	}
+}
+
+// filter turns one sample of each of the 32 subbands into 32 PCM samples.
+func (s *mp3Synthesis) filter(in *[32]float32, out []float32) {
+	x := *in
+	var scratch [32]float32
+	dct(x[:], scratch[:])
+
+	// V[i] = sum(cos((16+i)(2k+1)pi/64) * in[k]), expressed by the DCT-II of in
+	s.offset = (s.offset - 64) & 1023
+	v, o := &s.v, s.offset
+	for i := 0; i < 16; i++ {
+		v[(o+i)&1023] = x[i+16]
+	}
+	v[(o+16)&1023] = 0
+	for i := 17; i < 48; i++ {
+		v[(o+i)&1023] = -x[48-i]
+	}
+	for i := 48; i < 64; i++ {
+		v[(o+i)&1023] = -x[i-48]
+	}
+
+	for j := 0; j < 32; j++ {
+		var sum float32
+		for i := 0; i < 8; i++ {
+			sum += mp3SynthesisD[64*i+j] * v[(o+128*i+j)&1023]
+			sum += mp3SynthesisD[64*i+32+j] * v[(o+128*i+96+j)&1023]
+		}
+		out[j] = sum
+	}
+}
+
+// Factors 1/(2cos((2k+1)pi/2n)) of the DCT by log2(n)
+var dctFactors [6][]float32
+
+func init() {
+	for log := 1; log < len(dctFactors); log++ {
+		n := 1 << log
+		dctFactors[log] = make([]float32, n/2)
+		for k := range dctFactors[log] {
+			dctFactors[log][k] = float32(1 / (2 * math.Cos(float64(2*k+1)*math.Pi/float64(2*n))))
+		}
+	}
+}
+
+// dct computes the unscaled DCT-II X[m] = sum(x[k] cos(m(2k+1)pi/2n)) of x in
+// place with Lee's algorithm. len(x) is a power of two up to 32, scratch has
+// the same length.
+func dct(x []float32, scratch []float32) {
+	n := len(x)
+	if n == 1 {
+		return
+	}
+	half := n / 2
+	factors := dctFactors[bits.Len(uint(n))-1]
+	even, odd := scratch[:half], scratch[half:n]
+	for k := 0; k < half; k++ {
+		even[k] = x[k] + x[n-1-k]
+		odd[k] = (x[k] - x[n-1-k]) * factors[k]
+	}
+	dct(even, x[:half])
+	dct(odd, x[half:])
+	for m := 0; m < half; m++ {
+		x[2*m] = even[m]
+	}
+	for m := 0; m < half-1; m++ {
+		x[2*m+1] = odd[m] + odd[m+1]
+	}
+	x[n-1] = odd[half-1]
+}
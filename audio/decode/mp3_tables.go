@@ -0,0 +1,418 @@
+package decode
+
+// Tables of ISO/IEC 11172-3 and ISO/IEC 13818-3 used by the MP3 decoder.
+
+// Scalefactor band boundaries for long and short blocks by sample rate index
+// (see mp3SampleRates)
+var mp3LongBands = [9][23]int{
+	{0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 52, 62, 74, 90, 110, 134, 162, 196, 238, 288, 342, 418, 576},
+	{0, 4, 8, 12, 16, 20, 24, 30, 36, 42, 50, 60, 72, 88, 106, 128, 156, 190, 230, 276, 330, 384, 576},
+	{0, 4, 8, 12, 16, 20, 24, 30, 36, 44, 54, 66, 82, 102, 126, 156, 194, 240, 296, 364, 448, 550, 576},
+	{0, 6, 12, 18, 24, 30, 36, 44, 54, 66, 80, 96, 116, 140, 168, 200, 238, 284, 336, 396, 464, 522, 576},
+	{0, 6, 12, 18, 24, 30, 36, 44, 54, 66, 80, 96, 114, 136, 162, 194, 232, 278, 332, 394, 464, 540, 576},
+	{0, 6, 12, 18, 24, 30, 36, 44, 54, 66, 80, 96, 116, 140, 168, 200, 238, 284, 336, 396, 464, 522, 576},
+	{0, 6, 12, 18, 24, 30, 36, 44, 54, 66, 80, 96, 116, 140, 168, 200, 238, 284, 336, 396, 464, 522, 576},
+	{0, 6, 12, 18, 24, 30, 36, 44, 54, 66, 80, 96, 116, 140, 168, 200, 238, 284, 336, 396, 464, 522, 576},
+	{0, 12, 24, 36, 48, 60, 72, 88, 108, 132, 160, 192, 232, 280, 336, 400, 476, 566, 568, 570, 572, 574, 576},
+}
+
+var mp3ShortBands = [9][14]int{
+	{0, 4, 8, 12, 16, 22, 30, 40, 52, 66, 84, 106, 136, 192},
+	{0, 4, 8, 12, 16, 22, 28, 38, 50, 64, 80, 100, 126, 192},
+	{0, 4, 8, 12, 16, 22, 30, 42, 58, 78, 104, 138, 180, 192},
+	{0, 4, 8, 12, 18, 24, 32, 42, 56, 74, 100, 132, 174, 192},
+	{0, 4, 8, 12, 18, 26, 36, 48, 62, 80, 104, 136, 180, 192},
+	{0, 4, 8, 12, 18, 26, 36, 48, 62, 80, 104, 134, 174, 192},
+	{0, 4, 8, 12, 18, 26, 36, 48, 62, 80, 104, 134, 174, 192},
+	{0, 4, 8, 12, 18, 26, 36, 48, 62, 80, 104, 134, 174, 192},
+	{0, 8, 16, 24, 36, 52, 72, 96, 124, 160, 162, 164, 166, 192},
+}
+
+// Preemphasis of the long scalefactor bands, applied if preflag is set
+var mp3Pretab = [22]int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 3, 3, 3, 2, 0}
+
+// Scalefactor bit lengths of MPEG-1 by scalefac_compress
+var (
+	mp3Slen1 = [16]int{0, 0, 0, 0, 3, 1, 1, 1, 2, 2, 2, 3, 3, 3, 4, 4}
+	mp3Slen2 = [16]int{0, 1, 2, 3, 0, 1, 2, 3, 1, 2, 3, 1, 2, 3, 2, 3}
+)
+
+// Number of scalefactors per partition of MPEG-2 by partition table and block
+// kind (long, short, mixed), ISO/IEC 13818-3 Table B.6
+var mp3LSFScalefactorCounts = [6][3][4]int{
+	{{6, 5, 5, 5}, {9, 9, 9, 9}, {6, 9, 9, 9}},
+	{{6, 5, 7, 3}, {9, 9, 12, 6}, {6, 9, 12, 6}},
+	{{11, 10, 0, 0}, {18, 18, 0, 0}, {15, 18, 0, 0}},
+	{{7, 7, 7, 0}, {12, 12, 12, 0}, {6, 15, 12, 0}},
+	{{6, 6, 6, 3}, {12, 9, 9, 6}, {6, 12, 9, 6}},
+	{{8, 8, 5, 0}, {15, 12, 9, 0}, {6, 18, 9, 0}},
+}
+
+// Synthesis window D[i] of ISO/IEC 11172-3 Table 3-B.3 in units of 2^-16
+var mp3SynthesisWindow = [512]int32{
+	0, -1, -1, -1, -1, -1, -1, -2, -2, -2, -2, -3, -3, -4, -4, -5,
+	-5, -6, -7, -7, -8, -9, -10, -11, -13, -14, -16, -17, -19, -21, -24, -26,
+	-29, -31, -35, -38, -41, -45, -49, -53, -58, -63, -68, -73, -79, -85, -91, -97,
+	-104, -111, -117, -125, -132, -139, -147, -154, -161, -169, -176, -183, -190, -196, -202, -208,
+	213, 218, 222, 225, 227, 228, 228, 227, 224, 221, 215, 208, 200, 189, 177, 163,
+	146, 127, 106, 83, 57, 29, -2, -36, -72, -111, -153, -197, -244, -294, -347, -401,
+	-459, -519, -581, -645, -711, -779, -848, -919, -991, -1064, -1137, -1210, -1283, -1356, -1428, -1498,
+	-1567, -1634, -1698, -1759, -1817, -1870, -1919, -1962, -2001, -2032, -2057, -2075, -2085, -2087, -2080, -2063,
+	2037, 2000, 1952, 1893, 1822, 1739, 1644, 1535, 1414, 1280, 1131, 970, 794, 605, 402, 185,
+	-45, -288, -545, -814, -1095, -1388, -1692, -2006, -2330, -2663, -3004, -3351, -3705, -4063, -4425, -4788,
+	-5153, -5517, -5879, -6237, -6589, -6935, -7271, -7597, -7910, -8209, -8491, -8755, -8998, -9219, -9416, -9585,
+	-9727, -9838, -9916, -9959, -9966, -9935, -9863, -9750, -9592, -9389, -9139, -8840, -8492, -8092, -7640, -7134,
+	6574, 5959, 5288, 4561, 3776, 2935, 2037, 1082, 70, -998, -2122, -3300, -4533, -5818, -7154, -8540,
+	-9975, -11455, -12980, -14548, -16155, -17799, -19478, -21189, -22929, -24694, -26482, -28289, -30112, -31947, -33791, -35640,
+	-37489, -39336, -41176, -43006, -44821, -46617, -48390, -50137, -51853, -53534, -55178, -56778, -58333, -59838, -61289, -62684,
+	-64019, -65290, -66494, -67629, -68692, -69679, -70590, -71420, -72169, -72835, -73415, -73908, -74313, -74630, -74856, -74992,
+	75038, 74992, 74856, 74630, 74313, 73908, 73415, 72835, 72169, 71420, 70590, 69679, 68692, 67629, 66494, 65290,
+	64019, 62684, 61289, 59838, 58333, 56778, 55178, 53534, 51853, 50137, 48390, 46617, 44821, 43006, 41176, 39336,
+	37489, 35640, 33791, 31947, 30112, 28289, 26482, 24694, 22929, 21189, 19478, 17799, 16155, 14548, 12980, 11455,
+	9975, 8540, 7154, 5818, 4533, 3300, 2122, 998, -70, -1082, -2037, -2935, -3776, -4561, -5288, -5959,
+	6574, 7134, 7640, 8092, 8492, 8840, 9139, 9389, 9592, 9750, 9863, 9935, 9966, 9959, 9916, 9838,
+	9727, 9585, 9416, 9219, 8998, 8755, 8491, 8209, 7910, 7597, 7271, 6935, 6589, 6237, 5879, 5517,
+	5153, 4788, 4425, 4063, 3705, 3351, 3004, 2663, 2330, 2006, 1692, 1388, 1095, 814, 545, 288,
+	45, -185, -402, -605, -794, -970, -1131, -1280, -1414, -1535, -1644, -1739, -1822, -1893, -1952, -2000,
+	2037, 2063, 2080, 2087, 2085, 2075, 2057, 2032, 2001, 1962, 1919, 1870, 1817, 1759, 1698, 1634,
+	1567, 1498, 1428, 1356, 1283, 1210, 1137, 1064, 991, 919, 848, 779, 711, 645, 581, 519,
+	459, 401, 347, 294, 244, 197, 153, 111, 72, 36, 2, -29, -57, -83, -106, -127,
+	-146, -163, -177, -189, -200, -208, -215, -221, -224, -227, -228, -228, -227, -225, -222, -218,
+	213, 208, 202, 196, 190, 183, 176, 169, 161, 154, 147, 139, 132, 125, 117, 111,
+	104, 97, 91, 85, 79, 73, 68, 63, 58, 53, 49, 45, 41, 38, 35, 31,
+	29, 26, 24, 21, 19, 17, 16, 14, 13, 11, 10, 9, 8, 7, 7, 6,
+	5, 5, 4, 4, 3, 3, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1,
+}
+
+// huffmanTable is one of the Huffman code tables of ISO/IEC 11172-3 Table
+// 3-B.7. Codes and their lengths are indexed by x*size+y for the big value
+// tables and by the value vwxy for the count1 tables 32 and 33.
+type huffmanTable struct {
+	size    int
+	linbits int
+	lengths []uint8
+	codes   []uint16
+}
+
+var huffman1Lengths = []uint8{
+	1, 3, 2, 3,
+}
+
+var huffman1Codes = []uint16{
+	0x1, 0x1, 0x1, 0x0,
+}
+
+var huffman2Lengths = []uint8{
+	1, 3, 6, 3, 3, 5, 5, 5, 6,
+}
+
+var huffman2Codes = []uint16{
+	0x1, 0x2, 0x1, 0x3, 0x1, 0x1, 0x3, 0x2, 0x0,
+}
+
+var huffman3Lengths = []uint8{
+	2, 2, 6, 3, 2, 5, 5, 5, 6,
+}
+
+var huffman3Codes = []uint16{
+	0x3, 0x2, 0x1, 0x1, 0x1, 0x1, 0x3, 0x2, 0x0,
+}
+
+var huffman5Lengths = []uint8{
+	1, 3, 6, 7, 3, 3, 6, 7, 6, 6, 7, 8, 7, 6, 7, 8,
+}
+
+var huffman5Codes = []uint16{
+	0x1, 0x2, 0x6, 0x5, 0x3, 0x1, 0x4, 0x4, 0x7, 0x5, 0x7, 0x1, 0x6, 0x1, 0x1, 0x0,
+}
+
+var huffman6Lengths = []uint8{
+	3, 3, 5, 7, 3, 2, 4, 5, 4, 4, 5, 6, 6, 5, 6, 7,
+}
+
+var huffman6Codes = []uint16{
+	0x7, 0x3, 0x5, 0x1, 0x6, 0x2, 0x3, 0x2, 0x5, 0x4, 0x4, 0x1, 0x3, 0x3, 0x2, 0x0,
+}
+
+var huffman7Lengths = []uint8{
+	1, 3, 6, 8, 8, 9, 3, 4, 6, 7, 7, 8, 6, 5, 7, 8,
+	8, 9, 7, 7, 8, 9, 9, 9, 7, 7, 8, 9, 9, 10, 8, 8,
+	9, 10, 10, 10,
+}
+
+var huffman7Codes = []uint16{
+	0x1, 0x2, 0xa, 0x13, 0x10, 0xa, 0x3, 0x3, 0x7, 0xa, 0x5, 0x3, 0xb, 0x4, 0xd, 0x11,
+	0x8, 0x4, 0xc, 0xb, 0x12, 0xf, 0xb, 0x2, 0x7, 0x6, 0x9, 0xe, 0x3, 0x1, 0x6, 0x4,
+	0x5, 0x3, 0x2, 0x0,
+}
+
+var huffman8Lengths = []uint8{
+	2, 3, 6, 8, 8, 9, 3, 2, 4, 8, 8, 8, 6, 4, 6, 8,
+	8, 9, 8, 8, 8, 9, 9, 10, 8, 7, 8, 9, 10, 10, 9, 8,
+	9, 9, 11, 11,
+}
+
+var huffman8Codes = []uint16{
+	0x3, 0x4, 0x6, 0x12, 0xc, 0x5, 0x5, 0x1, 0x2, 0x10, 0x9, 0x3, 0x7, 0x3, 0x5, 0xe,
+	0x7, 0x3, 0x13, 0x11, 0xf, 0xd, 0xa, 0x4, 0xd, 0x5, 0x8, 0xb, 0x5, 0x1, 0xc, 0x4,
+	0x4, 0x1, 0x1, 0x0,
+}
+
+var huffman9Lengths = []uint8{
+	3, 3, 5, 6, 8, 9, 3, 3, 4, 5, 6, 8, 4, 4, 5, 6,
+	7, 8, 6, 5, 6, 7, 7, 8, 7, 6, 7, 7, 8, 9, 8, 7,
+	8, 8, 9, 9,
+}
+
+var huffman9Codes = []uint16{
+	0x7, 0x5, 0x9, 0xe, 0xf, 0x7, 0x6, 0x4, 0x5, 0x5, 0x6, 0x7, 0x7, 0x6, 0x8, 0x8,
+	0x8, 0x5, 0xf, 0x6, 0x9, 0xa, 0x5, 0x1, 0xb, 0x7, 0x9, 0x6, 0x4, 0x1, 0xe, 0x4,
+	0x6, 0x2, 0x6, 0x0,
+}
+
+var huffman10Lengths = []uint8{
+	1, 3, 6, 8, 9, 9, 9, 10, 3, 4, 6, 7, 8, 9, 8, 8,
+	6, 6, 7, 8, 9, 10, 9, 9, 7, 7, 8, 9, 10, 10, 9, 10,
+	8, 8, 9, 10, 10, 10, 10, 10, 9, 9, 10, 10, 11, 11, 10, 11,
+	8, 8, 9, 10, 10, 10, 11, 11, 9, 8, 9, 10, 10, 11, 11, 11,
+}
+
+var huffman10Codes = []uint16{
+	0x1, 0x2, 0xa, 0x17, 0x23, 0x1e, 0xc, 0x11, 0x3, 0x3, 0x8, 0xc, 0x12, 0x15, 0xc, 0x7,
+	0xb, 0x9, 0xf, 0x15, 0x20, 0x28, 0x13, 0x6, 0xe, 0xd, 0x16, 0x22, 0x2e, 0x17, 0x12, 0x7,
+	0x14, 0x13, 0x21, 0x2f, 0x1b, 0x16, 0x9, 0x3, 0x1f, 0x16, 0x29, 0x1a, 0x15, 0x14, 0x5, 0x3,
+	0xe, 0xd, 0xa, 0xb, 0x10, 0x6, 0x5, 0x1, 0x9, 0x8, 0x7, 0x8, 0x4, 0x4, 0x2, 0x0,
+}
+
+var huffman11Lengths = []uint8{
+	2, 3, 5, 7, 8, 9, 8, 9, 3, 3, 4, 6, 8, 8, 7, 8,
+	5, 5, 6, 7, 8, 9, 8, 8, 7, 6, 7, 9, 8, 10, 8, 9,
+	8, 8, 8, 9, 9, 10, 9, 10, 8, 8, 9, 10, 10, 11, 10, 11,
+	8, 7, 7, 8, 9, 10, 10, 10, 8, 7, 8, 9, 10, 10, 10, 10,
+}
+
+var huffman11Codes = []uint16{
+	0x3, 0x4, 0xa, 0x18, 0x22, 0x21, 0x15, 0xf, 0x5, 0x3, 0x4, 0xa, 0x20, 0x11, 0xb, 0xa,
+	0xb, 0x7, 0xd, 0x12, 0x1e, 0x1f, 0x14, 0x5, 0x19, 0xb, 0x13, 0x3b, 0x1b, 0x12, 0xc, 0x5,
+	0x23, 0x21, 0x1f, 0x3a, 0x1e, 0x10, 0x7, 0x5, 0x1c, 0x1a, 0x20, 0x13, 0x11, 0xf, 0x8, 0xe,
+	0xe, 0xc, 0x9, 0xd, 0xe, 0x9, 0x4, 0x1, 0xb, 0x4, 0x6, 0x6, 0x6, 0x3, 0x2, 0x0,
+}
+
+var huffman12Lengths = []uint8{
+	4, 3, 5, 7, 8, 9, 9, 9, 3, 3, 4, 5, 7, 7, 8, 8,
+	5, 4, 5, 6, 7, 8, 7, 8, 6, 5, 6, 6, 7, 8, 8, 8,
+	7, 6, 7, 7, 8, 8, 8, 9, 8, 7, 8, 8, 8, 9, 8, 9,
+	8, 7, 7, 8, 8, 9, 9, 10, 9, 8, 8, 9, 9, 9, 9, 10,
+}
+
+var huffman12Codes = []uint16{
+	0x9, 0x6, 0x10, 0x21, 0x29, 0x27, 0x26, 0x1a, 0x7, 0x5, 0x6, 0x9, 0x17, 0x10, 0x1a, 0xb,
+	0x11, 0x7, 0xb, 0xe, 0x15, 0x1e, 0xa, 0x7, 0x11, 0xa, 0xf, 0xc, 0x12, 0x1c, 0xe, 0x5,
+	0x20, 0xd, 0x16, 0x13, 0x12, 0x10, 0x9, 0x5, 0x28, 0x11, 0x1f, 0x1d, 0x11, 0xd, 0x4, 0x2,
+	0x1b, 0xc, 0xb, 0xf, 0xa, 0x7, 0x4, 0x1, 0x1b, 0xc, 0x8, 0xc, 0x6, 0x3, 0x1, 0x0,
+}
+
+var huffman13Lengths = []uint8{
+	1, 4, 6, 7, 8, 9, 9, 10, 9, 10, 11, 11, 12, 12, 13, 13,
+	3, 4, 6, 7, 8, 8, 9, 9, 9, 9, 10, 10, 11, 12, 12, 12,
+	6, 6, 7, 8, 9, 9, 10, 10, 9, 10, 10, 11, 11, 12, 13, 13,
+	7, 7, 8, 9, 9, 10, 10, 10, 10, 11, 11, 11, 11, 12, 13, 13,
+	8, 7, 9, 9, 10, 10, 11, 11, 10, 11, 11, 12, 12, 13, 13, 14,
+	9, 8, 9, 10, 10, 10, 11, 11, 11, 11, 12, 11, 13, 13, 14, 14,
+	9, 9, 10, 10, 11, 11, 11, 11, 11, 12, 12, 12, 13, 13, 14, 14,
+	10, 9, 10, 11, 11, 11, 12, 12, 12, 12, 13, 13, 13, 14, 16, 16,
+	9, 8, 9, 10, 10, 11, 11, 12, 12, 12, 12, 13, 13, 14, 15, 15,
+	10, 9, 10, 10, 11, 11, 11, 13, 12, 13, 13, 14, 14, 14, 16, 15,
+	10, 10, 10, 11, 11, 12, 12, 13, 12, 13, 14, 13, 14, 15, 16, 17,
+	11, 10, 10, 11, 12, 12, 12, 12, 13, 13, 13, 14, 15, 15, 15, 16,
+	11, 11, 11, 12, 12, 13, 12, 13, 14, 14, 15, 15, 15, 16, 16, 16,
+	12, 11, 12, 13, 13, 13, 14, 14, 14, 14, 14, 15, 16, 15, 16, 16,
+	13, 12, 12, 13, 13, 13, 15, 14, 14, 17, 15, 15, 15, 17, 16, 16,
+	12, 12, 13, 14, 14, 14, 15, 14, 15, 15, 16, 16, 19, 18, 19, 16,
+}
+
+var huffman13Codes = []uint16{
+	0x1, 0x5, 0xe, 0x15, 0x22, 0x33, 0x2e, 0x47, 0x2a, 0x34, 0x44, 0x34, 0x43, 0x2c, 0x2b, 0x13,
+	0x3, 0x4, 0xc, 0x13, 0x1f, 0x1a, 0x2c, 0x21, 0x1f, 0x18, 0x20, 0x18, 0x1f, 0x23, 0x16, 0xe,
+	0xf, 0xd, 0x17, 0x24, 0x3b, 0x31, 0x4d, 0x41, 0x1d, 0x28, 0x1e, 0x28, 0x1b, 0x21, 0x2a, 0x10,
+	0x16, 0x14, 0x25, 0x3d, 0x38, 0x4f, 0x49, 0x40, 0x2b, 0x4c, 0x38, 0x25, 0x1a, 0x1f, 0x19, 0xe,
+	0x23, 0x10, 0x3c, 0x39, 0x61, 0x4b, 0x72, 0x5b, 0x36, 0x49, 0x37, 0x29, 0x30, 0x35, 0x17, 0x18,
+	0x3a, 0x1b, 0x32, 0x60, 0x4c, 0x46, 0x5d, 0x54, 0x4d, 0x3a, 0x4f, 0x1d, 0x4a, 0x31, 0x29, 0x11,
+	0x2f, 0x2d, 0x4e, 0x4a, 0x73, 0x5e, 0x5a, 0x4f, 0x45, 0x53, 0x47, 0x32, 0x3b, 0x26, 0x24, 0xf,
+	0x48, 0x22, 0x38, 0x5f, 0x5c, 0x55, 0x5b, 0x5a, 0x56, 0x49, 0x4d, 0x41, 0x33, 0x2c, 0x2b, 0x2a,
+	0x2b, 0x14, 0x1e, 0x2c, 0x37, 0x4e, 0x48, 0x57, 0x4e, 0x3d, 0x2e, 0x36, 0x25, 0x1e, 0x14, 0x10,
+	0x35, 0x19, 0x29, 0x25, 0x2c, 0x3b, 0x36, 0x51, 0x42, 0x4c, 0x39, 0x36, 0x25, 0x12, 0x27, 0xb,
+	0x23, 0x21, 0x1f, 0x39, 0x2a, 0x52, 0x48, 0x50, 0x2f, 0x3a, 0x37, 0x15, 0x16, 0x1a, 0x26, 0x16,
+	0x35, 0x19, 0x17, 0x26, 0x46, 0x3c, 0x33, 0x24, 0x37, 0x1a, 0x22, 0x17, 0x1b, 0xe, 0x9, 0x7,
+	0x22, 0x20, 0x1c, 0x27, 0x31, 0x4b, 0x1e, 0x34, 0x30, 0x28, 0x34, 0x1c, 0x12, 0x11, 0x9, 0x5,
+	0x2d, 0x15, 0x22, 0x40, 0x38, 0x32, 0x31, 0x2d, 0x1f, 0x13, 0xc, 0xf, 0xa, 0x7, 0x6, 0x3,
+	0x30, 0x17, 0x14, 0x27, 0x24, 0x23, 0x35, 0x15, 0x10, 0x17, 0xd, 0xa, 0x6, 0x1, 0x4, 0x2,
+	0x10, 0xf, 0x11, 0x1b, 0x19, 0x14, 0x1d, 0xb, 0x11, 0xc, 0x10, 0x8, 0x1, 0x1, 0x0, 0x1,
+}
+
+var huffman15Lengths = []uint8{
+	3, 4, 5, 7, 7, 8, 9, 9, 9, 10, 10, 11, 11, 11, 12, 13,
+	4, 3, 5, 6, 7, 7, 8, 8, 8, 9, 9, 10, 10, 10, 11, 11,
+	5, 5, 5, 6, 7, 7, 8, 8, 8, 9, 9, 10, 10, 11, 11, 11,
+	6, 6, 6, 7, 7, 8, 8, 9, 9, 9, 10, 10, 10, 11, 11, 11,
+	7, 6, 7, 7, 8, 8, 9, 9, 9, 9, 10, 10, 10, 11, 11, 11,
+	8, 7, 7, 8, 8, 8, 9, 9, 9, 9, 10, 10, 11, 11, 11, 12,
+	9, 7, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 11, 11, 12, 12,
+	9, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 10, 11, 11, 11, 12,
+	9, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 11, 11, 12, 12, 12,
+	9, 8, 9, 9, 9, 9, 10, 10, 10, 11, 11, 11, 11, 12, 12, 12,
+	10, 9, 9, 9, 10, 10, 10, 10, 10, 11, 11, 11, 11, 12, 13, 12,
+	10, 9, 9, 9, 10, 10, 10, 10, 11, 11, 11, 11, 12, 12, 12, 13,
+	11, 10, 9, 10, 10, 10, 11, 11, 11, 11, 11, 11, 12, 12, 13, 13,
+	11, 10, 10, 10, 10, 11, 11, 11, 11, 12, 12, 12, 12, 12, 13, 13,
+	12, 11, 11, 11, 11, 11, 11, 11, 12, 12, 12, 12, 13, 13, 12, 13,
+	12, 11, 11, 11, 11, 11, 11, 12, 12, 12, 12, 12, 13, 13, 13, 13,
+}
+
+var huffman15Codes = []uint16{
+	0x7, 0xc, 0x12, 0x35, 0x2f, 0x4c, 0x7c, 0x6c, 0x59, 0x7b, 0x6c, 0x77, 0x6b, 0x51, 0x7a, 0x3f,
+	0xd, 0x5, 0x10, 0x1b, 0x2e, 0x24, 0x3d, 0x33, 0x2a, 0x46, 0x34, 0x53, 0x41, 0x29, 0x3b, 0x24,
+	0x13, 0x11, 0xf, 0x18, 0x29, 0x22, 0x3b, 0x30, 0x28, 0x40, 0x32, 0x4e, 0x3e, 0x50, 0x38, 0x21,
+	0x1d, 0x1c, 0x19, 0x2b, 0x27, 0x3f, 0x37, 0x5d, 0x4c, 0x3b, 0x5d, 0x48, 0x36, 0x4b, 0x32, 0x1d,
+	0x34, 0x16, 0x2a, 0x28, 0x43, 0x39, 0x5f, 0x4f, 0x48, 0x39, 0x59, 0x45, 0x31, 0x42, 0x2e, 0x1b,
+	0x4d, 0x25, 0x23, 0x42, 0x3a, 0x34, 0x5b, 0x4a, 0x3e, 0x30, 0x4f, 0x3f, 0x5a, 0x3e, 0x28, 0x26,
+	0x7d, 0x20, 0x3c, 0x38, 0x32, 0x5c, 0x4e, 0x41, 0x37, 0x57, 0x47, 0x33, 0x49, 0x33, 0x46, 0x1e,
+	0x6d, 0x35, 0x31, 0x5e, 0x58, 0x4b, 0x42, 0x7a, 0x5b, 0x49, 0x38, 0x2a, 0x40, 0x2c, 0x15, 0x19,
+	0x5a, 0x2b, 0x29, 0x4d, 0x49, 0x3f, 0x38, 0x5c, 0x4d, 0x42, 0x2f, 0x43, 0x30, 0x35, 0x24, 0x14,
+	0x47, 0x22, 0x43, 0x3c, 0x3a, 0x31, 0x58, 0x4c, 0x43, 0x6a, 0x47, 0x36, 0x26, 0x27, 0x17, 0xf,
+	0x6d, 0x35, 0x33, 0x2f, 0x5a, 0x52, 0x3a, 0x39, 0x30, 0x48, 0x39, 0x29, 0x17, 0x1b, 0x3e, 0x9,
+	0x56, 0x2a, 0x28, 0x25, 0x46, 0x40, 0x34, 0x2b, 0x46, 0x37, 0x2a, 0x19, 0x1d, 0x12, 0xb, 0xb,
+	0x76, 0x44, 0x1e, 0x37, 0x32, 0x2e, 0x4a, 0x41, 0x31, 0x27, 0x18, 0x10, 0x16, 0xd, 0xe, 0x7,
+	0x5b, 0x2c, 0x27, 0x26, 0x22, 0x3f, 0x34, 0x2d, 0x1f, 0x34, 0x1c, 0x13, 0xe, 0x8, 0x9, 0x3,
+	0x7b, 0x3c, 0x3a, 0x35, 0x2f, 0x2b, 0x20, 0x16, 0x25, 0x18, 0x11, 0xc, 0xf, 0xa, 0x2, 0x1,
+	0x47, 0x25, 0x22, 0x1e, 0x1c, 0x14, 0x11, 0x1a, 0x15, 0x10, 0xa, 0x6, 0x8, 0x6, 0x2, 0x0,
+}
+
+var huffman16Lengths = []uint8{
+	1, 4, 6, 8, 9, 9, 10, 10, 11, 11, 11, 12, 12, 12, 13, 9,
+	3, 4, 6, 7, 8, 9, 9, 9, 10, 10, 10, 11, 12, 11, 12, 8,
+	6, 6, 7, 8, 9, 9, 10, 10, 11, 10, 11, 11, 11, 12, 12, 9,
+	8, 7, 8, 9, 9, 10, 10, 10, 11, 11, 12, 12, 12, 13, 13, 10,
+	9, 8, 9, 9, 10, 10, 11, 11, 11, 12, 12, 12, 13, 13, 13, 9,
+	9, 8, 9, 9, 10, 11, 11, 12, 11, 12, 12, 13, 13, 13, 14, 10,
+	10, 9, 9, 10, 11, 11, 11, 11, 12, 12, 12, 12, 13, 13, 14, 10,
+	10, 9, 10, 10, 11, 11, 11, 12, 12, 13, 13, 13, 13, 15, 15, 10,
+	10, 10, 10, 11, 11, 11, 12, 12, 13, 13, 13, 13, 14, 14, 14, 10,
+	11, 10, 10, 11, 11, 12, 12, 13, 13, 13, 13, 14, 13, 14, 13, 11,
+	11, 11, 10, 11, 12, 12, 12, 12, 13, 14, 14, 14, 15, 15, 14, 10,
+	12, 11, 11, 11, 12, 12, 13, 14, 14, 14, 14, 14, 14, 13, 14, 11,
+	12, 12, 12, 12, 12, 13, 13, 13, 13, 15, 14, 14, 14, 14, 16, 11,
+	14, 12, 12, 12, 13, 13, 14, 14, 14, 16, 15, 15, 15, 17, 15, 11,
+	13, 13, 11, 12, 14, 14, 13, 14, 14, 15, 16, 15, 17, 15, 14, 11,
+	9, 8, 8, 9, 9, 10, 10, 10, 11, 11, 11, 11, 11, 11, 11, 8,
+}
+
+var huffman16Codes = []uint16{
+	0x1, 0x5, 0xe, 0x2c, 0x4a, 0x3f, 0x6e, 0x5d, 0xac, 0x95, 0x8a, 0xf2, 0xe1, 0xc3, 0x178, 0x11,
+	0x3, 0x4, 0xc, 0x14, 0x23, 0x3e, 0x35, 0x2f, 0x53, 0x4b, 0x44, 0x77, 0xc9, 0x6b, 0xcf, 0x9,
+	0xf, 0xd, 0x17, 0x26, 0x43, 0x3a, 0x67, 0x5a, 0xa1, 0x48, 0x7f, 0x75, 0x6e, 0xd1, 0xce, 0x10,
+	0x2d, 0x15, 0x27, 0x45, 0x40, 0x72, 0x63, 0x57, 0x9e, 0x8c, 0xfc, 0xd4, 0xc7, 0x183, 0x16d, 0x1a,
+	0x4b, 0x24, 0x44, 0x41, 0x73, 0x65, 0xb3, 0xa4, 0x9b, 0x108, 0xf6, 0xe2, 0x18b, 0x17e, 0x16a, 0x9,
+	0x42, 0x1e, 0x3b, 0x38, 0x66, 0xb9, 0xad, 0x109, 0x8e, 0xfd, 0xe8, 0x190, 0x184, 0x17a, 0x1bd, 0x10,
+	0x6f, 0x36, 0x34, 0x64, 0xb8, 0xb2, 0xa0, 0x85, 0x101, 0xf4, 0xe4, 0xd9, 0x181, 0x16e, 0x2cb, 0xa,
+	0x62, 0x30, 0x5b, 0x58, 0xa5, 0x9d, 0x94, 0x105, 0xf8, 0x197, 0x18d, 0x174, 0x17c, 0x379, 0x374, 0x8,
+	0x55, 0x54, 0x51, 0x9f, 0x9c, 0x8f, 0x104, 0xf9, 0x1ab, 0x191, 0x188, 0x17f, 0x2d7, 0x2c9, 0x2c4, 0x7,
+	0x9a, 0x4c, 0x49, 0x8d, 0x83, 0x100, 0xf5, 0x1aa, 0x196, 0x18a, 0x180, 0x2df, 0x167, 0x2c6, 0x160, 0xb,
+	0x8b, 0x81, 0x43, 0x7d, 0xf7, 0xe9, 0xe5, 0xdb, 0x189, 0x2e7, 0x2e1, 0x2d0, 0x375, 0x372, 0x1b7, 0x4,
+	0xf3, 0x78, 0x76, 0x73, 0xe3, 0xdf, 0x18c, 0x2ea, 0x2e6, 0x2e0, 0x2d1, 0x2c8, 0x2c2, 0xdf, 0x1b4, 0x6,
+	0xca, 0xe0, 0xde, 0xda, 0xd8, 0x185, 0x182, 0x17d, 0x16c, 0x378, 0x1bb, 0x2c3, 0x1b8, 0x1b5, 0x6c0, 0x4,
+	0x2eb, 0xd3, 0xd2, 0xd0, 0x172, 0x17b, 0x2de, 0x2d3, 0x2ca, 0x6c7, 0x373, 0x36d, 0x36c, 0xd83, 0x361, 0x2,
+	0x179, 0x171, 0x66, 0xbb, 0x2d6, 0x2d2, 0x166, 0x2c7, 0x2c5, 0x362, 0x6c6, 0x367, 0xd82, 0x366, 0x1b2, 0x0,
+	0xc, 0xa, 0x7, 0xb, 0xa, 0x11, 0xb, 0x9, 0xd, 0xc, 0xa, 0x7, 0x5, 0x3, 0x1, 0x3,
+}
+
+var huffman24Lengths = []uint8{
+	4, 4, 6, 7, 8, 9, 9, 10, 10, 11, 11, 11, 11, 11, 12, 9,
+	4, 4, 5, 6, 7, 8, 8, 9, 9, 9, 10, 10, 10, 10, 10, 8,
+	6, 5, 6, 7, 7, 8, 8, 9, 9, 9, 9, 10, 10, 10, 11, 7,
+	7, 6, 7, 7, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 7,
+	8, 7, 7, 8, 8, 8, 8, 9, 9, 9, 10, 10, 10, 10, 11, 7,
+	9, 7, 8, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 10, 7,
+	9, 8, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 10, 11, 7,
+	10, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 10, 11, 11, 8,
+	10, 9, 9, 9, 9, 9, 9, 9, 9, 10, 10, 10, 10, 11, 11, 8,
+	10, 9, 9, 9, 9, 9, 9, 10, 10, 10, 10, 10, 11, 11, 11, 8,
+	11, 9, 9, 9, 9, 10, 10, 10, 10, 10, 10, 11, 11, 11, 11, 8,
+	11, 10, 9, 9, 9, 10, 10, 10, 10, 10, 10, 11, 11, 11, 11, 8,
+	11, 10, 10, 10, 10, 10, 10, 10, 10, 10, 11, 11, 11, 11, 11, 8,
+	11, 10, 10, 10, 10, 10, 10, 10, 11, 11, 11, 11, 11, 11, 11, 8,
+	12, 10, 10, 10, 10, 10, 10, 11, 11, 11, 11, 11, 11, 11, 11, 8,
+	8, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 8, 8, 8, 8, 4,
+}
+
+var huffman24Codes = []uint16{
+	0xf, 0xd, 0x2e, 0x50, 0x92, 0x106, 0xf8, 0x1b2, 0x1aa, 0x29d, 0x28d, 0x289, 0x26d, 0x205, 0x408, 0x58,
+	0xe, 0xc, 0x15, 0x26, 0x47, 0x82, 0x7a, 0xd8, 0xd1, 0xc6, 0x147, 0x159, 0x13f, 0x129, 0x117, 0x2a,
+	0x2f, 0x16, 0x29, 0x4a, 0x44, 0x80, 0x78, 0xdd, 0xcf, 0xc2, 0xb6, 0x154, 0x13b, 0x127, 0x21d, 0x12,
+	0x51, 0x27, 0x4b, 0x46, 0x86, 0x7d, 0x74, 0xdc, 0xcc, 0xbe, 0xb2, 0x145, 0x137, 0x125, 0x10f, 0x10,
+	0x93, 0x48, 0x45, 0x87, 0x7f, 0x76, 0x70, 0xd2, 0xc8, 0xbc, 0x160, 0x143, 0x132, 0x11d, 0x21c, 0xe,
+	0x107, 0x42, 0x81, 0x7e, 0x77, 0x72, 0xd6, 0xca, 0xc0, 0xb4, 0x155, 0x13d, 0x12d, 0x119, 0x106, 0xc,
+	0xf9, 0x7b, 0x79, 0x75, 0x71, 0xd7, 0xce, 0xc3, 0xb9, 0x15b, 0x14a, 0x134, 0x123, 0x110, 0x208, 0xa,
+	0x1b3, 0x73, 0x6f, 0x6d, 0xd3, 0xcb, 0xc4, 0xbb, 0x161, 0x14c, 0x139, 0x12a, 0x11b, 0x213, 0x17d, 0x11,
+	0x1ab, 0xd4, 0xd0, 0xcd, 0xc9, 0xc1, 0xba, 0xb1, 0xa9, 0x140, 0x12f, 0x11e, 0x10c, 0x202, 0x179, 0x10,
+	0x14f, 0xc7, 0xc5, 0xbf, 0xbd, 0xb5, 0xae, 0x14d, 0x141, 0x131, 0x121, 0x113, 0x209, 0x17b, 0x173, 0xb,
+	0x29c, 0xb8, 0xb7, 0xb3, 0xaf, 0x158, 0x14b, 0x13a, 0x130, 0x122, 0x115, 0x212, 0x17f, 0x175, 0x16e, 0xa,
+	0x28c, 0x15a, 0xab, 0xa8, 0xa4, 0x13e, 0x135, 0x12b, 0x11f, 0x114, 0x107, 0x201, 0x177, 0x170, 0x16a, 0x6,
+	0x288, 0x142, 0x13c, 0x138, 0x133, 0x12e, 0x124, 0x11c, 0x10d, 0x105, 0x200, 0x178, 0x172, 0x16c, 0x167, 0x4,
+	0x26c, 0x12c, 0x128, 0x126, 0x120, 0x11a, 0x111, 0x10a, 0x203, 0x17c, 0x176, 0x171, 0x16d, 0x169, 0x165, 0x2,
+	0x409, 0x118, 0x116, 0x112, 0x10b, 0x108, 0x103, 0x17e, 0x17a, 0x174, 0x16f, 0x16b, 0x168, 0x166, 0x164, 0x0,
+	0x2b, 0x14, 0x13, 0x11, 0xf, 0xd, 0xb, 0x9, 0x7, 0x6, 0x4, 0x7, 0x5, 0x3, 0x1, 0x3,
+}
+
+var huffman32Lengths = []uint8{
+	1, 4, 4, 5, 4, 6, 5, 6, 4, 5, 5, 6, 5, 6, 6, 6,
+}
+
+var huffman32Codes = []uint16{
+	0x1, 0x5, 0x4, 0x5, 0x6, 0x5, 0x4, 0x4, 0x7, 0x3, 0x6, 0x0, 0x7, 0x2, 0x3, 0x1,
+}
+
+var huffman33Lengths = []uint8{
+	4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
+}
+
+var huffman33Codes = []uint16{
+	0xf, 0xe, 0xd, 0xc, 0xb, 0xa, 0x9, 0x8, 0x7, 0x6, 0x5, 0x4, 0x3, 0x2, 0x1, 0x0,
+}
+
+var mp3HuffmanTables = [34]huffmanTable{
+	0:  {}, // not used
+	1:  {size: 2, lengths: huffman1Lengths, codes: huffman1Codes},
+	2:  {size: 3, lengths: huffman2Lengths, codes: huffman2Codes},
+	3:  {size: 3, lengths: huffman3Lengths, codes: huffman3Codes},
+	4:  {}, // not used
+	5:  {size: 4, lengths: huffman5Lengths, codes: huffman5Codes},
+	6:  {size: 4, lengths: huffman6Lengths, codes: huffman6Codes},
+	7:  {size: 6, lengths: huffman7Lengths, codes: huffman7Codes},
+	8:  {size: 6, lengths: huffman8Lengths, codes: huffman8Codes},
+	9:  {size: 6, lengths: huffman9Lengths, codes: huffman9Codes},
+	10: {size: 8, lengths: huffman10Lengths, codes: huffman10Codes},
+	11: {size: 8, lengths: huffman11Lengths, codes: huffman11Codes},
+	12: {size: 8, lengths: huffman12Lengths, codes: huffman12Codes},
+	13: {size: 16, lengths: huffman13Lengths, codes: huffman13Codes},
+	14: {}, // not used
+	15: {size: 16, lengths: huffman15Lengths, codes: huffman15Codes},
+	16: {size: 16, linbits: 1, lengths: huffman16Lengths, codes: huffman16Codes},
+	17: {size: 16, linbits: 2, lengths: huffman16Lengths, codes: huffman16Codes},
+	18: {size: 16, linbits: 3, lengths: huffman16Lengths, codes: huffman16Codes},
+	19: {size: 16, linbits: 4, lengths: huffman16Lengths, codes: huffman16Codes},
+	20: {size: 16, linbits: 6, lengths: huffman16Lengths, codes: huffman16Codes},
+	21: {size: 16, linbits: 8, lengths: huffman16Lengths, codes: huffman16Codes},
+	22: {size: 16, linbits: 10, lengths: huffman16Lengths, codes: huffman16Codes},
+	23: {size: 16, linbits: 13, lengths: huffman16Lengths, codes: huffman16Codes},
+	24: {size: 16, linbits: 4, lengths: huffman24Lengths, codes: huffman24Codes},
+	25: {size: 16, linbits: 5, lengths: huffman24Lengths, codes: huffman24Codes},
+	26: {size: 16, linbits: 6, lengths: huffman24Lengths, codes: huffman24Codes},
+	27: {size: 16, linbits: 7, lengths: huffman24Lengths, codes: huffman24Codes},
+	28: {size: 16, linbits: 8, lengths: huffman24Lengths, codes: huffman24Codes},
+	29: {size: 16, linbits: 9, lengths: huffman24Lengths, codes: huffman24Codes},
+	30: {size: 16, linbits: 11, lengths: huffman24Lengths, codes: huffman24Codes},
+	31: {size: 16, linbits: 13, lengths: huffman24Lengths, codes: huffman24Codes},
+	32: {lengths: huffman32Lengths, codes: huffman32Codes},
+	33: {lengths: huffman33Lengths, codes: huffman33Codes},
+}
@@ -0,0 +1,226 @@
+package decode_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// MPEG-1 Layer III, 128 kbit/s, 44100 Hz without padding: 417 byte frames
+var (
+	stereoHeader = []byte{0xFF, 0xFB, 0x90, 0x00}
+	monoHeader   = []byte{0xFF, 0xFB, 0x90, 0xC0}
+)
+
+const frameSize = 417
+
+// bitWriter appends big endian bit fields to data.
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) write(v uint32, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(v>>i&1) << (7 - w.n%8)
+		w.n++
+	}
+}
+
+// mp3Frame builds a frame from header, side info and main data.
+func mp3Frame(header []byte, side []byte, mainData []byte) []byte {
+	frame := append(append(append([]byte{}, header...), side...), mainData...)
+	return append(frame, make([]byte, frameSize-len(frame))...)
+}
+
+// silentFrame has all-zero side info, so its granules are empty.
+func silentFrame(header []byte) []byte {
+	sideInfoSize := 32
+	if header[3]>>6 == 3 {
+		sideInfoSize = 17
+	}
+	return mp3Frame(header, make([]byte, sideInfoSize), nil)
+}
+
+// toneFrame is a mono frame with a single non zero frequency line in its
+// first granule.
+func toneFrame() []byte {
+	return toneFrameWithGain(210)
+}
+
+func toneFrameWithGain(gain uint32) []byte {
+	side := &bitWriter{}
+	side.write(0, 9)  // main_data_begin
+	side.write(0, 5)  // private bits
+	side.write(0, 4)  // scfsi
+	side.write(3, 12) // part2_3_length
+	side.write(1, 9)  // big_values
+	side.write(gain, 8)
+	side.write(0, 4) // scalefac_compress
+	side.write(0, 1) // window switching
+	side.write(1, 5) // table select
+	side.write(0, 10)
+	side.write(0, 7) // region counts
+	side.write(0, 3) // preflag, scalefac_scale, count1table
+	side.write(0, 59)
+
+	main := &bitWriter{}
+	main.write(1, 2) // x=1, y=0 in table 1
+	main.write(0, 1) // positive
+	return mp3Frame(monoHeader, side.data, main.data)
+}
+
+func TestMP3Silence(t *testing.T) {
+	content := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 10}
+	content = append(content, make([]byte, 10)...)
+	content = append(content, "junk"...)
+	for i := 0; i < 3; i++ {
+		content = append(content, silentFrame(stereoHeader)...)
+	}
+	content = append(content, "TAG"...)
+
+	d, err := decode.Open(writeTestFile(t, "test.mp3", content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.Close()
+	if d.SampleRate() != 44100 || d.Channels() != 2 {
+		t.Errorf("expected 44100 Hz stereo, but got %d Hz with %d channels", d.SampleRate(), d.Channels())
+	}
+	samples := readAll(t, d)
+	if len(samples) != 3*1152*2 {
+		t.Errorf("expected %d samples, but got %d", 3*1152*2, len(samples))
+	}
+	for i, s := range samples {
+		if s != 0 {
+			t.Fatalf("expected silence, but got %f at sample %d", s, i)
+		}
+	}
+}
+
+func TestMP3Tone(t *testing.T) {
+	content := append(toneFrame(), silentFrame(monoHeader)...)
+	d, err := decode.NewMP3(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Channels() != 1 {
+		t.Errorf("expected mono, but got %d channels", d.Channels())
+	}
+	samples := readAll(t, d)
+	if len(samples) != 2*1152 {
+		t.Fatalf("expected %d samples, but got %d", 2*1152, len(samples))
+	}
+	var energy float64
+	for i, s := range samples {
+		if math.IsNaN(float64(s)) || math.Abs(float64(s)) > 1 {
+			t.Fatalf("expected samples in [-1, 1], but got %f at sample %d", s, i)
+		}
+		energy += float64(s) * float64(s)
+	}
+	if energy == 0 {
+		t.Errorf("expected the frequency line to produce sound")
+	}
+}
+
+// infoFrame is a mono Info frame with the number of frames and a LAME tag.
+func infoFrame(frames uint32, delay, padding int) []byte {
+	tag := binary.BigEndian.AppendUint32([]byte("Info"), 1)
+	tag = binary.BigEndian.AppendUint32(tag, frames)
+	lame := make([]byte, 24)
+	copy(lame, "LAME3.100")
+	lame[21] = byte(delay >> 4)
+	lame[22] = byte(delay<<4 | padding>>8)
+	lame[23] = byte(padding)
+	return mp3Frame(monoHeader, make([]byte, 17), append(tag, lame...))
+}
+
+func TestMP3Gapless(t *testing.T) {
+	content := append(toneFrame(), silentFrame(monoHeader)...)
+	content = append(content, toneFrame()...)
+	content = append(content, silentFrame(monoHeader)...)
+	d, err := decode.NewMP3(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := d.Gapless(); ok {
+		t.Errorf("expected no gapless info without a LAME tag")
+	}
+	full := readAll(t, d)
+
+	d, err = decode.NewMP3(bytes.NewReader(append(infoFrame(4, 100, 600), content...)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g, ok := d.Gapless()
+	if expected := (decode.Gapless{Delay: 100, Padding: 600, Samples: 4*1152 - 700}); !ok || g != expected {
+		t.Errorf("expected %+v, but got %+v", expected, g)
+	}
+	samples := readAll(t, d)
+	if len(samples) != 4*1152-700 {
+		t.Fatalf("expected %d samples, but got %d", 4*1152-700, len(samples))
+	}
+	skip := 100 + decode.MP3_DECODER_DELAY
+	for i, s := range samples {
+		if s != full[skip+i] {
+			t.Fatalf("expected sample %d to be %f, but got %f", i, full[skip+i], s)
+		}
+	}
+}
+
+func TestParseITunSMPB(t *testing.T) {
+	g, err := decode.ParseITunSMPB(" 00000000 00000840 000001CA 00000000003F31F6 00000000 00000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := (decode.Gapless{Delay: 2112, Padding: 458, Samples: 4141558}); g != expected {
+		t.Errorf("expected %+v, but got %+v", expected, g)
+	}
+	if _, err := decode.ParseITunSMPB("00000000 0840"); !errors.Is(err, decode.ErrMalformedStream) {
+		t.Errorf("expected ErrMalformedStream, but got %v", err)
+	}
+}
+
+func TestMP3Clipped(t *testing.T) {
+	// A gain far above full scale
+	d, err := decode.NewMP3(bytes.NewReader(append(toneFrameWithGain(255), silentFrame(monoHeader)...)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var peak float64
+	for _, s := range readAll(t, d) {
+		peak = max(peak, math.Abs(float64(s)))
+	}
+	if peak != 1 {
+		t.Errorf("expected the samples to be clipped to 1, but got a peak of %f", peak)
+	}
+}
+
+func TestMP3ShortBuffer(t *testing.T) {
+	d, err := decode.NewMP3(bytes.NewReader(silentFrame(stereoHeader)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.Read(make([]float32, 1)); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("expected io.ErrShortBuffer, but got %v", err)
+	}
+	// Odd buffer sizes only return whole sample frames
+	if n, err := d.Read(make([]float32, 5)); err != nil || n != 4 {
+		t.Errorf("expected 4 samples, but got %d (%v)", n, err)
+	}
+}
+
+func TestMP3NoFrames(t *testing.T) {
+	_, err := decode.NewMP3(bytes.NewReader(bytes.Repeat([]byte{0xFF, 0x00}, 1000)))
+	if !errors.Is(err, decode.ErrMalformedStream) {
+		t.Errorf("expected ErrMalformedStream, but got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package decode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// https://www.mmsp.ece.mcgill.ca/Documents/AudioFormats/WAVE/WAVE.html
+// https://www.mmsp.ece.mcgill.ca/Documents/AudioFormats/AIFF/AIFF.html
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// PCM reads the uncompressed audio of WAV and AIFF files: 8 to 32 bit
+// integer and 32 or 64 bit floating point samples in either byte order.
+type PCM struct {
+	r             *bufio.Reader
+	order         binary.ByteOrder
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	size          int  // bytes per sample
+	float         bool // IEEE floating point samples
+	unsigned      bool // 8 bit WAV samples are unsigned
+	remaining     int64
+	streamed      bool // size unknown, the samples end with the file
+	buf           []byte
+}
+
+// NewWAV returns a decoder for the WAV (RIFF or big endian RIFX) file in r.
+func NewWAV(r io.Reader) (*PCM, error) {
+	d := &PCM{r: bufio.NewReaderSize(r, 64*1024), order: binary.LittleEndian}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(d.r, header); err != nil || string(header[8:]) != "WAVE" {
+		return nil, fmt.Errorf("%w: missing WAVE header", ErrMalformedStream)
+	}
+	if string(header[:4]) == "RIFX" {
+		d.order = binary.BigEndian
+	}
+
+	hasFormat := false
+	for {
+		id, data, size, err := d.nextChunk(d.order, "fmt ", "data")
+		if err != nil {
+			return nil, err
+		}
+		if id == "data" {
+			if !hasFormat {
+				return nil, fmt.Errorf("%w: data chunk before fmt chunk", ErrMalformedStream)
+			}
+			d.remaining = size
+			if size == math.MaxUint32 { // unknown size of streamed files
+				d.remaining = math.MaxInt64
+				d.streamed = true
+			}
+			return d, nil
+		}
+		if err := d.parseFormat(data); err != nil {
+			return nil, err
+		}
+		hasFormat = true
+	}
+}
+
+func (d *PCM) parseFormat(data []byte) error {
+	if len(data) < 16 {
+		return fmt.Errorf("%w: fmt chunk too short", ErrMalformedStream)
+	}
+	format := int(d.order.Uint16(data[0:]))
+	d.channels = int(d.order.Uint16(data[2:]))
+	d.sampleRate = int(d.order.Uint32(data[4:]))
+	blockAlign := int(d.order.Uint16(data[12:]))
+	d.bitsPerSample = int(d.order.Uint16(data[14:]))
+	if format == wavFormatExtensible && len(data) >= 26 {
+		if valid := int(d.order.Uint16(data[18:])); valid > 0 {
+			d.bitsPerSample = valid
+		}
+		format = int(d.order.Uint16(data[24:])) // start of the sub format GUID
+	}
+	if d.channels == 0 || blockAlign%d.channels != 0 {
+		return fmt.Errorf("%w: invalid channel count", ErrMalformedStream)
+	}
+	d.size = blockAlign / d.channels
+
+	switch {
+	case format == wavFormatPCM && d.size >= 1 && d.size <= 4:
+		d.unsigned = d.size == 1
+	case format == wavFormatFloat && (d.size == 4 || d.size == 8):
+		d.float = true
+	default:
+		return fmt.Errorf("%w: WAV format %d with %d byte samples", ErrUnsupportedFormat, format, d.size)
+	}
+	return nil
+}
+
+// NewAIFF returns a decoder for the AIFF or uncompressed AIFF-C file in r.
+func NewAIFF(r io.Reader) (*PCM, error) {
+	d := &PCM{r: bufio.NewReaderSize(r, 64*1024), order: binary.BigEndian}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(d.r, header); err != nil || string(header[:4]) != "FORM" {
+		return nil, fmt.Errorf("%w: missing FORM header", ErrMalformedStream)
+	}
+	aifc := string(header[8:]) == "AIFC"
+
+	hasCommon := false
+	for {
+		id, data, size, err := d.nextChunk(binary.BigEndian, "COMM", "SSND")
+		if err != nil {
+			return nil, err
+		}
+		if id == "SSND" {
+			if !hasCommon {
+				return nil, fmt.Errorf("%w: SSND chunk before COMM chunk", ErrMalformedStream)
+			}
+			// The audio starts after an offset, usually 0
+			ssnd := make([]byte, 8)
+			if _, err := io.ReadFull(d.r, ssnd); err != nil {
+				return nil, fmt.Errorf("%w: %w SSND chunk", ErrMalformedStream, ErrTruncated)
+			}
+			offset := int64(binary.BigEndian.Uint32(ssnd))
+			if _, err := d.r.Discard(int(offset)); err != nil {
+				return nil, fmt.Errorf("%w: %w SSND chunk", ErrMalformedStream, ErrTruncated)
+			}
+			d.remaining = max(0, size-8-offset)
+			return d, nil
+		}
+		if err := d.parseCommon(data, aifc); err != nil {
+			return nil, err
+		}
+		hasCommon = true
+	}
+}
+
+func (d *PCM) parseCommon(data []byte, aifc bool) error {
+	if len(data) < 18 || aifc && len(data) < 22 {
+		return fmt.Errorf("%w: COMM chunk too short", ErrMalformedStream)
+	}
+	d.channels = int(binary.BigEndian.Uint16(data[0:]))
+	d.bitsPerSample = int(binary.BigEndian.Uint16(data[6:]))
+	d.sampleRate = int(math.Round(extendedToFloat(data[8:18])))
+	d.size = (d.bitsPerSample + 7) / 8
+	if d.channels == 0 {
+		return fmt.Errorf("%w: invalid channel count", ErrMalformedStream)
+	}
+
+	compression := "NONE"
+	if aifc {
+		compression = string(data[18:22])
+	}
+	switch compression {
+	case "NONE", "twos":
+	case "sowt":
+		d.order = binary.LittleEndian
+	case "fl32", "FL32":
+		d.float, d.size, d.bitsPerSample = true, 4, 32
+	case "fl64", "FL64":
+		d.float, d.size, d.bitsPerSample = true, 8, 64
+	default:
+		return fmt.Errorf("%w: AIFF-C compression \"%s\"", ErrUnsupportedFormat, compression)
+	}
+	if d.size < 1 || d.size > 4 && !d.float {
+		return fmt.Errorf("%w: AIFF with %d bit samples", ErrUnsupportedFormat, d.bitsPerSample)
+	}
+	return nil
+}
+
+// extendedToFloat converts an 80 bit IEEE 754 extended precision number.
+func extendedToFloat(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b)&0x7FFF) - 16383 - 63
+	v := math.Ldexp(float64(binary.BigEndian.Uint64(b[2:])), exponent)
+	if b[0]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
+
+// nextChunk skips to the next of the chunks with the given ids, whose sizes
+// are stored in order. It returns the content of the format chunk, but leaves
+// the audio chunk unread and returns its size.
+func (d *PCM) nextChunk(order binary.ByteOrder, format string, audio string) (string, []byte, int64, error) {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(d.r, header); err != nil {
+			return "", nil, 0, fmt.Errorf("%w: missing %s chunk", ErrMalformedStream, audio)
+		}
+		id, size := string(header[:4]), int64(order.Uint32(header[4:]))
+		switch id {
+		case audio:
+			return id, nil, size, nil
+		case format:
+			data := make([]byte, size)
+			if _, err := io.ReadFull(d.r, data); err != nil {
+				return "", nil, 0, fmt.Errorf("%w: %w %s chunk", ErrMalformedStream, ErrTruncated, id)
+			}
+			if size%2 == 1 {
+				d.r.Discard(1)
+			}
+			return id, data, size, nil
+		}
+		if _, err := d.r.Discard(int(size + size%2)); err != nil { // chunks are padded to an even size
+			return "", nil, 0, fmt.Errorf("%w: %w %s chunk", ErrMalformedStream, ErrTruncated, id)
+		}
+	}
+}
+
+func (d *PCM) SampleRate() int {
+	return d.sampleRate
+}
+
+func (d *PCM) Channels() int {
+	return d.channels
+}
+
+// BitsPerSample returns the sample size, without the padding of samples
+// stored in larger containers.
+func (d *PCM) BitsPerSample() int {
+	return d.bitsPerSample
+}
+
+func (d *PCM) Read(samples []float32) (int, error) {
+	if len(samples) < d.channels {
+		return 0, io.ErrShortBuffer
+	}
+	frameSize := d.size * d.channels
+	frames := min(int64(len(samples)/d.channels), d.remaining/int64(frameSize))
+	if frames == 0 {
+		return 0, io.EOF
+	}
+	if need := int(frames) * frameSize; len(d.buf) < need {
+		d.buf = make([]byte, need)
+	}
+	n, err := io.ReadFull(d.r, d.buf[:int(frames)*frameSize])
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		if !d.streamed {
+			return 0, fmt.Errorf("%w: %w audio data", ErrMalformedStream, ErrTruncated)
+		}
+		d.remaining = int64(n) // streamed file without size, ends here
+		frames = int64(n / frameSize)
+	} else if err != nil {
+		return 0, err
+	}
+	d.remaining -= frames * int64(frameSize)
+
+	count := int(frames) * d.channels
+	for i := 0; i < count; i++ {
+		samples[i] = d.sample(d.buf[i*d.size : (i+1)*d.size])
+	}
+	if count == 0 {
+		return 0, io.EOF
+	}
+	return count, nil
+}
+
+// sample converts a single stored sample.
+func (d *PCM) sample(b []byte) float32 {
+	switch {
+	case d.float && d.size == 4:
+		return math.Float32frombits(d.order.Uint32(b))
+	case d.float:
+		return float32(math.Float64frombits(d.order.Uint64(b)))
+	case d.unsigned:
+		return float32(int(b[0])-128) / 128
+	}
+	var v uint32
+	for i := range b {
+		if d.order == binary.BigEndian {
+			v = v<<8 | uint32(b[i])
+		} else {
+			v |= uint32(b[i]) << (8 * i)
+		}
+	}
+	bits := 8 * d.size
+	return float32(int32(v<<(32-bits))>>(32-bits)) / float32(uint32(1)<<(bits-1))
+}
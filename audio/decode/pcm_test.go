@@ -0,0 +1,169 @@
+package decode_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// byteOrder is implemented by binary.LittleEndian and binary.BigEndian.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+func riffChunk(order byteOrder, id string, data []byte) []byte {
+	chunk := order.AppendUint32([]byte(id), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// wavFile builds a WAV file with a LIST chunk before the audio data. With
+// validBits > 0 it uses the extensible format.
+func wavFile(order byteOrder, format int, channels int, bits int, validBits int, data []byte) []byte {
+	formatTag := format
+	if validBits > 0 {
+		formatTag = 0xFFFE
+	}
+	fmt := order.AppendUint16(nil, uint16(formatTag))
+	fmt = order.AppendUint16(fmt, uint16(channels))
+	fmt = order.AppendUint32(fmt, 44100)
+	fmt = order.AppendUint32(fmt, uint32(44100*channels*bits/8))
+	fmt = order.AppendUint16(fmt, uint16(channels*bits/8))
+	fmt = order.AppendUint16(fmt, uint16(bits))
+	if validBits > 0 {
+		fmt = order.AppendUint16(fmt, 22)
+		fmt = order.AppendUint16(fmt, uint16(validBits))
+		fmt = order.AppendUint32(fmt, 0)
+		fmt = order.AppendUint16(fmt, uint16(format))
+		fmt = append(fmt, "\x00\x00\x00\x00\x10\x00\x80\x00\x00\xAA\x00\x38\x9B\x71"...)
+	}
+	magic := "RIFF"
+	if order == byteOrder(binary.BigEndian) {
+		magic = "RIFX"
+	}
+	content := append([]byte(magic), 0, 0, 0, 0)
+	content = append(content, "WAVE"...)
+	content = append(content, riffChunk(order, "fmt ", fmt)...)
+	content = append(content, riffChunk(order, "LIST", []byte("odd"))...)
+	content = append(content, riffChunk(order, "data", data)...)
+	return content
+}
+
+// aiffFile builds a 44100 Hz AIFF file, or an AIFF-C file if compression is
+// set.
+func aiffFile(compression string, channels int, bits int, data []byte) []byte {
+	comm := binary.BigEndian.AppendUint16(nil, uint16(channels))
+	comm = binary.BigEndian.AppendUint32(comm, uint32(len(data)*8/bits/channels))
+	comm = binary.BigEndian.AppendUint16(comm, uint16(bits))
+	comm = append(comm, 0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0)
+	form := "AIFF"
+	if compression != "" {
+		form = "AIFC"
+		comm = append(comm, compression...)
+		comm = append(comm, 0) // empty name
+		comm = append(comm, 0)
+	}
+	ssnd := append(make([]byte, 8), data...)
+	content := append([]byte("FORM"), 0, 0, 0, 0)
+	content = append(content, form...)
+	content = append(content, riffChunk(binary.BigEndian, "COMM", comm)...)
+	content = append(content, riffChunk(binary.BigEndian, "SSND", ssnd)...)
+	return content
+}
+
+func float32Bytes(order byteOrder, values ...float32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = order.AppendUint32(b, math.Float32bits(v))
+	}
+	return b
+}
+
+func float64Bytes(order byteOrder, values ...float64) []byte {
+	var b []byte
+	for _, v := range values {
+		b = order.AppendUint64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+func TestPCM(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	tests := []struct {
+		name     string
+		content  []byte
+		channels int
+		bits     int
+		expected []float32
+	}{
+		{"wav 8 bit", wavFile(le, 1, 1, 8, 0, []byte{0, 128, 192}), 1, 8, []float32{-1, 0, 0.5}},
+		{"wav 16 bit", wavFile(le, 1, 2, 16, 0, []byte{0x00, 0x80, 0x00, 0x40}), 2, 16, []float32{-1, 0.5}},
+		{"wav 24 bit", wavFile(le, 1, 1, 24, 0, []byte{0, 0, 0x80, 0, 0, 0x40}), 1, 24, []float32{-1, 0.5}},
+		{"wav 32 bit", wavFile(le, 1, 1, 32, 0, []byte{0, 0, 0, 0xC0}), 1, 32, []float32{-0.5}},
+		{"wav 24 in 32 bit", wavFile(le, 1, 1, 32, 24, []byte{0, 0, 0, 0x40}), 1, 24, []float32{0.5}},
+		{"wav float", wavFile(le, 3, 2, 32, 0, float32Bytes(le, 0.25, -0.75)), 2, 32, []float32{0.25, -0.75}},
+		{"wav double", wavFile(le, 3, 1, 64, 0, float64Bytes(le, -0.125)), 1, 64, []float32{-0.125}},
+		{"rifx 16 bit", wavFile(be, 1, 1, 16, 0, []byte{0x40, 0x00}), 1, 16, []float32{0.5}},
+		{"aiff 8 bit", aiffFile("", 1, 8, []byte{0x80, 0x40}), 1, 8, []float32{-1, 0.5}},
+		{"aiff 16 bit", aiffFile("", 2, 16, []byte{0xC0, 0x00, 0x40, 0x00}), 2, 16, []float32{-0.5, 0.5}},
+		{"aiff 24 bit", aiffFile("", 1, 24, []byte{0x40, 0, 0}), 1, 24, []float32{0.5}},
+		{"aifc little endian", aiffFile("sowt", 1, 16, []byte{0x00, 0x40}), 1, 16, []float32{0.5}},
+		{"aifc float", aiffFile("fl32", 1, 32, float32Bytes(be, 0.25)), 1, 32, []float32{0.25}},
+		{"aifc double", aiffFile("fl64", 1, 64, float64Bytes(be, -0.25)), 1, 64, []float32{-0.25}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := decode.Open(writeTestFile(t, "test", test.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer d.Close()
+			if d.SampleRate() != 44100 || d.Channels() != test.channels {
+				t.Errorf("expected 44100 Hz with %d channels, but got %d Hz with %d channels", test.channels, d.SampleRate(), d.Channels())
+			}
+			if bits := d.Decoder.(*decode.PCM).BitsPerSample(); bits != test.bits {
+				t.Errorf("expected %d bits per sample, but got %d", test.bits, bits)
+			}
+			samples := readAll(t, d)
+			if len(samples) != len(test.expected) {
+				t.Fatalf("expected samples %v, but got %v", test.expected, samples)
+			}
+			for i := range samples {
+				if samples[i] != test.expected[i] {
+					t.Errorf("expected samples %v, but got %v", test.expected, samples)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestPCMTruncated(t *testing.T) {
+	content := wavFile(binary.LittleEndian, 1, 1, 16, 0, []byte{0, 0, 0, 0})
+	d, err := decode.Open(writeTestFile(t, "test.wav", content[:len(content)-2]))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.Close()
+	if _, err := d.Read(make([]float32, 10)); !errors.Is(err, decode.ErrMalformedStream) {
+		t.Errorf("expected ErrMalformedStream, but got %v", err)
+	}
+}
+
+func TestPCMUnsupported(t *testing.T) {
+	for name, content := range map[string][]byte{
+		"adpcm": wavFile(binary.LittleEndian, 2, 1, 4, 0, nil),
+		"ulaw":  aiffFile("ulaw", 1, 16, nil),
+	} {
+		if _, err := decode.Open(writeTestFile(t, "test", content)); !errors.Is(err, decode.ErrUnsupportedFormat) {
+			t.Errorf("expected ErrUnsupportedFormat for %s, but got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package decode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/makl11/musiman/audio/ogg"
+)
+
+// Upper limit of the problems reported for a single file
+const MAX_PROBLEMS = 100
+
+// Verify checks the integrity of the music file at path: the structure of its
+// container, the checksums of formats which have them and that all of its
+// audio can be decoded. Every problem found wraps ErrMalformedStream and, for
+// the damage they describe, ErrTruncated, ErrChecksum or ErrLostSync. An
+// error is returned if the file can not be read or its format is not
+// supported.
+func Verify(path string) ([]error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	format, err := detectFormat(r)
+	if errors.Is(err, ErrMalformedStream) {
+		return []error{err}, nil // i.e. nothing after an ID3v2 tag
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	start -= int64(r.Buffered())
+	stream := io.NewSectionReader(f, start, info.Size()-start)
+
+	p := &problems{base: start}
+	var data []byte
+	switch format {
+	case formatMP3, formatOgg:
+		// Both are small enough to be checked in memory, which makes
+		// resyncing after junk simple
+		if data, err = io.ReadAll(stream); err != nil {
+			return nil, err
+		}
+		if format == formatMP3 {
+			p.mp3(data)
+		} else {
+			p.ogg(data)
+		}
+	case formatFLAC:
+		// Frame and MD5 checksums are verified by the decoder
+		if err := p.flac(stream); err != nil {
+			return nil, err
+		}
+		return p.found, nil
+	case formatWAV, formatAIFF:
+		if err := p.chunks(stream, stream.Size()); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.found) > 0 {
+		return p.found, nil // decoding would only find the same problems
+	}
+
+	var d Decoder
+	switch format {
+	case formatMP3:
+		d, err = NewMP3(bytes.NewReader(data))
+	case formatOgg:
+		d, err = NewVorbis(bytes.NewReader(data))
+	case formatWAV:
+		d, err = NewWAV(io.NewSectionReader(stream, 0, stream.Size()))
+	case formatAIFF:
+		d, err = NewAIFF(io.NewSectionReader(stream, 0, stream.Size()))
+	}
+	if err == nil {
+		err = decodeAll(d)
+	}
+	switch {
+	case errors.Is(err, ErrUnsupportedFormat):
+		return nil, fmt.Errorf("%s: %w", path, err)
+	case errors.Is(err, ErrMalformedStream):
+		p.add(err)
+	case err != nil:
+		return nil, err
+	}
+	return p.found, nil
+}
+
+// decodeAll decodes all audio of d, returning the first error.
+func decodeAll(d Decoder) error {
+	buf := make([]float32, 4096*max(1, d.Channels()))
+	for {
+		_, err := d.Read(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// problems collects the problems found in a stream starting at byte base of
+// its file, up to MAX_PROBLEMS.
+type problems struct {
+	base  int64
+	found []error
+}
+
+func (p *problems) add(err error) {
+	if !p.full() {
+		p.found = append(p.found, err)
+	}
+}
+
+// damage adds a problem of the given kind, with the offset of the damage in
+// the stream.
+func (p *problems) damage(kind error, offset int, format string, args ...any) {
+	p.add(fmt.Errorf("%w: %w: byte %d: %s", ErrMalformedStream, kind, p.base+int64(offset), fmt.Sprintf(format, args...)))
+}
+
+func (p *problems) full() bool {
+	return len(p.found) >= MAX_PROBLEMS
+}
+
+// mp3 walks the frames of the MP3 stream in data. Junk in front of the first
+// frame is common and not reported, unlike junk between frames. Tags at the
+// end are skipped. Frames protected by a CRC are checked, and the number of
+// frames is compared to the one stated in a Xing or VBRI header.
+func (p *problems) mp3(data []byte) {
+	data = data[:mp3AudioEnd(data)]
+	pos := findMP3Frame(data, 0, nil)
+	if pos < 0 {
+		p.add(fmt.Errorf("%w: no MPEG audio frame found", ErrMalformedStream))
+		return
+	}
+	first, _ := parseMP3Header(data[pos:])
+	frames, stated := 0, 0
+	for pos < len(data) && !p.full() {
+		h, ok := parseMP3Header(data[pos:])
+		if !ok || !h.compatible(first) {
+			next := findMP3Frame(data, pos+1, &first)
+			if next < 0 {
+				p.damage(ErrLostSync, pos, "%d bytes of junk after the last frame", len(data)-pos)
+				break
+			}
+			p.damage(ErrLostSync, pos, "%d bytes of junk between frames", next-pos)
+			pos = next
+			continue
+		}
+		size := h.frameSize()
+		if pos+size > len(data) {
+			p.damage(ErrTruncated, pos, "last frame has %d of %d bytes", len(data)-pos, size)
+			break
+		}
+		frame := data[pos : pos+size]
+		if h.protected && !checkMP3CRC(frame, h) {
+			p.damage(ErrChecksum, pos, "frame CRC")
+		}
+		if n, ok := xingFrames(frame, h); ok && frames == 0 && stated == 0 {
+			stated = n
+		} else {
+			frames++
+		}
+		pos += size
+	}
+	// Encoders disagree whether the Xing frame is counted
+	if stated > 0 && frames < stated-1 {
+		p.damage(ErrTruncated, len(data), "stream has %d of %d frames", frames, stated)
+	}
+}
+
+// findMP3Frame returns the position of the first frame in data at or after
+// from which is followed by another frame or the end of data. With ref set,
+// only frames compatible to it are found. It returns -1 if there is none.
+func findMP3Frame(data []byte, from int, ref *mp3Header) int {
+	for i := from; i+4 <= len(data); i++ {
+		h, ok := parseMP3Header(data[i:])
+		if !ok || ref != nil && !h.compatible(*ref) {
+			continue
+		}
+		if next := i + h.frameSize(); next+4 <= len(data) {
+			if n, ok := parseMP3Header(data[next:]); !ok || !n.compatible(h) {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+// mp3AudioEnd returns the end of the audio of an MP3 stream, before ID3v1,
+// APEv2 and Lyrics3v2 tags at its end.
+func mp3AudioEnd(data []byte) int {
+	end := len(data)
+	for {
+		switch {
+		case end >= 128 && bytes.Equal(data[end-128:end-125], []byte("TAG")):
+			end -= 128
+		case end >= 32 && bytes.Equal(data[end-32:end-24], []byte("APETAGEX")):
+			size := int(binary.LittleEndian.Uint32(data[end-20:])) // with footer
+			if binary.LittleEndian.Uint32(data[end-12:])&(1<<31) != 0 {
+				size += 32 // header
+			}
+			if size < 32 || size > end {
+				return end
+			}
+			end -= size
+		case end >= 15 && bytes.Equal(data[end-9:end], []byte("LYRICS200")):
+			size, err := strconv.Atoi(string(data[end-15 : end-9]))
+			if err != nil || size+15 > end {
+				return end
+			}
+			end -= size + 15
+		default:
+			return end
+		}
+	}
+}
+
+// checkMP3CRC checks the CRC of a protected frame, which covers the last two
+// bytes of the header and the side info.
+func checkMP3CRC(frame []byte, h mp3Header) bool {
+	if len(frame) < 6+h.sideInfoSize() {
+		return false
+	}
+	crc := updateCRC16(0xFFFF, frame[2:4])
+	crc = updateCRC16(crc, frame[6:6+h.sideInfoSize()])
+	return crc == binary.BigEndian.Uint16(frame[4:6])
+}
+
+// xingFrames returns the number of frames stated in the Xing, Info or VBRI
+// header in frame, 0 if it is not stated. It returns false if frame has no
+// such header.
+func xingFrames(frame []byte, h mp3Header) (int, bool) {
+	if !isXingFrame(frame, h) {
+		return 0, false
+	}
+	offset := 4 + h.sideInfoSize()
+	if h.protected {
+		offset += 2
+	}
+	switch {
+	case len(frame) >= 36+18 && bytes.Equal(frame[36:40], []byte("VBRI")):
+		return int(binary.BigEndian.Uint32(frame[36+14:])), true
+	case len(frame) >= offset+12 && binary.BigEndian.Uint32(frame[offset+4:])&1 != 0:
+		return int(binary.BigEndian.Uint32(frame[offset+8:])), true
+	}
+	return 0, true
+}
+
+// ogg walks the pages of the Ogg stream in data, checking their checksums and
+// that no page of a logical stream is missing, including its last one.
+func (p *problems) ogg(data []byte) {
+	type stream struct {
+		next  uint32 // expected sequence number
+		ended bool
+	}
+	streams := map[uint32]*stream{}
+	var serials []uint32
+	pos := 0
+	for pos < len(data) && !p.full() {
+		page, err := ogg.ReadPage(bytes.NewReader(data[pos:]))
+		if page == nil {
+			next := bytes.Index(data[pos+1:], []byte("OggS"))
+			switch {
+			case next >= 0:
+				p.damage(ErrLostSync, pos, "%d bytes of junk between pages", next+1)
+				pos += next + 1
+				continue
+			case bytes.HasPrefix(data[pos:], []byte("OggS")):
+				p.damage(ErrTruncated, pos, "last page: %v", err)
+			default:
+				p.damage(ErrLostSync, pos, "%d bytes of junk after the last page", len(data)-pos)
+			}
+			break
+		}
+		if errors.Is(err, ogg.ErrChecksum) {
+			p.damage(ErrChecksum, pos, "page %d", page.Sequence)
+		}
+		s, found := streams[page.Serial]
+		if !found {
+			s = &stream{next: page.Sequence}
+			streams[page.Serial] = s
+			serials = append(serials, page.Serial)
+		}
+		switch {
+		case page.Sequence > s.next:
+			p.damage(ErrLostSync, pos, "pages %d to %d of stream %08x are missing", s.next, page.Sequence-1, page.Serial)
+		case page.Sequence < s.next:
+			p.damage(ErrLostSync, pos, "page %d of stream %08x is out of order", page.Sequence, page.Serial)
+		}
+		s.next = page.Sequence + 1
+		s.ended = s.ended || page.HeaderType&ogg.HEADER_EOS != 0
+		pos += 27 + len(page.Segments) + len(page.Payload)
+	}
+	for _, serial := range serials {
+		if !streams[serial].ended {
+			p.damage(ErrTruncated, len(data), "stream %08x has no last page", serial)
+		}
+	}
+}
+
+// flac decodes the FLAC stream in r, continuing after damaged frames. A
+// stream with fewer samples than stated in its STREAMINFO block is reported
+// as truncated, unless frames were damaged.
+func (p *problems) flac(r io.Reader) error {
+	d, err := NewFLAC(r)
+	if errors.Is(err, ErrMalformedStream) {
+		p.add(err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var position int64 // samples per channel
+	var previous error
+	buf := make([]float32, 4096*d.channels)
+	for !p.full() {
+		n, err := d.Read(buf)
+		position += int64(n / d.channels)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, ErrMalformedStream) {
+			p.add(fmt.Errorf("sample %d: %w", position, err))
+			previous = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		previous = nil
+	}
+	damaged := len(p.found)
+	if d.hash != nil && previous != nil {
+		damaged-- // the MD5 signature mismatch right before the end
+	}
+	if damaged == 0 && d.totalSamples > 0 && position < d.totalSamples {
+		p.add(fmt.Errorf("%w: %w: stream has %d of %d samples", ErrMalformedStream, ErrTruncated, position, d.totalSamples))
+	}
+	return nil
+}
+
+// chunks checks that the chunks of the RIFF, RIFX or FORM file in r of the
+// given size are complete and add up to its size. Tags appended to the file
+// are accepted.
+func (p *problems) chunks(r io.ReaderAt, size int64) error {
+	header := make([]byte, 12)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return err
+	}
+	container := string(header[:4])
+	var order binary.ByteOrder = binary.BigEndian
+	if container == "RIFF" {
+		order = binary.LittleEndian
+	}
+	declared := int64(order.Uint32(header[4:])) + 8
+	switch {
+	case declared > size:
+		p.damage(ErrTruncated, 0, "%s chunk has %d of %d bytes", container, size, declared)
+	case declared < size && !appendedTag(r, declared):
+		p.add(fmt.Errorf("%w: byte %d: %d bytes after the %s chunk", ErrMalformedStream, p.base+declared, size-declared, container))
+	}
+
+	chunk := make([]byte, 8)
+	for pos := int64(12); pos+8 <= min(declared, size) && !p.full(); {
+		if _, err := r.ReadAt(chunk, pos); err != nil {
+			return err
+		}
+		id, chunkSize := string(chunk[:4]), int64(order.Uint32(chunk[4:]))
+		if id == "data" && chunkSize == math.MaxUint32 {
+			break // streamed file of unknown size
+		}
+		if pos+8+chunkSize > size {
+			p.damage(ErrTruncated, int(pos), "%s chunk has %d of %d bytes", id, size-pos-8, chunkSize)
+			break
+		}
+		pos += 8 + chunkSize + chunkSize%2 // chunks are padded to an even size
+	}
+	return nil
+}
+
+// appendedTag reports whether an ID3 tag follows the chunk ending at pos.
+func appendedTag(r io.ReaderAt, pos int64) bool {
+	magic := make([]byte, 3)
+	if _, err := r.ReadAt(magic, pos); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, []byte("ID3")) || bytes.Equal(magic, []byte("TAG"))
+}
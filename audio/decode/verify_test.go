@@ -0,0 +1,111 @@
+package decode_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// expectProblems checks that the file with content has exactly the problems
+// of the given kinds, nil for problems of no particular kind.
+func expectProblems(t *testing.T, name string, content []byte, kinds ...error) {
+	t.Helper()
+	problems, err := decode.Verify(writeTestFile(t, name, content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(problems) != len(kinds) {
+		t.Fatalf("expected %d problems, but got %v", len(kinds), problems)
+	}
+	for i, kind := range kinds {
+		if !errors.Is(problems[i], decode.ErrMalformedStream) || kind != nil && !errors.Is(problems[i], kind) {
+			t.Errorf("expected problem %d to be %v, but got %v", i, kind, problems[i])
+		}
+	}
+}
+
+func TestVerifyMP3(t *testing.T) {
+	var frames []byte
+	for i := 0; i < 3; i++ {
+		frames = append(frames, silentFrame(stereoHeader)...)
+	}
+	// protected frame with a CRC of 0
+	protected := silentFrame([]byte{0xFF, 0xFA, 0x90, 0x00})
+	protected = append(protected[:4], append([]byte{0, 0}, protected[4:len(protected)-2]...)...)
+
+	t.Run("intact", func(t *testing.T) {
+		content := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 0}
+		content = append(append(content, "junk"...), frames...)
+		content = append(content, "TAG"...)
+		content = append(content, make([]byte, 125)...)
+		expectProblems(t, "test.mp3", content)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		expectProblems(t, "test.mp3", frames[:len(frames)-100], decode.ErrTruncated)
+	})
+	t.Run("junk between frames", func(t *testing.T) {
+		content := append(append(append([]byte{}, frames[:2*frameSize]...), "junk"...), frames[2*frameSize:]...)
+		expectProblems(t, "test.mp3", content, decode.ErrLostSync)
+	})
+	t.Run("CRC mismatch", func(t *testing.T) {
+		content := append(append([]byte{}, protected...), protected...)
+		expectProblems(t, "test.mp3", content, decode.ErrChecksum, decode.ErrChecksum)
+	})
+}
+
+func TestVerifyFLAC(t *testing.T) {
+	signal := [][]int{testSignal(0), testSignal(100), testSignal(200)}
+	var frames [][]byte
+	for i, values := range signal {
+		frames = append(frames, flacFrame(i, 1, func(w *bitWriter) {
+			writeVerbatim(w, values, 16)
+			writeVerbatim(w, values, 16)
+		}))
+	}
+	signature := testSignature(signal, signal)
+	last := len(frames[2])
+
+	expectProblems(t, "test.flac", flacStream(2, frames, signature))
+	// Without signature only the number of samples tells
+	content := flacStream(2, frames, nil)
+	expectProblems(t, "test.flac", content[:len(content)-last], decode.ErrTruncated)
+	content = flacStream(2, frames, signature)
+	expectProblems(t, "test.flac", content[:len(content)-last], decode.ErrChecksum, decode.ErrTruncated)
+
+	// Decoding continues after a damaged frame
+	content = flacStream(2, frames, nil)
+	content[len(content)-last-10] ^= 0xFF
+	expectProblems(t, "test.flac", content, decode.ErrChecksum)
+}
+
+func TestVerifyOgg(t *testing.T) {
+	packets := make([][]byte, 6)
+	for i := range packets {
+		packets[i] = toneVorbisPacket()
+	}
+	content := vorbisStream(vorbisSetup(), packets, []int{3}, []int64{256, 600})
+	expectProblems(t, "test.ogg", content)
+
+	damaged := append([]byte{}, content...)
+	damaged[len(damaged)-10] ^= 0xFF
+	expectProblems(t, "test.ogg", damaged, decode.ErrChecksum)
+	expectProblems(t, "test.ogg", content[:len(content)-10], decode.ErrTruncated, decode.ErrTruncated)
+}
+
+func TestVerifyWAV(t *testing.T) {
+	content := wavFile(binary.LittleEndian, 1, 1, 16, 0, []byte{1, 0, 2, 0})
+	binary.LittleEndian.PutUint32(content[4:], uint32(len(content)-8))
+	expectProblems(t, "test.wav", content)
+	expectProblems(t, "test.wav", append(content, "ID3"...))
+	expectProblems(t, "test.wav", append(content, "junk"...), nil)
+	expectProblems(t, "test.wav", content[:len(content)-2], decode.ErrTruncated, decode.ErrTruncated)
+}
+
+func TestVerifyUnsupportedFormat(t *testing.T) {
+	path := writeTestFile(t, "test.txt", []byte("not audio at all"))
+	if _, err := decode.Verify(path); !errors.Is(err, decode.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
@@ -0,0 +1,419 @@
+package decode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/makl11/musiman/audio/ogg"
+)
+
+// Vorbis I in an Ogg bitstream
+// https://xiph.org/vorbis/doc/Vorbis_I_spec.html
+
+const (
+	vorbisPacketIdentification = 1
+	vorbisPacketComment        = 3
+	vorbisPacketSetup          = 5
+)
+
+type vorbisMapping struct {
+	magnitudes, angles []int // coupled channel pairs
+	mux                []int // submap by channel
+	floors, residues   []int // by submap
+}
+
+type vorbisMode struct {
+	long    bool
+	mapping int
+}
+
+// Vorbis decodes the first logical Vorbis stream of an Ogg bitstream.
+type Vorbis struct {
+	packets    *ogg.PacketReader
+	sampleRate int
+	channels   int
+	blockSizes [2]int
+	books      []*vorbisCodebook
+	floors     []vorbisFloor
+	residues   []*vorbisResidue
+	mappings   []vorbisMapping
+	modes      []vorbisMode
+
+	imdct  [2]*imdct
+	slopes [2][]float32 // rising window halves by block size
+	// per channel decoding state
+	spectrum [][]float32
+	curve    [][]float32
+	block    [][]float32 // windowed output of the current packet
+	overlap  [][]float32 // of the previous packet
+	unused   []bool      // floors
+	prevSize int         // block size of the previous packet, 0 before the first
+
+	position int64 // samples per channel returned before the current page
+	started  bool  // the first audio page is decoded
+	pcm      pcmBuffer
+	eof      bool
+}
+
+// NewVorbis returns a decoder for the Ogg Vorbis stream in r. Other codecs in
+// Ogg, like Opus, return ErrUnsupportedFormat.
+func NewVorbis(r io.Reader) (*Vorbis, error) {
+	d := &Vorbis{packets: ogg.NewPacketReader(r)}
+	packet, err := d.packets.NextPacket()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedStream, err)
+	}
+	if !isVorbisHeader(packet, vorbisPacketIdentification) {
+		return nil, fmt.Errorf("%w: no Vorbis stream in Ogg", ErrUnsupportedFormat)
+	}
+	if err := d.parseIdentification(packet[7:]); err != nil {
+		return nil, err
+	}
+	packet, err = d.packets.NextPacket()
+	if err != nil || !isVorbisHeader(packet, vorbisPacketComment) {
+		return nil, fmt.Errorf("%w: missing Vorbis comment header", ErrMalformedStream)
+	}
+	packet, err = d.packets.NextPacket()
+	if err != nil || !isVorbisHeader(packet, vorbisPacketSetup) {
+		return nil, fmt.Errorf("%w: missing Vorbis setup header", ErrMalformedStream)
+	}
+	if err := d.parseSetup(&lsbBitReader{data: packet[7:]}); err != nil {
+		return nil, err
+	}
+
+	for i, size := range d.blockSizes {
+		d.imdct[i] = newIMDCT(size)
+		d.slopes[i] = make([]float32, size/2)
+		for j := range d.slopes[i] {
+			s := math.Sin((float64(j) + 0.5) / float64(size/2) * math.Pi / 2)
+			d.slopes[i][j] = float32(math.Sin(math.Pi / 2 * s * s))
+		}
+	}
+	d.pcm.channels = d.channels
+	for ch := 0; ch < d.channels; ch++ {
+		d.spectrum = append(d.spectrum, make([]float32, d.blockSizes[1]/2))
+		d.curve = append(d.curve, make([]float32, d.blockSizes[1]/2))
+		d.block = append(d.block, make([]float32, d.blockSizes[1]))
+		d.overlap = append(d.overlap, make([]float32, d.blockSizes[1]))
+	}
+	d.unused = make([]bool, d.channels)
+	return d, nil
+}
+
+func isVorbisHeader(packet []byte, packetType byte) bool {
+	return len(packet) >= 7 && packet[0] == packetType && bytes.Equal(packet[1:7], []byte("vorbis"))
+}
+
+func (d *Vorbis) parseIdentification(b []byte) error {
+	if len(b) < 23 {
+		return fmt.Errorf("%w: Vorbis identification header too short", ErrMalformedStream)
+	}
+	br := &lsbBitReader{data: b}
+	if version := br.bits(32); version != 0 {
+		return fmt.Errorf("%w: Vorbis version %d", ErrUnsupportedFormat, version)
+	}
+	d.channels, d.sampleRate = int(br.bits(8)), int(br.bits(32))
+	br.skip(3 * 32) // bitrates
+	d.blockSizes = [2]int{1 << br.bits(4), 1 << br.bits(4)}
+	if d.channels == 0 || d.sampleRate == 0 || !br.flag() {
+		return fmt.Errorf("%w: invalid Vorbis identification header", ErrMalformedStream)
+	}
+	if d.blockSizes[0] < 64 || d.blockSizes[1] > 8192 || d.blockSizes[0] > d.blockSizes[1] {
+		return fmt.Errorf("%w: invalid Vorbis block sizes", ErrMalformedStream)
+	}
+	return nil
+}
+
+func (d *Vorbis) parseSetup(br *lsbBitReader) error {
+	d.books = make([]*vorbisCodebook, br.bits(8)+1)
+	for i := range d.books {
+		book, err := readVorbisCodebook(br)
+		if err != nil {
+			return err
+		}
+		d.books[i] = book
+	}
+	for i := br.bits(6) + 1; i > 0; i-- {
+		if br.bits(16) != 0 {
+			return fmt.Errorf("%w: invalid Vorbis time domain transform", ErrMalformedStream)
+		}
+	}
+	d.floors = make([]vorbisFloor, br.bits(6)+1)
+	for i := range d.floors {
+		floor, err := readVorbisFloor(br, d.books)
+		if err != nil {
+			return err
+		}
+		d.floors[i] = floor
+	}
+	d.residues = make([]*vorbisResidue, br.bits(6)+1)
+	for i := range d.residues {
+		residue, err := readVorbisResidue(br, d.books)
+		if err != nil {
+			return err
+		}
+		d.residues[i] = residue
+	}
+	d.mappings = make([]vorbisMapping, br.bits(6)+1)
+	for i := range d.mappings {
+		if err := d.readMapping(br, &d.mappings[i]); err != nil {
+			return err
+		}
+	}
+	d.modes = make([]vorbisMode, br.bits(6)+1)
+	for i := range d.modes {
+		long := br.flag()
+		window, transform, mapping := br.bits(16), br.bits(16), int(br.bits(8))
+		if window != 0 || transform != 0 || mapping >= len(d.mappings) {
+			return fmt.Errorf("%w: invalid Vorbis mode", ErrMalformedStream)
+		}
+		d.modes[i] = vorbisMode{long: long, mapping: mapping}
+	}
+	if !br.flag() || br.eop {
+		return fmt.Errorf("%w: truncated Vorbis setup header", ErrMalformedStream)
+	}
+	return nil
+}
+
+func (d *Vorbis) readMapping(br *lsbBitReader, m *vorbisMapping) error {
+	if br.bits(16) != 0 {
+		return fmt.Errorf("%w: invalid Vorbis mapping type", ErrMalformedStream)
+	}
+	submaps := 1
+	if br.flag() {
+		submaps = int(br.bits(4)) + 1
+	}
+	if br.flag() {
+		channelBits := ilog(uint32(d.channels - 1))
+		for i := br.bits(8) + 1; i > 0; i-- {
+			magnitude, angle := int(br.bits(channelBits)), int(br.bits(channelBits))
+			if magnitude == angle || magnitude >= d.channels || angle >= d.channels {
+				return fmt.Errorf("%w: invalid Vorbis channel coupling", ErrMalformedStream)
+			}
+			m.magnitudes, m.angles = append(m.magnitudes, magnitude), append(m.angles, angle)
+		}
+	}
+	if br.bits(2) != 0 {
+		return fmt.Errorf("%w: invalid Vorbis mapping", ErrMalformedStream)
+	}
+	m.mux = make([]int, d.channels)
+	if submaps > 1 {
+		for ch := range m.mux {
+			m.mux[ch] = int(br.bits(4))
+			if m.mux[ch] >= submaps {
+				return fmt.Errorf("%w: invalid Vorbis mapping mux", ErrMalformedStream)
+			}
+		}
+	}
+	m.floors, m.residues = make([]int, submaps), make([]int, submaps)
+	for i := 0; i < submaps; i++ {
+		br.skip(8) // unused time configuration
+		m.floors[i], m.residues[i] = int(br.bits(8)), int(br.bits(8))
+		if m.floors[i] >= len(d.floors) || m.residues[i] >= len(d.residues) {
+			return fmt.Errorf("%w: invalid Vorbis mapping", ErrMalformedStream)
+		}
+	}
+	return nil
+}
+
+func (d *Vorbis) SampleRate() int {
+	return d.sampleRate
+}
+
+func (d *Vorbis) Channels() int {
+	return d.channels
+}
+
+func (d *Vorbis) Read(samples []float32) (int, error) {
+	for len(d.pcm.samples) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+		if err := d.decodePage(); err != nil {
+			return 0, err
+		}
+	}
+	return d.pcm.read(samples)
+}
+
+// decodePage decodes the packets ending on the next page into the PCM buffer,
+// trimming it to the granule position of the page.
+func (d *Vorbis) decodePage() error {
+	d.pcm.samples = d.pcm.samples[:0]
+	for {
+		packet, err := d.packets.NextPacket()
+		if err == io.EOF {
+			d.eof = true
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedStream, err)
+		}
+		d.decodePacket(packet)
+		if granule := d.packets.Granule(); granule >= 0 {
+			last := d.packets.Page().HeaderType&ogg.HEADER_EOS != 0
+			d.trim(granule, last)
+			d.eof = last
+			return nil
+		}
+	}
+}
+
+// trim drops the samples before the start of the stream from the first page
+// and those after its end from the last page, both derived from the granule
+// position at the end of the page.
+func (d *Vorbis) trim(granule int64, last bool) {
+	frames := int64(len(d.pcm.samples) / d.channels)
+	switch {
+	case last && granule-d.position < frames:
+		frames = max(0, granule-d.position)
+		d.pcm.samples = d.pcm.samples[:frames*int64(d.channels)]
+	case !d.started && granule < frames:
+		drop := frames - max(0, granule)
+		d.pcm.samples = d.pcm.samples[drop*int64(d.channels):]
+		frames -= drop
+	}
+	if frames > 0 || d.prevSize > 0 {
+		d.started = true
+	}
+	d.position += frames
+}
+
+// decodePacket decodes an audio packet and appends the samples finished by it
+// to the PCM buffer.
+func (d *Vorbis) decodePacket(packet []byte) {
+	br := &lsbBitReader{data: packet}
+	if len(packet) == 0 || br.flag() { // not an audio packet
+		return
+	}
+	modeNumber := int(br.bits(ilog(uint32(len(d.modes) - 1))))
+	if modeNumber >= len(d.modes) || br.eop {
+		return
+	}
+	mode := d.modes[modeNumber]
+	size, prevLong, nextLong := d.blockSizes[0], false, false
+	if mode.long {
+		size, prevLong, nextLong = d.blockSizes[1], br.flag(), br.flag()
+	}
+	mapping := &d.mappings[mode.mapping]
+
+	n := size / 2
+	for ch := 0; ch < d.channels; ch++ {
+		floor := d.floors[mapping.floors[mapping.mux[ch]]]
+		d.unused[ch] = !floor.decode(br, d.books, d.curve[ch][:n])
+	}
+	// A coupled channel needs the residue of its partner
+	noResidue := append([]bool(nil), d.unused...)
+	for i := range mapping.magnitudes {
+		m, a := mapping.magnitudes[i], mapping.angles[i]
+		if !noResidue[m] || !noResidue[a] {
+			noResidue[m], noResidue[a] = false, false
+		}
+	}
+	for ch := 0; ch < d.channels; ch++ {
+		clear(d.spectrum[ch][:n])
+	}
+	for submap, residue := range mapping.residues {
+		var vectors [][]float32
+		var skip []bool
+		for ch := 0; ch < d.channels; ch++ {
+			if mapping.mux[ch] == submap {
+				vectors = append(vectors, d.spectrum[ch][:n])
+				skip = append(skip, noResidue[ch])
+			}
+		}
+		d.residues[residue].decode(br, d.books, vectors, skip)
+	}
+	for i := len(mapping.magnitudes) - 1; i >= 0; i-- {
+		magnitudes, angles := d.spectrum[mapping.magnitudes[i]][:n], d.spectrum[mapping.angles[i]][:n]
+		for j := range magnitudes {
+			m, a := magnitudes[j], angles[j]
+			switch {
+			case m > 0 && a > 0:
+				angles[j] = m - a
+			case m > 0:
+				magnitudes[j], angles[j] = m+a, m
+			case a > 0:
+				angles[j] = m + a
+			default:
+				magnitudes[j], angles[j] = m-a, m
+			}
+		}
+	}
+
+	long := 0
+	if mode.long {
+		long = 1
+	}
+	for ch := 0; ch < d.channels; ch++ {
+		spectrum, block := d.spectrum[ch][:n], d.block[ch][:size]
+		if d.unused[ch] {
+			clear(spectrum)
+		} else {
+			for i, c := range d.curve[ch][:n] {
+				spectrum[i] *= c
+			}
+		}
+		d.imdct[long].transform(spectrum, block)
+		d.window(block, mode.long && !prevLong, mode.long && !nextLong)
+	}
+	d.overlapAdd(size)
+}
+
+// window applies the window to a block, whose slopes are short at the sides
+// next to short blocks.
+func (d *Vorbis) window(block []float32, shortLeft bool, shortRight bool) {
+	n := len(block)
+	leftSlope, rightSlope := d.slopes[0], d.slopes[0]
+	if n == d.blockSizes[1] {
+		leftSlope, rightSlope = d.slopes[1], d.slopes[1]
+	}
+	leftStart, rightStart := 0, n/2
+	if shortLeft {
+		leftSlope, leftStart = d.slopes[0], n/4-d.blockSizes[0]/4
+	}
+	if shortRight {
+		rightSlope, rightStart = d.slopes[0], 3*n/4-d.blockSizes[0]/4
+	}
+	for i := 0; i < leftStart; i++ {
+		block[i] = 0
+	}
+	for i, s := range leftSlope {
+		block[leftStart+i] *= s
+	}
+	for i := range rightSlope {
+		block[rightStart+i] *= rightSlope[len(rightSlope)-1-i]
+	}
+	for i := rightStart + len(rightSlope); i < n; i++ {
+		block[i] = 0
+	}
+}
+
+// overlapAdd adds the first half of the current block to the second half of
+// the previous one, outputting the samples between their centers.
+func (d *Vorbis) overlapAdd(size int) {
+	prevSize := d.prevSize
+	d.prevSize = size
+	if prevSize > 0 {
+		count := prevSize/4 + size/4
+		offset := len(d.pcm.samples)
+		d.pcm.samples = append(d.pcm.samples, make([]float32, count*d.channels)...)
+		out := d.pcm.samples[offset:]
+		for ch := 0; ch < d.channels; ch++ {
+			prev, cur := d.overlap[ch][:prevSize], d.block[ch][:size]
+			for i := 0; i < count; i++ {
+				var s float32
+				if j := prevSize/2 + i; j < prevSize {
+					s = prev[j]
+				}
+				if j := i - prevSize/4 + size/4; j >= 0 {
+					s += cur[j]
+				}
+				out[i*d.channels+ch] = clip(s)
+			}
+		}
+	}
+	d.overlap, d.block = d.block, d.overlap
+}
@@ -0,0 +1,243 @@
+package decode
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Codewords up to this length are decoded by a table lookup
+const vorbisFastBits = 10
+
+// vorbisCodebook is a Huffman code over its entries, optionally mapping each
+// entry to a vector for VQ decoding.
+type vorbisCodebook struct {
+	dimensions int
+	entries    int
+	// entry<<8 | length by the next vorbisFastBits bits, -1 for longer codes
+	fast []int32
+	// tree for longer codes, children are node indexes or -(entry+1) for
+	// leaves, 0 marks a missing child
+	tree    [][2]int32
+	single  int       // the only used entry, -1 if there are more
+	length  int       // codeword length of single
+	vectors []float32 // entries * dimensions, nil without lookup table
+}
+
+func readVorbisCodebook(br *lsbBitReader) (*vorbisCodebook, error) {
+	if br.bits(24) != 0x564342 {
+		return nil, fmt.Errorf("%w: invalid codebook sync pattern", ErrMalformedStream)
+	}
+	c := &vorbisCodebook{dimensions: int(br.bits(16)), entries: int(br.bits(24)), single: -1}
+	lengths := make([]uint8, c.entries)
+	if !br.flag() { // unordered
+		sparse := br.flag()
+		for i := range lengths {
+			if !sparse || br.flag() {
+				lengths[i] = uint8(br.bits(5) + 1)
+			}
+		}
+	} else {
+		length := int(br.bits(5)) + 1
+		for i := 0; i < c.entries; length++ {
+			n := int(br.bits(ilog(uint32(c.entries - i))))
+			if i+n > c.entries || length > 32 {
+				return nil, fmt.Errorf("%w: invalid codebook lengths", ErrMalformedStream)
+			}
+			for ; n > 0; n-- {
+				lengths[i] = uint8(length)
+				i++
+			}
+		}
+	}
+
+	switch lookup := br.bits(4); lookup {
+	case 0:
+	case 1, 2:
+		minimum, delta := float32Unpack(br.bits(32)), float32Unpack(br.bits(32))
+		valueBits, sequence := int(br.bits(4))+1, br.flag()
+		values := c.entries * c.dimensions
+		if lookup == 1 {
+			values = lookup1Values(c.entries, c.dimensions)
+		}
+		if values > len(br.data)*8/valueBits {
+			return nil, fmt.Errorf("%w: codebook lookup table exceeds packet", ErrMalformedStream)
+		}
+		multiplicands := make([]float32, values)
+		for i := range multiplicands {
+			multiplicands[i] = float32(br.bits(valueBits))
+		}
+		c.vectors = make([]float32, c.entries*c.dimensions)
+		for e := 0; e < c.entries; e++ {
+			var last float32
+			divisor := 1
+			for d := 0; d < c.dimensions; d++ {
+				offset := e*c.dimensions + d
+				if lookup == 1 {
+					offset = e / divisor % values
+					divisor *= values
+				}
+				v := multiplicands[offset]*delta + minimum + last
+				if sequence {
+					last = v
+				}
+				c.vectors[e*c.dimensions+d] = v
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: invalid codebook lookup type %d", ErrMalformedStream, lookup)
+	}
+	if br.eop {
+		return nil, fmt.Errorf("%w: truncated codebook", ErrMalformedStream)
+	}
+	return c, c.buildHuffman(lengths)
+}
+
+// buildHuffman assigns the codewords of the given lengths in entry order,
+// each getting the lowest free codeword of its length.
+func (c *vorbisCodebook) buildHuffman(lengths []uint8) error {
+	used := 0
+	for e, length := range lengths {
+		if length > 0 {
+			used++
+			c.single, c.length = e, int(length)
+		}
+	}
+	if used != 1 {
+		c.single = -1
+	}
+
+	c.fast = make([]int32, 1<<vorbisFastBits)
+	for i := range c.fast {
+		c.fast[i] = -1
+	}
+	c.tree = [][2]int32{{}}
+	var next [33]uint32 // next free codeword by length
+	for e, l := range lengths {
+		length := int(l)
+		if length == 0 || used == 1 {
+			continue
+		}
+		code := next[length]
+		if length < 32 && code>>length != 0 {
+			return fmt.Errorf("%w: overspecified codebook", ErrMalformedStream)
+		}
+		// Take code and every longer codeword starting with it
+		for j := length; j > 0; j-- {
+			if next[j]&1 == 1 {
+				if j == 1 {
+					next[1]++
+				} else {
+					next[j] = next[j-1] << 1
+				}
+				break
+			}
+			next[j]++
+		}
+		prefix := code
+		for j := length + 1; j < 33; j++ {
+			if next[j]>>1 != prefix {
+				break
+			}
+			prefix = next[j]
+			next[j] = next[j-1] << 1
+		}
+		c.insert(code, length, e)
+	}
+	return nil
+}
+
+func (c *vorbisCodebook) insert(code uint32, length int, entry int) {
+	// Codewords are read most significant bit first, so the bit reader sees
+	// them reversed
+	reversed := bits.Reverse32(code) >> (32 - length)
+	if length <= vorbisFastBits {
+		for i := reversed; i < 1<<vorbisFastBits; i += 1 << length {
+			c.fast[i] = int32(entry<<8 | length)
+		}
+		return
+	}
+	node := 0
+	for bit := length - 1; bit > 0; bit-- {
+		b := code >> bit & 1
+		if c.tree[node][b] == 0 {
+			c.tree = append(c.tree, [2]int32{})
+			c.tree[node][b] = int32(len(c.tree) - 1)
+		}
+		node = int(c.tree[node][b])
+	}
+	c.tree[node][code&1] = int32(-entry - 1)
+}
+
+// decode reads a codeword and returns its entry, or -1 for invalid codewords
+// and at the end of the packet.
+func (c *vorbisCodebook) decode(br *lsbBitReader) int {
+	if c.single >= 0 {
+		br.skip(c.length)
+		if br.eop {
+			return -1
+		}
+		return c.single
+	}
+	if f := c.fast[br.peek(vorbisFastBits)]; f >= 0 {
+		br.skip(int(f & 0xFF))
+		if br.eop {
+			return -1
+		}
+		return int(f >> 8)
+	}
+	// The prefix of longer codewords is not in the table, walk the tree
+	node := 0
+	for {
+		child := c.tree[node][br.bits(1)]
+		switch {
+		case br.eop || child == 0:
+			return -1
+		case child < 0:
+			return int(-child - 1)
+		}
+		node = int(child)
+	}
+}
+
+// vector reads a codeword and returns the vector of its entry, nil for
+// invalid codewords and at the end of the packet.
+func (c *vorbisCodebook) vector(br *lsbBitReader) []float32 {
+	e := c.decode(br)
+	if e < 0 {
+		return nil
+	}
+	return c.vectors[e*c.dimensions : (e+1)*c.dimensions]
+}
+
+func float32Unpack(x uint32) float32 {
+	mantissa := float64(x & 0x1FFFFF)
+	if x&0x80000000 != 0 {
+		mantissa = -mantissa
+	}
+	return float32(math.Ldexp(mantissa, int(x>>21&0x3FF)-788))
+}
+
+// lookup1Values returns the largest r with r^dimensions <= entries.
+func lookup1Values(entries int, dimensions int) int {
+	r := int(math.Floor(math.Pow(float64(entries), 1/float64(dimensions))))
+	pow := func(r int) int {
+		p := 1
+		for i := 0; i < dimensions; i++ {
+			p *= r
+		}
+		return p
+	}
+	for pow(r+1) <= entries {
+		r++
+	}
+	for r > 0 && pow(r) > entries {
+		r--
+	}
+	return r
+}
+
+// ilog returns the number of bits needed to store x.
+func ilog(x uint32) int {
+	return bits.Len32(x)
+}
@@ -0,0 +1,329 @@
+package decode
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// vorbisFloor computes the spectral envelope of a channel.
+type vorbisFloor interface {
+	// decode reads the floor of a channel from the packet and renders it into
+	// curve, which has half the block size. It returns false if the floor is
+	// unused, so the channel is silent.
+	decode(br *lsbBitReader, books []*vorbisCodebook, curve []float32) bool
+}
+
+func readVorbisFloor(br *lsbBitReader, books []*vorbisCodebook) (vorbisFloor, error) {
+	switch floorType := br.bits(16); floorType {
+	case 0:
+		return readVorbisFloor0(br, books)
+	case 1:
+		return readVorbisFloor1(br, books)
+	default:
+		return nil, fmt.Errorf("%w: invalid floor type %d", ErrMalformedStream, floorType)
+	}
+}
+
+// vorbisFloor0 is a line spectral pair representation of the envelope.
+type vorbisFloor0 struct {
+	order           int
+	rate            int
+	barkMapSize     int
+	amplitudeBits   int
+	amplitudeOffset int
+	books           []int
+	barkMaps        map[int][]int // by curve length
+	coefficients    []float32
+}
+
+func readVorbisFloor0(br *lsbBitReader, books []*vorbisCodebook) (*vorbisFloor0, error) {
+	f := &vorbisFloor0{
+		order:           int(br.bits(8)),
+		rate:            int(br.bits(16)),
+		barkMapSize:     int(br.bits(16)),
+		amplitudeBits:   int(br.bits(6)),
+		amplitudeOffset: int(br.bits(8)),
+		books:           make([]int, br.bits(4)+1),
+		barkMaps:        map[int][]int{},
+	}
+	for i := range f.books {
+		f.books[i] = int(br.bits(8))
+		if f.books[i] >= len(books) || books[f.books[i]].vectors == nil {
+			return nil, fmt.Errorf("%w: invalid floor 0 codebook", ErrMalformedStream)
+		}
+	}
+	if f.order == 0 || f.rate == 0 || f.barkMapSize == 0 || f.amplitudeBits == 0 {
+		return nil, fmt.Errorf("%w: invalid floor 0 configuration", ErrMalformedStream)
+	}
+	return f, nil
+}
+
+func bark(x float64) float64 {
+	return 13.1*math.Atan(0.00074*x) + 2.24*math.Atan(0.0000000185*x*x) + 0.0001*x
+}
+
+func (f *vorbisFloor0) barkMap(n int) []int {
+	if m, ok := f.barkMaps[n]; ok {
+		return m
+	}
+	m := make([]int, n)
+	scale := float64(f.barkMapSize) / bark(0.5*float64(f.rate))
+	for i := range m {
+		m[i] = min(f.barkMapSize-1, int(math.Floor(bark(float64(f.rate*i)/float64(2*n))*scale)))
+	}
+	f.barkMaps[n] = m
+	return m
+}
+
+func (f *vorbisFloor0) decode(br *lsbBitReader, books []*vorbisCodebook, curve []float32) bool {
+	amplitude := int(br.bits(f.amplitudeBits))
+	if amplitude == 0 || br.eop {
+		return false
+	}
+	number := int(br.bits(ilog(uint32(len(f.books)))))
+	if number >= len(f.books) {
+		return false
+	}
+	book := books[f.books[number]]
+	f.coefficients = f.coefficients[:0]
+	var last float32
+	for len(f.coefficients) < f.order {
+		v := book.vector(br)
+		if v == nil {
+			return false
+		}
+		for _, c := range v {
+			f.coefficients = append(f.coefficients, c+last)
+		}
+		last = f.coefficients[len(f.coefficients)-1]
+	}
+
+	m := f.barkMap(len(curve))
+	for i := 0; i < len(curve); {
+		cos := math.Cos(math.Pi * float64(m[i]) / float64(f.barkMapSize))
+		p, q := 1.0, 1.0
+		for j := 0; j < f.order; j++ {
+			d := 4 * (math.Cos(float64(f.coefficients[j])) - cos) * (math.Cos(float64(f.coefficients[j])) - cos)
+			if j%2 == 1 {
+				p *= d
+			} else {
+				q *= d
+			}
+		}
+		if f.order%2 == 1 {
+			p *= 1 - cos*cos
+			q /= 4
+		} else {
+			p *= (1 - cos) / 2
+			q *= (1 + cos) / 2
+		}
+		value := float32(math.Exp(0.11512925 * (float64(amplitude*f.amplitudeOffset)/
+			(float64(int(1)<<f.amplitudeBits-1)*math.Sqrt(p+q)) - float64(f.amplitudeOffset))))
+		// Frequencies mapping to the same bark value share the floor value
+		for current := m[i]; i < len(curve) && m[i] == current; i++ {
+			curve[i] = value
+		}
+	}
+	return true
+}
+
+type vorbisFloor1Class struct {
+	dimensions int
+	subclasses int // in bits
+	masterbook int
+	books      []int // by subclass, -1 for none
+}
+
+// vorbisFloor1 is a piecewise linear representation of the envelope in dB.
+type vorbisFloor1 struct {
+	partitions []int // class by partition
+	classes    []vorbisFloor1Class
+	multiplier int
+	rangeBits  int
+	xs         []int
+	order      []int // of xs sorted ascending
+	low, high  []int // neighbors of each x
+	// scratch space of decode
+	ys    []int
+	step2 []bool
+}
+
+// Ranges of the y values by multiplier
+var vorbisFloor1Ranges = [4]int{256, 128, 86, 64}
+
+func readVorbisFloor1(br *lsbBitReader, books []*vorbisCodebook) (*vorbisFloor1, error) {
+	f := &vorbisFloor1{partitions: make([]int, br.bits(5))}
+	maxClass := -1
+	for i := range f.partitions {
+		f.partitions[i] = int(br.bits(4))
+		maxClass = max(maxClass, f.partitions[i])
+	}
+	validBook := func(book int) bool {
+		return book < len(books)
+	}
+	f.classes = make([]vorbisFloor1Class, maxClass+1)
+	for i := range f.classes {
+		c := &f.classes[i]
+		c.dimensions = int(br.bits(3)) + 1
+		c.subclasses = int(br.bits(2))
+		if c.subclasses > 0 {
+			c.masterbook = int(br.bits(8))
+			if !validBook(c.masterbook) {
+				return nil, fmt.Errorf("%w: invalid floor 1 codebook", ErrMalformedStream)
+			}
+		}
+		c.books = make([]int, 1<<c.subclasses)
+		for j := range c.books {
+			c.books[j] = int(br.bits(8)) - 1
+			if c.books[j] >= 0 && !validBook(c.books[j]) {
+				return nil, fmt.Errorf("%w: invalid floor 1 codebook", ErrMalformedStream)
+			}
+		}
+	}
+	f.multiplier = int(br.bits(2)) + 1
+	f.rangeBits = int(br.bits(4))
+	f.xs = []int{0, 1 << f.rangeBits}
+	for _, class := range f.partitions {
+		for j := 0; j < f.classes[class].dimensions; j++ {
+			f.xs = append(f.xs, int(br.bits(f.rangeBits)))
+		}
+	}
+	if len(f.xs) > 65 {
+		return nil, fmt.Errorf("%w: too many floor 1 values", ErrMalformedStream)
+	}
+
+	f.order = make([]int, len(f.xs))
+	for i := range f.order {
+		f.order[i] = i
+	}
+	sort.SliceStable(f.order, func(a, b int) bool { return f.xs[f.order[a]] < f.xs[f.order[b]] })
+	for i := 1; i < len(f.order); i++ {
+		if f.xs[f.order[i]] == f.xs[f.order[i-1]] {
+			return nil, fmt.Errorf("%w: duplicate floor 1 x value", ErrMalformedStream)
+		}
+	}
+	f.low, f.high = make([]int, len(f.xs)), make([]int, len(f.xs))
+	for i := 2; i < len(f.xs); i++ {
+		low, high := 0, 1
+		for j := 0; j < i; j++ {
+			if f.xs[j] < f.xs[i] && f.xs[j] > f.xs[low] {
+				low = j
+			}
+			if f.xs[j] > f.xs[i] && f.xs[j] < f.xs[high] {
+				high = j
+			}
+		}
+		f.low[i], f.high[i] = low, high
+	}
+	f.ys, f.step2 = make([]int, len(f.xs)), make([]bool, len(f.xs))
+	return f, nil
+}
+
+func (f *vorbisFloor1) decode(br *lsbBitReader, books []*vorbisCodebook, curve []float32) bool {
+	if !br.flag() {
+		return false
+	}
+	yRange := vorbisFloor1Ranges[f.multiplier-1]
+	ys := f.ys
+	ys[0], ys[1] = int(br.bits(ilog(uint32(yRange-1)))), int(br.bits(ilog(uint32(yRange-1))))
+	offset := 2
+	for _, class := range f.partitions {
+		c := &f.classes[class]
+		value := 0
+		if c.subclasses > 0 {
+			value = books[c.masterbook].decode(br)
+		}
+		for j := 0; j < c.dimensions; j++ {
+			book := c.books[value&(1<<c.subclasses-1)]
+			value >>= c.subclasses
+			ys[offset+j] = 0
+			if book >= 0 {
+				ys[offset+j] = books[book].decode(br)
+			}
+		}
+		offset += c.dimensions
+	}
+	if br.eop {
+		return false
+	}
+
+	// Unwrap the y values, which are offsets to the line between the
+	// neighbors
+	f.step2[0], f.step2[1] = true, true
+	for i := 2; i < len(f.xs); i++ {
+		low, high := f.low[i], f.high[i]
+		predicted := renderPoint(f.xs[low], ys[low], f.xs[high], ys[high], f.xs[i])
+		value, highRoom, lowRoom := ys[i], yRange-predicted, predicted
+		room := 2 * min(highRoom, lowRoom)
+		f.step2[i] = value != 0
+		switch {
+		case value == 0:
+			ys[i] = predicted
+			continue
+		case value >= room && highRoom > lowRoom:
+			ys[i] = value - lowRoom + predicted
+		case value >= room:
+			ys[i] = predicted - value + highRoom - 1
+		case value%2 == 1:
+			ys[i] = predicted - (value+1)/2
+		default:
+			ys[i] = predicted + value/2
+		}
+		f.step2[low], f.step2[high] = true, true
+	}
+
+	lx, ly := 0, ys[0]*f.multiplier
+	for _, i := range f.order[1:] {
+		if f.step2[i] {
+			hx, hy := f.xs[i], ys[i]*f.multiplier
+			renderLine(lx, ly, hx, hy, curve)
+			lx, ly = hx, hy
+		}
+	}
+	if lx < len(curve) {
+		renderLine(lx, ly, len(curve), ly, curve)
+	}
+	return true
+}
+
+func renderPoint(x0 int, y0 int, x1 int, y1 int, x int) int {
+	dy := y1 - y0
+	offset := abs(dy) * (x - x0) / (x1 - x0)
+	if dy < 0 {
+		return y0 - offset
+	}
+	return y0 + offset
+}
+
+// renderLine draws the line from (x0, y0) to (x1, y1) into curve, mapping
+// the y values from dB to linear amplitudes.
+func renderLine(x0 int, y0 int, x1 int, y1 int, curve []float32) {
+	dy, dx := y1-y0, x1-x0
+	base := dy / dx
+	step := base + 1
+	if dy < 0 {
+		step = base - 1
+	}
+	ady := abs(dy) - abs(base)*dx
+	y, err := y0, 0
+	for x := x0; x < min(x1, len(curve)); x++ {
+		if x > x0 {
+			err += ady
+			if err >= dx {
+				err -= dx
+				y += step
+			} else {
+				y += base
+			}
+		}
+		curve[x] = vorbisInverseDB[max(0, min(255, y))]
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
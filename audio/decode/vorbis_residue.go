@@ -0,0 +1,168 @@
+package decode
+
+import "fmt"
+
+// vorbisResidue is the fine structure of the spectrum, vector quantized in
+// partitions which are classified to select the codebooks.
+type vorbisResidue struct {
+	residueType     int
+	begin, end      int
+	partitionSize   int
+	classifications int
+	classbook       int
+	books           [][8]int // by classification and pass, -1 for none
+	// scratch space of decode
+	classes    [][]int
+	interleave []float32
+}
+
+func readVorbisResidue(br *lsbBitReader, books []*vorbisCodebook) (*vorbisResidue, error) {
+	r := &vorbisResidue{residueType: int(br.bits(16))}
+	if r.residueType > 2 {
+		return nil, fmt.Errorf("%w: invalid residue type %d", ErrMalformedStream, r.residueType)
+	}
+	r.begin, r.end = int(br.bits(24)), int(br.bits(24))
+	r.partitionSize = int(br.bits(24)) + 1
+	r.classifications = int(br.bits(6)) + 1
+	r.classbook = int(br.bits(8))
+	if r.classbook >= len(books) || books[r.classbook].dimensions == 0 {
+		return nil, fmt.Errorf("%w: invalid residue classbook", ErrMalformedStream)
+	}
+	cascades := make([]int, r.classifications)
+	for i := range cascades {
+		cascades[i] = int(br.bits(3))
+		if br.flag() {
+			cascades[i] |= int(br.bits(5)) << 3
+		}
+	}
+	r.books = make([][8]int, r.classifications)
+	for i, cascade := range cascades {
+		for pass := range r.books[i] {
+			r.books[i][pass] = -1
+			if cascade>>pass&1 == 1 {
+				book := int(br.bits(8))
+				if book >= len(books) || books[book].vectors == nil || books[book].dimensions == 0 {
+					return nil, fmt.Errorf("%w: invalid residue codebook", ErrMalformedStream)
+				}
+				r.books[i][pass] = book
+			}
+		}
+	}
+	return r, nil
+}
+
+// decode reads the residue vectors of the channels in a submap, adding them to
+// vectors. Channels with a set skip flag are not coded.
+func (r *vorbisResidue) decode(br *lsbBitReader, books []*vorbisCodebook, vectors [][]float32, skip []bool) {
+	if r.residueType != 2 {
+		r.decodeVectors(br, books, vectors, skip)
+		return
+	}
+
+	// All channels are coded interleaved in a single vector
+	coded := false
+	for _, s := range skip {
+		coded = coded || !s
+	}
+	if !coded {
+		return
+	}
+	channels, n := len(vectors), len(vectors[0])
+	if cap(r.interleave) < n*channels {
+		r.interleave = make([]float32, n*channels)
+	}
+	v := r.interleave[:n*channels]
+	clear(v)
+	r.decodeVectors(br, books, [][]float32{v}, []bool{false})
+	for i := 0; i < n; i++ {
+		for ch := range vectors {
+			vectors[ch][i] += v[i*channels+ch]
+		}
+	}
+}
+
+func (r *vorbisResidue) decodeVectors(br *lsbBitReader, books []*vorbisCodebook, vectors [][]float32, skip []bool) {
+	begin, end := min(r.begin, len(vectors[0])), min(r.end, len(vectors[0]))
+	partitions := (end - begin) / r.partitionSize
+	if partitions <= 0 {
+		return
+	}
+	classbook := books[r.classbook]
+	perCodeword := classbook.dimensions
+	for len(r.classes) < len(vectors) {
+		r.classes = append(r.classes, nil)
+	}
+	for ch := range vectors {
+		if len(r.classes[ch]) < partitions+perCodeword {
+			r.classes[ch] = make([]int, partitions+perCodeword)
+		}
+	}
+
+	for pass := 0; pass < 8; pass++ {
+		for partition := 0; partition < partitions; {
+			if pass == 0 {
+				for ch := range vectors {
+					if skip[ch] {
+						continue
+					}
+					value := classbook.decode(br)
+					if value < 0 {
+						return
+					}
+					for i := perCodeword - 1; i >= 0; i-- {
+						r.classes[ch][partition+i] = value % r.classifications
+						value /= r.classifications
+					}
+				}
+			}
+			for i := 0; i < perCodeword && partition < partitions; i++ {
+				for ch, v := range vectors {
+					if skip[ch] {
+						continue
+					}
+					book := r.books[r.classes[ch][partition]][pass]
+					if book < 0 {
+						continue
+					}
+					offset := begin + partition*r.partitionSize
+					if !r.decodePartition(br, books[book], v[offset:offset+r.partitionSize]) {
+						return
+					}
+				}
+				partition++
+			}
+		}
+	}
+}
+
+// decodePartition adds the vectors of a partition to v, returning false at the
+// end of the packet.
+func (r *vorbisResidue) decodePartition(br *lsbBitReader, book *vorbisCodebook, v []float32) bool {
+	if r.residueType == 0 {
+		// The vector elements are interleaved over the partition
+		step := len(v) / book.dimensions
+		for i := 0; i < step; i++ {
+			entry := book.vector(br)
+			if entry == nil {
+				return false
+			}
+			for j, e := range entry {
+				v[i+j*step] += e
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(v); {
+		entry := book.vector(br)
+		if entry == nil {
+			return false
+		}
+		for _, e := range entry {
+			if i < len(v) {
+				v[i] += e
+			}
+			i++
+		}
+	}
+	return true
+}
@@ -0,0 +1,247 @@
+package decode_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/ogg"
+)
+
+// Test stream parameters: 8000 Hz mono with blocks of 256 samples, each
+// packet finishing 128 samples
+const (
+	vorbisBlockSize = 256
+	vorbisToneBin   = 5
+)
+
+// lsbBitWriter appends little endian bit fields, least significant bit first,
+// to data.
+type lsbBitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *lsbBitWriter) write(v uint32, bits int) {
+	for i := 0; i < bits; i++ {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(v>>i&1) << (w.n % 8)
+		w.n++
+	}
+}
+
+func vorbisHeader(packetType byte, content []byte) []byte {
+	return append(append([]byte{packetType}, "vorbis"...), content...)
+}
+
+// vorbisSetup has a single codebook with the entries 0 and 1 as one bit
+// codewords, a flat floor and a residue coding every spectral line with that
+// codebook.
+func vorbisSetup() []byte {
+	w := &lsbBitWriter{}
+	w.write(0, 8) // codebooks - 1
+	w.write(0x564342, 24)
+	w.write(1, 16) // dimensions
+	w.write(2, 24) // entries
+	w.write(0, 2)  // unordered, not sparse
+	w.write(0, 5)  // length 1
+	w.write(0, 5)
+	w.write(1, 4)              // lookup type
+	w.write(0, 32)             // minimum 0
+	w.write(768<<21|1<<20, 32) // delta 1
+	w.write(0, 4)              // value bits - 1
+	w.write(0, 1)              // no sequence
+	w.write(0, 1)              // multiplicands
+	w.write(1, 1)
+
+	w.write(0, 6) // time domain transforms - 1
+	w.write(0, 16)
+	w.write(0, 6)  // floors - 1
+	w.write(1, 16) // floor type
+	w.write(0, 5)  // partitions
+	w.write(0, 2)  // multiplier - 1
+	w.write(7, 4)  // range bits, the x values are 0 and 128
+
+	w.write(0, 6)    // residues - 1
+	w.write(1, 16)   // residue type
+	w.write(0, 24)   // begin
+	w.write(128, 24) // end
+	w.write(127, 24) // partition size - 1
+	w.write(0, 6)    // classifications - 1
+	w.write(0, 8)    // classbook
+	w.write(1, 3)    // cascade: only the first pass
+	w.write(0, 1)
+	w.write(0, 8) // book of the first pass
+
+	w.write(0, 6)  // mappings - 1
+	w.write(0, 16) // mapping type
+	w.write(0, 2)  // no submaps, no coupling
+	w.write(0, 2)
+	w.write(0, 8) // submap
+	w.write(0, 8)
+	w.write(0, 8)
+
+	w.write(0, 6) // modes - 1
+	w.write(0, 1) // short blocks
+	w.write(0, 32)
+	w.write(0, 8)
+	w.write(1, 1) // framing
+	return vorbisHeader(5, w.data)
+}
+
+// toneVorbisPacket has a single spectral line of amplitude 1.
+func toneVorbisPacket() []byte {
+	return vorbisPacket(func(line int) bool { return line == vorbisToneBin })
+}
+
+// vorbisPacket has the spectral lines for which set returns true at amplitude
+// 1, all others at 0.
+func vorbisPacket(set func(line int) bool) []byte {
+	w := &lsbBitWriter{}
+	w.write(0, 1)   // audio packet
+	w.write(1, 1)   // floor used
+	w.write(255, 8) // 0 dB from 0 to 128
+	w.write(255, 8)
+	w.write(0, 1) // class 0
+	for i := 0; i < vorbisBlockSize/2; i++ {
+		if set(i) {
+			w.write(1, 1)
+		} else {
+			w.write(0, 1)
+		}
+	}
+	return w.data
+}
+
+// vorbisStream builds a stream with the audio packets split into pages at
+// the given indexes, each page ending at the given granule position.
+func vorbisStream(setup []byte, packets [][]byte, splits []int, granules []int64) []byte {
+	id := binaryLE(0, 4)                  // version
+	id = append(id, 1)                    // channels
+	id = append(id, binaryLE(8000, 4)...) // sample rate
+	id = append(id, make([]byte, 12)...)  // bitrates
+	id = append(id, 0x88, 1)              // block sizes 256/256, framing
+
+	pages := ogg.Paginate([][]byte{vorbisHeader(1, id)}, 1, 0, 0)
+	pages[0].HeaderType = ogg.HEADER_BOS
+	pages = append(pages, ogg.Paginate([][]byte{vorbisHeader(3, make([]byte, 8)), setup}, 1, 1, 0)...)
+	start := 0
+	for i, split := range append(splits, len(packets)) {
+		pages = append(pages, ogg.Paginate(packets[start:split], 1, uint32(len(pages)), granules[i])...)
+		start = split
+	}
+	pages[len(pages)-1].HeaderType |= ogg.HEADER_EOS
+
+	var content []byte
+	for _, page := range pages {
+		content = append(content, page.Bytes()...)
+	}
+	return content
+}
+
+func binaryLE(v uint32, size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+	return b
+}
+
+// expectedTone returns the samples between the centers of two consecutive
+// tone blocks, calculated with the definitions of the IMDCT and the window.
+func expectedTone() []float64 {
+	n := vorbisBlockSize
+	block := make([]float64, n)
+	for i := range block {
+		x := float64(min(i, n-1-i))
+		s := math.Sin((x + 0.5) / float64(n/2) * math.Pi / 2)
+		window := math.Sin(math.Pi / 2 * s * s)
+		block[i] = window * math.Cos(2*math.Pi/float64(n)*(float64(i)+0.5+float64(n)/4)*(vorbisToneBin+0.5))
+	}
+	tone := make([]float64, n/2)
+	for i := range tone {
+		tone[i] = block[n/2+i] + block[i]
+	}
+	return tone
+}
+
+func TestVorbis(t *testing.T) {
+	packets := make([][]byte, 6)
+	for i := range packets {
+		packets[i] = toneVorbisPacket()
+	}
+	tests := []struct {
+		name     string
+		splits   []int
+		granules []int64
+		skipped  int // samples trimmed from the start
+		length   int
+	}{
+		{"single page", nil, []int64{640}, 0, 640},
+		{"trimmed end", []int{3}, []int64{256, 600}, 0, 600},
+		{"trimmed start", []int{3}, []int64{200, 584}, 56, 584},
+	}
+	tone := expectedTone()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := decode.Open(writeTestFile(t, "test.ogg", vorbisStream(vorbisSetup(), packets, test.splits, test.granules)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer d.Close()
+			if d.SampleRate() != 8000 || d.Channels() != 1 {
+				t.Errorf("expected 8000 Hz mono, but got %d Hz with %d channels", d.SampleRate(), d.Channels())
+			}
+			samples := readAll(t, d)
+			if len(samples) != test.length {
+				t.Fatalf("expected %d samples, but got %d", test.length, len(samples))
+			}
+			for i, s := range samples {
+				if expected := tone[(i+test.skipped)%len(tone)]; math.Abs(float64(s)-expected) > 1e-4 {
+					t.Fatalf("expected sample %d to be %f, but got %f", i, expected, s)
+				}
+			}
+		})
+	}
+}
+
+func TestVorbisClipped(t *testing.T) {
+	// All spectral lines at full amplitude add up to far above full scale
+	packets := make([][]byte, 4)
+	for i := range packets {
+		packets[i] = vorbisPacket(func(int) bool { return true })
+	}
+	d, err := decode.Open(writeTestFile(t, "test.ogg", vorbisStream(vorbisSetup(), packets, nil, []int64{384})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.Close()
+	var peak float64
+	for _, s := range readAll(t, d) {
+		peak = max(peak, math.Abs(float64(s)))
+	}
+	if peak != 1 {
+		t.Errorf("expected the samples to be clipped to 1, but got a peak of %f", peak)
+	}
+}
+
+func TestVorbisUnsupported(t *testing.T) {
+	page := ogg.Paginate([][]byte{[]byte("OpusHead\x01\x02\x38\x01\x80\xBB\x00\x00\x00\x00\x00")}, 1, 0, 0)[0]
+	page.HeaderType = ogg.HEADER_BOS
+	if _, err := decode.Open(writeTestFile(t, "test.opus", page.Bytes())); !errors.Is(err, decode.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
+
+func TestVorbisMalformedSetup(t *testing.T) {
+	setup := vorbisSetup()
+	setup[8] ^= 0xFF // codebook sync pattern
+	content := vorbisStream(setup, [][]byte{toneVorbisPacket()}, nil, []int64{0})
+	if _, err := decode.NewVorbis(bytes.NewReader(content)); !errors.Is(err, decode.ErrMalformedStream) {
+		t.Errorf("expected ErrMalformedStream, but got %v", err)
+	}
+}
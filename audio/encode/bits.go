@@ -0,0 +1,50 @@
+package encode
+
+// bitWriter writes big endian bit fields into a byte slice.
+type bitWriter struct {
+	data  []byte
+	cache uint64 // n bits not yet written to data, right aligned
+	n     int
+}
+
+// write writes the n <= 32 low bits of v.
+func (b *bitWriter) write(v uint64, n int) {
+	b.cache = b.cache<<n | v&(1<<n-1)
+	b.n += n
+	for b.n >= 8 {
+		b.n -= 8
+		b.data = append(b.data, byte(b.cache>>b.n))
+	}
+}
+
+// signed writes v as an n <= 32 bit two's complement number.
+func (b *bitWriter) signed(v int64, n int) {
+	b.write(uint64(v), n)
+}
+
+// unary writes v zero bits followed by a one bit.
+func (b *bitWriter) unary(v uint64) {
+	for ; v >= 32; v -= 32 {
+		b.write(0, 32)
+	}
+	b.write(1, int(v)+1)
+}
+
+// rice writes v Rice coded with parameter k.
+func (b *bitWriter) rice(v int64, k int) {
+	u := zigzag(v)
+	b.unary(u >> k)
+	b.write(u, k)
+}
+
+// align pads the data with zero bits to a whole byte.
+func (b *bitWriter) align() {
+	if b.n > 0 {
+		b.write(0, 8-b.n)
+	}
+}
+
+// zigzag maps signed values to unsigned ones: 0, -1, 1, -2 to 0, 1, 2, 3.
+func zigzag(v int64) uint64 {
+	return uint64(v<<1 ^ v>>63)
+}
@@ -0,0 +1,64 @@
+package encode
+
+import (
+	"errors"
+	"io"
+	"math"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// Encoders turning PCM samples into the audio data of music files, without
+// cgo or external programs. They are the counterpart of the decode package.
+
+var (
+	ErrInvalidFormat = errors.New("invalid audio format")
+	ErrInvalidLevel  = errors.New("invalid compression level")
+	ErrTooLarge      = errors.New("audio too large for the format")
+)
+
+// Format describes the samples of an encoded stream.
+type Format struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+}
+
+// Encoder encodes interleaved PCM samples in the range [-1, 1], samples
+// outside of it are clipped.
+type Encoder interface {
+	// Write encodes samples, whose number has to be a multiple of the number
+	// of channels.
+	Write(samples []float32) error
+	// Close encodes the samples still buffered and completes the headers. It
+	// does not close the underlying writer.
+	Close() error
+}
+
+// Encode encodes all audio of d with e and closes e.
+func Encode(e Encoder, d decode.Decoder) error {
+	buf := make([]float32, 4096*d.Channels())
+	for {
+		n, err := d.Read(buf)
+		if n > 0 {
+			if err := e.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// quantize converts s to an integer sample of the given size. Samples decoded
+// from integer samples of up to 24 bits are converted back exactly.
+func quantize(s float32, bits int) int64 {
+	scale := float64(int64(1) << (bits - 1))
+	v := math.Round(float64(s) * scale)
+	return int64(max(-scale, min(scale-1, v)))
+}
@@ -0,0 +1,92 @@
+package encode_test
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/encode"
+)
+
+// signal returns frames of interleaved samples of the given size: a chord
+// with a little noise, different in every channel, and a silent start.
+func signal(frames int, channels int, bits int) []float32 {
+	rng := rand.New(rand.NewSource(1))
+	scale := float64(int64(1) << (bits - 1))
+	samples := make([]float32, 0, frames*channels)
+	for i := 0; i < frames; i++ {
+		for ch := 0; ch < channels; ch++ {
+			var v float64
+			if i >= 100 {
+				t := float64(i) / 44100
+				v = 0.3*math.Sin(2*math.Pi*440*t+float64(ch)) + 0.2*math.Sin(2*math.Pi*660*t) + 0.0003*rng.NormFloat64()
+			}
+			samples = append(samples, float32(math.Round(v*scale)/scale))
+		}
+	}
+	return samples
+}
+
+// encodeFile encodes samples with the encoder returned by create into a file
+// and returns its path.
+func encodeFile(t *testing.T, samples []float32, channels int, create func(w io.WriteSeeker) (encode.Encoder, error)) string {
+	path := filepath.Join(t.TempDir(), "encoded")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	defer f.Close()
+	e, err := create(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// In pieces which do not line up with frames
+	for len(samples) > 0 {
+		n := min(len(samples), 1234*channels)
+		if err := e.Write(samples[:n]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		samples = samples[n:]
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+// decodeFile decodes all samples of the file at path.
+func decodeFile(t *testing.T, path string) (*decode.File, []float32) {
+	d, err := decode.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open encoded file: %v", err)
+	}
+	defer d.Close()
+	var samples []float32
+	buf := make([]float32, 1000*d.Channels())
+	for {
+		n, err := d.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return d, samples
+		}
+		if err != nil {
+			t.Fatalf("failed to decode encoded file: %v", err)
+		}
+	}
+}
+
+func assertSamples(t *testing.T, expected []float32, actual []float32) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d samples, but got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected sample %d to be %v, but got %v", i, expected[i], actual[i])
+		}
+	}
+}
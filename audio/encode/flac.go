@@ -0,0 +1,355 @@
+package encode
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// https://xiph.org/flac/format.html
+
+// Compression levels of the FLAC encoder, like those of the reference encoder
+const (
+	MIN_LEVEL     = 0
+	MAX_LEVEL     = 8
+	DEFAULT_LEVEL = 5
+)
+
+const (
+	flacBlockStreamInfo = 0
+	flacLastBlockFlag   = 0x80
+	flacStreamInfoSize  = 34
+
+	// Channel assignments of stereo decorrelation
+	flacLeftSide  = 8
+	flacRightSide = 9
+	flacMidSide   = 10
+)
+
+// flacLevel are the encoder settings of a compression level.
+type flacLevel struct {
+	blockSize         int  // samples per channel in a frame
+	stereo            bool // try to decorrelate stereo channels
+	maxLPCOrder       int  // 0 for fixed predictors only
+	exhaustive        bool // try every LPC order instead of estimating the best one
+	maxPartitionOrder int
+}
+
+// Settings by compression level, close to those of the reference encoder.
+// Every level tries all fixed predictors and keeps the smallest subframe.
+var flacLevels = [MAX_LEVEL + 1]flacLevel{
+	{blockSize: 1152, maxPartitionOrder: 3},
+	{blockSize: 1152, stereo: true, maxPartitionOrder: 3},
+	{blockSize: 1152, stereo: true, maxPartitionOrder: 4},
+	{blockSize: 4096, maxLPCOrder: 6, maxPartitionOrder: 4},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 8, maxPartitionOrder: 4},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 8, maxPartitionOrder: 5},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 8, maxPartitionOrder: 6},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 12, maxPartitionOrder: 6},
+	{blockSize: 4096, stereo: true, maxLPCOrder: 12, exhaustive: true, maxPartitionOrder: 8},
+}
+
+// Sample rate codes of frame headers, rates without one are stored in the
+// header or taken from the stream info
+var flacSampleRateCodes = map[int]uint64{88200: 1, 176400: 2, 192000: 3, 8000: 4, 16000: 5, 22050: 6, 24000: 7, 32000: 8, 44100: 9, 48000: 10, 96000: 11}
+
+// Sample size codes of frame headers, sizes without one are taken from the
+// stream info
+var flacSampleSizeCodes = map[int]uint64{8: 1, 12: 2, 16: 4, 20: 5, 24: 6}
+
+// FLAC encodes a FLAC stream with fixed size frames, which only contains the
+// STREAMINFO metadata block. Tags and pictures are added by the tags package.
+// The frame sizes, number of samples and MD5 signature of the stream info are
+// only known once all samples are encoded, Close fills them in, so it needs
+// an io.WriteSeeker.
+type FLAC struct {
+	w            io.WriteSeeker
+	format       Format
+	level        flacLevel
+	block        [][]int64 // samples of the current frame by channel
+	n            int       // samples per channel in block
+	frame        uint64    // number of the next frame
+	totalSamples int64     // per channel
+	minFrameSize int
+	maxFrameSize int
+	hash         hash.Hash
+	raw          []byte
+}
+
+// NewFLAC writes the header of a FLAC stream with format to w and returns an
+// encoder for its samples, compressing them with level between MIN_LEVEL and
+// MAX_LEVEL.
+func NewFLAC(w io.WriteSeeker, format Format, level int) (*FLAC, error) {
+	if format.SampleRate <= 0 || format.SampleRate > 655350 || format.Channels <= 0 || format.Channels > 8 || format.BitsPerSample < 4 || format.BitsPerSample > 24 {
+		return nil, fmt.Errorf("%w: %d Hz with %d channels of %d bits for FLAC", ErrInvalidFormat, format.SampleRate, format.Channels, format.BitsPerSample)
+	}
+	if level < MIN_LEVEL || level > MAX_LEVEL {
+		return nil, fmt.Errorf("%w: %d is not between %d and %d", ErrInvalidLevel, level, MIN_LEVEL, MAX_LEVEL)
+	}
+	e := &FLAC{w: w, format: format, level: flacLevels[level], hash: md5.New()}
+	e.block = make([][]int64, format.Channels)
+	for ch := range e.block {
+		e.block[ch] = make([]int64, e.level.blockSize)
+	}
+
+	header := append([]byte("fLaC"), flacBlockStreamInfo|flacLastBlockFlag, 0, 0, flacStreamInfoSize)
+	if _, err := w.Write(append(header, e.streamInfo()...)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// streamInfo returns the STREAMINFO block of the samples encoded so far.
+func (e *FLAC) streamInfo() []byte {
+	blockSize := e.level.blockSize
+	if e.totalSamples > 0 && e.totalSamples < int64(blockSize) {
+		blockSize = max(16, int(e.totalSamples)) // a single, short frame
+	}
+	var b bitWriter
+	b.write(uint64(blockSize), 16) // minimum, the last frame does not count
+	b.write(uint64(blockSize), 16)
+	b.write(uint64(e.minFrameSize), 24)
+	b.write(uint64(e.maxFrameSize), 24)
+	b.write(uint64(e.format.SampleRate), 20)
+	b.write(uint64(e.format.Channels-1), 3)
+	b.write(uint64(e.format.BitsPerSample-1), 5)
+	b.write(uint64(e.totalSamples>>32), 4)
+	b.write(uint64(e.totalSamples), 32)
+	if e.totalSamples > 0 {
+		return e.hash.Sum(b.data)
+	}
+	return append(b.data, make([]byte, md5.Size)...)
+}
+
+func (e *FLAC) Write(samples []float32) error {
+	channels, bits := e.format.Channels, e.format.BitsPerSample
+	for i := 0; i+channels <= len(samples); i += channels {
+		for ch, s := range samples[i : i+channels] {
+			e.block[ch][e.n] = quantize(s, bits)
+		}
+		e.n++
+		if e.n == e.level.blockSize {
+			if err := e.encodeFrame(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *FLAC) Close() error {
+	if e.n > 0 {
+		if err := e.encodeFrame(); err != nil {
+			return err
+		}
+	}
+	if _, err := e.w.Seek(8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(e.streamInfo()); err != nil {
+		return err
+	}
+	_, err := e.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// encodeFrame encodes the samples of the current block as a frame.
+func (e *FLAC) encodeFrame() error {
+	if e.totalSamples+int64(e.n) >= 1<<36 {
+		return fmt.Errorf("%w: FLAC streams are limited to 2^36 samples", ErrTooLarge)
+	}
+	block := make([][]int64, e.format.Channels)
+	for ch := range block {
+		block[ch] = e.block[ch][:e.n]
+	}
+	e.updateHash(block)
+
+	assignment := uint64(e.format.Channels - 1)
+	var subframes []*flacSubframe
+	bits := e.format.BitsPerSample
+	if e.format.Channels == 2 && e.level.stereo {
+		assignment, subframes = e.decorrelate(block[0], block[1])
+	} else {
+		for _, samples := range block {
+			subframes = append(subframes, newFLACSubframe(samples, bits, e.level))
+		}
+	}
+
+	var b bitWriter
+	b.write(0xFFF8, 16) // sync code, fixed block size
+	blockSizeCode, blockSizeBits := flacBlockSizeCode(e.n)
+	b.write(blockSizeCode, 4)
+	rateCode, rateBits := flacSampleRateCode(e.format.SampleRate)
+	b.write(rateCode, 4)
+	b.write(assignment, 4)
+	b.write(flacSampleSizeCodes[bits], 3)
+	b.write(0, 1)
+	b.data = append(b.data, utf8Number(e.frame)...)
+	if blockSizeBits > 0 {
+		b.write(uint64(e.n-1), blockSizeBits)
+	}
+	switch rateBits {
+	case 8:
+		b.write(uint64(e.format.SampleRate/1000), 8)
+	case 16:
+		if rateCode == 13 {
+			b.write(uint64(e.format.SampleRate), 16)
+		} else {
+			b.write(uint64(e.format.SampleRate/10), 16)
+		}
+	}
+	b.write(uint64(crc8(b.data)), 8)
+
+	for _, s := range subframes {
+		s.write(&b)
+	}
+	b.align()
+	b.write(uint64(crc16(b.data)), 16)
+
+	if _, err := e.w.Write(b.data); err != nil {
+		return err
+	}
+	if e.frame == 0 || len(b.data) < e.minFrameSize {
+		e.minFrameSize = len(b.data)
+	}
+	e.maxFrameSize = max(e.maxFrameSize, len(b.data))
+	e.frame++
+	e.totalSamples += int64(e.n)
+	e.n = 0
+	return nil
+}
+
+// decorrelate returns the channel assignment and subframes of the smallest
+// encoding of a stereo block: left and right, left and side, side and right
+// or mid and side.
+func (e *FLAC) decorrelate(left []int64, right []int64) (uint64, []*flacSubframe) {
+	mid, side := make([]int64, len(left)), make([]int64, len(left))
+	for i := range left {
+		mid[i], side[i] = (left[i]+right[i])>>1, left[i]-right[i]
+	}
+	bits := e.format.BitsPerSample
+	l := newFLACSubframe(left, bits, e.level)
+	r := newFLACSubframe(right, bits, e.level)
+	m := newFLACSubframe(mid, bits, e.level)
+	s := newFLACSubframe(side, bits+1, e.level) // the side channel needs an extra bit
+
+	assignment, subframes, size := uint64(1), []*flacSubframe{l, r}, l.size+r.size
+	if l.size+s.size < size {
+		assignment, subframes, size = flacLeftSide, []*flacSubframe{l, s}, l.size+s.size
+	}
+	if s.size+r.size < size {
+		assignment, subframes, size = flacRightSide, []*flacSubframe{s, r}, s.size+r.size
+	}
+	if m.size+s.size < size {
+		assignment, subframes = flacMidSide, []*flacSubframe{m, s}
+	}
+	return assignment, subframes
+}
+
+// updateHash adds the samples of block to the MD5 signature, as little
+// endian integers of whole bytes.
+func (e *FLAC) updateHash(block [][]int64) {
+	bytesPerSample := (e.format.BitsPerSample + 7) / 8
+	e.raw = e.raw[:0]
+	for i := 0; i < e.n; i++ {
+		for _, samples := range block {
+			for b := 0; b < bytesPerSample; b++ {
+				e.raw = append(e.raw, byte(samples[i]>>(8*b)))
+			}
+		}
+	}
+	e.hash.Write(e.raw)
+}
+
+// flacBlockSizeCode returns the block size code of frame headers and the
+// number of bits of the block size following the header, 0 if the code
+// describes it.
+func flacBlockSizeCode(n int) (uint64, int) {
+	switch {
+	case n == 192:
+		return 1, 0
+	case n%576 == 0 && n/576&(n/576-1) == 0 && n <= 4608:
+		for code := uint64(2); code <= 5; code++ {
+			if 576<<(code-2) == n {
+				return code, 0
+			}
+		}
+	case n%256 == 0 && n/256&(n/256-1) == 0 && n <= 32768:
+		for code := uint64(8); code <= 15; code++ {
+			if 256<<(code-8) == n {
+				return code, 0
+			}
+		}
+	case n <= 256:
+		return 6, 8
+	}
+	return 7, 16
+}
+
+// flacSampleRateCode returns the sample rate code of frame headers and the
+// number of bits of the sample rate following the header.
+func flacSampleRateCode(rate int) (uint64, int) {
+	if code, ok := flacSampleRateCodes[rate]; ok {
+		return code, 0
+	}
+	switch {
+	case rate%1000 == 0 && rate/1000 <= 0xFF:
+		return 12, 8 // in kHz
+	case rate <= 0xFFFF:
+		return 13, 16 // in Hz
+	case rate%10 == 0 && rate/10 <= 0xFFFF:
+		return 14, 16 // in tens of Hz
+	}
+	return 0, 0 // from the stream info
+}
+
+// utf8Number returns v in the UTF-8 like coding of frame numbers.
+func utf8Number(v uint64) []byte {
+	if v < 0x80 {
+		return []byte{byte(v)}
+	}
+	n := 2 // bytes, holding 5n+1 bits
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	b := []byte{byte(0xFF<<(8-n)) | byte(v>>(6*(n-1)))}
+	for i := n - 2; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(6*i))&0x3F)
+	}
+	return b
+}
+
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	for i := range crc8Table {
+		c8, c16 := uint8(i), uint16(i)<<8
+		for b := 0; b < 8; b++ {
+			c8 = c8<<1 ^ uint8(-(c8>>7))&0x07
+			c16 = c16<<1 ^ uint16(-(c16>>15))&0x8005
+		}
+		crc8Table[i], crc16Table[i] = c8, c16
+	}
+}
+
+// crc8 is the CRC of FLAC frame headers, polynomial x^8 + x^2 + x + 1.
+func crc8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
+
+// crc16 is the CRC of FLAC frames, polynomial x^16 + x^15 + x^2 + 1.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
@@ -0,0 +1,414 @@
+package encode
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// Precision of quantized LPC coefficients in bits
+	flacLPCPrecision = 12
+	// Largest shift of LPC predictions
+	flacMaxLPCShift = 15
+	// Largest Rice parameter of the 4 bit parameter coding, larger ones need
+	// the 5 bit coding
+	flacMaxRiceParameter4 = 14
+	flacMaxRiceParameter  = 30
+)
+
+// Subframe types
+const (
+	flacConstant = 0
+	flacVerbatim = 1
+	flacFixed    = 8  // plus the order
+	flacLPC      = 31 // plus the order
+)
+
+// flacSubframe is the encoding of the samples of a single channel.
+type flacSubframe struct {
+	kind         int // subframe type, flacLPC + order for LPC
+	samples      []int64
+	sampleSize   int // after removing wasted bits
+	wasted       int // zero bits at the end of every sample
+	order        int
+	coefficients []int64 // of LPC
+	shift        int
+	residual     []int64
+	rice         flacRice
+	size         int // in bits
+}
+
+// flacRice is the partitioned Rice coding of a residual.
+type flacRice struct {
+	partitionOrder int
+	parameters     []int
+	size           int // in bits
+}
+
+// newFLACSubframe returns the smallest encoding of samples, whose size is
+// sampleSize bits, the level allows: constant, verbatim, by a fixed
+// predictor or by linear prediction.
+func newFLACSubframe(samples []int64, sampleSize int, level flacLevel) *flacSubframe {
+	constant := true
+	var or int64
+	for _, s := range samples {
+		constant = constant && s == samples[0]
+		or |= s
+	}
+	if constant {
+		return &flacSubframe{kind: flacConstant, samples: samples, sampleSize: sampleSize, size: 8 + sampleSize}
+	}
+
+	// Samples converted from fewer bits end in zero bits, which are stored
+	// only once
+	wasted := bits.TrailingZeros64(uint64(or))
+	if wasted > 0 {
+		shifted := make([]int64, len(samples))
+		for i, s := range samples {
+			shifted[i] = s >> wasted
+		}
+		samples = shifted
+		sampleSize -= wasted
+	}
+	header := 8 + wasted // the unary wasted bit count follows the header
+
+	best := &flacSubframe{kind: flacVerbatim, samples: samples, sampleSize: sampleSize, wasted: wasted, size: header + len(samples)*sampleSize}
+	consider := func(s *flacSubframe) {
+		if s != nil && s.size < best.size {
+			best = s
+		}
+	}
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		residual := fixedResidual(samples, order)
+		rice, ok := newFLACRice(residual, order, level.maxPartitionOrder)
+		if !ok {
+			continue
+		}
+		consider(&flacSubframe{
+			kind: flacFixed + order, samples: samples, sampleSize: sampleSize, wasted: wasted, order: order,
+			residual: residual, rice: rice, size: header + order*sampleSize + rice.size,
+		})
+	}
+	if level.maxLPCOrder > 0 && len(samples) > level.maxLPCOrder {
+		consider(newFLACLPCSubframe(samples, sampleSize, wasted, level))
+	}
+	return best
+}
+
+// newFLACLPCSubframe returns the smallest encoding of samples by linear
+// prediction, nil if there is none. Unless level is exhaustive, only the
+// order with the lowest estimated size is tried.
+func newFLACLPCSubframe(samples []int64, sampleSize int, wasted int, level flacLevel) *flacSubframe {
+	autocorrelation := windowedAutocorrelation(samples, level.maxLPCOrder)
+	if autocorrelation[0] == 0 {
+		return nil
+	}
+	lpc, errors := levinsonDurbin(autocorrelation, level.maxLPCOrder)
+
+	orders := []int{}
+	if level.exhaustive {
+		for order := 1; order <= len(lpc); order++ {
+			orders = append(orders, order)
+		}
+	} else {
+		// Expected bits per residual sample by the prediction error
+		best, bestBits := 1, math.Inf(1)
+		for order := 1; order <= len(lpc); order++ {
+			perSample := 0.0
+			if errors[order-1] > 0 {
+				perSample = max(0, 0.5*math.Log2(0.5*errors[order-1]/float64(len(samples))))
+			}
+			total := perSample*float64(len(samples)-order) + float64(order*(sampleSize+flacLPCPrecision))
+			if total < bestBits {
+				best, bestBits = order, total
+			}
+		}
+		orders = append(orders, best)
+	}
+
+	var best *flacSubframe
+	for _, order := range orders {
+		coefficients, shift, ok := quantizeLPC(lpc[order-1])
+		if !ok {
+			continue
+		}
+		residual, ok := lpcResidual(samples, coefficients, shift)
+		if !ok {
+			continue
+		}
+		rice, ok := newFLACRice(residual, order, level.maxPartitionOrder)
+		if !ok {
+			continue
+		}
+		size := 8 + wasted + order*sampleSize + 4 + 5 + order*flacLPCPrecision + rice.size
+		if best == nil || size < best.size {
+			best = &flacSubframe{
+				kind: flacLPC + order, samples: samples, sampleSize: sampleSize, wasted: wasted, order: order,
+				coefficients: coefficients, shift: shift, residual: residual, rice: rice, size: size,
+			}
+		}
+	}
+	return best
+}
+
+func (s *flacSubframe) write(b *bitWriter) {
+	b.write(0, 1)
+	b.write(uint64(s.kind), 6)
+	if s.wasted > 0 {
+		b.write(1, 1)
+		b.unary(uint64(s.wasted - 1))
+	} else {
+		b.write(0, 1)
+	}
+
+	switch {
+	case s.kind == flacConstant:
+		b.signed(s.samples[0], s.sampleSize)
+		return
+	case s.kind == flacVerbatim:
+		for _, v := range s.samples {
+			b.signed(v, s.sampleSize)
+		}
+		return
+	}
+	for _, v := range s.samples[:s.order] {
+		b.signed(v, s.sampleSize)
+	}
+	if s.kind > flacLPC {
+		b.write(flacLPCPrecision-1, 4)
+		b.signed(int64(s.shift), 5)
+		for _, c := range s.coefficients {
+			b.signed(c, flacLPCPrecision)
+		}
+	}
+	s.rice.write(b, s.residual, s.order)
+}
+
+// fixedResidual returns the residual of the fixed polynomial predictor of
+// order. The first order values are the warm-up samples.
+func fixedResidual(samples []int64, order int) []int64 {
+	residual := make([]int64, len(samples))
+	copy(residual, samples[:order])
+	for i := order; i < len(samples); i++ {
+		switch order {
+		case 0:
+			residual[i] = samples[i]
+		case 1:
+			residual[i] = samples[i] - samples[i-1]
+		case 2:
+			residual[i] = samples[i] - 2*samples[i-1] + samples[i-2]
+		case 3:
+			residual[i] = samples[i] - 3*samples[i-1] + 3*samples[i-2] - samples[i-3]
+		case 4:
+			residual[i] = samples[i] - 4*samples[i-1] + 6*samples[i-2] - 4*samples[i-3] + samples[i-4]
+		}
+	}
+	return residual
+}
+
+// windowedAutocorrelation returns the autocorrelation of samples with a
+// Tukey window for the lags 0 to maxLag.
+func windowedAutocorrelation(samples []int64, maxLag int) []float64 {
+	n := len(samples)
+	windowed := make([]float64, n)
+	taper := n / 4 // half of a Tukey window with p = 0.5 on both ends
+	for i, s := range samples {
+		w := 1.0
+		if taper > 1 && i < taper {
+			w = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		} else if taper > 1 && i >= n-taper {
+			w = 0.5 - 0.5*math.Cos(math.Pi*float64(n-1-i)/float64(taper))
+		}
+		windowed[i] = float64(s) * w
+	}
+	autocorrelation := make([]float64, maxLag+1)
+	for lag := range autocorrelation {
+		var sum float64
+		for i := lag; i < n; i++ {
+			sum += windowed[i] * windowed[i-lag]
+		}
+		autocorrelation[lag] = sum
+	}
+	return autocorrelation
+}
+
+// levinsonDurbin returns the LPC coefficients for the orders 1 to maxOrder
+// of the given autocorrelation and the prediction error of each order. It
+// stops early at an order which predicts the samples perfectly.
+func levinsonDurbin(autocorrelation []float64, maxOrder int) ([][]float64, []float64) {
+	lpc := make([][]float64, maxOrder)
+	errors := make([]float64, maxOrder)
+	coefficients := make([]float64, maxOrder)
+	err := autocorrelation[0]
+	for i := 0; i < maxOrder; i++ {
+		r := -autocorrelation[i+1]
+		for j := 0; j < i; j++ {
+			r -= coefficients[j] * autocorrelation[i-j]
+		}
+		r /= err
+		coefficients[i] = r
+		for j := 0; j < i/2; j++ {
+			coefficients[j], coefficients[i-1-j] = coefficients[j]+r*coefficients[i-1-j], coefficients[i-1-j]+r*coefficients[j]
+		}
+		if i%2 == 1 {
+			coefficients[i/2] += coefficients[i/2] * r
+		}
+		err *= 1 - r*r
+
+		// Predictions are added, the recursion yields subtracted ones
+		lpc[i] = make([]float64, i+1)
+		for j := range lpc[i] {
+			lpc[i][j] = -coefficients[j]
+		}
+		errors[i] = err
+		if err <= 0 {
+			return lpc[:i+1], errors[:i+1]
+		}
+	}
+	return lpc, errors
+}
+
+// quantizeLPC quantizes coefficients to flacLPCPrecision bits and returns
+// them with the shift of their predictions. Rounding errors are carried over
+// to the next coefficient. It fails for coefficients which are all zero or
+// too large to quantize.
+func quantizeLPC(coefficients []float64) ([]int64, int, bool) {
+	var largest float64
+	for _, c := range coefficients {
+		largest = max(largest, math.Abs(c))
+	}
+	if largest == 0 || math.IsNaN(largest) || math.IsInf(largest, 0) {
+		return nil, 0, false
+	}
+	_, exponent := math.Frexp(largest)
+	// The largest coefficient uses all bits but the sign
+	shift := min(flacMaxLPCShift, flacLPCPrecision-1-exponent)
+	if shift < 0 {
+		return nil, 0, false
+	}
+
+	limit := float64(int64(1)<<(flacLPCPrecision-1) - 1)
+	quantized := make([]int64, len(coefficients))
+	var carried float64
+	for i, c := range coefficients {
+		carried += c * float64(int64(1)<<shift)
+		q := max(-limit-1, min(limit, math.Round(carried)))
+		carried -= q
+		quantized[i] = int64(q)
+	}
+	return quantized, shift, true
+}
+
+// lpcResidual returns the residual of the linear predictor with the given
+// coefficients and shift. The first len(coefficients) values are the warm-up
+// samples. It fails if the residual does not fit in 32 bits, which decoders
+// rely on.
+func lpcResidual(samples []int64, coefficients []int64, shift int) ([]int64, bool) {
+	order := len(coefficients)
+	residual := make([]int64, len(samples))
+	copy(residual, samples[:order])
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coefficients {
+			sum += c * samples[i-1-j]
+		}
+		r := samples[i] - sum>>shift
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil, false
+		}
+		residual[i] = r
+	}
+	return residual, true
+}
+
+// newFLACRice returns the smallest partitioned Rice coding of residual, of
+// which the first order values are warm-up samples, up to maxPartitionOrder.
+// The sizes are estimated from the sums of the partitions, like the
+// reference encoder does. It fails if a residual is too large to code.
+func newFLACRice(residual []int64, order int, maxPartitionOrder int) (flacRice, bool) {
+	n := len(residual)
+	// Partitions have to be of equal size and hold the warm-up samples
+	for maxPartitionOrder > 0 && (n%(1<<maxPartitionOrder) != 0 || n>>maxPartitionOrder < order) {
+		maxPartitionOrder--
+	}
+	if n>>maxPartitionOrder < order {
+		return flacRice{}, false
+	}
+
+	// Sums of the partitions of the highest order, merged for lower ones
+	sums := make([]uint64, 1<<maxPartitionOrder)
+	size := n >> maxPartitionOrder
+	for i := order; i < n; i++ {
+		u := zigzag(residual[i])
+		if u > math.MaxUint32 {
+			return flacRice{}, false
+		}
+		sums[i/size] += u
+	}
+
+	best := flacRice{size: math.MaxInt}
+	for partitionOrder := maxPartitionOrder; partitionOrder >= 0; partitionOrder-- {
+		partitions := 1 << partitionOrder
+		if partitionOrder < maxPartitionOrder {
+			for p := 0; p < partitions; p++ {
+				sums[p] = sums[2*p] + sums[2*p+1]
+			}
+		}
+		rice := flacRice{partitionOrder: partitionOrder, parameters: make([]int, partitions), size: 2 + 4}
+		partitionSize := n >> partitionOrder
+		parameterBits := 4
+		for p := 0; p < partitions; p++ {
+			count := partitionSize
+			if p == 0 {
+				count -= order
+			}
+			k, bits := riceParameter(sums[p], count)
+			rice.parameters[p] = k
+			rice.size += bits
+			if k > flacMaxRiceParameter4 {
+				parameterBits = 5
+			}
+		}
+		rice.size += partitions * parameterBits
+		if rice.size < best.size {
+			best = rice
+		}
+	}
+	return best, true
+}
+
+// riceParameter returns the Rice parameter with the smallest estimated size
+// for count values with the given sum, and that size in bits.
+func riceParameter(sum uint64, count int) (int, int) {
+	if count == 0 {
+		return 0, 0
+	}
+	estimate := max(0, bits.Len64(sum/uint64(count))-1)
+	best, bestSize := 0, math.MaxInt
+	for k := max(0, estimate-1); k <= min(flacMaxRiceParameter, estimate+1); k++ {
+		size := count*(k+1) + int(sum>>k)
+		if size < bestSize {
+			best, bestSize = k, size
+		}
+	}
+	return best, bestSize
+}
+
+func (r flacRice) write(b *bitWriter, residual []int64, order int) {
+	parameterBits := 4
+	for _, k := range r.parameters {
+		if k > flacMaxRiceParameter4 {
+			parameterBits = 5
+		}
+	}
+	b.write(uint64(parameterBits-4), 2) // coding method
+	b.write(uint64(r.partitionOrder), 4)
+	partitionSize := len(residual) >> r.partitionOrder
+	i := order
+	for p, k := range r.parameters {
+		b.write(uint64(k), parameterBits)
+		for end := (p + 1) * partitionSize; i < end; i++ {
+			b.rice(residual[i], k)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package encode_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/makl11/musiman/audio/encode"
+)
+
+func TestFLAC(t *testing.T) {
+	cases := []struct {
+		frames, channels, bits, level int
+	}{
+		{10000, 1, 16, 0},
+		{10000, 2, 16, 1},
+		{10000, 2, 16, 3},
+		{10000, 2, 16, encode.DEFAULT_LEVEL},
+		{10000, 2, 24, 8},
+		{5000, 6, 24, encode.DEFAULT_LEVEL},
+		{5000, 1, 8, encode.DEFAULT_LEVEL},
+		{5000, 2, 12, 2},
+		{7, 2, 16, encode.DEFAULT_LEVEL}, // shorter than the warm-up of LPC
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%d channels of %d bits at level %d", c.channels, c.bits, c.level), func(t *testing.T) {
+			samples := signal(c.frames, c.channels, c.bits)
+			format := encode.Format{SampleRate: 44100, Channels: c.channels, BitsPerSample: c.bits}
+			path := encodeFile(t, samples, c.channels, func(w io.WriteSeeker) (encode.Encoder, error) {
+				return encode.NewFLAC(w, format, c.level)
+			})
+			// The decoder verifies the MD5 signature as well
+			d, decoded := decodeFile(t, path)
+			if d.SampleRate() != 44100 || d.Channels() != c.channels || d.BitsPerSample() != c.bits {
+				t.Errorf("expected %+v, but got %d Hz with %d channels of %d bits", format, d.SampleRate(), d.Channels(), d.BitsPerSample())
+			}
+			assertSamples(t, samples, decoded)
+		})
+	}
+}
+
+func TestFLACCompression(t *testing.T) {
+	samples := signal(44100, 2, 16)
+	format := encode.Format{SampleRate: 44100, Channels: 2, BitsPerSample: 16}
+	var sizes []int64
+	for _, level := range []int{encode.MIN_LEVEL, encode.MAX_LEVEL} {
+		path := encodeFile(t, samples, 2, func(w io.WriteSeeker) (encode.Encoder, error) {
+			return encode.NewFLAC(w, format, level)
+		})
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sizes = append(sizes, info.Size())
+	}
+	if raw := int64(len(samples) * 2); sizes[0] >= raw/2 {
+		t.Errorf("expected less than %d bytes at the lowest level, but got %d", raw/2, sizes[0])
+	}
+	if sizes[1] >= sizes[0] {
+		t.Errorf("expected the highest level to compress better than %d bytes, but got %d", sizes[0], sizes[1])
+	}
+}
+
+func TestFLACWastedBits(t *testing.T) {
+	// 8 bit audio stored in 16 bits
+	samples := signal(5000, 1, 8)
+	path := encodeFile(t, samples, 1, func(w io.WriteSeeker) (encode.Encoder, error) {
+		return encode.NewFLAC(w, encode.Format{SampleRate: 44100, Channels: 1, BitsPerSample: 16}, encode.DEFAULT_LEVEL)
+	})
+	_, decoded := decodeFile(t, path)
+	assertSamples(t, samples, decoded)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Size() > 5000 {
+		t.Errorf("expected the wasted bits to be stored once, but got %d bytes", info.Size())
+	}
+}
+
+func TestNewFLACInvalid(t *testing.T) {
+	f, err := os.Create(t.TempDir() + "/invalid.flac")
+	if err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	defer f.Close()
+	if _, err := encode.NewFLAC(f, encode.Format{SampleRate: 44100, Channels: 9, BitsPerSample: 16}, encode.DEFAULT_LEVEL); !errors.Is(err, encode.ErrInvalidFormat) {
+		t.Errorf("expected ErrInvalidFormat, but got %v", err)
+	}
+	if _, err := encode.NewFLAC(f, encode.Format{SampleRate: 44100, Channels: 2, BitsPerSample: 16}, 9); !errors.Is(err, encode.ErrInvalidLevel) {
+		t.Errorf("expected ErrInvalidLevel, but got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package encode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// https://www.mmsp.ece.mcgill.ca/Documents/AudioFormats/WAVE/WAVE.html
+
+const (
+	wavFormatPCM        = 1
+	wavFormatExtensible = 0xFFFE
+)
+
+// Speaker positions of the channels of WAVE_FORMAT_EXTENSIBLE files by the
+// number of channels, in the same order FLAC uses
+var wavChannelMasks = [9]uint32{0, 0x4, 0x3, 0x7, 0x33, 0x37, 0x3F, 0x70F, 0x63F}
+
+// GUID of integer PCM samples, following the format code in the sub format
+// of WAVE_FORMAT_EXTENSIBLE files
+var wavSubFormatGUID = []byte("\x00\x00\x00\x00\x10\x00\x80\x00\x00\xAA\x00\x38\x9B\x71")
+
+// WAV writes WAV files with 8, 16, 24 or 32 bit integer samples. Files with
+// more than 2 channels or 16 bits are written as WAVE_FORMAT_EXTENSIBLE. The
+// chunk sizes are only known once all samples are written, Close fills them
+// in, so it needs an io.WriteSeeker. Streams, i.e. piped into another
+// program, are written with unknown sizes instead.
+type WAV struct {
+	w        io.Writer
+	seeker   io.WriteSeeker // nil for streams
+	format   Format
+	size     int   // bytes per sample
+	dataSize int64 // bytes of samples written so far
+	dataAt   int64 // offset of the data chunk size
+	chunks   []byte
+	buf      []byte
+}
+
+// NewWAV writes the header of a WAV file with format to w and returns an
+// encoder for its samples.
+func NewWAV(w io.WriteSeeker, format Format) (*WAV, error) {
+	e, err := newWAV(w, format, 0)
+	if err != nil {
+		return nil, err
+	}
+	e.seeker = w
+	return e, nil
+}
+
+// NewWAVStream writes the header of a WAV stream with format to w, with the
+// maximum size marking the size as unknown, and returns an encoder for its
+// samples. Streams have no size limit and chunks added to them are ignored.
+func NewWAVStream(w io.Writer, format Format) (*WAV, error) {
+	return newWAV(w, format, math.MaxUint32)
+}
+
+func newWAV(w io.Writer, format Format, size uint32) (*WAV, error) {
+	if format.SampleRate <= 0 || format.Channels <= 0 || format.Channels > math.MaxUint16 || format.BitsPerSample%8 != 0 || format.BitsPerSample < 8 || format.BitsPerSample > 32 {
+		return nil, fmt.Errorf("%w: %d Hz with %d channels of %d bits for WAV", ErrInvalidFormat, format.SampleRate, format.Channels, format.BitsPerSample)
+	}
+	e := &WAV{w: w, format: format, size: format.BitsPerSample / 8}
+
+	blockAlign := format.Channels * e.size
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, wavFormatPCM)
+	if format.Channels > 2 || format.BitsPerSample > 16 {
+		fmtChunk = binary.LittleEndian.AppendUint16(nil, wavFormatExtensible)
+	}
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(format.Channels))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(format.SampleRate))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(format.SampleRate*blockAlign))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(blockAlign))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(format.BitsPerSample))
+	if format.Channels > 2 || format.BitsPerSample > 16 {
+		var mask uint32
+		if format.Channels < len(wavChannelMasks) {
+			mask = wavChannelMasks[format.Channels]
+		}
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 22) // size of the extension
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(format.BitsPerSample))
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, mask)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, wavFormatPCM)
+		fmtChunk = append(fmtChunk, wavSubFormatGUID...)
+	}
+
+	header := binary.LittleEndian.AppendUint32([]byte("RIFF"), size)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(fmtChunk)))
+	header = append(header, fmtChunk...)
+	header = append(header, "data"...)
+	e.dataAt = int64(len(header))
+	header = binary.LittleEndian.AppendUint32(header, size)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// AddChunk adds a chunk, i.e. an "id3 " chunk with tags, which Close writes
+// after the samples.
+func (e *WAV) AddChunk(id string, data []byte) {
+	e.chunks = append(e.chunks, id...)
+	e.chunks = binary.LittleEndian.AppendUint32(e.chunks, uint32(len(data)))
+	e.chunks = append(e.chunks, data...)
+	if len(data)%2 != 0 {
+		e.chunks = append(e.chunks, 0) // chunks are padded to an even size
+	}
+}
+
+func (e *WAV) Write(samples []float32) error {
+	e.buf = e.buf[:0]
+	bits := e.format.BitsPerSample
+	for _, s := range samples {
+		v := quantize(s, bits)
+		if e.size == 1 {
+			v += 128 // 8 bit samples are unsigned
+		}
+		for b := 0; b < e.size; b++ {
+			e.buf = append(e.buf, byte(v>>(8*b)))
+		}
+	}
+	if e.seeker != nil && e.dataAt+4+e.dataSize+int64(len(e.buf)) > math.MaxUint32 {
+		return fmt.Errorf("%w: WAV files are limited to 4 GiB", ErrTooLarge)
+	}
+	n, err := e.w.Write(e.buf)
+	e.dataSize += int64(n)
+	return err
+}
+
+func (e *WAV) Close() error {
+	if e.seeker == nil {
+		return nil
+	}
+	trailer := e.chunks
+	if e.dataSize%2 != 0 {
+		trailer = append([]byte{0}, trailer...)
+	}
+	if _, err := e.w.Write(trailer); err != nil {
+		return err
+	}
+	riffSize := e.dataAt + 4 + e.dataSize + int64(len(trailer)) - 8
+	if riffSize > math.MaxUint32 {
+		return fmt.Errorf("%w: WAV files are limited to 4 GiB", ErrTooLarge)
+	}
+
+	if err := e.patch(4, uint32(riffSize)); err != nil {
+		return err
+	}
+	if err := e.patch(e.dataAt, uint32(e.dataSize)); err != nil {
+		return err
+	}
+	_, err := e.seeker.Seek(0, io.SeekEnd)
+	return err
+}
+
+// patch overwrites the 32 bit size at offset.
+func (e *WAV) patch(offset int64, size uint32) error {
+	if _, err := e.seeker.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := e.w.Write(binary.LittleEndian.AppendUint32(nil, size))
+	return err
+}
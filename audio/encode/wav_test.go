@@ -0,0 +1,88 @@
+package encode_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/makl11/musiman/audio/encode"
+	"github.com/makl11/musiman/audio/tags"
+)
+
+func TestWAV(t *testing.T) {
+	cases := []struct {
+		frames, channels, bits int
+	}{
+		{3001, 1, 8},
+		{3001, 2, 16},
+		{3001, 2, 24},
+		{3001, 6, 16},
+		{3001, 1, 32},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%d channels of %d bits", c.channels, c.bits), func(t *testing.T) {
+			samples := signal(c.frames, c.channels, min(c.bits, 24))
+			format := encode.Format{SampleRate: 48000, Channels: c.channels, BitsPerSample: c.bits}
+			path := encodeFile(t, samples, c.channels, func(w io.WriteSeeker) (encode.Encoder, error) {
+				return encode.NewWAV(w, format)
+			})
+			d, decoded := decodeFile(t, path)
+			if d.SampleRate() != 48000 || d.Channels() != c.channels || d.BitsPerSample() != c.bits {
+				t.Errorf("expected %+v, but got %d Hz with %d channels of %d bits", format, d.SampleRate(), d.Channels(), d.BitsPerSample())
+			}
+			assertSamples(t, samples, decoded)
+		})
+	}
+}
+
+func TestWAVChunks(t *testing.T) {
+	samples := signal(1001, 1, 8)
+	var e *encode.WAV
+	path := encodeFile(t, samples, 1, func(w io.WriteSeeker) (encode.Encoder, error) {
+		var err error
+		e, err = encode.NewWAV(w, encode.Format{SampleRate: 8000, Channels: 1, BitsPerSample: 8})
+		if err == nil {
+			e.AddChunk("id3 ", tags.ID3v2(&tags.Tags{Title: "Odd"}, nil))
+		}
+		return e, err
+	})
+	_, decoded := decodeFile(t, path)
+	assertSamples(t, samples, decoded)
+	tagged, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tagged.Title != "Odd" {
+		t.Errorf("expected the title Odd, but got %q", tagged.Title)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Size()%2 != 0 {
+		t.Errorf("expected the odd sized data chunk to be padded, but got %d bytes", info.Size())
+	}
+}
+
+func TestWAVStream(t *testing.T) {
+	samples := signal(3001, 2, 16)
+	path := encodeFile(t, samples, 2, func(w io.WriteSeeker) (encode.Encoder, error) {
+		// Hide Seek, like a pipe
+		return encode.NewWAVStream(struct{ io.Writer }{w}, encode.Format{SampleRate: 44100, Channels: 2, BitsPerSample: 16})
+	})
+	_, decoded := decodeFile(t, path)
+	assertSamples(t, samples, decoded)
+}
+
+func TestNewWAVInvalidFormat(t *testing.T) {
+	f, err := os.Create(t.TempDir() + "/invalid.wav")
+	if err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	defer f.Close()
+	if _, err := encode.NewWAV(f, encode.Format{SampleRate: 44100, Channels: 2, BitsPerSample: 20}); !errors.Is(err, encode.ErrInvalidFormat) {
+		t.Errorf("expected ErrInvalidFormat, but got %v", err)
+	}
+}
@@ -10,6 +10,8 @@ var MUSIC_FILE_TYPES = map[string]bool{
 	"wma": true,
 	// https://en.wikipedia.org/wiki/Free_Lossless_Audio_Codec
 	"flac": true,
+	// https://en.wikipedia.org/wiki/MP4_file_format
+	"m4a": true,
 	// https://en.wikipedia.org/wiki/Waveform_Audio_File_Format
 	"wav": true,
 	// https://en.wikipedia.org/wiki/Audio_Interchange_File_Format
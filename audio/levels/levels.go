@@ -0,0 +1,164 @@
+package levels
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// Level statistics of decoded audio, finding badly mastered tracks by their
+// clipping and DC offset, and hidden tracks by long silence.
+
+const (
+	SILENCE       = -60.0           // dBFS, blocks with a lower peak are silent
+	CLIP_LEVEL    = 32767.0 / 32768 // full scale of 16 bit samples
+	MIN_CLIP_RUN  = 3               // consecutive samples at full scale which are clipped
+	MAX_DC_OFFSET = 0.005           // higher offsets are a sign of bad mastering
+)
+
+var ErrInvalidFormat = errors.New("invalid audio format")
+
+// Silence is detected in blocks of 10 ms
+const blocksPerSecond = 100
+
+// Stats is the result of an Analyzer. Times are in seconds, levels linear
+// with 1 for full scale.
+type Stats struct {
+	Duration        float64
+	LeadingSilence  float64
+	TrailingSilence float64
+	LongestGap      float64 // longest silence between audio, 0 if there is none
+	GapStart        float64 // of the longest gap
+	Clipped         int64   // samples in runs of at least MIN_CLIP_RUN at CLIP_LEVEL or above
+	DCOffset        float64 // mean of the channel with the largest one
+	Peak            float64 // highest absolute sample value
+}
+
+// Analyzer analyses a stream of samples.
+type Analyzer struct {
+	channels   int
+	sampleRate int
+	blockSize  int // samples per channel in a block
+	threshold  float64
+	blockPeak  float64
+	pos        int   // samples per channel in the current block
+	blocks     int64 // number of blocks so far
+	firstSound int64 // first block which is not silent, -1 if there is none yet
+	lastSound  int64
+	gap        int64 // blocks of the longest gap
+	gapStart   int64
+	runs       []int // of samples at full scale by channel
+	sums       []float64
+	frames     int64
+	s          Stats
+}
+
+// New returns an Analyzer for interleaved audio with the given sample rate
+// and number of channels.
+func New(sampleRate int, channels int) (*Analyzer, error) {
+	if sampleRate < blocksPerSecond || channels <= 0 {
+		return nil, fmt.Errorf("%w: %d Hz with %d channels", ErrInvalidFormat, sampleRate, channels)
+	}
+	return &Analyzer{
+		channels:   channels,
+		sampleRate: sampleRate,
+		blockSize:  sampleRate / blocksPerSecond,
+		threshold:  math.Pow(10, SILENCE/20),
+		firstSound: -1,
+		lastSound:  -1,
+		runs:       make([]int, channels),
+		sums:       make([]float64, channels),
+	}, nil
+}
+
+// Feed adds interleaved samples, whose number has to be a multiple of the
+// number of channels.
+func (a *Analyzer) Feed(samples []float32) {
+	for i := 0; i+a.channels <= len(samples); i += a.channels {
+		for c, s := range samples[i : i+a.channels] {
+			v := math.Abs(float64(s))
+			a.sums[c] += float64(s)
+			a.blockPeak = max(a.blockPeak, v)
+			if v < CLIP_LEVEL {
+				a.runs[c] = 0
+				continue
+			}
+			a.runs[c]++
+			switch {
+			case a.runs[c] == MIN_CLIP_RUN:
+				a.s.Clipped += MIN_CLIP_RUN
+			case a.runs[c] > MIN_CLIP_RUN:
+				a.s.Clipped++
+			}
+		}
+		a.frames++
+		a.pos++
+		if a.pos == a.blockSize {
+			a.endBlock()
+		}
+	}
+}
+
+func (a *Analyzer) endBlock() {
+	if a.blockPeak >= a.threshold {
+		if a.firstSound < 0 {
+			a.firstSound = a.blocks
+		} else if gap := a.blocks - a.lastSound - 1; gap > a.gap {
+			a.gap, a.gapStart = gap, a.lastSound+1
+		}
+		a.lastSound = a.blocks
+	}
+	a.s.Peak = max(a.s.Peak, a.blockPeak)
+	a.blockPeak = 0
+	a.pos = 0
+	a.blocks++
+}
+
+// Finish returns the statistics of all samples fed so far.
+func (a *Analyzer) Finish() Stats {
+	if a.pos > 0 {
+		a.endBlock()
+	}
+	s := a.s
+	s.Duration = float64(a.frames) / float64(a.sampleRate)
+	blockDuration := float64(a.blockSize) / float64(a.sampleRate)
+	if a.firstSound < 0 {
+		s.LeadingSilence = s.Duration
+	} else {
+		s.LeadingSilence = float64(a.firstSound) * blockDuration
+		s.TrailingSilence = max(0, s.Duration-float64(a.lastSound+1)*blockDuration)
+	}
+	s.LongestGap = float64(a.gap) * blockDuration
+	s.GapStart = float64(a.gapStart) * blockDuration
+	if a.frames > 0 {
+		for _, sum := range a.sums {
+			if mean := sum / float64(a.frames); math.Abs(mean) > math.Abs(s.DCOffset) {
+				s.DCOffset = mean
+			}
+		}
+	}
+	return s
+}
+
+// Analyze analyses all audio of d.
+func Analyze(d decode.Decoder) (Stats, error) {
+	a, err := New(d.SampleRate(), d.Channels())
+	if err != nil {
+		return Stats{}, err
+	}
+	buf := make([]float32, 4096*d.Channels())
+	for {
+		n, err := d.Read(buf)
+		a.Feed(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Stats{}, err
+		}
+	}
+	return a.Finish(), nil
+}
@@ -0,0 +1,118 @@
+package levels_test
+
+import (
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/makl11/musiman/audio/levels"
+)
+
+// sliceDecoder plays the given mono samples at 1000 Hz.
+type sliceDecoder struct {
+	samples []float32
+}
+
+func (d *sliceDecoder) SampleRate() int { return 1000 }
+func (d *sliceDecoder) Channels() int   { return 1 }
+
+func (d *sliceDecoder) Read(samples []float32) (int, error) {
+	n := copy(samples, d.samples)
+	d.samples = d.samples[n:]
+	if len(d.samples) == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// segment is a 250 Hz sine with the given amplitude and offset, silence for
+// an amplitude and offset of 0.
+type segment struct {
+	seconds   float64
+	amplitude float64
+	offset    float64
+}
+
+func play(segments ...segment) *sliceDecoder {
+	d := &sliceDecoder{}
+	for _, s := range segments {
+		for i := 0; i < int(s.seconds*1000); i++ {
+			d.samples = append(d.samples, float32(s.offset+s.amplitude*math.Sin(2*math.Pi*250*float64(i)/1000)))
+		}
+	}
+	return d
+}
+
+func TestAnalyze(t *testing.T) {
+	s, err := levels.Analyze(play(
+		segment{seconds: 1.5},
+		segment{seconds: 2, amplitude: 0.1},
+		segment{seconds: 12},
+		segment{seconds: 1, amplitude: 0.5},
+		segment{seconds: 0.5},
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []struct {
+		name          string
+		actual, value float64
+	}{
+		{"duration", s.Duration, 17},
+		{"leading silence", s.LeadingSilence, 1.5},
+		{"trailing silence", s.TrailingSilence, 0.5},
+		{"longest gap", s.LongestGap, 12},
+		{"gap start", s.GapStart, 3.5},
+		{"peak", s.Peak, 0.5},
+	}
+	for _, e := range expected {
+		if math.Abs(e.actual-e.value) > 0.011 {
+			t.Errorf("expected %s of %v, but got %v", e.name, e.value, e.actual)
+		}
+	}
+	if s.Clipped != 0 || math.Abs(s.DCOffset) > 1e-6 {
+		t.Errorf("expected no clipping and DC offset, but got %d and %v", s.Clipped, s.DCOffset)
+	}
+}
+
+func TestAnalyzeClipping(t *testing.T) {
+	// Runs of 4 and 5 samples at full scale are clipped, one of 2 is not
+	d := &sliceDecoder{samples: []float32{0.5, 1, 1, 1, 1, 0.2, 1, 1, 0, -1, -1, -1, -1.1, -1, 0.3}}
+	s, err := levels.Analyze(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Clipped != 9 {
+		t.Errorf("expected 9 clipped samples, but got %d", s.Clipped)
+	}
+	if math.Abs(s.Peak-1.1) > 1e-6 {
+		t.Errorf("expected a peak of 1.1, but got %v", s.Peak)
+	}
+}
+
+func TestAnalyzeDCOffset(t *testing.T) {
+	s, err := levels.Analyze(play(segment{seconds: 1, amplitude: 0.5, offset: -0.02}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if math.Abs(s.DCOffset+0.02) > 1e-6 {
+		t.Errorf("expected a DC offset of -0.02, but got %v", s.DCOffset)
+	}
+}
+
+func TestAnalyzeSilence(t *testing.T) {
+	s, err := levels.Analyze(play(segment{seconds: 2}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.LeadingSilence != 2 || s.TrailingSilence != 0 || s.LongestGap != 0 || s.Peak != 0 {
+		t.Errorf("expected only leading silence, but got %+v", s)
+	}
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	if _, err := levels.New(44100, 0); !errors.Is(err, levels.ErrInvalidFormat) {
+		t.Errorf("expected ErrInvalidFormat, but got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package loudness
+
+import "math"
+
+// kWeighting is the K-weighting filter of one channel: a high shelf modelling
+// the acoustic effect of the head followed by a high pass. The coefficients
+// are derived for the sample rate like libebur128 does, at 48 kHz they match
+// the ones given in BS.1770-4.
+type kWeighting struct {
+	shelf, highPass biquad
+}
+
+func newKWeighting(sampleRate int) kWeighting {
+	rate := float64(sampleRate)
+
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1, b1: -2, b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return kWeighting{shelf: shelf, highPass: highPass}
+}
+
+func (k *kWeighting) filter(x float64) float64 {
+	return k.highPass.filter(k.shelf.filter(x))
+}
+
+// biquad is a second order IIR filter in direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (b *biquad) filter(x float64) float64 {
+	w := x - b.a1*b.z1 - b.a2*b.z2
+	y := b.b0*w + b.b1*b.z1 + b.b2*b.z2
+	b.z2, b.z1 = b.z1, w
+	return y
+}
+
+// Coefficients of the interpolation filter of BS.1770-4 Annex 2, which
+// oversamples by 4 with 12 taps per phase
+var truePeakPhases = [4][12]float64{
+	{0.0017089843750, 0.0109863281250, -0.0196533203125, 0.0332031250000, -0.0594482421875, 0.1373291015625,
+		0.9721679687500, -0.1022949218750, 0.0476074218750, -0.0266113281250, 0.0148925781250, -0.0083007812500},
+	{-0.0291748046875, 0.0292968750000, -0.0517578125000, 0.0891113281250, -0.1665039062500, 0.4650878906250,
+		0.7797851562500, -0.2003173828125, 0.1015625000000, -0.0582275390625, 0.0330810546875, -0.0189208984375},
+	{-0.0189208984375, 0.0330810546875, -0.0582275390625, 0.1015625000000, -0.2003173828125, 0.7797851562500,
+		0.4650878906250, -0.1665039062500, 0.0891113281250, -0.0517578125000, 0.0292968750000, -0.0291748046875},
+	{-0.0083007812500, 0.0148925781250, -0.0266113281250, 0.0476074218750, -0.1022949218750, 0.9721679687500,
+		0.1373291015625, -0.0594482421875, 0.0332031250000, -0.0196533203125, 0.0109863281250, 0.0017089843750},
+}
+
+// truePeak estimates the peak of the signal between the samples of one
+// channel by oversampling.
+type truePeak struct {
+	history [12]float64 // last samples, the latest first
+}
+
+// add adds a sample and returns the highest absolute value of the
+// interpolated samples up to it.
+func (t *truePeak) add(x float64) float64 {
+	copy(t.history[1:], t.history[:11])
+	t.history[0] = x
+	peak := 0.0
+	for _, phase := range truePeakPhases {
+		v := 0.0
+		for i, c := range phase {
+			v += c * t.history[i]
+		}
+		peak = max(peak, math.Abs(v))
+	}
+	return peak
+}
@@ -0,0 +1,241 @@
+package loudness
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// Loudness measurement after ITU-R BS.1770-4 with the loudness range of EBU
+// Tech 3342, as used by EBU R 128 and ReplayGain 2.0.
+// https://www.itu.int/rec/R-REC-BS.1770
+// https://tech.ebu.ch/docs/tech/tech3342.pdf
+
+const (
+	ABSOLUTE_GATE       = -70.0 // LUFS, quieter blocks are ignored
+	RELATIVE_GATE       = -10.0 // LU below the loudness of the blocks above the absolute gate
+	RANGE_RELATIVE_GATE = -20.0 // LU, relative gate of the loudness range
+)
+
+var ErrInvalidFormat = errors.New("invalid audio format")
+
+// Energies are summed in sub-blocks of 100 ms. Gating blocks of 400 ms start
+// every sub-block, short-term blocks of 3 s every 10 sub-blocks.
+const (
+	blockSubBlocks     = 4
+	shortTermSubBlocks = 30
+	shortTermStep      = 10
+)
+
+// Meter measures the loudness of a stream of samples.
+type Meter struct {
+	channels  int
+	weights   []float64 // of the channels
+	filters   []kWeighting
+	peaks     []truePeak // nil if the sample rate needs no oversampling
+	subSize   int        // samples per channel in a sub-block
+	sum       float64    // of the weighted squares in the current sub-block
+	pos       int        // samples per channel in the current sub-block
+	subBlocks []float64  // sums of the last shortTermSubBlocks sub-blocks
+	count     int        // number of sub-blocks so far
+	m         Measurement
+}
+
+// Measurement is the result of a Meter. Measurements of several tracks can be
+// combined into the measurement of an album.
+type Measurement struct {
+	Blocks     []float64 // mean square of every gating block
+	ShortTerm  []float64 // mean square of every short-term block
+	SamplePeak float64   // highest absolute sample value, 1 for full scale
+	TruePeak   float64   // highest absolute value of the oversampled signal
+}
+
+// New returns a Meter for interleaved audio with the given sample rate and
+// number of channels. Channels are weighted as in the ITU layouts: the fourth
+// of six channels is the LFE channel and ignored, the last two of five or six
+// channels are surround channels.
+func New(sampleRate int, channels int) (*Meter, error) {
+	if sampleRate < 8000 || channels <= 0 {
+		return nil, fmt.Errorf("%w: %d Hz with %d channels", ErrInvalidFormat, sampleRate, channels)
+	}
+	m := &Meter{
+		channels: channels,
+		weights:  make([]float64, channels),
+		filters:  make([]kWeighting, channels),
+		subSize:  sampleRate / 10,
+	}
+	for c := range channels {
+		m.weights[c] = 1
+		m.filters[c] = newKWeighting(sampleRate)
+	}
+	if channels == 5 || channels == 6 {
+		m.weights[channels-2], m.weights[channels-1] = 1.41, 1.41
+	}
+	if channels == 6 {
+		m.weights[3] = 0
+	}
+	if sampleRate < 96000 {
+		m.peaks = make([]truePeak, channels)
+	}
+	return m, nil
+}
+
+// Feed adds interleaved samples, whose number has to be a multiple of the
+// number of channels.
+func (m *Meter) Feed(samples []float32) {
+	for i := 0; i+m.channels <= len(samples); i += m.channels {
+		for c, s := range samples[i : i+m.channels] {
+			v := float64(s)
+			m.m.SamplePeak = max(m.m.SamplePeak, math.Abs(v))
+			if m.peaks != nil {
+				m.m.TruePeak = max(m.m.TruePeak, m.peaks[c].add(v))
+			}
+			if m.weights[c] != 0 {
+				y := m.filters[c].filter(v)
+				m.sum += m.weights[c] * y * y
+			}
+		}
+		m.pos++
+		if m.pos == m.subSize {
+			m.endSubBlock()
+		}
+	}
+}
+
+func (m *Meter) endSubBlock() {
+	if len(m.subBlocks) == shortTermSubBlocks {
+		m.subBlocks = m.subBlocks[1:]
+	}
+	m.subBlocks = append(m.subBlocks, m.sum)
+	m.sum, m.pos = 0, 0
+	m.count++
+
+	sum := func(n int) float64 {
+		total := 0.0
+		for _, s := range m.subBlocks[len(m.subBlocks)-n:] {
+			total += s
+		}
+		return total / float64(n*m.subSize)
+	}
+	if m.count >= blockSubBlocks {
+		m.m.Blocks = append(m.m.Blocks, sum(blockSubBlocks))
+	}
+	if m.count >= shortTermSubBlocks && (m.count-shortTermSubBlocks)%shortTermStep == 0 {
+		m.m.ShortTerm = append(m.m.ShortTerm, sum(shortTermSubBlocks))
+	}
+}
+
+// Finish returns the measurement of all samples fed so far. Samples of an
+// incomplete last block are not measured.
+func (m *Meter) Finish() Measurement {
+	result := m.m
+	result.TruePeak = max(result.TruePeak, result.SamplePeak)
+	return result
+}
+
+// Integrated returns the gated loudness of all blocks in LUFS, or -Inf if
+// all of them are below the absolute gate.
+func (m Measurement) Integrated() float64 {
+	return gatedLoudness(m.Blocks, RELATIVE_GATE)
+}
+
+// Range returns the loudness range in LU, the spread of the gated
+// short-term loudness between its 10th and 95th percentile.
+func (m Measurement) Range() float64 {
+	threshold := math.Pow(10, (ABSOLUTE_GATE+0.691)/10)
+	var gated []float64
+	for _, e := range m.ShortTerm {
+		if e > threshold {
+			gated = append(gated, e)
+		}
+	}
+	if len(gated) == 0 {
+		return 0
+	}
+	relative := energyToLoudness(mean(gated)) + RANGE_RELATIVE_GATE
+	var values []float64
+	for _, e := range gated {
+		if l := energyToLoudness(e); l >= relative {
+			values = append(values, l)
+		}
+	}
+	slices.Sort(values)
+	percentile := func(p float64) float64 {
+		return values[int(math.Round(p*float64(len(values)-1)))]
+	}
+	return percentile(0.95) - percentile(0.10)
+}
+
+// Combine returns the measurement of the tracks of an album as if they were
+// played one after another.
+func Combine(tracks ...Measurement) Measurement {
+	var album Measurement
+	for _, t := range tracks {
+		album.Blocks = append(album.Blocks, t.Blocks...)
+		album.ShortTerm = append(album.ShortTerm, t.ShortTerm...)
+		album.SamplePeak = max(album.SamplePeak, t.SamplePeak)
+		album.TruePeak = max(album.TruePeak, t.TruePeak)
+	}
+	return album
+}
+
+// gatedLoudness returns the loudness of the blocks above the absolute gate
+// and the gate relative to their loudness.
+func gatedLoudness(blocks []float64, relativeGate float64) float64 {
+	threshold := math.Pow(10, (ABSOLUTE_GATE+0.691)/10)
+	var gated []float64
+	for _, e := range blocks {
+		if e > threshold {
+			gated = append(gated, e)
+		}
+	}
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	threshold = math.Pow(10, (energyToLoudness(mean(gated))+relativeGate+0.691)/10)
+	var total float64
+	var n int
+	for _, e := range gated {
+		if e > threshold {
+			total += e
+			n++
+		}
+	}
+	return energyToLoudness(total / float64(n))
+}
+
+func energyToLoudness(e float64) float64 {
+	return -0.691 + 10*math.Log10(e)
+}
+
+func mean(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+// Measure measures the loudness of all audio of d.
+func Measure(d decode.Decoder) (Measurement, error) {
+	m, err := New(d.SampleRate(), d.Channels())
+	if err != nil {
+		return Measurement{}, err
+	}
+	buf := make([]float32, 4096*d.Channels())
+	for {
+		n, err := d.Read(buf)
+		m.Feed(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Measurement{}, err
+		}
+	}
+	return m.Finish(), nil
+}
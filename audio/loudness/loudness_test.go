@@ -0,0 +1,135 @@
+package loudness_test
+
+import (
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/makl11/musiman/audio/loudness"
+)
+
+// sineDecoder plays 1 kHz sines on all channels, each segment for the given
+// seconds at the given level in dBFS.
+type sineDecoder struct {
+	rate, channels int
+	segments       []segment
+	pos            int
+}
+
+type segment struct {
+	seconds float64
+	level   float64
+}
+
+func (d *sineDecoder) SampleRate() int { return d.rate }
+func (d *sineDecoder) Channels() int   { return d.channels }
+
+func (d *sineDecoder) Read(samples []float32) (int, error) {
+	n := 0
+	for ; n+d.channels <= len(samples); d.pos++ {
+		start := 0
+		i := 0
+		for ; i < len(d.segments); i++ {
+			end := start + int(d.segments[i].seconds*float64(d.rate))
+			if d.pos < end {
+				break
+			}
+			start = end
+		}
+		if i == len(d.segments) {
+			return n, io.EOF
+		}
+		v := float32(math.Pow(10, d.segments[i].level/20) * math.Sin(2*math.Pi*1000*float64(d.pos)/float64(d.rate)))
+		for c := 0; c < d.channels; c++ {
+			samples[n] = v
+			n++
+		}
+	}
+	return n, nil
+}
+
+// EBU Tech 3341 and 3342 test signals
+func TestMeasure(t *testing.T) {
+	tests := []struct {
+		name       string
+		rate       int
+		segments   []segment
+		integrated float64
+		lra        float64
+	}{
+		{name: "-23 dBFS", rate: 48000, segments: []segment{{20, -23}}, integrated: -23},
+		{name: "-33 dBFS", rate: 44100, segments: []segment{{20, -33}}, integrated: -33},
+		{name: "gated quiet parts", rate: 48000, segments: []segment{{10, -36}, {60, -23}, {10, -36}}, integrated: -23, lra: 13},
+		{name: "range", rate: 48000, segments: []segment{{20, -20}, {20, -30}}, integrated: -22.6, lra: 10},
+		{name: "range with gated silence", rate: 48000, segments: []segment{{20, -50}, {20, -35}, {20, -20}, {20, -35}, {20, -50}}, integrated: -24.5, lra: 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := loudness.Measure(&sineDecoder{rate: tt.rate, channels: 2, segments: tt.segments})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result := m.Integrated(); math.Abs(result-tt.integrated) > 0.1 {
+				t.Errorf("expected integrated loudness %.1f LUFS, but got %.2f", tt.integrated, result)
+			}
+			if result := m.Range(); math.Abs(result-tt.lra) > 1 {
+				t.Errorf("expected loudness range %.0f LU, but got %.2f", tt.lra, result)
+			}
+		})
+	}
+}
+
+func TestMeasureSilence(t *testing.T) {
+	m, err := loudness.Measure(&sineDecoder{rate: 48000, channels: 1, segments: []segment{{5, -100}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result := m.Integrated(); !math.IsInf(result, -1) {
+		t.Errorf("expected -Inf for silence, but got %f", result)
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	// A sine at a quarter of the sample rate, sampled between its peaks
+	meter, err := loudness.New(48000, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	samples := make([]float32, 48000)
+	for i := range samples {
+		samples[i] = float32(math.Sin(math.Pi/2*float64(i) + math.Pi/4))
+	}
+	meter.Feed(samples)
+	m := meter.Finish()
+	if math.Abs(m.SamplePeak-math.Sqrt2/2) > 0.001 {
+		t.Errorf("expected sample peak %f, but got %f", math.Sqrt2/2, m.SamplePeak)
+	}
+	if db := 20 * math.Log10(m.TruePeak); math.Abs(db) > 0.5 {
+		t.Errorf("expected true peak of about 0 dBTP, but got %.2f", db)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	loud, err := loudness.Measure(&sineDecoder{rate: 48000, channels: 2, segments: []segment{{20, -20}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	quiet, err := loudness.Measure(&sineDecoder{rate: 48000, channels: 2, segments: []segment{{20, -30}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	album := loudness.Combine(loud, quiet)
+	if result := album.Integrated(); math.Abs(result+22.6) > 0.1 {
+		t.Errorf("expected album loudness -22.6 LUFS, but got %.2f", result)
+	}
+	if album.SamplePeak != loud.SamplePeak {
+		t.Errorf("expected the peak of the loudest track %f, but got %f", loud.SamplePeak, album.SamplePeak)
+	}
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	if _, err := loudness.New(44100, 0); !errors.Is(err, loudness.ErrInvalidFormat) {
+		t.Errorf("expected error %v, but got %v", loudness.ErrInvalidFormat, err)
+	}
+}
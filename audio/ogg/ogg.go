@@ -0,0 +1,253 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// https://www.xiph.org/ogg/doc/framing.html
+
+var (
+	ErrInvalidPage = errors.New("invalid ogg page")
+	ErrChecksum    = errors.New("ogg page checksum mismatch")
+)
+
+const (
+	HEADER_CONTINUED = 0x01 // the first packet of the page continues a packet of the previous page
+	HEADER_BOS       = 0x02 // first page of a logical stream
+	HEADER_EOS       = 0x04 // last page of a logical stream
+
+	headerSize  = 27
+	maxSegments = 255
+)
+
+var capturePattern = []byte("OggS")
+
+type Page struct {
+	HeaderType      byte
+	GranulePosition int64
+	Serial          uint32
+	Sequence        uint32
+	Checksum        uint32
+	Segments        []byte // lacing values
+	Payload         []byte
+}
+
+// ReadPage reads the next page from r and verifies its checksum. Pages with a
+// checksum mismatch are returned together with ErrChecksum. At the end of r
+// io.EOF is returned.
+func ReadPage(r io.Reader) (*Page, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: truncated header", ErrInvalidPage)
+		}
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], capturePattern) {
+		return nil, fmt.Errorf("%w: missing capture pattern", ErrInvalidPage)
+	}
+	if header[4] != 0 {
+		return nil, fmt.Errorf("%w: unknown stream structure version %d", ErrInvalidPage, header[4])
+	}
+
+	page := &Page{
+		HeaderType:      header[5],
+		GranulePosition: int64(binary.LittleEndian.Uint64(header[6:14])),
+		Serial:          binary.LittleEndian.Uint32(header[14:18]),
+		Sequence:        binary.LittleEndian.Uint32(header[18:22]),
+		Checksum:        binary.LittleEndian.Uint32(header[22:26]),
+		Segments:        make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, page.Segments); err != nil {
+		return nil, fmt.Errorf("%w: truncated segment table", ErrInvalidPage)
+	}
+	size := 0
+	for _, lacing := range page.Segments {
+		size += int(lacing)
+	}
+	page.Payload = make([]byte, size)
+	if _, err := io.ReadFull(r, page.Payload); err != nil {
+		return nil, fmt.Errorf("%w: truncated payload", ErrInvalidPage)
+	}
+
+	if checksum := page.computeChecksum(); checksum != page.Checksum {
+		return page, fmt.Errorf("%w: page %d has %08x, expected %08x", ErrChecksum, page.Sequence, page.Checksum, checksum)
+	}
+	return page, nil
+}
+
+// Bytes serialises the page, using the checksum calculated from its content
+// instead of the Checksum field.
+func (p *Page) Bytes() []byte {
+	b := p.marshal()
+	binary.LittleEndian.PutUint32(b[22:26], CRC(b))
+	return b
+}
+
+func (p *Page) computeChecksum() uint32 {
+	return CRC(p.marshal())
+}
+
+// marshal serialises the page with a zeroed checksum field.
+func (p *Page) marshal() []byte {
+	b := make([]byte, headerSize+len(p.Segments)+len(p.Payload))
+	copy(b, capturePattern)
+	b[5] = p.HeaderType
+	binary.LittleEndian.PutUint64(b[6:14], uint64(p.GranulePosition))
+	binary.LittleEndian.PutUint32(b[14:18], p.Serial)
+	binary.LittleEndian.PutUint32(b[18:22], p.Sequence)
+	b[26] = byte(len(p.Segments))
+	copy(b[headerSize:], p.Segments)
+	copy(b[headerSize+len(p.Segments):], p.Payload)
+	return b
+}
+
+// Packets splits the payload into its packets. The last packet is incomplete
+// if it continues on the next page.
+func (p *Page) Packets() (packets [][]byte, lastComplete bool) {
+	offset, start := 0, 0
+	lastComplete = true
+	for i, lacing := range p.Segments {
+		offset += int(lacing)
+		if lacing < 255 {
+			packets = append(packets, p.Payload[start:offset])
+			start = offset
+		} else if i == len(p.Segments)-1 {
+			packets = append(packets, p.Payload[start:offset])
+			lastComplete = false
+		}
+	}
+	return packets, lastComplete
+}
+
+// Paginate splits packets into pages of the logical stream serial, starting
+// with sequence number sequence. Every page gets granule position granule,
+// except for pages on which no packet ends, which get -1.
+func Paginate(packets [][]byte, serial uint32, sequence uint32, granule int64) []*Page {
+	var pages []*Page
+	page := &Page{Serial: serial, Sequence: sequence, GranulePosition: -1}
+	for _, packet := range packets {
+		remaining := packet
+		for {
+			if len(page.Segments) == maxSegments {
+				pages = append(pages, page)
+				sequence++
+				continued := page.Segments[maxSegments-1] == 255
+				page = &Page{Serial: serial, Sequence: sequence, GranulePosition: -1}
+				if continued {
+					page.HeaderType = HEADER_CONTINUED
+				}
+			}
+			n := min(len(remaining), 255)
+			page.Segments = append(page.Segments, byte(n))
+			page.Payload = append(page.Payload, remaining[:n]...)
+			remaining = remaining[n:]
+			if n < 255 {
+				page.GranulePosition = granule
+				break
+			}
+		}
+	}
+	if len(page.Segments) > 0 {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// PacketReader reassembles the packets of the first logical stream in an Ogg
+// bitstream. Pages of other multiplexed streams are skipped.
+type PacketReader struct {
+	r       io.Reader
+	serial  uint32
+	started bool
+	pending [][]byte
+	partial []byte
+	page    *Page
+}
+
+func NewPacketReader(r io.Reader) *PacketReader {
+	return &PacketReader{r: r}
+}
+
+// Page returns the page the last returned packet ended on.
+func (pr *PacketReader) Page() *Page {
+	return pr.page
+}
+
+// Granule returns the granule position of the last returned packet. It is only
+// known for the last packet ending on a page, for other packets it is -1.
+func (pr *PacketReader) Granule() int64 {
+	if len(pr.pending) > 0 || pr.page == nil {
+		return -1
+	}
+	return pr.page.GranulePosition
+}
+
+// NextPacket returns the next complete packet, or io.EOF at the end of the
+// stream.
+func (pr *PacketReader) NextPacket() ([]byte, error) {
+	for len(pr.pending) == 0 {
+		page, err := ReadPage(pr.r)
+		if err != nil {
+			if err == io.EOF && len(pr.partial) > 0 {
+				return nil, fmt.Errorf("%w: stream ends within a packet", ErrInvalidPage)
+			}
+			return nil, err
+		}
+		if !pr.started {
+			pr.serial, pr.started = page.Serial, true
+		} else if page.Serial != pr.serial {
+			continue
+		}
+		pr.page = page
+
+		packets, lastComplete := page.Packets()
+		if page.HeaderType&HEADER_CONTINUED == 0 {
+			pr.partial = nil
+		}
+		for i, packet := range packets {
+			if i == 0 && pr.partial != nil {
+				packet = append(pr.partial, packet...)
+				pr.partial = nil
+			}
+			if i == len(packets)-1 && !lastComplete {
+				pr.partial = append([]byte(nil), packet...)
+				break
+			}
+			pr.pending = append(pr.pending, packet)
+		}
+	}
+	packet := pr.pending[0]
+	pr.pending = pr.pending[1:]
+	return packet, nil
+}
+
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// CRC calculates the Ogg page checksum (polynomial 0x04C11DB7, no reflection,
+// zero initial value) of b. The checksum field in b must be zeroed.
+func CRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^c]
+	}
+	return crc
+}
@@ -0,0 +1,271 @@
+package quality
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+
+	"github.com/makl11/musiman/audio/decode"
+)
+
+// Spectral analysis of decoded audio, finding lossless files which were made
+// from lossy ones: the lowpass shelf of lossy encoders, samples padded with
+// zero bits and sample rates raised without adding content.
+
+const (
+	MIN_SHELF       = 30.0  // dB, smallest drop of the level at a lowpass cutoff
+	LOSSLESS_CUTOFF = 21000 // Hz, cutoffs above are normal anti-aliasing
+	SUSPICIOUS      = 0.5   // confidence from which files are suspicious
+)
+
+// Kinds of suspicions
+const (
+	LOWPASS   = "lowpass"
+	UPSAMPLED = "upsampled"
+	PADDED    = "padded"
+)
+
+var ErrInvalidFormat = errors.New("invalid audio format")
+
+// Cutoffs in Hz of the lowpass filters of common lossy encoder settings
+var encoderCutoffs = []float64{16000, 19000, 20000}
+
+const (
+	frameSize = 4096
+	silence   = -120.0 // dB, lower levels are no content
+	minFreq   = 2000   // Hz, lowest frequency searched for a cutoff
+	guardHz   = 250    // Hz between the levels compared at a cutoff
+	spanHz    = 1000   // Hz of content below a cutoff
+	typicalHz = 500    // Hz, tolerance of the encoder cutoffs
+)
+
+// Analysis is the result of an Analyzer.
+type Analysis struct {
+	SampleRate int
+	Bits       int     // sample size of lossless formats, 0 for lossy ones
+	UsedBits   int     // bits which are not always zero, 0 for silence and sizes above 24 bits
+	Cutoff     float64 // Hz, highest frequency below a lowpass shelf, 0 if there is none
+	Shelf      float64 // dB, drop of the level above the cutoff
+}
+
+// Suspicion is a sign that a lossless file was made from a lossy one.
+type Suspicion struct {
+	Kind       string  // LOWPASS, UPSAMPLED or PADDED
+	Confidence float64 // in (0, 1]
+	Detail     string
+}
+
+// Analyzer analyses a stream of samples.
+type Analyzer struct {
+	sampleRate int
+	channels   int
+	bits       int
+	scale      float64 // of samples to integers, 0 if the used bits are not counted
+	used       int32   // all integer samples or'ed together
+	frame      []float64
+	window     []float64
+	twiddle    []complex128
+	reverse    []int
+	buf        []complex128
+	power      []float64 // summed over all frames, frameSize/2 + 1 bins
+	frames     int
+}
+
+// New returns an Analyzer for interleaved audio with the given sample rate,
+// number of channels and sample size, which is 0 for lossy formats.
+func New(sampleRate int, channels int, bitsPerSample int) (*Analyzer, error) {
+	if sampleRate < 8000 || channels <= 0 || bitsPerSample < 0 {
+		return nil, fmt.Errorf("%w: %d Hz with %d channels of %d bits", ErrInvalidFormat, sampleRate, channels, bitsPerSample)
+	}
+	a := &Analyzer{
+		sampleRate: sampleRate,
+		channels:   channels,
+		bits:       bitsPerSample,
+		frame:      make([]float64, 0, frameSize),
+		window:     make([]float64, frameSize),
+		twiddle:    make([]complex128, frameSize/2),
+		reverse:    make([]int, frameSize),
+		buf:        make([]complex128, frameSize),
+		power:      make([]float64, frameSize/2+1),
+	}
+	if bitsPerSample > 0 && bitsPerSample <= 24 {
+		a.scale = float64(int32(1) << (bitsPerSample - 1))
+	}
+	// Hann window, scaled so that a full scale sine has a level of 0 dB
+	for i := range a.window {
+		a.window[i] = 4.0 / frameSize * 0.5 * (1 - math.Cos(float64(i)*2.0*math.Pi/frameSize))
+	}
+	for k := range a.twiddle {
+		a.twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/frameSize))
+	}
+	shift := 64 - bits.Len(frameSize-1)
+	for i := range a.reverse {
+		a.reverse[i] = int(bits.Reverse64(uint64(i)) >> shift)
+	}
+	return a, nil
+}
+
+// Feed adds interleaved samples, whose number has to be a multiple of the
+// number of channels.
+func (a *Analyzer) Feed(samples []float32) {
+	if a.scale != 0 {
+		for _, s := range samples {
+			a.used |= int32(math.Round(float64(s) * a.scale))
+		}
+	}
+	for i := 0; i+a.channels <= len(samples); i += a.channels {
+		sum := 0.0
+		for _, s := range samples[i : i+a.channels] {
+			sum += float64(s)
+		}
+		a.frame = append(a.frame, sum/float64(a.channels))
+		if len(a.frame) == frameSize {
+			a.addFrame()
+			a.frame = a.frame[:0]
+		}
+	}
+}
+
+// addFrame adds the power spectrum of the current frame.
+func (a *Analyzer) addFrame() {
+	for i, v := range a.frame {
+		a.buf[a.reverse[i]] = complex(v*a.window[i], 0)
+	}
+	for size := 2; size <= frameSize; size <<= 1 {
+		step := frameSize / size
+		for start := 0; start < frameSize; start += size {
+			for k := 0; k < size/2; k++ {
+				x, y := a.buf[start+k], a.buf[start+k+size/2]*a.twiddle[k*step]
+				a.buf[start+k], a.buf[start+k+size/2] = x+y, x-y
+			}
+		}
+	}
+	for i := range a.power {
+		re, im := real(a.buf[i]), imag(a.buf[i])
+		a.power[i] += re*re + im*im
+	}
+	a.frames++
+}
+
+// Finish returns the analysis of all samples fed so far. Samples of an
+// incomplete last frame only count for the used bits.
+func (a *Analyzer) Finish() Analysis {
+	r := Analysis{SampleRate: a.sampleRate, Bits: a.bits}
+	if a.scale != 0 && a.used != 0 {
+		r.UsedBits = a.bits - bits.TrailingZeros32(uint32(a.used))
+	}
+	if a.frames == 0 {
+		return r
+	}
+
+	levels := make([]float64, len(a.power))
+	for i, p := range a.power {
+		levels[i] = 10 * math.Log10(p/float64(a.frames)+1e-20)
+	}
+	// highest level from every bin up
+	above := make([]float64, len(levels)+1)
+	above[len(levels)] = math.Inf(-1)
+	for i := len(levels) - 1; i >= 0; i-- {
+		above[i] = max(levels[i], above[i+1])
+	}
+
+	binHz := float64(a.sampleRate) / frameSize
+	guard, span := int(guardHz/binHz)+1, int(spanHz/binHz)+1
+	for k := len(levels) - 1 - guard; k-guard-span >= int(minFreq/binHz); k-- {
+		below := meanLevel(levels[k-guard-span : k-guard])
+		floor := above[k+guard]
+		if below < silence || below-floor < MIN_SHELF {
+			continue
+		}
+		// The content ends somewhere below k+guard, at the last bin clearly
+		// above the levels of the shelf
+		cutoff := k + guard - 1
+		for levels[cutoff] < floor+MIN_SHELF/2 {
+			cutoff--
+		}
+		r.Cutoff = float64(cutoff) * binHz
+		r.Shelf = below - floor
+		break
+	}
+	return r
+}
+
+// meanLevel returns the mean of levels in dB.
+func meanLevel(levels []float64) float64 {
+	sum := 0.0
+	for _, l := range levels {
+		sum += l
+	}
+	return sum / float64(len(levels))
+}
+
+// Suspicions returns the signs that the analysed audio was made from a lossy
+// source. Lossy formats are never suspicious, their cutoff is expected.
+func (a Analysis) Suspicions() []Suspicion {
+	if a.Bits == 0 {
+		return nil
+	}
+	var s []Suspicion
+	// 0.5 for the smallest shelf, 1 from twice as much
+	steepness := min(1, a.Shelf/(2*MIN_SHELF))
+	nyquist := float64(a.SampleRate) / 2
+	if a.Cutoff > 0 && a.Cutoff < min(LOSSLESS_CUTOFF, 0.9*nyquist) {
+		confidence := 0.75 * steepness
+		for _, c := range encoderCutoffs {
+			if math.Abs(a.Cutoff-c) <= typicalHz {
+				confidence = steepness
+			}
+		}
+		s = append(s, Suspicion{LOWPASS, confidence, fmt.Sprintf("lowpass at %.1f kHz", a.Cutoff/1000)})
+	}
+	if a.Cutoff > 0 && a.SampleRate > 48000 && a.Cutoff <= 24000+typicalHz {
+		original := 48000
+		if a.Cutoff <= 22050+typicalHz {
+			original = 44100
+		}
+		s = append(s, Suspicion{UPSAMPLED, steepness, fmt.Sprintf("upsampled from %d Hz", original)})
+	}
+	if a.UsedBits > 0 && a.UsedBits < a.Bits {
+		confidence := min(1, float64(a.Bits-a.UsedBits)/8)
+		s = append(s, Suspicion{PADDED, confidence, fmt.Sprintf("%d of %d bits used", a.UsedBits, a.Bits)})
+	}
+	return s
+}
+
+// Confidence returns the highest confidence of the suspicions, 0 if there
+// are none.
+func (a Analysis) Confidence() float64 {
+	confidence := 0.0
+	for _, s := range a.Suspicions() {
+		confidence = max(confidence, s.Confidence)
+	}
+	return confidence
+}
+
+// Analyze analyses all audio of d. The sample size is taken from decoders of
+// lossless formats with a BitsPerSample method.
+func Analyze(d decode.Decoder) (Analysis, error) {
+	bitsPerSample := 0
+	if b, ok := d.(interface{ BitsPerSample() int }); ok {
+		bitsPerSample = b.BitsPerSample()
+	}
+	a, err := New(d.SampleRate(), d.Channels(), bitsPerSample)
+	if err != nil {
+		return Analysis{}, err
+	}
+	buf := make([]float32, frameSize*d.Channels())
+	for {
+		n, err := d.Read(buf)
+		a.Feed(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Analysis{}, err
+		}
+	}
+	return a.Finish(), nil
+}
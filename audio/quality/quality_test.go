@@ -0,0 +1,158 @@
+package quality_test
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/makl11/musiman/audio/quality"
+)
+
+// noiseDecoder plays two seconds of white noise on two channels, lowpass
+// filtered at cutoff Hz unless it is 0 and quantized to the given bits.
+type noiseDecoder struct {
+	rate, bits int
+	samples    []float64
+	pos        int
+}
+
+func newNoiseDecoder(rate int, bits int, quantize int, cutoff float64) *noiseDecoder {
+	r := rand.New(rand.NewSource(1))
+	noise := make([]float64, 2*rate)
+	for i := range noise {
+		noise[i] = 0.6 * (r.Float64() - 0.5)
+	}
+	d := &noiseDecoder{rate: rate, bits: bits, samples: noise}
+	if cutoff > 0 {
+		// Windowed sinc filter with the Blackman window
+		const taps = 401
+		fc := cutoff / float64(rate)
+		h := make([]float64, taps)
+		for n := range h {
+			x := float64(n - taps/2)
+			h[n] = 2 * fc
+			if x != 0 {
+				h[n] = math.Sin(2*math.Pi*fc*x) / (math.Pi * x)
+			}
+			h[n] *= 0.42 - 0.5*math.Cos(2*math.Pi*float64(n)/(taps-1)) + 0.08*math.Cos(4*math.Pi*float64(n)/(taps-1))
+		}
+		d.samples = make([]float64, len(noise)-taps)
+		for i := range d.samples {
+			for n, c := range h {
+				d.samples[i] += c * noise[i+n]
+			}
+		}
+	}
+	if quantize > 0 {
+		scale := float64(int(1) << (quantize - 1))
+		for i, v := range d.samples {
+			d.samples[i] = math.Round(v*scale) / scale
+		}
+	}
+	return d
+}
+
+func (d *noiseDecoder) SampleRate() int    { return d.rate }
+func (d *noiseDecoder) Channels() int      { return 2 }
+func (d *noiseDecoder) BitsPerSample() int { return d.bits }
+
+func (d *noiseDecoder) Read(samples []float32) (int, error) {
+	n := 0
+	for ; n+2 <= len(samples); d.pos++ {
+		if d.pos == len(d.samples) {
+			return n, io.EOF
+		}
+		samples[n], samples[n+1] = float32(d.samples[d.pos]), float32(d.samples[d.pos])
+		n += 2
+	}
+	return n, nil
+}
+
+// expectSuspicions checks that a has suspicions of exactly the given kinds,
+// all of them with at least the confidence of SUSPICIOUS.
+func expectSuspicions(t *testing.T, a quality.Analysis, kinds ...string) {
+	t.Helper()
+	suspicions := a.Suspicions()
+	if len(suspicions) != len(kinds) {
+		t.Fatalf("expected suspicions %v, but got %v for %+v", kinds, suspicions, a)
+	}
+	for i, kind := range kinds {
+		if suspicions[i].Kind != kind || suspicions[i].Confidence < quality.SUSPICIOUS {
+			t.Errorf("expected suspicion %d to be %s, but got %+v", i, kind, suspicions[i])
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	t.Run("full band", func(t *testing.T) {
+		a, err := quality.Analyze(newNoiseDecoder(44100, 16, 16, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a.UsedBits != 16 {
+			t.Errorf("expected 16 used bits, but got %d", a.UsedBits)
+		}
+		expectSuspicions(t, a)
+	})
+	t.Run("lowpass", func(t *testing.T) {
+		a, err := quality.Analyze(newNoiseDecoder(44100, 16, 16, 16000))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a.Cutoff < 15500 || a.Cutoff > 16500 {
+			t.Errorf("expected a cutoff at 16 kHz, but got %.0f Hz", a.Cutoff)
+		}
+		if a.Shelf < quality.MIN_SHELF {
+			t.Errorf("expected a shelf of at least %.0f dB, but got %.1f dB", quality.MIN_SHELF, a.Shelf)
+		}
+		expectSuspicions(t, a, quality.LOWPASS)
+	})
+	t.Run("lossy", func(t *testing.T) {
+		a, err := quality.Analyze(newNoiseDecoder(44100, 0, 0, 16000))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a.Cutoff == 0 || a.UsedBits != 0 {
+			t.Errorf("expected a cutoff and no used bits, but got %+v", a)
+		}
+		expectSuspicions(t, a)
+	})
+	t.Run("upsampled", func(t *testing.T) {
+		a, err := quality.Analyze(newNoiseDecoder(96000, 24, 24, 21500))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expectSuspicions(t, a, quality.UPSAMPLED)
+		if detail := a.Suspicions()[0].Detail; detail != "upsampled from 44100 Hz" {
+			t.Errorf("expected upsampling from 44100 Hz, but got %q", detail)
+		}
+	})
+	t.Run("zero padded", func(t *testing.T) {
+		a, err := quality.Analyze(newNoiseDecoder(44100, 24, 16, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a.UsedBits != 16 {
+			t.Errorf("expected 16 used bits, but got %d", a.UsedBits)
+		}
+		expectSuspicions(t, a, quality.PADDED)
+	})
+	t.Run("silence", func(t *testing.T) {
+		a, err := quality.Analyze(&noiseDecoder{rate: 44100, bits: 16, samples: make([]float64, 2*44100)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a.Cutoff != 0 || a.UsedBits != 0 {
+			t.Errorf("expected no cutoff and no used bits, but got %+v", a)
+		}
+		expectSuspicions(t, a)
+	})
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	if _, err := quality.New(44100, 0, 16); !errors.Is(err, quality.ErrInvalidFormat) {
+		t.Errorf("expected ErrInvalidFormat, but got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/makl11/musiman/audio/ogg"
+)
+
+// CopyAudio writes the audio data of the music file at path without any tags
+// to w, so files which only differ in their tags produce the same output.
+// Ogg streams are written as their audio packets.
+func CopyAudio(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format, err := detectFormat(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	switch format {
+	case formatMP3:
+		err = copyMP3Audio(f, w)
+	case formatFLAC:
+		err = copyFLACAudio(f, w)
+	case formatOgg:
+		err = copyOggAudio(f, w)
+	case formatWAV:
+		err = copyChunkAudio(f, w, binary.LittleEndian, "data")
+	case formatAIFF:
+		err = copyChunkAudio(f, w, binary.BigEndian, "SSND")
+	case formatMP4:
+		err = copyMP4Audio(f, w)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// copyUntilID3v1 copies f from offset up to its ID3v1 tag, if any.
+func copyUntilID3v1(f fileReader, w io.Writer, offset int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := info.Size()
+	if readID3v1(f, end) != nil {
+		end -= 128
+	}
+	if end < offset {
+		return fmt.Errorf("%w: tags overlap", ErrMalformedTag)
+	}
+	_, err = io.Copy(w, io.NewSectionReader(f, offset, end-offset))
+	return err
+}
+
+func copyMP3Audio(f fileReader, w io.Writer) error {
+	offset, err := id3v2TagSize(f)
+	if err != nil {
+		return err
+	}
+	return copyUntilID3v1(f, w, offset)
+}
+
+func copyFLACAudio(f fileReader, w io.Writer) error {
+	_, offset, err := readFLACBlocks(f)
+	if err != nil {
+		return err
+	}
+	return copyUntilID3v1(f, w, offset)
+}
+
+func copyOggAudio(f fileReader, w io.Writer) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	packets := ogg.NewPacketReader(io.NewSectionReader(f, 0, info.Size()))
+	first, err := packets.NextPacket()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedTag, err)
+	}
+	var headerCount int
+	switch {
+	case bytes.HasPrefix(first, vorbisIdentificationHeader):
+		headerCount = 3
+	case bytes.HasPrefix(first, opusIdentificationHeader):
+		headerCount = 2
+	default:
+		return fmt.Errorf("%w: ogg stream without vorbis or opus identification header", ErrUnsupportedFormat)
+	}
+	for i := 0; ; i++ {
+		packet, err := packets.NextPacket()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedTag, err)
+		}
+		if i+1 < headerCount {
+			continue
+		}
+		if _, err := w.Write(packet); err != nil {
+			return err
+		}
+	}
+}
+
+func copyChunkAudio(f fileReader, w io.Writer, order binary.ByteOrder, id string) error {
+	chunks, err := readChunks(f, order)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if c.ID == id {
+			_, err := io.Copy(w, io.NewSectionReader(f, c.Offset, c.Size))
+			return err
+		}
+	}
+	return fmt.Errorf("%w: no \"%s\" chunk", ErrMalformedTag, id)
+}
@@ -0,0 +1,89 @@
+package tags_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/makl11/musiman/audio/ogg"
+	"github.com/makl11/musiman/audio/tags"
+)
+
+func TestCopyAudio(t *testing.T) {
+	title := id3v23Frame("TIT2", append([]byte{0}, "Song"...))
+	mp3 := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(title))}
+	mp3 = append(mp3, title...)
+	mp3 = append(mp3, audioData...)
+	mp3 = append(mp3, append([]byte("TAGSong"), make([]byte, 121)...)...)
+
+	flac := []byte("fLaC")
+	flac = append(flac, 0x80, 0, 0, 34) // STREAMINFO
+	flac = append(flac, make([]byte, 34)...)
+	flac = append(flac, audioData...)
+
+	headers := [][]byte{append([]byte("\x01vorbis"), make([]byte, 23)...), []byte("\x03vorbis comment"), []byte("\x05vorbis setup")}
+	pages := ogg.Paginate(headers, 7, 0, 0)
+	pages = append(pages, ogg.Paginate([][]byte{audioData}, 7, uint32(len(pages)), 1024)...)
+	var vorbis []byte
+	for _, page := range pages {
+		vorbis = append(vorbis, page.Bytes()...)
+	}
+
+	wav := []byte("RIFF\x00\x00\x00\x00WAVE")
+	wav = append(wav, "LIST\x04\x00\x00\x00INFO"...)
+	wav = append(wav, "data"...)
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(len(audioData)))
+	wav = append(wav, audioData...)
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"test.mp3", mp3},
+		{"test.flac", flac},
+		{"test.ogg", vorbis},
+		{"test.wav", wav},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tags.CopyAudio(&buf, writeTestFile(t, tt.name, tt.content)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), audioData) {
+				t.Errorf("expected only the audio data, but got %d bytes", buf.Len())
+			}
+		})
+	}
+}
+
+func TestCopyAudioIgnoresTags(t *testing.T) {
+	content := []byte("fLaC")
+	content = append(content, 0x80, 0, 0, 34) // STREAMINFO
+	content = append(content, make([]byte, 34)...)
+	content = append(content, audioData...)
+	path := writeTestFile(t, "test.flac", content)
+
+	var before, after bytes.Buffer
+	if err := tags.CopyAudio(&before, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tags.Write(path, &tags.Tags{Title: "Changed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tags.CopyAudio(&after, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Errorf("expected the audio data to be unchanged by writing tags")
+	}
+}
+
+func TestCopyAudioUnsupportedFormat(t *testing.T) {
+	var buf bytes.Buffer
+	err := tags.CopyAudio(&buf, writeTestFile(t, "test.txt", []byte("not audio at all")))
+	if !errors.Is(err, tags.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package tags
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// https://xiph.org/flac/format.html#metadata_block
+
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockPadding       = 1
+	flacBlockVorbisComment = 4
+	flacBlockPicture       = 6
+
+	flacLastBlockFlag = 0x80
+	flacMaxBlockSize  = 1<<24 - 1
+)
+
+type flacBlock struct {
+	Type byte
+	Data []byte
+}
+
+// readFLACBlocks reads all metadata blocks of the FLAC stream in r. It also
+// returns the offset of the first audio frame.
+func readFLACBlocks(r io.ReaderAt) ([]flacBlock, int64, error) {
+	offset, err := id3v2TagSize(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, offset); err != nil || !bytes.Equal(magic, []byte("fLaC")) {
+		return nil, 0, fmt.Errorf("%w: missing FLAC stream marker", ErrMalformedTag)
+	}
+	offset += 4
+
+	var blocks []flacBlock
+	header := make([]byte, 4)
+	for {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, 0, fmt.Errorf("%w: truncated metadata block header: %w", ErrMalformedTag, err)
+		}
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		block := flacBlock{Type: header[0] &^ flacLastBlockFlag, Data: make([]byte, size)}
+		if _, err := r.ReadAt(block.Data, offset+4); err != nil {
+			return nil, 0, fmt.Errorf("%w: truncated metadata block: %w", ErrMalformedTag, err)
+		}
+		blocks = append(blocks, block)
+		offset += 4 + int64(size)
+		if header[0]&flacLastBlockFlag != 0 {
+			return blocks, offset, nil
+		}
+	}
+}
+
+func readFLAC(f fileReader) (*Tags, error) {
+	blocks, _, err := readFLACBlocks(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if block.Type == flacBlockVorbisComment {
+			vc, err := parseVorbisComment(block.Data)
+			if err != nil {
+				return nil, err
+			}
+			return vc.toTags(), nil
+		}
+	}
+	return &Tags{}, nil
+}
+
+// writeFLAC writes the FLAC stream of f with its vorbis comment replaced by
+// t to w.
+func writeFLAC(f fileReader, w io.Writer, t *Tags) error {
+	return rewriteFLAC(f, w, func(blocks []flacBlock) ([]flacBlock, error) {
+		comment := -1
+		for i, block := range blocks {
+			if block.Type == flacBlockVorbisComment {
+				comment = i
+				break
+			}
+		}
+		if comment < 0 {
+			if len(blocks) == 0 || blocks[0].Type != flacBlockStreamInfo {
+				return nil, fmt.Errorf("%w: FLAC stream without STREAMINFO block", ErrMalformedTag)
+			}
+			// Right after STREAMINFO, which has to come first
+			blocks = append(blocks[:1], append([]flacBlock{{Type: flacBlockVorbisComment}}, blocks[1:]...)...)
+			comment = 1
+		}
+
+		vc := &vorbisComment{Vendor: "musiman"}
+		if len(blocks[comment].Data) > 0 {
+			var err error
+			if vc, err = parseVorbisComment(blocks[comment].Data); err != nil {
+				return nil, err
+			}
+		}
+		vc.update(t)
+		blocks[comment].Data = vc.bytes()
+		return blocks, nil
+	})
+}
+
+// rewriteFLAC writes the FLAC stream of f with its metadata blocks changed by
+// update to w. update gets all blocks but padding. Growth of the metadata is
+// taken from the padding if possible. An ID3v2 tag in front of the stream is
+// dropped.
+func rewriteFLAC(f fileReader, w io.Writer, update func(blocks []flacBlock) ([]flacBlock, error)) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	blocks, audioOffset, err := readFLACBlocks(f)
+	if err != nil {
+		return err
+	}
+
+	size := func(blocks []flacBlock) int {
+		n := 0
+		for _, block := range blocks {
+			n += 4 + len(block.Data)
+		}
+		return n
+	}
+	padding := 0
+	kept := make([]flacBlock, 0, len(blocks)+1)
+	for _, block := range blocks {
+		if block.Type == flacBlockPadding {
+			padding += 4 + len(block.Data)
+			continue
+		}
+		kept = append(kept, block)
+	}
+	oldSize := size(kept)
+	if kept, err = update(kept); err != nil {
+		return err
+	}
+	if remaining := padding - (size(kept) - oldSize); remaining >= 4 {
+		kept = append(kept, flacBlock{Type: flacBlockPadding, Data: make([]byte, remaining-4)})
+	}
+
+	if _, err := w.Write([]byte("fLaC")); err != nil {
+		return err
+	}
+	for i, block := range kept {
+		if len(block.Data) > flacMaxBlockSize {
+			return fmt.Errorf("%w: metadata block exceeds the maximum FLAC block size", ErrMalformedTag)
+		}
+		header := []byte{block.Type, byte(len(block.Data) >> 16), byte(len(block.Data) >> 8), byte(len(block.Data))}
+		if i == len(kept)-1 {
+			header[0] |= flacLastBlockFlag
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(block.Data); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(w, io.NewSectionReader(f, audioOffset, info.Size()-audioOffset))
+	return err
+}
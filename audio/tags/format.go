@@ -0,0 +1,59 @@
+package tags
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+)
+
+type format int
+
+const (
+	formatMP3 format = iota
+	formatFLAC
+	formatOgg
+	formatWAV
+	formatAIFF
+	formatMP4
+)
+
+func detectFormat(r io.ReaderAt) (format, error) {
+	header := make([]byte, 12)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return formatFLAC, nil
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return formatOgg, nil
+	case len(header) == 12 && bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return formatWAV, nil
+	case len(header) == 12 && bytes.HasPrefix(header, []byte("FORM")) && (bytes.Equal(header[8:12], []byte("AIFF")) || bytes.Equal(header[8:12], []byte("AIFC"))):
+		return formatAIFF, nil
+	case len(header) == 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return formatMP4, nil
+	case bytes.HasPrefix(header, []byte("ID3")):
+		// FLAC files are sometimes prefixed with an ID3v2 tag as well
+		size, err := id3v2TagSize(r)
+		if err != nil {
+			return 0, err
+		}
+		magic := make([]byte, 4)
+		if _, err := r.ReadAt(magic, size); err == nil && bytes.Equal(magic, []byte("fLaC")) {
+			return formatFLAC, nil
+		}
+		return formatMP3, nil
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return formatMP3, nil
+	}
+	return 0, ErrUnsupportedFormat
+}
+
+type fileReader interface {
+	io.ReaderAt
+	Stat() (fs.FileInfo, error)
+}
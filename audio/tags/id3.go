@@ -0,0 +1,630 @@
+package tags
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// https://id3.org/id3v2.4.0-structure
+// https://id3.org/id3v2.3.0
+// https://id3.org/id3v2-00
+
+const (
+	id3HeaderSize = 10
+
+	id3FlagUnsynchronisation = 0x80
+	id3FlagExtendedHeader    = 0x40
+	id3FlagFooter            = 0x10
+
+	id3EncodingLatin1  = 0
+	id3EncodingUTF16   = 1
+	id3EncodingUTF16BE = 2
+	id3EncodingUTF8    = 3
+)
+
+// id3Frame is a single ID3v2 frame with an ID3v2.3/2.4 frame id. Data is
+// already freed from unsynchronisation, compression and data length
+// indicators.
+type id3Frame struct {
+	ID   string
+	Data []byte
+}
+
+type id3Tag struct {
+	Version byte // major version: 2, 3 or 4
+	Frames  []id3Frame
+}
+
+// Frame ids of ID3v2.2 and their ID3v2.3/2.4 equivalent
+var id3v22FrameIDs = map[string]string{
+	"TT2": "TIT2",
+	"TP1": "TPE1",
+	"TP2": "TPE2",
+	"TAL": "TALB",
+	"TYE": "TYER",
+	"TRK": "TRCK",
+	"TPA": "TPOS",
+	"TCO": "TCON",
+	"TXX": "TXXX",
+	"COM": "COMM",
+	"PIC": "PIC", // different layout than APIC, kept as is
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// id3v2TagSize returns the size of the ID3v2 tag at the start of r, including
+// header and footer, or 0 if there is none.
+func id3v2TagSize(r io.ReaderAt) (int64, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !bytes.HasPrefix(header, []byte("ID3")) {
+		return 0, nil
+	}
+	size := int64(id3HeaderSize) + int64(syncsafe(header[6:10]))
+	if header[5]&id3FlagFooter != 0 {
+		size += id3HeaderSize
+	}
+	return size, nil
+}
+
+// readID3v2 reads the ID3v2 tag at the start of r. It returns nil if there is
+// none.
+func readID3v2(r io.ReaderAt) (*id3Tag, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, []byte("ID3")) {
+		return nil, nil
+	}
+	version, flags := header[3], header[5]
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("%w: unknown ID3v2 version 2.%d", ErrMalformedTag, version)
+	}
+
+	body := make([]byte, syncsafe(header[6:10]))
+	if _, err := r.ReadAt(body, id3HeaderSize); err != nil {
+		return nil, fmt.Errorf("%w: truncated ID3v2 tag: %w", ErrMalformedTag, err)
+	}
+	if flags&id3FlagUnsynchronisation != 0 && version < 4 {
+		body = removeUnsynchronisation(body)
+	}
+	if flags&id3FlagExtendedHeader != 0 && version > 2 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("%w: truncated extended header", ErrMalformedTag)
+		}
+		if version == 3 {
+			body = body[min(len(body), 4+int(binary.BigEndian.Uint32(body))):]
+		} else {
+			body = body[min(len(body), int(syncsafe(body))):]
+		}
+	}
+
+	tag := &id3Tag{Version: version}
+	for len(body) > 0 && body[0] != 0 { // a zero byte starts the padding
+		frame, rest, err := parseID3Frame(body, version, flags&id3FlagUnsynchronisation != 0)
+		if err != nil {
+			return nil, err
+		}
+		body = rest
+		if frame != nil {
+			tag.Frames = append(tag.Frames, *frame)
+		}
+	}
+	return tag, nil
+}
+
+// parseID3Frame parses the frame at the start of b and returns the remaining
+// bytes. The returned frame is nil if it is encrypted and has to be skipped.
+func parseID3Frame(b []byte, version byte, unsynchronised bool) (*id3Frame, []byte, error) {
+	if version == 2 {
+		if len(b) < 6 {
+			return nil, nil, fmt.Errorf("%w: truncated frame header", ErrMalformedTag)
+		}
+		id := string(b[:3])
+		size := int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+		if len(b) < 6+size {
+			return nil, nil, fmt.Errorf("%w: frame %s exceeds tag size", ErrMalformedTag, id)
+		}
+		if newID, ok := id3v22FrameIDs[id]; ok {
+			id = newID
+		}
+		return &id3Frame{ID: id, Data: b[6 : 6+size]}, b[6+size:], nil
+	}
+
+	if len(b) < 10 {
+		return nil, nil, fmt.Errorf("%w: truncated frame header", ErrMalformedTag)
+	}
+	id := string(b[:4])
+	var size int
+	if version == 4 {
+		size = int(syncsafe(b[4:8]))
+	} else {
+		size = int(binary.BigEndian.Uint32(b[4:8]))
+	}
+	if len(b) < 10+size {
+		return nil, nil, fmt.Errorf("%w: frame %s exceeds tag size", ErrMalformedTag, id)
+	}
+	flags := b[9]
+	data, rest := b[10:10+size], b[10+size:]
+
+	var compressed, encrypted, hasDataLength bool
+	if version == 4 {
+		if flags&0x40 != 0 && len(data) > 0 { // grouping identity
+			data = data[1:]
+		}
+		compressed, encrypted, hasDataLength = flags&0x08 != 0, flags&0x04 != 0, flags&0x01 != 0
+		if flags&0x02 != 0 || unsynchronised {
+			data = removeUnsynchronisation(data)
+		}
+		if hasDataLength && len(data) >= 4 {
+			data = data[4:]
+		}
+	} else {
+		compressed, encrypted = flags&0x80 != 0, flags&0x40 != 0
+		if compressed && len(data) >= 4 { // decompressed size
+			data = data[4:]
+		}
+		if flags&0x20 != 0 && len(data) > 0 { // grouping identity
+			data = data[1:]
+		}
+	}
+	if encrypted {
+		return nil, rest, nil
+	}
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: frame %s: %w", ErrMalformedTag, id, err)
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: frame %s: %w", ErrMalformedTag, id, err)
+		}
+	}
+	return &id3Frame{ID: id, Data: data}, rest, nil
+}
+
+func removeUnsynchronisation(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+// decodeID3Text decodes the content of a text frame into its values. ID3v2.4
+// separates multiple values with a terminator.
+func decodeID3Text(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	encoding, data := data[0], data[1:]
+
+	var values []string
+	switch encoding {
+	case id3EncodingUTF16, id3EncodingUTF16BE:
+		var bigEndian = encoding == id3EncodingUTF16BE
+		for len(data) >= 2 {
+			end := 0
+			for end+1 < len(data) && (data[end] != 0 || data[end+1] != 0) {
+				end += 2
+			}
+			values = append(values, decodeUTF16(data[:min(end, len(data)&^1)], bigEndian))
+			data = data[min(end+2, len(data)):]
+		}
+	default:
+		for _, value := range bytes.Split(bytes.TrimRight(data, "\x00"), []byte{0}) {
+			if encoding == id3EncodingLatin1 {
+				values = append(values, decodeLatin1(value))
+			} else {
+				values = append(values, string(value))
+			}
+		}
+	}
+	return values
+}
+
+// decodeUTF16 decodes UTF-16 text, honouring a byte order mark if there is one.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		if b[0] == 0xFF && b[1] == 0xFE {
+			bigEndian, b = false, b[2:]
+		} else if b[0] == 0xFE && b[1] == 0xFF {
+			bigEndian, b = true, b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeLatin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// firstValue returns the first value of the text frame with id, or "".
+func (tag *id3Tag) firstValue(id string) string {
+	for _, frame := range tag.Frames {
+		if frame.ID == id {
+			if values := decodeID3Text(frame.Data); len(values) > 0 {
+				return strings.TrimSpace(values[0])
+			}
+		}
+	}
+	return ""
+}
+
+func (tag *id3Tag) toTags() *Tags {
+	t := &Tags{
+		Title:       tag.firstValue("TIT2"),
+		Artist:      tag.firstValue("TPE1"),
+		AlbumArtist: tag.firstValue("TPE2"),
+		Album:       tag.firstValue("TALB"),
+		Date:        tag.firstValue("TDRC"),
+		Genre:       parseID3Genre(tag.firstValue("TCON")),
+	}
+	if t.Date == "" {
+		t.Date = tag.firstValue("TYER")
+	}
+	// Malformed numbers are ignored when reading, there is nothing to fix them with
+	parseNumberPair(tag.firstValue("TRCK"), &t.Track, &t.TrackTotal)
+	parseNumberPair(tag.firstValue("TPOS"), &t.Disc, &t.DiscTotal)
+
+	for _, frame := range tag.Frames {
+		if frame.ID != "TXXX" {
+			continue
+		}
+		values := decodeID3Text(frame.Data)
+		if len(values) < 2 || values[0] == "" {
+			continue
+		}
+		if t.Custom == nil {
+			t.Custom = map[string]string{}
+		}
+		t.Custom[strings.ToUpper(values[0])] = strings.Join(values[1:], "; ")
+	}
+	return t
+}
+
+var id3GenreRefRegexp = regexp.MustCompile(`^\((\d+)\)(.*)$`)
+
+// parseID3Genre resolves numeric ID3v1 genre references like "(17)" or "17".
+func parseID3Genre(genre string) string {
+	if match := id3GenreRefRegexp.FindStringSubmatch(genre); match != nil {
+		if match[2] != "" {
+			return match[2]
+		}
+		genre = match[1]
+	}
+	if n, err := strconv.Atoi(genre); err == nil && n >= 0 && n < len(id3v1Genres) {
+		return id3v1Genres[n]
+	}
+	return genre
+}
+
+// readID3v1 reads the ID3v1(.1) tag at the end of r. It returns nil if there is
+// none.
+func readID3v1(r io.ReaderAt, size int64) *Tags {
+	if size < 128 {
+		return nil
+	}
+	b := make([]byte, 128)
+	if _, err := r.ReadAt(b, size-128); err != nil || !bytes.HasPrefix(b, []byte("TAG")) {
+		return nil
+	}
+	field := func(from int, to int) string {
+		return strings.TrimSpace(decodeLatin1(bytes.TrimRight(b[from:to], "\x00 ")))
+	}
+	t := &Tags{
+		Title:  field(3, 33),
+		Artist: field(33, 63),
+		Album:  field(63, 93),
+		Date:   field(93, 97),
+	}
+	if b[125] == 0 && b[126] != 0 {
+		t.Track = int(b[126])
+	}
+	if int(b[127]) < len(id3v1Genres) {
+		t.Genre = id3v1Genres[b[127]]
+	}
+	return t
+}
+
+func readMP3(f fileReader) (*Tags, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tag, err := readID3v2(f)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tags{}
+	if tag != nil {
+		t = tag.toTags()
+	}
+	if v1 := readID3v1(f, info.Size()); v1 != nil {
+		t.fillFrom(v1)
+	}
+	return t, nil
+}
+
+// fillFrom sets all empty fields of t to the values of other.
+func (t *Tags) fillFrom(other *Tags) {
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fillInt := func(dst *int, src int) {
+		if *dst == 0 {
+			*dst = src
+		}
+	}
+	fill(&t.Title, other.Title)
+	fill(&t.Artist, other.Artist)
+	fill(&t.AlbumArtist, other.AlbumArtist)
+	fill(&t.Album, other.Album)
+	fill(&t.Date, other.Date)
+	fill(&t.Genre, other.Genre)
+	fillInt(&t.Track, other.Track)
+	fillInt(&t.TrackTotal, other.TrackTotal)
+	fillInt(&t.Disc, other.Disc)
+	fillInt(&t.DiscTotal, other.DiscTotal)
+}
+
+// Frames represented by the fixed members of Tags
+var id3StandardFrames = map[string]bool{
+	"TIT2": true,
+	"TPE1": true,
+	"TPE2": true,
+	"TALB": true,
+	"TDRC": true,
+	"TYER": true,
+	"TRCK": true,
+	"TPOS": true,
+	"TCON": true,
+}
+
+// ID3v2.3 frames which were replaced in ID3v2.4. Frames mapped to "" are
+// dropped when writing.
+var id3v23Replacements = map[string]string{
+	"TORY": "TDOR",
+	"IPLS": "TIPL",
+	"TDAT": "",
+	"TIME": "",
+	"TRDA": "",
+	"TSIZ": "",
+	"EQUA": "",
+	"RVAD": "",
+}
+
+// update replaces the frames described by Tags with the values of t and
+// converts the remaining frames to ID3v2.4. TXXX frames with an unchanged
+// value are kept as they are.
+func (tag *id3Tag) update(t *Tags) {
+	original := tag.toTags()
+	var frames []id3Frame
+	add := func(id string, value string) {
+		if value != "" {
+			frames = append(frames, id3Frame{ID: id, Data: append([]byte{id3EncodingUTF8}, value...)})
+		}
+	}
+	add("TIT2", t.Title)
+	add("TPE1", t.Artist)
+	add("TPE2", t.AlbumArtist)
+	add("TALB", t.Album)
+	add("TDRC", t.Date)
+	add("TRCK", formatNumberPair(t.Track, t.TrackTotal))
+	add("TPOS", formatNumberPair(t.Disc, t.DiscTotal))
+	add("TCON", t.Genre)
+
+	for _, frame := range tag.Frames {
+		if replacement, ok := id3v23Replacements[frame.ID]; ok && tag.Version < 4 {
+			if replacement == "" {
+				continue
+			}
+			frame.ID = replacement
+		}
+		if frame.ID == "PIC" {
+			frame = convertID3v22Picture(frame)
+		}
+		switch {
+		case len(frame.ID) != 4 || id3StandardFrames[frame.ID]:
+			continue // ID3v2.2 frames without equivalent are dropped as well
+		case frame.ID == "TXXX":
+			values := decodeID3Text(frame.Data)
+			if len(values) < 2 || !unchangedCustom(t, original, strings.ToUpper(values[0])) {
+				continue
+			}
+		}
+		frames = append(frames, frame)
+	}
+	for _, key := range changedCustom(t, original) {
+		add("TXXX", key+"\x00"+t.Custom[key])
+	}
+	tag.Version = 4
+	tag.Frames = frames
+}
+
+// convertID3v22Picture converts a PIC frame, which names the image format
+// instead of its MIME type, into an APIC frame.
+func convertID3v22Picture(frame id3Frame) id3Frame {
+	if len(frame.Data) < 4 {
+		return frame
+	}
+	mime := "image/" + strings.ToLower(string(frame.Data[1:4]))
+	if mime == "image/jpg" {
+		mime = "image/jpeg"
+	}
+	data := append([]byte{frame.Data[0]}, mime...)
+	data = append(data, 0)
+	data = append(data, frame.Data[4:]...)
+	return id3Frame{ID: "APIC", Data: data}
+}
+
+// ID3v2 returns an ID3v2.4 tag with the values of t and pictures, i.e. for
+// the "id3 " chunk of WAV and AIFF files.
+func ID3v2(t *Tags, pictures []Picture) []byte {
+	tag := &id3Tag{Version: 4}
+	tag.update(t)
+	for _, p := range pictures {
+		tag.Frames = append(tag.Frames, id3PictureFrame(p))
+	}
+	return tag.bytes(0)
+}
+
+// bytes serialises the tag as ID3v2.4 with padding bytes of padding.
+func (tag *id3Tag) bytes(padding int) []byte {
+	var body []byte
+	for _, frame := range tag.Frames {
+		body = append(body, frame.ID...)
+		body = append(body, syncsafeBytes(uint32(len(frame.Data)))...)
+		body = append(body, 0, 0) // flags
+		body = append(body, frame.Data...)
+	}
+	body = append(body, make([]byte, padding)...)
+
+	b := append([]byte("ID3"), 4, 0, 0)
+	b = append(b, syncsafeBytes(uint32(len(body)))...)
+	return append(b, body...)
+}
+
+func syncsafeBytes(n uint32) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// updateID3v1 sets the fields of the ID3v1 tag b to the values of t. The
+// comment is kept. Values are truncated to the size of the fields.
+func updateID3v1(b []byte, t *Tags) {
+	field := func(from int, to int, value string) {
+		clear(b[from:to])
+		i := from
+		for _, r := range value {
+			if i == to {
+				break
+			}
+			if r > 0xFF {
+				r = '?'
+			}
+			b[i] = byte(r)
+			i++
+		}
+	}
+	field(3, 33, t.Title)
+	field(33, 63, t.Artist)
+	field(63, 93, t.Album)
+	field(93, 97, t.Date)
+	if t.Track > 0 && t.Track <= 0xFF {
+		b[125], b[126] = 0, byte(t.Track)
+	} else if b[125] == 0 {
+		b[126] = 0
+	}
+	b[127] = 0xFF
+	for i, genre := range id3v1Genres {
+		if strings.EqualFold(genre, t.Genre) {
+			b[127] = byte(i)
+		}
+	}
+}
+
+// writeMP3 writes the MP3 file f with its tags replaced by t to w. An
+// existing ID3v1 tag is updated as well.
+func writeMP3(f fileReader, w io.Writer, t *Tags) error {
+	return rewriteMP3(f, w, func(tag *id3Tag) { tag.update(t) }, func(v1 []byte) { updateID3v1(v1, t) })
+}
+
+// rewriteMP3 writes the MP3 file f with its ID3v2 tag changed by update and
+// an existing ID3v1 tag changed by updateV1 to w. update has to convert the
+// tag to ID3v2.4, which it is always written as.
+func rewriteMP3(f fileReader, w io.Writer, update func(tag *id3Tag), updateV1 func(v1 []byte)) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	tag, err := readID3v2(f)
+	if err != nil {
+		return err
+	}
+	audioOffset, err := id3v2TagSize(f)
+	if err != nil {
+		return err
+	}
+	if tag == nil {
+		tag = &id3Tag{Version: 4}
+	}
+	update(tag)
+
+	// Keep the audio data in place if the new tag fits
+	padding := 0
+	if size := len(tag.bytes(0)); int64(size) < audioOffset {
+		padding = int(audioOffset) - size
+	}
+	if _, err := w.Write(tag.bytes(padding)); err != nil {
+		return err
+	}
+
+	audioEnd := info.Size()
+	var v1 []byte
+	if readID3v1(f, info.Size()) != nil {
+		audioEnd -= 128
+		v1 = make([]byte, 128)
+		if _, err := f.ReadAt(v1, audioEnd); err != nil {
+			return err
+		}
+		updateV1(v1)
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(f, audioOffset, audioEnd-audioOffset)); err != nil {
+		return err
+	}
+	if v1 != nil {
+		_, err = w.Write(v1)
+	}
+	return err
+}
+
+// https://en.wikipedia.org/wiki/List_of_ID3v1_genres
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "Rhythm and Blues", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz & Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native US", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock 'n' Roll", "Hard Rock",
+}
@@ -0,0 +1,274 @@
+package tags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// MPEG-4 files (.m4a) consist of nested atoms. Tags are items of the atom
+// moov/udta/meta/ilst, each holding its values in data atoms.
+// https://developer.apple.com/documentation/quicktime-file-format/metadata_item_list_atom
+
+// Type indicators of data atoms
+const (
+	mp4TypeUTF8 = 1
+	mp4TypeJPEG = 13
+	mp4TypePNG  = 14
+	mp4TypeBMP  = 27
+)
+
+type mp4Atom struct {
+	Type   string
+	Offset int64 // of the data in the file
+	Size   int64 // of the data
+}
+
+// readMP4Atoms returns the atoms of r between offset and end.
+func readMP4Atoms(r io.ReaderAt, offset int64, end int64) ([]mp4Atom, error) {
+	var atoms []mp4Atom
+	header := make([]byte, 16)
+	for offset+8 <= end {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("%w: truncated atom header: %w", ErrMalformedTag, err)
+		}
+		a := mp4Atom{Type: string(header[4:8]), Offset: offset + 8}
+		size := int64(binary.BigEndian.Uint32(header))
+		switch size {
+		case 0: // up to the end
+			size = end - offset
+		case 1: // 64 bit size after the type
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("%w: truncated atom header: %w", ErrMalformedTag, err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			a.Offset += 8
+		}
+		a.Size = offset + size - a.Offset
+		if a.Size < 0 || offset+size > end {
+			return nil, fmt.Errorf("%w: atom \"%s\" exceeds its parent", ErrMalformedTag, a.Type)
+		}
+		atoms = append(atoms, a)
+		offset += size
+	}
+	return atoms, nil
+}
+
+// findMP4Atom returns the atom at path below the atoms between offset and end.
+func findMP4Atom(r io.ReaderAt, offset int64, end int64, path ...string) (mp4Atom, bool, error) {
+	atoms, err := readMP4Atoms(r, offset, end)
+	if err != nil {
+		return mp4Atom{}, false, err
+	}
+	for _, a := range atoms {
+		if a.Type != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return a, true, nil
+		}
+		offset := a.Offset
+		if a.Type == "meta" {
+			offset += 4 // version and flags
+		}
+		return findMP4Atom(r, offset, a.Offset+a.Size, path[1:]...)
+	}
+	return mp4Atom{}, false, nil
+}
+
+// MP4Format is the format of the audio track of an MPEG-4 file, which the
+// tags package reads because no decoder supports AAC.
+type MP4Format struct {
+	SampleRate int
+	Channels   int
+}
+
+// ReadMP4Format returns the format of the first track of the MPEG-4 file at
+// path, as given by its sample description.
+func ReadMP4Format(path string) (MP4Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return MP4Format{}, err
+	}
+	defer f.Close()
+	format, err := detectFormat(f)
+	if err != nil {
+		return MP4Format{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if format != formatMP4 {
+		return MP4Format{}, fmt.Errorf("%s: %w: not an MPEG-4 file", path, ErrUnsupportedFormat)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return MP4Format{}, err
+	}
+	stsd, found, err := findMP4Atom(f, 0, info.Size(), "moov", "trak", "mdia", "minf", "stbl", "stsd")
+	if err != nil {
+		return MP4Format{}, fmt.Errorf("%s: %w", path, err)
+	}
+	// Version, flags and entry count, then the first sample entry: its atom
+	// header, 8 bytes of reserved fields and data reference, 8 bytes of
+	// version and vendor, the channels, sample size, compression, packet size
+	// and the 16.16 fixed point sample rate
+	entry := make([]byte, 8+8+8+8+12)
+	if !found || stsd.Size < int64(len(entry)) {
+		return MP4Format{}, fmt.Errorf("%s: %w: no sample description", path, ErrMalformedTag)
+	}
+	if _, err := f.ReadAt(entry, stsd.Offset); err != nil {
+		return MP4Format{}, err
+	}
+	return MP4Format{
+		Channels:   int(binary.BigEndian.Uint16(entry[32:])),
+		SampleRate: int(binary.BigEndian.Uint16(entry[40:])),
+	}, nil
+}
+
+type mp4Value struct {
+	Type int
+	Data []byte
+}
+
+// readMP4Items returns the values of all items of the ilst atom of f by item
+// type. Freeform items are returned as "----:<name>".
+func readMP4Items(f fileReader) (map[string][]mp4Value, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ilst, found, err := findMP4Atom(f, 0, info.Size(), "moov", "udta", "meta", "ilst")
+	if err != nil || !found {
+		return nil, err
+	}
+	items, err := readMP4Atoms(f, ilst.Offset, ilst.Offset+ilst.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string][]mp4Value{}
+	for _, item := range items {
+		children, err := readMP4Atoms(f, item.Offset, item.Offset+item.Size)
+		if err != nil {
+			return nil, err
+		}
+		name := item.Type
+		for _, child := range children {
+			data := make([]byte, child.Size)
+			if _, err := f.ReadAt(data, child.Offset); err != nil {
+				return nil, err
+			}
+			// All children start with version and flags, data atoms then
+			// with their type and locale
+			switch {
+			case len(data) < 4:
+				return nil, fmt.Errorf("%w: truncated atom \"%s\"", ErrMalformedTag, child.Type)
+			case child.Type == "name":
+				name = "----:" + string(data[4:])
+			case child.Type == "data" && len(data) >= 8:
+				values[name] = append(values[name], mp4Value{Type: int(binary.BigEndian.Uint32(data) & 0xFFFFFF), Data: data[8:]})
+			}
+		}
+	}
+	return values, nil
+}
+
+func readMP4(f fileReader) (*Tags, error) {
+	items, err := readMP4Items(f)
+	if err != nil {
+		return nil, err
+	}
+	text := func(item string) string {
+		for _, v := range items[item] {
+			if v.Type == mp4TypeUTF8 {
+				return strings.TrimSpace(string(v.Data))
+			}
+		}
+		return ""
+	}
+	pair := func(item string, n *int, total *int) {
+		// Reserved, number and total as 16 bit integers
+		if v := items[item]; len(v) > 0 && len(v[0].Data) >= 6 {
+			*n = int(binary.BigEndian.Uint16(v[0].Data[2:]))
+			*total = int(binary.BigEndian.Uint16(v[0].Data[4:]))
+		}
+	}
+
+	t := &Tags{
+		Title:       text("\xa9nam"),
+		Artist:      text("\xa9ART"),
+		AlbumArtist: text("aART"),
+		Album:       text("\xa9alb"),
+		Date:        text("\xa9day"),
+		Genre:       text("\xa9gen"),
+	}
+	// Predefined genres are stored as ID3v1 genre number plus one
+	if v := items["gnre"]; t.Genre == "" && len(v) > 0 && len(v[0].Data) >= 2 {
+		if n := int(binary.BigEndian.Uint16(v[0].Data)) - 1; n >= 0 && n < len(id3v1Genres) {
+			t.Genre = id3v1Genres[n]
+		}
+	}
+	pair("trkn", &t.Track, &t.TrackTotal)
+	pair("disk", &t.Disc, &t.DiscTotal)
+
+	for item := range items {
+		name, freeform := strings.CutPrefix(item, "----:")
+		if !freeform {
+			continue
+		}
+		if value := text(item); value != "" {
+			if t.Custom == nil {
+				t.Custom = map[string]string{}
+			}
+			t.Custom[strings.ToUpper(name)] = value
+		}
+	}
+	return t, nil
+}
+
+func readMP4Pictures(f fileReader) ([]Picture, error) {
+	items, err := readMP4Items(f)
+	if err != nil {
+		return nil, err
+	}
+	var pictures []Picture
+	for _, v := range items["covr"] {
+		p := Picture{Type: PICTURE_FRONT_COVER, Data: v.Data}
+		switch v.Type {
+		case mp4TypeJPEG:
+			p.MIME = "image/jpeg"
+		case mp4TypePNG:
+			p.MIME = "image/png"
+		case mp4TypeBMP:
+			p.MIME = "image/bmp"
+		}
+		pictures = append(pictures, p)
+	}
+	return pictures, nil
+}
+
+// copyMP4Audio copies the content of all media data atoms of f to w.
+func copyMP4Audio(f fileReader, w io.Writer) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	atoms, err := readMP4Atoms(f, 0, info.Size())
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, a := range atoms {
+		if a.Type != "mdat" {
+			continue
+		}
+		found = true
+		if _, err := io.Copy(w, io.NewSectionReader(f, a.Offset, a.Size)); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: MPEG-4 file without media data", ErrMalformedTag)
+	}
+	return nil
+}
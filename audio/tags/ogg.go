@@ -0,0 +1,163 @@
+package tags
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/makl11/musiman/audio/ogg"
+)
+
+var (
+	vorbisIdentificationHeader = []byte("\x01vorbis")
+	vorbisCommentHeader        = []byte("\x03vorbis")
+	opusIdentificationHeader   = []byte("OpusHead")
+	opusCommentHeader          = []byte("OpusTags")
+)
+
+// readOggComment returns the comment header packet of the first logical stream
+// and the length of its codec specific prefix.
+func readOggComment(r io.Reader) ([]byte, int, error) {
+	packets := ogg.NewPacketReader(r)
+	if _, err := packets.NextPacket(); err != nil { // identification header
+		return nil, 0, fmt.Errorf("%w: %w", ErrMalformedTag, err)
+	}
+	packet, err := packets.NextPacket()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrMalformedTag, err)
+	}
+	switch {
+	case bytes.HasPrefix(packet, vorbisCommentHeader):
+		return packet, len(vorbisCommentHeader), nil
+	case bytes.HasPrefix(packet, opusCommentHeader):
+		return packet, len(opusCommentHeader), nil
+	}
+	return nil, 0, fmt.Errorf("%w: ogg stream without vorbis or opus comment header", ErrUnsupportedFormat)
+}
+
+func readOgg(f fileReader) (*Tags, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	packet, prefix, err := readOggComment(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	vc, err := parseVorbisComment(packet[prefix:])
+	if err != nil {
+		return nil, err
+	}
+	return vc.toTags(), nil
+}
+
+// writeOgg writes the Ogg Vorbis or Opus stream of f with its comment header
+// replaced by t to w.
+func writeOgg(f fileReader, w io.Writer, t *Tags) error {
+	return rewriteOgg(f, w, func(vc *vorbisComment) { vc.update(t) })
+}
+
+// rewriteOgg writes the Ogg Vorbis or Opus stream of f with its comment
+// header changed by update to w. The header pages are paginated anew and the
+// sequence numbers of all following pages of the stream are shifted
+// accordingly.
+func rewriteOgg(f fileReader, w io.Writer, update func(vc *vorbisComment)) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r := io.NewSectionReader(f, 0, info.Size())
+
+	// Collect the header packets, which have to end on a page boundary
+	var headers [][]byte
+	var partial []byte
+	var serial, firstSequence uint32
+	headerCount, headerPages := 0, uint32(0)
+	for headerCount == 0 || len(headers) < headerCount || partial != nil {
+		page, err := ogg.ReadPage(r)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedTag, err)
+		}
+		if headerPages == 0 {
+			serial, firstSequence = page.Serial, page.Sequence
+		} else if page.Serial != serial {
+			return fmt.Errorf("%w: multiplexed ogg streams", ErrUnsupportedFormat)
+		}
+		headerPages++
+
+		packets, lastComplete := page.Packets()
+		for i, packet := range packets {
+			if i == 0 && page.HeaderType&ogg.HEADER_CONTINUED != 0 {
+				packet = append(partial, packet...)
+				partial = nil
+			}
+			if i == len(packets)-1 && !lastComplete {
+				partial = append([]byte(nil), packet...)
+				break
+			}
+			headers = append(headers, packet)
+		}
+		if headerCount == 0 && len(headers) > 0 {
+			switch {
+			case bytes.HasPrefix(headers[0], vorbisIdentificationHeader):
+				headerCount = 3
+			case bytes.HasPrefix(headers[0], opusIdentificationHeader):
+				headerCount = 2
+			default:
+				return fmt.Errorf("%w: ogg stream is neither vorbis nor opus", ErrUnsupportedFormat)
+			}
+		}
+		if headerCount > 0 && len(headers) > headerCount {
+			return fmt.Errorf("%w: audio data starts on a header page", ErrMalformedTag)
+		}
+	}
+
+	prefix := vorbisCommentHeader
+	if headerCount == 2 {
+		prefix = opusCommentHeader
+	}
+	if !bytes.HasPrefix(headers[1], prefix) {
+		return fmt.Errorf("%w: missing comment header", ErrMalformedTag)
+	}
+	vc, err := parseVorbisComment(headers[1][len(prefix):])
+	if err != nil {
+		return err
+	}
+	update(vc)
+	comment := append(append([]byte(nil), prefix...), vc.bytes()...)
+	if headerCount == 3 {
+		comment = append(comment, 1) // framing bit
+	}
+	headers[1] = comment
+
+	// The identification header is alone on the first page
+	pages := ogg.Paginate(headers[:1], serial, firstSequence, 0)
+	pages[0].HeaderType |= ogg.HEADER_BOS
+	pages = append(pages, ogg.Paginate(headers[1:], serial, firstSequence+uint32(len(pages)), 0)...)
+	for _, page := range pages {
+		if _, err := w.Write(page.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	shift := uint32(len(pages)) - headerPages
+	if shift == 0 {
+		_, err = io.Copy(w, r)
+		return err
+	}
+	for {
+		page, err := ogg.ReadPage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedTag, err)
+		}
+		if page.Serial == serial {
+			page.Sequence += shift
+		}
+		if _, err := w.Write(page.Bytes()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Picture types of ID3v2 APIC frames and FLAC PICTURE blocks
+const (
+	PICTURE_OTHER       = 0
+	PICTURE_FRONT_COVER = 3
+	PICTURE_BACK_COVER  = 4
+)
+
+// Picture is an image embedded in a music file.
+type Picture struct {
+	Type        int    // i.e. PICTURE_FRONT_COVER
+	MIME        string // i.e. "image/jpeg"
+	Description string
+	// Size in pixels, only stored in FLAC and Ogg files. 0 if unknown.
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// Vorbis comment field of pictures in Ogg files, holding a base64 encoded
+// FLAC PICTURE block
+const vorbisPictureField = "METADATA_BLOCK_PICTURE"
+
+// ReadPictures returns the pictures embedded in the music file at path. MP4
+// cover art has no picture type and is returned as PICTURE_FRONT_COVER.
+func ReadPictures(path string) ([]Picture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	format, err := detectFormat(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var pictures []Picture
+	switch format {
+	case formatMP3:
+		var tag *id3Tag
+		if tag, err = readID3v2(f); err == nil && tag != nil {
+			pictures, err = tag.pictures()
+		}
+	case formatFLAC:
+		var blocks []flacBlock
+		if blocks, _, err = readFLACBlocks(f); err != nil {
+			break
+		}
+		for _, block := range blocks {
+			if block.Type != flacBlockPicture {
+				continue
+			}
+			p, err := parseFLACPicture(block.Data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			pictures = append(pictures, p)
+		}
+	case formatOgg:
+		var packet []byte
+		var prefix int
+		var info os.FileInfo
+		if info, err = f.Stat(); err != nil {
+			break
+		}
+		if packet, prefix, err = readOggComment(io.NewSectionReader(f, 0, info.Size())); err != nil {
+			break
+		}
+		var vc *vorbisComment
+		if vc, err = parseVorbisComment(packet[prefix:]); err == nil {
+			pictures, err = vc.pictures()
+		}
+	case formatWAV, formatAIFF:
+		var order binary.ByteOrder = binary.LittleEndian
+		if format == formatAIFF {
+			order = binary.BigEndian
+		}
+		var chunks []chunk
+		if chunks, err = readChunks(f, order); err != nil {
+			break
+		}
+		var tag *id3Tag
+		if tag, err = readID3ChunkTag(chunks); err == nil && tag != nil {
+			pictures, err = tag.pictures()
+		}
+	case formatMP4:
+		pictures, err = readMP4Pictures(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pictures, nil
+}
+
+// SetPicture embeds p in the music file at path, replacing all pictures of
+// the same type. It is written like Write writes tags.
+func SetPicture(path string, p Picture) error {
+	return rewrite(path, func(format format, f fileReader, w io.Writer) error {
+		switch format {
+		case formatMP3:
+			return rewriteMP3(f, w, func(tag *id3Tag) {
+				tag.update(tag.toTags())
+				tag.setPicture(p)
+			}, func([]byte) {})
+		case formatFLAC:
+			return rewriteFLAC(f, w, func(blocks []flacBlock) ([]flacBlock, error) {
+				kept := blocks[:0]
+				for _, block := range blocks {
+					if block.Type == flacBlockPicture {
+						if existing, err := parseFLACPicture(block.Data); err == nil && existing.Type == p.Type {
+							continue
+						}
+					}
+					kept = append(kept, block)
+				}
+				return append(kept, flacBlock{Type: flacBlockPicture, Data: flacPictureBytes(p)}), nil
+			})
+		case formatOgg:
+			return rewriteOgg(f, w, func(vc *vorbisComment) { vc.setPicture(p) })
+		}
+		return fmt.Errorf("%w: pictures can only be embedded in MP3, FLAC and Ogg files", ErrUnsupportedFormat)
+	})
+}
+
+// https://id3.org/id3v2.4.0-frames section 4.14
+
+func (tag *id3Tag) pictures() ([]Picture, error) {
+	var pictures []Picture
+	for _, frame := range tag.Frames {
+		if frame.ID == "PIC" {
+			frame = convertID3v22Picture(frame)
+		}
+		if frame.ID != "APIC" {
+			continue
+		}
+		p, err := parseID3Picture(frame.Data)
+		if err != nil {
+			return nil, err
+		}
+		pictures = append(pictures, p)
+	}
+	return pictures, nil
+}
+
+func parseID3Picture(data []byte) (Picture, error) {
+	if len(data) < 2 {
+		return Picture{}, fmt.Errorf("%w: truncated APIC frame", ErrMalformedTag)
+	}
+	encoding := data[0]
+	mime, rest, found := bytes.Cut(data[1:], []byte{0})
+	if !found || len(rest) < 1 {
+		return Picture{}, fmt.Errorf("%w: truncated APIC frame", ErrMalformedTag)
+	}
+	p := Picture{MIME: decodeLatin1(mime), Type: int(rest[0])}
+	rest = rest[1:]
+
+	// The description ends with a terminator of the text encoding
+	end, size := -1, 1
+	if encoding == id3EncodingUTF16 || encoding == id3EncodingUTF16BE {
+		size = 2
+		for i := 0; i+1 < len(rest); i += 2 {
+			if rest[i] == 0 && rest[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+	} else {
+		end = bytes.IndexByte(rest, 0)
+	}
+	if end < 0 {
+		return Picture{}, fmt.Errorf("%w: APIC frame without description terminator", ErrMalformedTag)
+	}
+	if values := decodeID3Text(append([]byte{encoding}, rest[:end]...)); len(values) > 0 {
+		p.Description = values[0]
+	}
+	p.Data = rest[end+size:]
+	if p.MIME != "" && !strings.Contains(p.MIME, "/") { // ID3v2.2 style image format
+		p.MIME = "image/" + strings.ToLower(p.MIME)
+	}
+	return p, nil
+}
+
+// setPicture replaces all APIC frames of the type of p with p.
+func (tag *id3Tag) setPicture(p Picture) {
+	frames := tag.Frames[:0]
+	for _, frame := range tag.Frames {
+		if frame.ID == "APIC" {
+			if existing, err := parseID3Picture(frame.Data); err == nil && existing.Type == p.Type {
+				continue
+			}
+		}
+		frames = append(frames, frame)
+	}
+	tag.Frames = append(frames, id3PictureFrame(p))
+}
+
+func id3PictureFrame(p Picture) id3Frame {
+	data := append([]byte{id3EncodingUTF8}, p.MIME...)
+	data = append(data, 0, byte(p.Type))
+	data = append(data, p.Description...)
+	data = append(data, 0)
+	return id3Frame{ID: "APIC", Data: append(data, p.Data...)}
+}
+
+// https://xiph.org/flac/format.html#metadata_block_picture
+
+func parseFLACPicture(b []byte) (Picture, error) {
+	readUint32 := func() (uint32, error) {
+		if len(b) < 4 {
+			return 0, fmt.Errorf("%w: truncated picture", ErrMalformedTag)
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		return n, nil
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(b)) < uint64(n) {
+			return nil, fmt.Errorf("%w: truncated picture", ErrMalformedTag)
+		}
+		data := b[:n]
+		b = b[n:]
+		return data, nil
+	}
+
+	var p Picture
+	var fields [4]uint32
+	pictureType, err := readUint32()
+	if err != nil {
+		return p, err
+	}
+	mime, err := readBytes()
+	if err != nil {
+		return p, err
+	}
+	description, err := readBytes()
+	if err != nil {
+		return p, err
+	}
+	for i := range 4 { // width, height, color depth, number of colors
+		if fields[i], err = readUint32(); err != nil {
+			return p, err
+		}
+	}
+	data, err := readBytes()
+	if err != nil {
+		return p, err
+	}
+	return Picture{
+		Type:        int(pictureType),
+		MIME:        string(mime),
+		Description: string(description),
+		Width:       int(fields[0]),
+		Height:      int(fields[1]),
+		Data:        data,
+	}, nil
+}
+
+func flacPictureBytes(p Picture) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(p.Type))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p.MIME)))
+	b = append(b, p.MIME...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p.Description)))
+	b = append(b, p.Description...)
+	b = binary.BigEndian.AppendUint32(b, uint32(p.Width))
+	b = binary.BigEndian.AppendUint32(b, uint32(p.Height))
+	b = binary.BigEndian.AppendUint32(b, 0) // color depth, unknown
+	b = binary.BigEndian.AppendUint32(b, 0) // number of colors, not indexed
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p.Data)))
+	return append(b, p.Data...)
+}
+
+func (vc *vorbisComment) pictures() ([]Picture, error) {
+	var pictures []Picture
+	for _, comment := range vc.Comments {
+		if field, ok := normalizeVorbisField(comment); !ok || field != vorbisPictureField {
+			continue
+		}
+		_, value, _ := strings.Cut(comment, "=")
+		block, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: picture: %w", ErrMalformedTag, err)
+		}
+		p, err := parseFLACPicture(block)
+		if err != nil {
+			return nil, err
+		}
+		pictures = append(pictures, p)
+	}
+	return pictures, nil
+}
+
+// setPicture replaces all pictures of the type of p with p.
+func (vc *vorbisComment) setPicture(p Picture) {
+	comments := vc.Comments[:0]
+	for _, comment := range vc.Comments {
+		if field, ok := normalizeVorbisField(comment); ok && field == vorbisPictureField {
+			existing, err := (&vorbisComment{Comments: []string{comment}}).pictures()
+			if err == nil && existing[0].Type == p.Type {
+				continue
+			}
+		}
+		comments = append(comments, comment)
+	}
+	vc.Comments = append(comments, vorbisPictureField+"="+base64.StdEncoding.EncodeToString(flacPictureBytes(p)))
+}
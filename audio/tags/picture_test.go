@@ -0,0 +1,207 @@
+package tags_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/makl11/musiman/audio/ogg"
+	"github.com/makl11/musiman/audio/tags"
+)
+
+func testOggFile(t *testing.T) string {
+	comment := []byte("\x03vorbis")
+	comment = binary.LittleEndian.AppendUint32(comment, 4)
+	comment = append(comment, "test"...)
+	comment = binary.LittleEndian.AppendUint32(comment, 1)
+	comment = binary.LittleEndian.AppendUint32(comment, 8)
+	comment = append(comment, "ARTIST=A"...)
+	comment = append(comment, 1)
+	headers := [][]byte{append([]byte("\x01vorbis"), make([]byte, 23)...), comment, []byte("\x05vorbis setup")}
+
+	var content []byte
+	pages := ogg.Paginate(headers[:1], 7, 0, 0)
+	pages[0].HeaderType |= ogg.HEADER_BOS
+	pages = append(pages, ogg.Paginate(headers[1:], 7, 1, 0)...)
+	pages = append(pages, ogg.Paginate([][]byte{audioData}, 7, uint32(len(pages)), 1024)...)
+	pages[len(pages)-1].HeaderType |= ogg.HEADER_EOS
+	for _, page := range pages {
+		content = append(content, page.Bytes()...)
+	}
+	return writeTestFile(t, "test.ogg", content)
+}
+
+func TestSetPicture(t *testing.T) {
+	flac := []byte("fLaC")
+	flac = append(flac, 0x80, 0, 0, 34) // STREAMINFO
+	flac = append(flac, make([]byte, 34)...)
+	flac = append(flac, audioData...)
+
+	tests := []struct {
+		title string
+		path  string
+	}{
+		{title: "FLAC", path: writeTestFile(t, "test.flac", flac)},
+		{title: "MP3", path: writeTestFile(t, "test.mp3", audioData)},
+		{title: "Ogg", path: testOggFile(t)},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if err := tags.Write(test.path, &tags.Tags{Artist: "Band"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			pictures := []tags.Picture{
+				{Type: tags.PICTURE_FRONT_COVER, MIME: "image/png", Description: "old", Data: []byte("old front")},
+				{Type: tags.PICTURE_BACK_COVER, MIME: "image/png", Data: []byte("back")},
+				{Type: tags.PICTURE_FRONT_COVER, MIME: "image/jpeg", Description: "Fröntcover", Width: 500, Height: 400, Data: []byte("front")},
+			}
+			for _, p := range pictures {
+				if err := tags.SetPicture(test.path, p); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			result, err := tags.ReadPictures(test.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result) != 2 {
+				t.Fatalf("expected 2 pictures, but got %d", len(result))
+			}
+			back, front := result[0], result[1]
+			if back.Type != tags.PICTURE_BACK_COVER || string(back.Data) != "back" {
+				t.Errorf("expected the back cover to be kept, but got type %d with %q", back.Type, back.Data)
+			}
+			if front.Type != tags.PICTURE_FRONT_COVER || front.MIME != "image/jpeg" || front.Description != "Fröntcover" || string(front.Data) != "front" {
+				t.Errorf("expected the front cover to be replaced, but got %+v", front)
+			}
+			if test.title != "MP3" && (front.Width != 500 || front.Height != 400) {
+				t.Errorf("expected size 500x400, but got %dx%d", front.Width, front.Height)
+			}
+
+			if tag, err := tags.Read(test.path); err != nil || tag.Artist != "Band" {
+				t.Errorf("expected tags to be kept, but got %v (%v)", tag, err)
+			}
+		})
+	}
+
+	wav := append([]byte("RIFF\x04\x00\x00\x00WAVE"), make([]byte, 16)...)
+	err := tags.SetPicture(writeTestFile(t, "test.wav", wav), tags.Picture{Type: tags.PICTURE_FRONT_COVER, Data: []byte("front")})
+	if !errors.Is(err, tags.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
+
+func mp4Atom(kind string, children ...[]byte) []byte {
+	content := bytes.Join(children, nil)
+	atom := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	return append(append(atom, kind...), content...)
+}
+
+func mp4Data(dataType uint32, value []byte) []byte {
+	return mp4Atom("data", binary.BigEndian.AppendUint32(nil, dataType), make([]byte, 4), value)
+}
+
+func TestReadMP4(t *testing.T) {
+	mdat := mp4Atom("mdat", audioData)
+	content := bytes.Join([][]byte{
+		mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00M4A isom")),
+		mp4Atom("moov", mp4Atom("udta", mp4Atom("meta", make([]byte, 4), mp4Atom("ilst",
+			mp4Atom("\xa9nam", mp4Data(1, []byte("Song"))),
+			mp4Atom("aART", mp4Data(1, []byte("Band"))),
+			mp4Atom("trkn", mp4Data(0, []byte{0, 0, 0, 3, 0, 12, 0, 0})),
+			mp4Atom("gnre", mp4Data(0, []byte{0, 9})),
+			mp4Atom("covr", mp4Data(13, []byte("jpeg")), mp4Data(14, []byte("png"))),
+			mp4Atom("----", mp4Atom("mean", make([]byte, 4), []byte("com.apple.iTunes")), mp4Atom("name", make([]byte, 4), []byte("MusicBrainz Track Id")), mp4Data(1, []byte("abc"))),
+		)))),
+		mdat,
+	}, nil)
+	path := writeTestFile(t, "test.m4a", content)
+
+	result, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Title != "Song" || result.AlbumArtist != "Band" || result.Genre != "Jazz" {
+		t.Errorf("expected title, album artist and genre %q, %q and %q, but got %q, %q and %q", "Song", "Band", "Jazz", result.Title, result.AlbumArtist, result.Genre)
+	}
+	if result.Track != 3 || result.TrackTotal != 12 {
+		t.Errorf("expected track 3/12, but got %d/%d", result.Track, result.TrackTotal)
+	}
+	if result.Custom["MUSICBRAINZ TRACK ID"] != "abc" {
+		t.Errorf("expected freeform item %q, but got %v", "abc", result.Custom)
+	}
+
+	pictures, err := tags.ReadPictures(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pictures) != 2 || pictures[0].MIME != "image/jpeg" || string(pictures[0].Data) != "jpeg" || pictures[1].MIME != "image/png" {
+		t.Errorf("expected a JPEG and a PNG cover, but got %+v", pictures)
+	}
+
+	var audio bytes.Buffer
+	if err := tags.CopyAudio(&audio, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(audio.Bytes(), audioData) {
+		t.Errorf("expected the media data to be copied")
+	}
+}
+
+func TestReadMP4Format(t *testing.T) {
+	entry := make([]byte, 28) // reserved, data reference, version and vendor
+	binary.BigEndian.PutUint16(entry[16:], 2)
+	binary.BigEndian.PutUint16(entry[18:], 16)
+	binary.BigEndian.PutUint32(entry[24:], 48000<<16)
+	stsd := mp4Atom("stsd", binary.BigEndian.AppendUint32(make([]byte, 4), 1), mp4Atom("mp4a", entry))
+	content := bytes.Join([][]byte{
+		mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00M4A isom")),
+		mp4Atom("moov", mp4Atom("trak", mp4Atom("mdia", mp4Atom("minf", mp4Atom("stbl", stsd))))),
+		mp4Atom("mdat", audioData),
+	}, nil)
+
+	format, err := tags.ReadMP4Format(writeTestFile(t, "test.m4a", content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format.SampleRate != 48000 || format.Channels != 2 {
+		t.Errorf("expected 48000 Hz stereo, but got %+v", format)
+	}
+	if _, err := tags.ReadMP4Format(testOggFile(t)); !errors.Is(err, tags.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
+
+func TestID3v2WAVChunk(t *testing.T) {
+	pictures := []tags.Picture{
+		{Type: tags.PICTURE_OTHER, MIME: "image/png", Data: []byte("first")},
+		{Type: tags.PICTURE_OTHER, MIME: "image/png", Data: []byte("second")},
+	}
+	tag := tags.ID3v2(&tags.Tags{Title: "Song", Track: 3, Custom: map[string]string{"MOOD": "calm"}}, pictures)
+
+	wav := []byte("RIFF\x00\x00\x00\x00WAVE")
+	wav = append(wav, "data"...)
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(len(audioData)))
+	wav = append(wav, audioData...)
+	wav = append(wav, "id3 "...)
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(len(tag)))
+	wav = append(wav, tag...)
+	path := writeTestFile(t, "test.wav", wav)
+
+	read, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if read.Title != "Song" || read.Track != 3 || read.Custom["MOOD"] != "calm" {
+		t.Errorf("expected the tags to be read from the id3 chunk, but got %+v", read)
+	}
+	result, err := tags.ReadPictures(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 2 || string(result[0].Data) != "first" || string(result[1].Data) != "second" {
+		t.Errorf("expected both pictures, but got %+v", result)
+	}
+}
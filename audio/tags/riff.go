@@ -0,0 +1,141 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// https://www.mmsp.ece.mcgill.ca/Documents/AudioFormats/WAVE/WAVE.html
+// https://www.mmsp.ece.mcgill.ca/Documents/AudioFormats/AIFF/AIFF.html
+
+type chunk struct {
+	ID     string
+	Data   []byte
+	Offset int64 // of the data in the file
+	Size   int64
+}
+
+// readChunks reads all chunks of a RIFF (little endian) or IFF (big endian)
+// container, skipping the 12 byte container header.
+func readChunks(f fileReader, order binary.ByteOrder) ([]chunk, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []chunk
+	header := make([]byte, 8)
+	for offset := int64(12); offset+8 <= info.Size(); {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return nil, err
+		}
+		size := int64(order.Uint32(header[4:]))
+		if size == math.MaxUint32 && string(header[:4]) == "data" {
+			size = info.Size() - offset - 8 // unknown size of streamed files
+		}
+		if offset+8+size > info.Size() {
+			return chunks, fmt.Errorf("%w: chunk \"%s\" exceeds file size", ErrMalformedTag, header[:4])
+		}
+		c := chunk{ID: string(header[:4]), Offset: offset + 8, Size: size}
+		// Audio data is not needed for tags and may be huge
+		if c.ID != "data" && c.ID != "SSND" {
+			c.Data = make([]byte, size)
+			if _, err := f.ReadAt(c.Data, offset+8); err != nil {
+				return nil, err
+			}
+		}
+		chunks = append(chunks, c)
+		offset += 8 + size + size%2 // chunks are padded to an even size
+	}
+	return chunks, nil
+}
+
+func readID3Chunk(chunks []chunk) (*Tags, error) {
+	tag, err := readID3ChunkTag(chunks)
+	if err != nil {
+		return nil, err
+	}
+	if tag == nil {
+		return &Tags{}, nil
+	}
+	return tag.toTags(), nil
+}
+
+// readID3ChunkTag returns the ID3v2 tag in the "id3 " chunk of chunks, nil if
+// there is none.
+func readID3ChunkTag(chunks []chunk) (*id3Tag, error) {
+	for _, c := range chunks {
+		if strings.EqualFold(c.ID, "id3 ") {
+			return readID3v2(bytes.NewReader(c.Data))
+		}
+	}
+	return nil, nil
+}
+
+// RIFF INFO list fields
+var riffInfoFields = map[string]string{
+	"INAM": FIELD_TITLE,
+	"IART": FIELD_ARTIST,
+	"IPRD": FIELD_ALBUM,
+	"ICRD": FIELD_DATE,
+	"IGNR": FIELD_GENRE,
+	"ITRK": FIELD_TRACK,
+	"IPRT": FIELD_TRACK,
+}
+
+func readWAV(f fileReader) (*Tags, error) {
+	chunks, err := readChunks(f, binary.LittleEndian)
+	if err != nil {
+		return nil, err
+	}
+	t, err := readID3Chunk(chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Tags{}
+	for _, c := range chunks {
+		if c.ID != "LIST" || !bytes.HasPrefix(c.Data, []byte("INFO")) {
+			continue
+		}
+		for b := c.Data[4:]; len(b) >= 8; {
+			id, size := string(b[:4]), int(binary.LittleEndian.Uint32(b[4:8]))
+			if 8+size > len(b) {
+				break
+			}
+			if field, ok := riffInfoFields[id]; ok {
+				// Malformed numbers are ignored when reading
+				info.SetField(field, string(bytes.TrimRight(b[8:8+size], "\x00")))
+			}
+			b = b[min(len(b), 8+size+size%2):]
+		}
+	}
+	t.fillFrom(info)
+	return t, nil
+}
+
+func readAIFF(f fileReader) (*Tags, error) {
+	chunks, err := readChunks(f, binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+	t, err := readID3Chunk(chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	text := &Tags{}
+	for _, c := range chunks {
+		switch c.ID {
+		case "NAME":
+			text.Title = strings.TrimSpace(string(c.Data))
+		case "AUTH":
+			text.Artist = strings.TrimSpace(string(c.Data))
+		}
+	}
+	t.fillFrom(text)
+	return t, nil
+}
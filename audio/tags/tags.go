@@ -0,0 +1,191 @@
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported file format")
+	ErrMalformedTag      = errors.New("malformed tag")
+)
+
+// Tags holds the metadata of a music file in a format independent way.
+type Tags struct {
+	Title       string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Date        string // as stored in the file, usually YYYY or YYYY-MM-DD
+	Track       int
+	TrackTotal  int
+	Disc        int
+	DiscTotal   int
+	Genre       string
+	// All other text tags, keyed by their upper case Vorbis comment field name
+	// or ID3 TXXX description, i.e. "REPLAYGAIN_TRACK_GAIN".
+	Custom map[string]string
+}
+
+// Canonical field names, as used by path templates.
+const (
+	FIELD_TITLE       = "title"
+	FIELD_ARTIST      = "artist"
+	FIELD_ALBUMARTIST = "albumartist"
+	FIELD_ALBUM       = "album"
+	FIELD_DATE        = "date"
+	FIELD_YEAR        = "year"
+	FIELD_TRACK       = "track"
+	FIELD_TRACKTOTAL  = "tracktotal"
+	FIELD_DISC        = "disc"
+	FIELD_DISCTOTAL   = "disctotal"
+	FIELD_GENRE       = "genre"
+)
+
+// Year returns the year part of Date, or 0 if it has none.
+func (t Tags) Year() int {
+	if len(t.Date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(t.Date[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// Fields returns all non empty tags by their lower case field name. The album
+// artist falls back to the artist if it is not set.
+func (t Tags) Fields() map[string]string {
+	fields := make(map[string]string, len(t.Custom)+11)
+	for k, v := range t.Custom {
+		if v != "" {
+			fields[strings.ToLower(k)] = v
+		}
+	}
+	setString := func(name string, value string) {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	setInt := func(name string, value int) {
+		if value > 0 {
+			fields[name] = strconv.Itoa(value)
+		}
+	}
+
+	setString(FIELD_TITLE, t.Title)
+	setString(FIELD_ARTIST, t.Artist)
+	setString(FIELD_ALBUMARTIST, t.AlbumArtist)
+	if t.AlbumArtist == "" {
+		setString(FIELD_ALBUMARTIST, t.Artist)
+	}
+	setString(FIELD_ALBUM, t.Album)
+	setString(FIELD_DATE, t.Date)
+	setInt(FIELD_YEAR, t.Year())
+	setInt(FIELD_TRACK, t.Track)
+	setInt(FIELD_TRACKTOTAL, t.TrackTotal)
+	setInt(FIELD_DISC, t.Disc)
+	setInt(FIELD_DISCTOTAL, t.DiscTotal)
+	setString(FIELD_GENRE, t.Genre)
+	return fields
+}
+
+// SetField sets the tag with the given field name (see Fields). Numeric fields
+// accept "n" and "n/total". Unknown names are stored in Custom.
+func (t *Tags) SetField(name string, value string) error {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(name) {
+	case FIELD_TITLE:
+		t.Title = value
+	case FIELD_ARTIST:
+		t.Artist = value
+	case FIELD_ALBUMARTIST:
+		t.AlbumArtist = value
+	case FIELD_ALBUM:
+		t.Album = value
+	case FIELD_DATE, FIELD_YEAR:
+		t.Date = value
+	case FIELD_GENRE:
+		t.Genre = value
+	case FIELD_TRACK:
+		return parseNumberPair(value, &t.Track, &t.TrackTotal)
+	case FIELD_TRACKTOTAL:
+		return parseNumber(value, &t.TrackTotal)
+	case FIELD_DISC:
+		return parseNumberPair(value, &t.Disc, &t.DiscTotal)
+	case FIELD_DISCTOTAL:
+		return parseNumber(value, &t.DiscTotal)
+	default:
+		if t.Custom == nil {
+			t.Custom = map[string]string{}
+		}
+		t.Custom[strings.ToUpper(name)] = value
+	}
+	return nil
+}
+
+// Read reads the tags of the music file at path. The format is detected from
+// the file content, not its extension.
+func Read(path string) (*Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	format, err := detectFormat(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags *Tags
+	switch format {
+	case formatMP3:
+		tags, err = readMP3(f)
+	case formatFLAC:
+		tags, err = readFLAC(f)
+	case formatOgg:
+		tags, err = readOgg(f)
+	case formatWAV:
+		tags, err = readWAV(f)
+	case formatAIFF:
+		tags, err = readAIFF(f)
+	case formatMP4:
+		tags, err = readMP4(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tags, nil
+}
+
+// parseNumberPair parses "n" or "n/total". total is only changed if the value
+// contains one.
+func parseNumberPair(value string, n *int, total *int) error {
+	number, rest, hasTotal := strings.Cut(value, "/")
+	if err := parseNumber(number, n); err != nil {
+		return err
+	}
+	if hasTotal {
+		return parseNumber(rest, total)
+	}
+	return nil
+}
+
+func parseNumber(value string, n *int) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		*n = 0
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("%w: \"%s\" is not a valid number", ErrMalformedTag, value)
+	}
+	*n = parsed
+	return nil
+}
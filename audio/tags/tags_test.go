@@ -0,0 +1,106 @@
+package tags_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/audio/tags"
+)
+
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	return path
+}
+
+func id3v23Frame(id string, data []byte) []byte {
+	frame := append([]byte(id), 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(data)))
+	return append(frame, data...)
+}
+
+func TestReadID3v23(t *testing.T) {
+	var frames []byte
+	// UTF-16 with byte order mark
+	frames = append(frames, id3v23Frame("TIT2", []byte{1, 0xFF, 0xFE, 'T', 0, 0xE4, 0, 'g', 0})...)
+	frames = append(frames, id3v23Frame("TPE1", append([]byte{0}, "Band"...))...)
+	frames = append(frames, id3v23Frame("TRCK", append([]byte{0}, "3/12"...))...)
+	frames = append(frames, id3v23Frame("TCON", append([]byte{0}, "(17)"...))...)
+	frames = append(frames, id3v23Frame("TXXX", append([]byte{3}, "replaygain_track_gain\x00-6.5 dB"...))...)
+	frames = append(frames, make([]byte, 16)...) // padding
+
+	size := len(frames)
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	content := append(header, frames...)
+	content = append(content, 0xFF, 0xFB, 0x90, 0x00) // start of an MPEG frame
+
+	result, err := tags.Read(writeTestFile(t, "test.mp3", content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Title != "Täg" {
+		t.Errorf("expected title %q, but got %q", "Täg", result.Title)
+	}
+	if result.Artist != "Band" || result.AlbumArtist != "" {
+		t.Errorf("expected artist %q and no album artist, but got %q and %q", "Band", result.Artist, result.AlbumArtist)
+	}
+	if result.Track != 3 || result.TrackTotal != 12 {
+		t.Errorf("expected track 3/12, but got %d/%d", result.Track, result.TrackTotal)
+	}
+	if result.Genre != "Rock" {
+		t.Errorf("expected genre %q, but got %q", "Rock", result.Genre)
+	}
+	if result.Custom["REPLAYGAIN_TRACK_GAIN"] != "-6.5 dB" {
+		t.Errorf("expected replay gain %q, but got %q", "-6.5 dB", result.Custom["REPLAYGAIN_TRACK_GAIN"])
+	}
+	if fields := result.Fields(); fields["albumartist"] != "Band" {
+		t.Errorf("expected album artist field to fall back to artist, but got %q", fields["albumartist"])
+	}
+}
+
+func TestReadFLACVorbisComment(t *testing.T) {
+	comments := []string{"TITLE=Song", "ALBUM ARTIST=Various", "DATE=2001-05-03", "TRACKNUMBER=7", "TRACKTOTAL=9", "MUSICBRAINZ_ALBUMID=abc"}
+	vendor := "test"
+	block := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	block = append(block, vendor...)
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, c := range comments {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(c)))
+		block = append(block, c...)
+	}
+
+	content := []byte("fLaC")
+	content = append(content, 0, 0, 0, 34) // STREAMINFO
+	content = append(content, make([]byte, 34)...)
+	content = append(content, 0x80|4, byte(len(block)>>16), byte(len(block)>>8), byte(len(block)))
+	content = append(content, block...)
+
+	result, err := tags.Read(writeTestFile(t, "test.flac", content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Title != "Song" || result.AlbumArtist != "Various" {
+		t.Errorf("expected title %q and album artist %q, but got %q and %q", "Song", "Various", result.Title, result.AlbumArtist)
+	}
+	if result.Year() != 2001 {
+		t.Errorf("expected year 2001, but got %d", result.Year())
+	}
+	if result.Track != 7 || result.TrackTotal != 9 {
+		t.Errorf("expected track 7/9, but got %d/%d", result.Track, result.TrackTotal)
+	}
+	if result.Custom["MUSICBRAINZ_ALBUMID"] != "abc" {
+		t.Errorf("expected custom field %q, but got %q", "abc", result.Custom["MUSICBRAINZ_ALBUMID"])
+	}
+}
+
+func TestReadUnsupportedFormat(t *testing.T) {
+	_, err := tags.Read(writeTestFile(t, "test.txt", []byte("not music at all")))
+	if !errors.Is(err, tags.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
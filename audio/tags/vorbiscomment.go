@@ -0,0 +1,186 @@
+package tags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// https://xiph.org/vorbis/doc/v-comment.html
+
+// vorbisComment is a comment block as used by FLAC and Ogg Vorbis/Opus.
+type vorbisComment struct {
+	Vendor   string
+	Comments []string // "FIELD=value"
+}
+
+func parseVorbisComment(b []byte) (*vorbisComment, error) {
+	readString := func() (string, error) {
+		if len(b) < 4 {
+			return "", fmt.Errorf("%w: truncated vorbis comment", ErrMalformedTag)
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(len(b)-4) < uint64(n) {
+			return "", fmt.Errorf("%w: truncated vorbis comment", ErrMalformedTag)
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, nil
+	}
+
+	vendor, err := readString()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: truncated vorbis comment", ErrMalformedTag)
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+
+	vc := &vorbisComment{Vendor: vendor}
+	for i := uint32(0); i < count; i++ {
+		comment, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		vc.Comments = append(vc.Comments, comment)
+	}
+	return vc, nil
+}
+
+// Alternative field names used by some taggers
+var vorbisFieldAliases = map[string]string{
+	"ALBUM ARTIST": "ALBUMARTIST",
+	"ALBUM_ARTIST": "ALBUMARTIST",
+	"YEAR":         "DATE",
+	"TOTALTRACKS":  "TRACKTOTAL",
+	"TOTALDISCS":   "DISCTOTAL",
+}
+
+// Fields represented by the fixed members of Tags
+var vorbisStandardFields = map[string]bool{
+	"TITLE":       true,
+	"ARTIST":      true,
+	"ALBUMARTIST": true,
+	"ALBUM":       true,
+	"DATE":        true,
+	"GENRE":       true,
+	"TRACKNUMBER": true,
+	"DISCNUMBER":  true,
+	"TRACKTOTAL":  true,
+	"DISCTOTAL":   true,
+}
+
+// normalizeVorbisField returns the upper case field name of comment with
+// aliases resolved.
+func normalizeVorbisField(comment string) (string, bool) {
+	field, _, ok := strings.Cut(comment, "=")
+	if !ok {
+		return "", false
+	}
+	field = strings.ToUpper(field)
+	if alias, ok := vorbisFieldAliases[field]; ok {
+		field = alias
+	}
+	return field, true
+}
+
+func (vc *vorbisComment) toTags() *Tags {
+	values := map[string][]string{}
+	var order []string
+	for _, comment := range vc.Comments {
+		field, ok := normalizeVorbisField(comment)
+		if !ok {
+			continue
+		}
+		_, value, _ := strings.Cut(comment, "=")
+		if _, seen := values[field]; !seen {
+			order = append(order, field)
+		}
+		values[field] = append(values[field], strings.TrimSpace(value))
+	}
+	first := func(field string) string {
+		if v := values[field]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	t := &Tags{
+		Title:       first("TITLE"),
+		Artist:      first("ARTIST"),
+		AlbumArtist: first("ALBUMARTIST"),
+		Album:       first("ALBUM"),
+		Date:        first("DATE"),
+		Genre:       first("GENRE"),
+	}
+	// Malformed numbers are ignored when reading, there is nothing to fix them with
+	parseNumberPair(first("TRACKNUMBER"), &t.Track, &t.TrackTotal)
+	parseNumberPair(first("DISCNUMBER"), &t.Disc, &t.DiscTotal)
+	if total := first("TRACKTOTAL"); total != "" {
+		parseNumber(total, &t.TrackTotal)
+	}
+	if total := first("DISCTOTAL"); total != "" {
+		parseNumber(total, &t.DiscTotal)
+	}
+
+	for _, field := range order {
+		if vorbisStandardFields[field] {
+			continue
+		}
+		if t.Custom == nil {
+			t.Custom = map[string]string{}
+		}
+		t.Custom[field] = strings.Join(values[field], "; ")
+	}
+	return t
+}
+
+// update replaces the comments with the values of t. Custom fields with an
+// unchanged value keep their original comments, which may hold several
+// values of the same field.
+func (vc *vorbisComment) update(t *Tags) {
+	original := vc.toTags()
+	var comments []string
+	add := func(field string, value string) {
+		if value != "" {
+			comments = append(comments, field+"="+value)
+		}
+	}
+	add("TITLE", t.Title)
+	add("ARTIST", t.Artist)
+	add("ALBUMARTIST", t.AlbumArtist)
+	add("ALBUM", t.Album)
+	add("DATE", t.Date)
+	add("TRACKNUMBER", formatNumber(t.Track))
+	add("TRACKTOTAL", formatNumber(t.TrackTotal))
+	add("DISCNUMBER", formatNumber(t.Disc))
+	add("DISCTOTAL", formatNumber(t.DiscTotal))
+	add("GENRE", t.Genre)
+
+	for _, comment := range vc.Comments {
+		field, ok := normalizeVorbisField(comment)
+		if ok && !vorbisStandardFields[field] && unchangedCustom(t, original, field) {
+			comments = append(comments, comment)
+		}
+	}
+	for _, key := range changedCustom(t, original) {
+		if !strings.Contains(key, "=") {
+			add(key, t.Custom[key])
+		}
+	}
+	vc.Comments = comments
+}
+
+func (vc *vorbisComment) bytes() []byte {
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vc.Vendor)))
+	b = append(b, vc.Vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vc.Comments)))
+	for _, comment := range vc.Comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(comment)))
+		b = append(b, comment...)
+	}
+	return b
+}
@@ -0,0 +1,130 @@
+package tags
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Write replaces the tags of the music file at path with t. Everything Tags
+// does not describe, like pictures, comments or unknown frames, is kept. The
+// file is written to a temporary file next to it, which then replaces the
+// original, so a failed write never leaves a broken file behind.
+func Write(path string, t *Tags) error {
+	return rewrite(path, func(format format, f fileReader, w io.Writer) error {
+		switch format {
+		case formatMP3:
+			return writeMP3(f, w, t)
+		case formatFLAC:
+			return writeFLAC(f, w, t)
+		case formatOgg:
+			return writeOgg(f, w, t)
+		}
+		return fmt.Errorf("%w: tags can only be written to MP3, FLAC and Ogg files", ErrUnsupportedFormat)
+	})
+}
+
+// Writable returns an error wrapping ErrUnsupportedFormat if Write does not
+// support the format of the music file at path.
+func Writable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format, err := detectFormat(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	switch format {
+	case formatMP3, formatFLAC, formatOgg:
+		return nil
+	}
+	return fmt.Errorf("%w: tags can only be written to MP3, FLAC and Ogg files", ErrUnsupportedFormat)
+}
+
+// rewrite calls write with the music file at path and a temporary file next
+// to it, which replaces the original if write succeeds.
+func rewrite(path string, write func(format format, f fileReader, w io.Writer) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format, err := detectFormat(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".musiman-tags-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails after the rename, which is fine
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	if err := write(format, f, w); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// formatNumber returns n as string, or "" if it is not set.
+func formatNumber(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// formatNumberPair returns "n/total", "n" or "" depending on which are set.
+func formatNumberPair(n int, total int) string {
+	if n <= 0 {
+		return ""
+	}
+	if total <= 0 {
+		return strconv.Itoa(n)
+	}
+	return strconv.Itoa(n) + "/" + strconv.Itoa(total)
+}
+
+// changedCustom returns the keys of all non empty custom tags of t whose
+// value differs from original, sorted to keep the output stable.
+func changedCustom(t *Tags, original *Tags) []string {
+	var keys []string
+	for key, value := range t.Custom {
+		if previous, ok := original.Custom[key]; value != "" && (!ok || previous != value) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unchangedCustom reports whether the custom tag key has the same value in t
+// as in original, so the original representation can be kept.
+func unchangedCustom(t *Tags, original *Tags, key string) bool {
+	value, ok := t.Custom[key]
+	previous, wasSet := original.Custom[key]
+	return ok && wasSet && value == previous
+}
@@ -0,0 +1,183 @@
+package tags_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/audio/ogg"
+	"github.com/makl11/musiman/audio/tags"
+)
+
+var audioData = bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00, 1, 2, 3, 4}, 64)
+
+func TestWriteFLAC(t *testing.T) {
+	content := []byte("fLaC")
+	content = append(content, 0, 0, 0, 34) // STREAMINFO
+	content = append(content, make([]byte, 34)...)
+	content = append(content, 0x80|1, 0, 0, 100) // padding
+	content = append(content, make([]byte, 100)...)
+	content = append(content, audioData...)
+	path := writeTestFile(t, "test.flac", content)
+
+	written := &tags.Tags{Title: "Song", Artist: "Band", Track: 2, TrackTotal: 10, Custom: map[string]string{"MOOD": "calm"}}
+	if err := tags.Write(path, written); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Title != "Song" || result.Artist != "Band" {
+		t.Errorf("expected title %q and artist %q, but got %q and %q", "Song", "Band", result.Title, result.Artist)
+	}
+	if result.Track != 2 || result.TrackTotal != 10 {
+		t.Errorf("expected track 2/10, but got %d/%d", result.Track, result.TrackTotal)
+	}
+	if result.Custom["MOOD"] != "calm" {
+		t.Errorf("expected custom field %q, but got %q", "calm", result.Custom["MOOD"])
+	}
+
+	content, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.HasSuffix(content, audioData) {
+		t.Errorf("expected audio data to be kept")
+	}
+	if expected := 4 + 38 + 104 + len(audioData); len(content) != expected {
+		t.Errorf("expected the comment to take space from the padding (size %d), but got size %d", expected, len(content))
+	}
+}
+
+func TestWriteMP3(t *testing.T) {
+	var frames []byte
+	frames = append(frames, id3v23Frame("TIT2", append([]byte{0}, "Old"...))...)
+	frames = append(frames, id3v23Frame("TYER", append([]byte{0}, "1999"...))...)
+	frames = append(frames, id3v23Frame("TXXX", append([]byte{0}, "Keep\x00me"...))...)
+	frames = append(frames, id3v23Frame("TXXX", append([]byte{0}, "Change\x00me"...))...)
+	frames = append(frames, id3v23Frame("APIC", append([]byte{0}, "image/png\x00\x03\x00picture"...))...)
+	size := len(frames)
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	content := append(header, frames...)
+	content = append(content, audioData...)
+	v1 := make([]byte, 128)
+	copy(v1, "TAGOld")
+	v1[127] = 17
+	content = append(content, v1...)
+	path := writeTestFile(t, "test.mp3", content)
+
+	original, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	original.Title = "Ünïcode title"
+	original.Genre = "Jazz"
+	original.Custom["CHANGE"] = "changed"
+	if err := tags.Write(path, original); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Title != "Ünïcode title" || result.Date != "1999" || result.Genre != "Jazz" {
+		t.Errorf("expected title, date and genre to be written, but got %q, %q and %q", result.Title, result.Date, result.Genre)
+	}
+	if result.Custom["KEEP"] != "me" || result.Custom["CHANGE"] != "changed" {
+		t.Errorf("expected custom fields %q and %q, but got %v", "me", "changed", result.Custom)
+	}
+
+	content, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content[3] != 4 {
+		t.Errorf("expected an ID3v2.4 tag, but got version 2.%d", content[3])
+	}
+	if !bytes.Contains(content, []byte("Keep\x00me")) || !bytes.Contains(content, []byte("APIC")) {
+		t.Errorf("expected unchanged TXXX and APIC frames to be kept")
+	}
+	v1 = content[len(content)-128:]
+	if !bytes.HasPrefix(v1, []byte("TAG\xDCn\xEFcode title")) || v1[127] != 8 {
+		t.Errorf("expected ID3v1 tag to be updated, but got %q", v1)
+	}
+	if !bytes.HasSuffix(content[:len(content)-128], audioData) {
+		t.Errorf("expected audio data to be kept")
+	}
+}
+
+func TestWriteOggVorbis(t *testing.T) {
+	comment := []byte("\x03vorbis")
+	comment = binary.LittleEndian.AppendUint32(comment, 4)
+	comment = append(comment, "test"...)
+	comment = binary.LittleEndian.AppendUint32(comment, 1)
+	comment = binary.LittleEndian.AppendUint32(comment, 8)
+	comment = append(comment, "ARTIST=A"...)
+	comment = append(comment, 1)
+	headers := [][]byte{append([]byte("\x01vorbis"), make([]byte, 23)...), comment, []byte("\x05vorbis setup")}
+
+	var content []byte
+	pages := ogg.Paginate(headers[:1], 7, 0, 0)
+	pages[0].HeaderType |= ogg.HEADER_BOS
+	pages = append(pages, ogg.Paginate(headers[1:], 7, 1, 0)...)
+	pages = append(pages, ogg.Paginate([][]byte{audioData}, 7, uint32(len(pages)), 1024)...)
+	pages[len(pages)-1].HeaderType |= ogg.HEADER_EOS
+	for _, page := range pages {
+		content = append(content, page.Bytes()...)
+	}
+	path := writeTestFile(t, "test.ogg", content)
+
+	// Long enough to need more header pages
+	long := strings.Repeat("x", 70000)
+	if err := tags.Write(path, &tags.Tags{Artist: "B", Title: long}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Artist != "B" || result.Title != long {
+		t.Errorf("expected artist %q and the long title, but got %q and a title of length %d", "B", result.Artist, len(result.Title))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	var sequence uint32
+	var last *ogg.Page
+	for {
+		page, err := ogg.ReadPage(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if page.Sequence != sequence {
+			t.Errorf("expected page sequence %d, but got %d", sequence, page.Sequence)
+		}
+		sequence++
+		last = page
+	}
+	if packets, _ := last.Packets(); len(packets) != 1 || !bytes.Equal(packets[0], audioData) || last.HeaderType&ogg.HEADER_EOS == 0 {
+		t.Errorf("expected the audio page to be kept")
+	}
+}
+
+func TestWriteUnsupportedFormat(t *testing.T) {
+	content := append([]byte("RIFF\x04\x00\x00\x00WAVE"), make([]byte, 16)...)
+	err := tags.Write(writeTestFile(t, "test.wav", content), &tags.Tags{Title: "Song"})
+	if !errors.Is(err, tags.ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat, but got %v", err)
+	}
+}
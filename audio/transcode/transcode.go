@@ -0,0 +1,252 @@
+package transcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/makl11/musiman/audio"
+	"github.com/makl11/musiman/audio/encode"
+)
+
+// Encoding to lossy formats like MP3, Opus or AAC by external encoders, i.e.
+// lame, opusenc or ffmpeg. The decoded samples are piped into their standard
+// input as a 16 bit WAV stream, so every encoder reading WAV files from
+// standard input can be used.
+
+var (
+	ErrUnknownProfile = errors.New("unknown transcoding profile")
+	ErrInvalidProfile = errors.New("invalid transcoding profile")
+	ErrEncoderFailed  = errors.New("encoder failed")
+)
+
+// Placeholders of Profile.Command, replaced by the path of the encoded file
+// and the format of the piped samples
+const (
+	PLACEHOLDER_OUTPUT   = "{output}"
+	PLACEHOLDER_RATE     = "{rate}"
+	PLACEHOLDER_CHANNELS = "{channels}"
+	PLACEHOLDER_BITS     = "{bits}"
+)
+
+// Bits per sample of the piped WAV stream, which every encoder supports
+const BITS_PER_SAMPLE = 16
+
+// stderrLimit is the number of bytes at the end of the standard error output
+// of an encoder kept for the error of a failed encoder.
+const stderrLimit = 2048
+
+// Profile describes how an external encoder is run to encode a file.
+type Profile struct {
+	Name string `mapstructure:"name"`
+	// Extension of the encoded files, without the dot
+	Extension string `mapstructure:"extension"`
+	// Media type of the encoded files, as detected by the scanner, i.e. "ogg"
+	// for Opus files
+	MediaType string `mapstructure:"media_type"`
+	// Program and arguments, with placeholders replaced before running it.
+	// It has to read the WAV stream from standard input and write the file
+	// at PLACEHOLDER_OUTPUT.
+	Command []string `mapstructure:"command"`
+}
+
+// PROFILES are the built-in profiles, which the config file can extend or
+// replace.
+var PROFILES = map[string]Profile{
+	"mp3": {
+		Name:      "mp3",
+		Extension: "mp3",
+		MediaType: "mp3",
+		Command:   []string{"lame", "--quiet", "-V", "2", "-", PLACEHOLDER_OUTPUT},
+	},
+	"opus": {
+		Name:      "opus",
+		Extension: "opus",
+		MediaType: "ogg",
+		Command:   []string{"opusenc", "--quiet", "--bitrate", "160", "-", PLACEHOLDER_OUTPUT},
+	},
+	"aac": {
+		Name:      "aac",
+		Extension: "m4a",
+		MediaType: "m4a",
+		Command:   []string{"ffmpeg", "-nostdin", "-hide_banner", "-loglevel", "error", "-f", "wav", "-i", "-", "-c:a", "aac", "-b:a", "256k", PLACEHOLDER_OUTPUT},
+	},
+}
+
+// Validate returns an error wrapping ErrInvalidProfile if p can not be used
+// to encode files.
+func (p Profile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidProfile)
+	}
+	if p.Extension == "" || strings.ContainsAny(p.Extension, "./\\") {
+		return fmt.Errorf("%w: %s: \"%s\" is not a valid extension", ErrInvalidProfile, p.Name, p.Extension)
+	}
+	if !audio.MUSIC_FILE_TYPES[p.MediaType] {
+		return fmt.Errorf("%w: %s: unknown or unsupported media type: \"%s\"", ErrInvalidProfile, p.Name, p.MediaType)
+	}
+	if len(p.Command) == 0 || p.Command[0] == "" {
+		return fmt.Errorf("%w: %s: missing command", ErrInvalidProfile, p.Name)
+	}
+	for _, arg := range p.Command[1:] {
+		if strings.Contains(arg, PLACEHOLDER_OUTPUT) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s: the command has no %s argument", ErrInvalidProfile, p.Name, PLACEHOLDER_OUTPUT)
+}
+
+// Lookup returns the valid profile called name, from profiles or else the
+// built-in ones.
+func Lookup(name string, profiles map[string]Profile) (Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		p, ok = PROFILES[strings.ToLower(name)]
+	}
+	if !ok {
+		return Profile{}, fmt.Errorf("%w: \"%s\"", ErrUnknownProfile, name)
+	}
+	if p.Name == "" {
+		p.Name = name
+	}
+	return p, p.Validate()
+}
+
+// Args returns the command of p with the placeholders replaced.
+func (p Profile) Args(output string, format encode.Format) []string {
+	r := strings.NewReplacer(
+		PLACEHOLDER_OUTPUT, output,
+		PLACEHOLDER_RATE, strconv.Itoa(format.SampleRate),
+		PLACEHOLDER_CHANNELS, strconv.Itoa(format.Channels),
+		PLACEHOLDER_BITS, strconv.Itoa(format.BitsPerSample),
+	)
+	args := make([]string, len(p.Command))
+	for i, arg := range p.Command {
+		args[i] = r.Replace(arg)
+	}
+	return args
+}
+
+// String returns the command of p, i.e. for the settings of derivations.
+func (p Profile) String() string {
+	return p.Name + ": " + strings.Join(p.Command, " ")
+}
+
+// Encoder encodes samples by piping them into the encoder of a profile. It
+// implements encode.Encoder.
+type Encoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	wav    *encode.WAV
+	stderr *tail
+	output string
+	done   bool
+	err    error // result of the finished encoder
+}
+
+// Start starts the encoder of p writing the file at output, which must not
+// exist yet, for samples with the sample rate and channels of format.
+func Start(p Profile, output string, format encode.Format) (*Encoder, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(output); err == nil {
+		return nil, fmt.Errorf("%w: %s already exists", os.ErrExist, output)
+	}
+	format.BitsPerSample = BITS_PER_SAMPLE
+	args := p.Args(output, format)
+
+	e := &Encoder{cmd: exec.Command(args[0], args[1:]...), stderr: &tail{}, output: output}
+	e.cmd.Stderr = e.stderr
+	stdin, err := e.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	e.stdin = stdin
+	if err := e.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrEncoderFailed, p.Name, err)
+	}
+	e.wav, err = encode.NewWAVStream(stdin, format)
+	if err != nil {
+		// The encoder may have exited already, its error tells why
+		if waitErr := e.wait(); waitErr != nil {
+			return nil, waitErr
+		}
+		os.Remove(output)
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Encoder) Write(samples []float32) error {
+	if err := e.wav.Write(samples); err != nil {
+		// Most likely a closed pipe because the encoder exited, its error
+		// tells why
+		if waitErr := e.wait(); waitErr != nil {
+			return waitErr
+		}
+		return err
+	}
+	return nil
+}
+
+// Close closes the standard input of the encoder and waits for it to exit.
+// The encoded file is removed if it fails.
+func (e *Encoder) Close() error {
+	if err := e.wait(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(e.output); err != nil {
+		return fmt.Errorf("%w: %s exited without writing %s", ErrEncoderFailed, filepath.Base(e.cmd.Path), e.output)
+	}
+	return nil
+}
+
+// wait closes the standard input of the encoder, waits for it to exit and
+// returns an error with its exit code and last error output if it failed.
+func (e *Encoder) wait() error {
+	if e.done {
+		return e.err
+	}
+	e.done = true
+	e.stdin.Close()
+	err := e.cmd.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.Exited():
+		e.err = fmt.Errorf("%w: %s exited with status %d", ErrEncoderFailed, filepath.Base(e.cmd.Path), exitErr.ExitCode())
+	case err != nil:
+		e.err = fmt.Errorf("%w: %s: %w", ErrEncoderFailed, filepath.Base(e.cmd.Path), err)
+	}
+	if e.err != nil {
+		if message := e.stderr.String(); message != "" {
+			e.err = fmt.Errorf("%w: %s", e.err, message)
+		}
+		os.Remove(e.output)
+	}
+	return e.err
+}
+
+// tail keeps the last stderrLimit bytes written to it.
+type tail struct {
+	buf []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrLimit {
+		t.buf = t.buf[len(t.buf)-stderrLimit:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept output on a single line.
+func (t *tail) String() string {
+	return strings.Join(strings.Fields(string(bytes.ToValidUTF8(t.buf, nil))), " ")
+}
@@ -0,0 +1,145 @@
+package transcode_test
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/encode"
+	"github.com/makl11/musiman/audio/transcode"
+)
+
+// fakeEncoder writes a shell script standing in for an external encoder and
+// returns a profile running it with args.
+func fakeEncoder(t *testing.T, script string, args ...string) transcode.Profile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "encoder")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatalf("failed to write fake encoder: %v", err)
+	}
+	return transcode.Profile{Name: "fake", Extension: "wav", MediaType: "wav", Command: append([]string{path}, args...)}
+}
+
+func sine(frames int, channels int) []float32 {
+	samples := make([]float32, frames*channels)
+	for i := range samples {
+		samples[i] = float32(math.Round(math.Sin(float64(i/channels)/10)*16000) / 32768)
+	}
+	return samples
+}
+
+func TestEncoder(t *testing.T) {
+	// Copies the WAV stream, after checking the arguments
+	profile := fakeEncoder(t, `[ "$1" = "-r" ] && [ "$2" = "22050" ] && [ "$3" = "2" ] && [ "$4" = "16" ] || exit 1; cat > "$5"`,
+		"-r", transcode.PLACEHOLDER_RATE, transcode.PLACEHOLDER_CHANNELS, transcode.PLACEHOLDER_BITS, transcode.PLACEHOLDER_OUTPUT)
+	output := filepath.Join(t.TempDir(), "encoded.wav")
+	e, err := transcode.Start(profile, output, encode.Format{SampleRate: 22050, Channels: 2, BitsPerSample: 24})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	samples := sine(100000, 2)
+	for i := 0; i < len(samples); i += 8192 {
+		if err := e.Write(samples[i:min(i+8192, len(samples))]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d, err := decode.Open(output)
+	if err != nil {
+		t.Fatalf("expected a WAV file, but got %v", err)
+	}
+	defer d.Close()
+	if d.SampleRate() != 22050 || d.Channels() != 2 || d.BitsPerSample() != 16 {
+		t.Errorf("expected 22050 Hz with 2 channels of 16 bits, but got %d Hz with %d channels of %d bits", d.SampleRate(), d.Channels(), d.BitsPerSample())
+	}
+	decoded := make([]float32, len(samples)+1)
+	n := 0
+	for err == nil {
+		var read int
+		read, err = d.Read(decoded[n:])
+		n += read
+	}
+	if n != len(samples) {
+		t.Fatalf("expected %d samples, but got %d and %v", len(samples), n, err)
+	}
+	for i := range samples {
+		if decoded[i] != samples[i] {
+			t.Fatalf("expected sample %d to be %v, but got %v", i, samples[i], decoded[i])
+		}
+	}
+}
+
+func TestEncoderFailure(t *testing.T) {
+	cases := []struct {
+		name     string
+		script   string
+		expected string
+	}{
+		{"exit code", `cat > "$1"; echo "unsupported sample rate" >&2; exit 3`, "exited with status 3: unsupported sample rate"},
+		{"early exit", `echo "can not read input" >&2; exit 2`, "exited with status 2: can not read input"},
+		{"no output", `cat > /dev/null`, "exited without writing"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "encoded.wav")
+			e, err := transcode.Start(fakeEncoder(t, c.script, transcode.PLACEHOLDER_OUTPUT), output, encode.Format{SampleRate: 44100, Channels: 2})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// More than fits into the pipe, writing fails if the encoder
+			// exited early
+			samples := sine(44100, 2)
+			for i := 0; i < 10 && err == nil; i++ {
+				err = e.Write(samples)
+			}
+			if err == nil {
+				err = e.Close()
+			}
+			if !errors.Is(err, transcode.ErrEncoderFailed) || !strings.Contains(err.Error(), c.expected) {
+				t.Errorf("expected ErrEncoderFailed with %q, but got %v", c.expected, err)
+			}
+			if _, err := os.Stat(output); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected the output of the failed encoder to be removed, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestStartMissingEncoder(t *testing.T) {
+	profile := transcode.Profile{Name: "missing", Extension: "mp3", MediaType: "mp3", Command: []string{"/nonexistent/lame", transcode.PLACEHOLDER_OUTPUT}}
+	_, err := transcode.Start(profile, filepath.Join(t.TempDir(), "encoded.mp3"), encode.Format{SampleRate: 44100, Channels: 2})
+	if !errors.Is(err, transcode.ErrEncoderFailed) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrEncoderFailed wrapping os.ErrNotExist, but got %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	custom := map[string]transcode.Profile{
+		"car":    {Extension: "mp3", MediaType: "mp3", Command: []string{"lame", "-V", "4", "-", transcode.PLACEHOLDER_OUTPUT}},
+		"broken": {Extension: "mp3", MediaType: "mp3", Command: []string{"lame", "-"}},
+		"mp3":    {Extension: "mp3", MediaType: "mp3", Command: []string{"lame", "-b", "320", "-", transcode.PLACEHOLDER_OUTPUT}},
+	}
+	p, err := transcode.Lookup("car", custom)
+	if err != nil || p.Name != "car" {
+		t.Errorf("expected the car profile, but got %+v and %v", p, err)
+	}
+	if p, err := transcode.Lookup("mp3", custom); err != nil || p.Command[2] != "320" {
+		t.Errorf("expected the configured mp3 profile to replace the built-in one, but got %+v and %v", p, err)
+	}
+	if p, err := transcode.Lookup("OPUS", nil); err != nil || p.MediaType != "ogg" {
+		t.Errorf("expected the built-in opus profile, but got %+v and %v", p, err)
+	}
+	if _, err := transcode.Lookup("broken", custom); !errors.Is(err, transcode.ErrInvalidProfile) {
+		t.Errorf("expected ErrInvalidProfile, but got %v", err)
+	}
+	if _, err := transcode.Lookup("vinyl", custom); !errors.Is(err, transcode.ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, but got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/audio/levels"
+	"github.com/makl11/musiman/audio/quality"
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+)
+
+var (
+	analyzeReport     string
+	analyzeMinSilence time.Duration
+)
+
+// analyzeCmd represents the analyze command
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze [directory]",
+	Short: "Analyze the audio of known music files (defaults to current directory if not specified)",
+	Long: `Analyze the decoded audio of known music files and print a report. Analyses are stored per audio content, so rescans reuse them.
+
+Reports:
+  quality  find lossless files made from lossy ones by the lowpass shelf of lossy encoders (16, 19 or 20 kHz), samples padded with zero bits and upsampled sample rates. Suspicious files are listed with a confidence between 0 and 1.
+  clipping find badly mastered tracks by their clipped samples and DC offset, and hidden tracks by silence of at least --min-silence at their start, end or between their audio.
+  gapless  list the exact length in samples of every file, with the encoder delay and padding of lossy files. Lossy files without them ("gaps") play and transcode with silence between the tracks of gapless albums.`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		switch analyzeReport {
+		case "quality":
+			reportQuality(db, dir)
+		case "clipping":
+			reportClipping(db, dir)
+		case "gapless":
+			reportGapless(db, dir)
+		default:
+			fmt.Printf("Error: unknown report %q\n", analyzeReport)
+			os.Exit(1)
+		}
+	},
+}
+
+func reportQuality(db *sqlx.DB, dir string) {
+	analysed, failed, err := library.AnalyzeQuality(db, dir)
+	if err != nil {
+		fmt.Println("Error analyzing quality:", err)
+		os.Exit(1)
+	}
+	for path, err := range failed {
+		fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+	}
+	counts := map[string]int{}
+	for _, f := range analysed {
+		class := qualityClass(f.Analysis)
+		counts[class]++
+		fmt.Printf("%s\t%s\t%s\n", class, f.File.Path, formatQuality(f.Analysis))
+	}
+	fmt.Printf("Analyzed %d files: %d suspicious, %d lossless, %d lossy\n", len(analysed), counts["suspect"], counts["lossless"], counts["lossy"])
+}
+
+func reportClipping(db *sqlx.DB, dir string) {
+	analysed, failed, err := library.AnalyzeLevels(db, dir)
+	if err != nil {
+		fmt.Println("Error analyzing levels:", err)
+		os.Exit(1)
+	}
+	for path, err := range failed {
+		fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+	}
+	minSilence := analyzeMinSilence.Seconds()
+	clipped, offset, silent := 0, 0, 0
+	for _, f := range analysed {
+		s := f.Stats
+		if s.Clipped > 0 {
+			clipped++
+			fmt.Printf("clipped\t%s\t%d samples\tpeak %.2f dBFS\n", f.File.Path, s.Clipped, 20*math.Log10(s.Peak))
+		}
+		if math.Abs(s.DCOffset) > levels.MAX_DC_OFFSET {
+			offset++
+			fmt.Printf("dc\t%s\t%.2f%%\n", f.File.Path, 100*s.DCOffset)
+		}
+		long := false
+		if s.LeadingSilence >= minSilence || s.TrailingSilence >= minSilence {
+			long = true
+			fmt.Printf("silence\t%s\tleading %.1f s\ttrailing %.1f s\n", f.File.Path, s.LeadingSilence, s.TrailingSilence)
+		}
+		if s.LongestGap >= minSilence {
+			long = true
+			fmt.Printf("gap\t%s\t%.1f s\tat %s\n", f.File.Path, s.LongestGap, formatPosition(s.GapStart))
+		}
+		if long {
+			silent++
+		}
+	}
+	fmt.Printf("Analyzed %d files: %d clipped, %d with a DC offset, %d with long silence\n", len(analysed), clipped, offset, silent)
+}
+
+func reportGapless(db *sqlx.DB, dir string) {
+	analysed, failed, err := library.AnalyzeProperties(db, dir)
+	if err != nil {
+		fmt.Println("Error reading audio properties:", err)
+		os.Exit(1)
+	}
+	for path, err := range failed {
+		fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+	}
+	counts := map[string]int{}
+	for _, f := range analysed {
+		p := f.Properties
+		class, delay := "gaps", "no delay and padding"
+		switch {
+		case p.BitsPerSample > 0:
+			class, delay = "lossless", "-"
+		case p.EncoderDelay != nil:
+			class, delay = "gapless", fmt.Sprintf("delay %d\tpadding %d", *p.EncoderDelay, *p.EncoderPadding)
+		}
+		counts[class]++
+		fmt.Printf("%s\t%s\t%d Hz\t%d samples\t%.3f s\t%s\n", class, f.File.Path, p.SampleRate, p.Samples, p.Duration(), delay)
+	}
+	fmt.Printf("Analyzed %d files: %d lossless, %d gapless, %d with gaps\n", len(analysed), counts["lossless"], counts["gapless"], counts["gaps"])
+}
+
+// formatPosition returns seconds as minutes and seconds, like 3:07.
+func formatPosition(seconds float64) string {
+	s := int(seconds)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// qualityClass returns suspect, lossless or lossy for the format and the
+// suspicions of a.
+func qualityClass(a quality.Analysis) string {
+	switch {
+	case a.Confidence() >= quality.SUSPICIOUS:
+		return "suspect"
+	case a.Bits > 0:
+		return "lossless"
+	}
+	return "lossy"
+}
+
+// formatQuality returns the confidence and the suspicions of suspicious
+// files, otherwise the sample rate, sample size and cutoff, tab separated.
+func formatQuality(a quality.Analysis) string {
+	if a.Confidence() >= quality.SUSPICIOUS {
+		var details []string
+		for _, s := range a.Suspicions() {
+			details = append(details, s.Detail)
+		}
+		return fmt.Sprintf("%.2f\t%s", a.Confidence(), strings.Join(details, ", "))
+	}
+	fields := []string{fmt.Sprintf("%d Hz", a.SampleRate)}
+	if a.Bits > 0 {
+		fields = append(fields, fmt.Sprintf("%d bits", a.Bits))
+	}
+	if a.Cutoff > 0 {
+		fields = append(fields, fmt.Sprintf("cutoff at %.1f kHz", a.Cutoff/1000))
+	} else {
+		fields = append(fields, "no cutoff")
+	}
+	return strings.Join(fields, "\t")
+}
+
+func init() {
+	analyzeCmd.Flags().StringVarP(&analyzeReport, "report", "r", "quality", "Report to print: quality, clipping or gapless")
+	analyzeCmd.Flags().DurationVar(&analyzeMinSilence, "min-silence", 10*time.Second, "Shortest silence reported by the clipping report")
+	rootCmd.AddCommand(analyzeCmd)
+}
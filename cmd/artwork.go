@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+)
+
+var (
+	artworkMinSize int
+	artworkSize    int
+	artworkForce   bool
+)
+
+// artworkCmd represents the artwork command
+var artworkCmd = &cobra.Command{
+	Use:   "artwork",
+	Short: "Manage embedded and folder album art",
+	Long:  `Manage album art. Every command first stores the pictures embedded in known files (ID3 APIC frames, FLAC PICTURE blocks, MP4 cover art) and folder images like cover.jpg or folder.png, each distinct image once. Files are grouped into albums by directory.`,
+}
+
+// artworkReportCmd represents the artwork report command
+var artworkReportCmd = &cobra.Command{
+	Use:     "report [directory]",
+	Short:   "List albums without album art or with tiny album art (defaults to current directory if not specified)",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		albums := scanArtwork(db, args)
+		images := map[string]bool{}
+		for _, album := range albums {
+			for _, sources := range [][]library.ArtworkSource{album.Folder, album.Embedded} {
+				for _, s := range sources {
+					images[string(s.Artwork.Hash)] = true
+				}
+			}
+			best := album.Best()
+			switch {
+			case best == nil:
+				fmt.Printf("missing\t%s\t%d files\n", album.Dir, len(album.Files))
+			case album.Tiny(artworkMinSize):
+				fmt.Printf("tiny\t%s\t%dx%d\t%s\n", album.Dir, best.Artwork.Width, best.Artwork.Height, best.Path)
+			}
+		}
+		fmt.Printf("Checked %d albums with %d distinct images\n", len(albums), len(images))
+	},
+}
+
+// artworkExportCmd represents the artwork export command
+var artworkExportCmd = &cobra.Command{
+	Use:     "export [directory]",
+	Short:   "Save the best album art of every album as cover.jpg (defaults to current directory if not specified)",
+	Long:    "Save the largest folder image or embedded front cover of every album as cover.jpg in its directory. Existing files are only replaced with --force.",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		albums := scanArtwork(db, args)
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		exported := 0
+		for _, album := range albums {
+			u, err := library.ExportArtwork(j, album, artworkSize, artworkForce)
+			switch {
+			case errors.Is(err, library.ErrNoArtwork):
+				fmt.Printf("skip\t%s\tno album art\n", album.Dir)
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "Skipping album %s: %v\n", album.Dir, err)
+			case u.Skipped != nil:
+				fmt.Printf("skip\t%s\t%v\n", u.Path, u.Skipped)
+			case u.UpToDate:
+				fmt.Printf("keep\t%s\n", u.Path)
+			default:
+				fmt.Printf("export\t%s\n", u.Path)
+				exported++
+			}
+		}
+		fmt.Printf("Exported %d images\n", exported)
+	},
+}
+
+// artworkEmbedCmd represents the artwork embed command
+var artworkEmbedCmd = &cobra.Command{
+	Use:     "embed [directory]",
+	Short:   "Embed the best album art of every album as front cover (defaults to current directory if not specified)",
+	Long:    "Embed the largest folder image or embedded front cover of every album as front cover in all of its files without one, or in all of them with --force. All files of an album are written together or not at all. Pictures can be embedded in MP3, FLAC and Ogg files.",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		albums := scanArtwork(db, args)
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		embedded := 0
+		for _, album := range albums {
+			updates, err := library.EmbedArtwork(j, album, artworkSize, artworkForce)
+			if errors.Is(err, library.ErrNoArtwork) {
+				fmt.Printf("skip\t%s\tno album art\n", album.Dir)
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping album %s: writing pictures failed, no file was changed: %v\n", album.Dir, err)
+				continue
+			}
+			for _, u := range updates {
+				switch {
+				case u.Skipped != nil:
+					fmt.Printf("skip\t%s\t%v\n", u.Path, u.Skipped)
+				case u.UpToDate:
+					fmt.Printf("keep\t%s\n", u.Path)
+				default:
+					fmt.Printf("embed\t%s\n", u.Path)
+					embedded++
+				}
+			}
+		}
+		fmt.Printf("Embedded album art in %d files\n", embedded)
+	},
+}
+
+// scanArtwork stores the artwork below the directory in args and returns it
+// by album. It exits if the files can not be loaded.
+func scanArtwork(db *sqlx.DB, args []string) []library.AlbumArtwork {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	albums, failed, err := library.ScanArtwork(db, dir)
+	if err != nil {
+		fmt.Println("Error scanning artwork:", err)
+		os.Exit(1)
+	}
+	for path, err := range failed {
+		fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+	}
+	return albums
+}
+
+func init() {
+	artworkReportCmd.Flags().IntVar(&artworkMinSize, "min-size", library.MIN_ARTWORK_SIZE, "Smallest width and height in pixels of album art that is not tiny")
+	for _, cmd := range []*cobra.Command{artworkExportCmd, artworkEmbedCmd} {
+		cmd.Flags().IntVarP(&artworkSize, "size", "s", 0, "Scale images down to fit into this many pixels and store them as JPEG, 0 keeps the size")
+		cmd.Flags().BoolVarP(&artworkForce, "force", "f", false, "Replace existing images")
+	}
+	artworkCmd.AddCommand(artworkReportCmd)
+	artworkCmd.AddCommand(artworkExportCmd)
+	artworkCmd.AddCommand(artworkEmbedCmd)
+	rootCmd.AddCommand(artworkCmd)
+}
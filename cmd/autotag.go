@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/makl11/musiman/acoustid"
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+)
+
+var (
+	autotagCandidates  int
+	autotagMaxDistance float64
+	autotagRelease     string
+	autotagDryRun      bool
+)
+
+// autotagCmd represents the autotag command
+var autotagCmd = &cobra.Command{
+	Use:   "autotag [directory]",
+	Short: "Tag known music files album by album from matching MusicBrainz releases (defaults to current directory if not specified)",
+	Long: `Tag known music files album by album from matching MusicBrainz releases. Files are grouped into albums by directory and album tag. Every stored release with the album's title or with a track of one of its recordings is a candidate, stored with "mb fetch" or "mb import". Candidates are scored by track count, lengths, titles and fingerprints, the distance between 0 and 1 is shown for the best ones.
+
+The best candidate is applied if its distance is not greater than --max-distance, all tracks of an album are tagged together or not at all. Recordings are looked up on AcoustID if acoustid_api_key is set in the config file or the environment.`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		albums, failed, err := library.GroupAlbums(db, dir)
+		if err != nil {
+			fmt.Println("Error loading files:", err)
+			os.Exit(1)
+		}
+		for path, err := range failed {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+		}
+		if autotagRelease != "" && len(albums) != 1 {
+			fmt.Printf("Error: a release can only be chosen for a single album, but found %d\n", len(albums))
+			os.Exit(1)
+		}
+
+		var client *acoustid.Client
+		if apiKey := viper.GetString("acoustid_api_key"); apiKey != "" {
+			client = acoustid.NewClient(apiKey, db)
+			client.BaseURL = viper.GetString("acoustid_url")
+		}
+		var j *journal.Journal
+		if !autotagDryRun {
+			if j, err = journal.New(db); err != nil {
+				fmt.Println("Error starting journal:", err)
+				os.Exit(1)
+			}
+		}
+
+		tagged := 0
+		for _, album := range albums {
+			fmt.Printf("album\t%s\t%s\t%d files\n", album.Dir, album.Title, len(album.Files))
+			if client != nil {
+				for path, err := range library.LookupAlbumRecordings(cmd.Context(), db, client, &album) {
+					fmt.Fprintf(os.Stderr, "Not looking up %s: %v\n", path, err)
+				}
+			}
+
+			var candidates []library.Candidate
+			if autotagRelease != "" {
+				release, err := data.GetRelease(db, autotagRelease)
+				if err != nil {
+					fmt.Println("Error loading release:", err)
+					os.Exit(1)
+				}
+				tracks, err := data.GetReleaseTracks(db, release.ID)
+				if err != nil {
+					fmt.Println("Error loading release:", err)
+					os.Exit(1)
+				}
+				candidates = []library.Candidate{library.ScoreRelease(album, release, tracks)}
+			} else if candidates, err = library.FindCandidates(db, album); err != nil {
+				fmt.Println("Error finding releases:", err)
+				os.Exit(1)
+			}
+			for _, c := range candidates[:min(len(candidates), autotagCandidates)] {
+				fmt.Printf("candidate\t%.3f\t%s\t%s - %s\t%d tracks\t%d missing\n", c.Distance, c.Release.ID, c.Release.ArtistCredit, c.Release.Title, len(c.Tracks), c.MissingTracks())
+			}
+			if len(candidates) == 0 {
+				fmt.Println("skip\tno candidates")
+				continue
+			}
+			best := candidates[0]
+			// A chosen release is applied regardless of its distance
+			if autotagRelease == "" && best.Distance > autotagMaxDistance {
+				fmt.Printf("skip\tdistance %.3f is greater than %.3f\n", best.Distance, autotagMaxDistance)
+				continue
+			}
+
+			plan := library.PlanAutotag(album, best)
+			for _, u := range plan {
+				switch {
+				case u.Skipped != nil:
+					fmt.Printf("skip\t%s\t%v\n", u.Path, u.Skipped)
+				case u.UpToDate:
+					fmt.Printf("keep\t%s\n", u.Path)
+				default:
+					fmt.Printf("tag\t%s\t%s\n", u.Path, formatFields(u.Matched))
+				}
+			}
+			if autotagDryRun {
+				continue
+			}
+			done, err := library.ApplyAutotag(j, album, best)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping album %s: writing tags failed, no file was changed: %v\n", album.Dir, err)
+				continue
+			}
+			tagged += len(done)
+		}
+		if !autotagDryRun {
+			fmt.Printf("Tagged %d files\n", tagged)
+		}
+	},
+}
+
+func init() {
+	autotagCmd.Flags().IntVarP(&autotagCandidates, "candidates", "c", 3, "Number of candidates to show per album")
+	autotagCmd.Flags().Float64VarP(&autotagMaxDistance, "max-distance", "d", 0.25, "Greatest distance of a release to apply it")
+	autotagCmd.Flags().StringVarP(&autotagRelease, "release", "r", "", "MBID of the stored release to apply, for a single album")
+	autotagCmd.Flags().BoolVarP(&autotagDryRun, "dry-run", "n", false, "Only print the candidates and the tags that would be set")
+	rootCmd.AddCommand(autotagCmd)
+}
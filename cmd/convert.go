@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/makl11/musiman/audio/encode"
+	"github.com/makl11/musiman/audio/transcode"
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+)
+
+var (
+	convertTo     string
+	convertOut    string
+	convertLevel  int
+	convertJobs   int
+	convertDryRun bool
+)
+
+// convertCmd represents the convert command
+var convertCmd = &cobra.Command{
+	Use:   "convert [directory]",
+	Short: "Convert known music files to FLAC, WAV or lossy formats (defaults to current directory if not specified)",
+	Long: `Convert known music files to FLAC, WAV or lossy formats, keeping their tags and pictures. Converted files are placed next to their source, or in the same relative directory below --out. They are added to the known files and linked to their source, so converting again skips files that were converted before from the same content.
+
+The sample size of lossless files is kept, up to 24 bits. Lossy files are converted to 16 bits, which does not restore the quality lost by their encoder.
+
+Lossy formats are encoded by external encoders, which have to be installed. The built-in profiles are mp3 (lame), opus (opusenc) and aac (ffmpeg). Profiles can be added or replaced in the config file, the decoded audio is piped into the command of a profile as 16 bit WAV stream:
+
+  "transcode_profiles": {
+    "car": {"extension": "mp3", "media_type": "mp3", "command": ["lame", "--quiet", "-V", "4", "-", "{output}"]}
+  }
+
+Besides {output}, the command may contain {rate}, {channels} and {bits} of the piped audio. Tags can not be copied to AAC files.`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		if convertLevel < encode.MIN_LEVEL || convertLevel > encode.MAX_LEVEL {
+			fmt.Printf("Error: level must be between %d and %d\n", encode.MIN_LEVEL, encode.MAX_LEVEL)
+			os.Exit(1)
+		}
+
+		// Native formats first, else the profile of an external encoder
+		var profile *transcode.Profile
+		format, err := library.ParseFormat(convertTo)
+		if err != nil {
+			p, err := lookupProfile(convertTo)
+			if err != nil {
+				fmt.Println("Error parsing format:", err)
+				os.Exit(1)
+			}
+			profile = &p
+		}
+
+		var plan []library.Placement
+		if profile != nil {
+			plan, err = library.PlanTranscode(db, dir, convertOut, *profile)
+		} else {
+			plan, err = library.PlanConvert(db, dir, convertOut, format)
+		}
+		if err != nil {
+			fmt.Println("Error planning conversions:", err)
+			os.Exit(1)
+		}
+
+		if convertDryRun {
+			for _, p := range plan {
+				switch {
+				case p.Skipped != nil:
+					fmt.Printf("skip\t%s\t%v\n", p.Source, p.Skipped)
+				case p.UpToDate:
+					fmt.Printf("keep\t%s\t%s\n", p.Source, p.Dest)
+				default:
+					fmt.Printf("convert\t%s\t%s\n", p.Source, p.Dest)
+				}
+			}
+			return
+		}
+
+		for _, p := range plan {
+			if p.Skipped != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", p.Source, p.Skipped)
+			}
+		}
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		report := func(p library.Placement, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error converting %s: %v\n", p.Source, err)
+				return
+			}
+			fmt.Printf("convert\t%s\t%s\n", p.Source, p.Dest)
+		}
+		if profile != nil {
+			err = library.Transcode(j, plan, *profile, convertJobs, report)
+		} else {
+			err = library.Convert(j, plan, format, convertLevel, convertJobs, report)
+		}
+		if err != nil {
+			fmt.Println("Error converting files:", err)
+			fmt.Printf("Converted files can be removed again with: musiman undo --last\n")
+			os.Exit(1)
+		}
+	},
+}
+
+// lookupProfile returns the transcoding profile called name, from the
+// transcode_profiles of the config file or else the built-in ones.
+func lookupProfile(name string) (transcode.Profile, error) {
+	var profiles map[string]transcode.Profile
+	if err := viper.UnmarshalKey("transcode_profiles", &profiles); err != nil {
+		return transcode.Profile{}, err
+	}
+	return transcode.Lookup(name, profiles)
+}
+
+func init() {
+	convertCmd.Flags().StringVarP(&convertTo, "to", "t", library.FORMAT_FLAC, "Target format: flac, wav or a transcoding profile like mp3, opus or aac")
+	convertCmd.Flags().StringVarP(&convertOut, "out", "o", "", "Directory for the converted files, next to their source if empty")
+	convertCmd.Flags().IntVarP(&convertLevel, "level", "l", encode.DEFAULT_LEVEL, "FLAC compression level, from 0 (fastest) to 8 (smallest)")
+	convertCmd.Flags().IntVarP(&convertJobs, "jobs", "j", runtime.NumCPU(), "Number of files encoded at once")
+	convertCmd.Flags().BoolVarP(&convertDryRun, "dry-run", "n", false, "Only print the planned conversion of every file")
+	rootCmd.AddCommand(convertCmd)
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/audio/encode"
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+)
+
+var (
+	cueOut       string
+	cueTemplate  string
+	cueLevel     int
+	cueDryRun    bool
+	cueSanitize  string
+	cueReplace   string
+	cueNormalize string
+)
+
+// cueCmd represents the cue command
+var cueCmd = &cobra.Command{
+	Use:   "cue",
+	Short: "Manage albums ripped to a single audio file with a cue sheet",
+}
+
+// cueScanCmd represents the cue scan command
+var cueScanCmd = &cobra.Command{
+	Use:     "scan [directory]",
+	Short:   "Register the tracks of all cue sheets as virtual tracks (defaults to current directory if not specified)",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		imported, failed, err := library.ImportCues(db, dir)
+		if err != nil {
+			fmt.Println("Error scanning cue sheets:", err)
+			os.Exit(1)
+		}
+		paths := make([]string, 0, len(failed))
+		for path := range failed {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, failed[path])
+		}
+		paths = paths[:0]
+		for path := range imported {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Printf("%s\t%d tracks\n", path, imported[path])
+		}
+	},
+}
+
+// cueSplitCmd represents the cue split command
+var cueSplitCmd = &cobra.Command{
+	Use:   "split <cue sheet>",
+	Short: "Split the audio files of a cue sheet into one FLAC file per track",
+	Long: `Split the audio files of a cue sheet into one FLAC file per track, tagged with the titles and performers of the cue sheet. The audio is split at the exact samples given by the cue sheet, lossless files keep their sample size. Pregaps stay at the end of the previous track.
+
+The split files are placed next to the cue sheet, or in --out. They are added to the known files and linked to the audio file they were split from, so splitting again skips tracks which were split before from the same audio.`,
+	Args:    cobra.ExactArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		if cueLevel < encode.MIN_LEVEL || cueLevel > encode.MAX_LEVEL {
+			fmt.Printf("Error: level must be between %d and %d\n", encode.MIN_LEVEL, encode.MAX_LEVEL)
+			os.Exit(1)
+		}
+		template, err := pathtemplate.Parse(cueTemplate)
+		if err != nil {
+			fmt.Println("Error parsing template:", err)
+			os.Exit(1)
+		}
+		profile, err := parseSanitizeProfile(cueSanitize, cueReplace, cueNormalize)
+		if err != nil {
+			fmt.Println("Error parsing sanitization profile:", err)
+			os.Exit(1)
+		}
+
+		plan, err := library.PlanCueSplit(db, args[0], cueOut, template, profile, cueLevel)
+		if err != nil {
+			fmt.Println("Error planning split:", err)
+			os.Exit(1)
+		}
+
+		if cueDryRun {
+			for _, s := range plan {
+				switch {
+				case s.Skipped != nil:
+					fmt.Printf("skip\t%d\t%v\n", s.Track.Track, s.Skipped)
+				case s.UpToDate:
+					fmt.Printf("keep\t%d\t%s\n", s.Track.Track, s.Dest)
+				default:
+					fmt.Printf("split\t%d\t%s\n", s.Track.Track, s.Dest)
+				}
+			}
+			return
+		}
+
+		for _, s := range plan {
+			if s.Skipped != nil {
+				fmt.Fprintf(os.Stderr, "Skipping track %d: %v\n", s.Track.Track, s.Skipped)
+			}
+		}
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		err = library.SplitCue(j, plan, cueLevel, func(s library.CueSplit, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error splitting track %d: %v\n", s.Track.Track, err)
+				return
+			}
+			fmt.Printf("split\t%d\t%s\n", s.Track.Track, s.Dest)
+		})
+		if err != nil {
+			fmt.Println("Error splitting cue sheet:", err)
+			fmt.Printf("Split files can be removed again with: musiman undo --last\n")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cueSplitCmd.Flags().StringVarP(&cueOut, "out", "o", "", "Directory for the split files, next to the cue sheet if empty")
+	cueSplitCmd.Flags().StringVarP(&cueTemplate, "template", "t", "{track:02} {title}", "File name template without extension, using the same syntax as library build")
+	cueSplitCmd.Flags().IntVarP(&cueLevel, "level", "l", encode.DEFAULT_LEVEL, "FLAC compression level, from 0 (fastest) to 8 (smallest)")
+	cueSplitCmd.Flags().BoolVarP(&cueDryRun, "dry-run", "n", false, "Only print the planned file of every track")
+	cueSplitCmd.Flags().StringVarP(&cueSanitize, "sanitize", "s", defaultSanitizeProfile(), "File name restrictions of the filesystem: posix, windows, smb, fat32 or strict")
+	cueSplitCmd.Flags().StringVar(&cueReplace, "replacement", "_", "Replacement for characters the filesystem does not allow, may be empty")
+	cueSplitCmd.Flags().StringVar(&cueNormalize, "normalize", "", "Unicode normalization of names: nfc, nfd or none, defaults to the one of the --sanitize profile")
+	cueCmd.AddCommand(cueScanCmd)
+	cueCmd.AddCommand(cueSplitCmd)
+	rootCmd.AddCommand(cueCmd)
+}
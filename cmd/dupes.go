@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+)
+
+var (
+	dupesAcoustic  bool
+	dupesThreshold float64
+)
+
+// dupesCmd represents the dupes command
+var dupesCmd = &cobra.Command{
+	Use:     "dupes [directory]",
+	Short:   "List duplicate known music files (defaults to current directory if not specified)",
+	Long:    "List groups of known music files with identical content. With --acoustic, files are grouped by the similarity of their fingerprints instead, which finds the same recording in different formats and bitrates. Missing fingerprints are calculated first, and the files of a group are ranked from best to worst quality: lossless files first, unless they look like they were made from lossy ones (see analyze --report quality), then by the bandwidth of their audio.",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		var groups []library.DuplicateGroup
+		var err error
+		if dupesAcoustic {
+			var skipped map[string]error
+			groups, skipped, err = library.FindAcousticDuplicates(db, dir, dupesThreshold)
+			for path, reason := range skipped {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, reason)
+			}
+		} else {
+			groups, err = library.FindDuplicates(db, dir)
+		}
+		if err != nil {
+			fmt.Println("Error finding duplicates:", err)
+			os.Exit(1)
+		}
+
+		for i, group := range groups {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("similarity\t%.3f\n", group.Similarity)
+			if !dupesAcoustic {
+				for _, file := range group.Files {
+					fmt.Printf("%s\t%s\t%d\n", file.Path, file.MediaType, file.Size)
+				}
+				continue
+			}
+			ranked, failed := library.RankDuplicates(db, group)
+			for _, f := range ranked {
+				if err, found := failed[f.File.Path]; found {
+					fmt.Fprintf(os.Stderr, "Not ranking %s: %v\n", f.File.Path, err)
+					fmt.Printf("%s\t%s\t%d\tunknown\n", f.File.Path, f.File.MediaType, f.File.Size)
+					continue
+				}
+				fmt.Printf("%s\t%s\t%d\t%s\t%s\n", f.File.Path, f.File.MediaType, f.File.Size, qualityClass(f.Analysis), formatQuality(f.Analysis))
+			}
+		}
+		fmt.Printf("Found %d groups of duplicates\n", len(groups))
+	},
+}
+
+func init() {
+	dupesCmd.Flags().BoolVarP(&dupesAcoustic, "acoustic", "a", false, "Group files by the similarity of their fingerprints instead of their content")
+	dupesCmd.Flags().Float64VarP(&dupesThreshold, "threshold", "t", 0.9, "Minimum fingerprint similarity with --acoustic, unrelated audio scores about 0.5")
+	rootCmd.AddCommand(dupesCmd)
+}
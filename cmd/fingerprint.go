@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+)
+
+// fingerprintCmd represents the fingerprint command
+var fingerprintCmd = &cobra.Command{
+	Use:     "fingerprint [directory]",
+	Short:   "Calculate acoustic fingerprints of known music files (defaults to current directory if not specified)",
+	Long:    "Calculate Chromaprint fingerprints of known music files, as used by AcoustID. Fingerprints are stored per audio content, so files only differing in their tags are decoded once and rescans reuse them.",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			fmt.Println("Error resolving directory:", err)
+			os.Exit(1)
+		}
+
+		files, err := data.GetFilesBelow(db, dir)
+		if err != nil {
+			fmt.Println("Error loading files:", err)
+			os.Exit(1)
+		}
+		failed := 0
+		for _, file := range files {
+			fp, err := library.Fingerprint(db, file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", file.Path, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s\t%.2f\t%s\n", file.Path, fp.Duration, fp.Fingerprint)
+		}
+		fmt.Printf("Fingerprinted %d files\n", len(files)-failed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fingerprintCmd)
+}
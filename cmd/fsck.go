@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+)
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck [directory]",
+	Short: "Detect silent corruption of known music files by re-hashing them (defaults to current directory if not specified)",
+	Long: `Re-hash known music files whose size and modification time did not change since the last scan. A different content hash then indicates silent disk corruption rather than an intentional edit. Such files are recorded as corrupt and listed together with known duplicates which still have the original content, to restore them from.
+
+Files which changed since the last scan are listed as well, rescan them to update their hashes.`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		results, failed, err := library.Fsck(db, dir)
+		if err != nil {
+			fmt.Println("Error checking files:", err)
+			os.Exit(1)
+		}
+		for path, err := range failed {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+		}
+		rotten, changed := 0, 0
+		for _, r := range results {
+			switch {
+			case r.Rotten:
+				rotten++
+				fmt.Printf("rotten\t%s\n", r.File.Path)
+				for _, d := range r.Duplicates {
+					fmt.Printf("copy\t%s\n", d.Path)
+				}
+			case r.Changed:
+				changed++
+				fmt.Printf("changed\t%s\n", r.File.Path)
+			}
+		}
+		fmt.Printf("Checked %d files: %d rotten, %d changed since the last scan\n", len(results), rotten, changed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+}
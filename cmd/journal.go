@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+)
+
+var journalPruneOlderThan time.Duration
+
+// journalCmd represents the journal command
+var journalCmd = &cobra.Command{
+	Use:   "journal",
+	Short: "Manage the journal of file operations",
+}
+
+// journalPruneCmd represents the journal prune command
+var journalPruneCmd = &cobra.Command{
+	Use:     "prune",
+	Short:   "Remove backups of deleted or overwritten files which are no longer needed",
+	Long:    "Remove the backups of deleted or overwritten files of operations older than --older-than or already undone, and backups left behind by interrupted operations. Operations whose backup was removed can no longer be undone.",
+	Args:    cobra.NoArgs,
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		pruned, err := journal.Prune(db, time.Now().Add(-journalPruneOlderThan))
+		for _, path := range pruned {
+			fmt.Printf("prune\t%s\n", path)
+		}
+		if err != nil {
+			fmt.Println("Error pruning backups:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	journalPruneCmd.Flags().DurationVar(&journalPruneOlderThan, "older-than", 30*24*time.Hour, "Minimum age of the operations whose backups are removed")
+	journalCmd.AddCommand(journalPruneCmd)
+	rootCmd.AddCommand(journalCmd)
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+var (
+	libraryDest      string
+	libraryTemplate  string
+	libraryMode      string
+	libraryDryRun    bool
+	librarySanitize  string
+	libraryReplace   string
+	libraryNormalize string
+)
+
+// libraryCmd represents the library command
+var libraryCmd = &cobra.Command{
+	Use:   "library",
+	Short: "Manage the central media library",
+}
+
+// libraryBuildCmd represents the library build command
+var libraryBuildCmd = &cobra.Command{
+	Use:     "build",
+	Short:   "Place every deduplicated music file into the library, in a hierarchy built from its tags",
+	Args:    cobra.NoArgs,
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		template, err := pathtemplate.Parse(libraryTemplate)
+		if err != nil {
+			fmt.Println("Error parsing template:", err)
+			os.Exit(1)
+		}
+		mode, err := library.ParseMode(libraryMode)
+		if err != nil {
+			fmt.Println("Error parsing mode:", err)
+			os.Exit(1)
+		}
+
+		profile, err := parseSanitizeProfile(librarySanitize, libraryReplace, libraryNormalize)
+		if err != nil {
+			fmt.Println("Error parsing sanitization profile:", err)
+			os.Exit(1)
+		}
+
+		plan, err := library.Plan(db, libraryDest, template, profile, mode)
+		if err != nil {
+			fmt.Println("Error planning library:", err)
+			os.Exit(1)
+		}
+
+		if libraryDryRun {
+			for _, p := range plan {
+				switch {
+				case p.Skipped != nil:
+					fmt.Printf("skip\t%s\t%v\n", p.Source, p.Skipped)
+				case p.UpToDate:
+					fmt.Printf("keep\t%s\t%s\n", p.Source, p.Dest)
+				default:
+					fmt.Printf("%s\t%s\t%s\n", mode, p.Source, p.Dest)
+				}
+			}
+			return
+		}
+
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		for _, p := range plan {
+			if p.Skipped != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", p.Source, p.Skipped)
+			}
+		}
+		err = library.Build(j, plan, mode, func(p library.Placement, err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error placing %s: %v\n", p.Source, err)
+				return
+			}
+			fmt.Printf("%s\t%s\t%s\n", mode, p.Source, p.Dest)
+		})
+		if err != nil {
+			fmt.Println("Error building library:", err)
+			fmt.Printf("Placed files can be removed again with: musiman undo --last\n")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	libraryBuildCmd.Flags().StringVarP(&libraryDest, "dest", "d", "", "Root directory of the library")
+	libraryBuildCmd.Flags().StringVarP(&libraryTemplate, "template", "t", "{albumartist}/{album}/{track:02} {title}", "Path template for library files, relative to --dest and without extension")
+	libraryBuildCmd.Flags().StringVarP(&libraryMode, "mode", "m", string(library.MODE_COPY), "How to place files into the library: copy, move, hardlink, reflink or symlink")
+	libraryBuildCmd.Flags().BoolVarP(&libraryDryRun, "dry-run", "n", false, "Only print the planned path of every file")
+	libraryBuildCmd.Flags().StringVarP(&librarySanitize, "sanitize", "s", defaultSanitizeProfile(), "File name restrictions of the target filesystem: posix, windows, smb, fat32 or strict")
+	libraryBuildCmd.Flags().StringVar(&libraryReplace, "replacement", "_", "Replacement for characters the target filesystem does not allow, may be empty")
+	libraryBuildCmd.Flags().StringVar(&libraryNormalize, "normalize", "", "Unicode normalization of names: nfc, nfd or none, defaults to the one of the --sanitize profile")
+	libraryBuildCmd.MarkFlagRequired("dest")
+	libraryCmd.AddCommand(libraryBuildCmd)
+	rootCmd.AddCommand(libraryCmd)
+}
+
+func defaultSanitizeProfile() string {
+	if runtime.GOOS == "windows" {
+		return sanitize.WINDOWS.Name
+	}
+	return sanitize.POSIX.Name
+}
+
+func parseSanitizeProfile(name string, replacement string, normalize string) (sanitize.Profile, error) {
+	profile, err := sanitize.ProfileByName(name)
+	if err != nil {
+		return profile, err
+	}
+	if normalize != "" {
+		normalization, err := sanitize.ParseNormalization(normalize)
+		if err != nil {
+			return profile, err
+		}
+		profile = profile.WithNormalization(normalization)
+	}
+	return profile.WithReplacement(replacement)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/makl11/musiman/acoustid"
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+)
+
+// lookupCmd represents the lookup command
+var lookupCmd = &cobra.Command{
+	Use:     "lookup [directory]",
+	Short:   "Look up known music files on AcoustID by their fingerprints (defaults to current directory if not specified)",
+	Long:    "Look up known music files on AcoustID by their fingerprints and print the best matching MusicBrainz recording. Responses are cached, so repeated lookups work offline. The API key and base URL can also be set as acoustid_api_key and acoustid_url in the config file or the environment.",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			fmt.Println("Error resolving directory:", err)
+			os.Exit(1)
+		}
+
+		client := acoustid.NewClient(viper.GetString("acoustid_api_key"), db)
+		client.BaseURL = viper.GetString("acoustid_url")
+
+		files, err := data.GetFilesBelow(db, dir)
+		if err != nil {
+			fmt.Println("Error loading files:", err)
+			os.Exit(1)
+		}
+		for _, file := range files {
+			results, err := library.LookupAcoustID(cmd.Context(), db, client, file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", file.Path, err)
+				continue
+			}
+			if len(results) == 0 || len(results[0].Recordings) == 0 {
+				fmt.Printf("%s\tno match\n", file.Path)
+				continue
+			}
+			recording := results[0].Recordings[0]
+			fmt.Printf("%s\t%.2f\t%s\t%s\t%s\n", file.Path, results[0].Score, recording.ID, formatArtists(recording.Artists), recording.Title)
+		}
+	},
+}
+
+// formatArtists returns the credited names of artists joined as credited.
+func formatArtists(artists []acoustid.Artist) string {
+	var b strings.Builder
+	for i, a := range artists {
+		b.WriteString(a.Name)
+		if i < len(artists)-1 {
+			if a.JoinPhrase != "" {
+				b.WriteString(a.JoinPhrase)
+			} else {
+				b.WriteString(", ")
+			}
+		}
+	}
+	return b.String()
+}
+
+func init() {
+	lookupCmd.Flags().String("api-key", "", "AcoustID application API key")
+	lookupCmd.Flags().String("acoustid-url", acoustid.BASE_URL, "Base URL of the AcoustID web service, i.e. of a local mirror")
+	viper.BindPFlag("acoustid_api_key", lookupCmd.Flags().Lookup("api-key"))
+	viper.BindPFlag("acoustid_url", lookupCmd.Flags().Lookup("acoustid-url"))
+	rootCmd.AddCommand(lookupCmd)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/audio/loudness"
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+)
+
+var (
+	loudnessWriteTags bool
+	loudnessR128      bool
+	loudnessDryRun    bool
+)
+
+// loudnessCmd represents the loudness command
+var loudnessCmd = &cobra.Command{
+	Use:   "loudness [directory]",
+	Short: "Measure the loudness of known music files and their albums (defaults to current directory if not specified)",
+	Long: `Measure the loudness of known music files after ITU-R BS.1770-4 and EBU R 128: the integrated loudness in LUFS, the loudness range in LU and the true peak in dBTP. Albums are formed by directory. Measurements are stored per audio content, so rescans reuse them.
+
+With --write-tags the REPLAYGAIN_* tags of ReplayGain 2.0 (reference -18 LUFS) are written, with --r128 also R128_TRACK_GAIN and R128_ALBUM_GAIN (reference -23 LUFS). All files are tagged together or not at all.`,
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		albums, failed, err := library.AnalyzeLoudness(db, dir)
+		if err != nil {
+			fmt.Println("Error measuring loudness:", err)
+			os.Exit(1)
+		}
+		for path, err := range failed {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", path, err)
+		}
+		for _, album := range albums {
+			for _, t := range album.Tracks {
+				fmt.Printf("track\t%s\t%s\n", t.File.Path, formatLoudness(t.Measurement))
+			}
+			fmt.Printf("album\t%s\t%s\n", album.Dir, formatLoudness(album.Measurement))
+		}
+		if !loudnessWriteTags && !loudnessR128 {
+			return
+		}
+
+		plan := library.PlanLoudnessTags(albums, loudnessR128)
+		for _, u := range plan {
+			switch {
+			case u.Skipped != nil:
+				fmt.Printf("skip\t%s\t%v\n", u.Path, u.Skipped)
+			case u.UpToDate:
+				fmt.Printf("keep\t%s\n", u.Path)
+			default:
+				fmt.Printf("tag\t%s\t%s\n", u.Path, formatFields(u.Matched))
+			}
+		}
+		if loudnessDryRun {
+			return
+		}
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		tagged, err := library.WriteTags(j, plan)
+		if err != nil {
+			fmt.Println("Error writing tags, no file was changed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Tagged %d files\n", len(tagged))
+	},
+}
+
+// formatLoudness returns the integrated loudness, loudness range and true
+// peak of m, tab separated.
+func formatLoudness(m loudness.Measurement) string {
+	integrated := "silent"
+	if l := m.Integrated(); !math.IsInf(l, -1) {
+		integrated = fmt.Sprintf("%.2f LUFS", l)
+	}
+	return fmt.Sprintf("%s\t%.2f LU\t%.2f dBTP", integrated, m.Range(), 20*math.Log10(m.TruePeak))
+}
+
+func init() {
+	loudnessCmd.Flags().BoolVarP(&loudnessWriteTags, "write-tags", "w", false, "Write ReplayGain 2.0 tags")
+	loudnessCmd.Flags().BoolVar(&loudnessR128, "r128", false, "Also write R128_* gain tags, implies --write-tags")
+	loudnessCmd.Flags().BoolVarP(&loudnessDryRun, "dry-run", "n", false, "Only print the tags that would be written")
+	rootCmd.AddCommand(loudnessCmd)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/musicbrainz"
+)
+
+// mbCmd represents the mb command
+var mbCmd = &cobra.Command{
+	Use:   "mb",
+	Short: "Manage the stored MusicBrainz data",
+}
+
+// mbFetchCmd represents the mb fetch command
+var mbFetchCmd = &cobra.Command{
+	Use:     "fetch <release-mbid>...",
+	Short:   "Fetch releases from MusicBrainz and store them with their tracks",
+	Long:    "Fetch releases from MusicBrainz and store them with their artists, tracks and recordings. The base URL can also be set as musicbrainz_url in the config file or the environment, i.e. to use a local mirror.",
+	Args:    cobra.MinimumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		client := musicbrainz.NewClient()
+		client.BaseURL = viper.GetString("musicbrainz_url")
+		for _, id := range args {
+			r, err := library.FetchRelease(cmd.Context(), db, client, id)
+			if err != nil {
+				fmt.Println("Error fetching release:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("release\t%s\t%s - %s\t%d tracks\n", r.ID, r.ArtistCredit, r.Title, r.TrackCount())
+		}
+	},
+}
+
+// mbImportCmd represents the mb import command
+var mbImportCmd = &cobra.Command{
+	Use:     "import <dump-dir>",
+	Short:   "Import a MusicBrainz data dump for offline matching",
+	Long:    "Import the artists, releases, recordings and tracks of an extracted MusicBrainz JSON or PostgreSQL data dump. Subsets of the dump with only some of the entity files or tables are fine, so matching can run without network access and rate limits.",
+	Args:    cobra.ExactArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		stats, err := library.ImportDump(db, args[0])
+		if err != nil {
+			fmt.Println("Error importing dump:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("artists\t%d\n", stats.Artists)
+		fmt.Printf("releases\t%d\n", stats.Releases)
+		fmt.Printf("recordings\t%d\n", stats.Recordings)
+		fmt.Printf("tracks\t%d\n", stats.Tracks)
+	},
+}
+
+func init() {
+	mbCmd.PersistentFlags().String("musicbrainz-url", musicbrainz.BASE_URL, "Base URL of the MusicBrainz web service, i.e. of a local mirror")
+	viper.BindPFlag("musicbrainz_url", mbCmd.PersistentFlags().Lookup("musicbrainz-url"))
+	mbCmd.AddCommand(mbFetchCmd)
+	mbCmd.AddCommand(mbImportCmd)
+	rootCmd.AddCommand(mbCmd)
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+)
+
+var (
+	renameTemplate  string
+	renameDryRun    bool
+	renameSanitize  string
+	renameReplace   string
+	renameNormalize string
+)
+
+// renameCmd represents the rename command
+var renameCmd = &cobra.Command{
+	Use:     "rename [directory]",
+	Short:   "Rename known music files in place based on their tags (defaults to current directory if not specified)",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		template, err := pathtemplate.Parse(renameTemplate)
+		if err != nil {
+			fmt.Println("Error parsing template:", err)
+			os.Exit(1)
+		}
+		profile, err := parseSanitizeProfile(renameSanitize, renameReplace, renameNormalize)
+		if err != nil {
+			fmt.Println("Error parsing sanitization profile:", err)
+			os.Exit(1)
+		}
+
+		plan, err := library.PlanRename(db, dir, template, profile)
+		if err != nil {
+			fmt.Println("Error planning renames:", err)
+			os.Exit(1)
+		}
+
+		if renameDryRun {
+			for _, p := range plan {
+				switch {
+				case p.Skipped != nil:
+					fmt.Printf("skip\t%s\t%v\n", p.Source, p.Skipped)
+				case p.UpToDate:
+					fmt.Printf("keep\t%s\n", p.Source)
+				case p.CaseOnly:
+					fmt.Printf("case\t%s\t%s\n", p.Source, p.Dest)
+				default:
+					fmt.Printf("rename\t%s\t%s\n", p.Source, p.Dest)
+				}
+			}
+			return
+		}
+
+		for _, p := range plan {
+			if p.Skipped != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", p.Source, p.Skipped)
+			}
+		}
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		renamed, err := library.Rename(j, plan)
+		if err != nil {
+			fmt.Println("Error renaming files, no file was renamed:", err)
+			os.Exit(1)
+		}
+		for _, p := range renamed {
+			fmt.Printf("rename\t%s\t%s\n", p.Source, p.Dest)
+		}
+	},
+}
+
+func init() {
+	renameCmd.Flags().StringVarP(&renameTemplate, "template", "t", "{track:02} {title}", "File name template without extension, using the same syntax as library build")
+	renameCmd.Flags().BoolVarP(&renameDryRun, "dry-run", "n", false, "Only print the planned name of every file")
+	renameCmd.Flags().StringVarP(&renameSanitize, "sanitize", "s", defaultSanitizeProfile(), "File name restrictions of the filesystem: posix, windows, smb, fat32 or strict")
+	renameCmd.Flags().StringVar(&renameReplace, "replacement", "_", "Replacement for characters the filesystem does not allow, may be empty")
+	renameCmd.Flags().StringVar(&renameNormalize, "normalize", "", "Unicode normalization of names: nfc, nfd or none, defaults to the one of the --sanitize profile")
+	rootCmd.AddCommand(renameCmd)
+}
@@ -41,7 +41,7 @@ var scanCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		err = scanner.ScanDirForMusic(dir, minSize, ignorePaths)
+		err = scanner.ScanDirForMusic(db, dir, minSize, ignorePaths)
 		if err != nil {
 			fmt.Println("Error scanning directory:", err)
 			os.Exit(1)
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/audio/transcode"
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+var (
+	syncTo        string
+	syncProfile   string
+	syncPlaylist  string
+	syncQuery     []string
+	syncTemplate  string
+	syncSanitize  string
+	syncReplace   string
+	syncNormalize string
+	syncJobs      int
+	syncDryRun    bool
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Mirror the library, a playlist or a query to a device, transcoding lossless files",
+	Long: `Mirror known music files to a target directory like the SD card of a car stereo or a music player. Without --playlist and --query every distinct file outside of the target is synced. Files are placed in a hierarchy built from their tags, with names allowed on FAT32 unless --sanitize says otherwise.
+
+Lossless files are transcoded with the transcoding profile given by --profile, see "musiman convert --help" for defining profiles. All other files, and every file without --profile, are copied.
+
+The synced files are recorded in the manifest .musiman-sync.json in the target directory. Syncing again only copies or transcodes files whose source changed, and deletes the synced files which are no longer selected. Files which were not created by a sync are never overwritten or deleted.
+
+Examples:
+  musiman sync --to /media/sdcard --profile car
+  musiman sync --to /media/player --playlist ~/road-trip.m3u
+  musiman sync --to /media/player --query genre=jazz --query "artist=Miles*"`,
+	Args:    cobra.NoArgs,
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		template, err := pathtemplate.Parse(syncTemplate)
+		if err != nil {
+			fmt.Println("Error parsing template:", err)
+			os.Exit(1)
+		}
+		sanitizeProfile, err := parseSanitizeProfile(syncSanitize, syncReplace, syncNormalize)
+		if err != nil {
+			fmt.Println("Error parsing sanitization profile:", err)
+			os.Exit(1)
+		}
+		var profile *transcode.Profile
+		if syncProfile != "" {
+			p, err := lookupProfile(syncProfile)
+			if err != nil {
+				fmt.Println("Error parsing profile:", err)
+				os.Exit(1)
+			}
+			profile = &p
+		}
+		query, err := library.ParseQuery(syncQuery)
+		if err != nil {
+			fmt.Println("Error parsing query:", err)
+			os.Exit(1)
+		}
+		var playlist []string
+		if syncPlaylist != "" {
+			if playlist, err = library.ReadPlaylist(syncPlaylist); err != nil {
+				fmt.Println("Error reading playlist:", err)
+				os.Exit(1)
+			}
+		}
+
+		plan, err := library.PlanSync(db, syncTo, playlist, query, template, sanitizeProfile, profile)
+		if err != nil {
+			fmt.Println("Error planning sync:", err)
+			os.Exit(1)
+		}
+
+		if syncDryRun {
+			for _, item := range plan.Items {
+				switch {
+				case item.Skipped != nil:
+					fmt.Printf("skip\t%s\t%v\n", item.Source, item.Skipped)
+				case item.Action == library.SYNC_DELETE:
+					fmt.Printf("%s\t%s\n", item.Action, item.Dest)
+				case item.UpToDate:
+					fmt.Printf("keep\t%s\t%s\n", item.Source, item.Dest)
+				default:
+					fmt.Printf("%s\t%s\t%s\n", item.Action, item.Source, item.Dest)
+				}
+			}
+			return
+		}
+
+		for _, item := range plan.Items {
+			if item.Skipped != nil {
+				fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", item.Source, item.Skipped)
+			}
+		}
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		err = library.Sync(j, plan, profile, syncJobs, func(item library.SyncItem, err error) {
+			if item.Action == library.SYNC_DELETE {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error deleting %s: %v\n", item.Dest, err)
+					return
+				}
+				fmt.Printf("%s\t%s\n", item.Action, item.Dest)
+				return
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error syncing %s: %v\n", item.Source, err)
+				return
+			}
+			fmt.Printf("%s\t%s\t%s\n", item.Action, item.Source, item.Dest)
+		})
+		if err != nil {
+			fmt.Println("Error syncing files:", err)
+			fmt.Printf("Synced files can be removed again with: musiman undo --last\n")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	syncCmd.Flags().StringVar(&syncTo, "to", "", "Target directory, i.e. the mount point of a device")
+	syncCmd.Flags().StringVarP(&syncProfile, "profile", "p", "", "Transcoding profile for lossless files, like mp3, opus, aac or one of the config file; lossless files are copied if empty")
+	syncCmd.Flags().StringVar(&syncPlaylist, "playlist", "", "M3U playlist with the files to sync, instead of the whole library")
+	syncCmd.Flags().StringArrayVarP(&syncQuery, "query", "q", nil, "Only sync files whose tag matches, as field=pattern with * as wildcard; may be repeated")
+	syncCmd.Flags().StringVarP(&syncTemplate, "template", "t", "{albumartist}/{album}/{track:02} {title}", "Path template for synced files, relative to --to and without extension")
+	syncCmd.Flags().StringVarP(&syncSanitize, "sanitize", "s", sanitize.FAT32.Name, "File name restrictions of the target filesystem: posix, windows, smb, fat32 or strict")
+	syncCmd.Flags().StringVar(&syncReplace, "replacement", "_", "Replacement for characters the target filesystem does not allow, may be empty")
+	syncCmd.Flags().StringVar(&syncNormalize, "normalize", "", "Unicode normalization of names: nfc, nfd or none, defaults to the one of the --sanitize profile")
+	syncCmd.Flags().IntVarP(&syncJobs, "jobs", "j", runtime.NumCPU(), "Number of files encoded at once")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "Only print the planned changes of the target directory")
+	syncCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(syncCmd)
+}
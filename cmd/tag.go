@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+)
+
+var (
+	tagPattern      string
+	tagDryRun       bool
+	tagKeepExisting bool
+)
+
+// tagCmd represents the tag command
+var tagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "Edit the tags of known music files",
+}
+
+// tagFromPathCmd represents the tag from-path command
+var tagFromPathCmd = &cobra.Command{
+	Use:     "from-path [directory]",
+	Short:   "Set tags of known music files from their paths (defaults to current directory if not specified)",
+	Long:    "Set tags of known music files from their paths. The pattern uses the syntax of library build templates and describes the trailing directories and the file name without extension, i.e. \"{artist} - {album}/{track} {title}\".",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		pattern, err := pathtemplate.Parse(tagPattern)
+		if err != nil {
+			fmt.Println("Error parsing pattern:", err)
+			os.Exit(1)
+		}
+		plan, err := library.PlanTagsFromPath(db, dir, pattern, tagKeepExisting)
+		if err != nil {
+			fmt.Println("Error planning tags:", err)
+			os.Exit(1)
+		}
+
+		for _, u := range plan {
+			switch {
+			case u.Skipped != nil:
+				fmt.Printf("skip\t%s\t%v\n", u.Path, u.Skipped)
+			case u.UpToDate:
+				fmt.Printf("keep\t%s\n", u.Path)
+			default:
+				fmt.Printf("tag\t%s\t%s\n", u.Path, formatFields(u.Matched))
+			}
+		}
+		if tagDryRun {
+			return
+		}
+
+		j, err := journal.New(db)
+		if err != nil {
+			fmt.Println("Error starting journal:", err)
+			os.Exit(1)
+		}
+		tagged, err := library.WriteTags(j, plan)
+		if err != nil {
+			fmt.Println("Error writing tags, no file was changed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Tagged %d files\n", len(tagged))
+	},
+}
+
+// formatFields returns fields as "name=value" pairs sorted by name.
+func formatFields(fields map[string]string) string {
+	pairs := make([]string, 0, len(fields))
+	for name, value := range fields {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\t")
+}
+
+func init() {
+	tagFromPathCmd.Flags().StringVarP(&tagPattern, "pattern", "p", "", "Path pattern, i.e. \"{artist} - {album}/{track} {title}\"")
+	tagFromPathCmd.MarkFlagRequired("pattern")
+	tagFromPathCmd.Flags().BoolVarP(&tagDryRun, "dry-run", "n", false, "Only print the tags that would be set")
+	tagFromPathCmd.Flags().BoolVarP(&tagKeepExisting, "keep-existing", "k", false, "Only set tags the file does not have yet")
+	tagCmd.AddCommand(tagFromPathCmd)
+	rootCmd.AddCommand(tagCmd)
+}
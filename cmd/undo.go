@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+
+	"github.com/makl11/musiman/context_keys"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+)
+
+var (
+	undoLast bool
+	undoList int
+)
+
+// undoCmd represents the undo command
+var undoCmd = &cobra.Command{
+	Use:     "undo [op-id|--last]",
+	Short:   "Reverse a recorded file operation, or the most recent batch of operations with --last",
+	Args:    cobra.MaximumNArgs(1),
+	PreRunE: data.InitDb,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := cmd.Context().Value(context_keys.DB).(*sqlx.DB) // Never nil, InitDb returns error if it fails
+		defer db.Close()
+
+		if undoList > 0 {
+			ops, err := data.GetRecentOperations(db, undoList)
+			if err != nil {
+				fmt.Println("Error reading operations:", err)
+				os.Exit(1)
+			}
+			for _, op := range ops {
+				printOperation(op)
+			}
+			return
+		}
+
+		var reversals []schema.Operation
+		var err error
+		switch {
+		case undoLast && len(args) == 0:
+			var batch int64
+			batch, err = data.GetLastUndoableBatch(db)
+			if err != nil {
+				fmt.Println("Error finding last batch:", err)
+				os.Exit(1)
+			}
+			reversals, err = journal.UndoBatch(db, batch)
+		case !undoLast && len(args) == 1:
+			var id int64
+			id, err = strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				fmt.Println("Error parsing operation id:", err)
+				os.Exit(1)
+			}
+			var op schema.Operation
+			op, err = data.GetOperation(db, id)
+			if err != nil {
+				fmt.Println("Error reading operation:", err)
+				os.Exit(1)
+			}
+			reversals, err = journal.Undo(db, []schema.Operation{op})
+		default:
+			fmt.Println("Error: specify either an operation id or --last")
+			os.Exit(1)
+		}
+
+		for _, op := range reversals {
+			printOperation(op)
+		}
+		if err != nil {
+			fmt.Println("Error undoing operations:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	undoCmd.Flags().BoolVarP(&undoLast, "last", "l", false, "Undo all operations of the most recent batch")
+	undoCmd.Flags().IntVar(&undoList, "list", 0, "List the given number of most recent operations instead of undoing anything")
+	rootCmd.AddCommand(undoCmd)
+}
+
+func printOperation(op schema.Operation) {
+	fmt.Printf("%d\tbatch %d\t%s\t%s", op.ID, op.Batch, op.Created.Format("2006-01-02 15:04:05"), op.Kind)
+	if op.Reverts != nil {
+		fmt.Printf(" (reverts %d)", *op.Reverts)
+	}
+	if op.BeforePath == op.AfterPath {
+		fmt.Printf("\t%s\n", op.AfterPath)
+	} else {
+		fmt.Printf("\t%s -> %s\n", op.BeforePath, op.AfterPath)
+	}
+}
@@ -0,0 +1,279 @@
+package cue
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Parsing of cue sheets, which describe the tracks of CD images and of albums
+// ripped to a single audio file:
+//
+//	PERFORMER "Band"
+//	TITLE "Album"
+//	REM DATE 1999
+//	FILE "album.flac" WAVE
+//	  TRACK 01 AUDIO
+//	    TITLE "Song"
+//	    INDEX 01 00:00:00
+//	  TRACK 02 AUDIO
+//	    TITLE "Other Song"
+//	    INDEX 00 04:10:30
+//	    INDEX 01 04:12:00
+//
+// Times are given as minutes, seconds and CD frames of 1/75 seconds.
+
+var ErrSyntax = errors.New("invalid cue sheet")
+
+// Number of CD frames per second, the unit of offsets
+const FRAMES_PER_SECOND = 75
+
+// Sheet is a parsed cue sheet.
+type Sheet struct {
+	Performer  string
+	Title      string
+	Songwriter string
+	Catalog    string
+	// Comments of the form REM KEY value by their upper case key, i.e. DATE
+	// or GENRE
+	Rem    map[string]string
+	Files  []File
+	Tracks []Track
+}
+
+// File is an audio file referenced by a cue sheet.
+type File struct {
+	Name string // as given, usually relative to the cue sheet
+	Type string // i.e. WAVE, MP3 or AIFF
+}
+
+// Track is a track of a cue sheet.
+type Track struct {
+	Number     int
+	Type       string // i.e. AUDIO
+	Title      string
+	Performer  string
+	Songwriter string
+	ISRC       string
+	Rem        map[string]string
+	Indexes    []Index
+}
+
+// Index is a position within a track, index 0 starts its pregap and index 1
+// the track itself.
+type Index struct {
+	Number int
+	File   int   // position of the file in Sheet.Files
+	Offset int64 // in CD frames from the start of the file
+}
+
+// Start returns the index 1 of t, the start of the track itself.
+func (t Track) Start() (Index, bool) {
+	for _, index := range t.Indexes {
+		if index.Number == 1 {
+			return index, true
+		}
+	}
+	return Index{}, false
+}
+
+// ReadFile parses the cue sheet at path.
+func ReadFile(path string) (*Sheet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sheet, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sheet, nil
+}
+
+// Parse parses a cue sheet. It is decoded as UTF-8 if it is valid UTF-8 and
+// as Windows-1252 otherwise, which older rippers use. Unknown commands are
+// ignored.
+func Parse(r io.Reader) (*Sheet, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(b) {
+		if b, err = charmap.Windows1252.NewDecoder().Bytes(b); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet := &Sheet{Rem: map[string]string{}}
+	var track *Track
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		fields, err := splitFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrSyntax, n, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		command, args := strings.ToUpper(fields[0]), fields[1:]
+		arg := func(i int) string {
+			if i < len(args) {
+				return args[i]
+			}
+			return ""
+		}
+		fail := func(format string, a ...any) error {
+			return fmt.Errorf("%w: line %d: %s: %s", ErrSyntax, n, command, fmt.Sprintf(format, a...))
+		}
+
+		switch command {
+		case "FILE":
+			if len(args) < 1 {
+				return nil, fail("missing file name")
+			}
+			sheet.Files = append(sheet.Files, File{Name: args[0], Type: strings.ToUpper(arg(1))})
+		case "TRACK":
+			if len(sheet.Files) == 0 {
+				return nil, fail("track before the first file")
+			}
+			number, err := strconv.Atoi(arg(0))
+			if err != nil || number < 1 || number > 99 {
+				return nil, fail("invalid track number \"%s\"", arg(0))
+			}
+			if len(sheet.Tracks) > 0 && number <= sheet.Tracks[len(sheet.Tracks)-1].Number {
+				return nil, fail("track %d is not in ascending order", number)
+			}
+			sheet.Tracks = append(sheet.Tracks, Track{Number: number, Type: strings.ToUpper(arg(1)), Rem: map[string]string{}})
+			track = &sheet.Tracks[len(sheet.Tracks)-1]
+		case "INDEX":
+			if track == nil {
+				return nil, fail("index outside of a track")
+			}
+			number, err := strconv.Atoi(arg(0))
+			if err != nil || number < 0 || number > 99 {
+				return nil, fail("invalid index number \"%s\"", arg(0))
+			}
+			offset, err := ParseTime(arg(1))
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			// Index 1 may be in a later file than index 0, i.e. if the
+			// pregap was appended to the previous track
+			track.Indexes = append(track.Indexes, Index{Number: number, File: len(sheet.Files) - 1, Offset: offset})
+		case "PERFORMER", "TITLE", "SONGWRITER":
+			setText(sheet, track, command, arg(0))
+		case "ISRC":
+			if track != nil {
+				track.ISRC = arg(0)
+			}
+		case "CATALOG":
+			sheet.Catalog = arg(0)
+		case "REM":
+			if len(args) < 2 {
+				continue // plain comment
+			}
+			rem := sheet.Rem
+			if track != nil {
+				rem = track.Rem
+			}
+			rem[strings.ToUpper(args[0])] = strings.Join(args[1:], " ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sheet.Tracks) == 0 {
+		return nil, fmt.Errorf("%w: no tracks", ErrSyntax)
+	}
+	for _, t := range sheet.Tracks {
+		if _, ok := t.Start(); !ok {
+			return nil, fmt.Errorf("%w: track %d has no index 01", ErrSyntax, t.Number)
+		}
+	}
+	return sheet, nil
+}
+
+// setText sets the text field of command on track, or on sheet before the
+// first track.
+func setText(sheet *Sheet, track *Track, command string, value string) {
+	if track == nil {
+		switch command {
+		case "PERFORMER":
+			sheet.Performer = value
+		case "TITLE":
+			sheet.Title = value
+		case "SONGWRITER":
+			sheet.Songwriter = value
+		}
+		return
+	}
+	switch command {
+	case "PERFORMER":
+		track.Performer = value
+	case "TITLE":
+		track.Title = value
+	case "SONGWRITER":
+		track.Songwriter = value
+	}
+}
+
+// splitFields splits a line at spaces, except in double quoted values.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			fields = append(fields, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	return fields, nil
+}
+
+// ParseTime parses a time of the form mm:ss:ff into CD frames.
+func ParseTime(s string) (int64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time \"%s\", expected mm:ss:ff", s)
+	}
+	var values [3]int64
+	for i, part := range parts {
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time \"%s\", expected mm:ss:ff", s)
+		}
+		values[i] = v
+	}
+	if values[1] >= 60 || values[2] >= FRAMES_PER_SECOND {
+		return 0, fmt.Errorf("invalid time \"%s\", seconds or frames out of range", s)
+	}
+	return (values[0]*60+values[1])*FRAMES_PER_SECOND + values[2], nil
+}
+
+// Samples converts an offset in CD frames to samples per channel at
+// sampleRate.
+func Samples(frames int64, sampleRate int) int64 {
+	return frames * int64(sampleRate) / FRAMES_PER_SECOND
+}
@@ -0,0 +1,98 @@
+package cue_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/cue"
+)
+
+const sheet = `REM GENRE "Progressive Rock"
+REM DATE 1999
+REM COMMENT "ExactAudioCopy v1.6"
+PERFORMER "Band"
+TITLE "Album"
+FILE "CD1.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "Song"
+    PERFORMER "Band feat. Guest"
+    ISRC USAB19900001
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Other Song"
+    REM COMPOSER Someone
+    INDEX 00 04:10:30
+FILE "CD2.flac" WAVE
+    INDEX 01 00:00:00
+  TRACK 03 AUDIO
+    TITLE Last
+    INDEX 01 03:02:74
+`
+
+func TestParse(t *testing.T) {
+	s, err := cue.Parse(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Performer != "Band" || s.Title != "Album" || s.Rem["GENRE"] != "Progressive Rock" || s.Rem["DATE"] != "1999" {
+		t.Errorf("expected the album fields, but got %+v", s)
+	}
+	if len(s.Files) != 2 || s.Files[0].Name != "CD1.flac" || s.Files[1].Type != "WAVE" {
+		t.Errorf("expected two files, but got %+v", s.Files)
+	}
+	if len(s.Tracks) != 3 {
+		t.Fatalf("expected 3 tracks, but got %d", len(s.Tracks))
+	}
+	if tr := s.Tracks[0]; tr.Title != "Song" || tr.Performer != "Band feat. Guest" || tr.ISRC != "USAB19900001" {
+		t.Errorf("expected the fields of track 1, but got %+v", tr)
+	}
+	if tr := s.Tracks[1]; tr.Performer != "" || tr.Rem["COMPOSER"] != "Someone" || len(tr.Indexes) != 2 {
+		t.Errorf("expected the fields of track 2, but got %+v", tr)
+	}
+	// The pregap of track 2 is at the end of the first file
+	if start, _ := s.Tracks[1].Start(); start.File != 1 || start.Offset != 0 {
+		t.Errorf("expected track 2 to start the second file, but got %+v", start)
+	}
+	if gap := s.Tracks[1].Indexes[0]; gap.File != 0 || gap.Offset != (4*60+10)*75+30 {
+		t.Errorf("expected the pregap in the first file, but got %+v", gap)
+	}
+	if start, _ := s.Tracks[2].Start(); start.File != 1 || start.Offset != (3*60+2)*75+74 || s.Tracks[2].Title != "Last" {
+		t.Errorf("expected track 3 in the second file, but got %+v", s.Tracks[2])
+	}
+}
+
+func TestParseWindows1252(t *testing.T) {
+	s, err := cue.Parse(strings.NewReader("TITLE \"Caf\xe9\"\r\nFILE a.wav WAVE\r\nTRACK 01 AUDIO\r\nINDEX 01 00:00:00\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Title != "Café" {
+		t.Errorf("expected \"Café\", but got %q", s.Title)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"TRACK 01 AUDIO\nINDEX 01 00:00:00",                                  // no file
+		"FILE a.wav WAVE\nTRACK 01 AUDIO",                                    // no index 01
+		"FILE a.wav WAVE\nTRACK 01 AUDIO\nINDEX 01 00:60:00",                 // seconds out of range
+		"FILE a.wav WAVE\nTRACK 02 AUDIO\nINDEX 01 00:00:00\nTRACK 01 AUDIO", // descending
+		"TITLE \"Album",
+		"PERFORMER \"Band\"\nTITLE \"Album\"", // no tracks
+	}
+	for _, input := range tests {
+		if _, err := cue.Parse(strings.NewReader(input)); !errors.Is(err, cue.ErrSyntax) {
+			t.Errorf("expected error %v for %q, but got %v", cue.ErrSyntax, input, err)
+		}
+	}
+}
+
+func TestSamples(t *testing.T) {
+	if s := cue.Samples(75, 44100); s != 44100 {
+		t.Errorf("expected 44100, but got %d", s)
+	}
+	if s := cue.Samples(1, 44100); s != 588 {
+		t.Errorf("expected 588, but got %d", s)
+	}
+}
@@ -0,0 +1,23 @@
+package data
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+// SaveAcoustIDResponse caches the response of a lookup, replacing an earlier
+// response to the same lookup.
+func SaveAcoustIDResponse(db sqlx.Ext, response schema.AcoustIDResponse) error {
+	_, err := sqlx.NamedExec(db, `INSERT INTO acoustid_responses (fingerprint, duration, response, created) VALUES (:fingerprint, :duration, :response, :created)
+		ON CONFLICT (fingerprint, duration) DO UPDATE SET response = excluded.response, created = excluded.created`, response)
+	return err
+}
+
+// GetAcoustIDResponse returns the cached response of a lookup, or
+// sql.ErrNoRows if it was not looked up yet.
+func GetAcoustIDResponse(db sqlx.Queryer, fingerprint string, duration int) (schema.AcoustIDResponse, error) {
+	var response schema.AcoustIDResponse
+	err := sqlx.Get(db, &response, `SELECT * FROM acoustid_responses WHERE fingerprint = ? AND duration = ?`, fingerprint, duration)
+	return response, err
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidArtwork = errors.New("invalid artwork")
+
+// HashArtwork calculates the hash of an image as it is stored in the artwork
+// table (schema.HASH_SIZE bytes).
+func HashArtwork(data []byte) []byte {
+	hash := sha512.Sum512(data)
+	return hash[:]
+}
+
+// SaveArtwork stores artwork unless an image with the same hash is already
+// stored.
+func SaveArtwork(db sqlx.Ext, artwork schema.Artwork) error {
+	if err := ValidateArtwork(artwork); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO artwork (hash, mime, width, height, data, created) VALUES (:hash, :mime, :width, :height, :data, :created)
+		ON CONFLICT (hash) DO NOTHING`, artwork)
+	return err
+}
+
+// GetArtwork returns the image with the given hash, or sql.ErrNoRows if it is
+// not stored.
+func GetArtwork(db sqlx.Queryer, hash []byte) (schema.Artwork, error) {
+	var artwork schema.Artwork
+	err := sqlx.Get(db, &artwork, `SELECT * FROM artwork WHERE hash = ?`, hash)
+	return artwork, err
+}
+
+// SetFileArtwork replaces the pictures stored for the file content with the
+// given hash by pictures.
+func SetFileArtwork(db sqlx.Ext, fileHash []byte, pictures []schema.FileArtwork) error {
+	if len(fileHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: files content hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidHash, ErrInvalidArgumentValue, schema.HASH_SIZE, len(fileHash))
+	}
+	if _, err := db.Exec(`DELETE FROM file_artwork WHERE file_hash = ?`, fileHash); err != nil {
+		return err
+	}
+	for _, p := range pictures {
+		p.FileHash = fileHash
+		if len(p.ArtworkHash) != schema.HASH_SIZE {
+			return fmt.Errorf("%w: %w: artwork hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidArtwork, ErrInvalidArgumentValue, schema.HASH_SIZE, len(p.ArtworkHash))
+		}
+		_, err := sqlx.NamedExec(db, `INSERT INTO file_artwork (file_hash, picture_type, artwork_hash) VALUES (:file_hash, :picture_type, :artwork_hash)
+			ON CONFLICT DO NOTHING`, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFileArtwork returns the pictures stored for the file content with the
+// given hash, ordered by picture type.
+func GetFileArtwork(db sqlx.Queryer, fileHash []byte) ([]schema.FileArtwork, error) {
+	var pictures []schema.FileArtwork
+	err := sqlx.Select(db, &pictures, `SELECT * FROM file_artwork WHERE file_hash = ? ORDER BY picture_type`, fileHash)
+	return pictures, err
+}
+
+// SaveFolderArtwork stores image, replacing an image with the same path.
+func SaveFolderArtwork(db sqlx.Ext, image schema.FolderArtwork) error {
+	if err := ValidatePath(image.Path); err != nil {
+		return fmt.Errorf("%w: %w: \"%s\" is not a valid file path: %w", ErrInvalidPath, ErrInvalidArgumentValue, image.Path, err)
+	}
+	if len(image.ArtworkHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: artwork hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidArtwork, ErrInvalidArgumentValue, schema.HASH_SIZE, len(image.ArtworkHash))
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO folder_artwork (path, artwork_hash, mod) VALUES (:path, :artwork_hash, :mod)
+		ON CONFLICT (path) DO UPDATE SET artwork_hash = excluded.artwork_hash, mod = excluded.mod`, image)
+	return err
+}
+
+// GetFolderArtwork returns the images stored directly in dir, ordered by path.
+func GetFolderArtwork(db sqlx.Queryer, dir string) ([]schema.FolderArtwork, error) {
+	dir = filepath.Clean(dir)
+	prefix := dir + string(filepath.Separator)
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		prefix = dir // root directory
+	}
+	var images []schema.FolderArtwork
+	err := sqlx.Select(db, &images, `SELECT * FROM folder_artwork WHERE substr(path, 1, ?) = ? AND instr(substr(path, ?), ?) = 0 ORDER BY path`,
+		len(prefix), prefix, len(prefix)+1, string(filepath.Separator))
+	return images, err
+}
+
+func DeleteFolderArtwork(db sqlx.Execer, path string) error {
+	_, err := db.Exec(`DELETE FROM folder_artwork WHERE path = ?`, path)
+	return err
+}
+
+func ValidateArtwork(artwork schema.Artwork) error {
+	if len(artwork.Data) == 0 {
+		return fmt.Errorf("%w: %w: data must not be empty", ErrInvalidArtwork, ErrMissingArgumentValue)
+	}
+	if artwork.MIME == "" {
+		return fmt.Errorf("%w: %w: MIME type must not be empty", ErrInvalidArtwork, ErrMissingArgumentValue)
+	}
+	if artwork.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidArtwork, ErrMissingArgumentValue)
+	}
+	if len(artwork.Hash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidArtwork, ErrInvalidArgumentValue, schema.HASH_SIZE, len(artwork.Hash))
+	}
+	if artwork.Width < 0 || artwork.Height < 0 {
+		return fmt.Errorf("%w: %w: size must not be negative", ErrInvalidArtwork, ErrInvalidArgumentValue)
+	}
+	return nil
+}
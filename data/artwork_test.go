@@ -0,0 +1,106 @@
+package data_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+func testArtwork(content string) schema.Artwork {
+	return schema.Artwork{
+		Hash:    data.HashArtwork([]byte(content)),
+		MIME:    "image/jpeg",
+		Width:   500,
+		Height:  500,
+		Data:    []byte(content),
+		Created: time.Now(),
+	}
+}
+
+func TestSaveArtwork(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	artwork := testArtwork("image")
+	if err := data.SaveArtwork(db, artwork); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	same := artwork
+	same.Width = 1
+	if err := data.SaveArtwork(db, same); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	result, err := data.GetArtwork(db, artwork.Hash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if result.Width != 500 || !bytes.Equal(result.Data, artwork.Data) {
+		t.Errorf("expected the first image to be kept, but got width %d", result.Width)
+	}
+
+	invalid := artwork
+	invalid.Hash = []byte("short")
+	if err := data.SaveArtwork(db, invalid); !errors.Is(err, data.ErrInvalidArtwork) || !errors.Is(err, data.ErrInvalidArgumentValue) {
+		t.Errorf("expected error %v, but got %v", data.ErrInvalidArtwork, err)
+	}
+}
+
+func TestSetFileArtwork(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	front, back := testArtwork("front"), testArtwork("back")
+	err := data.SetFileArtwork(db, validTestFile.Hash, []schema.FileArtwork{
+		{PictureType: 4, ArtworkHash: back.Hash},
+		{PictureType: 3, ArtworkHash: front.Hash},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	pictures, err := data.GetFileArtwork(db, validTestFile.Hash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(pictures) != 2 || pictures[0].PictureType != 3 || !bytes.Equal(pictures[0].ArtworkHash, front.Hash) {
+		t.Errorf("expected front and back cover, but got %v", pictures)
+	}
+
+	if err := data.SetFileArtwork(db, validTestFile.Hash, nil); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if pictures, _ := data.GetFileArtwork(db, validTestFile.Hash); len(pictures) != 0 {
+		t.Errorf("expected pictures to be replaced, but got %d", len(pictures))
+	}
+}
+
+func TestGetFolderArtwork(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	hash := testArtwork("cover").Hash
+	for _, path := range []string{"/music/album/cover.jpg", "/music/album/disc 2/cover.jpg", "/music/album 2/cover.jpg", "/music/folder.jpg"} {
+		if err := data.SaveFolderArtwork(db, schema.FolderArtwork{Path: path, ArtworkHash: hash, Mod: time.Now()}); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+	}
+
+	images, err := data.GetFolderArtwork(db, "/music/album")
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(images) != 1 || images[0].Path != "/music/album/cover.jpg" {
+		t.Errorf("expected only the image directly in the directory, but got %v", images)
+	}
+
+	if err := data.DeleteFolderArtwork(db, "/music/album/cover.jpg"); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if images, _ := data.GetFolderArtwork(db, "/music/album"); len(images) != 0 {
+		t.Errorf("expected the image to be deleted, but got %v", images)
+	}
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidCueTrack = errors.New("invalid cue track")
+
+// SaveCueTracks stores the tracks of the cue sheet at cuePath, replacing the
+// ones stored for it before.
+func SaveCueTracks(db sqlx.Ext, cuePath string, tracks []schema.CueTrack) error {
+	for _, t := range tracks {
+		if t.CuePath != cuePath {
+			return fmt.Errorf("%w: %w: track %d belongs to \"%s\", not \"%s\"", ErrInvalidCueTrack, ErrInvalidArgumentValue, t.Track, t.CuePath, cuePath)
+		}
+		if err := ValidateCueTrack(t); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM cue_tracks WHERE cue_path = ?`, cuePath); err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		_, err := sqlx.NamedExec(db, `INSERT INTO cue_tracks (cue_path, track, path, title, performer, album, album_performer, date, genre, start_frame, end_frame)
+			VALUES (:cue_path, :track, :path, :title, :performer, :album, :album_performer, :date, :genre, :start_frame, :end_frame)`, t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCueTracks returns the tracks of the cue sheet at cuePath, ordered by
+// track number.
+func GetCueTracks(db sqlx.Queryer, cuePath string) ([]schema.CueTrack, error) {
+	var tracks []schema.CueTrack
+	err := sqlx.Select(db, &tracks, `SELECT * FROM cue_tracks WHERE cue_path = ? ORDER BY track`, cuePath)
+	return tracks, err
+}
+
+// GetCueTracksOf returns the tracks stored in the audio file at path, ordered
+// by cue sheet and track number.
+func GetCueTracksOf(db sqlx.Queryer, path string) ([]schema.CueTrack, error) {
+	var tracks []schema.CueTrack
+	err := sqlx.Select(db, &tracks, `SELECT * FROM cue_tracks WHERE path = ? ORDER BY cue_path, track`, path)
+	return tracks, err
+}
+
+func ValidateCueTrack(t schema.CueTrack) error {
+	if t.CuePath == "" || t.Path == "" {
+		return fmt.Errorf("%w: %w: cue path and path must not be empty", ErrInvalidCueTrack, ErrMissingArgumentValue)
+	}
+	if t.Track < 1 || t.Track > 99 {
+		return fmt.Errorf("%w: %w: track number %d is not between 1 and 99", ErrInvalidCueTrack, ErrInvalidArgumentValue, t.Track)
+	}
+	if t.Start < 0 || t.End != nil && *t.End <= t.Start {
+		return fmt.Errorf("%w: %w: track %d does not end after its start", ErrInvalidCueTrack, ErrInvalidArgumentValue, t.Track)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package data_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+func TestSaveCueTracks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	end := int64(18780)
+	tracks := []schema.CueTrack{
+		{CuePath: "/music/album.cue", Track: 1, Path: "/music/album.flac", Title: "Song", Start: 0, End: &end},
+		{CuePath: "/music/album.cue", Track: 2, Path: "/music/album.flac", Title: "Other Song", Start: end},
+	}
+	if err := data.SaveCueTracks(db, "/music/album.cue", tracks); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if err := data.SaveCueTracks(db, "/music/album.cue", tracks[1:]); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	result, err := data.GetCueTracks(db, "/music/album.cue")
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(result) != 1 || result[0].Track != 2 || result[0].End != nil || result[0].Start != end {
+		t.Errorf("expected the tracks to be replaced, but got %+v", result)
+	}
+	if result, err := data.GetCueTracksOf(db, "/music/album.flac"); err != nil || len(result) != 1 {
+		t.Errorf("expected the track of the audio file, but got %+v and %v", result, err)
+	}
+
+	invalid := []schema.CueTrack{
+		{CuePath: "/music/album.cue", Track: 0, Path: "/music/album.flac"},
+		{CuePath: "/music/album.cue", Track: 1, Path: ""},
+		{CuePath: "/music/album.cue", Track: 1, Path: "/music/album.flac", Start: end, End: &end},
+		{CuePath: "/music/other.cue", Track: 1, Path: "/music/album.flac"},
+	}
+	for _, track := range invalid {
+		if err := data.SaveCueTracks(db, "/music/album.cue", []schema.CueTrack{track}); !errors.Is(err, data.ErrInvalidCueTrack) {
+			t.Errorf("expected error %v for %+v, but got %v", data.ErrInvalidCueTrack, track, err)
+		}
+	}
+}
@@ -21,6 +21,17 @@ func InitDb(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if err := Migrate(db); err != nil {
+		return err
+	}
+
+	cmd.SetContext(context.WithValue(cmd.Context(), context_keys.DB, db))
+	return nil
+}
+
+// Migrate applies all embedded migrations to db. It is used by InitDb and by
+// tests of other packages that need an in-memory database with the full schema.
+func Migrate(db *sqlx.DB) error {
 	goose.SetLogger(goose.NopLogger())
 	goose.SetBaseFS(embedMigrations)
 
@@ -28,10 +39,5 @@ func InitDb(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if err := goose.Up(db.DB, "migrations"); err != nil {
-		return err
-	}
-
-	cmd.SetContext(context.WithValue(cmd.Context(), context_keys.DB, db))
-	return nil
+	return goose.Up(db.DB, "migrations")
 }
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidDerivation = errors.New("invalid derivation")
+
+// SaveDerivation stores d, replacing an existing derivation of the same path.
+func SaveDerivation(db sqlx.Ext, d schema.Derivation) error {
+	if err := ValidateDerivation(d); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO derivations (path, hash, source_path, source_hash, format, settings, created)
+		VALUES (:path, :hash, :source_path, :source_hash, :format, :settings, :created)
+		ON CONFLICT (path) DO UPDATE SET hash = excluded.hash, source_path = excluded.source_path, source_hash = excluded.source_hash,
+			format = excluded.format, settings = excluded.settings, created = excluded.created`, d)
+	return err
+}
+
+// GetDerivation returns how the file at path was created, or sql.ErrNoRows if
+// it was not created from another file.
+func GetDerivation(db sqlx.Queryer, path string) (schema.Derivation, error) {
+	var d schema.Derivation
+	err := sqlx.Get(db, &d, `SELECT * FROM derivations WHERE path = ?`, path)
+	return d, err
+}
+
+// GetDerivationsOf returns the derivations of all files created from the file
+// at sourcePath, ordered by path.
+func GetDerivationsOf(db sqlx.Queryer, sourcePath string) ([]schema.Derivation, error) {
+	var derivations []schema.Derivation
+	err := sqlx.Select(db, &derivations, `SELECT * FROM derivations WHERE source_path = ? ORDER BY path`, sourcePath)
+	return derivations, err
+}
+
+func ValidateDerivation(d schema.Derivation) error {
+	if d.Path == "" || d.SourcePath == "" {
+		return fmt.Errorf("%w: %w: path and source path must not be empty", ErrInvalidDerivation, ErrMissingArgumentValue)
+	}
+	if d.Path == d.SourcePath {
+		return fmt.Errorf("%w: %w: \"%s\" can not be derived from itself", ErrInvalidDerivation, ErrInvalidArgumentValue, d.Path)
+	}
+	if len(d.Hash) != schema.HASH_SIZE || len(d.SourceHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: hashes must consist of exactly %d bytes, but are %d and %d bytes", ErrInvalidDerivation, ErrInvalidArgumentValue, schema.HASH_SIZE, len(d.Hash), len(d.SourceHash))
+	}
+	if d.Format == "" {
+		return fmt.Errorf("%w: %w: format must not be empty", ErrInvalidDerivation, ErrMissingArgumentValue)
+	}
+	if d.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidDerivation, ErrMissingArgumentValue)
+	}
+	return nil
+}
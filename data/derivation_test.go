@@ -0,0 +1,74 @@
+package data_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+func TestSaveDerivation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := data.GetDerivation(db, "/music/song.flac"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected error %v, but got %v", sql.ErrNoRows, err)
+	}
+
+	d := schema.Derivation{Path: "/music/song.flac", Hash: validHash, SourcePath: "/music/song.wav", SourceHash: validAudioHash, Format: "flac", Settings: "level 5", Created: time.Now()}
+	if err := data.SaveDerivation(db, d); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	other := d
+	other.Path, other.Format, other.Settings = "/music/song (2).wav", "wav", ""
+	if err := data.SaveDerivation(db, other); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	d.Settings = "level 8"
+	if err := data.SaveDerivation(db, d); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	result, err := data.GetDerivation(db, d.Path)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if result.SourcePath != d.SourcePath || result.Settings != "level 8" {
+		t.Errorf("expected the derivation to be replaced, but got %+v", result)
+	}
+	derivations, err := data.GetDerivationsOf(db, d.SourcePath)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(derivations) != 2 || derivations[0].Path != other.Path || derivations[1].Path != d.Path {
+		t.Errorf("expected both derivations ordered by path, but got %+v", derivations)
+	}
+}
+
+func TestValidateDerivation(t *testing.T) {
+	valid := schema.Derivation{Path: "/b.flac", Hash: validHash, SourcePath: "/a.wav", SourceHash: validHash, Format: "flac", Created: time.Now()}
+	tests := []struct {
+		title  string
+		modify func(d *schema.Derivation)
+		err    error
+	}{
+		{title: "Path", modify: func(d *schema.Derivation) { d.Path = "" }, err: data.ErrMissingArgumentValue},
+		{title: "SourcePath", modify: func(d *schema.Derivation) { d.SourcePath = d.Path }, err: data.ErrInvalidArgumentValue},
+		{title: "Hash", modify: func(d *schema.Derivation) { d.Hash = []byte("short") }, err: data.ErrInvalidArgumentValue},
+		{title: "Format", modify: func(d *schema.Derivation) { d.Format = "" }, err: data.ErrMissingArgumentValue},
+		{title: "Created", modify: func(d *schema.Derivation) { d.Created = time.Time{} }, err: data.ErrMissingArgumentValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			d := valid
+			tt.modify(&d)
+			err := data.ValidateDerivation(d)
+			if !errors.Is(err, data.ErrInvalidDerivation) || !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, but got %v", tt.err, err)
+			}
+		})
+	}
+}
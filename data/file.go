@@ -3,6 +3,7 @@ package data
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -14,12 +15,147 @@ import (
 	"github.com/makl11/musiman/data/schema"
 )
 
-func SaveFile(db *sqlx.DB, file schema.File) error {
+func SaveFile(db sqlx.Ext, file schema.File) error {
 	if err := ValidateFile(file); err != nil {
 		return err
 	}
 
-	_, err := db.NamedExec(`INSERT INTO files (path, hash, media_type, size, mod) VALUES (:path, :hash, :media_type, :size, :mod)`, file)
+	_, err := sqlx.NamedExec(db, `INSERT INTO files (path, hash, media_type, size, mod) VALUES (:path, :hash, :media_type, :size, :mod)`, file)
+	return err
+}
+
+// UpsertFile saves file, replacing hash, media type, size and modification
+// time of an already known file with the same path. Its audio hash and health
+// are kept as long as the content hash does not change.
+func UpsertFile(db sqlx.Ext, file schema.File) error {
+	if err := ValidateFile(file); err != nil {
+		return err
+	}
+
+	_, err := sqlx.NamedExec(db, `INSERT INTO files (path, hash, media_type, size, mod) VALUES (:path, :hash, :media_type, :size, :mod)
+		ON CONFLICT (path) DO UPDATE SET hash = excluded.hash, media_type = excluded.media_type, size = excluded.size, mod = excluded.mod,
+			audio_hash = CASE WHEN files.hash = excluded.hash THEN files.audio_hash END,
+			health = CASE WHEN files.hash = excluded.hash THEN files.health END,
+			problems = CASE WHEN files.hash = excluded.hash THEN files.problems ELSE '' END,
+			verified = CASE WHEN files.hash = excluded.hash THEN files.verified END`, file)
+	return err
+}
+
+func GetFile(db sqlx.Queryer, path string) (schema.File, error) {
+	var file schema.File
+	err := sqlx.Get(db, &file, `SELECT * FROM files WHERE path = ?`, path)
+	return file, err
+}
+
+// GetFilesByHash returns all files with the given content hash, ordered by
+// path.
+func GetFilesByHash(db sqlx.Queryer, hash []byte) ([]schema.File, error) {
+	var files []schema.File
+	err := sqlx.Select(db, &files, `SELECT * FROM files WHERE hash = ? ORDER BY path`, hash)
+	return files, err
+}
+
+// GetUniqueFiles returns one file per distinct content hash, ordered by path.
+// Of files sharing the same content, the one with the lowest path is returned.
+func GetUniqueFiles(db sqlx.Queryer) ([]schema.File, error) {
+	var files []schema.File
+	err := sqlx.Select(db, &files, `SELECT f.* FROM files f
+		WHERE f.path = (SELECT MIN(d.path) FROM files d WHERE d.hash = f.hash)
+		ORDER BY f.path`)
+	return files, err
+}
+
+// GetUniqueFilesOutside is GetUniqueFiles for the files which are not in dir
+// or its subdirectories.
+func GetUniqueFilesOutside(db sqlx.Queryer, dir string) ([]schema.File, error) {
+	prefix, upper := pathRange(dir)
+	var files []schema.File
+	err := sqlx.Select(db, &files, `SELECT f.* FROM files f
+		WHERE NOT (f.path >= ? AND f.path < ?)
+			AND f.path = (SELECT MIN(d.path) FROM files d WHERE d.hash = f.hash AND NOT (d.path >= ? AND d.path < ?))
+		ORDER BY f.path`, prefix, upper, prefix, upper)
+	return files, err
+}
+
+// GetFilesBelow returns all files in dir and its subdirectories, ordered by
+// path.
+func GetFilesBelow(db sqlx.Queryer, dir string) ([]schema.File, error) {
+	prefix, upper := pathRange(dir)
+	var files []schema.File
+	err := sqlx.Select(db, &files, `SELECT * FROM files WHERE path >= ? AND path < ? ORDER BY path`, prefix, upper)
+	return files, err
+}
+
+// pathRange returns the range of paths in dir and its subdirectories, from
+// prefix inclusive to upper exclusive.
+func pathRange(dir string) (prefix string, upper string) {
+	dir = filepath.Clean(dir)
+	prefix = dir + string(filepath.Separator)
+	if strings.HasSuffix(dir, string(filepath.Separator)) {
+		prefix = dir // root directory
+	}
+	// Everything starting with prefix sorts between prefix and prefix with its
+	// last byte incremented
+	upper = prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
+	return prefix, upper
+}
+
+// UpdateFilePath changes the path of a known file, i.e. after it was moved or
+// renamed. Unknown paths are ignored.
+func UpdateFilePath(db sqlx.Execer, oldPath string, newPath string) error {
+	if err := ValidatePath(newPath); err != nil {
+		return fmt.Errorf("%w: %w: \"%s\" is not a valid file path: %w", ErrInvalidPath, ErrInvalidArgumentValue, newPath, err)
+	}
+	_, err := db.Exec(`UPDATE files SET path = ? WHERE path = ?`, newPath, oldPath)
+	return err
+}
+
+// UpdateFileContent stores the new hash, size and modification time of a known
+// file after its content was changed, i.e. by writing tags. The audio hash and
+// health are reset, as the audio data may have changed as well. Unknown paths
+// are ignored.
+func UpdateFileContent(db sqlx.Execer, path string, hash []byte, size uint, mod time.Time) error {
+	if len(hash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: files content hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidHash, ErrInvalidArgumentValue, schema.HASH_SIZE, len(hash))
+	}
+	_, err := db.Exec(`UPDATE files SET hash = ?, size = ?, mod = ?, audio_hash = NULL,
+		health = NULL, problems = '', verified = NULL WHERE path = ?`, hash, size, mod, path)
+	return err
+}
+
+// GetUnverifiedFilesBelow returns the files in dir and its subdirectories
+// which were not verified since before, ordered by path.
+func GetUnverifiedFilesBelow(db sqlx.Queryer, dir string, before time.Time) ([]schema.File, error) {
+	files, err := GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, err
+	}
+	var unverified []schema.File
+	for _, file := range files {
+		if file.Verified == nil || file.Verified.Before(before) {
+			unverified = append(unverified, file)
+		}
+	}
+	return unverified, nil
+}
+
+// SetFileHealth records the result of verifying a known file. Unknown paths
+// are ignored.
+func SetFileHealth(db sqlx.Execer, path string, health string, problems string, verified time.Time) error {
+	switch health {
+	case schema.HEALTH_OK, schema.HEALTH_CORRUPT, schema.HEALTH_UNSUPPORTED:
+	default:
+		return fmt.Errorf("%w: %w: unknown health \"%s\"", ErrInvalidHealth, ErrInvalidArgumentValue, health)
+	}
+	if verified.IsZero() {
+		return fmt.Errorf("%w: %w: verified time must not be zero", ErrInvalidHealth, ErrMissingArgumentValue)
+	}
+	_, err := db.Exec(`UPDATE files SET health = ?, problems = ?, verified = ? WHERE path = ?`, health, problems, verified, path)
+	return err
+}
+
+func DeleteFile(db sqlx.Execer, path string) error {
+	_, err := db.Exec(`DELETE FROM files WHERE path = ?`, path)
 	return err
 }
 
@@ -32,6 +168,7 @@ var (
 	ErrInvalidMediaType = errors.New("invalid media type")
 	ErrInvalidSize      = errors.New("invalid size")
 	ErrInvalidMod       = errors.New("invalid mod")
+	ErrInvalidHealth    = errors.New("invalid health")
 )
 
 func ValidateFile(file schema.File) error {
@@ -1,6 +1,7 @@
 package data_test
 
 import (
+	"bytes"
 	"errors"
 	"os"
 	"reflect"
@@ -183,7 +184,7 @@ func TestSaveFileInvalidMediaType(t *testing.T) {
 	fileWithNonsenseMediaType := validTestFile
 	fileWithNonsenseMediaType.MediaType = "nonsense"
 	fileWithValidButUnsupportedMediaType := validTestFile
-	fileWithValidButUnsupportedMediaType.MediaType = "m4a"
+	fileWithValidButUnsupportedMediaType.MediaType = "mp4"
 
 	testCases := []struct {
 		title string
@@ -205,3 +206,56 @@ func TestSaveFileInvalidMediaType(t *testing.T) {
 		})
 	}
 }
+
+func TestSetFileHealth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	file := validTestFile
+	file.Path = "/music/test.mp3"
+	if err := data.SaveFile(db, file); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	unverified, err := data.GetUnverifiedFilesBelow(db, "/music", time.Now())
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(unverified) != 1 {
+		t.Fatalf("expected 1 unverified file, but got %d", len(unverified))
+	}
+
+	verified := time.Now()
+	if err := data.SetFileHealth(db, file.Path, schema.HEALTH_CORRUPT, "broken", verified); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	file, err = data.GetFile(db, file.Path)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if file.Health == nil || *file.Health != schema.HEALTH_CORRUPT || file.Problems != "broken" || file.Verified == nil || !file.Verified.Equal(verified) {
+		t.Errorf("expected the file to be recorded as corrupt, but got %v, %q, %v", file.Health, file.Problems, file.Verified)
+	}
+	unverified, err = data.GetUnverifiedFilesBelow(db, "/music", verified.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(unverified) != 0 {
+		t.Errorf("expected no unverified files, but got %d", len(unverified))
+	}
+
+	// Changed content has to be verified again
+	if err := data.UpdateFileContent(db, file.Path, bytes.Repeat([]byte("C"), schema.HASH_SIZE), 1, time.Now()); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	file, err = data.GetFile(db, file.Path)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if file.Health != nil || file.Problems != "" || file.Verified != nil {
+		t.Errorf("expected the health to be reset, but got %v, %q, %v", file.Health, file.Problems, file.Verified)
+	}
+
+	if err := data.SetFileHealth(db, file.Path, "fine", "", verified); !errors.Is(err, data.ErrInvalidHealth) || !errors.Is(err, data.ErrInvalidArgumentValue) {
+		t.Errorf("expected error %v, but got %v", data.ErrInvalidHealth, err)
+	}
+}
@@ -0,0 +1,57 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidFingerprint = errors.New("invalid fingerprint")
+
+// SetFileAudioHash stores the hash of the audio data of a known file. Unknown
+// paths are ignored.
+func SetFileAudioHash(db sqlx.Execer, path string, audioHash []byte) error {
+	if len(audioHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: audio hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidHash, ErrInvalidArgumentValue, schema.HASH_SIZE, len(audioHash))
+	}
+	_, err := db.Exec(`UPDATE files SET audio_hash = ? WHERE path = ?`, audioHash, path)
+	return err
+}
+
+// SaveFingerprint stores fp, replacing an existing fingerprint of the same
+// audio data.
+func SaveFingerprint(db sqlx.Ext, fp schema.Fingerprint) error {
+	if err := ValidateFingerprint(fp); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO fingerprints (audio_hash, duration, fingerprint, created) VALUES (:audio_hash, :duration, :fingerprint, :created)
+		ON CONFLICT (audio_hash) DO UPDATE SET duration = excluded.duration, fingerprint = excluded.fingerprint, created = excluded.created`, fp)
+	return err
+}
+
+// GetFingerprint returns the fingerprint of the audio data with the given
+// hash, or sql.ErrNoRows if it has not been calculated yet.
+func GetFingerprint(db sqlx.Queryer, audioHash []byte) (schema.Fingerprint, error) {
+	var fp schema.Fingerprint
+	err := sqlx.Get(db, &fp, `SELECT * FROM fingerprints WHERE audio_hash = ?`, audioHash)
+	return fp, err
+}
+
+func ValidateFingerprint(fp schema.Fingerprint) error {
+	if len(fp.AudioHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: audio hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidFingerprint, ErrInvalidArgumentValue, schema.HASH_SIZE, len(fp.AudioHash))
+	}
+	if fp.Fingerprint == "" {
+		return fmt.Errorf("%w: %w: fingerprint must not be empty", ErrInvalidFingerprint, ErrMissingArgumentValue)
+	}
+	if fp.Duration <= 0 {
+		return fmt.Errorf("%w: %w: duration must be positive", ErrInvalidFingerprint, ErrInvalidArgumentValue)
+	}
+	if fp.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidFingerprint, ErrMissingArgumentValue)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package data_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+var (
+	validAudioHash       = []byte("BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB") // 64 bytes
+	validTestFingerprint = schema.Fingerprint{
+		AudioHash:   validAudioHash,
+		Duration:    187.5,
+		Fingerprint: "AQAAAQA",
+		Created:     time.Now(),
+	}
+)
+
+func TestSaveFingerprint(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := data.SaveFingerprint(db, validTestFingerprint); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	changed := validTestFingerprint
+	changed.Fingerprint = "AQAAAgAA"
+	if err := data.SaveFingerprint(db, changed); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	fp, err := data.GetFingerprint(db, validAudioHash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if fp.Fingerprint != changed.Fingerprint || fp.Duration != changed.Duration {
+		t.Errorf("expected fingerprint %q with duration %v, but got %q with %v", changed.Fingerprint, changed.Duration, fp.Fingerprint, fp.Duration)
+	}
+}
+
+func TestGetFingerprintUnknown(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := data.GetFingerprint(db, validAudioHash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, but got %v", err)
+	}
+}
+
+func TestSaveFingerprintInvalid(t *testing.T) {
+	testCases := []struct {
+		title string
+		fp    schema.Fingerprint
+	}{
+		{title: "AudioHash", fp: withUnsetField(&validTestFingerprint, "AudioHash")},
+		{title: "Duration", fp: withUnsetField(&validTestFingerprint, "Duration")},
+		{title: "Fingerprint", fp: withUnsetField(&validTestFingerprint, "Fingerprint")},
+		{title: "Created", fp: withUnsetField(&validTestFingerprint, "Created")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			db := setupTestDB(t)
+			defer db.Close()
+
+			if err := data.SaveFingerprint(db, tc.fp); !errors.Is(err, data.ErrInvalidFingerprint) {
+				t.Errorf("expected ErrInvalidFingerprint, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileAudioHash(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := data.SaveFile(db, validTestFile); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if err := data.SetFileAudioHash(db, validTestFile.Path, validAudioHash); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	// Rescanning unchanged content keeps the audio hash
+	if err := data.UpsertFile(db, validTestFile); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	file, err := data.GetFile(db, validTestFile.Path)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if !bytes.Equal(file.AudioHash, validAudioHash) {
+		t.Errorf("expected the audio hash to be kept, but got %q", file.AudioHash)
+	}
+
+	changed := validTestFile
+	changed.Hash = bytes.Repeat([]byte("C"), schema.HASH_SIZE)
+	if err := data.UpsertFile(db, changed); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	file, err = data.GetFile(db, validTestFile.Path)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if file.AudioHash != nil {
+		t.Errorf("expected the audio hash to be reset, but got %q", file.AudioHash)
+	}
+}
@@ -0,0 +1,35 @@
+package data
+
+import (
+	"crypto/sha512"
+	"io"
+	"os"
+
+	"github.com/makl11/musiman/audio/tags"
+)
+
+// HashFile calculates the content hash of the file at path as it is stored in
+// the files table (schema.HASH_SIZE bytes).
+func HashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha512.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// HashAudio calculates the hash of the audio data of the music file at path
+// without its tags, so it does not change when the tags are edited.
+func HashAudio(path string) ([]byte, error) {
+	h := sha512.New()
+	if err := tags.CopyAudio(h, path); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidLevels = errors.New("invalid levels")
+
+// SaveLevels stores l, replacing existing statistics of the same audio data.
+func SaveLevels(db sqlx.Ext, l schema.Levels) error {
+	if err := ValidateLevels(l); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO levels (audio_hash, duration, leading_silence, trailing_silence, longest_gap, gap_start, clipped, dc_offset, peak, created)
+		VALUES (:audio_hash, :duration, :leading_silence, :trailing_silence, :longest_gap, :gap_start, :clipped, :dc_offset, :peak, :created)
+		ON CONFLICT (audio_hash) DO UPDATE SET duration = excluded.duration, leading_silence = excluded.leading_silence, trailing_silence = excluded.trailing_silence,
+			longest_gap = excluded.longest_gap, gap_start = excluded.gap_start, clipped = excluded.clipped, dc_offset = excluded.dc_offset, peak = excluded.peak,
+			created = excluded.created`, l)
+	return err
+}
+
+// GetLevels returns the statistics of the audio data with the given hash, or
+// sql.ErrNoRows if they have not been calculated yet.
+func GetLevels(db sqlx.Queryer, audioHash []byte) (schema.Levels, error) {
+	var l schema.Levels
+	err := sqlx.Get(db, &l, `SELECT * FROM levels WHERE audio_hash = ?`, audioHash)
+	return l, err
+}
+
+func ValidateLevels(l schema.Levels) error {
+	if len(l.AudioHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: audio hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidLevels, ErrInvalidArgumentValue, schema.HASH_SIZE, len(l.AudioHash))
+	}
+	if l.Duration < 0 || l.LeadingSilence < 0 || l.TrailingSilence < 0 || l.LongestGap < 0 || l.GapStart < 0 {
+		return fmt.Errorf("%w: %w: times must not be negative", ErrInvalidLevels, ErrInvalidArgumentValue)
+	}
+	if l.LeadingSilence+l.TrailingSilence > l.Duration || l.GapStart+l.LongestGap > l.Duration {
+		return fmt.Errorf("%w: %w: silence must not be longer than the duration of %v s", ErrInvalidLevels, ErrInvalidArgumentValue, l.Duration)
+	}
+	if l.Clipped < 0 || l.Peak < 0 {
+		return fmt.Errorf("%w: %w: clipped samples and peak must not be negative", ErrInvalidLevels, ErrInvalidArgumentValue)
+	}
+	if l.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidLevels, ErrMissingArgumentValue)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package data_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+func TestSaveLevels(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := data.GetLevels(db, validAudioHash); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected error %v, but got %v", sql.ErrNoRows, err)
+	}
+
+	l := schema.Levels{AudioHash: validAudioHash, Duration: 180, LeadingSilence: 0.5, Peak: 0.9, Created: time.Now()}
+	if err := data.SaveLevels(db, l); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	l.Clipped, l.DCOffset = 42, -0.01
+	if err := data.SaveLevels(db, l); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	result, err := data.GetLevels(db, validAudioHash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if result.Clipped != 42 || result.DCOffset != -0.01 || result.LeadingSilence != 0.5 {
+		t.Errorf("expected the statistics to be replaced, but got %+v", result)
+	}
+}
+
+func TestValidateLevels(t *testing.T) {
+	valid := schema.Levels{AudioHash: validAudioHash, Duration: 10, Created: time.Now()}
+	tests := []struct {
+		title  string
+		modify func(l *schema.Levels)
+		err    error
+	}{
+		{title: "AudioHash", modify: func(l *schema.Levels) { l.AudioHash = []byte("short") }, err: data.ErrInvalidArgumentValue},
+		{title: "Duration", modify: func(l *schema.Levels) { l.Duration = -1 }, err: data.ErrInvalidArgumentValue},
+		{title: "LongestGap", modify: func(l *schema.Levels) { l.GapStart, l.LongestGap = 5, 6 }, err: data.ErrInvalidArgumentValue},
+		{title: "Clipped", modify: func(l *schema.Levels) { l.Clipped = -1 }, err: data.ErrInvalidArgumentValue},
+		{title: "Created", modify: func(l *schema.Levels) { l.Created = time.Time{} }, err: data.ErrMissingArgumentValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			l := valid
+			tt.modify(&l)
+			err := data.ValidateLevels(l)
+			if !errors.Is(err, data.ErrInvalidLevels) || !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, but got %v", tt.err, err)
+			}
+		})
+	}
+}
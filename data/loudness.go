@@ -0,0 +1,76 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidLoudness = errors.New("invalid loudness")
+
+// SaveLoudness stores l, replacing an existing measurement of the same audio
+// data.
+func SaveLoudness(db sqlx.Ext, l schema.Loudness) error {
+	if err := ValidateLoudness(l); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO loudness (audio_hash, integrated, loudness_range, sample_peak, true_peak, blocks, short_term, created)
+		VALUES (:audio_hash, :integrated, :loudness_range, :sample_peak, :true_peak, :blocks, :short_term, :created)
+		ON CONFLICT (audio_hash) DO UPDATE SET integrated = excluded.integrated, loudness_range = excluded.loudness_range, sample_peak = excluded.sample_peak,
+			true_peak = excluded.true_peak, blocks = excluded.blocks, short_term = excluded.short_term, created = excluded.created`, l)
+	return err
+}
+
+// GetLoudness returns the loudness of the audio data with the given hash, or
+// sql.ErrNoRows if it has not been measured yet.
+func GetLoudness(db sqlx.Queryer, audioHash []byte) (schema.Loudness, error) {
+	var l schema.Loudness
+	err := sqlx.Get(db, &l, `SELECT * FROM loudness WHERE audio_hash = ?`, audioHash)
+	return l, err
+}
+
+// SaveAlbumLoudness stores l, replacing an existing measurement of the same
+// directory.
+func SaveAlbumLoudness(db sqlx.Ext, l schema.AlbumLoudness) error {
+	if l.Path == "" {
+		return fmt.Errorf("%w: %w: path must not be empty", ErrInvalidLoudness, ErrMissingArgumentValue)
+	}
+	if l.SamplePeak < 0 || l.TruePeak < 0 {
+		return fmt.Errorf("%w: %w: peaks must not be negative", ErrInvalidLoudness, ErrInvalidArgumentValue)
+	}
+	if l.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidLoudness, ErrMissingArgumentValue)
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO album_loudness (path, integrated, loudness_range, sample_peak, true_peak, created)
+		VALUES (:path, :integrated, :loudness_range, :sample_peak, :true_peak, :created)
+		ON CONFLICT (path) DO UPDATE SET integrated = excluded.integrated, loudness_range = excluded.loudness_range, sample_peak = excluded.sample_peak,
+			true_peak = excluded.true_peak, created = excluded.created`, l)
+	return err
+}
+
+// GetAlbumLoudness returns the loudness of the files in the directory at path,
+// or sql.ErrNoRows if it has not been measured yet.
+func GetAlbumLoudness(db sqlx.Queryer, path string) (schema.AlbumLoudness, error) {
+	var l schema.AlbumLoudness
+	err := sqlx.Get(db, &l, `SELECT * FROM album_loudness WHERE path = ?`, path)
+	return l, err
+}
+
+func ValidateLoudness(l schema.Loudness) error {
+	if len(l.AudioHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: audio hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidLoudness, ErrInvalidArgumentValue, schema.HASH_SIZE, len(l.AudioHash))
+	}
+	if l.SamplePeak < 0 || l.TruePeak < 0 {
+		return fmt.Errorf("%w: %w: peaks must not be negative", ErrInvalidLoudness, ErrInvalidArgumentValue)
+	}
+	if len(l.Blocks)%4 != 0 || len(l.ShortTerm)%4 != 0 {
+		return fmt.Errorf("%w: %w: blocks must consist of float32 values", ErrInvalidLoudness, ErrInvalidArgumentValue)
+	}
+	if l.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidLoudness, ErrMissingArgumentValue)
+	}
+	return nil
+}
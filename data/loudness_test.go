@@ -0,0 +1,70 @@
+package data_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+func TestSaveLoudness(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := data.GetLoudness(db, validAudioHash); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected error %v, but got %v", sql.ErrNoRows, err)
+	}
+
+	silence := schema.Loudness{AudioHash: validAudioHash, Blocks: make([]byte, 8), ShortTerm: []byte{}, Created: time.Now()}
+	if err := data.SaveLoudness(db, silence); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	result, err := data.GetLoudness(db, validAudioHash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if result.Integrated != nil || len(result.Blocks) != 8 {
+		t.Errorf("expected no integrated loudness and 2 blocks, but got %v and %d bytes", result.Integrated, len(result.Blocks))
+	}
+
+	integrated := -23.0
+	loud := silence
+	loud.Integrated, loud.TruePeak = &integrated, 0.9
+	if err := data.SaveLoudness(db, loud); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	result, err = data.GetLoudness(db, validAudioHash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if result.Integrated == nil || *result.Integrated != integrated || result.TruePeak != 0.9 {
+		t.Errorf("expected the measurement to be replaced, but got %+v", result)
+	}
+}
+
+func TestValidateLoudness(t *testing.T) {
+	valid := schema.Loudness{AudioHash: validAudioHash, Created: time.Now()}
+	tests := []struct {
+		title  string
+		modify func(l *schema.Loudness)
+		err    error
+	}{
+		{title: "AudioHash", modify: func(l *schema.Loudness) { l.AudioHash = []byte("short") }, err: data.ErrInvalidArgumentValue},
+		{title: "TruePeak", modify: func(l *schema.Loudness) { l.TruePeak = -1 }, err: data.ErrInvalidArgumentValue},
+		{title: "Blocks", modify: func(l *schema.Loudness) { l.Blocks = make([]byte, 3) }, err: data.ErrInvalidArgumentValue},
+		{title: "Created", modify: func(l *schema.Loudness) { l.Created = time.Time{} }, err: data.ErrMissingArgumentValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			l := valid
+			tt.modify(&l)
+			err := data.ValidateLoudness(l)
+			if !errors.Is(err, data.ErrInvalidLoudness) || !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, but got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE operations (
+  `id` INTEGER NOT NULL,
+  `batch` INTEGER NOT NULL,
+  `kind` TEXT NOT NULL,
+  `before_path` TEXT NOT NULL,
+  `after_path` TEXT NOT NULL,
+  `before_hash` BLOB,
+  `after_hash` BLOB,
+  `backup` TEXT NOT NULL DEFAULT '',
+  `media_type` TEXT NOT NULL DEFAULT '', -- of a deleted file from the files table, to re-add it when restoring
+  `reverts` INTEGER,
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`id` AUTOINCREMENT),
+  FOREIGN KEY (`reverts`) REFERENCES operations (`id`)
+);
+CREATE INDEX operations_batch ON operations (`batch`);
+CREATE UNIQUE INDEX operations_reverts ON operations (`reverts`);
+-- +goose Down
+DROP TABLE operations;
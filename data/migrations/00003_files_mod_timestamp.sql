@@ -0,0 +1,29 @@
+-- +goose Up
+-- Declare `mod` as TIMESTAMP so the sqlite driver parses it back into a time
+CREATE TABLE files_new (
+  `path` TEXT NOT NULL,
+  `hash` BLOB NOT NULL,
+  `media_type` TEXT NOT NULL,
+  `size` INTEGER NOT NULL,
+  `mod` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`path`)
+);
+INSERT INTO files_new SELECT * FROM files;
+DROP TABLE files;
+ALTER TABLE files_new RENAME TO files;
+CREATE INDEX files_hash ON files (`hash`);
+-- +goose Down
+DROP INDEX files_hash;
+CREATE TABLE files_old (
+  `path` TEXT NOT NULL,
+  `hash` BLOB NOT NULL,
+  `media_type` TEXT NOT NULL,
+  `size` INTEGER NOT NULL,
+  `mod` TEXT NOT NULL,
+  --
+  PRIMARY KEY (`path`)
+);
+INSERT INTO files_old SELECT * FROM files;
+DROP TABLE files;
+ALTER TABLE files_old RENAME TO files;
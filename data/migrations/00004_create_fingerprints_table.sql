@@ -0,0 +1,17 @@
+-- +goose Up
+-- Hash of the audio data without tags, fingerprints are shared by all files
+-- with the same audio
+ALTER TABLE files ADD COLUMN `audio_hash` BLOB;
+CREATE INDEX files_audio_hash ON files (`audio_hash`);
+CREATE TABLE fingerprints (
+  `audio_hash` BLOB NOT NULL,
+  `duration` REAL NOT NULL,
+  `fingerprint` TEXT NOT NULL,
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`audio_hash`)
+);
+-- +goose Down
+DROP TABLE fingerprints;
+DROP INDEX files_audio_hash;
+ALTER TABLE files DROP COLUMN `audio_hash`;
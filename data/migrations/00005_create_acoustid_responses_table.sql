@@ -0,0 +1,13 @@
+-- +goose Up
+-- Raw responses of AcoustID lookups, so repeated lookups do not hit the
+-- rate limited web service
+CREATE TABLE acoustid_responses (
+  `fingerprint` TEXT NOT NULL,
+  `duration` INTEGER NOT NULL,
+  `response` TEXT NOT NULL,
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`fingerprint`, `duration`)
+);
+-- +goose Down
+DROP TABLE acoustid_responses;
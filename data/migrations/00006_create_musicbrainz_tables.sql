@@ -0,0 +1,66 @@
+-- +goose Up
+-- Canonical metadata from MusicBrainz, identified by their MBIDs. Artist
+-- credits are stored as displayed, linked to the first credited artist.
+CREATE TABLE artists (
+  `id` TEXT NOT NULL,
+  `name` TEXT NOT NULL,
+  `sort_name` TEXT NOT NULL DEFAULT '',
+  `disambiguation` TEXT NOT NULL DEFAULT '',
+  `updated` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`id`)
+);
+CREATE TABLE releases (
+  `id` TEXT NOT NULL,
+  `release_group_id` TEXT NOT NULL DEFAULT '',
+  `title` TEXT NOT NULL,
+  `artist_id` TEXT NOT NULL,
+  `artist_credit` TEXT NOT NULL,
+  `date` TEXT NOT NULL DEFAULT '',
+  `country` TEXT NOT NULL DEFAULT '',
+  `status` TEXT NOT NULL DEFAULT '',
+  `barcode` TEXT NOT NULL DEFAULT '',
+  `track_count` INTEGER NOT NULL,
+  `updated` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`artist_id`) REFERENCES artists (`id`)
+);
+CREATE TABLE recordings (
+  `id` TEXT NOT NULL,
+  `title` TEXT NOT NULL,
+  `artist_id` TEXT NOT NULL,
+  `artist_credit` TEXT NOT NULL,
+  `length` INTEGER NOT NULL DEFAULT 0, -- milliseconds, 0 if unknown
+  `updated` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`artist_id`) REFERENCES artists (`id`)
+);
+CREATE TABLE tracks (
+  `id` TEXT NOT NULL,
+  `release_id` TEXT NOT NULL,
+  `recording_id` TEXT NOT NULL,
+  `disc` INTEGER NOT NULL,
+  `position` INTEGER NOT NULL,
+  `number` TEXT NOT NULL,
+  `title` TEXT NOT NULL,
+  `artist_credit` TEXT NOT NULL,
+  `length` INTEGER NOT NULL DEFAULT 0, -- milliseconds, 0 if unknown
+  --
+  PRIMARY KEY (`id`),
+  FOREIGN KEY (`release_id`) REFERENCES releases (`id`),
+  FOREIGN KEY (`recording_id`) REFERENCES recordings (`id`)
+);
+CREATE INDEX tracks_release ON tracks (`release_id`, `disc`, `position`);
+CREATE INDEX tracks_recording ON tracks (`recording_id`);
+-- The recording is known without the track after an AcoustID lookup
+ALTER TABLE files ADD COLUMN `recording_id` TEXT REFERENCES recordings (`id`);
+ALTER TABLE files ADD COLUMN `track_id` TEXT REFERENCES tracks (`id`);
+-- +goose Down
+ALTER TABLE files DROP COLUMN `track_id`;
+ALTER TABLE files DROP COLUMN `recording_id`;
+DROP TABLE tracks;
+DROP TABLE recordings;
+DROP TABLE releases;
+DROP TABLE artists;
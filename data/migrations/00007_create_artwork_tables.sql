@@ -0,0 +1,35 @@
+-- +goose Up
+-- Images embedded in music files or found next to them, stored once per
+-- content hash
+CREATE TABLE artwork (
+  `hash` BLOB NOT NULL,
+  `mime` TEXT NOT NULL,
+  `width` INTEGER NOT NULL DEFAULT 0, -- pixels, 0 if the image can not be decoded
+  `height` INTEGER NOT NULL DEFAULT 0,
+  `data` BLOB NOT NULL,
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`hash`)
+);
+-- Pictures embedded in files, by the content hash of the file
+CREATE TABLE file_artwork (
+  `file_hash` BLOB NOT NULL,
+  `picture_type` INTEGER NOT NULL,
+  `artwork_hash` BLOB NOT NULL,
+  --
+  PRIMARY KEY (`file_hash`, `picture_type`, `artwork_hash`),
+  FOREIGN KEY (`artwork_hash`) REFERENCES artwork (`hash`)
+);
+-- Image files like cover.jpg in album directories
+CREATE TABLE folder_artwork (
+  `path` TEXT NOT NULL,
+  `artwork_hash` BLOB NOT NULL,
+  `mod` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`path`),
+  FOREIGN KEY (`artwork_hash`) REFERENCES artwork (`hash`)
+);
+-- +goose Down
+DROP TABLE folder_artwork;
+DROP TABLE file_artwork;
+DROP TABLE artwork;
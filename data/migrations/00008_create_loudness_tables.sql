@@ -0,0 +1,29 @@
+-- +goose Up
+-- Loudness after ITU-R BS.1770-4 by audio data. The energies of the gating
+-- and short-term blocks are kept to calculate the loudness of albums.
+CREATE TABLE loudness (
+  `audio_hash` BLOB NOT NULL,
+  `integrated` REAL, -- LUFS, NULL for silence
+  `loudness_range` REAL NOT NULL, -- LU
+  `sample_peak` REAL NOT NULL, -- linear, 1 for full scale
+  `true_peak` REAL NOT NULL,
+  `blocks` BLOB NOT NULL, -- little endian float32 mean squares of the 400 ms gating blocks
+  `short_term` BLOB NOT NULL, -- same for the 3 s short-term blocks
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`audio_hash`)
+);
+-- Loudness of the files in a directory played one after another
+CREATE TABLE album_loudness (
+  `path` TEXT NOT NULL,
+  `integrated` REAL,
+  `loudness_range` REAL NOT NULL,
+  `sample_peak` REAL NOT NULL,
+  `true_peak` REAL NOT NULL,
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`path`)
+);
+-- +goose Down
+DROP TABLE album_loudness;
+DROP TABLE loudness;
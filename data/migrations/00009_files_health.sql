@@ -0,0 +1,9 @@
+-- +goose Up
+-- Result of the last integrity check of a file, reset when its content changes
+ALTER TABLE files ADD COLUMN `health` TEXT; -- NULL until verified
+ALTER TABLE files ADD COLUMN `problems` TEXT NOT NULL DEFAULT ''; -- one per line
+ALTER TABLE files ADD COLUMN `verified` TIMESTAMP;
+-- +goose Down
+ALTER TABLE files DROP COLUMN `verified`;
+ALTER TABLE files DROP COLUMN `problems`;
+ALTER TABLE files DROP COLUMN `health`;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Spectral analysis by audio data, finding lossless files made from lossy ones
+CREATE TABLE quality (
+  `audio_hash` BLOB NOT NULL,
+  `sample_rate` INTEGER NOT NULL,
+  `bits` INTEGER NOT NULL, -- sample size, 0 for lossy formats
+  `used_bits` INTEGER NOT NULL, -- bits which are not always zero, 0 if unknown
+  `cutoff` REAL NOT NULL, -- Hz, highest frequency below a lowpass shelf, 0 if there is none
+  `shelf` REAL NOT NULL, -- dB, drop of the level above the cutoff
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`audio_hash`)
+);
+-- +goose Down
+DROP TABLE quality;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Level statistics by audio data: silence, clipping, DC offset and peak
+CREATE TABLE levels (
+  `audio_hash` BLOB NOT NULL,
+  `duration` REAL NOT NULL, -- seconds
+  `leading_silence` REAL NOT NULL, -- seconds
+  `trailing_silence` REAL NOT NULL,
+  `longest_gap` REAL NOT NULL, -- seconds of the longest silence between audio
+  `gap_start` REAL NOT NULL,
+  `clipped` INTEGER NOT NULL, -- samples
+  `dc_offset` REAL NOT NULL, -- linear, 1 for full scale
+  `peak` REAL NOT NULL,
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`audio_hash`)
+);
+-- +goose Down
+DROP TABLE levels;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Files created from the audio of another file, i.e. by converting it to
+-- another format. Paths and hashes are those at the time of the conversion.
+CREATE TABLE derivations (
+  `path` TEXT NOT NULL,
+  `hash` BLOB NOT NULL,
+  `source_path` TEXT NOT NULL,
+  `source_hash` BLOB NOT NULL,
+  `format` TEXT NOT NULL, -- of the created file, i.e. flac
+  `settings` TEXT NOT NULL DEFAULT '', -- of the encoder, i.e. level 5
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`path`)
+);
+CREATE INDEX derivations_source_path ON derivations (`source_path`);
+-- +goose Down
+DROP TABLE derivations;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Virtual tracks of cue sheets, each a part of a single audio file. Offsets
+-- are in CD frames of 1/75 seconds from the start of the audio file.
+CREATE TABLE cue_tracks (
+  `cue_path` TEXT NOT NULL,
+  `track` INTEGER NOT NULL,
+  `path` TEXT NOT NULL, -- of the audio file
+  `title` TEXT NOT NULL DEFAULT '',
+  `performer` TEXT NOT NULL DEFAULT '',
+  `album` TEXT NOT NULL DEFAULT '',
+  `album_performer` TEXT NOT NULL DEFAULT '',
+  `date` TEXT NOT NULL DEFAULT '',
+  `genre` TEXT NOT NULL DEFAULT '',
+  `start_frame` INTEGER NOT NULL,
+  `end_frame` INTEGER, -- NULL if the track lasts until the end of the file
+  --
+  PRIMARY KEY (`cue_path`, `track`)
+);
+CREATE INDEX cue_tracks_path ON cue_tracks (`path`);
+-- +goose Down
+DROP TABLE cue_tracks;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Sample-exact properties by audio data, with the encoder delay and padding
+-- of lossy formats which record them
+CREATE TABLE audio_properties (
+  `audio_hash` BLOB NOT NULL,
+  `sample_rate` INTEGER NOT NULL, -- Hz
+  `channels` INTEGER NOT NULL,
+  `bits_per_sample` INTEGER NOT NULL, -- 0 for lossy formats
+  `samples` INTEGER NOT NULL, -- per channel, without delay and padding
+  `encoder_delay` INTEGER, -- samples per channel, NULL if not recorded
+  `encoder_padding` INTEGER,
+  `created` TIMESTAMP NOT NULL,
+  --
+  PRIMARY KEY (`audio_hash`)
+);
+-- MP3 decoding now removes the encoder delay and padding, which changes the
+-- fingerprints and levels of MP3 audio, so they are calculated again
+DELETE FROM fingerprints WHERE audio_hash IN (SELECT audio_hash FROM files WHERE lower(path) LIKE '%.mp3');
+DELETE FROM levels WHERE audio_hash IN (SELECT audio_hash FROM files WHERE lower(path) LIKE '%.mp3');
+-- +goose Down
+DROP TABLE audio_properties;
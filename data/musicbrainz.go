@@ -0,0 +1,138 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidMBID = errors.New("invalid MusicBrainz identifier")
+
+// SaveArtist stores artist, replacing an artist with the same MBID.
+func SaveArtist(db sqlx.Ext, artist schema.Artist) error {
+	if err := validateMBID(artist.ID); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO artists (id, name, sort_name, disambiguation, updated) VALUES (:id, :name, :sort_name, :disambiguation, :updated)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, sort_name = excluded.sort_name, disambiguation = excluded.disambiguation, updated = excluded.updated`, artist)
+	return err
+}
+
+// SaveRelease stores release, replacing a release with the same MBID. Its
+// tracks are saved separately.
+func SaveRelease(db sqlx.Ext, release schema.Release) error {
+	if err := validateMBID(release.ID); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO releases (id, release_group_id, title, artist_id, artist_credit, date, country, status, barcode, track_count, updated)
+		VALUES (:id, :release_group_id, :title, :artist_id, :artist_credit, :date, :country, :status, :barcode, :track_count, :updated)
+		ON CONFLICT (id) DO UPDATE SET release_group_id = excluded.release_group_id, title = excluded.title, artist_id = excluded.artist_id,
+			artist_credit = excluded.artist_credit, date = excluded.date, country = excluded.country, status = excluded.status,
+			barcode = excluded.barcode, track_count = excluded.track_count, updated = excluded.updated`, release)
+	return err
+}
+
+// SaveRecording stores recording, replacing a recording with the same MBID.
+func SaveRecording(db sqlx.Ext, recording schema.Recording) error {
+	if err := validateMBID(recording.ID); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO recordings (id, title, artist_id, artist_credit, length, updated) VALUES (:id, :title, :artist_id, :artist_credit, :length, :updated)
+		ON CONFLICT (id) DO UPDATE SET title = excluded.title, artist_id = excluded.artist_id, artist_credit = excluded.artist_credit,
+			length = excluded.length, updated = excluded.updated`, recording)
+	return err
+}
+
+// SaveTrack stores track, replacing a track with the same MBID.
+func SaveTrack(db sqlx.Ext, track schema.Track) error {
+	if err := validateMBID(track.ID); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO tracks (id, release_id, recording_id, disc, position, number, title, artist_credit, length)
+		VALUES (:id, :release_id, :recording_id, :disc, :position, :number, :title, :artist_credit, :length)
+		ON CONFLICT (id) DO UPDATE SET release_id = excluded.release_id, recording_id = excluded.recording_id, disc = excluded.disc,
+			position = excluded.position, number = excluded.number, title = excluded.title, artist_credit = excluded.artist_credit, length = excluded.length`, track)
+	return err
+}
+
+func GetArtist(db sqlx.Queryer, id string) (schema.Artist, error) {
+	var artist schema.Artist
+	err := sqlx.Get(db, &artist, `SELECT * FROM artists WHERE id = ?`, id)
+	return artist, err
+}
+
+func GetRelease(db sqlx.Queryer, id string) (schema.Release, error) {
+	var release schema.Release
+	err := sqlx.Get(db, &release, `SELECT * FROM releases WHERE id = ?`, id)
+	return release, err
+}
+
+func GetRecording(db sqlx.Queryer, id string) (schema.Recording, error) {
+	var recording schema.Recording
+	err := sqlx.Get(db, &recording, `SELECT * FROM recordings WHERE id = ?`, id)
+	return recording, err
+}
+
+// GetReleaseTracks returns the tracks of a release in the order of its media.
+func GetReleaseTracks(db sqlx.Queryer, releaseID string) ([]schema.Track, error) {
+	var tracks []schema.Track
+	err := sqlx.Select(db, &tracks, `SELECT * FROM tracks WHERE release_id = ? ORDER BY disc, position`, releaseID)
+	return tracks, err
+}
+
+// FindReleasesByTitle returns the releases with the given title, ignoring the
+// case of ASCII letters.
+func FindReleasesByTitle(db sqlx.Queryer, title string) ([]schema.Release, error) {
+	var releases []schema.Release
+	err := sqlx.Select(db, &releases, `SELECT * FROM releases WHERE title = ? COLLATE NOCASE ORDER BY id`, title)
+	return releases, err
+}
+
+// GetRecordingReleases returns the releases with a track of the recording.
+func GetRecordingReleases(db sqlx.Queryer, recordingID string) ([]schema.Release, error) {
+	var releases []schema.Release
+	err := sqlx.Select(db, &releases, `SELECT * FROM releases WHERE id IN (SELECT release_id FROM tracks WHERE recording_id = ?) ORDER BY id`, recordingID)
+	return releases, err
+}
+
+// LinkFileToRecording records the recording of a known file, i.e. after an
+// AcoustID lookup. A linked track of another recording is unlinked.
+func LinkFileToRecording(db sqlx.Execer, path string, recordingID string) error {
+	if err := validateMBID(recordingID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE files SET recording_id = ?,
+		track_id = CASE WHEN recording_id = ? THEN track_id END WHERE path = ?`, recordingID, recordingID, path)
+	return err
+}
+
+// LinkFileToTrack records the track and with it the recording of a known
+// file. Unknown paths are ignored.
+func LinkFileToTrack(db sqlx.Execer, path string, track schema.Track) error {
+	if err := validateMBID(track.ID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE files SET recording_id = ?, track_id = ? WHERE path = ?`, track.RecordingID, track.ID, path)
+	return err
+}
+
+// validateMBID checks that id is a UUID in its canonical lower case form.
+func validateMBID(id string) error {
+	if len(id) != 36 {
+		return fmt.Errorf("%w: %w: \"%s\" is not a UUID", ErrInvalidMBID, ErrInvalidArgumentValue, id)
+	}
+	for i, c := range id {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return fmt.Errorf("%w: %w: \"%s\" is not a UUID", ErrInvalidMBID, ErrInvalidArgumentValue, id)
+			}
+		case !('0' <= c && c <= '9' || 'a' <= c && c <= 'f'):
+			return fmt.Errorf("%w: %w: \"%s\" is not a UUID", ErrInvalidMBID, ErrInvalidArgumentValue, id)
+		}
+	}
+	return nil
+}
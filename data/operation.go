@@ -0,0 +1,114 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var (
+	ErrInvalidOperation  = errors.New("invalid operation")
+	ErrOperationNotFound = errors.New("operation not found")
+)
+
+// NextBatch returns a batch number that has not been used by any recorded
+// operation yet.
+func NextBatch(db sqlx.Queryer) (int64, error) {
+	var batch int64
+	err := sqlx.Get(db, &batch, `SELECT COALESCE(MAX(batch), 0) + 1 FROM operations`)
+	return batch, err
+}
+
+// SaveOperation appends op to the operations journal and returns its ID.
+func SaveOperation(db sqlx.Ext, op schema.Operation) (int64, error) {
+	if err := ValidateOperation(op); err != nil {
+		return 0, err
+	}
+
+	res, err := sqlx.NamedExec(db, `INSERT INTO operations (batch, kind, before_path, after_path, before_hash, after_hash, backup, media_type, reverts, created)
+		VALUES (:batch, :kind, :before_path, :after_path, :before_hash, :after_hash, :backup, :media_type, :reverts, :created)`, op)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func ValidateOperation(op schema.Operation) error {
+	if op.Batch <= 0 {
+		return fmt.Errorf("%w: %w: batch must be positive", ErrInvalidOperation, ErrInvalidArgumentValue)
+	}
+	switch op.Kind {
+	case schema.OP_MOVE, schema.OP_RENAME, schema.OP_TAG_WRITE, schema.OP_DELETE, schema.OP_COPY, schema.OP_LINK, schema.OP_CONVERT, schema.OP_WRITE, schema.OP_OVERWRITE, schema.OP_RESTORE:
+	case "":
+		return fmt.Errorf("%w: %w: kind must not be empty", ErrInvalidOperation, ErrMissingArgumentValue)
+	default:
+		return fmt.Errorf("%w: %w: unknown operation kind: \"%s\"", ErrInvalidOperation, ErrInvalidArgumentValue, op.Kind)
+	}
+	if op.BeforePath == "" || op.AfterPath == "" {
+		return fmt.Errorf("%w: %w: before and after path must not be empty", ErrInvalidOperation, ErrMissingArgumentValue)
+	}
+	if op.BeforeHash == nil && op.AfterHash == nil {
+		return fmt.Errorf("%w: %w: at least one of before and after hash must be set", ErrInvalidOperation, ErrMissingArgumentValue)
+	}
+	if op.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidOperation, ErrMissingArgumentValue)
+	}
+	return nil
+}
+
+func GetOperation(db sqlx.Queryer, id int64) (schema.Operation, error) {
+	var op schema.Operation
+	err := sqlx.Get(db, &op, `SELECT * FROM operations WHERE id = ?`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return op, fmt.Errorf("%w: %d", ErrOperationNotFound, id)
+	}
+	return op, err
+}
+
+// GetBatchOperations returns all operations of batch in the order they were
+// performed.
+func GetBatchOperations(db sqlx.Queryer, batch int64) ([]schema.Operation, error) {
+	var ops []schema.Operation
+	err := sqlx.Select(db, &ops, `SELECT * FROM operations WHERE batch = ? ORDER BY id`, batch)
+	return ops, err
+}
+
+// GetRecentOperations returns up to limit operations, most recent first.
+func GetRecentOperations(db sqlx.Queryer, limit int) ([]schema.Operation, error) {
+	var ops []schema.Operation
+	err := sqlx.Select(db, &ops, `SELECT * FROM operations ORDER BY id DESC LIMIT ?`, limit)
+	return ops, err
+}
+
+// GetLastUndoableBatch returns the most recent batch that still contains
+// operations which are neither reversals nor already reverted.
+func GetLastUndoableBatch(db sqlx.Queryer) (int64, error) {
+	var batch int64
+	err := sqlx.Get(db, &batch, `SELECT o.batch FROM operations o
+		WHERE o.reverts IS NULL AND NOT EXISTS (SELECT 1 FROM operations r WHERE r.reverts = o.id)
+		ORDER BY o.id DESC LIMIT 1`)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: nothing left to undo", ErrOperationNotFound)
+	}
+	return batch, err
+}
+
+// IsOperationReverted reports whether a reversal of the operation with id has
+// been recorded.
+func IsOperationReverted(db sqlx.Queryer, id int64) (bool, error) {
+	var count int
+	err := sqlx.Get(db, &count, `SELECT COUNT(*) FROM operations WHERE reverts = ?`, id)
+	return count > 0, err
+}
+
+// GetBackupOperations returns all operations which kept a backup of the
+// previous content of a file, oldest first.
+func GetBackupOperations(db sqlx.Queryer) ([]schema.Operation, error) {
+	var ops []schema.Operation
+	err := sqlx.Select(db, &ops, `SELECT * FROM operations WHERE backup != '' ORDER BY id`)
+	return ops, err
+}
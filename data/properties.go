@@ -0,0 +1,55 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidAudioProperties = errors.New("invalid audio properties")
+
+// SaveAudioProperties stores p, replacing existing properties of the same
+// audio data.
+func SaveAudioProperties(db sqlx.Ext, p schema.AudioProperties) error {
+	if err := ValidateAudioProperties(p); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO audio_properties (audio_hash, sample_rate, channels, bits_per_sample, samples, encoder_delay, encoder_padding, created)
+		VALUES (:audio_hash, :sample_rate, :channels, :bits_per_sample, :samples, :encoder_delay, :encoder_padding, :created)
+		ON CONFLICT (audio_hash) DO UPDATE SET sample_rate = excluded.sample_rate, channels = excluded.channels, bits_per_sample = excluded.bits_per_sample,
+			samples = excluded.samples, encoder_delay = excluded.encoder_delay, encoder_padding = excluded.encoder_padding, created = excluded.created`, p)
+	return err
+}
+
+// GetAudioProperties returns the properties of the audio data with the given
+// hash, or sql.ErrNoRows if they have not been read yet.
+func GetAudioProperties(db sqlx.Queryer, audioHash []byte) (schema.AudioProperties, error) {
+	var p schema.AudioProperties
+	err := sqlx.Get(db, &p, `SELECT * FROM audio_properties WHERE audio_hash = ?`, audioHash)
+	return p, err
+}
+
+func ValidateAudioProperties(p schema.AudioProperties) error {
+	if len(p.AudioHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: audio hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidAudioProperties, ErrInvalidArgumentValue, schema.HASH_SIZE, len(p.AudioHash))
+	}
+	if p.SampleRate <= 0 || p.Channels <= 0 {
+		return fmt.Errorf("%w: %w: sample rate and channels must be positive", ErrInvalidAudioProperties, ErrInvalidArgumentValue)
+	}
+	if p.BitsPerSample < 0 || p.Samples < 0 {
+		return fmt.Errorf("%w: %w: sample size and samples must not be negative", ErrInvalidAudioProperties, ErrInvalidArgumentValue)
+	}
+	if (p.EncoderDelay == nil) != (p.EncoderPadding == nil) {
+		return fmt.Errorf("%w: %w: encoder delay and padding must be given together", ErrInvalidAudioProperties, ErrInvalidArgumentValue)
+	}
+	if p.EncoderDelay != nil && (*p.EncoderDelay < 0 || *p.EncoderPadding < 0) {
+		return fmt.Errorf("%w: %w: encoder delay and padding must not be negative", ErrInvalidAudioProperties, ErrInvalidArgumentValue)
+	}
+	if p.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidAudioProperties, ErrMissingArgumentValue)
+	}
+	return nil
+}
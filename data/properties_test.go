@@ -0,0 +1,64 @@
+package data_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+func TestSaveAudioProperties(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := data.GetAudioProperties(db, validAudioHash); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected error %v, but got %v", sql.ErrNoRows, err)
+	}
+
+	p := schema.AudioProperties{AudioHash: validAudioHash, SampleRate: 44100, Channels: 2, Samples: 44100 * 3, Created: time.Now()}
+	if err := data.SaveAudioProperties(db, p); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	delay, padding := 576, 1200
+	p.EncoderDelay, p.EncoderPadding = &delay, &padding
+	if err := data.SaveAudioProperties(db, p); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	result, err := data.GetAudioProperties(db, validAudioHash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if result.EncoderDelay == nil || *result.EncoderDelay != 576 || *result.EncoderPadding != 1200 || result.Duration() != 3 {
+		t.Errorf("expected the properties to be replaced, but got %+v", result)
+	}
+}
+
+func TestValidateAudioProperties(t *testing.T) {
+	valid := schema.AudioProperties{AudioHash: validAudioHash, SampleRate: 44100, Channels: 2, Created: time.Now()}
+	negative := -1
+	tests := []struct {
+		title  string
+		modify func(p *schema.AudioProperties)
+		err    error
+	}{
+		{title: "AudioHash", modify: func(p *schema.AudioProperties) { p.AudioHash = []byte("short") }, err: data.ErrInvalidArgumentValue},
+		{title: "SampleRate", modify: func(p *schema.AudioProperties) { p.SampleRate = 0 }, err: data.ErrInvalidArgumentValue},
+		{title: "Samples", modify: func(p *schema.AudioProperties) { p.Samples = -1 }, err: data.ErrInvalidArgumentValue},
+		{title: "EncoderPadding", modify: func(p *schema.AudioProperties) { p.EncoderDelay = new(int) }, err: data.ErrInvalidArgumentValue},
+		{title: "EncoderDelay", modify: func(p *schema.AudioProperties) { p.EncoderDelay, p.EncoderPadding = &negative, new(int) }, err: data.ErrInvalidArgumentValue},
+		{title: "Created", modify: func(p *schema.AudioProperties) { p.Created = time.Time{} }, err: data.ErrMissingArgumentValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			p := valid
+			tt.modify(&p)
+			err := data.ValidateAudioProperties(p)
+			if !errors.Is(err, data.ErrInvalidAudioProperties) || !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, but got %v", tt.err, err)
+			}
+		})
+	}
+}
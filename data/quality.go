@@ -0,0 +1,51 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidQuality = errors.New("invalid quality")
+
+// SaveQuality stores q, replacing an existing analysis of the same audio data.
+func SaveQuality(db sqlx.Ext, q schema.Quality) error {
+	if err := ValidateQuality(q); err != nil {
+		return err
+	}
+	_, err := sqlx.NamedExec(db, `INSERT INTO quality (audio_hash, sample_rate, bits, used_bits, cutoff, shelf, created)
+		VALUES (:audio_hash, :sample_rate, :bits, :used_bits, :cutoff, :shelf, :created)
+		ON CONFLICT (audio_hash) DO UPDATE SET sample_rate = excluded.sample_rate, bits = excluded.bits, used_bits = excluded.used_bits,
+			cutoff = excluded.cutoff, shelf = excluded.shelf, created = excluded.created`, q)
+	return err
+}
+
+// GetQuality returns the analysis of the audio data with the given hash, or
+// sql.ErrNoRows if it has not been analysed yet.
+func GetQuality(db sqlx.Queryer, audioHash []byte) (schema.Quality, error) {
+	var q schema.Quality
+	err := sqlx.Get(db, &q, `SELECT * FROM quality WHERE audio_hash = ?`, audioHash)
+	return q, err
+}
+
+func ValidateQuality(q schema.Quality) error {
+	if len(q.AudioHash) != schema.HASH_SIZE {
+		return fmt.Errorf("%w: %w: audio hash must consist of exactly %d bytes, but is %d bytes", ErrInvalidQuality, ErrInvalidArgumentValue, schema.HASH_SIZE, len(q.AudioHash))
+	}
+	if q.SampleRate <= 0 {
+		return fmt.Errorf("%w: %w: sample rate must be positive, but is %d", ErrInvalidQuality, ErrInvalidArgumentValue, q.SampleRate)
+	}
+	if q.Bits < 0 || q.UsedBits < 0 || q.UsedBits > q.Bits {
+		return fmt.Errorf("%w: %w: %d used bits of %d bits", ErrInvalidQuality, ErrInvalidArgumentValue, q.UsedBits, q.Bits)
+	}
+	if q.Cutoff < 0 || q.Cutoff > float64(q.SampleRate)/2 {
+		return fmt.Errorf("%w: %w: cutoff must be between 0 and half the sample rate, but is %v Hz", ErrInvalidQuality, ErrInvalidArgumentValue, q.Cutoff)
+	}
+	if q.Created.IsZero() {
+		return fmt.Errorf("%w: %w: created time must not be zero", ErrInvalidQuality, ErrMissingArgumentValue)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package data_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+func TestSaveQuality(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := data.GetQuality(db, validAudioHash); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected error %v, but got %v", sql.ErrNoRows, err)
+	}
+
+	q := schema.Quality{AudioHash: validAudioHash, SampleRate: 44100, Bits: 16, UsedBits: 16, Created: time.Now()}
+	if err := data.SaveQuality(db, q); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	q.Cutoff, q.Shelf = 16000, 60
+	if err := data.SaveQuality(db, q); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	result, err := data.GetQuality(db, validAudioHash)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if result.Bits != 16 || result.Cutoff != 16000 || result.Shelf != 60 {
+		t.Errorf("expected the analysis to be replaced, but got %+v", result)
+	}
+}
+
+func TestValidateQuality(t *testing.T) {
+	valid := schema.Quality{AudioHash: validAudioHash, SampleRate: 44100, Created: time.Now()}
+	tests := []struct {
+		title  string
+		modify func(q *schema.Quality)
+		err    error
+	}{
+		{title: "AudioHash", modify: func(q *schema.Quality) { q.AudioHash = []byte("short") }, err: data.ErrInvalidArgumentValue},
+		{title: "SampleRate", modify: func(q *schema.Quality) { q.SampleRate = 0 }, err: data.ErrInvalidArgumentValue},
+		{title: "UsedBits", modify: func(q *schema.Quality) { q.Bits, q.UsedBits = 16, 24 }, err: data.ErrInvalidArgumentValue},
+		{title: "Cutoff", modify: func(q *schema.Quality) { q.Cutoff = 30000 }, err: data.ErrInvalidArgumentValue},
+		{title: "Created", modify: func(q *schema.Quality) { q.Created = time.Time{} }, err: data.ErrMissingArgumentValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			q := valid
+			tt.modify(&q)
+			err := data.ValidateQuality(q)
+			if !errors.Is(err, data.ErrInvalidQuality) || !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, but got %v", tt.err, err)
+			}
+		})
+	}
+}
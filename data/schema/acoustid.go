@@ -0,0 +1,11 @@
+package schema
+
+import "time"
+
+// AcoustIDResponse is a cached response of the AcoustID lookup web service.
+type AcoustIDResponse struct {
+	Fingerprint string // as sent with the lookup
+	Duration    int    // in whole seconds, as sent with the lookup
+	Response    string // JSON
+	Created     time.Time
+}
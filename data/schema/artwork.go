@@ -0,0 +1,27 @@
+package schema
+
+import "time"
+
+// Artwork is an image, stored once no matter how many files embed it.
+type Artwork struct {
+	Hash    []byte // (schema.HASH_SIZE bytes) of Data
+	MIME    string `db:"mime"`
+	Width   int    // in pixels, 0 if the image can not be decoded
+	Height  int
+	Data    []byte
+	Created time.Time
+}
+
+// FileArtwork links a picture embedded in a file to its artwork.
+type FileArtwork struct {
+	FileHash    []byte `db:"file_hash"` // content hash of the file
+	PictureType int    `db:"picture_type"`
+	ArtworkHash []byte `db:"artwork_hash"`
+}
+
+// FolderArtwork is an image file next to music files, i.e. cover.jpg.
+type FolderArtwork struct {
+	Path        string
+	ArtworkHash []byte `db:"artwork_hash"`
+	Mod         time.Time
+}
@@ -0,0 +1,17 @@
+package schema
+
+// CueTrack is a track of a cue sheet, a part of a single audio file. Offsets
+// are in CD frames of 1/75 seconds from the start of the audio file.
+type CueTrack struct {
+	CuePath        string `db:"cue_path"`
+	Track          int
+	Path           string // of the audio file
+	Title          string
+	Performer      string // of the track, AlbumPerformer if the cue sheet has none
+	Album          string
+	AlbumPerformer string `db:"album_performer"`
+	Date           string
+	Genre          string
+	Start          int64  `db:"start_frame"`
+	End            *int64 `db:"end_frame"` // nil if the track lasts until the end of the file
+}
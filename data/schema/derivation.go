@@ -0,0 +1,16 @@
+package schema
+
+import "time"
+
+// Derivation links a file created from the audio of another file, i.e. by
+// converting it to another format, to that source file. Paths and hashes are
+// those at the time of the conversion.
+type Derivation struct {
+	Path       string
+	Hash       []byte // (schema.HASH_SIZE bytes)
+	SourcePath string `db:"source_path"`
+	SourceHash []byte `db:"source_hash"` // (schema.HASH_SIZE bytes)
+	Format     string // of the file at Path, i.e. "flac"
+	Settings   string // of the encoder, i.e. "level 5", empty if there are none
+	Created    time.Time
+}
@@ -4,10 +4,25 @@ import "time"
 
 const HASH_SIZE = 64
 
+// Health of a file after verifying it
+const (
+	HEALTH_OK          = "ok"
+	HEALTH_CORRUPT     = "corrupt"
+	HEALTH_UNSUPPORTED = "unsupported" // the format can not be verified
+)
+
 type File struct {
 	Path      string
 	Hash      []byte // (schema.HASH_SIZE bytes) must be unsized for storage driver compatibility
 	MediaType string `db:"media_type"`
 	Size      uint
 	Mod       time.Time
+	AudioHash []byte `db:"audio_hash"` // hash of the audio data without tags, nil until it is calculated
+	// MusicBrainz recording and track of the file, nil until identified
+	RecordingID *string `db:"recording_id"`
+	TrackID     *string `db:"track_id"`
+	// Result of the last integrity check, nil until verified
+	Health   *string
+	Problems string // found by the last integrity check, one per line
+	Verified *time.Time
 }
@@ -0,0 +1,12 @@
+package schema
+
+import "time"
+
+// Fingerprint is the acoustic fingerprint of the audio data with the given
+// hash, as calculated by the chromaprint package.
+type Fingerprint struct {
+	AudioHash   []byte  `db:"audio_hash"` // (schema.HASH_SIZE bytes)
+	Duration    float64 // in seconds
+	Fingerprint string  // compressed and base64 encoded, like fpcalc prints it
+	Created     time.Time
+}
@@ -0,0 +1,18 @@
+package schema
+
+import "time"
+
+// Levels are the level statistics of the audio data with the given hash, as
+// calculated by the levels package.
+type Levels struct {
+	AudioHash       []byte  `db:"audio_hash"` // (schema.HASH_SIZE bytes)
+	Duration        float64 // in seconds, like all times
+	LeadingSilence  float64 `db:"leading_silence"`
+	TrailingSilence float64 `db:"trailing_silence"`
+	LongestGap      float64 `db:"longest_gap"` // longest silence between audio
+	GapStart        float64 `db:"gap_start"`
+	Clipped         int64   // number of clipped samples
+	DCOffset        float64 `db:"dc_offset"` // linear, 1 for full scale
+	Peak            float64
+	Created         time.Time
+}
@@ -0,0 +1,26 @@
+package schema
+
+import "time"
+
+// Loudness is the loudness of the audio data with the given hash, as measured
+// by the loudness package.
+type Loudness struct {
+	AudioHash  []byte   `db:"audio_hash"` // (schema.HASH_SIZE bytes)
+	Integrated *float64 // in LUFS, nil for silence
+	Range      float64  `db:"loudness_range"` // in LU
+	SamplePeak float64  `db:"sample_peak"`    // linear, 1 for full scale
+	TruePeak   float64  `db:"true_peak"`
+	Blocks     []byte   // little endian float32 mean squares of the gating blocks
+	ShortTerm  []byte   `db:"short_term"` // same for the short-term blocks
+	Created    time.Time
+}
+
+// AlbumLoudness is the loudness of all known files in a directory.
+type AlbumLoudness struct {
+	Path       string   // of the directory
+	Integrated *float64 // in LUFS, nil for silence
+	Range      float64  `db:"loudness_range"` // in LU
+	SamplePeak float64  `db:"sample_peak"`
+	TruePeak   float64  `db:"true_peak"`
+	Created    time.Time
+}
@@ -0,0 +1,51 @@
+package schema
+
+import "time"
+
+// MusicBrainz entities, identified by their MBIDs. Artist credits are stored
+// as displayed, i.e. "A feat. B", with ArtistID referring to the first
+// credited artist.
+
+type Artist struct {
+	ID             string
+	Name           string
+	SortName       string `db:"sort_name"`
+	Disambiguation string
+	Updated        time.Time
+}
+
+type Release struct {
+	ID             string
+	ReleaseGroupID string `db:"release_group_id"`
+	Title          string
+	ArtistID       string `db:"artist_id"`
+	ArtistCredit   string `db:"artist_credit"`
+	Date           string // "YYYY", "YYYY-MM" or "YYYY-MM-DD", empty if unknown
+	Country        string
+	Status         string
+	Barcode        string
+	TrackCount     int `db:"track_count"`
+	Updated        time.Time
+}
+
+type Recording struct {
+	ID           string
+	Title        string
+	ArtistID     string `db:"artist_id"`
+	ArtistCredit string `db:"artist_credit"`
+	Length       int    // in milliseconds, 0 if unknown
+	Updated      time.Time
+}
+
+// Track is a recording as it appears on a medium of a release.
+type Track struct {
+	ID           string
+	ReleaseID    string `db:"release_id"`
+	RecordingID  string `db:"recording_id"`
+	Disc         int    // position of the medium, starting at 1
+	Position     int    // on the medium, starting at 1
+	Number       string // as printed, i.e. "A1"
+	Title        string
+	ArtistCredit string `db:"artist_credit"`
+	Length       int    // in milliseconds, 0 if unknown
+}
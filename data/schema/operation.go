@@ -0,0 +1,35 @@
+package schema
+
+import "time"
+
+type OperationKind string
+
+const (
+	OP_MOVE      OperationKind = "move"
+	OP_RENAME    OperationKind = "rename"
+	OP_TAG_WRITE OperationKind = "tag_write"
+	OP_DELETE    OperationKind = "delete"
+	OP_COPY      OperationKind = "copy"      // also used for reflinks
+	OP_LINK      OperationKind = "link"      // hard or symbolic link
+	OP_CONVERT   OperationKind = "convert"   // file created from the audio of another one, in a different format
+	OP_WRITE     OperationKind = "write"     // file created with new content, i.e. an image, before and after path are the same
+	OP_OVERWRITE OperationKind = "overwrite" // content of a file outside the files table replaced, i.e. of an image
+	OP_RESTORE   OperationKind = "restore"   // reversal of a delete or tag write, the file is restored from its backup
+)
+
+// Operation is a single entry of the append-only operations journal. Reversals
+// are recorded as new operations referencing the reverted one, existing
+// entries are never changed.
+type Operation struct {
+	ID         int64
+	Batch      int64
+	Kind       OperationKind
+	BeforePath string `db:"before_path"`
+	AfterPath  string `db:"after_path"`
+	BeforeHash []byte `db:"before_hash"` // nil if the file did not exist before the operation
+	AfterHash  []byte `db:"after_hash"`  // nil if the file does not exist after the operation
+	Backup     string // copy of the previous content for operations that destroy it, empty otherwise
+	MediaType  string `db:"media_type"` // of a deleted file which was in the files table, empty otherwise
+	Reverts    *int64 // ID of the operation this one reverses
+	Created    time.Time
+}
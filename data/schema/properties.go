@@ -0,0 +1,22 @@
+package schema
+
+import "time"
+
+// AudioProperties are the sample-exact properties of the audio data with the
+// given hash. Samples are counted per channel and exclude the encoder delay
+// and padding, which are nil for formats which do not record them.
+type AudioProperties struct {
+	AudioHash      []byte `db:"audio_hash"` // (schema.HASH_SIZE bytes)
+	SampleRate     int    `db:"sample_rate"`
+	Channels       int
+	BitsPerSample  int `db:"bits_per_sample"` // 0 for lossy formats
+	Samples        int64
+	EncoderDelay   *int `db:"encoder_delay"`
+	EncoderPadding *int `db:"encoder_padding"`
+	Created        time.Time
+}
+
+// Duration returns the exact duration in seconds.
+func (p AudioProperties) Duration() float64 {
+	return float64(p.Samples) / float64(p.SampleRate)
+}
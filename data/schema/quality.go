@@ -0,0 +1,15 @@
+package schema
+
+import "time"
+
+// Quality is the spectral analysis of the audio data with the given hash, as
+// done by the quality package.
+type Quality struct {
+	AudioHash  []byte  `db:"audio_hash"` // (schema.HASH_SIZE bytes)
+	SampleRate int     `db:"sample_rate"`
+	Bits       int     // sample size, 0 for lossy formats
+	UsedBits   int     `db:"used_bits"` // bits which are not always zero, 0 if unknown
+	Cutoff     float64 // in Hz, 0 if there is no lowpass shelf
+	Shelf      float64 // in dB
+	Created    time.Time
+}
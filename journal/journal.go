@@ -0,0 +1,467 @@
+package journal
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Directory that keeps copies of deleted or overwritten files, so these
+// operations can be reversed. Lives next to the database, Prune removes the
+// copies which are no longer needed.
+var BackupDir = filepath.Join("data", "backups")
+
+var (
+	ErrDestinationExists = errors.New("destination already exists")
+	ErrHashMismatch      = errors.New("file content changed since the operation was recorded")
+	ErrAlreadyReverted   = errors.New("operation was already undone")
+	ErrNotUndoable       = errors.New("operation can not be undone")
+)
+
+// Journal performs filesystem mutations and records each of them in the
+// operations table. All operations performed through one Journal share a
+// batch, which can be undone as a whole.
+type Journal struct {
+	db    *sqlx.DB
+	batch int64
+
+	// Set while running a Transaction
+	tx        *sqlx.Tx
+	rollbacks []func()
+}
+
+func New(db *sqlx.DB) (*Journal, error) {
+	batch, err := data.NextBatch(db)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{db: db, batch: batch}, nil
+}
+
+func (j *Journal) Batch() int64 {
+	return j.batch
+}
+
+// Move moves the file at src to dst, creating missing parent directories of dst.
+func (j *Journal) Move(src string, dst string) (schema.Operation, error) {
+	return j.relocate(schema.OP_MOVE, src, dst, nil)
+}
+
+// Rename renames the file at src to dst. It differs from Move only in how the
+// operation is recorded.
+func (j *Journal) Rename(src string, dst string) (schema.Operation, error) {
+	return j.relocate(schema.OP_RENAME, src, dst, nil)
+}
+
+// Delete removes the file at path. Its content is kept in BackupDir, so the
+// deletion can be undone.
+func (j *Journal) Delete(path string) (schema.Operation, error) {
+	return j.delete(path, nil)
+}
+
+func (j *Journal) delete(path string, reverts *int64) (schema.Operation, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	hash, err := data.HashFile(path)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	// Known files are re-added to the files table when the deletion is undone
+	var q sqlx.Queryer = j.db
+	if j.tx != nil {
+		q = j.tx
+	}
+	known, err := data.GetFile(q, path)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return schema.Operation{}, err
+	}
+	backup, err := reserveBackup(hash)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	if err := moveFile(path, backup); err != nil {
+		os.Remove(backup)
+		return schema.Operation{}, err
+	}
+
+	op := j.newOperation(schema.OP_DELETE, path, path, hash, nil)
+	op.Backup = backup
+	op.MediaType = known.MediaType
+	op.Reverts = reverts
+	return j.record(op, func(tx *sqlx.Tx) error {
+		return data.DeleteFile(tx, path)
+	}, func() {
+		moveFile(backup, path)
+	})
+}
+
+// WriteTags calls write to change the content of the file at path in place,
+// i.e. to update its tags. A copy of the previous content is kept in
+// BackupDir, so the change can be undone.
+func (j *Journal) WriteTags(path string, write func(path string) error) (schema.Operation, error) {
+	return j.replace(schema.OP_TAG_WRITE, path, write)
+}
+
+// OverwriteFile replaces the content of the existing file at path, which is
+// not in the files table, i.e. an image. A copy of the previous content is
+// kept in BackupDir, so the change can be undone.
+func (j *Journal) OverwriteFile(path string, content []byte) (schema.Operation, error) {
+	return j.replace(schema.OP_OVERWRITE, path, func(path string) error {
+		return os.WriteFile(path, content, 0o644)
+	})
+}
+
+// replace records the change of the content of the file at path by write,
+// updating the files table for tag writes.
+func (j *Journal) replace(kind schema.OperationKind, path string, write func(path string) error) (schema.Operation, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	hash, err := data.HashFile(path)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	backup, err := reserveBackup(hash)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	if err := copyFile(path, backup); err != nil {
+		os.Remove(backup)
+		return schema.Operation{}, err
+	}
+
+	if err := write(path); err != nil {
+		// The writer may have left the file half written
+		copyFile(backup, path)
+		os.Remove(backup)
+		return schema.Operation{}, err
+	}
+
+	newHash, err := data.HashFile(path)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	if bytes.Equal(hash, newHash) {
+		// Nothing changed, nothing to record
+		os.Remove(backup)
+		return schema.Operation{}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+
+	op := j.newOperation(kind, path, path, hash, newHash)
+	op.Backup = backup
+	return j.record(op, func(tx *sqlx.Tx) error {
+		if kind != schema.OP_TAG_WRITE {
+			return nil
+		}
+		return data.UpdateFileContent(tx, path, newHash, uint(info.Size()), info.ModTime())
+	}, func() {
+		copyFile(backup, path)
+		os.Remove(backup)
+	})
+}
+
+// WriteFile creates the file at path with content, creating missing parent
+// directories. Reversing it deletes the file.
+func (j *Journal) WriteFile(path string, content []byte) (schema.Operation, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return schema.Operation{}, fmt.Errorf("%w: %s", ErrDestinationExists, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return schema.Operation{}, err
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		os.Remove(path)
+		return schema.Operation{}, err
+	}
+	hash, err := data.HashFile(path)
+	if err != nil {
+		os.Remove(path)
+		return schema.Operation{}, err
+	}
+
+	op := j.newOperation(schema.OP_WRITE, path, path, nil, hash)
+	return j.record(op, func(tx *sqlx.Tx) error {
+		return nil
+	}, func() {
+		os.Remove(path)
+	})
+}
+
+// Copy copies the file at src to dst, creating missing parent directories of
+// dst.
+func (j *Journal) Copy(src string, dst string) (schema.Operation, error) {
+	return j.create(schema.OP_COPY, src, dst, copyFile)
+}
+
+// Reflink creates dst as a copy-on-write clone of src. This is only supported
+// on filesystems with reflink support like Btrfs or XFS.
+func (j *Journal) Reflink(src string, dst string) (schema.Operation, error) {
+	return j.create(schema.OP_COPY, src, dst, reflink)
+}
+
+// Hardlink creates dst as a hard link to src.
+func (j *Journal) Hardlink(src string, dst string) (schema.Operation, error) {
+	return j.create(schema.OP_LINK, src, dst, os.Link)
+}
+
+// Symlink creates dst as a symbolic link pointing to the absolute path of src.
+func (j *Journal) Symlink(src string, dst string) (schema.Operation, error) {
+	return j.create(schema.OP_LINK, src, dst, os.Symlink)
+}
+
+// Convert calls convert to create dst from the file at src, i.e. by encoding
+// its audio in another format, creating missing parent directories of dst.
+// Reversing it deletes dst.
+func (j *Journal) Convert(src string, dst string, convert func(src string, dst string) error) (schema.Operation, error) {
+	return j.create(schema.OP_CONVERT, src, dst, convert)
+}
+
+// create records the creation of dst from src by fn. Reversing it deletes dst.
+func (j *Journal) create(kind schema.OperationKind, src string, dst string, fn func(src string, dst string) error) (schema.Operation, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return schema.Operation{}, fmt.Errorf("%w: %s", ErrDestinationExists, dst)
+	}
+	if _, err := os.Stat(src); err != nil {
+		return schema.Operation{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return schema.Operation{}, err
+	}
+	if err := fn(src, dst); err != nil {
+		os.Remove(dst)
+		return schema.Operation{}, err
+	}
+	// Only copies and links have the content of src
+	hash, err := data.HashFile(dst)
+	if err != nil {
+		os.Remove(dst)
+		return schema.Operation{}, err
+	}
+
+	op := j.newOperation(kind, src, dst, nil, hash)
+	return j.record(op, func(tx *sqlx.Tx) error {
+		return nil
+	}, func() {
+		os.Remove(dst)
+	})
+}
+
+func (j *Journal) relocate(kind schema.OperationKind, src string, dst string, reverts *int64) (schema.Operation, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	dst, err = filepath.Abs(dst)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return schema.Operation{}, fmt.Errorf("%w: %s", ErrDestinationExists, dst)
+	}
+	hash, err := data.HashFile(src)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return schema.Operation{}, err
+	}
+	if err := moveFile(src, dst); err != nil {
+		return schema.Operation{}, err
+	}
+
+	op := j.newOperation(kind, src, dst, hash, hash)
+	op.Reverts = reverts
+	return j.record(op, func(tx *sqlx.Tx) error {
+		return data.UpdateFilePath(tx, src, dst)
+	}, func() {
+		moveFile(dst, src)
+	})
+}
+
+func (j *Journal) newOperation(kind schema.OperationKind, before string, after string, beforeHash []byte, afterHash []byte) schema.Operation {
+	return schema.Operation{
+		Batch:      j.batch,
+		Kind:       kind,
+		BeforePath: before,
+		AfterPath:  after,
+		BeforeHash: beforeHash,
+		AfterHash:  afterHash,
+		Created:    time.Now(),
+	}
+}
+
+// record appends op to the journal and applies the matching changes to the
+// files table in the same transaction. rollback reverts the filesystem change
+// of op, it is called if recording fails or, within Transaction, if the
+// transaction fails later on.
+func (j *Journal) record(op schema.Operation, updateFiles func(tx *sqlx.Tx) error, rollback func()) (schema.Operation, error) {
+	tx := j.tx
+	if tx == nil {
+		var err error
+		tx, err = j.db.Beginx()
+		if err != nil {
+			rollback()
+			return op, err
+		}
+		defer tx.Rollback()
+	}
+
+	id, err := func() (int64, error) {
+		if err := updateFiles(tx); err != nil {
+			return 0, err
+		}
+		return data.SaveOperation(tx, op)
+	}()
+	if err != nil {
+		rollback()
+		return op, err
+	}
+	op.ID = id
+
+	if j.tx != nil {
+		j.rollbacks = append(j.rollbacks, rollback)
+		return op, nil
+	}
+	if err := tx.Commit(); err != nil {
+		rollback()
+		return op, err
+	}
+	return op, nil
+}
+
+// Transaction calls fn and records all operations fn performs through j in a
+// single database transaction. If fn returns an error or the transaction can
+// not be committed, nothing is recorded and all filesystem changes of these
+// operations are reverted.
+func (j *Journal) Transaction(fn func() error) error {
+	if j.tx != nil {
+		return fn()
+	}
+	tx, err := j.db.Beginx()
+	if err != nil {
+		return err
+	}
+	j.tx, j.rollbacks = tx, nil
+	defer func() {
+		j.tx, j.rollbacks = nil, nil
+	}()
+
+	err = fn()
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		for i := len(j.rollbacks) - 1; i >= 0; i-- {
+			j.rollbacks[i]()
+		}
+		return err
+	}
+	return nil
+}
+
+// Update calls fn to change the database along with the operations of the
+// running Transaction, so these changes are committed or rolled back together
+// with them. Outside of a Transaction fn runs in a transaction of its own.
+func (j *Journal) Update(fn func(tx *sqlx.Tx) error) error {
+	if j.tx != nil {
+		return fn(j.tx)
+	}
+	tx, err := j.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // fails after the commit, which is fine
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// reserveBackup creates an empty, uniquely named file in BackupDir.
+func reserveBackup(hash []byte) (string, error) {
+	if err := os.MkdirAll(BackupDir, 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(BackupDir, fmt.Sprintf("%x-*", hash[:16]))
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return filepath.Abs(f.Name())
+}
+
+// moveFile renames src to dst, falling back to copy and delete if both are on
+// different filesystems. Other errors, like missing permissions, are returned.
+func moveFile(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(src, dst); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// copyFile copies the content and permissions of src to dst, overwriting dst
+// if it exists.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, time.Now(), info.ModTime())
+}
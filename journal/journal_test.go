@@ -0,0 +1,294 @@
+package journal_test
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+)
+
+func setupTestDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Connect("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite database: %v", err)
+	}
+	db.SetMaxOpenConns(1) // every connection would get its own in-memory database
+	if err := data.Migrate(db); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	return db
+}
+
+func setupTestFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	return path
+}
+
+func setupJournal(t *testing.T) (*sqlx.DB, *journal.Journal, string) {
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+	db := setupTestDB(t)
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("failed to create journal: %v", err)
+	}
+	return db, j, dir
+}
+
+func TestMoveAndUndo(t *testing.T) {
+	db, j, dir := setupJournal(t)
+	defer db.Close()
+
+	src := setupTestFile(t, dir, "a.mp3", "some audio")
+	dst := filepath.Join(dir, "sub", "b.mp3")
+
+	op, err := j.Move(src, dst)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("expected moved file to exist, but got %v", err)
+	}
+
+	if _, err := journal.Undo(db, []schema.Operation{op}); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("expected file to be back at its original path, but got %v", err)
+	}
+
+	_, err = journal.Undo(db, []schema.Operation{op})
+	if !errors.Is(err, journal.ErrAlreadyReverted) {
+		t.Errorf("expected error %v, but got %v", journal.ErrAlreadyReverted, err)
+	}
+}
+
+func TestUndoRefusesChangedFile(t *testing.T) {
+	db, j, dir := setupJournal(t)
+	defer db.Close()
+
+	src := setupTestFile(t, dir, "a.mp3", "some audio")
+	dst := filepath.Join(dir, "b.mp3")
+	if _, err := j.Rename(src, dst); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	setupTestFile(t, dir, "b.mp3", "edited audio")
+
+	_, err := journal.UndoBatch(db, j.Batch())
+	if !errors.Is(err, journal.ErrHashMismatch) {
+		t.Errorf("expected error %v, but got %v", journal.ErrHashMismatch, err)
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("expected file to stay in place, but got %v", err)
+	}
+}
+
+func TestDeleteAndTagWriteUndo(t *testing.T) {
+	db, j, dir := setupJournal(t)
+	defer db.Close()
+
+	deleted := setupTestFile(t, dir, "deleted.flac", "lossless audio")
+	untracked := setupTestFile(t, dir, "untracked.flac", "other audio")
+	tagged := setupTestFile(t, dir, "tagged.mp3", "untagged audio")
+	hash, err := data.HashFile(deleted)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	if err := data.SaveFile(db, schema.File{Path: deleted, Hash: hash, MediaType: "flac", Size: 14, Mod: time.Now()}); err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+
+	for _, path := range []string{deleted, untracked} {
+		if _, err := j.Delete(path); err != nil {
+			t.Fatalf("expected no error, but got %v", err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected deleted file to be gone, but got %v", err)
+		}
+	}
+	if _, err := data.GetFile(db, deleted); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected deleted file to be removed from the files table, but got %v", err)
+	}
+	_, err = j.WriteTags(tagged, func(path string) error {
+		return os.WriteFile(path, []byte("tagged audio"), 0o644)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	batch, err := data.GetLastUndoableBatch(db)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	reversals, err := journal.UndoBatch(db, batch)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(reversals) != 3 {
+		t.Errorf("expected 3 reversals, but got %d", len(reversals))
+	}
+
+	content, err := os.ReadFile(deleted)
+	if err != nil || string(content) != "lossless audio" {
+		t.Errorf("expected deleted file to be restored, but got %q (%v)", content, err)
+	}
+	if file, err := data.GetFile(db, deleted); err != nil || string(file.Hash) != string(hash) || file.MediaType != "flac" {
+		t.Errorf("expected deleted file to be back in the files table, but got %+v (%v)", file, err)
+	}
+	if _, err := data.GetFile(db, untracked); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected untracked file to stay out of the files table, but got %v", err)
+	}
+	content, err = os.ReadFile(tagged)
+	if err != nil || string(content) != "untagged audio" {
+		t.Errorf("expected tagged file to be restored, but got %q (%v)", content, err)
+	}
+
+	if _, err := data.GetLastUndoableBatch(db); !errors.Is(err, data.ErrOperationNotFound) {
+		t.Errorf("expected error %v, but got %v", data.ErrOperationNotFound, err)
+	}
+}
+
+func TestWriteFileAndUndo(t *testing.T) {
+	db, j, dir := setupJournal(t)
+	defer db.Close()
+
+	path := filepath.Join(dir, "album", "cover.jpg")
+	op, err := j.WriteFile(path, []byte("image"))
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if op.Kind != schema.OP_WRITE {
+		t.Errorf("expected kind %s, but got %s", schema.OP_WRITE, op.Kind)
+	}
+	if _, err := j.WriteFile(path, []byte("other image")); !errors.Is(err, journal.ErrDestinationExists) {
+		t.Errorf("expected error %v, but got %v", journal.ErrDestinationExists, err)
+	}
+
+	if _, err := journal.UndoBatch(db, j.Batch()); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected written file to be deleted, but got %v", err)
+	}
+}
+
+func TestOverwriteFileAndUndo(t *testing.T) {
+	db, j, dir := setupJournal(t)
+	defer db.Close()
+
+	path := setupTestFile(t, dir, "cover.jpg", "image")
+	op, err := j.OverwriteFile(path, []byte("larger image"))
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if op.Kind != schema.OP_OVERWRITE || op.Backup == "" {
+		t.Errorf("expected an overwrite with a backup, but got %+v", op)
+	}
+
+	if _, err := journal.UndoBatch(db, j.Batch()); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "image" {
+		t.Errorf("expected the previous content to be restored, but got %q (%v)", content, err)
+	}
+}
+
+func TestConvertAndUndo(t *testing.T) {
+	db, j, dir := setupJournal(t)
+	defer db.Close()
+
+	src := setupTestFile(t, dir, "song.wav", "wav audio")
+	dst := filepath.Join(dir, "flac", "song.flac")
+	op, err := j.Convert(src, dst, func(src string, dst string) error {
+		return os.WriteFile(dst, []byte("flac audio"), 0o644)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	hash, err := data.HashFile(dst)
+	if err != nil {
+		t.Fatalf("expected converted file, but got %v", err)
+	}
+	if op.Kind != schema.OP_CONVERT || op.BeforePath != src || string(op.AfterHash) != string(hash) {
+		t.Errorf("expected a conversion of %s with the hash of the converted file, but got %+v", src, op)
+	}
+
+	// A failed conversion leaves nothing behind
+	failed := filepath.Join(dir, "flac", "failed.flac")
+	if _, err := j.Convert(src, failed, func(src string, dst string) error {
+		os.WriteFile(dst, []byte("half"), 0o644)
+		return errors.New("encoder failed")
+	}); err == nil {
+		t.Errorf("expected the error of the conversion, but got nil")
+	}
+	if _, err := os.Stat(failed); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the partial file to be removed, but got %v", err)
+	}
+
+	if _, err := journal.UndoBatch(db, j.Batch()); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected converted file to be deleted, but got %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("expected source file to be kept, but got %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db, j, dir := setupJournal(t)
+	defer db.Close()
+
+	deleted, err := j.Delete(setupTestFile(t, dir, "deleted.flac", "lossless audio"))
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	tagged, err := j.WriteTags(setupTestFile(t, dir, "tagged.mp3", "untagged audio"), func(path string) error {
+		return os.WriteFile(path, []byte("tagged audio"), 0o644)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	// Left behind by interrupted operations
+	stale := setupTestFile(t, journal.BackupDir, "stale", "interrupted")
+	if err := os.Chtimes(stale, time.Now(), time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatalf("failed to change mod time: %v", err)
+	}
+	recent := setupTestFile(t, journal.BackupDir, "recent", "in progress")
+
+	pruned, err := journal.Prune(db, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(pruned) != 1 || filepath.Base(pruned[0]) != "stale" {
+		t.Errorf("expected only the stale backup to be pruned, but got %v", pruned)
+	}
+
+	if _, err := journal.Undo(db, []schema.Operation{deleted}); err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	pruned, err = journal.Prune(db, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+	if len(pruned) != 2 || pruned[0] != tagged.Backup || pruned[1] != recent {
+		t.Errorf("expected the backups of the tag write and the recent file to be pruned, but got %v", pruned)
+	}
+	if _, err := journal.Undo(db, []schema.Operation{tagged}); !errors.Is(err, journal.ErrNotUndoable) {
+		t.Errorf("expected error %v, but got %v", journal.ErrNotUndoable, err)
+	}
+}
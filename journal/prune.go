@@ -0,0 +1,72 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+)
+
+// Prune removes the backups in BackupDir which are no longer needed: those of
+// operations recorded before before or already reverted, and files no
+// operation refers to which were last modified before before, i.e. left
+// behind by an interrupted operation. Operations whose backup was pruned can
+// no longer be undone. It returns the paths of the removed backups.
+func Prune(db sqlx.Queryer, before time.Time) ([]string, error) {
+	ops, err := data.GetBackupOperations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []string
+	referenced := map[string]bool{}
+	for _, op := range ops {
+		referenced[op.Backup] = true
+		reverted, err := data.IsOperationReverted(db, op.ID)
+		if err != nil {
+			return pruned, err
+		}
+		if !reverted && !op.Created.Before(before) {
+			continue
+		}
+		// Restoring moves the backup back in place
+		if err := os.Remove(op.Backup); err == nil {
+			pruned = append(pruned, op.Backup)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return pruned, err
+		}
+	}
+
+	entries, err := os.ReadDir(BackupDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return pruned, nil
+	}
+	if err != nil {
+		return pruned, err
+	}
+	for _, entry := range entries {
+		path, err := filepath.Abs(filepath.Join(BackupDir, entry.Name()))
+		if err != nil {
+			return pruned, err
+		}
+		if referenced[path] || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return pruned, err
+		}
+		if !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, path)
+	}
+	return pruned, nil
+}
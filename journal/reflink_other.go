@@ -0,0 +1,12 @@
+//go:build !linux
+
+package journal
+
+import (
+	"errors"
+	"os"
+)
+
+func reflink(src string, dst string) error {
+	return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: errors.ErrUnsupported}
+}
@@ -0,0 +1,208 @@
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Undo reverses ops in reverse order and records the reversals as a new batch.
+// All operations are checked before anything is touched: if any of them can
+// not be reversed, i.e. because a file was changed since, nothing is undone.
+// If a reversal fails nonetheless, the ones already carried out are reverted.
+func Undo(db *sqlx.DB, ops []schema.Operation) ([]schema.Operation, error) {
+	sim := simulation{}
+	for i := len(ops) - 1; i >= 0; i-- {
+		if err := sim.checkUndoable(db, ops[i]); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", ops[i].ID, err)
+		}
+		sim.revert(ops[i])
+	}
+
+	j, err := New(db)
+	if err != nil {
+		return nil, err
+	}
+	var reversals []schema.Operation
+	err = j.Transaction(func() error {
+		for i := len(ops) - 1; i >= 0; i-- {
+			reversal, err := j.revert(ops[i])
+			if err != nil {
+				return fmt.Errorf("operation %d: %w", ops[i].ID, err)
+			}
+			reversals = append(reversals, reversal)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reversals, nil
+}
+
+// UndoBatch reverses all operations of batch which have not been reverted yet.
+func UndoBatch(db *sqlx.DB, batch int64) ([]schema.Operation, error) {
+	ops, err := data.GetBatchOperations(db, batch)
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]schema.Operation, 0, len(ops))
+	for _, op := range ops {
+		reverted, err := data.IsOperationReverted(db, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !reverted && op.Reverts == nil {
+			pending = append(pending, op)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, fmt.Errorf("batch %d: %w", batch, ErrAlreadyReverted)
+	}
+	return Undo(db, pending)
+}
+
+// simulation tracks the expected content of paths while checking a sequence
+// of reversals, as later operations of a batch may depend on the reversal of
+// earlier ones. A nil hash means the path will not exist.
+type simulation map[string][]byte
+
+func (sim simulation) checkUndoable(db sqlx.Queryer, op schema.Operation) error {
+	if op.Reverts != nil {
+		return fmt.Errorf("%w: it is itself the reversal of operation %d", ErrNotUndoable, *op.Reverts)
+	}
+	reverted, err := data.IsOperationReverted(db, op.ID)
+	if err != nil {
+		return err
+	}
+	if reverted {
+		return ErrAlreadyReverted
+	}
+
+	switch op.Kind {
+	case schema.OP_MOVE, schema.OP_RENAME:
+		if err := sim.checkHash(op.AfterPath, op.AfterHash); err != nil {
+			return err
+		}
+		return sim.checkFree(op.BeforePath)
+	case schema.OP_DELETE:
+		if err := sim.checkBackup(op); err != nil {
+			return err
+		}
+		return sim.checkFree(op.BeforePath)
+	case schema.OP_TAG_WRITE, schema.OP_OVERWRITE:
+		if err := sim.checkBackup(op); err != nil {
+			return err
+		}
+		return sim.checkHash(op.AfterPath, op.AfterHash)
+	case schema.OP_COPY, schema.OP_LINK, schema.OP_CONVERT, schema.OP_WRITE:
+		return sim.checkHash(op.AfterPath, op.AfterHash)
+	default:
+		return fmt.Errorf("%w: unsupported operation kind \"%s\"", ErrNotUndoable, op.Kind)
+	}
+}
+
+func (j *Journal) revert(op schema.Operation) (schema.Operation, error) {
+	switch op.Kind {
+	case schema.OP_MOVE, schema.OP_RENAME:
+		return j.relocate(op.Kind, op.AfterPath, op.BeforePath, &op.ID)
+	case schema.OP_DELETE, schema.OP_TAG_WRITE, schema.OP_OVERWRITE:
+		return j.restore(op)
+	case schema.OP_COPY, schema.OP_LINK, schema.OP_CONVERT, schema.OP_WRITE:
+		return j.delete(op.AfterPath, &op.ID)
+	default:
+		return schema.Operation{}, fmt.Errorf("%w: unsupported operation kind \"%s\"", ErrNotUndoable, op.Kind)
+	}
+}
+
+// restore puts the backup of a deleted or overwritten file back in place.
+// Deleted files which were in the files table are re-added with the content
+// hash recorded by the deletion.
+func (j *Journal) restore(op schema.Operation) (schema.Operation, error) {
+	// Empty directories may have been removed since, i.e. by sync
+	if err := os.MkdirAll(filepath.Dir(op.BeforePath), 0o755); err != nil {
+		return schema.Operation{}, err
+	}
+	if err := moveFile(op.Backup, op.BeforePath); err != nil {
+		return schema.Operation{}, err
+	}
+	info, err := os.Stat(op.BeforePath)
+	if err != nil {
+		return schema.Operation{}, err
+	}
+
+	reversal := j.newOperation(schema.OP_RESTORE, op.AfterPath, op.BeforePath, op.AfterHash, op.BeforeHash)
+	reversal.Reverts = &op.ID
+	return j.record(reversal, func(tx *sqlx.Tx) error {
+		switch {
+		case op.Kind == schema.OP_TAG_WRITE:
+			return data.UpdateFileContent(tx, op.BeforePath, op.BeforeHash, uint(info.Size()), info.ModTime())
+		case op.Kind == schema.OP_DELETE && op.MediaType != "":
+			return data.UpsertFile(tx, schema.File{Path: op.BeforePath, Hash: op.BeforeHash, MediaType: op.MediaType, Size: uint(info.Size()), Mod: info.ModTime()})
+		}
+		return nil
+	}, func() {
+		moveFile(op.BeforePath, op.Backup)
+	})
+}
+
+// revert records the effect of reverting op on the filesystem.
+func (sim simulation) revert(op schema.Operation) {
+	switch op.Kind {
+	case schema.OP_MOVE, schema.OP_RENAME:
+		sim[op.AfterPath] = nil
+		sim[op.BeforePath] = op.AfterHash
+	case schema.OP_DELETE:
+		sim[op.Backup] = nil
+		sim[op.BeforePath] = op.BeforeHash
+	case schema.OP_TAG_WRITE, schema.OP_OVERWRITE:
+		sim[op.Backup] = nil
+		sim[op.AfterPath] = op.BeforeHash
+	case schema.OP_COPY, schema.OP_LINK, schema.OP_CONVERT, schema.OP_WRITE:
+		sim[op.AfterPath] = nil
+	}
+}
+
+func (sim simulation) checkHash(path string, expected []byte) error {
+	hash, simulated := sim[path]
+	if !simulated {
+		var err error
+		if hash, err = data.HashFile(path); err != nil {
+			return err
+		}
+	} else if hash == nil {
+		return fmt.Errorf("%w: %s", os.ErrNotExist, path)
+	}
+	if !bytes.Equal(hash, expected) {
+		return fmt.Errorf("%w: %s", ErrHashMismatch, path)
+	}
+	return nil
+}
+
+// checkBackup checks that the backup of op still has the previous content.
+func (sim simulation) checkBackup(op schema.Operation) error {
+	if _, simulated := sim[op.Backup]; !simulated {
+		if _, err := os.Lstat(op.Backup); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: the backup %s was pruned", ErrNotUndoable, op.Backup)
+		}
+	}
+	return sim.checkHash(op.Backup, op.BeforeHash)
+}
+
+func (sim simulation) checkFree(path string) error {
+	if hash, simulated := sim[path]; simulated {
+		if hash == nil {
+			return nil
+		}
+	} else if _, err := os.Lstat(path); err != nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrDestinationExists, path)
+}
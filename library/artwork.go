@@ -0,0 +1,358 @@
+package library
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/artwork"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+)
+
+// File name of exported album art
+const COVER_FILE_NAME = "cover.jpg"
+
+// Smallest width and height of album art that is not reported as tiny
+const MIN_ARTWORK_SIZE = 500
+
+var ErrNoArtwork = errors.New("no artwork")
+
+// ArtworkSource is an image found embedded in a music file or as a folder
+// image next to it.
+type ArtworkSource struct {
+	Path        string // of the music file or folder image
+	Folder      bool   // set for folder images
+	PictureType int    // of embedded pictures, i.e. tags.PICTURE_FRONT_COVER
+	Artwork     schema.Artwork
+}
+
+// AlbumArtwork is the artwork of the music files in one directory.
+type AlbumArtwork struct {
+	Dir      string
+	Files    []schema.File
+	Embedded []ArtworkSource
+	Folder   []ArtworkSource
+}
+
+// isCover reports whether the embedded picture shows the album cover. Pictures
+// of type "other" are often used for covers as well.
+func (s ArtworkSource) isCover() bool {
+	return s.Folder || s.PictureType == tags.PICTURE_FRONT_COVER || s.PictureType == tags.PICTURE_OTHER
+}
+
+// Best returns the cover image with the most pixels, preferring folder images
+// on a tie. It returns nil if the album has no artwork.
+func (a AlbumArtwork) Best() *ArtworkSource {
+	var best *ArtworkSource
+	for _, sources := range [][]ArtworkSource{a.Folder, a.Embedded} {
+		for i := range sources {
+			s := &sources[i]
+			if !s.isCover() {
+				continue
+			}
+			if best == nil || s.Artwork.Width*s.Artwork.Height > best.Artwork.Width*best.Artwork.Height {
+				best = s
+			}
+		}
+	}
+	return best
+}
+
+// Tiny reports whether the best cover image of the album is smaller than
+// minSize pixels in width or height.
+func (a AlbumArtwork) Tiny(minSize int) bool {
+	best := a.Best()
+	return best != nil && min(best.Artwork.Width, best.Artwork.Height) < minSize
+}
+
+// frontCover returns the embedded front cover of the music file at path, or
+// nil if it has none.
+func (a AlbumArtwork) frontCover(path string) *ArtworkSource {
+	for i, s := range a.Embedded {
+		if s.Path == path && s.PictureType == tags.PICTURE_FRONT_COVER {
+			return &a.Embedded[i]
+		}
+	}
+	return nil
+}
+
+// ScanArtwork stores the pictures embedded in all known files below dir and
+// the folder images next to them in the artwork tables, every distinct image
+// once. It returns the artwork by directory. Files whose pictures can not be
+// read are returned with the reason.
+func ScanArtwork(db sqlx.Ext, dir string) ([]AlbumArtwork, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]error{}
+	var albums []AlbumArtwork
+	index := map[string]int{}
+	for _, file := range files {
+		albumDir := filepath.Dir(file.Path)
+		i, found := index[albumDir]
+		if !found {
+			i = len(albums)
+			index[albumDir] = i
+			albums = append(albums, AlbumArtwork{Dir: albumDir})
+		}
+		a := &albums[i]
+		a.Files = append(a.Files, file)
+
+		pictures, err := tags.ReadPictures(file.Path)
+		if errors.Is(err, tags.ErrUnsupportedFormat) {
+			continue
+		}
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		sources, err := storePictures(db, file, pictures)
+		if err != nil {
+			return nil, nil, err
+		}
+		a.Embedded = append(a.Embedded, sources...)
+	}
+
+	for i := range albums {
+		a := &albums[i]
+		if a.Folder, err = scanFolderImages(db, a.Dir, failed); err != nil {
+			return nil, nil, err
+		}
+	}
+	return albums, failed, nil
+}
+
+// storePictures stores the pictures embedded in file, replacing the ones
+// stored before for its content.
+func storePictures(db sqlx.Ext, file schema.File, pictures []tags.Picture) ([]ArtworkSource, error) {
+	var sources []ArtworkSource
+	var links []schema.FileArtwork
+	for _, p := range pictures {
+		if len(p.Data) == 0 {
+			continue
+		}
+		a := newArtwork(p.Data, p.MIME)
+		if err := data.SaveArtwork(db, a); err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Path, err)
+		}
+		sources = append(sources, ArtworkSource{Path: file.Path, PictureType: p.Type, Artwork: a})
+		links = append(links, schema.FileArtwork{PictureType: p.Type, ArtworkHash: a.Hash})
+	}
+	if err := data.SetFileArtwork(db, file.Hash, links); err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Path, err)
+	}
+	return sources, nil
+}
+
+// scanFolderImages stores the folder images in dir, ordered by preference,
+// and forgets the ones which no longer exist. Images which can not be decoded
+// are added to failed.
+func scanFolderImages(db sqlx.Ext, dir string, failed map[string]error) ([]ArtworkSource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var sources []ArtworkSource
+	found := map[string]bool{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !artwork.IsFolderImage(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			failed[path] = err
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			failed[path] = err
+			continue
+		}
+		if _, err := artwork.Inspect(content); err != nil {
+			failed[path] = err
+			continue
+		}
+		a := newArtwork(content, "")
+		if err := data.SaveArtwork(db, a); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := data.SaveFolderArtwork(db, schema.FolderArtwork{Path: path, ArtworkHash: a.Hash, Mod: info.ModTime()}); err != nil {
+			return nil, err
+		}
+		found[path] = true
+		sources = append(sources, ArtworkSource{Path: path, Folder: true, Artwork: a})
+	}
+	slices.SortStableFunc(sources, func(x, y ArtworkSource) int {
+		return artwork.FolderImageRank(filepath.Base(x.Path)) - artwork.FolderImageRank(filepath.Base(y.Path))
+	})
+
+	stored, err := data.GetFolderArtwork(db, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range stored {
+		if !found[image.Path] {
+			if err := data.DeleteFolderArtwork(db, image.Path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sources, nil
+}
+
+// newArtwork describes the image in content. The MIME type and size are
+// taken from the image if it can be decoded, otherwise mime is kept.
+func newArtwork(content []byte, mime string) schema.Artwork {
+	a := schema.Artwork{Hash: data.HashArtwork(content), MIME: mime, Data: content, Created: time.Now()}
+	if info, err := artwork.Inspect(content); err == nil {
+		a.MIME, a.Width, a.Height = info.MIME, info.Width, info.Height
+	}
+	if a.MIME == "" {
+		a.MIME = "application/octet-stream"
+	}
+	return a
+}
+
+// ArtworkUpdate is the outcome of exporting or embedding artwork for a single
+// file.
+type ArtworkUpdate struct {
+	Path string
+	// Reason why the file was not written, nil if it was written or is up to
+	// date
+	Skipped error
+	// Set if the file already had the artwork
+	UpToDate bool
+}
+
+// ExportArtwork writes the best cover image of album to COVER_FILE_NAME in its
+// directory as JPEG, scaled down to fit into size pixels unless size is 0. An
+// existing file is only replaced if force is set.
+func ExportArtwork(j *journal.Journal, album AlbumArtwork, size int, force bool) (ArtworkUpdate, error) {
+	u := ArtworkUpdate{Path: filepath.Join(album.Dir, COVER_FILE_NAME)}
+	best := album.Best()
+	if best == nil {
+		return u, ErrNoArtwork
+	}
+	content, err := artwork.EncodeJPEG(best.Artwork.Data, size)
+	if err != nil {
+		return u, fmt.Errorf("%s: %w", best.Path, err)
+	}
+	a := newArtwork(content, "image/jpeg")
+
+	_, err = os.Lstat(u.Path)
+	switch {
+	case err == nil:
+		hash, err := data.HashFile(u.Path)
+		if err != nil {
+			return u, err
+		}
+		// Files are hashed like images, so unchanged images are recognized
+		if bytes.Equal(hash, a.Hash) {
+			u.UpToDate = true
+			return u, nil
+		}
+		if !force {
+			u.Skipped = fmt.Errorf("%w: %s already exists", ErrConflict, u.Path)
+			return u, nil
+		}
+		if _, err := j.OverwriteFile(u.Path, content); err != nil {
+			return u, err
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if _, err := j.WriteFile(u.Path, content); err != nil {
+			return u, err
+		}
+	default:
+		return u, err
+	}
+
+	info, err := os.Stat(u.Path)
+	if err != nil {
+		return u, err
+	}
+	return u, j.Update(func(tx *sqlx.Tx) error {
+		if err := data.SaveArtwork(tx, a); err != nil {
+			return err
+		}
+		return data.SaveFolderArtwork(tx, schema.FolderArtwork{Path: u.Path, ArtworkHash: a.Hash, Mod: info.ModTime()})
+	})
+}
+
+// EmbedArtwork embeds the best cover image of album as front cover in all of
+// its files without one, or in all files if force is set. The image is scaled
+// down to fit into size pixels and stored as JPEG unless size is 0. Either
+// all files are written or, if writing one fails, none. Files of formats
+// without support for pictures are skipped.
+func EmbedArtwork(j *journal.Journal, album AlbumArtwork, size int, force bool) ([]ArtworkUpdate, error) {
+	best := album.Best()
+	if best == nil {
+		return nil, ErrNoArtwork
+	}
+	content := best.Artwork.Data
+	if size > 0 {
+		var err error
+		if content, err = artwork.EncodeJPEG(content, size); err != nil {
+			return nil, fmt.Errorf("%s: %w", best.Path, err)
+		}
+	}
+	a := newArtwork(content, best.Artwork.MIME)
+	picture := tags.Picture{Type: tags.PICTURE_FRONT_COVER, MIME: a.MIME, Width: a.Width, Height: a.Height, Data: content}
+
+	var updates []ArtworkUpdate
+	err := j.Transaction(func() error {
+		for _, file := range album.Files {
+			u := ArtworkUpdate{Path: file.Path}
+			if existing := album.frontCover(file.Path); existing != nil && (!force || bytes.Equal(existing.Artwork.Hash, a.Hash)) {
+				u.UpToDate = true
+				updates = append(updates, u)
+				continue
+			}
+			op, err := j.WriteTags(file.Path, func(path string) error {
+				return tags.SetPicture(path, picture)
+			})
+			if errors.Is(err, tags.ErrUnsupportedFormat) {
+				u.Skipped = err
+				updates = append(updates, u)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", file.Path, err)
+			}
+			if op.AfterHash != nil {
+				pictures, err := tags.ReadPictures(file.Path)
+				if err != nil {
+					return err
+				}
+				file.Hash = op.AfterHash
+				if err := j.Update(func(tx *sqlx.Tx) error {
+					_, err := storePictures(tx, file, pictures)
+					return err
+				}); err != nil {
+					return err
+				}
+			}
+			updates = append(updates, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
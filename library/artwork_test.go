@@ -0,0 +1,234 @@
+package library_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/artwork"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+)
+
+// testImage returns a PNG image of the given size, the shade makes images of
+// the same size distinct.
+func testImage(t *testing.T, width int, height int, shade uint8) []byte {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = shade
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// embedTestPicture embeds content as front cover in a known file.
+func embedTestPicture(t *testing.T, db *sqlx.DB, path string, content []byte) {
+	if err := tags.SetPicture(path, tags.Picture{Type: tags.PICTURE_FRONT_COVER, MIME: "image/png", Data: content}); err != nil {
+		t.Fatalf("failed to embed picture: %v", err)
+	}
+	hash, err := data.HashFile(path)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	if err := data.UpdateFileContent(db, path, hash, 1, time.Now()); err != nil {
+		t.Fatalf("failed to update test file: %v", err)
+	}
+}
+
+func TestScanArtwork(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	for _, album := range []string{"good", "missing", "tiny"} {
+		os.Mkdir(filepath.Join(dir, album), 0o755)
+	}
+
+	// Both files embed the same image, the larger folder image is the best
+	embedded := testImage(t, 300, 300, 0)
+	for _, name := range []string{"a.flac", "b.flac"} {
+		path := filepath.Join(dir, "good", name)
+		writeTaggedFLAC(t, db, path, "ALBUM=Good")
+		embedTestPicture(t, db, path, embedded)
+	}
+	os.WriteFile(filepath.Join(dir, "good", "folder.png"), testImage(t, 600, 600, 1), 0o644)
+	os.WriteFile(filepath.Join(dir, "good", "cover.jpg"), []byte("no image"), 0o644)
+	writeTaggedFLAC(t, db, filepath.Join(dir, "missing", "a.flac"), "ALBUM=Missing")
+	writeTaggedFLAC(t, db, filepath.Join(dir, "tiny", "a.flac"), "ALBUM=Tiny")
+	os.WriteFile(filepath.Join(dir, "tiny", "Cover.png"), testImage(t, 100, 100, 2), 0o644)
+
+	albums, failed, err := library.ScanArtwork(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 1 || failed[filepath.Join(dir, "good", "cover.jpg")] == nil {
+		t.Errorf("expected the broken image to fail, but got %v", failed)
+	}
+	if len(albums) != 3 {
+		t.Fatalf("expected 3 albums, but got %d", len(albums))
+	}
+
+	tests := []struct {
+		dir     string
+		best    string
+		missing bool
+		tiny    bool
+	}{
+		{dir: "good", best: "folder.png"},
+		{dir: "missing", missing: true},
+		{dir: "tiny", best: "Cover.png", tiny: true},
+	}
+	for i, test := range tests {
+		a := albums[i]
+		if a.Dir != filepath.Join(dir, test.dir) {
+			t.Errorf("expected album %s, but got %s", test.dir, a.Dir)
+			continue
+		}
+		best := a.Best()
+		if (best == nil) != test.missing {
+			t.Errorf("%s: expected missing %v, but got best %v", test.dir, test.missing, best)
+		} else if best != nil && filepath.Base(best.Path) != test.best {
+			t.Errorf("%s: expected best image %s, but got %s", test.dir, test.best, best.Path)
+		}
+		if result := a.Tiny(500); result != test.tiny {
+			t.Errorf("%s: expected tiny %v, but got %v", test.dir, test.tiny, result)
+		}
+	}
+
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM artwork"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 distinct images, but got %d", count)
+	}
+	if err := db.Get(&count, "SELECT COUNT(*) FROM file_artwork"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 embedded pictures, but got %d", count)
+	}
+}
+
+func TestExportArtwork(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+	path := filepath.Join(dir, "a.flac")
+	writeTaggedFLAC(t, db, path, "ALBUM=Album")
+	embedTestPicture(t, db, path, testImage(t, 800, 400, 0))
+
+	albums, _, err := library.ScanArtwork(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, err := library.ExportArtwork(j, albums[0], 200, false)
+	if err != nil || u.Skipped != nil || u.UpToDate {
+		t.Fatalf("expected cover to be exported, but got %+v (%v)", u, err)
+	}
+	content, err := os.ReadFile(u.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info, err := artwork.Inspect(content); err != nil || info.MIME != "image/jpeg" || info.Width != 200 || info.Height != 100 {
+		t.Errorf("expected image/jpeg of 200x100, but got %+v (%v)", info, err)
+	}
+
+	if u, err := library.ExportArtwork(j, albums[0], 200, false); err != nil || !u.UpToDate {
+		t.Errorf("expected cover to be up to date, but got %+v (%v)", u, err)
+	}
+	if u, err := library.ExportArtwork(j, albums[0], 100, false); err != nil || !errors.Is(u.Skipped, library.ErrConflict) {
+		t.Errorf("expected error %v, but got %+v (%v)", library.ErrConflict, u, err)
+	}
+	if u, err := library.ExportArtwork(j, albums[0], 100, true); err != nil || u.Skipped != nil || u.UpToDate {
+		t.Errorf("expected cover to be replaced, but got %+v (%v)", u, err)
+	}
+	// Images are not in the files table, they are not recorded as tag writes
+	ops, err := data.GetBatchOperations(db, j.Batch())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ops) != 2 || ops[0].Kind != schema.OP_WRITE || ops[1].Kind != schema.OP_OVERWRITE {
+		t.Errorf("expected a write and an overwrite, but got %+v", ops)
+	}
+
+	if _, err := journal.UndoBatch(db, j.Batch()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(u.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected undo to remove the exported cover, but got %v", err)
+	}
+}
+
+func TestEmbedArtwork(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+	covered := filepath.Join(dir, "a.flac")
+	uncovered := filepath.Join(dir, "b.flac")
+	writeTaggedFLAC(t, db, covered, "ALBUM=Album")
+	writeTaggedFLAC(t, db, uncovered, "ALBUM=Album")
+	embedTestPicture(t, db, covered, testImage(t, 100, 100, 0))
+	os.WriteFile(filepath.Join(dir, "cover.png"), testImage(t, 600, 600, 1), 0o644)
+
+	albums, _, err := library.ScanArtwork(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updates, err := library.EmbedArtwork(j, albums[0], 300, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updates) != 2 || !updates[0].UpToDate || updates[1].UpToDate || updates[1].Skipped != nil {
+		t.Fatalf("expected only the file without cover to be written, but got %+v", updates)
+	}
+
+	pictures, err := tags.ReadPictures(uncovered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pictures) != 1 || pictures[0].Type != tags.PICTURE_FRONT_COVER || pictures[0].MIME != "image/jpeg" || pictures[0].Width != 300 {
+		t.Errorf("expected a 300 pixels wide JPEG front cover, but got %+v", pictures)
+	}
+	file, err := data.GetFile(db, uncovered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if links, err := data.GetFileArtwork(db, file.Hash); err != nil || len(links) != 1 {
+		t.Errorf("expected the embedded picture to be stored for the new content, but got %v (%v)", links, err)
+	}
+
+	albums, _, err = library.ScanArtwork(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updates, err = library.EmbedArtwork(j, albums[0], 300, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updates[0].UpToDate || !updates[1].UpToDate {
+		t.Errorf("expected only the file with another cover to be written, but got %+v", updates)
+	}
+}
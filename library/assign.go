@@ -0,0 +1,75 @@
+package library
+
+import "math"
+
+// assign solves the assignment problem for a rows × columns cost matrix with
+// the Hungarian algorithm in O(n³). It returns for every row the column
+// assigned to it with the lowest total cost, -1 if there are more rows than
+// columns and the row is left unassigned.
+func assign(cost [][]float64, columns int) []int {
+	n := max(len(cost), columns)
+	// Missing rows and columns cost nothing, so the square matrix has the
+	// same optimal assignment
+	at := func(row, column int) float64 {
+		if row < len(cost) && column < columns {
+			return cost[row][column]
+		}
+		return 0
+	}
+
+	// Potentials u and v of rows and columns, match[column] is its row. All
+	// indices are shifted by one, column 0 is a virtual start.
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	match := make([]int, n+1)
+	way := make([]int, n+1)
+	for row := 1; row <= n; row++ {
+		match[0] = row
+		column := 0
+		minimum := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minimum {
+			minimum[j] = math.Inf(1)
+		}
+		for match[column] != 0 {
+			used[column] = true
+			i, delta, next := match[column], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				if c := at(i-1, j-1) - u[i] - v[j]; c < minimum[j] {
+					minimum[j], way[j] = c, column
+				}
+				if minimum[j] < delta {
+					delta, next = minimum[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[match[j]] += delta
+					v[j] -= delta
+				} else {
+					minimum[j] -= delta
+				}
+			}
+			column = next
+		}
+		for column != 0 {
+			previous := way[column]
+			match[column] = match[previous]
+			column = previous
+		}
+	}
+
+	assigned := make([]int, len(cost))
+	for j := 1; j <= n; j++ {
+		if row := match[j] - 1; row < len(cost) {
+			assigned[row] = -1
+			if j-1 < columns {
+				assigned[row] = j - 1
+			}
+		}
+	}
+	return assigned
+}
@@ -0,0 +1,442 @@
+package library
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/acoustid"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+)
+
+const (
+	// Minimum score of an AcoustID to trust its recordings
+	MIN_ACOUSTID_SCORE = 0.5
+	// Length differences of tracks up to this many seconds are not penalized,
+	// up to LENGTH_MAX_DIFF the penalty rises to the maximum
+	LENGTH_GRACE    = 10
+	LENGTH_MAX_DIFF = 30
+)
+
+// Weights of the parts of the distance between an album and a release. Every
+// matched track weighs 1 in total.
+const (
+	weightAlbum        = 3.0
+	weightAlbumArtist  = 3.0
+	weightMissingTrack = 0.9
+	weightExtraFile    = 0.6
+
+	weightTitle     = 3.0
+	weightLength    = 2.0
+	weightNumber    = 1.0
+	weightRecording = 4.0
+)
+
+var ErrNoMatchingTrack = errors.New("no matching track on the release")
+
+// Album is a group of known files which belong to the same release: all files
+// in one directory with the same album tag.
+type Album struct {
+	Dir    string
+	Title  string // album tag, empty if the files have none
+	Artist string // most common album artist, falling back to the artist
+	Files  []AlbumFile
+}
+
+type AlbumFile struct {
+	File     schema.File
+	Tags     *tags.Tags
+	Duration float64 // in seconds
+	// MBIDs of the recordings the file was identified as, either linked or
+	// looked up by its fingerprint
+	Recordings []string
+}
+
+// Candidate is a release scored against an album.
+type Candidate struct {
+	Release schema.Release
+	Tracks  []schema.Track
+	// Between 0 for a perfect match and 1 for no match at all
+	Distance float64
+	// The index in Tracks of the track matched with each file of the album,
+	// -1 if the file matches no track
+	Mapping []int
+}
+
+// MissingTracks returns the number of tracks no file was matched with.
+func (c Candidate) MissingTracks() int {
+	matched := 0
+	for _, t := range c.Mapping {
+		if t >= 0 {
+			matched++
+		}
+	}
+	return len(c.Tracks) - matched
+}
+
+// GroupAlbums groups the known files below dir into albums. The duration of
+// every file is taken from its fingerprint, which is calculated if it is not
+// stored yet. Files which can not be read are returned with their error
+// instead.
+func GroupAlbums(db sqlx.Ext, dir string) ([]Album, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]error{}
+	var albums []Album
+	index := map[[2]string]int{}
+	for _, file := range files {
+		t, err := tags.Read(file.Path)
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		fp, err := Fingerprint(db, file)
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		f := AlbumFile{File: file, Tags: t, Duration: fp.Duration}
+		if file.RecordingID != nil {
+			f.Recordings = []string{*file.RecordingID}
+		}
+
+		key := [2]string{filepath.Dir(file.Path), t.Album}
+		i, found := index[key]
+		if !found {
+			i = len(albums)
+			index[key] = i
+			albums = append(albums, Album{Dir: key[0], Title: key[1]})
+		}
+		albums[i].Files = append(albums[i].Files, f)
+	}
+
+	for i := range albums {
+		a := &albums[i]
+		slices.SortStableFunc(a.Files, func(x, y AlbumFile) int {
+			return cmp.Or(cmp.Compare(x.Tags.Disc, y.Tags.Disc), cmp.Compare(x.Tags.Track, y.Tags.Track))
+		})
+		artists := map[string]int{}
+		for _, f := range a.Files {
+			if artist := f.Tags.Fields()[tags.FIELD_ALBUMARTIST]; artist != "" {
+				artists[artist]++
+			}
+		}
+		for artist, n := range artists {
+			if n > artists[a.Artist] || n == artists[a.Artist] && artist < a.Artist {
+				a.Artist = artist
+			}
+		}
+	}
+	return albums, failed, nil
+}
+
+// LookupAlbumRecordings adds the recordings of the AcoustIDs of every file of
+// album with a score of at least MIN_ACOUSTID_SCORE. Files which can not be
+// looked up are returned with their error.
+func LookupAlbumRecordings(ctx context.Context, db sqlx.Ext, client *acoustid.Client, album *Album) map[string]error {
+	failed := map[string]error{}
+	for i := range album.Files {
+		f := &album.Files[i]
+		results, err := LookupAcoustID(ctx, db, client, f.File)
+		if err != nil {
+			failed[f.File.Path] = err
+			continue
+		}
+		for _, r := range results {
+			if r.Score < MIN_ACOUSTID_SCORE {
+				break // sorted by score
+			}
+			for _, recording := range r.Recordings {
+				if !slices.Contains(f.Recordings, recording.ID) {
+					f.Recordings = append(f.Recordings, recording.ID)
+				}
+			}
+		}
+	}
+	return failed
+}
+
+// FindCandidates scores all stored releases with the title of album or with
+// a track of one of its recordings, the best candidate first.
+func FindCandidates(db sqlx.Queryer, album Album) ([]Candidate, error) {
+	releases := map[string]schema.Release{}
+	if album.Title != "" {
+		found, err := data.FindReleasesByTitle(db, album.Title)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range found {
+			releases[r.ID] = r
+		}
+	}
+	searched := map[string]bool{}
+	for _, f := range album.Files {
+		for _, recording := range f.Recordings {
+			if searched[recording] {
+				continue
+			}
+			searched[recording] = true
+			found, err := data.GetRecordingReleases(db, recording)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range found {
+				releases[r.ID] = r
+			}
+		}
+	}
+
+	candidates := make([]Candidate, 0, len(releases))
+	for _, id := range slices.Sorted(maps.Keys(releases)) {
+		tracks, err := data.GetReleaseTracks(db, id)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, ScoreRelease(album, releases[id], tracks))
+	}
+	slices.SortStableFunc(candidates, func(a, b Candidate) int {
+		return cmp.Compare(a.Distance, b.Distance)
+	})
+	return candidates, nil
+}
+
+// ScoreRelease matches the files of album with the tracks of a release and
+// calculates the distance between them. Files are matched with tracks by
+// title, length, track number and recording, so that the sum of the
+// distances of all matched pairs is minimal.
+func ScoreRelease(album Album, release schema.Release, tracks []schema.Track) Candidate {
+	cost := make([][]float64, len(album.Files))
+	for i, f := range album.Files {
+		cost[i] = make([]float64, len(tracks))
+		for j, t := range tracks {
+			cost[i][j] = trackDistance(f, t)
+		}
+	}
+	c := Candidate{Release: release, Tracks: tracks, Mapping: assign(cost, len(tracks))}
+
+	var d distance
+	if album.Title != "" {
+		d.add(weightAlbum, stringDistance(album.Title, release.Title))
+	}
+	if album.Artist != "" {
+		d.add(weightAlbumArtist, stringDistance(album.Artist, release.ArtistCredit))
+	}
+	for i, t := range c.Mapping {
+		if t < 0 {
+			d.add(weightExtraFile, 1)
+		} else {
+			d.add(1, cost[i][t])
+		}
+	}
+	d.add(weightMissingTrack*float64(c.MissingTracks()), 1)
+	c.Distance = d.value()
+	return c
+}
+
+// distance is a weighted mean of distances between 0 and 1.
+type distance struct {
+	sum    float64
+	weight float64
+}
+
+func (d *distance) add(weight float64, value float64) {
+	d.sum += weight * value
+	d.weight += weight
+}
+
+func (d *distance) value() float64 {
+	if d.weight == 0 {
+		return 1
+	}
+	return d.sum / d.weight
+}
+
+func trackDistance(f AlbumFile, t schema.Track) float64 {
+	var d distance
+	title := f.Tags.Title
+	if title == "" {
+		// Mostly named like "01 Title"
+		name := filepath.Base(f.File.Path)
+		title = strings.TrimLeft(strings.TrimSuffix(name, filepath.Ext(name)), "0123456789 .-_")
+	}
+	d.add(weightTitle, stringDistance(title, t.Title))
+	if f.Duration > 0 && t.Length > 0 {
+		diff := math.Abs(f.Duration - float64(t.Length)/1000)
+		d.add(weightLength, math.Min(1, math.Max(0, diff-LENGTH_GRACE)/(LENGTH_MAX_DIFF-LENGTH_GRACE)))
+	}
+	if f.Tags.Track > 0 {
+		if f.Tags.Track == t.Position && (f.Tags.Disc == 0 || f.Tags.Disc == t.Disc) {
+			d.add(weightNumber, 0)
+		} else {
+			d.add(weightNumber, 1)
+		}
+	}
+	if len(f.Recordings) > 0 {
+		if slices.Contains(f.Recordings, t.RecordingID) {
+			d.add(weightRecording, 0)
+		} else {
+			d.add(weightRecording, 1)
+		}
+	}
+	return d.value()
+}
+
+// stringDistance returns the edit distance of a and b relative to the
+// length of the longer one, ignoring case, punctuation and whitespace
+// differences.
+func stringDistance(a, b string) float64 {
+	x, y := []rune(normalizeTitle(a)), []rune(normalizeTitle(b))
+	if len(x) == 0 && len(y) == 0 {
+		return 0
+	}
+	// Levenshtein distance, keeping only one row of the matrix
+	row := make([]int, len(y)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(y); j++ {
+			substitution := diagonal
+			if x[i-1] != y[j-1] {
+				substitution++
+			}
+			diagonal = row[j]
+			row[j] = min(row[j]+1, row[j-1]+1, substitution)
+		}
+	}
+	return float64(row[len(y)]) / float64(max(len(x), len(y)))
+}
+
+func normalizeTitle(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		case r == '&':
+			if b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString("and")
+			space = true
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// PlanAutotag determines the tags of every file of album from the track it
+// is matched with on the release of c. Tags which do not come from
+// MusicBrainz are kept. Files without a matching track are skipped.
+func PlanAutotag(album Album, c Candidate) []TagUpdate {
+	discTracks := map[int]int{}
+	discs := 0
+	for _, t := range c.Tracks {
+		discTracks[t.Disc]++
+		discs = max(discs, t.Disc)
+	}
+
+	plan := make([]TagUpdate, 0, len(album.Files))
+	for i, f := range album.Files {
+		u := TagUpdate{Path: f.File.Path}
+		if c.Mapping[i] < 0 {
+			u.Skipped = fmt.Errorf("%w %s", ErrNoMatchingTrack, c.Release.ID)
+			plan = append(plan, u)
+			continue
+		}
+		t := c.Tracks[c.Mapping[i]]
+		u.Matched = map[string]string{
+			tags.FIELD_TITLE:       t.Title,
+			tags.FIELD_ARTIST:      t.ArtistCredit,
+			tags.FIELD_ALBUMARTIST: c.Release.ArtistCredit,
+			tags.FIELD_ALBUM:       c.Release.Title,
+			tags.FIELD_DATE:        c.Release.Date,
+			tags.FIELD_TRACK:       strconv.Itoa(t.Position),
+			tags.FIELD_TRACKTOTAL:  strconv.Itoa(discTracks[t.Disc]),
+			tags.FIELD_DISC:        strconv.Itoa(t.Disc),
+			tags.FIELD_DISCTOTAL:   strconv.Itoa(discs),
+			// Custom tags named like by MusicBrainz Picard
+			"musicbrainz_albumid":        c.Release.ID,
+			"musicbrainz_albumartistid":  c.Release.ArtistID,
+			"musicbrainz_releasegroupid": c.Release.ReleaseGroupID,
+			"musicbrainz_releasetrackid": t.ID,
+			"musicbrainz_trackid":        t.RecordingID,
+		}
+		copied := *f.Tags
+		copied.Custom = maps.Clone(f.Tags.Custom)
+		u.Tags = &copied
+		before := explicitFields(u.Tags)
+		for name, value := range u.Matched {
+			if value == "" {
+				delete(u.Matched, name)
+				continue
+			}
+			if err := u.Tags.SetField(name, value); err != nil {
+				u.Skipped = err
+				break
+			}
+		}
+		if u.Skipped == nil && maps.Equal(before, explicitFields(u.Tags)) {
+			u.UpToDate = true
+		}
+		plan = append(plan, u)
+	}
+	return plan
+}
+
+// ApplyAutotag writes the tags of c to the files of album and links them to
+// their tracks in a single journal transaction: either all files are tagged
+// or none. It returns the updates that were carried out.
+func ApplyAutotag(j *journal.Journal, album Album, c Candidate) ([]TagUpdate, error) {
+	plan := PlanAutotag(album, c)
+	var done []TagUpdate
+	err := j.Transaction(func() error {
+		var err error
+		if done, err = WriteTags(j, plan); err != nil {
+			return err
+		}
+		return j.Update(func(tx *sqlx.Tx) error {
+			for i, f := range album.Files {
+				if c.Mapping[i] < 0 {
+					continue
+				}
+				if err := data.LinkFileToTrack(tx, f.File.Path, c.Tracks[c.Mapping[i]]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
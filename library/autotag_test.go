@@ -0,0 +1,298 @@
+package library_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/musicbrainz"
+)
+
+// A release with the tracks of mbRelease on a single disc in reverse order,
+// with a longer recording of the song
+var mbOtherRelease = musicbrainz.Release{
+	ID:           "4d2c2f8e-9a1b-4c3d-8e7f-6a5b4c3d2e1f",
+	Title:        "Album",
+	ArtistCredit: mbRelease.ArtistCredit,
+	Media: []musicbrainz.Medium{{Position: 1, TrackCount: 2, Tracks: []musicbrainz.Track{
+		{ID: "c0d1e2f3-a4b5-4c6d-8e9f-0a1b2c3d4e5f", Number: "1", Position: 1, Title: "Song", Length: length(240000),
+			Recording: musicbrainz.Recording{ID: "e4f5a6b7-c8d9-4e0f-a1b2-c3d4e5f6a7b8", Title: "Song"}},
+		{ID: "d1e2f3a4-b5c6-4d7e-9f0a-1b2c3d4e5f60", Number: "2", Position: 2, Title: "Intro", Length: length(60000),
+			Recording: musicbrainz.Recording{ID: "b9ad642e-b012-41c7-b72a-42cf4911f9ff", Title: "Intro"}},
+	}}},
+}
+
+func length(ms int) *int { return &ms }
+
+// storeAutotagReleases stores mbRelease with track lengths and mbOtherRelease.
+func storeAutotagReleases(t *testing.T, db *sqlx.DB) {
+	release := mbRelease
+	release.Media = []musicbrainz.Medium{mbRelease.Media[0], mbRelease.Media[1]}
+	release.Media[0].Tracks = []musicbrainz.Track{mbRelease.Media[0].Tracks[0]}
+	release.Media[0].Tracks[0].Length = length(60000)
+	release.Media[1].Tracks = []musicbrainz.Track{mbRelease.Media[1].Tracks[0]}
+	release.Media[1].Tracks[0].Length = length(200000)
+	for _, r := range []*musicbrainz.Release{&release, &mbOtherRelease} {
+		if err := library.StoreRelease(db, r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestFindCandidates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	storeAutotagReleases(t, db)
+
+	tests := []struct {
+		name     string
+		album    library.Album
+		expected string
+		mapping  []int
+		found    int
+	}{
+		{"by track numbers", library.Album{Title: "Album", Artist: "The Band", Files: []library.AlbumFile{
+			{Tags: &tags.Tags{Title: "Intro", Track: 1, Disc: 1}, Duration: 61},
+			{Tags: &tags.Tags{Title: "Song", Track: 1, Disc: 2}, Duration: 199},
+		}}, mbRelease.ID, []int{0, 1}, 2},
+		{"by track order", library.Album{Title: "album", Artist: "The Band", Files: []library.AlbumFile{
+			{Tags: &tags.Tags{Title: "Song", Track: 1}, Duration: 241},
+			{Tags: &tags.Tags{Title: "Intro", Track: 2}, Duration: 60},
+		}}, mbOtherRelease.ID, []int{0, 1}, 2},
+		{"by lengths and file names", library.Album{Title: "Album", Files: []library.AlbumFile{
+			{File: schema.File{Path: "/music/01 Song.mp3"}, Tags: &tags.Tags{}, Duration: 205},
+			{File: schema.File{Path: "/music/02 Intro.mp3"}, Tags: &tags.Tags{}, Duration: 58},
+		}}, mbRelease.ID, []int{1, 0}, 2},
+		{"by recordings without album title", library.Album{Files: []library.AlbumFile{
+			{Tags: &tags.Tags{Title: "song!"}, Recordings: []string{"e4f5a6b7-c8d9-4e0f-a1b2-c3d4e5f6a7b8"}},
+		}}, mbOtherRelease.ID, []int{0}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidates, err := library.FindCandidates(db, test.album)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(candidates) != test.found {
+				t.Fatalf("expected %d candidates, but got %d", test.found, len(candidates))
+			}
+			best := candidates[0]
+			if best.Release.ID != test.expected {
+				t.Errorf("expected %s to be the best candidate, but got %s", test.expected, best.Release.ID)
+			}
+			if len(candidates) > 1 && best.Distance >= candidates[1].Distance {
+				t.Errorf("expected the best candidate to be closer, but got %.3f and %.3f", best.Distance, candidates[1].Distance)
+			}
+			for i, track := range test.mapping {
+				if best.Mapping[i] != track {
+					t.Errorf("expected file %d to match track %d, but got %d", i, track, best.Mapping[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScoreReleaseMissingAndExtra(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	storeAutotagReleases(t, db)
+	release, err := data.GetRelease(db, mbOtherRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tracks, err := data.GetReleaseTracks(db, release.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	song := library.AlbumFile{Tags: &tags.Tags{Title: "Song", Track: 1}, Duration: 240}
+	intro := library.AlbumFile{Tags: &tags.Tags{Title: "Intro", Track: 2}, Duration: 60}
+	bonus := library.AlbumFile{Tags: &tags.Tags{Title: "Bonus", Track: 3}, Duration: 100}
+
+	complete := library.ScoreRelease(library.Album{Title: "Album", Artist: "The Band", Files: []library.AlbumFile{song, intro}}, release, tracks)
+	if complete.Distance != 0 {
+		t.Errorf("expected a perfect match, but got %.3f", complete.Distance)
+	}
+	missing := library.ScoreRelease(library.Album{Title: "Album", Artist: "The Band", Files: []library.AlbumFile{intro}}, release, tracks)
+	if missing.MissingTracks() != 1 || missing.Mapping[0] != 1 || missing.Distance <= complete.Distance {
+		t.Errorf("expected one missing track to increase the distance, but got %+v", missing)
+	}
+	extra := library.ScoreRelease(library.Album{Title: "Album", Artist: "The Band", Files: []library.AlbumFile{song, intro, bonus}}, release, tracks)
+	if extra.Mapping[2] != -1 || extra.Distance <= complete.Distance {
+		t.Errorf("expected the extra file to be unmatched and increase the distance, but got %+v", extra)
+	}
+}
+
+func TestApplyAutotag(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+	storeAutotagReleases(t, db)
+
+	writeTaggedFLAC(t, db, filepath.Join(dir, "01.flac"), "TITLE=intro", "GENRE=Rock")
+	writeTaggedFLAC(t, db, filepath.Join(dir, "02.flac"), "TITLE=song")
+	albums, failed, err := library.GroupAlbums(db, dir)
+	if err == nil && len(failed) == 0 {
+		t.Fatalf("expected files without audio to fail, but got %v", albums)
+	}
+	album := library.Album{Dir: dir}
+	for _, name := range []string{"01.flac", "02.flac"} {
+		file, err := data.GetFile(db, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		current, err := tags.Read(file.Path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		album.Files = append(album.Files, library.AlbumFile{File: file, Tags: current})
+	}
+	candidates, err := library.FindCandidates(db, album)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(candidates) != 0 {
+		t.Fatalf("expected no candidates without album title and recordings, but got %d", len(candidates))
+	}
+	release, err := data.GetRelease(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tracks, err := data.GetReleaseTracks(db, release.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done, err := library.ApplyAutotag(j, album, library.ScoreRelease(album, release, tracks))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(done) != 2 {
+		t.Errorf("expected 2 tagged files, but got %d", len(done))
+	}
+	written, err := tags.Read(filepath.Join(dir, "02.flac"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written.Title != "Song" || written.Album != "Album" || written.Disc != 2 || written.DiscTotal != 2 || written.Track != 1 || written.TrackTotal != 1 {
+		t.Errorf("expected the tags of the second disc, but got %+v", written)
+	}
+	if written.Artist != "The Band feat. Guest" || written.AlbumArtist != "The Band" || written.Custom["MUSICBRAINZ_ALBUMID"] != mbRelease.ID {
+		t.Errorf("expected the credits and ids of the release, but got %+v", written)
+	}
+	written, err = tags.Read(filepath.Join(dir, "01.flac"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written.Title != "Intro" || written.Genre != "Rock" {
+		t.Errorf("expected the genre to be kept, but got %+v", written)
+	}
+	file, err := data.GetFile(db, filepath.Join(dir, "01.flac"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if file.TrackID == nil || *file.TrackID != tracks[0].ID {
+		t.Errorf("expected the file to be linked to track %s, but got %v", tracks[0].ID, file.TrackID)
+	}
+}
+
+func TestApplyAutotagAtomic(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+	storeAutotagReleases(t, db)
+
+	writeTaggedFLAC(t, db, filepath.Join(dir, "01.flac"), "TITLE=intro")
+	// Not a FLAC file anymore, so writing its tags fails
+	broken := filepath.Join(dir, "02.flac")
+	writeTaggedFLAC(t, db, broken, "TITLE=song")
+	var album library.Album
+	for _, path := range []string{filepath.Join(dir, "01.flac"), broken} {
+		file, err := data.GetFile(db, path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		current, err := tags.Read(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		album.Files = append(album.Files, library.AlbumFile{File: file, Tags: current})
+	}
+	if err := os.WriteFile(broken, []byte("garbage"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before, err := os.ReadFile(filepath.Join(dir, "01.flac"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release, err := data.GetRelease(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tracks, err := data.GetReleaseTracks(db, release.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := library.ApplyAutotag(j, album, library.ScoreRelease(album, release, tracks)); err == nil {
+		t.Fatal("expected an error, but got none")
+	}
+	after, err := os.ReadFile(filepath.Join(dir, "01.flac"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Error("expected the first file to be restored")
+	}
+	file, err := data.GetFile(db, filepath.Join(dir, "01.flac"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if file.TrackID != nil {
+		t.Errorf("expected no track to be linked, but got %s", *file.TrackID)
+	}
+}
+
+func TestGroupAlbums(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	for _, sub := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	writeWAV(t, db, filepath.Join(dir, "a", "1.wav"), tone(2, 440), "")
+	writeWAV(t, db, filepath.Join(dir, "a", "2.wav"), tone(3, 330), "")
+	writeWAV(t, db, filepath.Join(dir, "b", "1.wav"), tone(1, 220), "")
+
+	albums, failed, err := library.GroupAlbums(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("expected no failed files, but got %v", failed)
+	}
+	if len(albums) != 2 || len(albums[0].Files) != 2 || len(albums[1].Files) != 1 {
+		t.Fatalf("expected one album per directory, but got %+v", albums)
+	}
+	if d := albums[0].Files[1].Duration; d < 2.99 || d > 3.01 {
+		t.Errorf("expected a duration of 3 seconds, but got %f", d)
+	}
+}
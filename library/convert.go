@@ -0,0 +1,379 @@
+package library
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/encode"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/audio/transcode"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+)
+
+// Target formats of Convert
+const (
+	FORMAT_FLAC = "flac"
+	FORMAT_WAV  = "wav"
+)
+
+var FORMATS = []string{FORMAT_FLAC, FORMAT_WAV}
+
+var (
+	ErrUnknownFormat = errors.New("unknown target format")
+	ErrSameFormat    = errors.New("already in the target format")
+)
+
+func ParseFormat(format string) (string, error) {
+	for _, f := range FORMATS {
+		if f == strings.ToLower(format) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("%w: \"%s\"", ErrUnknownFormat, format)
+}
+
+// PlanConvert determines the converted file of every known file below dir
+// for converting it to format. It is placed next to the file, or in the same
+// relative directory below out unless out is empty, with the extension of
+// format. Files already in format or which can not be decoded are skipped,
+// files converted before from the same content are up to date.
+func PlanConvert(db sqlx.Queryer, dir string, out string, format string) ([]Placement, error) {
+	return planConvert(db, dir, out, format, format, format)
+}
+
+// PlanTranscode is PlanConvert for transcoding with profile, whose name is
+// the format of the derivations.
+func PlanTranscode(db sqlx.Queryer, dir string, out string, profile transcode.Profile) ([]Placement, error) {
+	return planConvert(db, dir, out, profile.Name, profile.Extension, profile.MediaType)
+}
+
+func planConvert(db sqlx.Queryer, dir string, out string, format string, extension string, mediaType string) ([]Placement, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if out != "" {
+		if out, err = filepath.Abs(out); err != nil {
+			return nil, err
+		}
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]Placement, 0, len(files))
+	claimed := map[string]string{} // lower case destination -> source
+	for _, file := range files {
+		plan = append(plan, Placement{Source: file.Path})
+		current := &plan[len(plan)-1]
+
+		if file.MediaType == mediaType {
+			current.Skipped = ErrSameFormat
+			continue
+		}
+		d, err := decode.Open(file.Path)
+		if err != nil {
+			current.Skipped = err
+			continue
+		}
+		d.Close()
+
+		relative, err := filepath.Rel(dir, file.Path)
+		if err != nil {
+			current.Skipped = err
+			continue
+		}
+		current.Dest = strings.TrimSuffix(file.Path, filepath.Ext(file.Path)) + "." + extension
+		if out != "" {
+			current.Dest = filepath.Join(out, strings.TrimSuffix(relative, filepath.Ext(relative))+"."+extension)
+		}
+		// Checked before encoding, the converted file is added to the files
+		// table afterwards
+		if err := data.ValidatePath(current.Dest); err != nil {
+			current.Skipped = fmt.Errorf("%w: %s: %w", data.ErrInvalidPath, current.Dest, err)
+			continue
+		}
+
+		// Case insensitive, like Plan
+		key := strings.ToLower(current.Dest)
+		if other, ok := claimed[key]; ok {
+			current.Skipped = fmt.Errorf("%w: %s is already planned for %s", ErrConflict, current.Dest, other)
+			continue
+		}
+		claimed[key] = file.Path
+
+		if _, err := os.Lstat(current.Dest); err == nil {
+			derivation, err := data.GetDerivation(db, current.Dest)
+			if err == nil && bytes.Equal(derivation.SourceHash, file.Hash) && derivation.Format == format {
+				hash, err := data.HashFile(current.Dest)
+				current.UpToDate = err == nil && bytes.Equal(hash, derivation.Hash)
+			}
+			if !current.UpToDate {
+				current.Skipped = fmt.Errorf("%w: %s already exists", ErrConflict, current.Dest)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// Convert converts all files of plan which are neither skipped nor up to date
+// to format, FLAC with the compression level level, running up to jobs
+// encoders at once. The sample size is kept, lossy files are converted to 16
+// bits. Tags and pictures are copied to the converted file. Every conversion
+// is recorded in j, the converted file is added to the files table and linked
+// to its source as derivation. report is called for each converted file in
+// the order of plan with the result of the conversion.
+func Convert(j *journal.Journal, plan []Placement, format string, level int, jobs int, report func(p Placement, err error)) error {
+	var settings string
+	switch format {
+	case FORMAT_FLAC:
+		if level < encode.MIN_LEVEL || level > encode.MAX_LEVEL {
+			return fmt.Errorf("%w: %d is not between %d and %d", encode.ErrInvalidLevel, level, encode.MIN_LEVEL, encode.MAX_LEVEL)
+		}
+		settings = fmt.Sprintf("level %d", level)
+	case FORMAT_WAV:
+	default:
+		return fmt.Errorf("%w: \"%s\"", ErrUnknownFormat, format)
+	}
+	return convertAll(j, plan, format, format, settings, jobs, func(src string, dst string) error {
+		return convertFile(src, dst, format, level)
+	}, report)
+}
+
+// Transcode is Convert with the external encoder of profile, i.e. to lossy
+// formats. Tags and pictures are copied if the format of the encoded file
+// supports writing them, the command of profile is recorded as the settings
+// of the derivations.
+func Transcode(j *journal.Journal, plan []Placement, profile transcode.Profile, jobs int, report func(p Placement, err error)) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	return convertAll(j, plan, profile.Name, profile.MediaType, profile.String(), jobs, func(src string, dst string) error {
+		return transcodeFile(src, dst, profile)
+	}, report)
+}
+
+// converted is a file encoded by a worker of convertAll.
+type converted struct {
+	tmp        string // temporary directory of path
+	path       string
+	sourceHash []byte
+	err        error
+}
+
+// convertAll runs convert for every file of plan to be converted, up to jobs
+// at once, into a temporary directory next to its destination. The journal is
+// not safe for concurrent use, so the encoded files are moved to their
+// destination and recorded one after another, in the order of plan.
+func convertAll(j *journal.Journal, plan []Placement, format string, mediaType string, settings string, jobs int, convert func(src string, dst string) error, report func(p Placement, err error)) error {
+	jobs = max(jobs, 1)
+	results := make([]chan converted, len(plan))
+	for i, p := range plan {
+		if p.Skipped == nil && !p.UpToDate {
+			results[i] = make(chan converted, 1)
+		}
+	}
+	// Taken when a worker starts and released once its file is recorded,
+	// which also limits the number of encoded files waiting to be recorded
+	running := make(chan struct{}, jobs)
+	go func() {
+		for i, p := range plan {
+			if results[i] == nil {
+				continue
+			}
+			running <- struct{}{}
+			go func() {
+				results[i] <- encodeTemporary(p, convert)
+			}()
+		}
+	}()
+
+	var failed int
+	for i, p := range plan {
+		if results[i] == nil {
+			continue
+		}
+		result := <-results[i]
+		err := result.err
+		if err == nil {
+			err = j.Transaction(func() error {
+				op, err := j.Convert(p.Source, p.Dest, func(src string, dst string) error {
+					return os.Rename(result.path, dst)
+				})
+				if err != nil {
+					return err
+				}
+				info, err := os.Stat(op.AfterPath)
+				if err != nil {
+					return err
+				}
+				return j.Update(func(tx *sqlx.Tx) error {
+					file := schema.File{Path: op.AfterPath, Hash: op.AfterHash, MediaType: mediaType, Size: uint(info.Size()), Mod: info.ModTime()}
+					if err := data.UpsertFile(tx, file); err != nil {
+						return err
+					}
+					return data.SaveDerivation(tx, schema.Derivation{
+						Path:       op.AfterPath,
+						Hash:       op.AfterHash,
+						SourcePath: op.BeforePath,
+						SourceHash: result.sourceHash,
+						Format:     format,
+						Settings:   settings,
+						Created:    time.Now(),
+					})
+				})
+			})
+		}
+		if result.tmp != "" {
+			os.RemoveAll(result.tmp)
+		}
+		<-running
+		if err != nil {
+			failed++
+		}
+		report(p, err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be converted", failed)
+	}
+	return nil
+}
+
+// encodeTemporary hashes the source of p and converts it into a new temporary
+// directory next to the destination of p, which is removed again on failure.
+func encodeTemporary(p Placement, convert func(src string, dst string) error) converted {
+	var result converted
+	if result.sourceHash, result.err = data.HashFile(p.Source); result.err != nil {
+		return result
+	}
+	if result.err = os.MkdirAll(filepath.Dir(p.Dest), 0o755); result.err != nil {
+		return result
+	}
+	if result.tmp, result.err = os.MkdirTemp(filepath.Dir(p.Dest), ".musiman-convert-"); result.err != nil {
+		return result
+	}
+	result.path = filepath.Join(result.tmp, filepath.Base(p.Dest))
+	if result.err = convert(p.Source, result.path); result.err != nil {
+		os.RemoveAll(result.tmp)
+		result.tmp = ""
+	}
+	return result
+}
+
+// convertFile encodes the audio of the music file at src as format into a new
+// file at dst and copies its tags and pictures.
+func convertFile(src string, dst string, format string, level int) error {
+	t, err := tags.Read(src)
+	if err != nil {
+		return err
+	}
+	pictures, err := tags.ReadPictures(src)
+	if err != nil {
+		return err
+	}
+	d, err := decode.Open(src)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	bits := d.BitsPerSample()
+	switch {
+	case bits == 0: // lossy
+		bits = 16
+	case bits > 24:
+		bits = 24
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var e encode.Encoder
+	switch format {
+	case FORMAT_FLAC:
+		e, err = encode.NewFLAC(f, encode.Format{SampleRate: d.SampleRate(), Channels: d.Channels(), BitsPerSample: bits}, level)
+	case FORMAT_WAV:
+		var wav *encode.WAV
+		// WAV samples are whole bytes
+		wav, err = encode.NewWAV(f, encode.Format{SampleRate: d.SampleRate(), Channels: d.Channels(), BitsPerSample: (bits + 7) / 8 * 8})
+		if err == nil {
+			wav.AddChunk("id3 ", tags.ID3v2(t, pictures))
+		}
+		e = wav
+	}
+	if err != nil {
+		return err
+	}
+	if err := encode.Encode(e, d); err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if format == FORMAT_FLAC {
+		return copyTags(dst, t, pictures)
+	}
+	return nil
+}
+
+// transcodeFile encodes the audio of the music file at src with the external
+// encoder of profile into a new file at dst and copies its tags and pictures
+// if possible.
+func transcodeFile(src string, dst string, profile transcode.Profile) error {
+	t, err := tags.Read(src)
+	if err != nil {
+		return err
+	}
+	pictures, err := tags.ReadPictures(src)
+	if err != nil {
+		return err
+	}
+	d, err := decode.Open(src)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	e, err := transcode.Start(profile, dst, encode.Format{SampleRate: d.SampleRate(), Channels: d.Channels()})
+	if err != nil {
+		return err
+	}
+	if err := encode.Encode(e, d); err != nil {
+		e.Close()
+		return fmt.Errorf("%s: %w", src, err)
+	}
+
+	if err := tags.Writable(dst); errors.Is(err, tags.ErrUnsupportedFormat) {
+		return nil // i.e. MP4 files
+	} else if err != nil {
+		return err
+	}
+	return copyTags(dst, t, pictures)
+}
+
+// copyTags writes t and pictures to the music file at path.
+func copyTags(path string, t *tags.Tags, pictures []tags.Picture) error {
+	if err := tags.Write(path, t); err != nil {
+		return err
+	}
+	for _, p := range pictures {
+		if err := tags.SetPicture(path, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package library_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/audio/transcode"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+)
+
+// tagWAV appends an "id3 " chunk with t and pictures to the WAV file of
+// file and updates its hash.
+func tagWAV(t *testing.T, db *sqlx.DB, file schema.File, tagged *tags.Tags, pictures []tags.Picture) schema.File {
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		t.Fatalf("failed to read test file: %v", err)
+	}
+	tag := tags.ID3v2(tagged, pictures)
+	content = append(binary.LittleEndian.AppendUint32(append(content, "id3 "...), uint32(len(tag))), tag...)
+	binary.LittleEndian.PutUint32(content[4:], uint32(len(content)-8))
+	if err := os.WriteFile(file.Path, content, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if file.Hash, err = data.HashFile(file.Path); err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	if err := data.UpdateFileContent(db, file.Path, file.Hash, uint(len(content)), time.Now()); err != nil {
+		t.Fatalf("failed to update test file: %v", err)
+	}
+	return file
+}
+
+func TestConvert(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	out := t.TempDir()
+	journal.BackupDir = filepath.Join(out, "backups")
+
+	cover := tags.Picture{Type: tags.PICTURE_FRONT_COVER, MIME: "image/png", Data: []byte("cover")}
+	samples := tone(1, 440)
+	song := writeWAV(t, db, filepath.Join(dir, "song.wav"), samples, "")
+	song = tagWAV(t, db, song, &tags.Tags{Title: "Song", Artist: "Band", Track: 1}, []tags.Picture{cover})
+	if err := os.Mkdir(filepath.Join(dir, "disc 2"), 0o755); err != nil {
+		t.Fatalf("failed to create test directory: %v", err)
+	}
+	writeWAV(t, db, filepath.Join(dir, "disc 2", "other.wav"), tone(1, 660), "")
+	broken := filepath.Join(dir, "broken.wav")
+	if err := os.WriteFile(broken, []byte("not audio at all"), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	hash, err := data.HashFile(broken)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	if err := data.SaveFile(db, schema.File{Path: broken, Hash: hash, MediaType: "wav", Size: 16, Mod: time.Now()}); err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+
+	plan, err := library.PlanConvert(db, dir, out, library.FORMAT_FLAC)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan) != 3 || plan[0].Skipped == nil {
+		t.Fatalf("expected broken.wav to be skipped, but got %+v", plan)
+	}
+	converted := filepath.Join(out, "song.flac")
+	if plan[1].Dest != filepath.Join(out, "disc 2", "other.flac") || plan[2].Dest != converted {
+		t.Errorf("expected the converted files to keep their relative path, but got %+v", plan)
+	}
+
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("failed to create journal: %v", err)
+	}
+	err = library.Convert(j, plan, library.FORMAT_FLAC, 5, 2, func(p library.Placement, err error) {
+		if err != nil {
+			t.Errorf("unexpected error converting %s: %v", p.Source, err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d, err := decode.Open(converted)
+	if err != nil {
+		t.Fatalf("expected a FLAC file, but got %v", err)
+	}
+	var decoded []int16
+	buf := make([]int16, 4096)
+	for err == nil {
+		var n int
+		n, err = decode.ReadInt16(d, buf)
+		decoded = append(decoded, buf[:n]...)
+	}
+	d.Close()
+	if err != io.EOF || len(decoded) != len(samples) {
+		t.Fatalf("expected %d samples, but got %d and %v", len(samples), len(decoded), err)
+	}
+	for i := range samples {
+		if decoded[i] != samples[i] {
+			t.Fatalf("expected sample %d to be %d, but got %d", i, samples[i], decoded[i])
+		}
+	}
+	tagged, err := tags.Read(converted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tagged.Title != "Song" || tagged.Artist != "Band" || tagged.Track != 1 {
+		t.Errorf("expected the tags to be copied, but got %+v", tagged)
+	}
+	pictures, err := tags.ReadPictures(converted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pictures) != 1 || string(pictures[0].Data) != "cover" {
+		t.Errorf("expected the cover to be copied, but got %+v", pictures)
+	}
+
+	file, err := data.GetFile(db, converted)
+	if err != nil {
+		t.Fatalf("expected the converted file to be known, but got %v", err)
+	}
+	derivation, err := data.GetDerivation(db, converted)
+	if err != nil {
+		t.Fatalf("expected a derivation, but got %v", err)
+	}
+	if derivation.SourcePath != song.Path || string(derivation.SourceHash) != string(song.Hash) || string(derivation.Hash) != string(file.Hash) || derivation.Settings != "level 5" {
+		t.Errorf("expected %s to be derived from %s, but got %+v", converted, song.Path, derivation)
+	}
+
+	// Converted files are up to date, the converted FLAC files are skipped
+	// when converting the output
+	plan, err = library.PlanConvert(db, dir, out, library.FORMAT_FLAC)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan[1].UpToDate || !plan[2].UpToDate {
+		t.Errorf("expected the converted files to be up to date, but got %+v", plan)
+	}
+	plan, err = library.PlanConvert(db, out, "", library.FORMAT_FLAC)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan) != 2 || !errors.Is(plan[0].Skipped, library.ErrSameFormat) {
+		t.Errorf("expected FLAC files to be skipped, but got %+v", plan)
+	}
+
+	if _, err := journal.UndoBatch(db, j.Batch()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(converted); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the converted file to be deleted, but got %v", err)
+	}
+}
+
+func TestConvertToWAV(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+
+	samples := tone(1, 440)
+	song := writeWAV(t, db, filepath.Join(dir, "song.wav"), samples, "")
+	tagWAV(t, db, song, &tags.Tags{Title: "Song"}, nil)
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("failed to create journal: %v", err)
+	}
+
+	// To FLAC and back again, next to the source
+	for _, format := range []string{library.FORMAT_FLAC, library.FORMAT_WAV} {
+		plan, err := library.PlanConvert(db, dir, filepath.Join(dir, format), format)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := library.Convert(j, plan, format, 8, 1, func(p library.Placement, err error) {}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		dir = filepath.Join(dir, format)
+	}
+	converted := filepath.Join(dir, "song.wav")
+	tagged, err := tags.Read(converted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tagged.Title != "Song" {
+		t.Errorf("expected the title to survive both conversions, but got %+v", tagged)
+	}
+	original, err := data.HashAudio(song.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := data.HashAudio(converted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(original) != string(result) {
+		t.Errorf("expected the same audio data after converting to FLAC and back")
+	}
+}
+
+// fakeEncoder returns a transcoding profile running a shell script standing
+// in for an external encoder.
+func fakeEncoder(t *testing.T, script string) transcode.Profile {
+	path := filepath.Join(t.TempDir(), "encoder")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatalf("failed to write fake encoder: %v", err)
+	}
+	return transcode.Profile{Name: "fake", Extension: "mp3", MediaType: "mp3", Command: []string{path, transcode.PLACEHOLDER_OUTPUT}}
+}
+
+func TestTranscode(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	out := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+
+	samples := tone(1, 440)
+	song := writeWAV(t, db, filepath.Join(dir, "song.wav"), samples, "")
+	writeWAV(t, db, filepath.Join(dir, "other.wav"), tone(1, 660), "")
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("failed to create journal: %v", err)
+	}
+
+	failing := fakeEncoder(t, `echo "no encoder for you" >&2; exit 4`)
+	plan, err := library.PlanTranscode(db, dir, out, failing)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var failed []string
+	err = library.Transcode(j, plan, failing, 2, func(p library.Placement, err error) {
+		if !errors.Is(err, transcode.ErrEncoderFailed) || !strings.Contains(err.Error(), "exited with status 4: no encoder for you") {
+			t.Errorf("expected the encoder to fail, but got %v", err)
+		}
+		failed = append(failed, p.Source)
+	})
+	if err == nil || len(failed) != 2 {
+		t.Fatalf("expected both files to fail, but got %v and %v", failed, err)
+	}
+	if entries, err := os.ReadDir(out); err != nil || len(entries) != 0 {
+		t.Errorf("expected no files to be left behind, but got %v and %v", entries, err)
+	}
+
+	// Copies the piped WAV stream
+	copying := fakeEncoder(t, `cat > "$1"`)
+	plan, err = library.PlanTranscode(db, dir, out, copying)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var reported []string
+	err = library.Transcode(j, plan, copying, 2, func(p library.Placement, err error) {
+		if err != nil {
+			t.Errorf("unexpected error transcoding %s: %v", p.Source, err)
+		}
+		reported = append(reported, p.Source)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reported) != 2 || reported[0] != plan[0].Source || reported[1] != plan[1].Source {
+		t.Errorf("expected the files to be reported in the order of the plan, but got %v", reported)
+	}
+
+	transcoded := filepath.Join(out, "song.mp3")
+	original, err := data.HashAudio(song.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := data.HashAudio(transcoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(original) != string(result) {
+		t.Errorf("expected the piped audio data to be unchanged")
+	}
+	file, err := data.GetFile(db, transcoded)
+	if err != nil || file.MediaType != "mp3" {
+		t.Fatalf("expected the transcoded file to be known, but got %+v and %v", file, err)
+	}
+	derivation, err := data.GetDerivation(db, transcoded)
+	if err != nil {
+		t.Fatalf("expected a derivation, but got %v", err)
+	}
+	if derivation.SourcePath != song.Path || derivation.Format != "fake" || derivation.Settings != copying.String() {
+		t.Errorf("expected %s to be transcoded from %s, but got %+v", transcoded, song.Path, derivation)
+	}
+	if entries, err := os.ReadDir(out); err != nil || len(entries) != 2 {
+		t.Errorf("expected only the transcoded files, but got %v and %v", entries, err)
+	}
+
+	plan, err = library.PlanTranscode(db, dir, out, copying)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan[0].UpToDate || !plan[1].UpToDate {
+		t.Errorf("expected the transcoded files to be up to date, but got %+v", plan)
+	}
+}
@@ -0,0 +1,419 @@
+package library
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio"
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/encode"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/cue"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+var ErrBeyondAudio = errors.New("cue track starts or ends after the end of the audio file")
+
+// CueTracks returns the virtual tracks of the cue sheet at cuePath. Audio
+// files are resolved relative to the directory of the cue sheet. If a file
+// does not exist, a music file with the same name but another extension is
+// used, as albums are often converted without updating their cue sheet. A
+// track ends where the next track of the same file starts, the last one at
+// the end of its file.
+func CueTracks(cuePath string) ([]schema.CueTrack, error) {
+	cuePath, err := filepath.Abs(cuePath)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := cue.ReadFile(cuePath)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(sheet.Files))
+	for i, f := range sheet.Files {
+		if paths[i], err = resolveCueFile(filepath.Dir(cuePath), f.Name); err != nil {
+			return nil, fmt.Errorf("%s: %w", cuePath, err)
+		}
+	}
+
+	tracks := make([]schema.CueTrack, 0, len(sheet.Tracks))
+	for i, t := range sheet.Tracks {
+		start, _ := t.Start() // Parse ensures every track has one
+		track := schema.CueTrack{
+			CuePath:        cuePath,
+			Track:          t.Number,
+			Path:           paths[start.File],
+			Title:          t.Title,
+			Performer:      t.Performer,
+			Album:          sheet.Title,
+			AlbumPerformer: sheet.Performer,
+			Date:           sheet.Rem["DATE"],
+			Genre:          sheet.Rem["GENRE"],
+			Start:          start.Offset,
+		}
+		if track.Performer == "" {
+			track.Performer = sheet.Performer
+		}
+		if i+1 < len(sheet.Tracks) {
+			if next, _ := sheet.Tracks[i+1].Start(); next.File == start.File {
+				end := next.Offset
+				track.End = &end
+			}
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// resolveCueFile returns the path of the audio file called name in dir.
+func resolveCueFile(dir string, name string) (string, error) {
+	// Cue sheets made on Windows may use backslashes
+	path := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(name, "\\", "/")))
+	if filepath.IsAbs(name) {
+		path = name
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for extension := range audio.MUSIC_FILE_TYPES {
+		if _, err := os.Stat(base + "." + extension); err == nil {
+			return base + "." + extension, nil
+		}
+	}
+	return "", fmt.Errorf("%w: audio file \"%s\"", os.ErrNotExist, name)
+}
+
+// ImportCues registers the tracks of every cue sheet below dir, replacing the
+// ones registered before. It returns the number of tracks by cue sheet and
+// the cue sheets which could not be imported with the reason.
+func ImportCues(db *sqlx.DB, dir string) (map[string]int, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	imported := map[string]int{}
+	failed := map[string]error{}
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			failed[path] = err
+			return nil
+		}
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".cue") {
+			return nil
+		}
+		tracks, err := CueTracks(path)
+		if err != nil {
+			failed[path] = err
+			return nil
+		}
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := data.SaveCueTracks(tx, path, tracks); err != nil {
+			failed[path] = err
+			return nil
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		imported[path] = len(tracks)
+		return nil
+	})
+	return imported, failed, err
+}
+
+// CueSplit is the planned file of a single track of a cue sheet.
+type CueSplit struct {
+	Placement
+	Track schema.CueTrack
+	// Number of tracks of the cue sheet
+	TrackTotal int
+}
+
+// Tags returns the tags of the split file, as given by the cue sheet.
+func (s CueSplit) Tags() *tags.Tags {
+	return &tags.Tags{
+		Title:       s.Track.Title,
+		Artist:      s.Track.Performer,
+		AlbumArtist: s.Track.AlbumPerformer,
+		Album:       s.Track.Album,
+		Date:        s.Track.Date,
+		Track:       s.Track.Track,
+		TrackTotal:  s.TrackTotal,
+		Genre:       s.Track.Genre,
+	}
+}
+
+// settings returns the settings of the derivation of the split file, which
+// identify the part of the audio file it was made from.
+func (s CueSplit) settings(level int) string {
+	end := "end"
+	if s.Track.End != nil {
+		end = strconv.FormatInt(*s.Track.End, 10)
+	}
+	return fmt.Sprintf("level %d, track %d, frames %d-%s", level, s.Track.Track, s.Track.Start, end)
+}
+
+// PlanCueSplit determines the FLAC file of every track of the cue sheet at
+// cuePath by filling in template with the tags of the track and sanitizing
+// the result for the filesystem described by profile. The files are placed in
+// out, or next to the cue sheet if out is empty. Tracks split before from the
+// same audio with the same level are up to date.
+func PlanCueSplit(db sqlx.Queryer, cuePath string, out string, template *pathtemplate.Template, profile sanitize.Profile, level int) ([]CueSplit, error) {
+	if strings.Contains(template.String(), "/") {
+		return nil, ErrTemplateHasDirectories
+	}
+	tracks, err := CueTracks(cuePath)
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		out = filepath.Dir(cuePath)
+	}
+	if out, err = filepath.Abs(out); err != nil {
+		return nil, err
+	}
+
+	sourceHashes := map[string][]byte{}
+	plan := make([]CueSplit, 0, len(tracks))
+	claimed := map[string]int{} // lower case destination -> track
+	for _, track := range tracks {
+		plan = append(plan, CueSplit{Placement: Placement{Source: track.Path}, Track: track, TrackTotal: len(tracks)})
+		current := &plan[len(plan)-1]
+
+		name, err := template.Execute(current.Tags().Fields())
+		if err != nil {
+			current.Skipped = err
+			continue
+		}
+		current.Dest = filepath.Join(out, profile.Component(name+"."+FORMAT_FLAC))
+		if err := data.ValidatePath(current.Dest); err != nil {
+			current.Skipped = fmt.Errorf("%w: %s: %w (use --sanitize strict)", data.ErrInvalidPath, current.Dest, err)
+			continue
+		}
+		key := strings.ToLower(current.Dest)
+		if other, ok := claimed[key]; ok {
+			current.Skipped = fmt.Errorf("%w: %s is already planned for track %d", ErrConflict, current.Dest, other)
+			continue
+		}
+		claimed[key] = track.Track
+
+		if _, err := os.Lstat(current.Dest); err == nil {
+			derivation, err := data.GetDerivation(db, current.Dest)
+			if err == nil && derivation.Format == FORMAT_FLAC && derivation.Settings == current.settings(level) {
+				hash, found := sourceHashes[track.Path]
+				if !found {
+					hash, _ = data.HashFile(track.Path)
+					sourceHashes[track.Path] = hash
+				}
+				current.UpToDate = bytes.Equal(hash, derivation.SourceHash)
+			}
+			if !current.UpToDate {
+				current.Skipped = fmt.Errorf("%w: %s already exists", ErrConflict, current.Dest)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// SplitCue encodes the tracks of plan which are neither skipped nor up to
+// date into FLAC files with the compression level level, keeping the sample
+// size. Each audio file is decoded only once for all its tracks, so the
+// samples are split exactly. The tags of the tracks and the pictures of the
+// audio file are written to the split files. Every file is recorded in j,
+// added to the files table and linked to the audio file as derivation. report
+// is called for each split file with the result.
+func SplitCue(j *journal.Journal, plan []CueSplit, level int, report func(s CueSplit, err error)) error {
+	if level < encode.MIN_LEVEL || level > encode.MAX_LEVEL {
+		return fmt.Errorf("%w: %d is not between %d and %d", encode.ErrInvalidLevel, level, encode.MIN_LEVEL, encode.MAX_LEVEL)
+	}
+	var failed int
+	var r *trackReader
+	defer func() {
+		if r != nil {
+			r.Close()
+		}
+	}()
+	for _, s := range plan {
+		if s.Skipped != nil || s.UpToDate {
+			continue
+		}
+		if r != nil && r.path != s.Source {
+			r.Close()
+			r = nil
+		}
+		err := j.Transaction(func() error {
+			if r == nil {
+				var err error
+				if r, err = openTrackReader(s.Source); err != nil {
+					return err
+				}
+			}
+			op, err := j.Convert(s.Source, s.Dest, func(src string, dst string) error {
+				return r.split(s, dst, level)
+			})
+			if err != nil {
+				return err
+			}
+			info, err := os.Stat(op.AfterPath)
+			if err != nil {
+				return err
+			}
+			return j.Update(func(tx *sqlx.Tx) error {
+				file := schema.File{Path: op.AfterPath, Hash: op.AfterHash, MediaType: FORMAT_FLAC, Size: uint(info.Size()), Mod: info.ModTime()}
+				if err := data.UpsertFile(tx, file); err != nil {
+					return err
+				}
+				return data.SaveDerivation(tx, schema.Derivation{
+					Path:       op.AfterPath,
+					Hash:       op.AfterHash,
+					SourcePath: op.BeforePath,
+					SourceHash: r.hash,
+					Format:     FORMAT_FLAC,
+					Settings:   s.settings(level),
+					Created:    time.Now(),
+				})
+			})
+		})
+		if err != nil {
+			failed++
+		}
+		report(s, err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d tracks could not be split", failed)
+	}
+	return nil
+}
+
+// trackReader decodes an audio file from start to end for splitting it into
+// tracks, which are expected in ascending order.
+type trackReader struct {
+	path     string
+	hash     []byte
+	pictures []tags.Picture
+	d        *decode.File
+	position int64 // in samples per channel
+}
+
+func openTrackReader(path string) (*trackReader, error) {
+	r := &trackReader{path: path}
+	var err error
+	if r.hash, err = data.HashFile(path); err != nil {
+		return nil, err
+	}
+	if r.pictures, err = tags.ReadPictures(path); err != nil && !errors.Is(err, tags.ErrUnsupportedFormat) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *trackReader) Close() error {
+	if r.d == nil {
+		return nil
+	}
+	err := r.d.Close()
+	r.d = nil
+	return err
+}
+
+// split encodes the samples of the track of s into a new FLAC file at dst.
+func (r *trackReader) split(s CueSplit, dst string, level int) error {
+	// Decoders can not seek, so tracks before the current position, i.e.
+	// after a failed track, are decoded from the start again
+	if r.d == nil || cue.Samples(s.Track.Start, r.d.SampleRate()) < r.position {
+		r.Close()
+		d, err := decode.Open(r.path)
+		if err != nil {
+			return err
+		}
+		r.d, r.position = d, 0
+	}
+	start := cue.Samples(s.Track.Start, r.d.SampleRate())
+	end := int64(-1)
+	if s.Track.End != nil {
+		end = cue.Samples(*s.Track.End, r.d.SampleRate())
+	}
+
+	bits := r.d.BitsPerSample()
+	switch {
+	case bits == 0: // lossy
+		bits = 16
+	case bits > 24:
+		bits = 24
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	e, err := encode.NewFLAC(f, encode.Format{SampleRate: r.d.SampleRate(), Channels: r.d.Channels(), BitsPerSample: bits}, level)
+	if err != nil {
+		return err
+	}
+
+	if err := r.read(start, nil); err != nil {
+		r.Close() // position unknown
+		return err
+	}
+	if err := r.read(end, e.Write); err != nil {
+		r.Close()
+		return err
+	}
+	if err := e.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return copyTags(dst, s.Tags(), r.pictures)
+}
+
+// read decodes the samples up to the position until, or up to the end of the
+// file if until is negative, and passes them to write unless it is nil.
+func (r *trackReader) read(until int64, write func(samples []float32) error) error {
+	channels := r.d.Channels()
+	buf := make([]float32, 4096*channels)
+	for until < 0 || r.position < until {
+		frames := int64(len(buf) / channels)
+		if until >= 0 {
+			frames = min(frames, until-r.position)
+		}
+		n, err := r.d.Read(buf[:frames*int64(channels)])
+		if n > 0 {
+			r.position += int64(n / channels)
+			if write != nil {
+				if err := write(buf[:n]); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			if until >= 0 {
+				return fmt.Errorf("%w: %s", ErrBeyondAudio, r.path)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package library_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/encode"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/cue"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+// Two tracks in a single file and a third in its own file, whose name lacks
+// the extension of the converted file
+const albumCue = `PERFORMER "Band"
+TITLE "Album"
+REM DATE 1999
+FILE "album.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "Song"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Other Song"
+    PERFORMER "Guest"
+    INDEX 00 00:00:30
+    INDEX 01 00:00:40
+FILE "last.wav" WAVE
+  TRACK 03 AUDIO
+    TITLE "Last"
+    INDEX 01 00:00:00
+`
+
+// decodeAll returns all samples of the music file at path.
+func decodeAll(t *testing.T, path string) []float32 {
+	d, err := decode.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer d.Close()
+	var samples []float32
+	buf := make([]float32, 1024)
+	for {
+		n, err := d.Read(buf)
+		samples = append(samples, buf[:n]...)
+		if err == io.EOF {
+			return samples
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestImportCues(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	writeWAV(t, db, filepath.Join(dir, "album.wav"), tone(1, 440), "")
+	writeWAV(t, db, filepath.Join(dir, "last.flac"), tone(1, 660), "") // converted after ripping
+	cuePath := filepath.Join(dir, "album.cue")
+	if err := os.WriteFile(cuePath, []byte(albumCue), 0o644); err != nil {
+		t.Fatalf("failed to write cue sheet: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.cue"), []byte("FILE \"missing.wav\" WAVE\nTRACK 01 AUDIO\nINDEX 01 00:00:00\n"), 0o644); err != nil {
+		t.Fatalf("failed to write cue sheet: %v", err)
+	}
+
+	imported, failed, err := library.ImportCues(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(imported) != 1 || imported[cuePath] != 3 {
+		t.Errorf("expected 3 tracks of %s, but got %v", cuePath, imported)
+	}
+	if err := failed[filepath.Join(dir, "broken.cue")]; !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing audio file, but got %v", err)
+	}
+
+	tracks, err := data.GetCueTracks(db, cuePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracks) != 3 {
+		t.Fatalf("expected 3 tracks, but got %d", len(tracks))
+	}
+	if tr := tracks[0]; tr.Path != filepath.Join(dir, "album.wav") || tr.Start != 0 || tr.End == nil || *tr.End != 40 || tr.Performer != "Band" || tr.Date != "1999" {
+		t.Errorf("expected track 1 to end where track 2 starts, but got %+v", tr)
+	}
+	if tr := tracks[1]; tr.Start != 40 || tr.End != nil || tr.Performer != "Guest" || tr.AlbumPerformer != "Band" {
+		t.Errorf("expected track 2 to last until the end of the file, but got %+v", tr)
+	}
+	if tr := tracks[2]; tr.Path != filepath.Join(dir, "last.flac") || tr.Start != 0 || tr.End != nil {
+		t.Errorf("expected track 3 in the converted file, but got %+v", tr)
+	}
+}
+
+func TestSplitCue(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	out := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+
+	album := writeWAV(t, db, filepath.Join(dir, "album.wav"), tone(1, 440), "")
+	last := writeWAV(t, db, filepath.Join(dir, "last.wav"), tone(1, 660), "")
+	cuePath := filepath.Join(dir, "album.cue")
+	if err := os.WriteFile(cuePath, []byte(albumCue), 0o644); err != nil {
+		t.Fatalf("failed to write cue sheet: %v", err)
+	}
+
+	template, err := pathtemplate.Parse("{track:02} {title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan, err := library.PlanCueSplit(db, cuePath, out, template, sanitize.POSIX, encode.DEFAULT_LEVEL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("failed to create journal: %v", err)
+	}
+	var reported int
+	err = library.SplitCue(j, plan, encode.DEFAULT_LEVEL, func(s library.CueSplit, err error) {
+		if err != nil {
+			t.Errorf("unexpected error splitting track %d: %v", s.Track.Track, err)
+		}
+		reported++
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reported != 3 {
+		t.Errorf("expected 3 split tracks, but got %d", reported)
+	}
+
+	// 40 frames at 11025 Hz are 5880 samples
+	source := decodeAll(t, album.Path)
+	expected := map[string][]float32{
+		"01 Song.flac":       source[:5880],
+		"02 Other Song.flac": source[5880:],
+		"03 Last.flac":       decodeAll(t, last.Path),
+	}
+	for name, samples := range expected {
+		path := filepath.Join(out, name)
+		result := decodeAll(t, path)
+		if len(result) != len(samples) {
+			t.Errorf("expected %d samples in %s, but got %d", len(samples), name, len(result))
+			continue
+		}
+		for i := range samples {
+			if result[i] != samples[i] {
+				t.Errorf("expected sample %d of %s to be %v, but got %v", i, name, samples[i], result[i])
+				break
+			}
+		}
+		if _, err := data.GetDerivation(db, path); err != nil {
+			t.Errorf("expected a derivation of %s, but got %v", name, err)
+		}
+	}
+
+	tagged, err := tags.Read(filepath.Join(out, "02 Other Song.flac"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tagged.Title != "Other Song" || tagged.Artist != "Guest" || tagged.AlbumArtist != "Band" || tagged.Album != "Album" || tagged.Track != 2 || tagged.TrackTotal != 3 || tagged.Date != "1999" {
+		t.Errorf("expected the tags of the cue sheet, but got %+v", tagged)
+	}
+
+	plan, err = library.PlanCueSplit(db, cuePath, out, template, sanitize.POSIX, encode.DEFAULT_LEVEL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range plan {
+		if !s.UpToDate {
+			t.Errorf("expected track %d to be up to date, but got %+v", s.Track.Track, s)
+		}
+	}
+}
+
+func TestPlanCueSplitWithoutTracks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	cuePath := filepath.Join(t.TempDir(), "empty.cue")
+	if err := os.WriteFile(cuePath, []byte("PERFORMER \"Band\"\nTITLE \"Album\"\n"), 0o644); err != nil {
+		t.Fatalf("failed to write cue sheet: %v", err)
+	}
+	template, err := pathtemplate.Parse("{track:02} {title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := library.PlanCueSplit(db, cuePath, "", template, sanitize.POSIX, encode.DEFAULT_LEVEL); !errors.Is(err, cue.ErrSyntax) {
+		t.Errorf("expected error %v, but got %v", cue.ErrSyntax, err)
+	}
+}
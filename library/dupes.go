@@ -0,0 +1,124 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/chromaprint"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+var ErrInvalidThreshold = errors.New("invalid similarity threshold")
+
+// DuplicateGroup is a set of files with the same content or recording.
+type DuplicateGroup struct {
+	Files []schema.File // ordered by path
+	// Lowest similarity of the fingerprints linking the files into this
+	// group, 1 for identical content
+	Similarity float64
+}
+
+// FindDuplicates groups the known files below dir with identical content.
+func FindDuplicates(db sqlx.Queryer, dir string) ([]DuplicateGroup, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byHash := map[string][]schema.File{}
+	for _, file := range files {
+		byHash[string(file.Hash)] = append(byHash[string(file.Hash)], file)
+	}
+	var groups []DuplicateGroup
+	for _, file := range files { // in path order
+		if group := byHash[string(file.Hash)]; len(group) > 1 && group[0].Path == file.Path {
+			groups = append(groups, DuplicateGroup{Files: group, Similarity: 1})
+		}
+	}
+	return groups, nil
+}
+
+// FindAcousticDuplicates groups the known files below dir whose fingerprints
+// have a similarity of at least threshold, so the same recording is found in
+// different formats and bitrates. Missing fingerprints are calculated first,
+// files which can not be fingerprinted are returned with the reason.
+func FindAcousticDuplicates(db sqlx.Ext, dir string, threshold float64) ([]DuplicateGroup, map[string]error, error) {
+	if threshold <= 0.5 || threshold > 1 {
+		return nil, nil, fmt.Errorf("%w: %v is not in (0.5, 1], unrelated audio has a similarity of about 0.5", ErrInvalidThreshold, threshold)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skipped := map[string]error{}
+	index := chromaprint.NewIndex()
+	var indexed []schema.File // by index ID
+	var prints [][]uint32
+	for _, file := range files {
+		fp, err := Fingerprint(db, file)
+		if err != nil {
+			skipped[file.Path] = err
+			continue
+		}
+		raw, err := chromaprint.Decode(fp.Fingerprint)
+		if err != nil {
+			skipped[file.Path] = err
+			continue
+		}
+		index.Add(raw)
+		indexed = append(indexed, file)
+		prints = append(prints, raw)
+	}
+
+	// Union-find over all matches, remembering the weakest link of each group
+	parent := make([]int, len(indexed))
+	similarity := make([]float64, len(indexed))
+	for i := range parent {
+		parent[i], similarity[i] = i, 1
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i, raw := range prints {
+		for _, m := range index.Search(raw, threshold) {
+			a, b := find(i), find(m.ID)
+			if a == b {
+				continue
+			}
+			parent[b] = a
+			similarity[a] = min(similarity[a], similarity[b], m.Similarity)
+		}
+	}
+
+	members := map[int][]schema.File{}
+	for i, file := range indexed {
+		root := find(i)
+		members[root] = append(members[root], file)
+	}
+	var groups []DuplicateGroup
+	for root, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, DuplicateGroup{Files: group, Similarity: similarity[root]})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Files[0].Path < groups[j].Files[0].Path })
+	return groups, skipped, nil
+}
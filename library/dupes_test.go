@@ -0,0 +1,104 @@
+package library_test
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/library"
+)
+
+// melody plays notes for half a second each at 11025 Hz, with noise of the
+// given amplitude standing in for the artifacts of lossy encoding.
+func melody(seconds int, notes []float64, noise float64) []int16 {
+	samples := make([]int16, seconds*11025)
+	seed := uint32(1)
+	for i := range samples {
+		t := float64(i) / 11025
+		v := 8000 * math.Sin(2*math.Pi*notes[int(t*2)%len(notes)]*t)
+		seed = seed*1664525 + 1013904223
+		v += noise * (float64(seed>>16)/65536 - 0.5)
+		samples[i] = int16(v)
+	}
+	return samples
+}
+
+func TestFindDuplicates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(dir, "b"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	writeWAV(t, db, filepath.Join(dir, "a.wav"), tone(1, 440), "")
+	writeWAV(t, db, filepath.Join(dir, "b", "a copy.wav"), tone(1, 440), "")
+	writeWAV(t, db, filepath.Join(dir, "c.wav"), tone(1, 440), "tagged")
+
+	groups, err := library.FindDuplicates(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Files) != 2 {
+		t.Fatalf("expected one group of two files, but got %v", groups)
+	}
+	if groups[0].Files[0].Path != filepath.Join(dir, "a.wav") || groups[0].Files[1].Path != filepath.Join(dir, "b", "a copy.wav") {
+		t.Errorf("expected the identical files, but got %q and %q", groups[0].Files[0].Path, groups[0].Files[1].Path)
+	}
+}
+
+func TestFindAcousticDuplicates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	notes := []float64{262, 330, 392, 523, 440, 349, 294, 247}
+	other := []float64{196, 247, 220, 294, 370, 330, 415, 277}
+	writeWAV(t, db, filepath.Join(dir, "a.wav"), melody(20, notes, 0), "")
+	writeWAV(t, db, filepath.Join(dir, "b.wav"), melody(20, notes, 2000), "")
+	writeWAV(t, db, filepath.Join(dir, "c.wav"), melody(20, other, 0), "")
+	broken := filepath.Join(dir, "d.wav")
+	if err := os.WriteFile(broken, []byte("not audio at all"), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	hash, err := data.HashFile(broken)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	if err := data.SaveFile(db, schema.File{Path: broken, Hash: hash, MediaType: "wav", Size: 16, Mod: time.Now()}); err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+
+	groups, skipped, err := library.FindAcousticDuplicates(db, dir, 0.9)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(groups) != 1 || len(groups[0].Files) != 2 {
+		t.Fatalf("expected one group of two files, but got %v", groups)
+	}
+	if groups[0].Files[0].Path != filepath.Join(dir, "a.wav") || groups[0].Files[1].Path != filepath.Join(dir, "b.wav") {
+		t.Errorf("expected the noisy copy to be grouped, but got %q and %q", groups[0].Files[0].Path, groups[0].Files[1].Path)
+	}
+	if s := groups[0].Similarity; s < 0.9 || s >= 1 {
+		t.Errorf("expected a similarity in [0.9, 1), but got %v", s)
+	}
+	if len(skipped) != 1 || skipped[broken] == nil {
+		t.Errorf("expected the broken file to be skipped, but got %v", skipped)
+	}
+}
+
+func TestFindAcousticDuplicatesInvalidThreshold(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, threshold := range []float64{0, 0.5, 1.1} {
+		if _, _, err := library.FindAcousticDuplicates(db, t.TempDir(), threshold); !errors.Is(err, library.ErrInvalidThreshold) {
+			t.Errorf("expected ErrInvalidThreshold for %v, but got %v", threshold, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package library
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/chromaprint"
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Fingerprint returns the acoustic fingerprint of a known file. The audio
+// data is hashed first, so files which only differ in their tags share one
+// stored fingerprint and are decoded only once. A stored audio hash of the
+// file is trusted.
+func Fingerprint(db sqlx.Ext, file schema.File) (schema.Fingerprint, error) {
+	if file.AudioHash != nil {
+		if fp, err := data.GetFingerprint(db, file.AudioHash); err == nil {
+			return fp, nil
+		}
+	}
+	audioHash, err := updateAudioHash(db, file)
+	if err != nil {
+		return schema.Fingerprint{}, err
+	}
+	fp, err := data.GetFingerprint(db, audioHash)
+	if err == nil {
+		return fp, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return schema.Fingerprint{}, err
+	}
+
+	d, err := decode.Open(file.Path)
+	if err != nil {
+		return schema.Fingerprint{}, err
+	}
+	defer d.Close()
+	raw, duration, err := chromaprint.Calculate(d)
+	if err != nil {
+		return schema.Fingerprint{}, fmt.Errorf("%s: %w", file.Path, err)
+	}
+	fp = schema.Fingerprint{
+		AudioHash:   audioHash,
+		Duration:    duration,
+		Fingerprint: chromaprint.Encode(raw),
+		Created:     time.Now(),
+	}
+	return fp, data.SaveFingerprint(db, fp)
+}
+
+// updateAudioHash hashes the audio data of a known file and stores the hash.
+func updateAudioHash(db sqlx.Execer, file schema.File) ([]byte, error) {
+	audioHash, err := data.HashAudio(file.Path)
+	if err != nil {
+		return nil, err
+	}
+	return audioHash, data.SetFileAudioHash(db, file.Path, audioHash)
+}
@@ -0,0 +1,108 @@
+package library_test
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/library"
+)
+
+// tone returns a mono tone of the given frequency at 11025 Hz.
+func tone(seconds int, freq float64) []int16 {
+	samples := make([]int16, seconds*11025)
+	for i := range samples {
+		samples[i] = int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/11025))
+	}
+	return samples
+}
+
+// writeWAV writes a mono 16 bit WAV file at 11025 Hz and registers it in the
+// files table. extra is appended as a chunk after the audio data.
+func writeWAV(t *testing.T, db *sqlx.DB, path string, samples []int16, extra string) schema.File {
+	const rate = 11025
+	var pcm []byte
+	for _, v := range samples {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(v))
+	}
+	format := binary.LittleEndian.AppendUint16(nil, 1) // PCM
+	format = binary.LittleEndian.AppendUint16(format, 1)
+	format = binary.LittleEndian.AppendUint32(format, rate)
+	format = binary.LittleEndian.AppendUint32(format, rate*2)
+	format = binary.LittleEndian.AppendUint16(format, 2)
+	format = binary.LittleEndian.AppendUint16(format, 16)
+
+	content := []byte("RIFF\x00\x00\x00\x00WAVE")
+	content = append(binary.LittleEndian.AppendUint32(append(content, "fmt "...), uint32(len(format))), format...)
+	content = append(binary.LittleEndian.AppendUint32(append(content, "data"...), uint32(len(pcm))), pcm...)
+	content = append(binary.LittleEndian.AppendUint32(append(content, "note"...), uint32(len(extra))), extra...)
+	binary.LittleEndian.PutUint32(content[4:], uint32(len(content)-8))
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	hash, err := data.HashFile(path)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	file := schema.File{Path: path, Hash: hash, MediaType: "wav", Size: uint(len(content)), Mod: time.Now()}
+	if err := data.SaveFile(db, file); err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+	return file
+}
+
+func TestFingerprint(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	// Same audio, different metadata
+	a := writeWAV(t, db, filepath.Join(dir, "a.wav"), tone(5, 440), "aa")
+	b := writeWAV(t, db, filepath.Join(dir, "b.wav"), tone(5, 440), "bbbb")
+	other := writeWAV(t, db, filepath.Join(dir, "c.wav"), tone(5, 660), "aa")
+
+	fpA, err := library.Fingerprint(db, a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fpA.Duration != 5 {
+		t.Errorf("expected a duration of 5, but got %v", fpA.Duration)
+	}
+	fpB, err := library.Fingerprint(db, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fpB.Fingerprint != fpA.Fingerprint || !fpB.Created.Equal(fpA.Created) {
+		t.Errorf("expected the stored fingerprint to be reused for the same audio")
+	}
+	fpOther, err := library.Fingerprint(db, other)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fpOther.Fingerprint == fpA.Fingerprint {
+		t.Errorf("expected a different fingerprint for different audio")
+	}
+
+	var count int
+	if err := db.Get(&count, `SELECT COUNT(*) FROM fingerprints`); err != nil {
+		t.Fatalf("failed to query database: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 stored fingerprints, but got %d", count)
+	}
+	file, err := data.GetFile(db, b.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(file.AudioHash) != string(fpA.AudioHash) {
+		t.Errorf("expected the audio hash of the file to be stored")
+	}
+}
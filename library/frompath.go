@@ -0,0 +1,111 @@
+package library
+
+import (
+	"maps"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/pathtemplate"
+)
+
+// TagUpdate is the planned tag change of a single file.
+type TagUpdate struct {
+	Path string
+	// Values extracted from the path, by lower case field name
+	Matched map[string]string
+	// The complete tags to write
+	Tags *tags.Tags
+	// Reason why the file is not tagged, nil if it will be tagged
+	Skipped error
+	// Set if the file already has all matched values
+	UpToDate bool
+}
+
+// PlanTagsFromPath determines the tags of every known file below dir by
+// matching its path without extension against template, which has to
+// describe the trailing directories and the file name. With keepExisting
+// only empty fields are filled in.
+func PlanTagsFromPath(db sqlx.Queryer, dir string, template *pathtemplate.Template, keepExisting bool) ([]TagUpdate, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]TagUpdate, 0, len(files))
+	for _, file := range files {
+		u := TagUpdate{Path: file.Path}
+		plan = append(plan, u)
+		current := &plan[len(plan)-1]
+
+		path := filepath.ToSlash(strings.TrimSuffix(file.Path, filepath.Ext(file.Path)))
+		current.Matched, err = template.Match(path)
+		if err != nil {
+			current.Skipped = err
+			continue
+		}
+		current.Tags, err = tags.Read(file.Path)
+		if err != nil {
+			current.Skipped = err
+			continue
+		}
+
+		before := explicitFields(current.Tags)
+		for name, value := range current.Matched {
+			if keepExisting && before[name] != "" {
+				continue
+			}
+			if err := current.Tags.SetField(name, value); err != nil {
+				current.Skipped = err
+				break
+			}
+		}
+		if current.Skipped == nil && maps.Equal(before, explicitFields(current.Tags)) {
+			current.UpToDate = true
+		}
+	}
+	return plan, nil
+}
+
+// explicitFields returns the fields of t without the album artist fallback.
+func explicitFields(t *tags.Tags) map[string]string {
+	fields := t.Fields()
+	if t.AlbumArtist == "" {
+		delete(fields, tags.FIELD_ALBUMARTIST)
+	}
+	return fields
+}
+
+// WriteTags writes the tags of all updates of plan which are neither skipped
+// nor up to date in a single journal transaction: either all files are
+// tagged or none. It returns the updates that were carried out.
+func WriteTags(j *journal.Journal, plan []TagUpdate) ([]TagUpdate, error) {
+	var done []TagUpdate
+	err := j.Transaction(func() error {
+		for _, u := range plan {
+			if u.Skipped != nil || u.UpToDate {
+				continue
+			}
+			_, err := j.WriteTags(u.Path, func(path string) error {
+				return tags.Write(path, u.Tags)
+			})
+			if err != nil {
+				return err
+			}
+			done = append(done, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
@@ -0,0 +1,85 @@
+package library_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+)
+
+func TestTagsFromPath(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+	album := filepath.Join(dir, "Band - Album")
+	if err := os.Mkdir(album, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	writeTaggedFLAC(t, db, filepath.Join(album, "01 First.flac"))
+	writeTaggedFLAC(t, db, filepath.Join(album, "02 Second.flac"), "ARTIST=Other")
+	writeTaggedFLAC(t, db, filepath.Join(album, "03 Third.flac"), "ARTIST=Band", "ALBUM=Album", "TRACKNUMBER=3", "TITLE=Third")
+	writeTaggedFLAC(t, db, filepath.Join(album, "bonus.flac"))
+
+	template, err := pathtemplate.Parse("{artist} - {album}/{track} {title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan, err := library.PlanTagsFromPath(db, dir, template, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan) != 4 {
+		t.Fatalf("expected 4 planned updates, but got %d", len(plan))
+	}
+	if !plan[2].UpToDate {
+		t.Errorf("expected %s to be up to date", plan[2].Path)
+	}
+	if !errors.Is(plan[3].Skipped, pathtemplate.ErrNoMatch) {
+		t.Errorf("expected %s to be skipped with ErrNoMatch, but got %v", plan[3].Path, plan[3].Skipped)
+	}
+
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	done, err := library.WriteTags(j, plan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(done) != 2 {
+		t.Errorf("expected 2 tagged files, but got %d", len(done))
+	}
+
+	first, err := tags.Read(plan[0].Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Artist != "Band" || first.Album != "Album" || first.Track != 1 || first.Title != "First" {
+		t.Errorf("expected tags from the path, but got %+v", first)
+	}
+	second, err := tags.Read(plan[1].Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.Artist != "Other" || second.Title != "Second" {
+		t.Errorf("expected existing artist %q to be kept and title %q, but got %q and %q", "Other", "Second", second.Artist, second.Title)
+	}
+
+	if _, err := journal.UndoBatch(db, j.Batch()); err != nil {
+		t.Fatalf("unexpected error undoing: %v", err)
+	}
+	first, err = tags.Read(plan[0].Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Title != "" {
+		t.Errorf("expected undo to remove the written title, but got %q", first.Title)
+	}
+}
@@ -0,0 +1,107 @@
+package library
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Problem recorded for files whose content changed silently
+const ROT_PROBLEM = "content hash differs from the last scan, although size and modification time did not change"
+
+// FsckResult is the outcome of checking the content of a single file.
+type FsckResult struct {
+	File schema.File
+	// Set if the size or modification time differs from the last scan, as
+	// the file was edited its content is not checked
+	Changed bool
+	// Set if the content hash differs from the last scan although size and
+	// modification time did not change, which indicates disk corruption
+	Rotten bool
+	// Known files with the content the rotten file had at the last scan,
+	// checked to still have it
+	Duplicates []schema.File
+}
+
+// Fsck re-hashes the known files below dir whose size and modification time
+// did not change since the last scan and reports those whose content hash
+// differs. Rotten files are recorded as corrupt, together with the known-good
+// duplicates they can be restored from. Files which can not be checked, like
+// missing ones, are returned with the reason.
+func Fsck(db sqlx.Ext, dir string) ([]FsckResult, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]error{}
+	checked := map[string]FsckResult{} // duplicates may be checked early
+	check := func(file schema.File) (FsckResult, error) {
+		if r, found := checked[file.Path]; found {
+			return r, nil
+		}
+		r, err := fsckFile(file)
+		if err == nil {
+			checked[file.Path] = r
+		}
+		return r, err
+	}
+
+	var results []FsckResult
+	for _, file := range files {
+		r, err := check(file)
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		if r.Rotten {
+			copies, err := data.GetFilesByHash(db, file.Hash)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, c := range copies {
+				if c.Path == file.Path {
+					continue
+				}
+				if cr, err := check(c); err == nil && !cr.Changed && !cr.Rotten {
+					r.Duplicates = append(r.Duplicates, c)
+				}
+			}
+			if err := data.SetFileHealth(db, file.Path, schema.HEALTH_CORRUPT, ROT_PROBLEM, time.Now()); err != nil {
+				return nil, nil, err
+			}
+		}
+		results = append(results, r)
+	}
+	return results, failed, nil
+}
+
+// fsckFile compares the content hash of file to the stored one, unless its
+// size or modification time changed since the last scan.
+func fsckFile(file schema.File) (FsckResult, error) {
+	r := FsckResult{File: file}
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return r, err
+	}
+	if uint(info.Size()) != file.Size || !info.ModTime().Equal(file.Mod) {
+		r.Changed = true
+		return r, nil
+	}
+	hash, err := data.HashFile(file.Path)
+	if err != nil {
+		return r, err
+	}
+	r.Rotten = !bytes.Equal(hash, file.Hash)
+	return r, nil
+}
@@ -0,0 +1,157 @@
+package library_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/scanner"
+)
+
+// scanned sets the modification time of file to the one stored for it, as a
+// scan would have found it.
+func scanned(t *testing.T, file schema.File) {
+	if err := os.Chtimes(file.Path, file.Mod, file.Mod); err != nil {
+		t.Fatalf("failed to set modification time: %v", err)
+	}
+}
+
+// flipByte changes a byte of the file at path, keeping its size and
+// modification time.
+func flipByte(t *testing.T, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat test file: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read test file: %v", err)
+	}
+	content[len(content)/2] ^= 0x01
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("failed to set modification time: %v", err)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	other := t.TempDir() // copies outside the checked directory are found as well
+
+	write := func(dir string, name string, extra string) schema.File {
+		file := writeWAV(t, db, filepath.Join(dir, name), tone(1, 440), extra)
+		scanned(t, file)
+		return file
+	}
+	rotten := write(dir, "rotten.wav", "a")
+	good := write(other, "copy.wav", "a")
+	brokenCopy := write(dir, "broken copy.wav", "a")
+	alone := write(dir, "alone.wav", "b")
+	edited := write(dir, "edited.wav", "c")
+	intact := write(dir, "intact.wav", "d")
+
+	flipByte(t, rotten.Path)
+	flipByte(t, brokenCopy.Path)
+	flipByte(t, alone.Path)
+	if err := os.WriteFile(edited.Path, []byte("new content"), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	results, failed, err := library.Fsck(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("expected no failed files, but got %v", failed)
+	}
+	byPath := map[string]library.FsckResult{}
+	for _, r := range results {
+		byPath[r.File.Path] = r
+	}
+	if len(byPath) != 5 {
+		t.Fatalf("expected 5 checked files, but got %d", len(byPath))
+	}
+	for _, file := range []schema.File{rotten, brokenCopy, alone} {
+		if r := byPath[file.Path]; !r.Rotten || r.Changed {
+			t.Errorf("expected %s to be rotten, but got %+v", file.Path, r)
+		}
+		expectHealth(t, db, file.Path, schema.HEALTH_CORRUPT)
+	}
+	if r := byPath[rotten.Path]; len(r.Duplicates) != 1 || r.Duplicates[0].Path != good.Path {
+		t.Errorf("expected %s as known-good duplicate, but got %v", good.Path, r.Duplicates)
+	}
+	if r := byPath[alone.Path]; len(r.Duplicates) != 0 {
+		t.Errorf("expected no duplicates, but got %v", r.Duplicates)
+	}
+	if r := byPath[edited.Path]; !r.Changed || r.Rotten {
+		t.Errorf("expected %s to be changed, but got %+v", edited.Path, r)
+	}
+	if r := byPath[intact.Path]; r.Changed || r.Rotten {
+		t.Errorf("expected %s to be intact, but got %+v", intact.Path, r)
+	}
+	expectHealth(t, db, intact.Path, "")
+
+	if err := os.Remove(intact.Path); err != nil {
+		t.Fatalf("failed to remove test file: %v", err)
+	}
+	if _, failed, _ := library.Fsck(db, dir); !os.IsNotExist(failed[intact.Path]) {
+		t.Errorf("expected a missing file, but got %v", failed[intact.Path])
+	}
+}
+
+func TestScanKeepsRottenFile(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "rotten.wav")
+	writeWAV(t, db, path, tone(1, 440), "")
+	if err := scanner.ScanDirForMusic(db, dir, 0, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file, err := data.GetFile(db, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	flipByte(t, path)
+	if _, _, err := library.Fsck(db, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := scanner.ScanDirForMusic(db, dir, 0, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rescanned, err := data.GetFile(db, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(rescanned.Hash, file.Hash) {
+		t.Errorf("expected the known-good hash %x, but got %x", file.Hash, rescanned.Hash)
+	}
+	expectHealth(t, db, path, schema.HEALTH_CORRUPT)
+	if rescanned.Problems != library.ROT_PROBLEM {
+		t.Errorf("expected problem %q, but got %q", library.ROT_PROBLEM, rescanned.Problems)
+	}
+}
+
+// expectHealth checks the recorded health of the file at path, "" for none.
+func expectHealth(t *testing.T, db *sqlx.DB, path string, health string) {
+	file, err := data.GetFile(db, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result := file.Health; health == "" && result != nil || health != "" && (result == nil || *result != health) {
+		t.Errorf("expected health %q for %s, but got %v", health, path, result)
+	}
+}
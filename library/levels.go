@@ -0,0 +1,105 @@
+package library
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/levels"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Levels returns the level statistics of a known file. Like loudness, they are
+// stored per audio data.
+func Levels(db sqlx.Ext, file schema.File) (levels.Stats, error) {
+	if file.AudioHash != nil {
+		if l, err := data.GetLevels(db, file.AudioHash); err == nil {
+			return decodeLevels(l), nil
+		}
+	}
+	audioHash, err := updateAudioHash(db, file)
+	if err != nil {
+		return levels.Stats{}, err
+	}
+	l, err := data.GetLevels(db, audioHash)
+	if err == nil {
+		return decodeLevels(l), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return levels.Stats{}, err
+	}
+
+	d, err := decode.Open(file.Path)
+	if err != nil {
+		return levels.Stats{}, err
+	}
+	defer d.Close()
+	s, err := levels.Analyze(d)
+	if err != nil {
+		return levels.Stats{}, fmt.Errorf("%s: %w", file.Path, err)
+	}
+	l = schema.Levels{
+		AudioHash:       audioHash,
+		Duration:        s.Duration,
+		LeadingSilence:  s.LeadingSilence,
+		TrailingSilence: s.TrailingSilence,
+		LongestGap:      s.LongestGap,
+		GapStart:        s.GapStart,
+		Clipped:         s.Clipped,
+		DCOffset:        s.DCOffset,
+		Peak:            s.Peak,
+		Created:         time.Now(),
+	}
+	return s, data.SaveLevels(db, l)
+}
+
+func decodeLevels(l schema.Levels) levels.Stats {
+	return levels.Stats{
+		Duration:        l.Duration,
+		LeadingSilence:  l.LeadingSilence,
+		TrailingSilence: l.TrailingSilence,
+		LongestGap:      l.LongestGap,
+		GapStart:        l.GapStart,
+		Clipped:         l.Clipped,
+		DCOffset:        l.DCOffset,
+		Peak:            l.Peak,
+	}
+}
+
+// FileLevels are the level statistics of a single file.
+type FileLevels struct {
+	File  schema.File
+	Stats levels.Stats
+}
+
+// AnalyzeLevels calculates the level statistics of all known files below dir,
+// in path order. Files which can not be analysed are returned with the
+// reason.
+func AnalyzeLevels(db sqlx.Ext, dir string) ([]FileLevels, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]error{}
+	var analysed []FileLevels
+	for _, file := range files {
+		s, err := Levels(db, file)
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		analysed = append(analysed, FileLevels{File: file, Stats: s})
+	}
+	return analysed, failed, nil
+}
@@ -0,0 +1,64 @@
+package library_test
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+)
+
+func TestAnalyzeLevels(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	// A hidden track after 10 seconds of silence
+	samples := make([]int16, 11025)
+	samples = append(samples, tone(2, 440)...)
+	samples = append(samples, make([]int16, 10*11025)...)
+	samples = append(samples, tone(1, 660)...)
+	hidden := writeWAV(t, db, filepath.Join(dir, "hidden.wav"), samples, "")
+	clipped := tone(1, 440)
+	for i := range clipped {
+		v := max(min(int(clipped[i])*4/3, 10000), -10000)
+		clipped[i] = int16(v * 32767 / 10000)
+	}
+	writeWAV(t, db, filepath.Join(dir, "loud.wav"), clipped, "")
+
+	analysed, failed, err := library.AnalyzeLevels(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 0 || len(analysed) != 2 {
+		t.Fatalf("expected 2 analysed files, but got %+v and %v", analysed, failed)
+	}
+	s := analysed[0].Stats
+	if math.Abs(s.LeadingSilence-1) > 0.02 || s.TrailingSilence != 0 || math.Abs(s.LongestGap-10) > 0.02 || math.Abs(s.GapStart-3) > 0.02 {
+		t.Errorf("expected 1 s leading silence and a gap of 10 s after 3 s, but got %+v", s)
+	}
+	if s.Clipped != 0 {
+		t.Errorf("expected hidden.wav not to be clipped, but got %d clipped samples", s.Clipped)
+	}
+	if s := analysed[1].Stats; s.Clipped == 0 || s.Peak < 0.999 {
+		t.Errorf("expected loud.wav to be clipped, but got %+v", s)
+	}
+
+	// Statistics are reused for the same audio data
+	hidden, err = data.GetFile(db, hidden.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l, err := data.GetLevels(db, hidden.AudioHash)
+	if err != nil {
+		t.Fatalf("expected stored statistics, but got %v", err)
+	}
+	l.Clipped = 7
+	if err := data.SaveLevels(db, l); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s, err := library.Levels(db, hidden); err != nil || s.Clipped != 7 {
+		t.Errorf("expected the stored statistics, but got %+v and %v", s, err)
+	}
+}
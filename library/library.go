@@ -0,0 +1,163 @@
+package library
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+// Mode determines how files are placed into the library.
+type Mode string
+
+const (
+	MODE_COPY     Mode = "copy"
+	MODE_MOVE     Mode = "move"
+	MODE_HARDLINK Mode = "hardlink"
+	MODE_REFLINK  Mode = "reflink"
+	MODE_SYMLINK  Mode = "symlink"
+)
+
+var MODES = []Mode{MODE_COPY, MODE_MOVE, MODE_HARDLINK, MODE_REFLINK, MODE_SYMLINK}
+
+var (
+	ErrUnknownMode = errors.New("unknown library mode")
+	ErrConflict    = errors.New("destination conflict")
+)
+
+func ParseMode(mode string) (Mode, error) {
+	for _, m := range MODES {
+		if string(m) == strings.ToLower(mode) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("%w: \"%s\"", ErrUnknownMode, mode)
+}
+
+// Placement is the planned destination of a single file.
+type Placement struct {
+	Source string
+	Dest   string
+	// Reason why the file is not placed, nil if it will be placed
+	Skipped error
+	// Set if a file with the same content already exists at Dest
+	UpToDate bool
+	// Set if Dest only differs in case from Source on a case insensitive
+	// filesystem
+	CaseOnly bool
+}
+
+// Plan determines the library path of every distinct file in the files table
+// by filling in template with its tags and sanitizing the result for the
+// filesystem described by profile. Files with identical content are placed
+// only once. When moving, files whose library path data.ValidatePath rejects
+// are skipped, as the moved file could not be recorded in the files table.
+func Plan(db sqlx.Queryer, dest string, template *pathtemplate.Template, profile sanitize.Profile, mode Mode) ([]Placement, error) {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, err
+	}
+	files, err := data.GetUniqueFiles(db)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]Placement, 0, len(files))
+	claimed := map[string]string{} // lower case destination -> source
+	for _, file := range files {
+		p := Placement{Source: file.Path}
+		plan = append(plan, p)
+		current := &plan[len(plan)-1]
+
+		t, err := tags.Read(file.Path)
+		if err != nil {
+			current.Skipped = err
+			continue
+		}
+		relative, err := template.Execute(t.Fields())
+		if err != nil {
+			current.Skipped = err
+			continue
+		}
+		relative = profile.Path(relative + strings.ToLower(filepath.Ext(file.Path)))
+		current.Dest = filepath.Join(dest, filepath.FromSlash(relative))
+		// Only moved files are recorded in the files table, the other modes
+		// leave the source in place
+		if err := data.ValidatePath(current.Dest); err != nil && mode == MODE_MOVE {
+			current.Skipped = fmt.Errorf("%w: %s: %w (use --sanitize strict)", data.ErrInvalidPath, current.Dest, err)
+			continue
+		}
+
+		// Case insensitive, so the plan also works on FAT and NTFS
+		key := strings.ToLower(current.Dest)
+		if other, ok := claimed[key]; ok {
+			current.Skipped = fmt.Errorf("%w: %s is already planned for %s", ErrConflict, current.Dest, other)
+			continue
+		}
+		claimed[key] = file.Path
+
+		if _, err := os.Lstat(current.Dest); err == nil {
+			hash, err := data.HashFile(current.Dest)
+			if err == nil && bytes.Equal(hash, file.Hash) {
+				current.UpToDate = true
+			} else {
+				current.Skipped = fmt.Errorf("%w: %s already exists", ErrConflict, current.Dest)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// Build places all files of plan which are neither skipped nor up to date
+// into the library. Every placement is recorded in j. report is called for
+// each placed file with the result of the placement.
+func Build(j *journal.Journal, plan []Placement, mode Mode, report func(p Placement, err error)) error {
+	var place func(src string, dst string) error
+	switch mode {
+	case MODE_COPY:
+		place = ignoreOperation(j.Copy)
+	case MODE_MOVE:
+		place = ignoreOperation(j.Move)
+	case MODE_HARDLINK:
+		place = ignoreOperation(j.Hardlink)
+	case MODE_REFLINK:
+		place = ignoreOperation(j.Reflink)
+	case MODE_SYMLINK:
+		place = ignoreOperation(j.Symlink)
+	default:
+		return fmt.Errorf("%w: \"%s\"", ErrUnknownMode, mode)
+	}
+
+	var failed int
+	for _, p := range plan {
+		if p.Skipped != nil || p.UpToDate {
+			continue
+		}
+		err := place(p.Source, p.Dest)
+		if err != nil {
+			failed++
+		}
+		report(p, err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be placed", failed)
+	}
+	return nil
+}
+
+func ignoreOperation[T any](fn func(src string, dst string) (T, error)) func(src string, dst string) error {
+	return func(src string, dst string) error {
+		_, err := fn(src, dst)
+		return err
+	}
+}
@@ -0,0 +1,73 @@
+package library_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+func TestPlanSkipsInvalidPaths(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	out := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+
+	live := filepath.Join(dir, "a.flac")
+	writeTaggedFLAC(t, db, live, "ARTIST=Band", "TITLE=Live (Remastered)")
+	writeTaggedFLAC(t, db, filepath.Join(dir, "b.flac"), "ARTIST=Band", "TITLE=Studio")
+
+	template, err := pathtemplate.Parse("{artist}/{title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Copies are not recorded in the files table, so any valid file name works
+	plan, err := library.Plan(db, out, template, sanitize.POSIX, library.MODE_COPY)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan) != 2 || plan[0].Skipped != nil || plan[0].Dest != filepath.Join(out, "Band", "Live (Remastered).flac") {
+		t.Fatalf("expected no skipped file when copying, but got %+v", plan)
+	}
+
+	plan, err = library.Plan(db, out, template, sanitize.POSIX, library.MODE_MOVE)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan) != 2 || !errors.Is(plan[0].Skipped, data.ErrInvalidPath) || plan[1].Skipped != nil {
+		t.Fatalf("expected only the title with parentheses to be skipped when moving, but got %+v", plan)
+	}
+
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("failed to create journal: %v", err)
+	}
+	if err := library.Build(j, plan, library.MODE_MOVE, func(p library.Placement, err error) {
+		if err != nil {
+			t.Errorf("unexpected error placing %s: %v", p.Source, err)
+		}
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(live); err != nil {
+		t.Errorf("expected the skipped file to stay in place, but got %v", err)
+	}
+	if _, err := data.GetFile(db, filepath.Join(out, "Band", "Studio.flac")); err != nil {
+		t.Errorf("expected the other file to be moved, but got %v", err)
+	}
+
+	plan, err = library.Plan(db, out, template, sanitize.STRICT, library.MODE_MOVE)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan[0].Skipped != nil || plan[0].Dest != filepath.Join(out, "Band", "Live _Remastered_.flac") {
+		t.Errorf("expected the strict profile to replace the parentheses, but got %+v", plan[0])
+	}
+}
@@ -0,0 +1,20 @@
+package library
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/acoustid"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// LookupAcoustID returns the AcoustIDs of a known file and their recordings,
+// the best match first. Its fingerprint is calculated if it is not stored yet.
+func LookupAcoustID(ctx context.Context, db sqlx.Ext, client *acoustid.Client, file schema.File) ([]acoustid.Result, error) {
+	fp, err := Fingerprint(db, file)
+	if err != nil {
+		return nil, err
+	}
+	return client.Lookup(ctx, fp.Fingerprint, fp.Duration)
+}
@@ -0,0 +1,36 @@
+package library_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/acoustid"
+	"github.com/makl11/musiman/acoustid/acoustidtest"
+	"github.com/makl11/musiman/library"
+)
+
+func TestLookupAcoustID(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := acoustidtest.NewServer()
+	defer server.Close()
+	client := acoustid.NewClient(acoustidtest.API_KEY, db)
+	client.BaseURL = server.URL
+
+	notes := []float64{262, 330, 392, 523, 440, 349, 294, 247}
+	file := writeWAV(t, db, filepath.Join(t.TempDir(), "a.wav"), melody(20, notes, 0), "")
+	fp, err := library.Fingerprint(db, file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server.Add(fp.Fingerprint, 20, acoustid.Result{ID: "match", Score: 0.9, Recordings: []acoustid.Recording{{ID: "recording", Title: "Melody"}}})
+
+	results, err := library.LookupAcoustID(context.Background(), db, client, file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].Recordings[0].Title != "Melody" {
+		t.Errorf("expected the registered recording, but got %v", results)
+	}
+}
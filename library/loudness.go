@@ -0,0 +1,237 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/loudness"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Reference loudness of gains in LUFS
+const (
+	REPLAYGAIN_REFERENCE = -18.0 // ReplayGain 2.0
+	R128_REFERENCE       = -23.0 // EBU R 128, used by R128_* tags
+)
+
+var ErrSilence = errors.New("silence has no loudness")
+
+// Loudness returns the loudness measurement of a known file. Like
+// fingerprints, measurements are stored per audio data, so files which only
+// differ in their tags are decoded only once.
+func Loudness(db sqlx.Ext, file schema.File) (loudness.Measurement, error) {
+	if file.AudioHash != nil {
+		if l, err := data.GetLoudness(db, file.AudioHash); err == nil {
+			return decodeMeasurement(l), nil
+		}
+	}
+	audioHash, err := updateAudioHash(db, file)
+	if err != nil {
+		return loudness.Measurement{}, err
+	}
+	l, err := data.GetLoudness(db, audioHash)
+	if err == nil {
+		return decodeMeasurement(l), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return loudness.Measurement{}, err
+	}
+
+	d, err := decode.Open(file.Path)
+	if err != nil {
+		return loudness.Measurement{}, err
+	}
+	defer d.Close()
+	m, err := loudness.Measure(d)
+	if err != nil {
+		return loudness.Measurement{}, fmt.Errorf("%s: %w", file.Path, err)
+	}
+	l = schema.Loudness{
+		AudioHash:  audioHash,
+		Integrated: finite(m.Integrated()),
+		Range:      m.Range(),
+		SamplePeak: m.SamplePeak,
+		TruePeak:   m.TruePeak,
+		Blocks:     encodeEnergies(m.Blocks),
+		ShortTerm:  encodeEnergies(m.ShortTerm),
+		Created:    time.Now(),
+	}
+	return m, data.SaveLoudness(db, l)
+}
+
+// TrackLoudness is the loudness of a single file.
+type TrackLoudness struct {
+	File        schema.File
+	Measurement loudness.Measurement
+}
+
+// AlbumLoudness is the loudness of the files in one directory.
+type AlbumLoudness struct {
+	Dir         string
+	Tracks      []TrackLoudness
+	Measurement loudness.Measurement // of all tracks played one after another
+}
+
+// AnalyzeLoudness measures the loudness of all known files below dir and of
+// the albums they form by directory, storing both. Files which can not be
+// measured are returned with the reason and left out of their album.
+func AnalyzeLoudness(db sqlx.Ext, dir string) ([]AlbumLoudness, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]error{}
+	var albums []AlbumLoudness
+	index := map[string]int{}
+	for _, file := range files {
+		m, err := Loudness(db, file)
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		albumDir := filepath.Dir(file.Path)
+		i, found := index[albumDir]
+		if !found {
+			i = len(albums)
+			index[albumDir] = i
+			albums = append(albums, AlbumLoudness{Dir: albumDir})
+		}
+		albums[i].Tracks = append(albums[i].Tracks, TrackLoudness{File: file, Measurement: m})
+	}
+
+	for i := range albums {
+		a := &albums[i]
+		measurements := make([]loudness.Measurement, len(a.Tracks))
+		for j, t := range a.Tracks {
+			measurements[j] = t.Measurement
+		}
+		a.Measurement = loudness.Combine(measurements...)
+		err := data.SaveAlbumLoudness(db, schema.AlbumLoudness{
+			Path:       a.Dir,
+			Integrated: finite(a.Measurement.Integrated()),
+			Range:      a.Measurement.Range(),
+			SamplePeak: a.Measurement.SamplePeak,
+			TruePeak:   a.Measurement.TruePeak,
+			Created:    time.Now(),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return albums, failed, nil
+}
+
+// PlanLoudnessTags determines the ReplayGain 2.0 tags of every track of
+// albums, with r128 also the R128_* gain tags as used for Opus. Gains are
+// based on the integrated loudness, peaks on the true peak. Silent tracks and
+// files of formats whose tags can not be written are skipped.
+func PlanLoudnessTags(albums []AlbumLoudness, r128 bool) []TagUpdate {
+	var plan []TagUpdate
+	for _, a := range albums {
+		albumLoudness := a.Measurement.Integrated()
+		for _, t := range a.Tracks {
+			u := TagUpdate{Path: t.File.Path}
+			trackLoudness := t.Measurement.Integrated()
+			if math.IsInf(trackLoudness, -1) {
+				u.Skipped = ErrSilence
+				plan = append(plan, u)
+				continue
+			}
+			u.Matched = map[string]string{
+				"replaygain_track_gain": formatGain(REPLAYGAIN_REFERENCE - trackLoudness),
+				"replaygain_track_peak": formatPeak(t.Measurement.TruePeak),
+				"replaygain_album_gain": formatGain(REPLAYGAIN_REFERENCE - albumLoudness),
+				"replaygain_album_peak": formatPeak(a.Measurement.TruePeak),
+			}
+			if r128 {
+				u.Matched["r128_track_gain"] = formatQ78(R128_REFERENCE - trackLoudness)
+				u.Matched["r128_album_gain"] = formatQ78(R128_REFERENCE - albumLoudness)
+			}
+
+			if err := tags.Writable(t.File.Path); err != nil {
+				u.Skipped = err
+				plan = append(plan, u)
+				continue
+			}
+			existing, err := tags.Read(t.File.Path)
+			if err != nil {
+				u.Skipped = err
+				plan = append(plan, u)
+				continue
+			}
+			copied := *existing
+			copied.Custom = maps.Clone(existing.Custom)
+			u.Tags = &copied
+			before := explicitFields(u.Tags)
+			for name, value := range u.Matched {
+				u.Tags.SetField(name, value) // custom fields can always be set
+			}
+			u.UpToDate = maps.Equal(before, explicitFields(u.Tags))
+			plan = append(plan, u)
+		}
+	}
+	return plan
+}
+
+func formatGain(gain float64) string {
+	return strconv.FormatFloat(gain, 'f', 2, 64) + " dB"
+}
+
+func formatPeak(peak float64) string {
+	return strconv.FormatFloat(peak, 'f', 6, 64)
+}
+
+// formatQ78 formats gain as a Q7.8 fixed point number, in 1/256 dB.
+func formatQ78(gain float64) string {
+	return strconv.Itoa(int(max(math.MinInt16, min(math.MaxInt16, math.Round(gain*256)))))
+}
+
+// finite returns nil for infinite values, as for the loudness of silence.
+func finite(v float64) *float64 {
+	if math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+func encodeEnergies(energies []float64) []byte {
+	b := make([]byte, 0, 4*len(energies))
+	for _, e := range energies {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(e)))
+	}
+	return b
+}
+
+func decodeEnergies(b []byte) []float64 {
+	energies := make([]float64, len(b)/4)
+	for i := range energies {
+		energies[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return energies
+}
+
+func decodeMeasurement(l schema.Loudness) loudness.Measurement {
+	return loudness.Measurement{
+		Blocks:     decodeEnergies(l.Blocks),
+		ShortTerm:  decodeEnergies(l.ShortTerm),
+		SamplePeak: l.SamplePeak,
+		TruePeak:   l.TruePeak,
+	}
+}
@@ -0,0 +1,152 @@
+package library_test
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/audio/loudness"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/library"
+)
+
+func TestAnalyzeLoudness(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	loud := writeWAV(t, db, filepath.Join(dir, "loud.wav"), tone(5, 440), "")
+	quiet := tone(5, 440)
+	for i := range quiet {
+		quiet[i] /= 2 // 6 dB quieter
+	}
+	writeWAV(t, db, filepath.Join(dir, "quiet.wav"), quiet, "")
+	writeWAV(t, db, filepath.Join(dir, "silence.wav"), make([]int16, 11025), "")
+
+	albums, failed, err := library.AnalyzeLoudness(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("expected no failed files, but got %v", failed)
+	}
+	if len(albums) != 1 || len(albums[0].Tracks) != 3 {
+		t.Fatalf("expected one album with 3 tracks, but got %+v", albums)
+	}
+	levels := map[string]float64{}
+	for _, track := range albums[0].Tracks {
+		levels[filepath.Base(track.File.Path)] = track.Measurement.Integrated()
+	}
+	if d := levels["loud.wav"] - levels["quiet.wav"]; math.Abs(d-6.02) > 0.1 {
+		t.Errorf("expected a difference of 6 LU, but got %.2f", d)
+	}
+	if !math.IsInf(levels["silence.wav"], -1) {
+		t.Errorf("expected -Inf for silence, but got %f", levels["silence.wav"])
+	}
+	album := albums[0].Measurement.Integrated()
+	if album <= levels["quiet.wav"] || album >= levels["loud.wav"] {
+		t.Errorf("expected the album loudness between its tracks, but got %.2f", album)
+	}
+
+	stored, err := data.GetAlbumLoudness(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.Integrated == nil || math.Abs(*stored.Integrated-album) > 1e-9 {
+		t.Errorf("expected stored album loudness %.2f, but got %v", album, stored.Integrated)
+	}
+
+	// Measurements are reused for the same audio data
+	loud, err = data.GetFile(db, loud.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l, err := data.GetLoudness(db, loud.AudioHash)
+	if err != nil {
+		t.Fatalf("expected a stored measurement, but got %v", err)
+	}
+	l.SamplePeak, l.TruePeak = 0.5, 0.5
+	if err := data.SaveLoudness(db, l); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, err := library.Loudness(db, loud)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.TruePeak != 0.5 {
+		t.Errorf("expected the stored measurement to be used, but got true peak %f", m.TruePeak)
+	}
+	if math.Abs(m.Integrated()-levels["loud.wav"]) > 0.01 {
+		t.Errorf("expected stored loudness %.2f, but got %.2f", levels["loud.wav"], m.Integrated())
+	}
+
+	// Tags can not be written to WAV files, silence has no gain
+	for _, u := range library.PlanLoudnessTags(albums, false) {
+		if filepath.Base(u.Path) == "silence.wav" {
+			if !errors.Is(u.Skipped, library.ErrSilence) {
+				t.Errorf("expected error %v for %s, but got %v", library.ErrSilence, u.Path, u.Skipped)
+			}
+		} else if !errors.Is(u.Skipped, tags.ErrUnsupportedFormat) {
+			t.Errorf("expected error %v for %s, but got %v", tags.ErrUnsupportedFormat, u.Path, u.Skipped)
+		}
+	}
+}
+
+// measurement returns a measurement of the given loudness in LUFS.
+func measurement(lufs float64, peak float64) loudness.Measurement {
+	e := math.Pow(10, (lufs+0.691)/10)
+	return loudness.Measurement{Blocks: []float64{e, e}, SamplePeak: peak, TruePeak: peak}
+}
+
+func TestPlanLoudnessTags(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	a, b := filepath.Join(dir, "a.flac"), filepath.Join(dir, "b.flac")
+	writeTaggedFLAC(t, db, a, "TITLE=A")
+	writeTaggedFLAC(t, db, b, "TITLE=B", "REPLAYGAIN_TRACK_GAIN=-5.00 dB", "REPLAYGAIN_TRACK_PEAK=0.900000",
+		"REPLAYGAIN_ALBUM_GAIN=-3.00 dB", "REPLAYGAIN_ALBUM_PEAK=0.900000")
+	trackA, trackB := measurement(-28, 0.5), measurement(-13, 0.9)
+	albums := []library.AlbumLoudness{{
+		Dir: dir,
+		Tracks: []library.TrackLoudness{
+			{File: schema.File{Path: a}, Measurement: trackA},
+			{File: schema.File{Path: b}, Measurement: trackB},
+		},
+		Measurement: measurement(-15, 0.9),
+	}}
+
+	plan := library.PlanLoudnessTags(albums, true)
+	if len(plan) != 2 {
+		t.Fatalf("expected 2 updates, but got %d", len(plan))
+	}
+	expected := map[string]string{
+		"replaygain_track_gain": "10.00 dB",
+		"replaygain_track_peak": "0.500000",
+		"replaygain_album_gain": "-3.00 dB",
+		"replaygain_album_peak": "0.900000",
+		"r128_track_gain":       "1280",
+		"r128_album_gain":       "-2048",
+	}
+	for name, value := range expected {
+		if result := plan[0].Matched[name]; result != value {
+			t.Errorf("expected %s to be %q, but got %q", name, value, result)
+		}
+		if result := plan[0].Tags.Custom[strings.ToUpper(name)]; result != value {
+			t.Errorf("expected tag %s to be %q, but got %q", name, value, result)
+		}
+	}
+	if plan[0].UpToDate || plan[0].Skipped != nil {
+		t.Errorf("expected %s to be written, but got %+v", a, plan[0])
+	}
+
+	plan = library.PlanLoudnessTags(albums, false)
+	if !plan[1].UpToDate {
+		t.Errorf("expected %s to be up to date, but got %+v", b, plan[1].Matched)
+	}
+}
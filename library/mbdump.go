@@ -0,0 +1,589 @@
+package library
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/musicbrainz"
+)
+
+// ImportStats counts the entities stored by ImportDump.
+type ImportStats struct {
+	Artists    int
+	Releases   int
+	Recordings int
+	Tracks     int
+}
+
+// Number of rows ImportDump stores per transaction
+var IMPORT_CHUNK = 10000
+
+// ImportDump stores the artists, releases, recordings and tracks of a
+// MusicBrainz JSON or PostgreSQL data dump extracted to dir, or to its mbdump
+// directory. Subsets of the dump are fine, missing tables or entity files are
+// skipped. The rows are committed in chunks of IMPORT_CHUNK, an interrupted
+// import is resumed by importing the dump again, as entities are replaced by
+// their MBID.
+func ImportDump(db *sqlx.DB, dir string) (ImportStats, error) {
+	if info, err := os.Stat(filepath.Join(dir, musicbrainz.DUMP_DIR)); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, musicbrainz.DUMP_DIR)
+	}
+	isJSON, err := isJSONDump(dir)
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	// The temporary tables only exist on the connection which created them
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return ImportStats{}, err
+	}
+	defer conn.Close()
+	if !isJSON {
+		if _, err := conn.ExecContext(ctx, tsvDumpTables); err != nil {
+			return ImportStats{}, err
+		}
+		defer conn.ExecContext(ctx, dropTSVDumpTables)
+	}
+	w := &dumpWriter{conn: conn}
+	if w.tx, err = conn.BeginTxx(ctx, nil); err != nil {
+		return ImportStats{}, err
+	}
+	defer func() {
+		if w.tx != nil {
+			w.tx.Rollback() // fails after the commit, which is fine
+		}
+	}()
+	var stats ImportStats
+	if isJSON {
+		stats, err = importJSONDump(w, dir)
+	} else {
+		stats, err = importTSVDump(w, dir)
+	}
+	if err != nil {
+		return ImportStats{}, err
+	}
+	return stats, w.tx.Commit()
+}
+
+// dumpWriter stores the rows of a dump in transactions of IMPORT_CHUNK rows.
+type dumpWriter struct {
+	conn *sqlx.Conn
+	tx   *sqlx.Tx
+	rows int
+}
+
+// stored counts a stored row and starts the next transaction after
+// IMPORT_CHUNK rows.
+func (w *dumpWriter) stored() error {
+	w.rows++
+	if w.rows%IMPORT_CHUNK != 0 {
+		return nil
+	}
+	if err := w.tx.Commit(); err != nil {
+		return err
+	}
+	var err error
+	w.tx, err = w.conn.BeginTxx(context.Background(), nil)
+	return err
+}
+
+// get runs a query for a single row like sqlx.Get and reports whether it
+// was found.
+func (w *dumpWriter) get(dest any, query string, args ...any) (bool, error) {
+	err := sqlx.Get(w.tx, dest, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isJSONDump tells the dump formats apart by the first entity file found, in
+// the JSON dumps every line is an object.
+func isJSONDump(dir string) (bool, error) {
+	for _, name := range []string{"artist", "release", "recording"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		first, err := bufio.NewReader(f).Peek(1)
+		f.Close()
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		return len(first) == 1 && first[0] == '{', nil
+	}
+	return false, fmt.Errorf("%w: neither artists, releases nor recordings found in %s", musicbrainz.ErrInvalidDump, dir)
+}
+
+func importJSONDump(w *dumpWriter, dir string) (ImportStats, error) {
+	var stats ImportStats
+	now := time.Now()
+	err := readJSONDump(dir, "artist", func(d *json.Decoder) error {
+		var a musicbrainz.Artist
+		if err := d.Decode(&a); err != nil {
+			return err
+		}
+		stats.Artists++
+		if err := data.SaveArtist(w.tx, schema.Artist{ID: a.ID, Name: a.Name, SortName: a.SortName, Disambiguation: a.Disambiguation, Updated: now}); err != nil {
+			return err
+		}
+		return w.stored()
+	})
+	if err != nil {
+		return stats, err
+	}
+	err = readJSONDump(dir, "release", func(d *json.Decoder) error {
+		var r musicbrainz.Release
+		if err := d.Decode(&r); err != nil {
+			return err
+		}
+		stats.Releases++
+		for _, m := range r.Media {
+			stats.Tracks += len(m.Tracks)
+		}
+		if err := StoreRelease(w.tx, &r); err != nil {
+			return err
+		}
+		return w.stored()
+	})
+	if err != nil {
+		return stats, err
+	}
+	err = readJSONDump(dir, "recording", func(d *json.Decoder) error {
+		var r musicbrainz.Recording
+		if err := d.Decode(&r); err != nil {
+			return err
+		}
+		stats.Recordings++
+		if err := StoreRecording(w.tx, &r); err != nil {
+			return err
+		}
+		return w.stored()
+	})
+	return stats, err
+}
+
+// readJSONDump calls decode for every entity in the named file of a JSON
+// dump. A missing file is skipped.
+func readJSONDump(dir string, name string, decode func(d *json.Decoder) error) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	d := json.NewDecoder(bufio.NewReader(f))
+	for n := 1; d.More(); n++ {
+		if err := decode(d); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				return fmt.Errorf("%w: %s: entity %d: %w", musicbrainz.ErrInvalidDump, name, n, err)
+			}
+			return fmt.Errorf("%s: entity %d: %w", name, n, err)
+		}
+	}
+	return nil
+}
+
+// Temporary tables mapping the internal ids of the PostgreSQL dump, by which
+// its rows refer to each other, to MBIDs and the other values needed from
+// the referenced rows. Full dumps are too large to keep them in memory.
+const tsvDumpTables = `CREATE TEMP TABLE mbdump_ids (
+  kind TEXT NOT NULL, -- table of the id
+  id TEXT NOT NULL,
+  gid TEXT NOT NULL, -- MBID
+  PRIMARY KEY (kind, id)
+);
+CREATE TEMP TABLE mbdump_credits (
+  id TEXT NOT NULL PRIMARY KEY,
+  name TEXT NOT NULL, -- as displayed, i.e. "A feat. B"
+  artist_id TEXT NOT NULL DEFAULT '' -- MBID of the first credited artist
+);
+CREATE TEMP TABLE mbdump_events (
+  release TEXT NOT NULL PRIMARY KEY,
+  date TEXT NOT NULL,
+  country TEXT NOT NULL
+);
+CREATE TEMP TABLE mbdump_media (
+  id TEXT NOT NULL PRIMARY KEY,
+  release TEXT NOT NULL,
+  position INTEGER NOT NULL,
+  track_count INTEGER NOT NULL
+);
+CREATE INDEX temp.mbdump_media_release ON mbdump_media (release);`
+
+const dropTSVDumpTables = `DROP TABLE temp.mbdump_ids; DROP TABLE temp.mbdump_credits; DROP TABLE temp.mbdump_events; DROP TABLE temp.mbdump_media;`
+
+// credit is an artist credit of the PostgreSQL dump.
+type credit struct {
+	Name     string `db:"name"`
+	ArtistID string `db:"artist_id"`
+}
+
+// medium is a medium of the PostgreSQL dump.
+type medium struct {
+	Release  string `db:"release"`
+	Position int    `db:"position"`
+}
+
+// importTSVDump stores the tables of a PostgreSQL dump. Rows refer to each
+// other by their internal ids, which are mapped to MBIDs while reading the
+// tables in order of their references.
+func importTSVDump(w *dumpWriter, dir string) (ImportStats, error) {
+	// Without the artist credits, releases, recordings and tracks lack
+	// their artist
+	for _, table := range []string{"release", "recording", "track"} {
+		if _, err := os.Stat(filepath.Join(dir, table)); err != nil {
+			continue
+		}
+		for _, credits := range []string{"artist_credit", "artist_credit_name"} {
+			if _, err := os.Stat(filepath.Join(dir, credits)); err != nil {
+				return ImportStats{}, fmt.Errorf("%w: missing table %s, which table %s refers to", musicbrainz.ErrInvalidDump, credits, table)
+			}
+		}
+	}
+
+	var stats ImportStats
+	now := time.Now()
+	setID := func(kind string, id string, gid string) error {
+		if _, err := w.tx.Exec(`INSERT INTO mbdump_ids (kind, id, gid) VALUES (?, ?, ?) ON CONFLICT (kind, id) DO UPDATE SET gid = excluded.gid`, kind, id, gid); err != nil {
+			return err
+		}
+		return w.stored()
+	}
+	getID := func(kind string, id string) (string, bool, error) {
+		var gid string
+		found, err := w.get(&gid, `SELECT gid FROM mbdump_ids WHERE kind = ? AND id = ?`, kind, id)
+		return gid, found, err
+	}
+
+	// artist: id, gid, name, sort_name, ..., comment (13), ...
+	err := readTSVDump(dir, "artist", 14, func(row []string) error {
+		stats.Artists++
+		if err := data.SaveArtist(w.tx, schema.Artist{ID: row[1], Name: row[2], SortName: row[3], Disambiguation: row[13], Updated: now}); err != nil {
+			return err
+		}
+		return setID("artist", row[0], row[1])
+	})
+	if err != nil {
+		return stats, err
+	}
+	// artist_credit: id, name, ...
+	err = readTSVDump(dir, "artist_credit", 2, func(row []string) error {
+		if _, err := w.tx.Exec(`INSERT OR REPLACE INTO mbdump_credits (id, name) VALUES (?, ?)`, row[0], row[1]); err != nil {
+			return err
+		}
+		return w.stored()
+	})
+	if err != nil {
+		return stats, err
+	}
+	// artist_credit_name: artist_credit, position, artist, name, join_phrase
+	err = readTSVDump(dir, "artist_credit_name", 3, func(row []string) error {
+		var known bool
+		if _, err := w.get(&known, `SELECT EXISTS (SELECT 1 FROM mbdump_credits WHERE id = ?)`, row[0]); err != nil {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown artist credit %s", musicbrainz.ErrInvalidDump, row[0])
+		}
+		if row[1] != "0" {
+			return nil
+		}
+		artist, found, err := getID("artist", row[2])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: unknown artist %s", musicbrainz.ErrInvalidDump, row[2])
+		}
+		if _, err := w.tx.Exec(`UPDATE mbdump_credits SET artist_id = ? WHERE id = ?`, artist, row[0]); err != nil {
+			return err
+		}
+		return w.stored()
+	})
+	if err != nil {
+		return stats, err
+	}
+	artistCredit := func(id string) (credit, error) {
+		var c credit
+		found, err := w.get(&c, `SELECT name, artist_id FROM mbdump_credits WHERE id = ?`, id)
+		if err != nil {
+			return c, err
+		}
+		if !found || c.ArtistID == "" {
+			return c, fmt.Errorf("%w: unknown artist credit %s", musicbrainz.ErrInvalidDump, id)
+		}
+		return c, nil
+	}
+
+	// release_group: id, gid, ...
+	err = readTSVDump(dir, "release_group", 2, func(row []string) error {
+		return setID("release_group", row[0], row[1])
+	})
+	if err != nil {
+		return stats, err
+	}
+	// The few release statuses and countries are kept in memory.
+	// release_status: id, name, ...
+	statuses := map[string]string{}
+	err = readTSVDump(dir, "release_status", 2, func(row []string) error {
+		statuses[row[0]] = row[1]
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	// iso_3166_1: area, code
+	countries := map[string]string{}
+	err = readTSVDump(dir, "iso_3166_1", 2, func(row []string) error {
+		countries[row[0]] = row[1]
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	// Releases are dated like on the web service by their earliest event.
+	// release_country: release, country, date_year, date_month, date_day
+	// release_unknown_country: release, date_year, date_month, date_day
+	addEvent := func(release string, country string, year, month, day string) error {
+		date, err := formatDate(year, month, day)
+		if err != nil {
+			return err
+		}
+		_, err = w.tx.Exec(`INSERT INTO mbdump_events (release, date, country) VALUES (?, ?, ?)
+			ON CONFLICT (release) DO UPDATE SET date = excluded.date, country = excluded.country
+			WHERE excluded.date != '' AND (mbdump_events.date = '' OR excluded.date < mbdump_events.date)`, release, date, country)
+		if err != nil {
+			return err
+		}
+		return w.stored()
+	}
+	err = readTSVDump(dir, "release_country", 5, func(row []string) error {
+		return addEvent(row[0], countries[row[1]], row[2], row[3], row[4])
+	})
+	if err != nil {
+		return stats, err
+	}
+	err = readTSVDump(dir, "release_unknown_country", 4, func(row []string) error {
+		return addEvent(row[0], "", row[1], row[2], row[3])
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// medium: id, release, position, format, name, edits_pending, last_updated, track_count, ...
+	err = readTSVDump(dir, "medium", 8, func(row []string) error {
+		position, err := strconv.Atoi(row[2])
+		if err != nil {
+			return fmt.Errorf("%w: medium position: %w", musicbrainz.ErrInvalidDump, err)
+		}
+		count, err := strconv.Atoi(row[7])
+		if err != nil {
+			return fmt.Errorf("%w: medium track count: %w", musicbrainz.ErrInvalidDump, err)
+		}
+		if _, err := w.tx.Exec(`INSERT OR REPLACE INTO mbdump_media (id, release, position, track_count) VALUES (?, ?, ?, ?)`, row[0], row[1], position, count); err != nil {
+			return err
+		}
+		return w.stored()
+	})
+	if err != nil {
+		return stats, err
+	}
+	// release: id, gid, name, artist_credit, release_group, status, packaging, language, script, barcode, ...
+	err = readTSVDump(dir, "release", 10, func(row []string) error {
+		c, err := artistCredit(row[3])
+		if err != nil {
+			return err
+		}
+		group, _, err := getID("release_group", row[4])
+		if err != nil {
+			return err
+		}
+		var event struct {
+			Date    string `db:"date"`
+			Country string `db:"country"`
+		}
+		if _, err := w.get(&event, `SELECT date, country FROM mbdump_events WHERE release = ?`, row[0]); err != nil {
+			return err
+		}
+		var trackCount int
+		if _, err := w.get(&trackCount, `SELECT COALESCE(SUM(track_count), 0) FROM mbdump_media WHERE release = ?`, row[0]); err != nil {
+			return err
+		}
+		stats.Releases++
+		err = data.SaveRelease(w.tx, schema.Release{
+			ID:             row[1],
+			ReleaseGroupID: group,
+			Title:          row[2],
+			ArtistID:       c.ArtistID,
+			ArtistCredit:   c.Name,
+			Date:           event.Date,
+			Country:        event.Country,
+			Status:         statuses[row[5]],
+			Barcode:        row[9],
+			TrackCount:     trackCount,
+			Updated:        now,
+		})
+		if err != nil {
+			return err
+		}
+		return setID("release", row[0], row[1])
+	})
+	if err != nil {
+		return stats, err
+	}
+	// recording: id, gid, name, artist_credit, length, ...
+	err = readTSVDump(dir, "recording", 5, func(row []string) error {
+		c, err := artistCredit(row[3])
+		if err != nil {
+			return err
+		}
+		length, err := atoiOrZero(row[4])
+		if err != nil {
+			return err
+		}
+		stats.Recordings++
+		if err := data.SaveRecording(w.tx, schema.Recording{ID: row[1], Title: row[2], ArtistID: c.ArtistID, ArtistCredit: c.Name, Length: length, Updated: now}); err != nil {
+			return err
+		}
+		return setID("recording", row[0], row[1])
+	})
+	if err != nil {
+		return stats, err
+	}
+	// track: id, gid, recording, medium, position, number, name, artist_credit, length, ...
+	err = readTSVDump(dir, "track", 9, func(row []string) error {
+		var m medium
+		found, err := w.get(&m, `SELECT release, position FROM mbdump_media WHERE id = ?`, row[3])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: unknown medium %s", musicbrainz.ErrInvalidDump, row[3])
+		}
+		release, found, err := getID("release", m.Release)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: unknown release %s", musicbrainz.ErrInvalidDump, m.Release)
+		}
+		recording, found, err := getID("recording", row[2])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: unknown recording %s", musicbrainz.ErrInvalidDump, row[2])
+		}
+		c, err := artistCredit(row[7])
+		if err != nil {
+			return err
+		}
+		position, err := strconv.Atoi(row[4])
+		if err != nil {
+			return fmt.Errorf("%w: track position: %w", musicbrainz.ErrInvalidDump, err)
+		}
+		length, err := atoiOrZero(row[8])
+		if err != nil {
+			return err
+		}
+		stats.Tracks++
+		err = data.SaveTrack(w.tx, schema.Track{
+			ID:           row[1],
+			ReleaseID:    release,
+			RecordingID:  recording,
+			Disc:         m.Position,
+			Position:     position,
+			Number:       row[5],
+			Title:        row[6],
+			ArtistCredit: c.Name,
+			Length:       length,
+		})
+		if err != nil {
+			return err
+		}
+		return w.stored()
+	})
+	return stats, err
+}
+
+// readTSVDump calls store for every row of the named table of a PostgreSQL
+// dump, which needs at least the given number of columns. A missing table is
+// skipped.
+func readTSVDump(dir string, table string, columns int, store func(row []string) error) error {
+	f, err := os.Open(filepath.Join(dir, table))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := musicbrainz.NewTSVReader(f)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+		if len(row) < columns {
+			return fmt.Errorf("%w: %s: line %d: expected %d columns, but got %d", musicbrainz.ErrInvalidDump, table, r.Line(), columns, len(row))
+		}
+		if err := store(row); err != nil {
+			return fmt.Errorf("%s: line %d: %w", table, r.Line(), err)
+		}
+	}
+}
+
+// formatDate formats the partial dates of the PostgreSQL dump like the web
+// service, i.e. "2001" or "2001-05-03".
+func formatDate(year, month, day string) (string, error) {
+	date := ""
+	for i, part := range []string{year, month, day} {
+		if part == "" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return "", fmt.Errorf("%w: date: %w", musicbrainz.ErrInvalidDump, err)
+		}
+		if i == 0 {
+			date = fmt.Sprintf("%04d", n)
+		} else {
+			date += fmt.Sprintf("-%02d", n)
+		}
+	}
+	return date, nil
+}
+
+// atoiOrZero parses a nullable length, which is empty if unknown.
+func atoiOrZero(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: length: %w", musicbrainz.ErrInvalidDump, err)
+	}
+	return n, nil
+}
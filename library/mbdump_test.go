@@ -0,0 +1,196 @@
+package library_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/musicbrainz"
+)
+
+// writeDump writes the given files to the mbdump directory of a new dump.
+func writeDump(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, musicbrainz.DUMP_DIR), 0755); err != nil {
+		t.Fatalf("failed to create dump directory: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, musicbrainz.DUMP_DIR, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestImportJSONDump(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	release, err := json.Marshal(mbRelease)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	artist, err := json.Marshal(mbGuest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := writeDump(t, map[string]string{"artist": string(artist) + "\n", "release": string(release) + "\n"})
+
+	stats, err := library.ImportDump(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats != (library.ImportStats{Artists: 1, Releases: 1, Tracks: 2}) {
+		t.Errorf("expected 1 artist, 1 release and 2 tracks, but got %+v", stats)
+	}
+	tracks, err := data.GetReleaseTracks(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracks) != 2 || tracks[1].ArtistCredit != "The Band feat. Guest" {
+		t.Errorf("expected both tracks of the release, but got %+v", tracks)
+	}
+}
+
+// A PostgreSQL dump of mbRelease without the second disc
+var tsvDump = map[string]string{
+	"artist": "11\t" + mbArtist.ID + "\tBand\tBand, The\t1990\t\\N\t\\N\t\\N\t\\N\t\\N\t2\t\\N\t\\N\t\t0\t2020-01-01 00:00:00+00\tf\t\\N\t\\N\n" +
+		"12\t" + mbGuest.ID + "\tGuest\tGuest\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N\t1\t\\N\t\\N\tthe singer\t0\t2020-01-01 00:00:00+00\tf\t\\N\t\\N\n",
+	"artist_credit": "21\tThe Band\t1\t10\t2020-01-01 00:00:00+00\t0\n" +
+		"22\tThe Band feat. Guest\t2\t1\t2020-01-01 00:00:00+00\t0\n",
+	"artist_credit_name": "21\t0\t11\tThe Band\t\n" +
+		"22\t0\t11\tThe Band\t feat. \n" +
+		"22\t1\t12\tGuest\t\n",
+	"release_group":           "31\tddaa2d4d-314e-3e7c-b1d0-f6d207f5aa2f\tAlbum\t21\t1\t\t0\t\\N\n",
+	"release_status":          "1\tOfficial\t\\N\t0\t\\N\t4e304316-386d-3409-af2e-78857eec5cfe\n",
+	"iso_3166_1":              "81\tDE\n",
+	"release_country":         "41\t81\t2001\t5\t3\n",
+	"release_unknown_country": "41\t2002\t\\N\t\\N\n",
+	"medium":                  "51\t41\t1\t1\t\t0\t\\N\t2\n",
+	"release":                 "41\t" + mbRelease.ID + "\tAlbum\t21\t31\t1\t\\N\t120\t28\t4006381333931\t\t0\t-1\t\\N\n",
+	"recording": "61\tb9ad642e-b012-41c7-b72a-42cf4911f9ff\tIntro\t21\t61000\t\t0\t\\N\tf\n" +
+		"62\t5c1e6a0e-7b4d-4a39-9b3b-2d8f4e6c7a90\tSong\\twith tab\t22\t\\N\t\t0\t\\N\tf\n",
+	"track": "71\t7f0e1a3c-8b9d-4e2f-a1b2-c3d4e5f60718\t61\t51\t1\t1\tIntro\t21\t61000\t0\t\\N\tf\n" +
+		"72\t1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d\t62\t51\t2\t2\tSong\\twith tab\t22\t\\N\t0\t\\N\tf\n",
+}
+
+func TestImportTSVDump(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := writeDump(t, tsvDump)
+
+	stats, err := library.ImportDump(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats != (library.ImportStats{Artists: 2, Releases: 1, Recordings: 2, Tracks: 2}) {
+		t.Errorf("expected 2 artists, 1 release, 2 recordings and 2 tracks, but got %+v", stats)
+	}
+
+	release, err := data.GetRelease(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if release.ArtistID != mbArtist.ID || release.ArtistCredit != "The Band" || release.ReleaseGroupID != "ddaa2d4d-314e-3e7c-b1d0-f6d207f5aa2f" {
+		t.Errorf("expected the release by %q, but got %+v", "The Band", release)
+	}
+	if release.Date != "2001-05-03" || release.Country != "DE" || release.Status != "Official" || release.TrackCount != 2 {
+		t.Errorf("expected the earliest release event with 2 tracks, but got %+v", release)
+	}
+	guest, err := data.GetArtist(db, mbGuest.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if guest.Disambiguation != "the singer" {
+		t.Errorf("expected the disambiguation %q, but got %q", "the singer", guest.Disambiguation)
+	}
+	tracks, err := data.GetReleaseTracks(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracks) != 2 || tracks[0].Length != 61000 || tracks[1].Title != "Song\twith tab" || tracks[1].ArtistCredit != "The Band feat. Guest" {
+		t.Fatalf("expected both tracks of the release, but got %+v", tracks)
+	}
+	recording, err := data.GetRecording(db, tracks[1].RecordingID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recording.ArtistID != mbArtist.ID || recording.Length != 0 {
+		t.Errorf("expected the recording of unknown length by %q, but got %+v", mbArtist.ID, recording)
+	}
+}
+
+func TestImportDumpInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"empty", map[string]string{}},
+		{"missing artist credit", map[string]string{"release": tsvDump["release"]}},
+		{"missing artist credit tables", func() map[string]string {
+			files := map[string]string{}
+			for _, name := range []string{"artist", "release", "recording", "track", "medium"} {
+				files[name] = tsvDump[name]
+			}
+			return files
+		}()},
+		{"missing columns", map[string]string{"artist": "11\t" + mbArtist.ID + "\tBand\n"}},
+		{"invalid json", map[string]string{"release": `{"id": 1}` + "\n"}},
+		{"unknown medium", func() map[string]string {
+			files := map[string]string{}
+			for name, content := range tsvDump {
+				files[name] = content
+			}
+			files["track"] = strings.Replace(files["track"], "\t51\t", "\t52\t", 1)
+			return files
+		}()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			defer db.Close()
+			if _, err := library.ImportDump(db, writeDump(t, test.files)); !errors.Is(err, musicbrainz.ErrInvalidDump) {
+				t.Errorf("expected ErrInvalidDump, but got %v", err)
+			}
+			if _, err := data.GetRelease(db, mbRelease.ID); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("expected nothing to be stored, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestImportTSVDumpInChunks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer func(chunk int) { library.IMPORT_CHUNK = chunk }(library.IMPORT_CHUNK)
+	library.IMPORT_CHUNK = 2
+
+	// The chunks stored before an error stay, importing again completes them
+	broken := map[string]string{}
+	for name, content := range tsvDump {
+		broken[name] = content
+	}
+	broken["track"] = strings.Replace(broken["track"], "\t51\t", "\t52\t", 1)
+	if _, err := library.ImportDump(db, writeDump(t, broken)); !errors.Is(err, musicbrainz.ErrInvalidDump) {
+		t.Fatalf("expected ErrInvalidDump, but got %v", err)
+	}
+	if _, err := data.GetArtist(db, mbArtist.ID); err != nil {
+		t.Errorf("expected the artists of the first chunks to be stored, but got %v", err)
+	}
+
+	stats, err := library.ImportDump(db, writeDump(t, tsvDump))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats != (library.ImportStats{Artists: 2, Releases: 1, Recordings: 2, Tracks: 2}) {
+		t.Errorf("expected 2 artists, 1 release, 2 recordings and 2 tracks, but got %+v", stats)
+	}
+	if tracks, err := data.GetReleaseTracks(db, mbRelease.ID); err != nil || len(tracks) != 2 {
+		t.Errorf("expected both tracks of the release, but got %+v (%v)", tracks, err)
+	}
+}
@@ -0,0 +1,143 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/musicbrainz"
+)
+
+var ErrMissingArtistCredit = errors.New("missing artist credit")
+
+// StoreRelease saves a release as returned by musicbrainz.Client.GetRelease
+// with its credited artists, tracks and their recordings. Run it in a
+// transaction to store all of them or nothing.
+func StoreRelease(db sqlx.Ext, r *musicbrainz.Release) error {
+	if len(r.ArtistCredit) == 0 {
+		return fmt.Errorf("%w: release %s", ErrMissingArtistCredit, r.ID)
+	}
+	now := time.Now()
+	if err := storeArtists(db, r.ArtistCredit, now); err != nil {
+		return err
+	}
+	release := schema.Release{
+		ID:           r.ID,
+		Title:        r.Title,
+		ArtistID:     r.ArtistCredit[0].Artist.ID,
+		ArtistCredit: r.ArtistCredit.String(),
+		Date:         r.Date,
+		Country:      r.Country,
+		Status:       r.Status,
+		Barcode:      r.Barcode,
+		TrackCount:   r.TrackCount(),
+		Updated:      now,
+	}
+	if r.ReleaseGroup != nil {
+		release.ReleaseGroupID = r.ReleaseGroup.ID
+	}
+	if err := data.SaveRelease(db, release); err != nil {
+		return err
+	}
+
+	for _, medium := range r.Media {
+		for _, t := range medium.Tracks {
+			// Tracks without own credits are credited like their recording,
+			// recordings included in releases often come without credits
+			credits := t.ArtistCredit
+			if len(credits) == 0 {
+				credits = t.Recording.ArtistCredit
+			}
+			if len(credits) == 0 {
+				credits = r.ArtistCredit
+			}
+			recording := t.Recording
+			if len(recording.ArtistCredit) == 0 {
+				recording.ArtistCredit = credits
+			}
+			if err := StoreRecording(db, &recording); err != nil {
+				return err
+			}
+			err := data.SaveTrack(db, schema.Track{
+				ID:           t.ID,
+				ReleaseID:    r.ID,
+				RecordingID:  recording.ID,
+				Disc:         medium.Position,
+				Position:     t.Position,
+				Number:       t.Number,
+				Title:        t.Title,
+				ArtistCredit: credits.String(),
+				Length:       lengthOrZero(t.Length),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FetchRelease looks up a release on MusicBrainz and stores it with its
+// artists, tracks and recordings.
+func FetchRelease(ctx context.Context, db *sqlx.DB, client *musicbrainz.Client, id string) (*musicbrainz.Release, error) {
+	r, err := client.GetRelease(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() // fails after the commit, which is fine
+	if err := StoreRelease(tx, r); err != nil {
+		return nil, err
+	}
+	return r, tx.Commit()
+}
+
+// StoreRecording saves a recording with its credited artists.
+func StoreRecording(db sqlx.Ext, r *musicbrainz.Recording) error {
+	if len(r.ArtistCredit) == 0 {
+		return fmt.Errorf("%w: recording %s", ErrMissingArtistCredit, r.ID)
+	}
+	now := time.Now()
+	if err := storeArtists(db, r.ArtistCredit, now); err != nil {
+		return err
+	}
+	return data.SaveRecording(db, schema.Recording{
+		ID:           r.ID,
+		Title:        r.Title,
+		ArtistID:     r.ArtistCredit[0].Artist.ID,
+		ArtistCredit: r.ArtistCredit.String(),
+		Length:       lengthOrZero(r.Length),
+		Updated:      now,
+	})
+}
+
+func storeArtists(db sqlx.Ext, credits musicbrainz.Credits, now time.Time) error {
+	for _, c := range credits {
+		err := data.SaveArtist(db, schema.Artist{
+			ID:             c.Artist.ID,
+			Name:           c.Artist.Name,
+			SortName:       c.Artist.SortName,
+			Disambiguation: c.Artist.Disambiguation,
+			Updated:        now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lengthOrZero(length *int) int {
+	if length == nil {
+		return 0
+	}
+	return *length
+}
@@ -0,0 +1,139 @@
+package library_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/musicbrainz"
+	"github.com/makl11/musiman/musicbrainz/musicbrainztest"
+)
+
+var (
+	mbArtist  = musicbrainz.Artist{ID: "2fd06f00-d1ab-4c34-a23c-d0aa7b1fe5e9", Name: "Band", SortName: "Band, The"}
+	mbGuest   = musicbrainz.Artist{ID: "9c9f1380-2516-4fc9-a3e6-f9f61941d090", Name: "Guest", SortName: "Guest"}
+	mbRelease = musicbrainz.Release{
+		ID:           "0b4b8f79-4d0a-4f3e-9d6b-1e7f5c0d2a11",
+		Title:        "Album",
+		Date:         "2001-05-03",
+		ArtistCredit: musicbrainz.Credits{{Name: "The Band", Artist: mbArtist}},
+		ReleaseGroup: &musicbrainz.ReleaseGroup{ID: "ddaa2d4d-314e-3e7c-b1d0-f6d207f5aa2f", Title: "Album"},
+		Media: []musicbrainz.Medium{
+			{Position: 1, TrackCount: 1, Tracks: []musicbrainz.Track{
+				{ID: "7f0e1a3c-8b9d-4e2f-a1b2-c3d4e5f60718", Number: "1", Position: 1, Title: "Intro",
+					Recording: musicbrainz.Recording{ID: "b9ad642e-b012-41c7-b72a-42cf4911f9ff", Title: "Intro"}},
+			}},
+			{Position: 2, TrackCount: 1, Tracks: []musicbrainz.Track{
+				{ID: "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d", Number: "1", Position: 1, Title: "Song",
+					ArtistCredit: musicbrainz.Credits{{Name: "The Band", JoinPhrase: " feat. ", Artist: mbArtist}, {Name: "Guest", Artist: mbGuest}},
+					Recording:    musicbrainz.Recording{ID: "5c1e6a0e-7b4d-4a39-9b3b-2d8f4e6c7a90", Title: "Song"}},
+			}},
+		},
+	}
+)
+
+func TestFetchRelease(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := musicbrainztest.NewServer()
+	defer server.Close()
+	server.AddRelease(mbRelease)
+	client := musicbrainz.NewClient()
+	client.BaseURL = server.BaseURL()
+
+	if _, err := library.FetchRelease(context.Background(), db, client, mbRelease.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	release, err := data.GetRelease(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if release.ArtistCredit != "The Band" || release.ArtistID != mbArtist.ID || release.TrackCount != 2 {
+		t.Errorf("expected the release by %q with 2 tracks, but got %+v", "The Band", release)
+	}
+	tracks, err := data.GetReleaseTracks(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracks) != 2 || tracks[0].Title != "Intro" || tracks[1].Disc != 2 {
+		t.Fatalf("expected the tracks of both discs in order, but got %+v", tracks)
+	}
+	if tracks[0].ArtistCredit != "The Band" || tracks[1].ArtistCredit != "The Band feat. Guest" {
+		t.Errorf("expected the release credit to be inherited, but got %q and %q", tracks[0].ArtistCredit, tracks[1].ArtistCredit)
+	}
+	recording, err := data.GetRecording(db, tracks[1].RecordingID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recording.ArtistCredit != "The Band feat. Guest" {
+		t.Errorf("expected the recording to be credited like its track, but got %q", recording.ArtistCredit)
+	}
+	if _, err := data.GetArtist(db, mbGuest.ID); err != nil {
+		t.Errorf("expected the guest artist to be stored, but got %v", err)
+	}
+}
+
+func TestFetchReleaseInvalid(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	server := musicbrainztest.NewServer()
+	defer server.Close()
+	broken := mbRelease
+	broken.Media = []musicbrainz.Medium{{Position: 1, TrackCount: 1, Tracks: []musicbrainz.Track{
+		{ID: "not-an-mbid", Position: 1, Title: "Broken", Recording: musicbrainz.Recording{ID: "b9ad642e-b012-41c7-b72a-42cf4911f9ff"}},
+	}}}
+	server.AddRelease(broken)
+	client := musicbrainz.NewClient()
+	client.BaseURL = server.BaseURL()
+
+	if _, err := library.FetchRelease(context.Background(), db, client, broken.ID); !errors.Is(err, data.ErrInvalidMBID) {
+		t.Errorf("expected ErrInvalidMBID, but got %v", err)
+	}
+	if _, err := data.GetRelease(db, broken.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected nothing to be stored, but got %v", err)
+	}
+}
+
+func TestLinkFileToTrack(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if err := library.StoreRelease(db, &mbRelease); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file := writeWAV(t, db, filepath.Join(t.TempDir(), "a.wav"), tone(1, 440), "")
+	tracks, err := data.GetReleaseTracks(db, mbRelease.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := data.LinkFileToTrack(db, file.Path, tracks[1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Linking the same recording keeps the track
+	if err := data.LinkFileToRecording(db, file.Path, tracks[1].RecordingID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	linked, err := data.GetFile(db, file.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if linked.TrackID == nil || *linked.TrackID != tracks[1].ID {
+		t.Errorf("expected track %q to be linked, but got %v", tracks[1].ID, linked.TrackID)
+	}
+
+	if err := data.LinkFileToRecording(db, file.Path, tracks[0].RecordingID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	linked, err = data.GetFile(db, file.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if linked.TrackID != nil || linked.RecordingID == nil || *linked.RecordingID != tracks[0].RecordingID {
+		t.Errorf("expected only recording %q to be linked, but got %v and %v", tracks[0].RecordingID, linked.RecordingID, linked.TrackID)
+	}
+}
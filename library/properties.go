@@ -0,0 +1,144 @@
+package library
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// AudioProperties returns the sample-exact properties of a known file. Like
+// levels, they are stored per audio data.
+func AudioProperties(db sqlx.Ext, file schema.File) (schema.AudioProperties, error) {
+	if file.AudioHash != nil {
+		if p, err := data.GetAudioProperties(db, file.AudioHash); err == nil {
+			return p, nil
+		}
+	}
+	audioHash, err := updateAudioHash(db, file)
+	if err != nil {
+		return schema.AudioProperties{}, err
+	}
+	p, err := data.GetAudioProperties(db, audioHash)
+	if err == nil {
+		return p, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return schema.AudioProperties{}, err
+	}
+
+	p, err = readAudioProperties(file.Path)
+	if err != nil {
+		return schema.AudioProperties{}, err
+	}
+	p.AudioHash, p.Created = audioHash, time.Now()
+	return p, data.SaveAudioProperties(db, p)
+}
+
+// readAudioProperties reads the properties of the music file at path from
+// its decoder, or from the tags of AAC files, which can not be decoded.
+func readAudioProperties(path string) (schema.AudioProperties, error) {
+	d, err := decode.Open(path)
+	if errors.Is(err, decode.ErrUnsupportedFormat) {
+		return readMP4Properties(path)
+	}
+	if err != nil {
+		return schema.AudioProperties{}, err
+	}
+	defer d.Close()
+
+	p := schema.AudioProperties{SampleRate: d.SampleRate(), Channels: d.Channels(), BitsPerSample: d.BitsPerSample()}
+	g, ok := d.Gapless()
+	if ok {
+		p.EncoderDelay, p.EncoderPadding = &g.Delay, &g.Padding
+	}
+	if flac, ok := d.Decoder.(*decode.FLAC); ok {
+		p.Samples = flac.TotalSamples()
+	} else {
+		p.Samples = g.Samples
+	}
+	if p.Samples > 0 {
+		return p, nil
+	}
+	// Not recorded, so the decoded samples are counted
+	buf := make([]float32, 4096*p.Channels)
+	for {
+		n, err := d.Read(buf)
+		p.Samples += int64(n / p.Channels)
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return schema.AudioProperties{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+}
+
+// readMP4Properties reads the properties of an AAC file from its sample
+// description and its iTunSMPB tag, without which its length is unknown.
+func readMP4Properties(path string) (schema.AudioProperties, error) {
+	format, err := tags.ReadMP4Format(path)
+	if err != nil {
+		return schema.AudioProperties{}, err
+	}
+	t, err := tags.Read(path)
+	if err != nil {
+		return schema.AudioProperties{}, err
+	}
+	smpb, ok := t.Custom["ITUNSMPB"]
+	if !ok {
+		return schema.AudioProperties{}, fmt.Errorf("%s: %w: no iTunSMPB tag", path, decode.ErrUnsupportedFormat)
+	}
+	g, err := decode.ParseITunSMPB(smpb)
+	if err != nil {
+		return schema.AudioProperties{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return schema.AudioProperties{
+		SampleRate:     format.SampleRate,
+		Channels:       format.Channels,
+		Samples:        g.Samples,
+		EncoderDelay:   &g.Delay,
+		EncoderPadding: &g.Padding,
+	}, nil
+}
+
+// FileProperties are the audio properties of a single file.
+type FileProperties struct {
+	File       schema.File
+	Properties schema.AudioProperties
+}
+
+// AnalyzeProperties reads the audio properties of all known files below dir,
+// in path order. Files whose properties can not be read are returned with
+// the reason.
+func AnalyzeProperties(db sqlx.Ext, dir string) ([]FileProperties, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]error{}
+	var analysed []FileProperties
+	for _, file := range files {
+		p, err := AudioProperties(db, file)
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		analysed = append(analysed, FileProperties{File: file, Properties: p})
+	}
+	return analysed, failed, nil
+}
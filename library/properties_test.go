@@ -0,0 +1,83 @@
+package library_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/library"
+)
+
+// writeGaplessMP3 writes silent mono MP3 frames behind an Info frame with a
+// LAME tag recording the encoder delay and padding.
+func writeGaplessMP3(t *testing.T, db *sqlx.DB, path string, frames int, delay, padding int) schema.File {
+	header := []byte{0xFF, 0xFB, 0x90, 0xC0} // MPEG-1 Layer III, 128 kbit/s, 44100 Hz, mono
+	frame := func(mainData []byte) []byte {
+		f := append(append(append([]byte{}, header...), make([]byte, 17)...), mainData...)
+		return append(f, make([]byte, 417-len(f))...)
+	}
+	info := binary.BigEndian.AppendUint32([]byte("Info"), 1)
+	info = binary.BigEndian.AppendUint32(info, uint32(frames))
+	lame := make([]byte, 24)
+	copy(lame, "LAME3.100")
+	lame[21], lame[22], lame[23] = byte(delay>>4), byte(delay<<4|padding>>8), byte(padding)
+	content := frame(append(info, lame...))
+	for i := 0; i < frames; i++ {
+		content = append(content, frame(nil)...)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	hash, err := data.HashFile(path)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	file := schema.File{Path: path, Hash: hash, MediaType: "mp3", Size: uint(len(content)), Mod: time.Now()}
+	if err := data.SaveFile(db, file); err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+	return file
+}
+
+func TestAnalyzeProperties(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	writeGaplessMP3(t, db, filepath.Join(dir, "a.mp3"), 10, 576, 1000)
+	writeWAV(t, db, filepath.Join(dir, "b.wav"), tone(2, 440), "")
+
+	analysed, failed, err := library.AnalyzeProperties(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 0 || len(analysed) != 2 {
+		t.Fatalf("expected 2 analysed files, but got %+v and %v", analysed, failed)
+	}
+	p := analysed[0].Properties
+	if p.SampleRate != 44100 || p.Channels != 1 || p.BitsPerSample != 0 || p.Samples != 10*1152-576-1000 {
+		t.Errorf("expected %d samples at 44100 Hz, but got %+v", 10*1152-576-1000, p)
+	}
+	if p.EncoderDelay == nil || *p.EncoderDelay != 576 || *p.EncoderPadding != 1000 {
+		t.Errorf("expected a delay of 576 and padding of 1000 samples, but got %+v", p)
+	}
+	if p := analysed[1].Properties; p.SampleRate != 11025 || p.BitsPerSample != 16 || p.Samples != 2*11025 || p.EncoderDelay != nil || p.Duration() != 2 {
+		t.Errorf("expected 2 s of 16 bit audio without delay, but got %+v", p)
+	}
+
+	// Properties are reused for the same audio data
+	file, err := data.GetFile(db, filepath.Join(dir, "a.mp3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := data.GetAudioProperties(db, file.AudioHash); err != nil {
+		t.Errorf("expected stored properties, but got %v", err)
+	}
+}
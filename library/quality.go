@@ -0,0 +1,133 @@
+package library
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/quality"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Quality returns the spectral analysis of a known file. Like loudness, it is
+// stored per audio data.
+func Quality(db sqlx.Ext, file schema.File) (quality.Analysis, error) {
+	if file.AudioHash != nil {
+		if q, err := data.GetQuality(db, file.AudioHash); err == nil {
+			return decodeQuality(q), nil
+		}
+	}
+	audioHash, err := updateAudioHash(db, file)
+	if err != nil {
+		return quality.Analysis{}, err
+	}
+	q, err := data.GetQuality(db, audioHash)
+	if err == nil {
+		return decodeQuality(q), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return quality.Analysis{}, err
+	}
+
+	d, err := decode.Open(file.Path)
+	if err != nil {
+		return quality.Analysis{}, err
+	}
+	defer d.Close()
+	a, err := quality.Analyze(d)
+	if err != nil {
+		return quality.Analysis{}, fmt.Errorf("%s: %w", file.Path, err)
+	}
+	q = schema.Quality{
+		AudioHash:  audioHash,
+		SampleRate: a.SampleRate,
+		Bits:       a.Bits,
+		UsedBits:   a.UsedBits,
+		Cutoff:     a.Cutoff,
+		Shelf:      a.Shelf,
+		Created:    time.Now(),
+	}
+	return a, data.SaveQuality(db, q)
+}
+
+func decodeQuality(q schema.Quality) quality.Analysis {
+	return quality.Analysis{SampleRate: q.SampleRate, Bits: q.Bits, UsedBits: q.UsedBits, Cutoff: q.Cutoff, Shelf: q.Shelf}
+}
+
+// FileQuality is the spectral analysis of a single file.
+type FileQuality struct {
+	File     schema.File
+	Analysis quality.Analysis
+}
+
+// AnalyzeQuality analyses all known files below dir, in path order. Files
+// which can not be analysed are returned with the reason.
+func AnalyzeQuality(db sqlx.Ext, dir string) ([]FileQuality, map[string]error, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]error{}
+	var analysed []FileQuality
+	for _, file := range files {
+		a, err := Quality(db, file)
+		if err != nil {
+			failed[file.Path] = err
+			continue
+		}
+		analysed = append(analysed, FileQuality{File: file, Analysis: a})
+	}
+	return analysed, failed, nil
+}
+
+// RankDuplicates orders the files of group from best to worst quality:
+// lossless files which are not suspicious first, then by the bandwidth of
+// their content, the confidence of their suspicions and their size. A lossy
+// file so comes before the suspicious lossless file made from it. Files which
+// can not be analysed come last and are returned with the reason.
+func RankDuplicates(db sqlx.Ext, group DuplicateGroup) ([]FileQuality, map[string]error) {
+	failed := map[string]error{}
+	var ranked, unknown []FileQuality
+	for _, file := range group.Files {
+		a, err := Quality(db, file)
+		if err != nil {
+			failed[file.Path] = err
+			unknown = append(unknown, FileQuality{File: file})
+			continue
+		}
+		ranked = append(ranked, FileQuality{File: file, Analysis: a})
+	}
+
+	trusted := func(a quality.Analysis) bool { return a.Bits > 0 && a.Confidence() < quality.SUSPICIOUS }
+	bandwidth := func(a quality.Analysis) float64 {
+		if a.Cutoff > 0 {
+			return a.Cutoff
+		}
+		return float64(a.SampleRate) / 2
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i].Analysis, ranked[j].Analysis
+		switch {
+		case trusted(a) != trusted(b):
+			return trusted(a)
+		case bandwidth(a) != bandwidth(b):
+			return bandwidth(a) > bandwidth(b)
+		case a.Confidence() != b.Confidence():
+			return a.Confidence() < b.Confidence()
+		}
+		return ranked[i].File.Size > ranked[j].File.Size
+	})
+	return append(ranked, unknown...), failed
+}
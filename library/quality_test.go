@@ -0,0 +1,120 @@
+package library_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/audio/quality"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/library"
+)
+
+// padded returns samples with the low 8 bits cleared, as if they were
+// converted from 8 bits.
+func padded(samples []int16) []int16 {
+	for i := range samples {
+		samples[i] &^= 0xFF
+	}
+	return samples
+}
+
+func TestAnalyzeQuality(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	genuine := writeWAV(t, db, filepath.Join(dir, "genuine.wav"), tone(2, 440), "")
+	writeWAV(t, db, filepath.Join(dir, "padded.wav"), padded(tone(2, 440)), "")
+	broken := filepath.Join(dir, "broken.wav")
+	if err := os.WriteFile(broken, []byte("not audio at all"), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	hash, err := data.HashFile(broken)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	if err := data.SaveFile(db, schema.File{Path: broken, Hash: hash, MediaType: "wav", Size: 16, Mod: time.Now()}); err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+
+	analysed, failed, err := library.AnalyzeQuality(db, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 1 || failed[broken] == nil {
+		t.Errorf("expected %s to fail, but got %v", broken, failed)
+	}
+	if len(analysed) != 2 {
+		t.Fatalf("expected 2 analysed files, but got %+v", analysed)
+	}
+	if a := analysed[0].Analysis; a.Bits != 16 || a.UsedBits != 16 || len(a.Suspicions()) != 0 {
+		t.Errorf("expected genuine.wav to be unsuspicious, but got %+v", a)
+	}
+	if s := analysed[1].Analysis.Suspicions(); len(s) != 1 || s[0].Kind != quality.PADDED || s[0].Confidence != 1 {
+		t.Errorf("expected padded.wav to be padded, but got %+v", s)
+	}
+
+	// Analyses are reused for the same audio data
+	genuine, err = data.GetFile(db, genuine.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q, err := data.GetQuality(db, genuine.AudioHash)
+	if err != nil {
+		t.Fatalf("expected a stored analysis, but got %v", err)
+	}
+	q.Cutoff, q.Shelf = 4000, 60
+	if err := data.SaveQuality(db, q); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, err := library.Quality(db, genuine)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Cutoff != 4000 {
+		t.Errorf("expected the stored analysis, but got %+v", a)
+	}
+}
+
+func TestRankDuplicates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	genuine := writeWAV(t, db, filepath.Join(dir, "a.wav"), tone(2, 440), "")
+	fake := writeWAV(t, db, filepath.Join(dir, "b.wav"), padded(tone(2, 440)), "")
+	lossy := writeWAV(t, db, filepath.Join(dir, "c.wav"), tone(2, 660), "")
+	missing := writeWAV(t, db, filepath.Join(dir, "d.wav"), tone(2, 880), "")
+	if err := os.Remove(missing.Path); err != nil {
+		t.Fatalf("failed to remove test file: %v", err)
+	}
+	// Pretend c.wav is lossy without a cutoff
+	if _, err := library.Quality(db, lossy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lossy, err := data.GetFile(db, lossy.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := data.SaveQuality(db, schema.Quality{AudioHash: lossy.AudioHash, SampleRate: 11025, Created: time.Now()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	group := library.DuplicateGroup{Files: []schema.File{missing, fake, lossy, genuine}, Similarity: 0.95}
+	ranked, failed := library.RankDuplicates(db, group)
+	if len(failed) != 1 || failed[missing.Path] == nil {
+		t.Errorf("expected %s to fail, but got %v", missing.Path, failed)
+	}
+	expected := []string{genuine.Path, lossy.Path, fake.Path, missing.Path}
+	if len(ranked) != len(expected) {
+		t.Fatalf("expected %d files, but got %d", len(expected), len(ranked))
+	}
+	for i, path := range expected {
+		if ranked[i].File.Path != path {
+			t.Errorf("expected %s at rank %d, but got %s", path, i, ranked[i].File.Path)
+		}
+	}
+}
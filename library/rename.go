@@ -0,0 +1,184 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+var ErrTemplateHasDirectories = errors.New("rename templates must not contain directories")
+
+// PlanRename determines a new name for every known file below dir by filling
+// in template with its tags. Files stay in their directory. Two files mapping
+// to the same name are reported as conflict, on case insensitive filesystems
+// also if the names only differ in case.
+func PlanRename(db sqlx.Queryer, dir string, template *pathtemplate.Template, profile sanitize.Profile) ([]Placement, error) {
+	if strings.Contains(template.String(), "/") {
+		return nil, ErrTemplateHasDirectories
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := data.GetFilesBelow(db, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]Placement, 0, len(files))
+	renamed := map[string]bool{} // sources that will be renamed
+	for _, file := range files {
+		p := Placement{Source: file.Path}
+		t, err := tags.Read(file.Path)
+		if err != nil {
+			p.Skipped = err
+			plan = append(plan, p)
+			continue
+		}
+		name, err := template.Execute(t.Fields())
+		if err != nil {
+			p.Skipped = err
+			plan = append(plan, p)
+			continue
+		}
+		name = profile.Component(name + strings.ToLower(filepath.Ext(file.Path)))
+		p.Dest = filepath.Join(filepath.Dir(file.Path), name)
+		if err := data.ValidatePath(p.Dest); err != nil {
+			p.Skipped = fmt.Errorf("%w: %s: %w (use --sanitize strict)", data.ErrInvalidPath, p.Dest, err)
+		} else if p.Dest == p.Source {
+			p.UpToDate = true
+		} else {
+			renamed[p.Source] = true
+		}
+		plan = append(plan, p)
+	}
+
+	caseInsensitive := map[string]bool{} // per directory
+	claimed := map[string]string{}       // destination key -> source
+	for i := range plan {
+		p := &plan[i]
+		if p.Skipped != nil {
+			continue
+		}
+		parent := filepath.Dir(p.Dest)
+		if _, ok := caseInsensitive[parent]; !ok {
+			caseInsensitive[parent] = isCaseInsensitive(parent)
+		}
+		key := p.Dest
+		if caseInsensitive[parent] {
+			key = strings.ToLower(key)
+		}
+		if other, ok := claimed[key]; ok {
+			p.Skipped = fmt.Errorf("%w: %s is already planned for %s", ErrConflict, p.Dest, other)
+			delete(renamed, p.Source)
+			continue
+		}
+		claimed[key] = p.Source
+	}
+
+	for i := range plan {
+		p := &plan[i]
+		if p.Skipped != nil || p.UpToDate {
+			continue
+		}
+		info, err := os.Lstat(p.Dest)
+		if err != nil {
+			continue // free
+		}
+		if sourceInfo, err := os.Lstat(p.Source); err == nil && os.SameFile(info, sourceInfo) && strings.EqualFold(p.Source, p.Dest) {
+			p.CaseOnly = true
+			continue
+		}
+		if !renamed[existingPath(plan, p.Dest)] {
+			p.Skipped = fmt.Errorf("%w: %s already exists", ErrConflict, p.Dest)
+		}
+	}
+	return plan, nil
+}
+
+// existingPath returns the source in plan that currently occupies path, which
+// may differ in case on case insensitive filesystems.
+func existingPath(plan []Placement, path string) string {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return ""
+	}
+	for _, p := range plan {
+		if sourceInfo, err := os.Lstat(p.Source); err == nil && os.SameFile(info, sourceInfo) {
+			return p.Source
+		}
+	}
+	return ""
+}
+
+// Rename renames all files of plan which are neither skipped nor up to date
+// in a single journal transaction: either all files are renamed or none.
+// Case only renames and renames onto names of other renamed files go through
+// a temporary name. It returns the placements that were carried out.
+func Rename(j *journal.Journal, plan []Placement) ([]Placement, error) {
+	var done []Placement
+	err := j.Transaction(func() error {
+		var direct, viaTemp []Placement
+		for _, p := range plan {
+			if p.Skipped != nil || p.UpToDate {
+				continue
+			}
+			if _, err := os.Lstat(p.Dest); err == nil {
+				viaTemp = append(viaTemp, p)
+			} else {
+				direct = append(direct, p)
+			}
+		}
+
+		temps := make([]string, len(viaTemp))
+		for i, p := range viaTemp {
+			temps[i] = filepath.Join(filepath.Dir(p.Source), fmt.Sprintf(".musiman-rename-%d-%d%s", j.Batch(), i, filepath.Ext(p.Source)))
+			if _, err := j.Rename(p.Source, temps[i]); err != nil {
+				return fmt.Errorf("%s: %w", p.Source, err)
+			}
+		}
+		for _, p := range direct {
+			if _, err := j.Rename(p.Source, p.Dest); err != nil {
+				return fmt.Errorf("%s: %w", p.Source, err)
+			}
+			done = append(done, p)
+		}
+		for i, p := range viaTemp {
+			if _, err := j.Rename(temps[i], p.Dest); err != nil {
+				return fmt.Errorf("%s: %w", p.Source, err)
+			}
+			done = append(done, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// isCaseInsensitive reports whether the filesystem of the existing directory
+// dir treats names differing only in case as the same file.
+func isCaseInsensitive(dir string) bool {
+	f, err := os.CreateTemp(dir, ".musiman-case-*")
+	if err != nil {
+		return false
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+
+	base := filepath.Base(name)
+	_, err = os.Lstat(filepath.Join(dir, strings.ToUpper(base)))
+	return err == nil
+}
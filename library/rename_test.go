@@ -0,0 +1,139 @@
+package library_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+func setupTestDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Connect("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite database: %v", err)
+	}
+	db.SetMaxOpenConns(1) // every connection would get its own in-memory database
+	if err := data.Migrate(db); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	return db
+}
+
+// writeTaggedFLAC writes a minimal FLAC file with the given vorbis comments
+// and registers it in the files table.
+func writeTaggedFLAC(t *testing.T, db *sqlx.DB, path string, comments ...string) {
+	block := binary.LittleEndian.AppendUint32(nil, 0) // empty vendor
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, c := range comments {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(c)))
+		block = append(block, c...)
+	}
+	content := append([]byte("fLaC"), 0, 0, 0, 34)
+	content = append(content, make([]byte, 34)...)
+	content = append(content, 0x80|4, byte(len(block)>>16), byte(len(block)>>8), byte(len(block)))
+	content = append(content, block...)
+	content = append(content, path...) // unique content per file
+
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	hash, err := data.HashFile(path)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+	err = data.SaveFile(db, schema.File{Path: path, Hash: hash, MediaType: "flac", Size: uint(len(content)), Mod: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+}
+
+func TestRenameSwapAndConflict(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	journal.BackupDir = filepath.Join(dir, "backups")
+
+	// "01 A" and "02 B" swap their names, the last two files map to the same name
+	writeTaggedFLAC(t, db, filepath.Join(dir, "01 A.flac"), "TITLE=B", "TRACKNUMBER=2")
+	writeTaggedFLAC(t, db, filepath.Join(dir, "02 B.flac"), "TITLE=A", "TRACKNUMBER=1")
+	writeTaggedFLAC(t, db, filepath.Join(dir, "x.flac"), "TITLE=Same", "TRACKNUMBER=5")
+	writeTaggedFLAC(t, db, filepath.Join(dir, "y.flac"), "TITLE=Same", "TRACKNUMBER=5")
+
+	template, err := pathtemplate.Parse("{track:02} {title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan, err := library.PlanRename(db, dir, template, sanitize.POSIX)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan[3].Skipped == nil {
+		t.Errorf("expected second file mapping to the same name to be skipped")
+	}
+
+	j, err := journal.New(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	renamed, err := library.Rename(j, plan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(renamed) != 3 {
+		t.Errorf("expected 3 renamed files, but got %d", len(renamed))
+	}
+
+	file, err := data.GetFile(db, filepath.Join(dir, "05 Same.flac"))
+	if err != nil {
+		t.Errorf("expected files table to contain the new path, but got %v", err)
+	}
+	expectedHash, _ := data.HashFile(filepath.Join(dir, "05 Same.flac"))
+	if string(file.Hash) != string(expectedHash) {
+		t.Errorf("expected files table entry to match the renamed file")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "02 B.flac"))
+	if err != nil || !strings.HasSuffix(string(content), "01 A.flac") {
+		t.Errorf("expected former \"01 A.flac\" to be named \"02 B.flac\", but got %v", err)
+	}
+}
+
+func TestRenameKeepsUpToDateFiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	writeTaggedFLAC(t, db, filepath.Join(dir, "03 C.flac"), "TITLE=C", "TRACKNUMBER=3")
+
+	template, err := pathtemplate.Parse("{track:02} {title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan, err := library.PlanRename(db, dir, template, sanitize.POSIX)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan) != 1 || !plan[0].UpToDate {
+		t.Errorf("expected file to be up to date, but got %+v", plan)
+	}
+
+	dirTemplate, err := pathtemplate.Parse("{album}/{title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := library.PlanRename(db, dir, dirTemplate, sanitize.POSIX); !errors.Is(err, library.ErrTemplateHasDirectories) {
+		t.Errorf("expected ErrTemplateHasDirectories, but got %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package library
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/audio/transcode"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+// Mirroring a selection of the library to a target directory, i.e. the SD
+// card of a car stereo or a music player.
+
+// Name of the manifest in the target directory of a sync
+const SYNC_MANIFEST = ".musiman-sync.json"
+
+// Format recorded in the manifest for files copied without transcoding
+const SYNC_FORMAT_COPY = "copy"
+
+// Tolerance when comparing modification times of synced files, FAT only
+// stores them with a resolution of two seconds.
+const syncModTolerance = 2 * time.Second
+
+// SyncAction is the change of the target directory planned for a file.
+type SyncAction string
+
+const (
+	SYNC_COPY      SyncAction = "copy"
+	SYNC_TRANSCODE SyncAction = "transcode"
+	SYNC_DELETE    SyncAction = "delete"
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+	ErrUnknownFile  = errors.New("not a known file, scan it first")
+)
+
+// SyncItem is the planned change of a single file of the target directory.
+// Deleted files only have a Dest.
+type SyncItem struct {
+	Placement
+	Action SyncAction
+
+	key    string // slash separated path of Dest relative to the target
+	hash   []byte // content hash of Source
+	format string // as recorded in the manifest
+}
+
+// SyncPlan is the outcome of PlanSync.
+type SyncPlan struct {
+	Target string
+	// Deletions first, so replaced files make room for their successors
+	Items []SyncItem
+	// Manifest of the target without files which no longer exist
+	Manifest Manifest
+}
+
+// Manifest records the files a sync created in its target directory, so
+// repeated syncs neither hash nor transcode unchanged files and only ever
+// delete their own files.
+type Manifest struct {
+	// Synced files by their slash separated path relative to the target
+	Files map[string]ManifestEntry `json:"files"`
+}
+
+type ManifestEntry struct {
+	Source     string    `json:"source"`
+	SourceHash string    `json:"source_hash"` // hex encoded
+	Format     string    `json:"format"`      // SYNC_FORMAT_COPY or the transcoding profile
+	Size       int64     `json:"size"`
+	Mod        time.Time `json:"mod"`
+}
+
+// ReadManifest reads the manifest of target, an empty one if there is none.
+func ReadManifest(target string) (Manifest, error) {
+	m := Manifest{}
+	b, err := os.ReadFile(filepath.Join(target, SYNC_MANIFEST))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return m, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return m, fmt.Errorf("%s: %w", filepath.Join(target, SYNC_MANIFEST), err)
+		}
+	}
+	if m.Files == nil {
+		m.Files = map[string]ManifestEntry{}
+	}
+	return m, nil
+}
+
+// WriteManifest replaces the manifest of target. It is written to a temporary
+// file first, so an interrupted write keeps the previous one.
+func WriteManifest(target string, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(target, SYNC_MANIFEST+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(target, SYNC_MANIFEST))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Query selects files by their tags.
+type Query []queryTerm
+
+type queryTerm struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+// ParseQuery parses terms of the form field=pattern, with the field names of
+// path templates. Patterns are case insensitive and match the whole value,
+// * matches any number of characters. An empty pattern matches files without
+// the field. A file matches the query if it matches all terms.
+func ParseQuery(terms []string) (Query, error) {
+	q := make(Query, 0, len(terms))
+	for _, term := range terms {
+		field, pattern, found := strings.Cut(term, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !found || field == "" {
+			return nil, fmt.Errorf("%w: \"%s\" is not of the form field=pattern", ErrInvalidQuery, term)
+		}
+		expr := strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSpace(pattern)), `\*`, ".*")
+		q = append(q, queryTerm{field: field, pattern: regexp.MustCompile("(?is)^" + expr + "$")})
+	}
+	return q, nil
+}
+
+// Match reports whether fields, as returned by tags.Tags.Fields, match q.
+func (q Query) Match(fields map[string]string) bool {
+	for _, term := range q {
+		if !term.pattern.MatchString(fields[term.field]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadPlaylist returns the absolute paths of the entries of the M3U playlist
+// at path. Relative entries are relative to the directory of the playlist.
+func ReadPlaylist(path string) ([]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")) // M3U8 files may start with a BOM
+
+	paths := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		paths = append(paths, filepath.Clean(line))
+	}
+	return paths, nil
+}
+
+// PlanSync plans mirroring a selection of the known files to target. Without
+// playlist every distinct file outside of target is selected, else the known
+// files of playlist. Only the files matching query are kept. They are placed
+// below target like Plan does, with the names sanitized for the filesystem
+// described by sanitizeProfile. Lossless files are transcoded with profile
+// unless it is nil, all other files are copied.
+//
+// Files recorded in the manifest of target are up to date without hashing
+// them if their source content and format did not change and they still have
+// the recorded size and modification time, else they are replaced. Recorded
+// files which are no longer selected are deleted. Other existing files are
+// never touched, they are reported as conflicts.
+func PlanSync(db sqlx.Queryer, target string, playlist []string, query Query, template *pathtemplate.Template, sanitizeProfile sanitize.Profile, profile *transcode.Profile) (SyncPlan, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return SyncPlan{}, err
+	}
+	manifest, err := ReadManifest(target)
+	if err != nil {
+		return SyncPlan{}, err
+	}
+
+	var items []SyncItem
+	var files []schema.File
+	if playlist == nil {
+		if files, err = data.GetUniqueFilesOutside(db, target); err != nil {
+			return SyncPlan{}, err
+		}
+	} else {
+		seen := map[string]bool{} // hex content hashes
+		for _, path := range playlist {
+			file, err := data.GetFile(db, path)
+			if errors.Is(err, sql.ErrNoRows) {
+				items = append(items, SyncItem{Placement: Placement{Source: path, Skipped: ErrUnknownFile}, Action: SYNC_COPY})
+				continue
+			}
+			if err != nil {
+				return SyncPlan{}, err
+			}
+			if hash := hex.EncodeToString(file.Hash); !seen[hash] {
+				seen[hash] = true
+				files = append(files, file)
+			}
+		}
+	}
+
+	recorded := map[string]string{} // lower case manifest path -> manifest path
+	for key := range manifest.Files {
+		recorded[strings.ToLower(key)] = key
+	}
+	claimed := map[string]string{} // lower case destination -> source
+	var deletions []SyncItem
+	deleteRecorded := func(key string) {
+		path := filepath.Join(target, filepath.FromSlash(key))
+		if _, err := os.Lstat(path); err != nil {
+			delete(manifest.Files, key) // nothing left to delete
+			return
+		}
+		deletions = append(deletions, SyncItem{Placement: Placement{Dest: path}, Action: SYNC_DELETE, key: key})
+	}
+
+	for _, file := range files {
+		item := SyncItem{Placement: Placement{Source: file.Path}, Action: SYNC_COPY, hash: file.Hash, format: SYNC_FORMAT_COPY}
+		t, err := tags.Read(file.Path)
+		if err != nil {
+			item.Skipped = err
+			items = append(items, item)
+			continue
+		}
+		fields := t.Fields()
+		if !query.Match(fields) {
+			continue
+		}
+		relative, err := template.Execute(fields)
+		if err != nil {
+			item.Skipped = err
+			items = append(items, item)
+			continue
+		}
+		extension := strings.ToLower(filepath.Ext(file.Path))
+		if profile != nil && lossless(file.Path) {
+			item.Action = SYNC_TRANSCODE
+			item.format = profile.String()
+			extension = "." + profile.Extension
+		}
+		item.key = sanitizeProfile.Path(relative + extension)
+		item.Dest = filepath.Join(target, filepath.FromSlash(item.key))
+		// Transcoded files are added to the files table, copies are not
+		if err := data.ValidatePath(item.Dest); err != nil && item.Action == SYNC_TRANSCODE {
+			item.Skipped = fmt.Errorf("%w: %s: %w (use --sanitize strict)", data.ErrInvalidPath, item.Dest, err)
+			items = append(items, item)
+			continue
+		}
+
+		// Case insensitive, as FAT is
+		lower := strings.ToLower(item.key)
+		if other, ok := claimed[lower]; ok {
+			item.Skipped = fmt.Errorf("%w: %s is already planned for %s", ErrConflict, item.Dest, other)
+			items = append(items, item)
+			continue
+		}
+		claimed[lower] = file.Path
+
+		if key, ok := recorded[lower]; ok {
+			entry := manifest.Files[key]
+			if key == item.key && entry.SourceHash == hex.EncodeToString(file.Hash) && entry.Format == item.format && unchangedSince(item.Dest, entry) {
+				item.UpToDate = true
+			} else {
+				deleteRecorded(key)
+			}
+		} else if _, err := os.Lstat(item.Dest); err == nil {
+			item.Skipped = fmt.Errorf("%w: %s already exists and was not synced", ErrConflict, item.Dest)
+		}
+		items = append(items, item)
+	}
+
+	for lower, key := range recorded {
+		if _, ok := claimed[lower]; !ok {
+			deleteRecorded(key)
+		}
+	}
+	sort.Slice(deletions, func(a, b int) bool { return deletions[a].Dest < deletions[b].Dest })
+	return SyncPlan{Target: target, Items: append(deletions, items...), Manifest: manifest}, nil
+}
+
+// lossless reports whether the file at path is in a lossless format.
+func lossless(path string) bool {
+	d, err := decode.Open(path)
+	if err != nil {
+		return false
+	}
+	defer d.Close()
+	return d.BitsPerSample() > 0
+}
+
+// unchangedSince reports whether the file at path still has the size and
+// modification time recorded in entry.
+func unchangedSince(path string, entry ManifestEntry) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	diff := info.ModTime().Sub(entry.Mod)
+	return info.Size() == entry.Size && diff <= syncModTolerance && diff >= -syncModTolerance
+}
+
+// Sync carries out all changes of plan which are neither skipped nor up to
+// date, transcoding with profile and running up to jobs encoders at once.
+// Every change is recorded in j, directories left empty by deletions are
+// removed. The manifest of the target is updated with every synced file and
+// written at the end, also if some files failed. report is called for each
+// change with its result.
+func Sync(j *journal.Journal, plan SyncPlan, profile *transcode.Profile, jobs int, report func(item SyncItem, err error)) error {
+	if profile != nil {
+		if err := profile.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(plan.Target, 0o755); err != nil {
+		return err
+	}
+	manifest := plan.Manifest
+
+	var failed int
+	done := func(item SyncItem, err error) {
+		if err == nil && item.Action != SYNC_DELETE {
+			var info os.FileInfo
+			if info, err = os.Stat(item.Dest); err == nil {
+				manifest.Files[item.key] = ManifestEntry{
+					Source:     item.Source,
+					SourceHash: hex.EncodeToString(item.hash),
+					Format:     item.format,
+					Size:       info.Size(),
+					Mod:        info.ModTime(),
+				}
+			}
+		}
+		if err != nil {
+			failed++
+		}
+		report(item, err)
+	}
+
+	var transcodes []Placement
+	pending := map[string]SyncItem{} // by destination
+	for _, item := range plan.Items {
+		if item.Skipped != nil || item.UpToDate {
+			continue
+		}
+		switch item.Action {
+		case SYNC_DELETE:
+			_, err := j.Delete(item.Dest)
+			if err == nil {
+				delete(manifest.Files, item.key)
+				removeEmptyDirs(filepath.Dir(item.Dest), plan.Target)
+			}
+			done(item, err)
+		case SYNC_COPY:
+			_, err := j.Copy(item.Source, item.Dest)
+			done(item, err)
+		case SYNC_TRANSCODE:
+			if profile == nil {
+				done(item, fmt.Errorf("%w: missing profile", transcode.ErrInvalidProfile))
+				continue
+			}
+			transcodes = append(transcodes, item.Placement)
+			pending[item.Dest] = item
+		}
+	}
+	if len(transcodes) > 0 {
+		// Failed files are counted by done
+		Transcode(j, transcodes, *profile, jobs, func(p Placement, err error) {
+			done(pending[p.Dest], err)
+		})
+	}
+
+	if err := WriteManifest(plan.Target, manifest); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be synced", failed)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents below root as long as they are
+// empty.
+func removeEmptyDirs(dir string, root string) {
+	for strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,163 @@
+package library_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/audio/tags"
+	"github.com/makl11/musiman/journal"
+	"github.com/makl11/musiman/library"
+	"github.com/makl11/musiman/pathtemplate"
+	"github.com/makl11/musiman/sanitize"
+)
+
+func TestSync(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+	target := filepath.Join(t.TempDir(), "sdcard")
+	journal.BackupDir = filepath.Join(dir, "backups")
+
+	song := writeWAV(t, db, filepath.Join(dir, "song.wav"), tone(1, 440), "")
+	tagWAV(t, db, song, &tags.Tags{Title: "Song?", Artist: "Band", Album: "Live", Track: 1}, nil)
+	writeTaggedFLAC(t, db, filepath.Join(dir, "other.flac"), "ARTIST=Band", "ALBUM=Live", "TRACKNUMBER=2", "TITLE=Other")
+	writeTaggedFLAC(t, db, filepath.Join(dir, "third.flac"), "ARTIST=Band", "ALBUM=Live", "TRACKNUMBER=3", "TITLE=Third")
+	foreign := filepath.Join(target, "Band", "Live", "03 Third.flac")
+	if err := os.MkdirAll(filepath.Dir(foreign), 0o755); err != nil {
+		t.Fatalf("failed to create test directory: %v", err)
+	}
+	if err := os.WriteFile(foreign, []byte("not synced"), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	template, err := pathtemplate.Parse("{albumartist}/{album}/{track:02} {title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	profile := fakeEncoder(t, `cat > "$1"`)
+	sync := func(query ...string) library.SyncPlan {
+		q, err := library.ParseQuery(query)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		plan, err := library.PlanSync(db, target, nil, q, template, sanitize.FAT32, &profile)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		j, err := journal.New(db)
+		if err != nil {
+			t.Fatalf("failed to create journal: %v", err)
+		}
+		err = library.Sync(j, plan, &profile, 2, func(item library.SyncItem, err error) {
+			if err != nil {
+				t.Errorf("unexpected error syncing %s: %v", item.Source, err)
+			}
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return plan
+	}
+	actions := func(plan library.SyncPlan) map[string]library.SyncAction {
+		result := map[string]library.SyncAction{}
+		for _, item := range plan.Items {
+			if strings.HasPrefix(item.Source, target) {
+				t.Errorf("expected synced files not to be synced again, but got %s", item.Source)
+			}
+			switch {
+			case item.Skipped != nil:
+				result[filepath.Base(item.Source)] = "skip"
+			case item.UpToDate:
+				result[filepath.Base(item.Source)] = "keep"
+			case item.Action == library.SYNC_DELETE:
+				result[filepath.Base(item.Dest)] = item.Action
+			default:
+				result[filepath.Base(item.Source)] = item.Action
+			}
+		}
+		return result
+	}
+	expectActions := func(plan library.SyncPlan, expected map[string]library.SyncAction) {
+		result := actions(plan)
+		if len(result) != len(expected) {
+			t.Errorf("expected %v, but got %v", expected, result)
+		}
+		for name, action := range expected {
+			if result[name] != action {
+				t.Errorf("expected %s for %s, but got %v", action, name, result)
+			}
+		}
+	}
+
+	// Lossless files are transcoded, others copied, foreign files kept
+	plan := sync()
+	expectActions(plan, map[string]library.SyncAction{"song.wav": library.SYNC_TRANSCODE, "other.flac": library.SYNC_COPY, "third.flac": "skip"})
+	transcoded := filepath.Join(target, "Band", "Live", "01 Song_.mp3")
+	copied := filepath.Join(target, "Band", "Live", "02 Other.flac")
+	for _, path := range []string{transcoded, copied} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be synced, but got %v", path, err)
+		}
+	}
+	manifest, err := library.ReadManifest(target)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(manifest.Files) != 2 || manifest.Files["Band/Live/01 Song_.mp3"].Format != profile.String() || manifest.Files["Band/Live/02 Other.flac"].Format != library.SYNC_FORMAT_COPY {
+		t.Errorf("expected both synced files in the manifest, but got %+v", manifest.Files)
+	}
+
+	// Nothing changed
+	plan = sync()
+	expectActions(plan, map[string]library.SyncAction{"song.wav": "keep", "other.flac": "keep", "third.flac": "skip"})
+
+	// A changed synced file is replaced
+	if err := os.WriteFile(copied, []byte("changed on the device"), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	plan = sync()
+	expectActions(plan, map[string]library.SyncAction{"song.wav": "keep", "02 Other.flac": library.SYNC_DELETE, "other.flac": library.SYNC_COPY, "third.flac": "skip"})
+
+	// Files no longer selected are deleted, foreign ones never
+	plan = sync("title=song*")
+	expectActions(plan, map[string]library.SyncAction{"song.wav": "keep", "02 Other.flac": library.SYNC_DELETE})
+	if _, err := os.Stat(copied); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be deleted, but got %v", copied, err)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("expected %s to be kept, but got %v", foreign, err)
+	}
+	if manifest, err = library.ReadManifest(target); err != nil || len(manifest.Files) != 1 {
+		t.Errorf("expected only the transcoded file in the manifest, but got %+v and %v", manifest.Files, err)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	fields := map[string]string{"artist": "Miles Davis", "genre": "Jazz"}
+	tests := []struct {
+		terms    []string
+		expected bool
+	}{
+		{nil, true},
+		{[]string{"genre=jazz"}, true},
+		{[]string{"artist=Miles*"}, true},
+		{[]string{"artist=Miles"}, false},
+		{[]string{"artist=*davis", "genre=Rock"}, false},
+		{[]string{"album="}, true},
+		{[]string{"genre=(jazz)"}, false},
+	}
+	for _, tt := range tests {
+		q, err := library.ParseQuery(tt.terms)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tt.terms, err)
+		}
+		if result := q.Match(fields); result != tt.expected {
+			t.Errorf("expected %v for %v, but got %v", tt.expected, tt.terms, result)
+		}
+	}
+	if _, err := library.ParseQuery([]string{"jazz"}); err == nil {
+		t.Errorf("expected an error for a term without field")
+	}
+}
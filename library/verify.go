@@ -0,0 +1,35 @@
+package library
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+)
+
+// Verify checks the integrity of a known file by decoding all of it and
+// records its health together with the problems found. Files of formats which
+// can not be verified are recorded as unsupported. If the file can not be
+// read, nothing is recorded and the error is returned.
+func Verify(db sqlx.Execer, file schema.File) (string, []error, error) {
+	problems, err := decode.Verify(file.Path)
+	health := schema.HEALTH_OK
+	switch {
+	case errors.Is(err, decode.ErrUnsupportedFormat):
+		health = schema.HEALTH_UNSUPPORTED
+	case err != nil:
+		return "", nil, err
+	case len(problems) > 0:
+		health = schema.HEALTH_CORRUPT
+	}
+	lines := make([]string, len(problems))
+	for i, p := range problems {
+		lines[i] = p.Error()
+	}
+	return health, problems, data.SetFileHealth(db, file.Path, health, strings.Join(lines, "\n"), time.Now())
+}
@@ -0,0 +1,74 @@
+package library_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/audio/decode"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
+	"github.com/makl11/musiman/library"
+)
+
+func TestVerify(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	intact := writeWAV(t, db, filepath.Join(dir, "intact.wav"), tone(1, 440), "")
+	truncated := writeWAV(t, db, filepath.Join(dir, "truncated.wav"), tone(1, 440), "")
+	content, err := os.ReadFile(truncated.Path)
+	if err != nil {
+		t.Fatalf("failed to read test file: %v", err)
+	}
+	if err := os.WriteFile(truncated.Path, content[:len(content)/2], 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	unsupported := schema.File{Path: filepath.Join(dir, "unsupported.m4a"), Hash: intact.Hash, MediaType: "m4a", Size: 1, Mod: time.Now()}
+	if err := os.WriteFile(unsupported.Path, []byte("not audio at all"), 0o644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if err := data.SaveFile(db, unsupported); err != nil {
+		t.Fatalf("failed to save test file: %v", err)
+	}
+
+	tests := []struct {
+		file     schema.File
+		health   string
+		problems int
+	}{
+		{intact, schema.HEALTH_OK, 0},
+		{truncated, schema.HEALTH_CORRUPT, 2}, // RIFF and data chunk
+		{unsupported, schema.HEALTH_UNSUPPORTED, 0},
+	}
+	for _, tt := range tests {
+		health, problems, err := library.Verify(db, tt.file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if health != tt.health || len(problems) != tt.problems {
+			t.Errorf("expected %s with %d problems for %s, but got %s with %v", tt.health, tt.problems, tt.file.Path, health, problems)
+		}
+		for _, p := range problems {
+			if !errors.Is(p, decode.ErrTruncated) {
+				t.Errorf("expected a truncated file, but got %v", p)
+			}
+		}
+		file, err := data.GetFile(db, tt.file.Path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if file.Health == nil || *file.Health != tt.health || file.Verified == nil {
+			t.Errorf("expected %s to be recorded for %s, but got %v", tt.health, tt.file.Path, file.Health)
+		}
+	}
+	if err := os.Remove(intact.Path); err != nil {
+		t.Fatalf("failed to remove test file: %v", err)
+	}
+	if _, _, err := library.Verify(db, intact); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected error %v, but got %v", os.ErrNotExist, err)
+	}
+}
@@ -0,0 +1,99 @@
+package musicbrainz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Readers for the MusicBrainz data dumps, https://musicbrainz.org/doc/MusicBrainz_Database/Download.
+// Both the JSON dumps and the PostgreSQL dumps extract to a directory named
+// mbdump containing one file per entity or table.
+
+const DUMP_DIR = "mbdump"
+
+var ErrInvalidDump = errors.New("invalid MusicBrainz data dump")
+
+// TSVReader reads the rows of a table in the PostgreSQL dumps, which are
+// written in the text format of COPY: one row per line, columns separated by
+// tabs, special characters escaped with backslashes and NULL written as \N.
+type TSVReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func NewTSVReader(r io.Reader) *TSVReader {
+	return &TSVReader{r: bufio.NewReader(r)}
+}
+
+// Line returns the number of the last row read, starting at 1.
+func (t *TSVReader) Line() int {
+	return t.line
+}
+
+// Read returns the columns of the next row with escape sequences replaced.
+// NULL is returned as an empty string. At the end of the table Read returns
+// io.EOF.
+func (t *TSVReader) Read() ([]string, error) {
+	line, err := t.r.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil // last row without line break
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.line++
+	line = strings.TrimSuffix(line, "\n")
+	if line == `\.` {
+		return nil, io.EOF // end of data marker of COPY
+	}
+
+	columns := strings.Split(line, "\t")
+	for i, c := range columns {
+		if c == `\N` {
+			columns[i] = ""
+			continue
+		}
+		if columns[i], err = unescape(c); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidDump, t.line, err)
+		}
+	}
+	return columns, nil
+}
+
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("incomplete escape sequence")
+		}
+		switch s[i] {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		default:
+			// Any other character stands for itself, i.e. "\\"
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,56 @@
+package musicbrainz_test
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/musicbrainz"
+)
+
+func TestTSVReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected [][]string
+	}{
+		{"plain", "1\tabc\tName\n2\tdef\tOther\n", [][]string{{"1", "abc", "Name"}, {"2", "def", "Other"}}},
+		{"null", "1\t\\N\t\n", [][]string{{"1", "", ""}}},
+		{"escaped", "1\tTab\\there\tLine\\nbreak\tBack\\\\slash\n", [][]string{{"1", "Tab\there", "Line\nbreak", "Back\\slash"}}},
+		{"no final line break", "1\tabc", [][]string{{"1", "abc"}}},
+		{"end of data marker", "1\tabc\n\\.\n2\tdef\n", [][]string{{"1", "abc"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := musicbrainz.NewTSVReader(strings.NewReader(test.input))
+			var rows [][]string
+			for {
+				row, err := r.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				rows = append(rows, row)
+			}
+			if !reflect.DeepEqual(rows, test.expected) {
+				t.Errorf("expected %q, but got %q", test.expected, rows)
+			}
+		})
+	}
+}
+
+func TestTSVReaderInvalid(t *testing.T) {
+	r := musicbrainz.NewTSVReader(strings.NewReader("1\tabc\n2\tdef\\\n"))
+	if _, err := r.Read(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.Read(); !errors.Is(err, musicbrainz.ErrInvalidDump) {
+		t.Errorf("expected ErrInvalidDump, but got %v", err)
+	}
+	if r.Line() != 2 {
+		t.Errorf("expected line 2, but got %d", r.Line())
+	}
+}
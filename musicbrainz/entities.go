@@ -0,0 +1,106 @@
+package musicbrainz
+
+import "strings"
+
+// Entities as returned by the web service and found in the JSON data dumps.
+// Only the fields used by musiman are decoded.
+
+type Artist struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	SortName       string `json:"sort-name"`
+	Type           string `json:"type,omitempty"`
+	Country        string `json:"country,omitempty"`
+	Disambiguation string `json:"disambiguation,omitempty"`
+}
+
+// ArtistCredit is one artist as credited on a recording, release or track.
+type ArtistCredit struct {
+	Name       string `json:"name"`       // as credited, may differ from the artist name
+	JoinPhrase string `json:"joinphrase"` // between this and the next credited artist
+	Artist     Artist `json:"artist"`
+}
+
+// Credits is the list of credited artists of an entity.
+type Credits []ArtistCredit
+
+// String returns the credited names joined as credited, i.e. "A feat. B".
+func (c Credits) String() string {
+	var b strings.Builder
+	for _, credit := range c {
+		b.WriteString(credit.Name)
+		b.WriteString(credit.JoinPhrase)
+	}
+	return b.String()
+}
+
+type Recording struct {
+	ID             string    `json:"id"`
+	Title          string    `json:"title"`
+	Length         *int      `json:"length"` // in milliseconds, nil if unknown
+	Disambiguation string    `json:"disambiguation,omitempty"`
+	ArtistCredit   Credits   `json:"artist-credit,omitempty"`
+	ISRCs          []string  `json:"isrcs,omitempty"`
+	Releases       []Release `json:"releases,omitempty"`
+}
+
+type ReleaseGroup struct {
+	ID               string    `json:"id"`
+	Title            string    `json:"title"`
+	PrimaryType      string    `json:"primary-type,omitempty"`
+	SecondaryTypes   []string  `json:"secondary-types,omitempty"`
+	FirstReleaseDate string    `json:"first-release-date,omitempty"`
+	ArtistCredit     Credits   `json:"artist-credit,omitempty"`
+	Releases         []Release `json:"releases,omitempty"`
+}
+
+type Label struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type LabelInfo struct {
+	CatalogNumber string `json:"catalog-number"`
+	Label         *Label `json:"label"`
+}
+
+type Track struct {
+	ID           string    `json:"id"`
+	Number       string    `json:"number"`   // as printed, i.e. "A1"
+	Position     int       `json:"position"` // on the medium, starting at 1
+	Title        string    `json:"title"`
+	Length       *int      `json:"length"` // in milliseconds, nil if unknown
+	ArtistCredit Credits   `json:"artist-credit,omitempty"`
+	Recording    Recording `json:"recording"`
+}
+
+type Medium struct {
+	Position   int     `json:"position"` // disc number, starting at 1
+	Format     string  `json:"format,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	TrackCount int     `json:"track-count"`
+	Tracks     []Track `json:"tracks,omitempty"`
+}
+
+type Release struct {
+	ID             string        `json:"id"`
+	Title          string        `json:"title"`
+	Status         string        `json:"status,omitempty"`
+	Date           string        `json:"date,omitempty"` // "YYYY", "YYYY-MM" or "YYYY-MM-DD"
+	Country        string        `json:"country,omitempty"`
+	Barcode        string        `json:"barcode,omitempty"`
+	Disambiguation string        `json:"disambiguation,omitempty"`
+	ArtistCredit   Credits       `json:"artist-credit,omitempty"`
+	ReleaseGroup   *ReleaseGroup `json:"release-group,omitempty"`
+	LabelInfo      []LabelInfo   `json:"label-info,omitempty"`
+	Media          []Medium      `json:"media,omitempty"`
+}
+
+// TrackCount returns the number of tracks on all media.
+func (r *Release) TrackCount() int {
+	n := 0
+	for _, m := range r.Media {
+		n += m.TrackCount
+	}
+	return n
+}
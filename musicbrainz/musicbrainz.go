@@ -0,0 +1,143 @@
+package musicbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/makl11/musiman/throttle"
+)
+
+// Client of the MusicBrainz web service (WS/2) using its JSON format.
+// https://musicbrainz.org/doc/MusicBrainz_API
+
+const (
+	BASE_URL            = "https://musicbrainz.org/ws/2"
+	REQUESTS_PER_SECOND = 1
+	// Identifies musiman as required by the rate limiting rules
+	USER_AGENT = "musiman/0.1 ( https://github.com/makl11/musiman )"
+	// Retries of requests rejected with 503 Service Unavailable, which
+	// MusicBrainz answers when its rate limit is exceeded
+	MAX_RETRIES = 3
+)
+
+var (
+	ErrNotFound     = errors.New("not found on MusicBrainz")
+	ErrServiceError = errors.New("MusicBrainz web service error")
+)
+
+type Client struct {
+	BaseURL    string
+	UserAgent  string
+	HTTPClient *http.Client
+	// Wait before retrying a request without Retry-After header, doubled for
+	// each retry
+	RetryDelay time.Duration
+
+	throttle *throttle.Throttle
+}
+
+func NewClient() *Client {
+	return &Client{
+		BaseURL:    BASE_URL,
+		UserAgent:  USER_AGENT,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		RetryDelay: time.Second,
+		throttle:   throttle.New(REQUESTS_PER_SECOND),
+	}
+}
+
+// GetArtist returns the artist with the given MBID.
+func (c *Client) GetArtist(ctx context.Context, id string) (*Artist, error) {
+	var artist Artist
+	return &artist, c.get(ctx, "artist", id, nil, &artist)
+}
+
+// GetRecording returns the recording with the given MBID, including its
+// artists and the releases it appears on.
+func (c *Client) GetRecording(ctx context.Context, id string) (*Recording, error) {
+	var recording Recording
+	return &recording, c.get(ctx, "recording", id, []string{"artist-credits", "releases", "isrcs"}, &recording)
+}
+
+// GetRelease returns the release with the given MBID, including its artists,
+// release group and all media with their tracks and recordings.
+func (c *Client) GetRelease(ctx context.Context, id string) (*Release, error) {
+	var release Release
+	return &release, c.get(ctx, "release", id, []string{"artist-credits", "release-groups", "recordings", "media", "labels"}, &release)
+}
+
+// GetReleaseGroup returns the release group with the given MBID, including
+// its artists and releases.
+func (c *Client) GetReleaseGroup(ctx context.Context, id string) (*ReleaseGroup, error) {
+	var group ReleaseGroup
+	return &group, c.get(ctx, "release-group", id, []string{"artist-credits", "releases"}, &group)
+}
+
+// get looks up an entity and decodes it into v.
+func (c *Client) get(ctx context.Context, entity string, id string, inc []string, v any) error {
+	query := url.Values{"fmt": {"json"}}
+	if len(inc) > 0 {
+		// Encoded as "a+b" like in the documentation
+		query.Set("inc", strings.Join(inc, " "))
+	}
+	u := strings.TrimSuffix(c.BaseURL, "/") + "/" + entity + "/" + url.PathEscape(id) + "?" + query.Encode()
+
+	delay := c.RetryDelay
+	for retry := 0; ; retry++ {
+		if err := c.throttle.Wait(ctx); err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", c.UserAgent)
+		req.Header.Set("Accept", "application/json")
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			if err := json.Unmarshal(body, v); err != nil {
+				return fmt.Errorf("%w: %s %s: %w", ErrServiceError, entity, id, err)
+			}
+			return nil
+		case resp.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("%w: %s %s", ErrNotFound, entity, id)
+		case resp.StatusCode == http.StatusServiceUnavailable && retry < MAX_RETRIES:
+			wait := delay
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			delay *= 2
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			var e struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(body, &e) == nil && e.Error != "" {
+				return fmt.Errorf("%w: %s %s: %s (%s)", ErrServiceError, entity, id, e.Error, resp.Status)
+			}
+			return fmt.Errorf("%w: %s %s: %s", ErrServiceError, entity, id, resp.Status)
+		}
+	}
+}
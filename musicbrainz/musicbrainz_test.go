@@ -0,0 +1,121 @@
+package musicbrainz_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/musicbrainz"
+	"github.com/makl11/musiman/musicbrainz/musicbrainztest"
+)
+
+func length(ms int) *int { return &ms }
+
+var (
+	artist  = musicbrainz.Artist{ID: "a1", Name: "Band", SortName: "Band, The"}
+	guest   = musicbrainz.Artist{ID: "a2", Name: "Guest", SortName: "Guest"}
+	release = musicbrainz.Release{
+		ID:           "r1",
+		Title:        "Album",
+		Date:         "2001-05-03",
+		ArtistCredit: musicbrainz.Credits{{Name: "The Band", Artist: artist}},
+		ReleaseGroup: &musicbrainz.ReleaseGroup{ID: "g1", Title: "Album", PrimaryType: "Album"},
+		Media: []musicbrainz.Medium{{Position: 1, Format: "CD", TrackCount: 2, Tracks: []musicbrainz.Track{
+			{ID: "t1", Number: "1", Position: 1, Title: "Intro", Length: length(61000), Recording: musicbrainz.Recording{ID: "rec1", Title: "Intro"}},
+			{ID: "t2", Number: "2", Position: 2, Title: "Song", Length: nil, Recording: musicbrainz.Recording{ID: "rec2", Title: "Song"},
+				ArtistCredit: musicbrainz.Credits{{Name: "The Band", JoinPhrase: " feat. ", Artist: artist}, {Name: "Guest", Artist: guest}}},
+		}}},
+	}
+)
+
+func newTestClient(server *musicbrainztest.Server) *musicbrainz.Client {
+	client := musicbrainz.NewClient()
+	client.BaseURL = server.BaseURL()
+	client.RetryDelay = time.Millisecond
+	return client
+}
+
+func TestGetRelease(t *testing.T) {
+	server := musicbrainztest.NewServer()
+	defer server.Close()
+	server.AddRelease(release)
+
+	result, err := newTestClient(server).GetRelease(context.Background(), "r1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Title != "Album" || result.ReleaseGroup == nil || result.ReleaseGroup.ID != "g1" {
+		t.Errorf("expected release %q of release group %q, but got %+v", "Album", "g1", result)
+	}
+	if result.TrackCount() != 2 || result.Media[0].Tracks[1].Recording.ID != "rec2" {
+		t.Fatalf("expected 2 tracks with their recordings, but got %+v", result.Media)
+	}
+	if credit := result.Media[0].Tracks[1].ArtistCredit.String(); credit != "The Band feat. Guest" {
+		t.Errorf("expected artist credit %q, but got %q", "The Band feat. Guest", credit)
+	}
+	if l := result.Media[0].Tracks[0].Length; l == nil || *l != 61000 {
+		t.Errorf("expected a length of 61000ms, but got %v", l)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, but got %d", len(requests))
+	}
+	if requests[0].Path != "/ws/2/release/r1" || requests[0].UserAgent != musicbrainz.USER_AGENT {
+		t.Errorf("expected a request of the release with the musiman user agent, but got %+v", requests[0])
+	}
+	if requests[0].Inc != "artist-credits release-groups recordings media labels" {
+		t.Errorf("expected the tracks to be included, but got inc %q", requests[0].Inc)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	server := musicbrainztest.NewServer()
+	defer server.Close()
+
+	if _, err := newTestClient(server).GetArtist(context.Background(), "unknown"); !errors.Is(err, musicbrainz.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, but got %v", err)
+	}
+}
+
+func TestGetRetryUnavailable(t *testing.T) {
+	server := musicbrainztest.NewServer()
+	defer server.Close()
+	server.AddArtist(artist)
+	server.SetUnavailable(2)
+
+	result, err := newTestClient(server).GetArtist(context.Background(), "a1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Name != "Band" {
+		t.Errorf("expected artist %q, but got %q", "Band", result.Name)
+	}
+
+	// Retries respect the rate limit as well
+	requests := server.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, but got %d", len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		// Allow for the resolution of the clock
+		if gap := requests[i].Time.Sub(requests[i-1].Time); gap < time.Second-5*time.Millisecond {
+			t.Errorf("expected at least 1s between requests, but got %v", gap)
+		}
+	}
+}
+
+func TestGetCanceledWhileRetrying(t *testing.T) {
+	server := musicbrainztest.NewServer()
+	defer server.Close()
+	server.AddArtist(artist)
+	server.SetUnavailable(musicbrainz.MAX_RETRIES + 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// Waiting for the rate limit ends with the context
+	if _, err := newTestClient(server).GetArtist(ctx, "a1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, but got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+// Package musicbrainztest provides an in-process fake of the MusicBrainz web
+// service, so lookups can be tested without network access.
+package musicbrainztest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makl11/musiman/musicbrainz"
+)
+
+// Request is a request received by a Server.
+type Request struct {
+	Time      time.Time
+	Path      string // i.e. "/ws/2/release/<id>"
+	Inc       string // included subqueries separated by spaces
+	UserAgent string
+}
+
+// Server serves registered entities below /ws/2, like musicbrainz.org.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	entities    map[string]any // by "<entity>/<id>"
+	unavailable int
+	requests    []Request
+}
+
+// NewServer starts a Server. It has to be closed after use.
+func NewServer() *Server {
+	s := &Server{entities: map[string]any{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// BaseURL returns the URL to use as musicbrainz.Client.BaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/ws/2"
+}
+
+func (s *Server) add(entity string, id string, v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entities[entity+"/"+id] = v
+}
+
+func (s *Server) AddArtist(a musicbrainz.Artist) { s.add("artist", a.ID, a) }
+
+func (s *Server) AddRecording(r musicbrainz.Recording) { s.add("recording", r.ID, r) }
+
+func (s *Server) AddRelease(r musicbrainz.Release) { s.add("release", r.ID, r) }
+
+func (s *Server) AddReleaseGroup(g musicbrainz.ReleaseGroup) { s.add("release-group", g.ID, g) }
+
+// SetUnavailable makes the server answer the next n requests with 503
+// Service Unavailable, like musicbrainz.org does when rate limiting.
+func (s *Server) SetUnavailable(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = n
+}
+
+// Requests returns all requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Time: time.Now(), Path: r.URL.Path, Inc: r.URL.Query().Get("inc"), UserAgent: r.UserAgent()})
+	unavailable := s.unavailable > 0
+	if unavailable {
+		s.unavailable--
+	}
+	entity, found := s.entities[strings.TrimPrefix(r.URL.Path, "/ws/2/")]
+	s.mu.Unlock()
+
+	switch {
+	case unavailable:
+		w.Header().Set("Retry-After", "0")
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Your requests are exceeding the allowable rate limit."})
+	case r.URL.Query().Get("fmt") != "json":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "only the json format is supported"})
+	case !found:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not Found"})
+	default:
+		writeJSON(w, http.StatusOK, entity)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,235 @@
+package pathtemplate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A path template describes a relative file path (without extension) built
+// from tag fields, i.e.
+//
+//	{albumartist}/{year} - {album}/{disc:02}-{track:02} {title}
+//
+// "/" separates directories. Placeholders consist of a field name, an optional
+// format spec after ":" and an optional default value after "|":
+//
+//	{track:02}        numeric value, zero padded to two digits
+//	{year|0000}       "0000" if the file has no year
+//	{{ and }}         literal braces
+
+var (
+	ErrSyntax       = errors.New("invalid path template")
+	ErrMissingField = errors.New("missing field value")
+	ErrInvalidPath  = errors.New("template produces an invalid path")
+	ErrNoMatch      = errors.New("path does not match template")
+)
+
+// Fields that only match digits when parsing paths
+var numericFields = map[string]bool{
+	"track":      true,
+	"tracktotal": true,
+	"disc":       true,
+	"disctotal":  true,
+	"year":       true,
+}
+
+type segment struct {
+	literal    string // only set for literal text
+	field      string
+	width      int
+	zeroPad    bool
+	fallback   string
+	hasDefault bool
+}
+
+type Template struct {
+	raw      string
+	segments []segment
+	pattern  *regexp.Regexp // used by Match
+}
+
+func Parse(template string) (*Template, error) {
+	t := &Template{raw: template}
+	var literal strings.Builder
+	flushLiteral := func() {
+		if literal.Len() > 0 {
+			t.segments = append(t.segments, segment{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '{' && strings.HasPrefix(template[i:], "{{"):
+			literal.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(template[i:], "}}"):
+			literal.WriteByte('}')
+			i++
+		case c == '}':
+			return nil, fmt.Errorf("%w: unmatched \"}\" at position %d", ErrSyntax, i)
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unclosed \"{\" at position %d", ErrSyntax, i)
+			}
+			seg, err := parsePlaceholder(template[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("%w at position %d", err, i)
+			}
+			flushLiteral()
+			t.segments = append(t.segments, seg)
+			i += end
+		case c == '\\':
+			return nil, fmt.Errorf("%w: use \"/\" to separate directories", ErrSyntax)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flushLiteral()
+
+	if len(t.segments) == 0 {
+		return nil, fmt.Errorf("%w: template is empty", ErrSyntax)
+	}
+	if strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("%w: template must describe a relative path", ErrSyntax)
+	}
+	t.pattern = compilePattern(t.segments)
+	return t, nil
+}
+
+// compilePattern builds the regular expression used by Match. Fields match
+// as little as possible within a single path component.
+func compilePattern(segments []segment) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?:^|/)")
+	for _, seg := range segments {
+		switch {
+		case seg.literal != "":
+			expr.WriteString(regexp.QuoteMeta(seg.literal))
+		case numericFields[seg.field]:
+			expr.WriteString(`\s*(\d+)`) // allows space padding
+		default:
+			expr.WriteString(`([^/]+?)`)
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+func parsePlaceholder(placeholder string) (segment, error) {
+	var seg segment
+	placeholder, seg.fallback, seg.hasDefault = strings.Cut(placeholder, "|")
+	name, spec, hasSpec := strings.Cut(placeholder, ":")
+	seg.field = strings.ToLower(strings.TrimSpace(name))
+	if seg.field == "" {
+		return seg, fmt.Errorf("%w: placeholder without field name", ErrSyntax)
+	}
+	if strings.ContainsAny(seg.field, "{/") {
+		return seg, fmt.Errorf("%w: invalid field name \"%s\"", ErrSyntax, seg.field)
+	}
+	if hasSpec {
+		seg.zeroPad = strings.HasPrefix(spec, "0")
+		width, err := strconv.Atoi(spec)
+		if err != nil || width < 0 {
+			return seg, fmt.Errorf("%w: invalid format spec \"%s\" for field \"%s\"", ErrSyntax, spec, seg.field)
+		}
+		seg.width = width
+	}
+	return seg, nil
+}
+
+func (t *Template) String() string {
+	return t.raw
+}
+
+// Fields returns the names of all fields used in the template.
+func (t *Template) Fields() []string {
+	var fields []string
+	for _, seg := range t.segments {
+		if seg.literal == "" {
+			fields = append(fields, seg.field)
+		}
+	}
+	return fields
+}
+
+// Execute fills in the template with fields, a map of lower case field names
+// to values. Path separators in values are replaced, so a value never adds a
+// directory level. The result uses "/" as separator.
+func (t *Template) Execute(fields map[string]string) (string, error) {
+	var b strings.Builder
+	var missing []string
+	for _, seg := range t.segments {
+		if seg.literal != "" {
+			b.WriteString(seg.literal)
+			continue
+		}
+		value, ok := fields[seg.field]
+		if !ok || value == "" {
+			if !seg.hasDefault {
+				missing = append(missing, seg.field)
+				continue
+			}
+			value = seg.fallback
+		}
+		b.WriteString(formatValue(value, seg))
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrMissingField, strings.Join(missing, ", "))
+	}
+
+	path := b.String()
+	for _, component := range strings.Split(path, "/") {
+		if component == "" || component == "." || component == ".." {
+			return "", fmt.Errorf("%w: \"%s\"", ErrInvalidPath, path)
+		}
+	}
+	return path, nil
+}
+
+func formatValue(value string, seg segment) string {
+	value = strings.NewReplacer("/", "-", "\\", "-").Replace(value)
+	if seg.width == 0 {
+		return value
+	}
+	if n, err := strconv.Atoi(value); err == nil && seg.zeroPad {
+		return fmt.Sprintf("%0*d", seg.width, n)
+	}
+	if pad := seg.width - len([]rune(value)); pad > 0 {
+		return strings.Repeat(" ", pad) + value
+	}
+	return value
+}
+
+// Match is the reverse of Execute: it extracts field values from path, which
+// has to end with the directories and file name described by the template.
+// path uses "/" as separator and has no extension. Leading directories not
+// covered by the template are ignored. Numeric fields only match digits.
+func (t *Template) Match(path string) (map[string]string, error) {
+	match := t.pattern.FindStringSubmatch(path)
+	if match == nil {
+		return nil, fmt.Errorf("%w: \"%s\"", ErrNoMatch, path)
+	}
+	fields := map[string]string{}
+	i := 1
+	for _, seg := range t.segments {
+		if seg.literal != "" {
+			continue
+		}
+		value := strings.TrimSpace(match[i])
+		i++
+		if seg.hasDefault && value == seg.fallback {
+			continue
+		}
+		if previous, ok := fields[seg.field]; ok && previous != value {
+			return nil, fmt.Errorf("%w: \"%s\" has different values for field \"%s\"", ErrNoMatch, path, seg.field)
+		}
+		fields[seg.field] = value
+	}
+	return fields, nil
+}
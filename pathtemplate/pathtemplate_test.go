@@ -0,0 +1,134 @@
+package pathtemplate_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/makl11/musiman/pathtemplate"
+)
+
+var testFields = map[string]string{
+	"albumartist": "AC/DC",
+	"album":       "Back in Black",
+	"year":        "1980",
+	"disc":        "1",
+	"track":       "6",
+	"title":       "Back in Black",
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{albumartist}/{year} - {album}/{disc:02}-{track:02} {title}", "AC-DC/1980 - Back in Black/01-06 Back in Black"},
+		{"{artist|Unknown Artist}/{title}", "Unknown Artist/Back in Black"},
+		{"{track:3}", "  6"},
+		{"{{{title}}}", "{Back in Black}"},
+		{"{ALBUM}", "Back in Black"},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := pathtemplate.Parse(tt.template)
+		if err != nil {
+			t.Fatalf("unexpected error for template %s: %v", tt.template, err)
+		}
+		result, err := tmpl.Execute(testFields)
+		if err != nil {
+			t.Errorf("unexpected error for template %s: %v", tt.template, err)
+		}
+		if result != tt.expected {
+			t.Errorf("expected %q, got %q for template %s", tt.expected, result, tt.template)
+		}
+	}
+}
+
+func TestParseInvalidTemplates(t *testing.T) {
+	invalidTemplates := []string{"", "{title", "title}", "{}", "{track:x}", "/{title}", "{album}\\{title}"}
+
+	for _, template := range invalidTemplates {
+		_, err := pathtemplate.Parse(template)
+		if !errors.Is(err, pathtemplate.ErrSyntax) {
+			t.Errorf("expected ErrSyntax for template %q, but got %v", template, err)
+		}
+	}
+}
+
+func TestExecuteMissingField(t *testing.T) {
+	tmpl, err := pathtemplate.Parse("{genre}/{composer}/{title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = tmpl.Execute(testFields)
+	if !errors.Is(err, pathtemplate.ErrMissingField) {
+		t.Errorf("expected ErrMissingField, but got %v", err)
+	}
+}
+
+func TestExecuteInvalidPath(t *testing.T) {
+	tmpl, err := pathtemplate.Parse("{album}/{title}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = tmpl.Execute(map[string]string{"album": "..", "title": "x"})
+	if !errors.Is(err, pathtemplate.ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath, but got %v", err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		expected map[string]string
+	}{
+		{"{artist} - {album}/{track} {title}", "/music/Daft Punk - Discovery/01 One More Time", map[string]string{"artist": "Daft Punk", "album": "Discovery", "track": "01", "title": "One More Time"}},
+		{"{track:02} - {title}", "03 - Rock - n - Roll", map[string]string{"track": "03", "title": "Rock - n - Roll"}},
+		{"{artist|Unknown Artist}/{title}", "Unknown Artist/Intro", map[string]string{"title": "Intro"}},
+		{"{track:3} {title}", "  6 Six", map[string]string{"track": "6", "title": "Six"}},
+		{"{album}/{album} {track}", "a/b/Discovery/Discovery 2", map[string]string{"album": "Discovery", "track": "2"}},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := pathtemplate.Parse(tt.template)
+		if err != nil {
+			t.Fatalf("unexpected error for template %s: %v", tt.template, err)
+		}
+		fields, err := tmpl.Match(tt.path)
+		if err != nil {
+			t.Errorf("unexpected error for template %s and path %s: %v", tt.template, tt.path, err)
+			continue
+		}
+		if len(fields) != len(tt.expected) {
+			t.Errorf("expected %v for path %s, but got %v", tt.expected, tt.path, fields)
+			continue
+		}
+		for name, value := range tt.expected {
+			if fields[name] != value {
+				t.Errorf("expected %s to be \"%s\" for path %s, but got \"%s\"", name, value, tt.path, fields[name])
+			}
+		}
+	}
+}
+
+func TestMatchNoMatch(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+	}{
+		{"{artist} - {album}/{track} {title}", "Discovery/01 One More Time"},
+		{"{track} {title}", "One More Time"},
+		{"{album}/{album} {track}", "Discovery/Homework 2"},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := pathtemplate.Parse(tt.template)
+		if err != nil {
+			t.Fatalf("unexpected error for template %s: %v", tt.template, err)
+		}
+		_, err = tmpl.Match(tt.path)
+		if !errors.Is(err, pathtemplate.ErrNoMatch) {
+			t.Errorf("expected ErrNoMatch for path %s, but got %v", tt.path, err)
+		}
+	}
+}
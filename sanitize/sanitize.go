@@ -0,0 +1,259 @@
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrUnknownProfile       = errors.New("unknown sanitization profile")
+	ErrUnknownNormalization = errors.New("unknown unicode normalization")
+	ErrInvalidReplacement   = errors.New("invalid replacement")
+)
+
+type Normalization string
+
+const (
+	NORMALIZATION_NONE Normalization = ""
+	NORMALIZATION_NFC  Normalization = "NFC" // composed, used by Windows and most Linux software
+	NORMALIZATION_NFD  Normalization = "NFD" // decomposed, used by macOS HFS+
+)
+
+var NORMALIZATIONS = []Normalization{NORMALIZATION_NFC, NORMALIZATION_NFD}
+
+// ParseNormalization returns the normalization called name, "none" for
+// NORMALIZATION_NONE.
+func ParseNormalization(name string) (Normalization, error) {
+	if strings.EqualFold(name, "none") {
+		return NORMALIZATION_NONE, nil
+	}
+	for _, n := range NORMALIZATIONS {
+		if strings.EqualFold(string(n), name) {
+			return n, nil
+		}
+	}
+	return NORMALIZATION_NONE, fmt.Errorf("%w: \"%s\"", ErrUnknownNormalization, name)
+}
+
+// Profile describes the file name restrictions of a target filesystem.
+type Profile struct {
+	Name string
+	// Characters that are replaced in every path component
+	Forbidden string
+	// Replace ASCII control characters (bytes 1-31 and 127), NUL is always replaced
+	ForbidControl bool
+	// Replace characters outside the Basic Multilingual Plane, like emoji,
+	// which need two UTF-16 code units
+	ForbidSupplementary bool
+	// Characters that are replaced only at the start of a path component
+	ForbiddenLeading string
+	// Base names (before the first ".") that can not be used, compared case insensitively
+	ReservedNames []string
+	// Remove trailing dots and spaces from path components
+	TrimTrailingDotsSpaces bool
+	// Maximum length of a path component in bytes (UTF-8), 0 for no limit
+	MaxComponentBytes int
+	Normalization     Normalization
+	// Replacement for forbidden characters, may be empty to remove them
+	Replacement string
+	// Replacements for specific characters, overriding Replacement
+	Replacements map[rune]string
+}
+
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+var (
+	POSIX = Profile{
+		Name:              "posix",
+		Forbidden:         "/",
+		MaxComponentBytes: 255,
+		Normalization:     NORMALIZATION_NFC,
+		Replacement:       "_",
+	}
+	// NTFS, https://learn.microsoft.com/en-us/windows/win32/fileio/naming-a-file
+	WINDOWS = windowsProfile("windows")
+	// SMB shares, which are usually backed by Windows or mapped by Samba to
+	// Windows restrictions.
+	SMB = windowsProfile("smb")
+	// FAT32 and exFAT, as used on SD cards, USB sticks and by car stereos. They
+	// share the restrictions of Windows, but long file names are stored as
+	// UCS-2 by many embedded implementations, which can not represent
+	// characters outside the Basic Multilingual Plane.
+	FAT32 = fat32Profile()
+	// Paths which are accepted by data.ValidatePath on any filesystem.
+	STRICT = Profile{
+		Name:                   "strict",
+		Forbidden:              "<>:\"/\\|?*[](){}&'!;",
+		ForbidControl:          true,
+		ForbiddenLeading:       "-~",
+		ReservedNames:          windowsReservedNames,
+		TrimTrailingDotsSpaces: true,
+		MaxComponentBytes:      255,
+		Normalization:          NORMALIZATION_NFC,
+		Replacement:            "_",
+	}
+)
+
+func windowsProfile(name string) Profile {
+	return Profile{
+		Name:                   name,
+		Forbidden:              "<>:\"/\\|?*",
+		ForbidControl:          true,
+		ReservedNames:          windowsReservedNames,
+		TrimTrailingDotsSpaces: true,
+		MaxComponentBytes:      255,
+		Normalization:          NORMALIZATION_NFC,
+		Replacement:            "_",
+	}
+}
+
+func fat32Profile() Profile {
+	p := windowsProfile("fat32")
+	p.ForbidSupplementary = true
+	return p
+}
+
+var PROFILES = []Profile{POSIX, WINDOWS, SMB, FAT32, STRICT}
+
+func ProfileByName(name string) (Profile, error) {
+	for _, p := range PROFILES {
+		if p.Name == strings.ToLower(name) {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("%w: \"%s\"", ErrUnknownProfile, name)
+}
+
+// WithReplacement returns a copy of p using replacement for forbidden
+// characters. The replacement itself must be allowed by p.
+func (p Profile) WithReplacement(replacement string) (Profile, error) {
+	if sanitized := p.Component(replacement); replacement != "" && sanitized != replacement {
+		return p, fmt.Errorf("%w: replacement \"%s\" is not allowed by profile %s", ErrInvalidReplacement, replacement, p.Name)
+	}
+	p.Replacement = replacement
+	return p, nil
+}
+
+// WithNormalization returns a copy of p normalizing names to normalization.
+func (p Profile) WithNormalization(normalization Normalization) Profile {
+	p.Normalization = normalization
+	return p
+}
+
+// Path sanitizes every component of the relative, "/" separated path.
+func (p Profile) Path(relative string) string {
+	components := strings.Split(relative, "/")
+	for i, c := range components {
+		components[i] = p.Component(c)
+	}
+	return path.Join(components...)
+}
+
+// Component returns a version of name that can be used as a file or
+// directory name on the profiles filesystem. The extension of name is kept
+// when it has to be shortened.
+func (p Profile) Component(name string) string {
+	switch p.Normalization {
+	case NORMALIZATION_NFC:
+		name = norm.NFC.String(name)
+	case NORMALIZATION_NFD:
+		name = norm.NFD.String(name)
+	}
+
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == utf8.RuneError && !strings.HasPrefix(name[i:], string(utf8.RuneError)):
+			b.WriteString(p.replacement(r)) // invalid UTF-8
+		case r == 0 || (p.ForbidControl && (r <= 31 || r == 127)):
+			b.WriteString(p.replacement(r))
+		case p.ForbidSupplementary && r > 0xFFFF:
+			b.WriteString(p.replacement(r))
+		case strings.ContainsRune(p.Forbidden, r):
+			b.WriteString(p.replacement(r))
+		case i == 0 && strings.ContainsRune(p.ForbiddenLeading, r):
+			b.WriteString(p.replacement(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name = p.trim(b.String())
+
+	if p.isReserved(name) {
+		stem, ext := splitReserved(name)
+		name = stem + p.fallback() + ext
+	}
+	if p.MaxComponentBytes > 0 && len(name) > p.MaxComponentBytes {
+		name = p.trim(truncate(name, p.MaxComponentBytes))
+	}
+	if name == "" || name == "." || name == ".." {
+		name = p.fallback()
+	}
+	return name
+}
+
+func (p Profile) replacement(r rune) string {
+	if replacement, ok := p.Replacements[r]; ok {
+		return replacement
+	}
+	return p.Replacement
+}
+
+// fallback is used for names that would be empty or reserved otherwise.
+func (p Profile) fallback() string {
+	if p.Replacement != "" {
+		return p.Replacement
+	}
+	return "_"
+}
+
+func (p Profile) trim(name string) string {
+	if p.TrimTrailingDotsSpaces {
+		name = strings.TrimRight(name, ". ")
+	}
+	return name
+}
+
+func (p Profile) isReserved(name string) bool {
+	stem, _ := splitReserved(name)
+	stem = strings.TrimRight(stem, " ")
+	for _, reserved := range p.ReservedNames {
+		if strings.EqualFold(stem, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitReserved splits name at the first "." as Windows does when checking for
+// reserved names ("CON.tar.gz" is reserved as well).
+func splitReserved(name string) (string, string) {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// truncate shortens name to at most maxBytes without splitting UTF-8 sequences.
+// Short extensions are kept.
+func truncate(name string, maxBytes int) string {
+	ext := path.Ext(name)
+	if len(ext) > 16 || len(ext) >= maxBytes || ext == name {
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	limit := maxBytes - len(ext)
+	for limit > 0 && !utf8.RuneStart(stem[limit]) {
+		limit--
+	}
+	return stem[:limit] + ext
+}
@@ -0,0 +1,130 @@
+package sanitize_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/sanitize"
+)
+
+func TestComponentWindows(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"AC/DC: Live?", "AC_DC_ Live_"},
+		{"CON", "CON_"},
+		{"nul.flac", "nul_.flac"},
+		{"Console", "Console"},
+		{"Hidden Track... ", "Hidden Track"},
+		{"tab\there", "tab_here"},
+		{"...", "_"},
+	}
+
+	for _, tt := range tests {
+		result := sanitize.WINDOWS.Component(tt.input)
+		if result != tt.expected {
+			t.Errorf("expected %q, got %q for input %q", tt.expected, result, tt.input)
+		}
+	}
+}
+
+func TestComponentPOSIXKeepsWindowsCharacters(t *testing.T) {
+	input := "What? <Live> CON."
+	if result := sanitize.POSIX.Component(input); result != input {
+		t.Errorf("expected %q, got %q", input, result)
+	}
+}
+
+func TestComponentNormalization(t *testing.T) {
+	decomposed := "Beyonce\u0301"
+	composed := "Beyonc\u00e9"
+
+	if result := sanitize.FAT32.Component(decomposed); result != composed {
+		t.Errorf("expected NFC %q, got %q", composed, result)
+	}
+	normalization, err := sanitize.ParseNormalization("nfd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nfd := sanitize.POSIX.WithNormalization(normalization)
+	if result := nfd.Component(composed); result != decomposed {
+		t.Errorf("expected NFD %q, got %q", decomposed, result)
+	}
+}
+
+func TestComponentFAT32(t *testing.T) {
+	input := "Party \U0001F389 Mix: Caf\u00e9"
+	if result := sanitize.FAT32.Component(input); result != "Party _ Mix_ Caf\u00e9" {
+		t.Errorf("expected the emoji to be replaced, but got %q", result)
+	}
+	if result := sanitize.WINDOWS.Component(input); result != "Party \U0001F389 Mix_ Caf\u00e9" {
+		t.Errorf("expected the emoji to be kept on NTFS, but got %q", result)
+	}
+}
+
+func TestParseNormalization(t *testing.T) {
+	if n, err := sanitize.ParseNormalization("none"); err != nil || n != sanitize.NORMALIZATION_NONE {
+		t.Errorf("expected no normalization, but got %q and %v", n, err)
+	}
+	if _, err := sanitize.ParseNormalization("nfkc"); !errors.Is(err, sanitize.ErrUnknownNormalization) {
+		t.Errorf("expected ErrUnknownNormalization, but got %v", err)
+	}
+}
+
+func TestComponentMaxBytes(t *testing.T) {
+	long := strings.Repeat("ä", 200) + ".flac" // 405 bytes
+
+	result := sanitize.POSIX.Component(long)
+	if len(result) > 255 {
+		t.Errorf("expected at most 255 bytes, got %d", len(result))
+	}
+	if !strings.HasSuffix(result, ".flac") {
+		t.Errorf("expected extension to be kept, got %q", result)
+	}
+	if !strings.HasPrefix(result, strings.Repeat("ä", 125)) {
+		t.Errorf("expected truncation at a character boundary, got %q", result)
+	}
+}
+
+func TestReplacements(t *testing.T) {
+	profile, err := sanitize.WINDOWS.WithReplacement("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	profile.Replacements = map[rune]string{':': " -"}
+
+	if result := profile.Component("Title: Sub?"); result != "Title - Sub" {
+		t.Errorf("expected %q, got %q", "Title - Sub", result)
+	}
+
+	_, err = sanitize.WINDOWS.WithReplacement("?")
+	if !errors.Is(err, sanitize.ErrInvalidReplacement) {
+		t.Errorf("expected ErrInvalidReplacement, but got %v", err)
+	}
+}
+
+func TestStrictPassesValidatePath(t *testing.T) {
+	inputs := []string{"-rf ~/(Remix) [Live]/Don't Stop!; & Go", "~home/C:\\x/\"quoted\""}
+
+	for _, input := range inputs {
+		result := sanitize.STRICT.Path(input)
+		if err := data.ValidatePath(result); err != nil {
+			t.Errorf("expected %q to be valid, but got %v", result, err)
+		}
+	}
+}
+
+func TestProfileByName(t *testing.T) {
+	if _, err := sanitize.ProfileByName("FAT32"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if p, err := sanitize.ProfileByName("smb"); err != nil || p.Name != "smb" || p.Forbidden != sanitize.WINDOWS.Forbidden {
+		t.Errorf("expected the SMB profile with the Windows rules, but got %+v and %v", p, err)
+	}
+	if _, err := sanitize.ProfileByName("hfs"); !errors.Is(err, sanitize.ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, but got %v", err)
+	}
+}
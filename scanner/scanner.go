@@ -1,6 +1,9 @@
 package scanner
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -8,13 +11,21 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/liamg/magic"
 
 	"github.com/makl11/musiman/audio"
+	"github.com/makl11/musiman/data"
+	"github.com/makl11/musiman/data/schema"
 )
 
-// TODO: skip path if it matches any of the ignorePaths
-func ScanDirForMusic(scanRoot string, minSize uint64, ignorePaths []string) error {
+// ScanDirForMusic recursively searches scanRoot for music files and saves
+// them with their content hash in the files table.
+func ScanDirForMusic(db sqlx.Ext, scanRoot string, minSize uint64, ignorePaths []string) error {
+	scanRoot, err := filepath.Abs(scanRoot)
+	if err != nil {
+		return err
+	}
 	fileSystem := os.DirFS(scanRoot)
 	var buf []byte = make([]byte, 1024) // size recommended here: https://pkg.go.dev/github.com/liamg/magic#Lookup
 	return fs.WalkDir(fileSystem, ".", func(path string, entry fs.DirEntry, err error) error {
@@ -52,19 +63,53 @@ func ScanDirForMusic(scanRoot string, minSize uint64, ignorePaths []string) erro
 				return nil
 			}
 			fileType, err := magic.LookupSync(buf[:bytesRead])
-			if err != nil {
-				if err == magic.ErrUnknown {
-					return nil
-				}
+			if err != nil && err != magic.ErrUnknown {
 				return err
 			}
+			if mp4 := detectMP4Audio(buf[:bytesRead]); mp4 != nil {
+				fileType, err = mp4, nil
+			}
+			if err == magic.ErrUnknown {
+				return nil
+			}
 
 			if fileType == nil {
 				panic("filetype is nil")
 			}
 
 			if audio.MUSIC_FILE_TYPES[fileType.Extension] {
-				fmt.Print(filepath.Join(scanRoot, path), "\t\t")
+				fullPath := filepath.Join(scanRoot, path)
+				hash, err := data.HashFile(fullPath)
+				if err != nil {
+					return err
+				}
+				// A different content with the same size and modification
+				// time is most likely disk corruption, keep the known-good
+				// hash and health for fsck and restoring the file
+				known, err := data.GetFile(db, fullPath)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("%s: %w", fullPath, err)
+				}
+				if err == nil && known.Size == uint(fileInfo.Size()) && known.Mod.Equal(fileInfo.ModTime()) && !bytes.Equal(known.Hash, hash) {
+					fmt.Fprintf(os.Stderr, "Keeping known hash of %s: content changed without size or modification time changing, run fsck\n", fullPath)
+					return nil
+				}
+				err = data.UpsertFile(db, schema.File{
+					Path:      fullPath,
+					Hash:      hash,
+					MediaType: fileType.Extension,
+					Size:      uint(fileInfo.Size()),
+					Mod:       fileInfo.ModTime(),
+				})
+				if errors.Is(err, data.ErrInvalidPath) {
+					fmt.Fprintln(os.Stderr, "Skipping file:", err)
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("%s: %w", fullPath, err)
+				}
+
+				fmt.Print(fullPath, "\t\t")
 				if fileType.MIME != "" {
 					fmt.Printf("%s\t", fileType.MIME)
 				}
@@ -76,3 +121,15 @@ func ScanDirForMusic(scanRoot string, minSize uint64, ignorePaths []string) erro
 		return nil
 	})
 }
+
+// MPEG-4 brands of audio files
+var mp4AudioBrands = map[string]bool{"M4A ": true, "M4B ": true, "M4P ": true}
+
+// detectMP4Audio returns the file type of MPEG-4 audio files, which magic
+// does not tell apart from videos, or nil for other files.
+func detectMP4Audio(buf []byte) *magic.FileType {
+	if len(buf) < 12 || string(buf[4:8]) != "ftyp" || !mp4AudioBrands[string(buf[8:12])] {
+		return nil
+	}
+	return &magic.FileType{Extension: "m4a", Description: "MPEG-4 audio", MIME: "audio/mp4"}
+}
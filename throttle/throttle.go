@@ -0,0 +1,40 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle spaces out calls to respect the rate limit of a web service.
+type Throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time // earliest time of the next call
+}
+
+// New returns a Throttle allowing perSecond calls per second.
+func New(perSecond float64) *Throttle {
+	return &Throttle{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the next call is allowed or ctx is done.
+func (t *Throttle) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	at := t.next
+	if at.Before(now) {
+		at = now
+	}
+	t.next = at.Add(t.interval)
+	t.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
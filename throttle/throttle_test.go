@@ -0,0 +1,36 @@
+package throttle_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makl11/musiman/throttle"
+)
+
+func TestWait(t *testing.T) {
+	th := throttle.New(50) // every 20ms
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := th.Wait(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// The first call is not delayed
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("expected 4 calls to take at least 60ms, but took %v", elapsed)
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	th := throttle.New(0.1) // every 10s
+	if err := th.Wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := th.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, but got %v", err)
+	}
+}